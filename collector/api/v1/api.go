@@ -0,0 +1,155 @@
+// Package apiv1 is the stable schema of the collector's and the aggregator's
+// /v1 HTTP API.
+//
+// The v1 types are only ever added to, with fields that older clients can
+// ignore. Renaming, retyping or removing a field takes a package api/v2 with
+// its own types, served under /v2 next to /v1, which keeps being served from
+// the same data until its clients moved on. The collector converts its
+// internal types to these, so refactoring them never changes the API.
+package apiv1
+
+import "time"
+
+// Version is the path prefix of the API, e.g. /v1/samples.
+const Version = "v1"
+
+// The paths of the API.
+const (
+	SamplesPath = "/" + Version + "/samples"
+	RollupsPath = "/" + Version + "/rollups"
+	MarksPath   = "/" + Version + "/marks"
+)
+
+// Sample is the usage of a node over an interval. The usages are percents of
+// the node's CPUs.
+type Sample struct {
+	// Cluster is only set by a federating aggregator
+	Cluster string    `json:"cluster,omitempty"`
+	Node    string    `json:"node"`
+	Pool    string    `json:"pool,omitempty"`
+	Time    time.Time `json:"time"`
+	// Interval is the elapsed time the usage was measured over, in
+	// nanoseconds
+	Interval         int64   `json:"interval,omitempty"`
+	CPUs             int     `json:"cpus"`
+	Cores            int     `json:"cores"`
+	AvgCPUUsage      float64 `json:"avg_cpu_usage"`
+	AdjustedCPUUsage float64 `json:"adjusted_cpu_usage"`
+	Load1            float64 `json:"load1,omitempty"`
+	Load5            float64 `json:"load5,omitempty"`
+	Load15           float64 `json:"load15,omitempty"`
+	// FreeCores is the number of cores idle on all their threads, missing
+	// from the samples of older collectors
+	FreeCores *int `json:"free_cores,omitempty"`
+	// Label is the mark the sample falls in
+	Label   string       `json:"label,omitempty"`
+	Sockets []GroupUsage `json:"sockets,omitempty"`
+	Nodes   []GroupUsage `json:"nodes,omitempty"`
+	// IRQCPUs are the CPUs busy with interrupts
+	IRQCPUs []int32 `json:"irq_cpus,omitempty"`
+	// Steal is the percent of CPU time taken by the hypervisor, only in VMs
+	Steal float64 `json:"steal,omitempty"`
+	// Derating is the capacity derating factor of the clock speed, 1 at the
+	// nominal frequency
+	Derating float64 `json:"derating,omitempty"`
+	BusyMHz  float64 `json:"busy_mhz,omitempty"`
+	// LLCOccupancy is only collected where resctrl is mounted
+	LLCOccupancy []LLCOccupancy `json:"llc_occupancy,omitempty"`
+	// SMTInterference is the IPC lost to busy siblings, only measured by the
+	// ipc sibling model
+	SMTInterference *float64 `json:"smt_interference,omitempty"`
+	// Window summarizes RCPU over the collector's -window samples
+	Window *Window `json:"rcpu_window,omitempty"`
+	// Pods are only attributed with -pod-resources-socket
+	Pods []Pod `json:"pods,omitempty"`
+	// Containers are only attributed with -cri-endpoint
+	Containers []Container `json:"containers,omitempty"`
+}
+
+// GroupUsage is the adjusted usage of a socket or a NUMA node.
+type GroupUsage struct {
+	ID               int32   `json:"id"`
+	AdjustedCPUUsage float64 `json:"adjusted_cpu_usage"`
+	// Cores is the number of physical cores of the group
+	Cores int `json:"cores,omitempty"`
+}
+
+// LLCOccupancy is the last level cache a resctrl group occupies in a cache
+// domain.
+type LLCOccupancy struct {
+	// Group is the group's directory in resctrl, / for the root group
+	Group  string `json:"group"`
+	Domain string `json:"domain"`
+	Bytes  uint64 `json:"bytes"`
+}
+
+// Window is the mean of RCPU over the window, its standard deviation and
+// confidence bounds.
+type Window struct {
+	Samples int     `json:"samples"`
+	Mean    float64 `json:"mean"`
+	StdDev  float64 `json:"stddev"`
+	Low     float64 `json:"low"`
+	High    float64 `json:"high"`
+}
+
+// Pod is the usage of a pod with pinned CPUs, in cores.
+type Pod struct {
+	Namespace     string  `json:"namespace"`
+	Name          string  `json:"name"`
+	BusyCores     float64 `json:"busy_cores"`
+	AdjustedCores float64 `json:"adjusted_cores"`
+}
+
+// Container is the usage of a container, in logical CPUs for BusyCores and in
+// physical cores for AdjustedCores.
+type Container struct {
+	ID            string  `json:"id"`
+	Namespace     string  `json:"namespace"`
+	Pod           string  `json:"pod"`
+	Container     string  `json:"container"`
+	BusyCores     float64 `json:"busy_cores"`
+	AdjustedCores float64 `json:"adjusted_cores"`
+}
+
+// SampleList is the body of GET /v1/samples, the latest sample of the
+// collector or of every node of the aggregator.
+type SampleList struct {
+	Samples []Sample `json:"samples"`
+}
+
+// Rollup summarizes RCPU over the nodes of a pool.
+type Rollup struct {
+	Cluster        string  `json:"cluster"`
+	Pool           string  `json:"pool"`
+	Nodes          int     `json:"nodes"`
+	UnknownNodes   int     `json:"unknown_nodes"`
+	Cores          int     `json:"cores"`
+	RemainingCores float64 `json:"remaining_cores"`
+	RCPUMin        float64 `json:"rcpu_min"`
+	RCPUP10        float64 `json:"rcpu_p10"`
+	RCPUP50        float64 `json:"rcpu_p50"`
+	RCPUP90        float64 `json:"rcpu_p90"`
+	RCPUMax        float64 `json:"rcpu_max"`
+}
+
+// RollupList is the body of GET /v1/rollups.
+type RollupList struct {
+	Rollups []Rollup `json:"rollups"`
+}
+
+// Mark labels the samples taken from Start until End, or until the next mark
+// when End is zero. GET /v1/marks returns an array of them.
+type Mark struct {
+	Label string    `json:"label"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end,omitempty"`
+}
+
+// MarkRequest is the body of POST /v1/marks, which returns the Mark started.
+// An empty label ends the open mark without starting a new one.
+type MarkRequest struct {
+	Label string `json:"label"`
+	// Duration is a Go duration, e.g. "10m", empty keeps the mark open
+	Duration string `json:"duration,omitempty"`
+}
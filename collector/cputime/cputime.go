@@ -0,0 +1,233 @@
+// Package cputime is the arithmetic of RCPU: the per-CPU times read from
+// /proc/stat or its equivalents, the periods between two reads, and the
+// average and SMT-adjusted usages computed over them. The collector computes
+// every sample with it, so tools reusing it get the same figures.
+//
+// Times are cumulative counters in ticks, USER_HZ on Linux. A period is the
+// difference of two reads of the same CPU, and the usages take the periods
+// of every CPU indexed by CPU ID.
+package cputime
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrCounterReset is returned for a CPU whose counters went backwards,
+	// re-onlined or reset by the kernel, there is no period to compute
+	ErrCounterReset = errors.New("CPU time counters went backwards")
+	// ErrZeroPeriod is returned when no time was counted at all, e.g. two
+	// reads within the same tick
+	ErrZeroPeriod = errors.New("total period is zero")
+)
+
+// CPUTime is the cumulative time a CPU spent in each state. User and Nice
+// exclude the time spent running guests, unlike the user and nice of
+// /proc/stat, see FromProcStat.
+type CPUTime struct {
+	CPUId       int32
+	CollectTime time.Time
+	User        uint64
+	Nice        uint64
+	Sys         uint64
+	Idle        uint64
+	IOWait      uint64
+	IRQ         uint64
+	SoftIRQ     uint64
+	Steal       uint64
+	Guest       uint64
+	GuestNice   uint64
+}
+
+// FromProcStat returns the times of a cpuN line of /proc/stat, its counters
+// in order, user through guest_nice. The kernel counts the time spent running
+// guests in user and nice too, it is taken out so it counts once.
+func FromProcStat(cpuId int32, collectTime time.Time, counters [10]uint64) CPUTime {
+	user, nice, sys, idle, iowait := counters[0], counters[1], counters[2], counters[3], counters[4]
+	irq, softIRQ, steal, guest, guestNice := counters[5], counters[6], counters[7], counters[8], counters[9]
+
+	return CPUTime{
+		CPUId:       cpuId,
+		CollectTime: collectTime,
+		// Some kernels briefly report more guest than user time
+		User:      SaturatedSub(user, guest),
+		Nice:      SaturatedSub(nice, guestNice),
+		Sys:       sys,
+		Idle:      idle,
+		IOWait:    iowait,
+		IRQ:       irq,
+		SoftIRQ:   softIRQ,
+		Steal:     steal,
+		Guest:     guest,
+		GuestNice: guestNice,
+	}
+}
+
+func (t *CPUTime) TotalIdleTime() uint64 {
+	return t.Idle + t.IOWait
+}
+
+func (t *CPUTime) TotalSystemTime() uint64 {
+	return t.Sys + t.IRQ + t.SoftIRQ
+}
+
+func (t *CPUTime) TotalVirtualTime() uint64 {
+	return t.Guest + t.GuestNice
+}
+
+func (t *CPUTime) TotalTime() uint64 {
+	return t.User + t.Nice + t.TotalSystemTime() + t.TotalIdleTime() + t.Steal + t.TotalVirtualTime()
+}
+
+// CPUTimePeriod is the time a CPU spent in each state between two reads.
+type CPUTimePeriod struct {
+	CPUId int32
+	// Elapsed is measured on the monotonic clock, so rates stay correct when
+	// a tick fires late or the wall clock jumps
+	Elapsed           time.Duration
+	UserPeriod        uint64
+	NicePeriod        uint64
+	SysPeriod         uint64
+	TotalSystemPeriod uint64
+	IdlePeriod        uint64
+	TotalIdlePeriod   uint64
+	IOWaitPeriod      uint64
+	IRQPeriod         uint64
+	SoftIRQPeriod     uint64
+	StealPeriod       uint64
+	GuestPeriod       uint64
+	TotalPeriod       uint64
+}
+
+// SaturatedSub returns a - b, or 0 rather than wrapping around when b is
+// larger.
+func SaturatedSub(a, b uint64) uint64 {
+	if a > b {
+		return a - b
+	}
+
+	return 0
+}
+
+func NewCPUTimePeriod(t1, t2 *CPUTime) (*CPUTimePeriod, error) {
+	period := &CPUTimePeriod{}
+	if err := period.Set(t1, t2); err != nil {
+		return nil, err
+	}
+
+	return period, nil
+}
+
+// Set computes the period in place, to avoid an allocation per CPU per tick.
+// A counter going backwards on its own, e.g. the idle time of a CPU coming
+// out of NO_HZ, counts as zero, while the total going backwards is
+// ErrCounterReset.
+func (p *CPUTimePeriod) Set(t1, t2 *CPUTime) error {
+	if t1.CPUId != t2.CPUId {
+		return fmt.Errorf("CPU IDs don't match: %d != %d", t1.CPUId, t2.CPUId)
+	}
+
+	if t2.CollectTime.Before(t1.CollectTime) {
+		return fmt.Errorf("collect time is not in order: %v > %v", t1.CollectTime, t2.CollectTime)
+	}
+
+	// Counters only go backwards when a CPU was re-onlined or the kernel reset them
+	if t2.TotalTime() < t1.TotalTime() {
+		return fmt.Errorf("%w: CPU %d total time %d < %d", ErrCounterReset, t1.CPUId, t2.TotalTime(), t1.TotalTime())
+	}
+
+	*p = CPUTimePeriod{
+		CPUId:             t1.CPUId,
+		Elapsed:           t2.CollectTime.Sub(t1.CollectTime),
+		UserPeriod:        SaturatedSub(t2.User, t1.User),
+		NicePeriod:        SaturatedSub(t2.Nice, t1.Nice),
+		SysPeriod:         SaturatedSub(t2.Sys, t1.Sys),
+		TotalSystemPeriod: SaturatedSub(t2.TotalSystemTime(), t1.TotalSystemTime()),
+		IdlePeriod:        SaturatedSub(t2.Idle, t1.Idle),
+		TotalIdlePeriod:   SaturatedSub(t2.TotalIdleTime(), t1.TotalIdleTime()),
+		IOWaitPeriod:      SaturatedSub(t2.IOWait, t1.IOWait),
+		IRQPeriod:         SaturatedSub(t2.IRQ, t1.IRQ),
+		SoftIRQPeriod:     SaturatedSub(t2.SoftIRQ, t1.SoftIRQ),
+		StealPeriod:       SaturatedSub(t2.Steal, t1.Steal),
+		GuestPeriod:       SaturatedSub(t2.Guest, t1.Guest),
+		TotalPeriod:       SaturatedSub(t2.TotalTime(), t1.TotalTime()),
+	}
+
+	return nil
+}
+
+// AverageCPUUsage is the busy share of the time of every CPU, in percent,
+// the state of the art following top, htop, bottom, btop, etc.
+func AverageCPUUsage(cpuTimePeriods []CPUTimePeriod) (float64, error) {
+	var totalPeriod uint64
+	var totalIdlePeriod uint64
+	for i := range cpuTimePeriods {
+		totalPeriod += cpuTimePeriods[i].TotalPeriod
+		totalIdlePeriod += cpuTimePeriods[i].TotalIdlePeriod
+	}
+
+	if totalPeriod == 0 {
+		return 0.0, ErrZeroPeriod
+	}
+
+	cpuUtilization := 100.0 * (1 - float64(totalIdlePeriod)/float64(totalPeriod))
+
+	return cpuUtilization, nil
+}
+
+// CoresAverageCPUUsage is AverageCPUUsage over the CPUs of the cores only.
+func CoresAverageCPUUsage(cores [][]int32, cpuTimePeriods []CPUTimePeriod) (float64, error) {
+	var totalPeriod uint64
+	var totalIdlePeriod uint64
+	for _, cpuIds := range cores {
+		for _, cpuId := range cpuIds {
+			totalPeriod += cpuTimePeriods[cpuId].TotalPeriod
+			totalIdlePeriod += cpuTimePeriods[cpuId].TotalIdlePeriod
+		}
+	}
+
+	if totalPeriod == 0 {
+		return 0.0, ErrZeroPeriod
+	}
+
+	return 100.0 * (1 - float64(totalIdlePeriod)/float64(totalPeriod)), nil
+}
+
+// AdjustedCPUUsage is the busy share of the physical cores, in percent, a
+// core of two SMT siblings being busy while either is. It takes the CPU IDs
+// of every core and the periods indexed by CPU ID. The CPUs of an anomalous
+// core, without exactly two CPUs, count as cores of their own, as in the
+// average.
+func AdjustedCPUUsage(cores [][]int32, cpuTimePeriods []CPUTimePeriod) (float64, error) {
+	var totalPeriod uint64
+	var totalIdlePeriod uint64
+
+	for _, cpuIds := range cores {
+		if len(cpuIds) != 2 {
+			for _, cpuId := range cpuIds {
+				totalPeriod += cpuTimePeriods[cpuId].TotalPeriod
+				totalIdlePeriod += cpuTimePeriods[cpuId].TotalIdlePeriod
+			}
+			continue
+		}
+
+		ht0 := &cpuTimePeriods[cpuIds[0]]
+		ht1 := &cpuTimePeriods[cpuIds[1]]
+
+		period := max(ht0.TotalPeriod, ht1.TotalPeriod)
+		idlePeriod := min(ht0.TotalIdlePeriod, ht1.TotalIdlePeriod)
+
+		totalPeriod += period
+		totalIdlePeriod += idlePeriod
+	}
+
+	if totalPeriod == 0 {
+		return 0.0, ErrZeroPeriod
+	}
+
+	cpuUtilization := 100.0 * (1 - float64(totalIdlePeriod)/float64(totalPeriod))
+
+	return cpuUtilization, nil
+}
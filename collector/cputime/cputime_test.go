@@ -0,0 +1,253 @@
+package cputime
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestSaturatedSub(t *testing.T) {
+	tests := []struct {
+		a, b, want uint64
+	}{
+		{10, 3, 7},
+		{3, 3, 0},
+		{3, 10, 0},
+		{0, math.MaxUint64, 0},
+		{math.MaxUint64, 0, math.MaxUint64},
+	}
+
+	for _, test := range tests {
+		if got := SaturatedSub(test.a, test.b); got != test.want {
+			t.Errorf("SaturatedSub(%d, %d): expected %d, got %d", test.a, test.b, test.want, got)
+		}
+	}
+}
+
+func TestFromProcStat(t *testing.T) {
+	tests := []struct {
+		name     string
+		counters [10]uint64
+		want     CPUTime
+		total    uint64
+	}{
+		{
+			name:     "without guests",
+			counters: [10]uint64{100, 10, 50, 800, 5, 2, 3, 1, 0, 0},
+			want:     CPUTime{User: 100, Nice: 10, Sys: 50, Idle: 800, IOWait: 5, IRQ: 2, SoftIRQ: 3, Steal: 1},
+			total:    971,
+		},
+		{
+			// The guest time is in user and nice too, it counts once
+			name:     "guests",
+			counters: [10]uint64{100, 10, 50, 800, 0, 0, 0, 0, 40, 4},
+			want:     CPUTime{User: 60, Nice: 6, Sys: 50, Idle: 800, Guest: 40, GuestNice: 4},
+			total:    960,
+		},
+		{
+			name:     "more guest than user time",
+			counters: [10]uint64{30, 0, 50, 800, 0, 0, 0, 0, 40, 4},
+			want:     CPUTime{User: 0, Nice: 0, Sys: 50, Idle: 800, Guest: 40, GuestNice: 4},
+			total:    894,
+		},
+		{
+			name:     "older kernels",
+			counters: [10]uint64{100, 10, 50, 800},
+			want:     CPUTime{User: 100, Nice: 10, Sys: 50, Idle: 800},
+			total:    960,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := FromProcStat(3, start, test.counters)
+
+			want := test.want
+			want.CPUId, want.CollectTime = 3, start
+			if got != want {
+				t.Errorf("expected %+v, got %+v", want, got)
+			}
+			if total := got.TotalTime(); total != test.total {
+				t.Errorf("expected a total time of %d, got %d", test.total, total)
+			}
+		})
+	}
+}
+
+func TestCPUTimePeriod(t *testing.T) {
+	prev := CPUTime{CPUId: 1, CollectTime: start, User: 100, Nice: 10, Sys: 50, Idle: 800, IOWait: 20, IRQ: 5, SoftIRQ: 5, Steal: 2, Guest: 40}
+
+	tests := []struct {
+		name    string
+		cur     CPUTime
+		want    CPUTimePeriod
+		wantErr error
+	}{
+		{
+			name: "busy",
+			cur:  CPUTime{CPUId: 1, CollectTime: start.Add(time.Second), User: 150, Nice: 10, Sys: 60, Idle: 830, IOWait: 25, IRQ: 6, SoftIRQ: 7, Steal: 3, Guest: 50},
+			want: CPUTimePeriod{
+				CPUId: 1, Elapsed: time.Second, UserPeriod: 50, SysPeriod: 10, TotalSystemPeriod: 13, IdlePeriod: 30, TotalIdlePeriod: 35,
+				IOWaitPeriod: 5, IRQPeriod: 1, SoftIRQPeriod: 2, StealPeriod: 1, GuestPeriod: 10, TotalPeriod: 109,
+			},
+		},
+		{
+			// The iowait of a CPU coming out of NO_HZ can go backwards
+			// while the total keeps going forwards
+			name: "counter going backwards",
+			cur:  CPUTime{CPUId: 1, CollectTime: start.Add(time.Second), User: 200, Nice: 10, Sys: 50, Idle: 810, IOWait: 10, IRQ: 5, SoftIRQ: 5, Steal: 2, Guest: 40},
+			want: CPUTimePeriod{
+				CPUId: 1, Elapsed: time.Second, UserPeriod: 100, IdlePeriod: 10, TotalIdlePeriod: 0, TotalPeriod: 100,
+			},
+		},
+		{
+			name:    "counters reset",
+			cur:     CPUTime{CPUId: 1, CollectTime: start.Add(time.Second), User: 1, Idle: 2},
+			wantErr: ErrCounterReset,
+		},
+		{
+			name: "same counters",
+			cur:  prev,
+			want: CPUTimePeriod{CPUId: 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := NewCPUTimePeriod(&prev, &test.cur)
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("expected %v, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if *got != test.want {
+				t.Errorf("expected %+v, got %+v", test.want, *got)
+			}
+		})
+	}
+
+	other := prev
+	other.CPUId = 2
+	if _, err := NewCPUTimePeriod(&prev, &other); err == nil {
+		t.Errorf("expected an error for different CPUs")
+	}
+
+	earlier := prev
+	earlier.CollectTime = start.Add(-time.Second)
+	if _, err := NewCPUTimePeriod(&prev, &earlier); err == nil {
+		t.Errorf("expected an error for reads out of order")
+	}
+}
+
+// busyPeriod is a period of 100 ticks, busy for the given ticks.
+func busyPeriod(cpuId int32, busy uint64) CPUTimePeriod {
+	return CPUTimePeriod{CPUId: cpuId, TotalPeriod: 100, TotalIdlePeriod: 100 - busy}
+}
+
+func TestUsages(t *testing.T) {
+	tests := []struct {
+		name     string
+		cores    [][]int32
+		periods  []CPUTimePeriod
+		average  float64
+		adjusted float64
+	}{
+		{
+			name:     "idle",
+			cores:    [][]int32{{0, 1}},
+			periods:  []CPUTimePeriod{busyPeriod(0, 0), busyPeriod(1, 0)},
+			average:  0,
+			adjusted: 0,
+		},
+		{
+			// The core is busy while either sibling is
+			name:     "one sibling busy",
+			cores:    [][]int32{{0, 1}},
+			periods:  []CPUTimePeriod{busyPeriod(0, 100), busyPeriod(1, 0)},
+			average:  50,
+			adjusted: 100,
+		},
+		{
+			// The siblings are assumed to overlap as much as they can
+			name:     "both siblings partly busy",
+			cores:    [][]int32{{0, 1}},
+			periods:  []CPUTimePeriod{busyPeriod(0, 60), busyPeriod(1, 30)},
+			average:  45,
+			adjusted: 60,
+		},
+		{
+			name:     "siblings apart",
+			cores:    [][]int32{{0, 2}, {1, 3}},
+			periods:  []CPUTimePeriod{busyPeriod(0, 100), busyPeriod(1, 0), busyPeriod(2, 0), busyPeriod(3, 0)},
+			average:  25,
+			adjusted: 50,
+		},
+		{
+			// A core without its sibling counts its CPU as a core
+			name:     "anomalous core",
+			cores:    [][]int32{{0, 1}, {2}},
+			periods:  []CPUTimePeriod{busyPeriod(0, 100), busyPeriod(1, 0), busyPeriod(2, 0)},
+			average:  100.0 / 3,
+			adjusted: 50,
+		},
+		{
+			// A late tick of one sibling doesn't make the core idle
+			name:     "uneven periods",
+			cores:    [][]int32{{0, 1}},
+			periods:  []CPUTimePeriod{{CPUId: 0, TotalPeriod: 200, TotalIdlePeriod: 100}, busyPeriod(1, 0)},
+			average:  100.0 / 3,
+			adjusted: 50,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			average, err := AverageCPUUsage(test.periods)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(average-test.average) > 1e-9 {
+				t.Errorf("expected an average of %.2f%%, got %.2f%%", test.average, average)
+			}
+
+			cores, err := CoresAverageCPUUsage(test.cores, test.periods)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(cores-average) > 1e-9 {
+				t.Errorf("expected the average over every core to be %.2f%%, got %.2f%%", average, cores)
+			}
+
+			adjusted, err := AdjustedCPUUsage(test.cores, test.periods)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(adjusted-test.adjusted) > 1e-9 {
+				t.Errorf("expected an adjusted usage of %.2f%%, got %.2f%%", test.adjusted, adjusted)
+			}
+		})
+	}
+}
+
+func TestUsagesZeroPeriod(t *testing.T) {
+	periods := make([]CPUTimePeriod, 2)
+	cores := [][]int32{{0, 1}}
+
+	if _, err := AverageCPUUsage(periods); !errors.Is(err, ErrZeroPeriod) {
+		t.Errorf("expected ErrZeroPeriod of the average, got %v", err)
+	}
+	if _, err := CoresAverageCPUUsage(cores, periods); !errors.Is(err, ErrZeroPeriod) {
+		t.Errorf("expected ErrZeroPeriod of the cores' average, got %v", err)
+	}
+	if _, err := AdjustedCPUUsage(cores, periods); !errors.Is(err, ErrZeroPeriod) {
+		t.Errorf("expected ErrZeroPeriod of the adjusted usage, got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package parse
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// CgroupCPUStatUsage returns usage_usec out of a cgroup v2 cpu.stat, e.g.
+// "usage_usec 1234\nuser_usec 1000\nsystem_usec 234\n".
+func CgroupCPUStatUsage(b []byte) (uint64, error) {
+	for len(b) > 0 {
+		line := b
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			line, b = b[:i], b[i+1:]
+		} else {
+			b = nil
+		}
+
+		rest, ok := bytes.CutPrefix(line, []byte("usage_usec"))
+		if !ok || len(rest) == 0 || !isSpace(rest[0]) {
+			continue
+		}
+
+		v, rest, ok := Uint(rest)
+		if !ok || !isBlank(rest) {
+			return 0, fmt.Errorf("%w: cpu.stat usage_usec %q", ErrMalformed, line)
+		}
+
+		return v, nil
+	}
+
+	return 0, fmt.Errorf("%w: no usage_usec in cpu.stat", ErrMalformed)
+}
+
+// CgroupCPUAcctUsage parses a cgroup v1 cpuacct.usage, in nanoseconds.
+func CgroupCPUAcctUsage(b []byte) (uint64, error) {
+	v, rest, ok := Uint(bytes.TrimSpace(b))
+	if !ok || !isBlank(rest) {
+		return 0, fmt.Errorf("%w: cpuacct.usage %q", ErrMalformed, b)
+	}
+
+	return v, nil
+}
+
+// ProcCgroups parses /proc/<pid>/cgroup into the cgroup path of every
+// controller, e.g. "4:cpu,cpuacct:/kubepods/pod1\n0::/kubepods/pod1\n". The
+// path of the cgroup v2 hierarchy is under the empty controller.
+func ProcCgroups(b []byte) (map[string]string, error) {
+	cgroups := make(map[string]string)
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		// The path may contain colons, the first two fields can't
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 || !strings.HasPrefix(fields[2], "/") {
+			return nil, fmt.Errorf("%w: cgroup line %q", ErrMalformed, line)
+		}
+
+		if fields[1] == "" {
+			cgroups[""] = fields[2]
+			continue
+		}
+
+		for _, controller := range strings.Split(fields[1], ",") {
+			cgroups[controller] = fields[2]
+		}
+	}
+
+	if len(cgroups) == 0 {
+		return nil, fmt.Errorf("%w: no cgroups", ErrMalformed)
+	}
+
+	return cgroups, nil
+}
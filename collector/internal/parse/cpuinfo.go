@@ -0,0 +1,71 @@
+package parse
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// cpuInfoValue returns the value of key of the first processor in
+// /proc/cpuinfo. Keys are matched case insensitively with any whitespace
+// before the colon, and the value may itself contain colons.
+func cpuInfoValue(r io.Reader, key string) (string, error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 4096), 1024*1024)
+	for s.Scan() {
+		k, value, ok := strings.Cut(s.Text(), ":")
+		if !ok || !strings.EqualFold(strings.TrimSpace(k), key) {
+			continue
+		}
+
+		if value := strings.TrimSpace(value); value != "" {
+			return value, nil
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+
+	return "", fmt.Errorf("%w: no %s", ErrMalformed, key)
+}
+
+// CPUInfoModel returns the model name of the first processor in
+// /proc/cpuinfo.
+func CPUInfoModel(r io.Reader) (string, error) {
+	return cpuInfoValue(r, "model name")
+}
+
+// CPUInfoFlags returns the feature flags of the first processor in
+// /proc/cpuinfo, which only x86 reports.
+func CPUInfoFlags(r io.Reader) ([]string, error) {
+	flags, err := cpuInfoValue(r, "flags")
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Fields(flags), nil
+}
+
+// ModelBaseFrequency returns the base frequency in kHz Intel puts at the end
+// of the model name, e.g. "Intel(R) Xeon(R) Platinum 8380 CPU @ 2.30GHz".
+func ModelBaseFrequency(model string) (uint64, bool) {
+	_, freq, ok := strings.Cut(model, "@")
+	if !ok {
+		return 0, false
+	}
+
+	ghz, ok := strings.CutSuffix(strings.TrimSpace(freq), "GHz")
+	if !ok {
+		return 0, false
+	}
+
+	v, err := strconv.ParseFloat(ghz, 64)
+	if err != nil || v <= 0 || v > 100 {
+		return 0, false
+	}
+
+	return uint64(v*1e6 + 0.5), true
+}
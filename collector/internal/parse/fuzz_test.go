@@ -0,0 +1,219 @@
+package parse
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fixture holds the files of a collector fixture, see the collector's
+// selftest, the fuzz targets are seeded with them.
+type fixture struct {
+	Proc map[string]string `json:"proc"`
+	Sys  map[string]string `json:"sys"`
+	Stat [2]string         `json:"stat"`
+}
+
+func loadFixtures(f *testing.F) []fixture {
+	names, err := filepath.Glob("../../rcpu/fixtures/*.json")
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	if len(names) == 0 {
+		f.Fatal("no fixtures found")
+	}
+
+	var fixtures []fixture
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			f.Fatal(err)
+		}
+
+		var fx fixture
+		if err := json.Unmarshal(data, &fx); err != nil {
+			f.Fatalf("failed to parse %s: %v", name, err)
+		}
+		fixtures = append(fixtures, fx)
+	}
+
+	return fixtures
+}
+
+func FuzzParseProcStat(f *testing.F) {
+	for _, fx := range loadFixtures(f) {
+		for _, stat := range fx.Stat {
+			f.Add([]byte(stat))
+		}
+	}
+	f.Add([]byte("cpu0 1 2 3\n"))
+	f.Add([]byte("cpu18446744073709551616 1 2 3 4\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var fields [StatCPUFields]uint64
+		for _, line := range bytes.Split(data, []byte("\n")) {
+			if !IsStatCPULine(line) {
+				continue
+			}
+
+			cpuId, err := StatCPULine(line, &fields)
+			if err == nil && cpuId < 0 {
+				t.Fatalf("negative CPU ID %d from %q", cpuId, line)
+			}
+		}
+	})
+}
+
+func FuzzParseCPUInfo(f *testing.F) {
+	for _, fx := range loadFixtures(f) {
+		f.Add(fx.Proc["cpuinfo"])
+	}
+	f.Add("model name\t:\n")
+
+	f.Fuzz(func(t *testing.T, cpuInfo string) {
+		model, err := CPUInfoModel(strings.NewReader(cpuInfo))
+		if err == nil {
+			ModelBaseFrequency(model)
+		}
+
+		CPUInfoFlags(strings.NewReader(cpuInfo))
+	})
+}
+
+func FuzzParseCPUList(f *testing.F) {
+	for _, fx := range loadFixtures(f) {
+		for name, data := range fx.Sys {
+			if strings.HasSuffix(name, "list") || strings.HasSuffix(name, "online") {
+				f.Add(data)
+			}
+		}
+	}
+	f.Add("0-4294967295")
+	f.Add("0-65535,0-65535")
+	f.Add("3-1")
+
+	f.Fuzz(func(t *testing.T, s string) {
+		cpus, err := CPUList(s)
+		if err != nil {
+			return
+		}
+
+		if len(cpus) > MaxCPUs {
+			t.Fatalf("%d CPUs from %q, more than %d", len(cpus), s, MaxCPUs)
+		}
+
+		for _, cpu := range cpus {
+			if cpu < 0 || cpu >= MaxCPUs {
+				t.Fatalf("CPU %d out of range from %q", cpu, s)
+			}
+		}
+	})
+}
+
+func FuzzParseSysInt(f *testing.F) {
+	for _, fx := range loadFixtures(f) {
+		for name, data := range fx.Sys {
+			if strings.HasSuffix(name, "_id") || strings.HasSuffix(name, "active") {
+				f.Add([]byte(data))
+			}
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		SysInt(b)
+		SysUint(b)
+	})
+}
+
+func FuzzParseLsCPU(f *testing.F) {
+	f.Add("CPU NODE SOCKET CORE\n0   0    0      0\n1   0    0      1\n2   -    -      -\n")
+	f.Add("CPU NODE SOCKET CORE\n0 - 0 0\n")
+
+	f.Fuzz(func(t *testing.T, out string) {
+		entries, err := LsCPU(out)
+		if err != nil {
+			return
+		}
+
+		for _, entry := range entries {
+			if entry.CPU < 0 || entry.Node < 0 || entry.Socket < 0 || entry.Core < 0 {
+				t.Fatalf("negative entry %+v from %q", entry, out)
+			}
+		}
+	})
+}
+
+func FuzzParseLoadAvg(f *testing.F) {
+	f.Add([]byte("0.52 0.58 0.59 2/1234 5678\n"))
+	f.Add([]byte("NaN Inf -1 0/0 0\n"))
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		LoadAvg(b)
+	})
+}
+
+func FuzzParseCgroup(f *testing.F) {
+	f.Add([]byte("usage_usec 1234\nuser_usec 1000\nsystem_usec 234\n"))
+	f.Add([]byte("12345678\n"))
+	f.Add([]byte("4:cpu,cpuacct:/kubepods/pod1\n0::/kubepods/pod1\n"))
+	f.Add([]byte("0::/a:b\n"))
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		CgroupCPUStatUsage(b)
+		CgroupCPUAcctUsage(b)
+
+		cgroups, err := ProcCgroups(b)
+		if err != nil {
+			return
+		}
+
+		for controller, path := range cgroups {
+			if !strings.HasPrefix(path, "/") {
+				t.Fatalf("relative path %q of controller %q from %q", path, controller, b)
+			}
+		}
+	})
+}
+
+func FuzzParseSchedTopology(f *testing.F) {
+	f.Add(dualSocketTopologySpec)
+	f.Add(singleSocketNoSMTTopologySpec)
+	f.Add(`<groups><group><cpu>0-4294967295</cpu></group></groups>`)
+
+	f.Fuzz(func(t *testing.T, spec string) {
+		entries, _, err := SchedTopology(spec)
+		if err != nil {
+			return
+		}
+
+		for _, entry := range entries {
+			if entry.CPU < 0 || entry.Core < 0 || entry.Socket < 0 {
+				t.Fatalf("negative entry %+v from %q", entry, spec)
+			}
+		}
+	})
+}
+
+func FuzzParseLogicalProcessors(f *testing.F) {
+	f.Add(logicalProcessorRecord(RelationProcessorCore, 0, 0, 0x3))
+	f.Add(append(logicalProcessorRecord(RelationNumaNode, 3, 1, 0xff), logicalProcessorRecord(RelationProcessorPackage, 0, 1, 0xff)...))
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		for _, wordSize := range []int{4, 8} {
+			entries, err := LogicalProcessors(b, wordSize)
+			if err != nil {
+				continue
+			}
+
+			for _, entry := range entries {
+				if entry.CPU < 0 || entry.Core < 0 || entry.Socket < 0 || entry.Node < 0 {
+					t.Fatalf("negative entry %+v", entry)
+				}
+			}
+		}
+	})
+}
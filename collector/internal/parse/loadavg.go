@@ -0,0 +1,29 @@
+package parse
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// LoadAvg parses the first three fields of /proc/loadavg, e.g.
+// "0.52 0.58 0.59 2/1234 5678".
+func LoadAvg(b []byte) ([3]float64, error) {
+	var load [3]float64
+
+	items := strings.Fields(string(b))
+	if len(items) < 3 {
+		return load, fmt.Errorf("%w: loadavg has %d fields", ErrMalformed, len(items))
+	}
+
+	for i := range load {
+		v, err := strconv.ParseFloat(items[i], 64)
+		if err != nil || v < 0 || math.IsInf(v, 0) || math.IsNaN(v) {
+			return load, fmt.Errorf("%w: loadavg field %q", ErrMalformed, items[i])
+		}
+		load[i] = v
+	}
+
+	return load, nil
+}
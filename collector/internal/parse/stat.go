@@ -0,0 +1,114 @@
+// Package parse holds the parsers for the procfs, sysfs and lscpu formats the
+// collector reads. They never panic on malformed input and report it as an
+// error instead, exotic kernels shouldn't be able to take down the agent.
+package parse
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// StatCPUFields is the number of counters of a cpuN line since Linux 2.6.33.
+const StatCPUFields = 10
+
+// StatMinCPUFields is the number of counters every kernel reports, user,
+// nice, system and idle. Older kernels miss the later ones.
+const StatMinCPUFields = 4
+
+var ErrMalformed = errors.New("malformed input")
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r'
+}
+
+// Uint parses the next whitespace separated unsigned integer in place,
+// returning the rest of the input. Values that overflow uint64 are rejected.
+func Uint(b []byte) (uint64, []byte, bool) {
+	i := 0
+	for i < len(b) && isSpace(b[i]) {
+		i++
+	}
+
+	start := i
+	var v uint64
+	for ; i < len(b) && !isSpace(b[i]); i++ {
+		c := b[i]
+		if c < '0' || c > '9' {
+			return 0, nil, false
+		}
+
+		d := uint64(c - '0')
+		if v > (math.MaxUint64-d)/10 {
+			return 0, nil, false
+		}
+		v = v*10 + d
+	}
+
+	if i == start {
+		return 0, nil, false
+	}
+
+	return v, b[i:], true
+}
+
+// IsStatCPULine reports whether line is a per-CPU line of /proc/stat, as
+// opposed to the "cpu " total or any other line.
+func IsStatCPULine(line []byte) bool {
+	return len(line) >= 4 && line[0] == 'c' && line[1] == 'p' && line[2] == 'u' && line[3] >= '0' && line[3] <= '9'
+}
+
+// StatCPULine parses a cpuN line of /proc/stat into fields without
+// allocating. Missing trailing counters of older kernels are zero, counters
+// added by future kernels are ignored.
+func StatCPULine(line []byte, fields *[StatCPUFields]uint64) (int32, error) {
+	if !IsStatCPULine(line) {
+		return 0, fmt.Errorf("%w: not a cpuN line", ErrMalformed)
+	}
+
+	cpuId, rest, ok := Uint(line[3:])
+	if !ok || cpuId > math.MaxInt32 {
+		return 0, fmt.Errorf("%w: invalid CPU ID", ErrMalformed)
+	}
+
+	// The ID and the first counter must be separated
+	if len(rest) == 0 || !isSpace(rest[0]) {
+		return 0, fmt.Errorf("%w: truncated cpu%d line", ErrMalformed, cpuId)
+	}
+
+	n := 0
+	for ; n < StatCPUFields; n++ {
+		v, next, ok := Uint(rest)
+		if !ok {
+			break
+		}
+		fields[n], rest = v, next
+	}
+
+	// Anything left must be more counters, not garbage
+	if n == StatCPUFields {
+		for !isBlank(rest) {
+			if _, rest, ok = Uint(rest); !ok {
+				return 0, fmt.Errorf("%w: trailing garbage on cpu%d line", ErrMalformed, cpuId)
+			}
+		}
+	} else if n < StatMinCPUFields || !isBlank(rest) {
+		return 0, fmt.Errorf("%w: field %d of cpu%d line", ErrMalformed, n+1, cpuId)
+	}
+
+	for i := n; i < StatCPUFields; i++ {
+		fields[i] = 0
+	}
+
+	return int32(cpuId), nil
+}
+
+func isBlank(b []byte) bool {
+	for _, c := range b {
+		if !isSpace(c) {
+			return false
+		}
+	}
+
+	return true
+}
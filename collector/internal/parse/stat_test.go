@@ -0,0 +1,44 @@
+package parse
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"solelab.tech/collector/internal/testutil"
+)
+
+func BenchmarkParse(b *testing.B) {
+	for _, coresPerSocket := range []int{4, 64, 256} {
+		b.Run(fmt.Sprintf("cpus=%d", 4*coresPerSocket), func(b *testing.B) {
+			m := testutil.NewMachine(testutil.DualSocket(coresPerSocket))
+			m.Step(time.Hour, func(cpu int) float64 { return float64(cpu%10) / 10 })
+			stat := m.Stat()
+
+			var fields [StatCPUFields]uint64
+			b.SetBytes(int64(len(stat)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				data := stat
+				for len(data) > 0 {
+					line := data
+					if j := bytes.IndexByte(data, '\n'); j >= 0 {
+						line, data = data[:j], data[j+1:]
+					} else {
+						data = nil
+					}
+
+					if !IsStatCPULine(line) {
+						continue
+					}
+
+					if _, err := StatCPULine(line, &fields); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
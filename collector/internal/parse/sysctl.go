@@ -0,0 +1,181 @@
+package parse
+
+import (
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// CPUStates is the number of counters per CPU of FreeBSD's kern.cp_times,
+// user, nice, system, interrupt and idle.
+const CPUStates = 5
+
+// CPTimes parses the raw value of FreeBSD's kern.cp_times sysctl, an array of
+// C longs of wordSize bytes in the host's byte order, CPUStates per CPU. The
+// index is the CPU ID, CPUs absent up to the highest ID are included as zeros.
+func CPTimes(b []byte, wordSize int) ([][CPUStates]uint64, error) {
+	if wordSize != 4 && wordSize != 8 {
+		return nil, fmt.Errorf("%w: word size %d", ErrMalformed, wordSize)
+	}
+
+	if len(b) == 0 || len(b)%(CPUStates*wordSize) != 0 {
+		return nil, fmt.Errorf("%w: kern.cp_times has %d bytes, not a multiple of %d", ErrMalformed, len(b), CPUStates*wordSize)
+	}
+
+	times := make([][CPUStates]uint64, len(b)/(CPUStates*wordSize))
+	for i := range times {
+		for j := range times[i] {
+			if wordSize == 8 {
+				times[i][j] = binary.NativeEndian.Uint64(b)
+			} else {
+				times[i][j] = uint64(binary.NativeEndian.Uint32(b))
+			}
+			b = b[wordSize:]
+		}
+	}
+
+	return times, nil
+}
+
+// BSDLoadAvg parses the raw value of FreeBSD's vm.loadavg sysctl, a struct
+// loadavg of three fixed point uint32 averages followed by the C long scale.
+func BSDLoadAvg(b []byte, wordSize int) ([3]float64, error) {
+	var load [3]float64
+
+	// The scale is aligned to its own size
+	offset := (3*4 + wordSize - 1) / wordSize * wordSize
+	if (wordSize != 4 && wordSize != 8) || len(b) < offset+wordSize {
+		return load, fmt.Errorf("%w: vm.loadavg has %d bytes", ErrMalformed, len(b))
+	}
+
+	var scale uint64
+	if wordSize == 8 {
+		scale = binary.NativeEndian.Uint64(b[offset:])
+	} else {
+		scale = uint64(binary.NativeEndian.Uint32(b[offset:]))
+	}
+	if scale == 0 {
+		return load, fmt.Errorf("%w: vm.loadavg has a zero scale", ErrMalformed)
+	}
+
+	for i := range load {
+		load[i] = float64(binary.NativeEndian.Uint32(b[4*i:])) / float64(scale)
+	}
+
+	return load, nil
+}
+
+// SchedTopologyEntry is a CPU of FreeBSD's kern.sched.topology_spec. Cores
+// are numbered in the order of their SMT groups, sockets in the order of the
+// groups below the root.
+type SchedTopologyEntry struct {
+	CPU    int32
+	Core   int32
+	Socket int32
+}
+
+type schedGroup struct {
+	CPUs     string       `xml:"cpu"`
+	Flags    []string     `xml:"flags>flag"`
+	Children []schedGroup `xml:"children>group"`
+}
+
+func (g *schedGroup) smt() bool {
+	for _, flag := range g.Flags {
+		if strings.Contains(flag, "SMT") || strings.Contains(flag, "THREAD") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (g *schedGroup) cpus() ([]int32, error) {
+	// e.g. "0, 1, 2, 3", unlike the ranges of Linux
+	return CPUList(strings.ReplaceAll(g.CPUs, " ", ""))
+}
+
+// SchedTopology parses FreeBSD's kern.sched.topology_spec, the XML tree of
+// CPU groups sharing a cache level. A CPU outside any SMT group is a core of
+// its own. Machines without SMT report no SMT groups at all, smt is false for
+// them.
+func SchedTopology(spec string) (entries []SchedTopologyEntry, smt bool, err error) {
+	var root struct {
+		Groups []schedGroup `xml:"group"`
+	}
+	if err := xml.Unmarshal([]byte(spec), &root); err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	if len(root.Groups) != 1 {
+		return nil, false, fmt.Errorf("%w: expected a single root group, got %d", ErrMalformed, len(root.Groups))
+	}
+
+	seen := make(map[int32]bool)
+	var core int32
+	var walk func(g *schedGroup, socket int32) error
+	walk = func(g *schedGroup, socket int32) error {
+		if len(g.Children) > 0 && !g.smt() {
+			for i := range g.Children {
+				if err := walk(&g.Children[i], socket); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+
+		cpus, err := g.cpus()
+		if err != nil {
+			return err
+		}
+
+		smt = smt || g.smt()
+		for _, cpu := range cpus {
+			if seen[cpu] {
+				return fmt.Errorf("%w: CPU %d is in two groups", ErrMalformed, cpu)
+			}
+			seen[cpu] = true
+
+			entries = append(entries, SchedTopologyEntry{CPU: cpu, Core: core, Socket: socket})
+			if !g.smt() {
+				core++
+			}
+		}
+		if g.smt() {
+			core++
+		}
+
+		return nil
+	}
+
+	// Below a single socket the root already is the package, with the cores
+	// as its children
+	top := &root.Groups[0]
+	multiSocket := false
+	for i := range top.Children {
+		if len(top.Children[i].Children) > 0 {
+			multiSocket = true
+		}
+	}
+
+	if !multiSocket {
+		err = walk(top, 0)
+	} else {
+		for i := range top.Children {
+			if err = walk(&top.Children[i], int32(i)); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(entries) == 0 {
+		return nil, false, fmt.Errorf("%w: no CPUs in the topology", ErrMalformed)
+	}
+
+	return entries, smt, nil
+}
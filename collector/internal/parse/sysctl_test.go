@@ -0,0 +1,125 @@
+package parse
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// A dual socket machine with 2 cores per socket, as reported by FreeBSD 14
+const dualSocketTopologySpec = `<groups>
+ <group level="1" cache-level="0">
+  <cpu count="8" mask="ff,0,0,0">0, 1, 2, 3, 4, 5, 6, 7</cpu>
+  <children>
+   <group level="2" cache-level="3">
+    <cpu count="4" mask="f,0,0,0">0, 1, 2, 3</cpu>
+    <children>
+     <group level="3" cache-level="2">
+      <cpu count="2" mask="3,0,0,0">0, 1</cpu>
+      <flags><flag name="THREAD">THREAD group</flag><flag name="SMT">SMT group</flag></flags>
+     </group>
+     <group level="3" cache-level="2">
+      <cpu count="2" mask="c,0,0,0">2, 3</cpu>
+      <flags><flag name="THREAD">THREAD group</flag><flag name="SMT">SMT group</flag></flags>
+     </group>
+    </children>
+   </group>
+   <group level="2" cache-level="3">
+    <cpu count="4" mask="f0,0,0,0">4, 5, 6, 7</cpu>
+    <children>
+     <group level="3" cache-level="2">
+      <cpu count="2" mask="30,0,0,0">4, 5</cpu>
+      <flags><flag name="THREAD">THREAD group</flag><flag name="SMT">SMT group</flag></flags>
+     </group>
+     <group level="3" cache-level="2">
+      <cpu count="2" mask="c0,0,0,0">6, 7</cpu>
+      <flags><flag name="THREAD">THREAD group</flag><flag name="SMT">SMT group</flag></flags>
+     </group>
+    </children>
+   </group>
+  </children>
+ </group>
+</groups>
+`
+
+const singleSocketNoSMTTopologySpec = `<groups>
+ <group level="1" cache-level="3">
+  <cpu count="2" mask="3,0,0,0">0, 1</cpu>
+ </group>
+</groups>
+`
+
+func TestSchedTopology(t *testing.T) {
+	entries, smt, err := SchedTopology(dualSocketTopologySpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []SchedTopologyEntry{
+		{CPU: 0, Core: 0, Socket: 0}, {CPU: 1, Core: 0, Socket: 0},
+		{CPU: 2, Core: 1, Socket: 0}, {CPU: 3, Core: 1, Socket: 0},
+		{CPU: 4, Core: 2, Socket: 1}, {CPU: 5, Core: 2, Socket: 1},
+		{CPU: 6, Core: 3, Socket: 1}, {CPU: 7, Core: 3, Socket: 1},
+	}
+	if !smt || !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected %v with SMT, got %v, SMT %v", expected, entries, smt)
+	}
+
+	entries, smt, err = SchedTopology(singleSocketNoSMTTopologySpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = []SchedTopologyEntry{{CPU: 0, Core: 0, Socket: 0}, {CPU: 1, Core: 1, Socket: 0}}
+	if smt || !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected %v without SMT, got %v, SMT %v", expected, entries, smt)
+	}
+
+	if _, _, err := SchedTopology("<groups></groups>"); err == nil {
+		t.Errorf("expected an error for an empty topology")
+	}
+}
+
+func TestCPTimes(t *testing.T) {
+	for _, wordSize := range []int{4, 8} {
+		b := make([]byte, 2*CPUStates*wordSize)
+		for i := 0; i < 2*CPUStates; i++ {
+			if wordSize == 8 {
+				binary.NativeEndian.PutUint64(b[i*wordSize:], uint64(i+1))
+			} else {
+				binary.NativeEndian.PutUint32(b[i*wordSize:], uint32(i+1))
+			}
+		}
+
+		times, err := CPTimes(b, wordSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := [][CPUStates]uint64{{1, 2, 3, 4, 5}, {6, 7, 8, 9, 10}}
+		if !reflect.DeepEqual(times, expected) {
+			t.Errorf("word size %d: expected %v, got %v", wordSize, expected, times)
+		}
+
+		if _, err := CPTimes(b[:len(b)-1], wordSize); err == nil {
+			t.Errorf("word size %d: expected an error for a truncated value", wordSize)
+		}
+	}
+}
+
+func TestBSDLoadAvg(t *testing.T) {
+	b := make([]byte, 24)
+	binary.NativeEndian.PutUint32(b[0:], 1024)
+	binary.NativeEndian.PutUint32(b[4:], 512)
+	binary.NativeEndian.PutUint32(b[8:], 2048)
+	binary.NativeEndian.PutUint64(b[16:], 2048)
+
+	load, err := BSDLoadAvg(b, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if load != [3]float64{0.5, 0.25, 1} {
+		t.Errorf("expected 0.5 0.25 1, got %v", load)
+	}
+}
@@ -0,0 +1,131 @@
+package parse
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxCPUs bounds the CPU IDs accepted from CPU lists, so a corrupted range
+// like 0-4294967295 can't exhaust memory.
+const MaxCPUs = 1 << 16
+
+// SysInt parses a sysfs attribute holding a single integer.
+func SysInt(b []byte) (int32, error) {
+	v, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	return int32(v), nil
+}
+
+// SysUint parses a sysfs attribute holding a single unsigned 64 bit integer,
+// e.g. a byte count.
+func SysUint(b []byte) (uint64, error) {
+	v, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	return v, nil
+}
+
+// CPUList parses the kernel's list format, e.g. "0-3,8,10-11" as used by
+// devices/system/cpu/online and thread_siblings_list.
+func CPUList(s string) ([]int32, error) {
+	var cpus []int32
+
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		lo, hi, isRange := strings.Cut(part, "-")
+		first, err := strconv.ParseUint(lo, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: CPU list %q", ErrMalformed, s)
+		}
+
+		last := first
+		if isRange {
+			if last, err = strconv.ParseUint(hi, 10, 32); err != nil {
+				return nil, fmt.Errorf("%w: CPU list %q", ErrMalformed, s)
+			}
+		}
+
+		if first > last || last >= MaxCPUs {
+			return nil, fmt.Errorf("%w: CPU range %q", ErrMalformed, part)
+		}
+
+		// Repeated ranges could add up to as much
+		if len(cpus)+int(last-first+1) > MaxCPUs {
+			return nil, fmt.Errorf("%w: CPU list %q has more than %d CPUs", ErrMalformed, s, MaxCPUs)
+		}
+
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, int32(cpu))
+		}
+	}
+
+	return cpus, nil
+}
+
+// LsCPUEntry is a line of lscpu -e=CPU,NODE,SOCKET,CORE.
+type LsCPUEntry struct {
+	CPU    int32
+	Node   int32
+	Socket int32
+	Core   int32
+}
+
+// LsCPU parses the extended output of lscpu, skipping the header. lscpu
+// prints "-" for a column that doesn't apply. Without NUMA the node counts as
+// 0, offline CPUs have neither socket nor core and are left out.
+func LsCPU(out string) ([]LsCPUEntry, error) {
+	/*
+		# lscpu -e=CPU,NODE,SOCKET,CORE
+		Format:
+		CPU NODE SOCKET CORE
+		0   0    0      0
+		1   0    0      1
+	*/
+
+	var entries []LsCPUEntry
+	for i, line := range strings.Split(out, "\n") {
+		items := strings.Fields(line)
+		if len(items) == 0 || (i == 0 && items[0] == "CPU") {
+			continue
+		}
+
+		if len(items) != 4 {
+			return nil, fmt.Errorf("%w: lscpu line %d has %d columns, expected 4", ErrMalformed, i+1, len(items))
+		}
+
+		if items[0] == "-" {
+			return nil, fmt.Errorf("%w: lscpu line %d has no CPU", ErrMalformed, i+1)
+		}
+
+		if items[2] == "-" || items[3] == "-" {
+			continue
+		}
+
+		var values [4]int32
+		for j, item := range items {
+			if item == "-" {
+				continue
+			}
+
+			v, err := strconv.ParseUint(item, 10, 31)
+			if err != nil {
+				return nil, fmt.Errorf("%w: lscpu line %d: %v", ErrMalformed, i+1, err)
+			}
+			values[j] = int32(v)
+		}
+
+		entries = append(entries, LsCPUEntry{CPU: values[0], Node: values[1], Socket: values[2], Core: values[3]})
+	}
+
+	return entries, nil
+}
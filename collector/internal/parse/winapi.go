@@ -0,0 +1,180 @@
+package parse
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// ProcessorPerformanceSize is the size of Windows'
+// SYSTEM_PROCESSOR_PERFORMANCE_INFORMATION, five 64 bit times and a 32 bit
+// interrupt count, padded.
+const ProcessorPerformanceSize = 48
+
+// ProcessorPerformance is a processor's times in 100ns units. The kernel time
+// includes the idle, DPC and interrupt times.
+type ProcessorPerformance struct {
+	Idle      uint64
+	Kernel    uint64
+	User      uint64
+	DPC       uint64
+	Interrupt uint64
+}
+
+// ProcessorPerformances parses the array NtQuerySystemInformation returns for
+// SystemProcessorPerformanceInformation, one entry per processor of the
+// calling thread's processor group.
+func ProcessorPerformances(b []byte) ([]ProcessorPerformance, error) {
+	if len(b) == 0 || len(b)%ProcessorPerformanceSize != 0 {
+		return nil, fmt.Errorf("%w: processor performance information has %d bytes, not a multiple of %d", ErrMalformed, len(b), ProcessorPerformanceSize)
+	}
+
+	perfs := make([]ProcessorPerformance, len(b)/ProcessorPerformanceSize)
+	for i := range perfs {
+		entry := b[i*ProcessorPerformanceSize:]
+		perfs[i] = ProcessorPerformance{
+			Idle:      binary.LittleEndian.Uint64(entry[0:]),
+			Kernel:    binary.LittleEndian.Uint64(entry[8:]),
+			User:      binary.LittleEndian.Uint64(entry[16:]),
+			DPC:       binary.LittleEndian.Uint64(entry[24:]),
+			Interrupt: binary.LittleEndian.Uint64(entry[32:]),
+		}
+	}
+
+	return perfs, nil
+}
+
+// The LOGICAL_PROCESSOR_RELATIONSHIP values of the records of
+// GetLogicalProcessorInformationEx.
+const (
+	RelationProcessorCore    = 0
+	RelationNumaNode         = 1
+	RelationProcessorPackage = 3
+	RelationAll              = 0xffff
+)
+
+// LogicalProcessorEntry is a logical processor of
+// GetLogicalProcessorInformationEx. The CPU is numbered across processor
+// groups, cores and packages in the order Windows reports them.
+type LogicalProcessorEntry struct {
+	CPU    int32
+	Group  int32
+	Core   int32
+	Socket int32
+	Node   int32
+}
+
+// groupMasks reads the GROUP_AFFINITY array at the end of a PROCESSOR_ or
+// NUMA_NODE_RELATIONSHIP, both have the count at offset 22 and the array at
+// offset 24. The CPUs are numbered wordSize*8 per group.
+func groupMasks(b []byte, wordSize int) ([]int32, error) {
+	if len(b) < 24 {
+		return nil, fmt.Errorf("%w: truncated processor relationship", ErrMalformed)
+	}
+
+	// Before Windows 10 a NUMA node has a single mask and the count is zero
+	count := max(1, int(binary.LittleEndian.Uint16(b[22:])))
+	affinitySize := wordSize + 8
+
+	var cpus []int32
+	for i := 0; i < count; i++ {
+		affinity := b[24+i*affinitySize:]
+		if len(affinity) < affinitySize {
+			return nil, fmt.Errorf("%w: truncated group affinity", ErrMalformed)
+		}
+
+		var mask uint64
+		if wordSize == 8 {
+			mask = binary.LittleEndian.Uint64(affinity)
+		} else {
+			mask = uint64(binary.LittleEndian.Uint32(affinity))
+		}
+		group := int(binary.LittleEndian.Uint16(affinity[wordSize:]))
+
+		for bit := 0; bit < wordSize*8; bit++ {
+			if mask&(1<<bit) != 0 {
+				cpus = append(cpus, int32(group*wordSize*8+bit))
+			}
+		}
+	}
+
+	return cpus, nil
+}
+
+// LogicalProcessors parses the SYSTEM_LOGICAL_PROCESSOR_INFORMATION_EX
+// records GetLogicalProcessorInformationEx returns for RelationAll, wordSize
+// being the size of a KAFFINITY mask. Caches and groups are skipped.
+func LogicalProcessors(b []byte, wordSize int) ([]LogicalProcessorEntry, error) {
+	if wordSize != 4 && wordSize != 8 {
+		return nil, fmt.Errorf("%w: word size %d", ErrMalformed, wordSize)
+	}
+
+	entries := make(map[int32]*LogicalProcessorEntry)
+	var order []int32
+	var cores, packages int32
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return nil, fmt.Errorf("%w: truncated record header", ErrMalformed)
+		}
+
+		relationship := binary.LittleEndian.Uint32(b)
+		size := binary.LittleEndian.Uint32(b[4:])
+		if size < 8 || uint64(size) > uint64(len(b)) {
+			return nil, fmt.Errorf("%w: record of %d bytes", ErrMalformed, size)
+		}
+		record := b[8:size]
+		b = b[size:]
+
+		if relationship != RelationProcessorCore && relationship != RelationNumaNode && relationship != RelationProcessorPackage {
+			continue
+		}
+
+		cpus, err := groupMasks(record, wordSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, cpu := range cpus {
+			if cpu >= MaxCPUs {
+				return nil, fmt.Errorf("%w: CPU %d out of range", ErrMalformed, cpu)
+			}
+
+			entry, ok := entries[cpu]
+			if !ok {
+				entry = &LogicalProcessorEntry{CPU: cpu, Group: cpu / int32(wordSize*8)}
+				entries[cpu] = entry
+				order = append(order, cpu)
+			}
+
+			switch relationship {
+			case RelationProcessorCore:
+				entry.Core = cores
+			case RelationProcessorPackage:
+				entry.Socket = packages
+			case RelationNumaNode:
+				node := binary.LittleEndian.Uint32(record)
+				if node > MaxCPUs {
+					return nil, fmt.Errorf("%w: NUMA node %d out of range", ErrMalformed, node)
+				}
+				entry.Node = int32(node)
+			}
+		}
+
+		switch relationship {
+		case RelationProcessorCore:
+			cores++
+		case RelationProcessorPackage:
+			packages++
+		}
+	}
+
+	if len(order) == 0 {
+		return nil, fmt.Errorf("%w: no logical processors", ErrMalformed)
+	}
+
+	result := make([]LogicalProcessorEntry, 0, len(order))
+	for _, cpu := range order {
+		result = append(result, *entries[cpu])
+	}
+
+	return result, nil
+}
@@ -0,0 +1,68 @@
+package parse
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// logicalProcessorRecord encodes a SYSTEM_LOGICAL_PROCESSOR_INFORMATION_EX
+// record of a 64 bit Windows with a single group affinity.
+func logicalProcessorRecord(relationship uint32, node uint32, group uint16, mask uint64) []byte {
+	b := make([]byte, 8+24+16)
+	binary.LittleEndian.PutUint32(b[0:], relationship)
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(b)))
+	binary.LittleEndian.PutUint32(b[8:], node)
+	binary.LittleEndian.PutUint16(b[8+22:], 1)
+	binary.LittleEndian.PutUint64(b[8+24:], mask)
+	binary.LittleEndian.PutUint16(b[8+24+8:], group)
+
+	return b
+}
+
+func TestLogicalProcessors(t *testing.T) {
+	var b []byte
+	b = append(b, logicalProcessorRecord(RelationProcessorCore, 0, 0, 0x3)...)
+	b = append(b, logicalProcessorRecord(RelationProcessorCore, 0, 0, 0xc)...)
+	b = append(b, logicalProcessorRecord(RelationProcessorCore, 0, 1, 0x3)...)
+	// A cache, skipped
+	b = append(b, logicalProcessorRecord(2, 0, 0, 0xf)...)
+	b = append(b, logicalProcessorRecord(RelationProcessorPackage, 0, 0, 0xf)...)
+	b = append(b, logicalProcessorRecord(RelationProcessorPackage, 0, 1, 0x3)...)
+	b = append(b, logicalProcessorRecord(RelationNumaNode, 1, 1, 0x3)...)
+
+	entries, err := LogicalProcessors(b, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []LogicalProcessorEntry{
+		{CPU: 0, Core: 0}, {CPU: 1, Core: 0},
+		{CPU: 2, Core: 1}, {CPU: 3, Core: 1},
+		{CPU: 64, Group: 1, Core: 2, Socket: 1, Node: 1}, {CPU: 65, Group: 1, Core: 2, Socket: 1, Node: 1},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected %v, got %v", expected, entries)
+	}
+
+	if _, err := LogicalProcessors(b[:len(b)-1], 8); err == nil {
+		t.Errorf("expected an error for a truncated record")
+	}
+}
+
+func TestProcessorPerformances(t *testing.T) {
+	b := make([]byte, 2*ProcessorPerformanceSize)
+	for i := 0; i < 5; i++ {
+		binary.LittleEndian.PutUint64(b[ProcessorPerformanceSize+8*i:], uint64(i+1))
+	}
+
+	perfs, err := ProcessorPerformances(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ProcessorPerformance{{}, {Idle: 1, Kernel: 2, User: 3, DPC: 4, Interrupt: 5}}
+	if !reflect.DeepEqual(perfs, expected) {
+		t.Errorf("expected %v, got %v", expected, perfs)
+	}
+}
@@ -0,0 +1,342 @@
+// Package testutil generates synthetic procfs and sysfs trees, so the
+// collector can be exercised on topologies we don't have hardware for.
+package testutil
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing/fstest"
+	"time"
+)
+
+// UserHZ is the tick rate /proc/stat counts in.
+const UserHZ = 100
+
+const (
+	statUser = iota
+	statNice
+	statSys
+	statIdle
+	statIOWait
+	statIRQ
+	statSoftIRQ
+	statSteal
+	statGuest
+	statGuestNice
+	statFields
+)
+
+type Core struct {
+	Socket  int
+	Node    int
+	Threads int
+}
+
+type Topology struct {
+	Model string
+	Cores []Core
+	// BaseKHz is the base frequency cpufreq reports, none without it
+	BaseKHz int
+}
+
+func sameCores(sockets, coresPerSocket, threads int) []Core {
+	var cores []Core
+	for socket := 0; socket < sockets; socket++ {
+		for i := 0; i < coresPerSocket; i++ {
+			cores = append(cores, Core{Socket: socket, Node: socket, Threads: threads})
+		}
+	}
+
+	return cores
+}
+
+// DualSocket is a two socket machine with SMT2, one NUMA node per socket.
+func DualSocket(coresPerSocket int) Topology {
+	return Topology{
+		Model:   "Intel(R) Xeon(R) Platinum 8380 CPU @ 2.30GHz",
+		Cores:   sameCores(2, coresPerSocket, 2),
+		BaseKHz: 2300000,
+	}
+}
+
+// Hybrid has SMT2 performance cores followed by single threaded efficiency
+// cores, like Alder Lake.
+func Hybrid(pCores, eCores int) Topology {
+	cores := sameCores(1, pCores, 2)
+	for i := 0; i < eCores; i++ {
+		cores = append(cores, Core{Threads: 1})
+	}
+
+	return Topology{
+		Model:   "12th Gen Intel(R) Core(TM) i9-12900K",
+		Cores:   cores,
+		BaseKHz: 3200000,
+	}
+}
+
+// SMT4 has four hardware threads per core, like POWER.
+func SMT4(cores int) Topology {
+	return Topology{
+		Model: "POWER9 (architected), altivec supported",
+		Cores: sameCores(1, cores, 4),
+	}
+}
+
+type cpuState struct {
+	core     int
+	coreId   int
+	online   bool
+	counters [statFields]uint64
+	curKHz   int
+	maxKHz   int
+}
+
+// Machine is a synthetic host whose counters advance with Step.
+type Machine struct {
+	topo Topology
+	cpus []cpuState
+	// fractional ticks carried over between steps, so short steps still add up
+	carry []float64
+}
+
+// NewMachine numbers the CPUs like Linux does on Intel, the first thread of
+// every core first, then the second threads and so on.
+func NewMachine(topo Topology) *Machine {
+	m := &Machine{topo: topo}
+
+	coreIds := make(map[int]int)
+	ids := make([]int, len(topo.Cores))
+	for i, core := range topo.Cores {
+		ids[i] = coreIds[core.Socket]
+		coreIds[core.Socket]++
+	}
+
+	for thread := 0; ; thread++ {
+		added := false
+		for i, core := range topo.Cores {
+			if thread < core.Threads {
+				m.cpus = append(m.cpus, cpuState{core: i, coreId: ids[i], online: true, curKHz: topo.BaseKHz, maxKHz: topo.BaseKHz * 3 / 2})
+				added = true
+			}
+		}
+
+		if !added {
+			break
+		}
+	}
+	m.carry = make([]float64, len(m.cpus))
+
+	return m
+}
+
+func (m *Machine) NumCPUs() int {
+	return len(m.cpus)
+}
+
+// Siblings returns the CPUs sharing a core with cpu, including itself.
+func (m *Machine) Siblings(cpu int) []int {
+	var siblings []int
+	for id, state := range m.cpus {
+		if state.core == m.cpus[cpu].core {
+			siblings = append(siblings, id)
+		}
+	}
+
+	return siblings
+}
+
+// SetOnline hotplugs a CPU. Like the kernel, an offlined CPU disappears from
+// /proc/stat and its counters start over when it comes back.
+func (m *Machine) SetOnline(cpu int, online bool) {
+	if !online {
+		m.cpus[cpu].counters = [statFields]uint64{}
+	}
+	m.cpus[cpu].online = online
+}
+
+// SetFrequency sets the current frequency and the scaling_max_freq cap of a
+// CPU in kHz, by default it runs at the base frequency with turbo up to 1.5
+// times that.
+func (m *Machine) SetFrequency(cpu int, curKHz, maxKHz int) {
+	m.cpus[cpu].curKHz = curKHz
+	m.cpus[cpu].maxKHz = maxKHz
+}
+
+// SetCounters sets every counter of a CPU, e.g. close to the uint64 limit to
+// make them wrap, or lower than before to simulate a reset.
+func (m *Machine) SetCounters(cpu int, value uint64) {
+	for i := range m.cpus[cpu].counters {
+		m.cpus[cpu].counters[i] = value
+	}
+}
+
+// Step advances the counters by elapsed, with busy returning the busy
+// fraction of a CPU in [0, 1]. Busy time is split into user and system time
+// 3:1, which doesn't matter to the collector but looks like a real machine.
+func (m *Machine) Step(elapsed time.Duration, busy func(cpu int) float64) {
+	for cpu := range m.cpus {
+		state := &m.cpus[cpu]
+		if !state.online {
+			continue
+		}
+
+		b := min(1, max(0, busy(cpu)))
+		ticks := elapsed.Seconds()*UserHZ + m.carry[cpu]
+		whole := uint64(ticks)
+		m.carry[cpu] = ticks - float64(whole)
+
+		busyTicks := uint64(b*float64(whole) + 0.5)
+		state.counters[statUser] += busyTicks - busyTicks/4
+		state.counters[statSys] += busyTicks / 4
+		state.counters[statIdle] += whole - busyTicks
+	}
+}
+
+// Stat renders /proc/stat, including the aggregate line and some of the
+// other lines the kernel writes.
+func (m *Machine) Stat() []byte {
+	var total [statFields]uint64
+	for _, state := range m.cpus {
+		if !state.online {
+			continue
+		}
+
+		for i, v := range state.counters {
+			total[i] += v
+		}
+	}
+
+	var b bytes.Buffer
+	writeLine := func(name string, counters [statFields]uint64) {
+		b.WriteString(name)
+		for _, v := range counters {
+			fmt.Fprintf(&b, " %d", v)
+		}
+		b.WriteByte('\n')
+	}
+
+	writeLine("cpu ", total)
+	for cpu, state := range m.cpus {
+		if state.online {
+			writeLine(fmt.Sprintf("cpu%d", cpu), state.counters)
+		}
+	}
+
+	b.WriteString("intr 0\nctxt 0\nbtime 0\nprocesses 1\nprocs_running 1\nprocs_blocked 0\nsoftirq 0 0 0 0 0 0 0 0 0 0 0\n")
+
+	return b.Bytes()
+}
+
+// ProcFS returns the procfs files the collector reads.
+func (m *Machine) ProcFS() fstest.MapFS {
+	var cpuInfo strings.Builder
+	for cpu, state := range m.cpus {
+		if !state.online {
+			continue
+		}
+
+		core := m.topo.Cores[state.core]
+		fmt.Fprintf(&cpuInfo, "processor\t: %d\nmodel name\t: %s\nphysical id\t: %d\ncore id\t\t: %d\n\n",
+			cpu, m.topo.Model, core.Socket, state.coreId)
+	}
+
+	return fstest.MapFS{
+		"stat":    {Data: m.Stat()},
+		"cpuinfo": {Data: []byte(cpuInfo.String())},
+		"meminfo": {Data: []byte("MemTotal:       263856404 kB\n")},
+		"loadavg": {Data: []byte("0.52 0.58 0.59 2/1234 5678\n")},
+	}
+}
+
+// SysFS returns the sysfs files describing the topology and SMT state, and
+// the root cgroup's cpu.stat.
+func (m *Machine) SysFS() fstest.MapFS {
+	smt := "0"
+	var online []string
+	sys := fstest.MapFS{}
+	for cpu, state := range m.cpus {
+		dir := fmt.Sprintf("devices/system/cpu/cpu%d", cpu)
+		sys[dir] = &fstest.MapFile{Mode: fs.ModeDir | 0o755}
+		if !state.online {
+			continue
+		}
+		online = append(online, fmt.Sprint(cpu))
+
+		core := m.topo.Cores[state.core]
+		if core.Threads > 1 {
+			smt = "1"
+		}
+
+		var siblings []string
+		for _, sibling := range m.Siblings(cpu) {
+			siblings = append(siblings, fmt.Sprint(sibling))
+		}
+
+		sys[dir+"/topology/physical_package_id"] = &fstest.MapFile{Data: []byte(fmt.Sprintf("%d\n", core.Socket))}
+		sys[dir+"/topology/core_id"] = &fstest.MapFile{Data: []byte(fmt.Sprintf("%d\n", state.coreId))}
+		sys[dir+"/topology/thread_siblings_list"] = &fstest.MapFile{Data: []byte(strings.Join(siblings, ",") + "\n")}
+		sys[fmt.Sprintf("%s/node%d", dir, core.Node)] = &fstest.MapFile{Mode: fs.ModeDir | 0o755}
+
+		if m.topo.BaseKHz > 0 {
+			sys[dir+"/cpufreq/base_frequency"] = &fstest.MapFile{Data: []byte(fmt.Sprintf("%d\n", m.topo.BaseKHz))}
+			sys[dir+"/cpufreq/scaling_cur_freq"] = &fstest.MapFile{Data: []byte(fmt.Sprintf("%d\n", state.curKHz))}
+			sys[dir+"/cpufreq/scaling_max_freq"] = &fstest.MapFile{Data: []byte(fmt.Sprintf("%d\n", state.maxKHz))}
+		}
+	}
+
+	// The root cgroup accounts the same busy time as /proc/stat, guest time
+	// is already part of user time
+	var busy uint64
+	for _, state := range m.cpus {
+		for i, v := range state.counters {
+			if i != statIdle && i != statIOWait && i != statGuest && i != statGuestNice {
+				busy += v
+			}
+		}
+	}
+	usec := busy * 1000000 / UserHZ
+	sys["fs/cgroup/cpu.stat"] = &fstest.MapFile{Data: []byte(fmt.Sprintf("usage_usec %d\nuser_usec %d\nsystem_usec %d\n", usec, usec-usec/4, usec/4))}
+
+	sys["devices/system/cpu/smt/active"] = &fstest.MapFile{Data: []byte(smt + "\n")}
+	sys["devices/system/cpu/online"] = &fstest.MapFile{Data: []byte(strings.Join(online, ",") + "\n")}
+
+	return sys
+}
+
+// WriteDir writes the trees under procRoot and sysRoot, for running the
+// collector binary against them with -proc-root and -sys-root. Call it again
+// after Step to advance the counters the collector sees.
+func (m *Machine) WriteDir(procRoot, sysRoot string) error {
+	if err := writeTree(procRoot, m.ProcFS()); err != nil {
+		return err
+	}
+
+	return writeTree(sysRoot, m.SysFS())
+}
+
+func writeTree(root string, tree fstest.MapFS) error {
+	for name, file := range tree {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if file.Mode.IsDir() {
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				return fmt.Errorf("failed to create %s: %v", path, err)
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return fmt.Errorf("failed to create %s: %v", filepath.Dir(path), err)
+		}
+
+		// Rewritten in place rather than renamed, the collector keeps stat open
+		if err := os.WriteFile(path, file.Data, 0o644); err != nil {
+			return fmt.Errorf("failed to write %s: %v", path, err)
+		}
+	}
+
+	return nil
+}
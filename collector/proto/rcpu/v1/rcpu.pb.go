@@ -0,0 +1,1460 @@
+// Wire format of the collector's samples, shared by every interop path.
+//
+// Versioning: fields are only ever added within v1. Removed fields are
+// reserved, never reused. A change that breaks readers goes to rcpu.v2.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.34.2
+// 	protoc        (unknown)
+// source: proto/rcpu/v1/rcpu.proto
+
+package rcpuv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// CPUTime is a CPU's cumulative counters from /proc/stat, in USER_HZ ticks.
+// Unlike the kernel, user and nice exclude guest and guest_nice.
+type CPUTime struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	CpuId       int32                  `protobuf:"varint,1,opt,name=cpu_id,json=cpuId,proto3" json:"cpu_id,omitempty"`
+	CollectTime *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=collect_time,json=collectTime,proto3" json:"collect_time,omitempty"`
+	User        uint64                 `protobuf:"varint,3,opt,name=user,proto3" json:"user,omitempty"`
+	Nice        uint64                 `protobuf:"varint,4,opt,name=nice,proto3" json:"nice,omitempty"`
+	Sys         uint64                 `protobuf:"varint,5,opt,name=sys,proto3" json:"sys,omitempty"`
+	Idle        uint64                 `protobuf:"varint,6,opt,name=idle,proto3" json:"idle,omitempty"`
+	Iowait      uint64                 `protobuf:"varint,7,opt,name=iowait,proto3" json:"iowait,omitempty"`
+	Irq         uint64                 `protobuf:"varint,8,opt,name=irq,proto3" json:"irq,omitempty"`
+	Softirq     uint64                 `protobuf:"varint,9,opt,name=softirq,proto3" json:"softirq,omitempty"`
+	Steal       uint64                 `protobuf:"varint,10,opt,name=steal,proto3" json:"steal,omitempty"`
+	Guest       uint64                 `protobuf:"varint,11,opt,name=guest,proto3" json:"guest,omitempty"`
+	GuestNice   uint64                 `protobuf:"varint,12,opt,name=guest_nice,json=guestNice,proto3" json:"guest_nice,omitempty"`
+}
+
+func (x *CPUTime) Reset() {
+	*x = CPUTime{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_rcpu_v1_rcpu_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CPUTime) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CPUTime) ProtoMessage() {}
+
+func (x *CPUTime) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_rcpu_v1_rcpu_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CPUTime.ProtoReflect.Descriptor instead.
+func (*CPUTime) Descriptor() ([]byte, []int) {
+	return file_proto_rcpu_v1_rcpu_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *CPUTime) GetCpuId() int32 {
+	if x != nil {
+		return x.CpuId
+	}
+	return 0
+}
+
+func (x *CPUTime) GetCollectTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CollectTime
+	}
+	return nil
+}
+
+func (x *CPUTime) GetUser() uint64 {
+	if x != nil {
+		return x.User
+	}
+	return 0
+}
+
+func (x *CPUTime) GetNice() uint64 {
+	if x != nil {
+		return x.Nice
+	}
+	return 0
+}
+
+func (x *CPUTime) GetSys() uint64 {
+	if x != nil {
+		return x.Sys
+	}
+	return 0
+}
+
+func (x *CPUTime) GetIdle() uint64 {
+	if x != nil {
+		return x.Idle
+	}
+	return 0
+}
+
+func (x *CPUTime) GetIowait() uint64 {
+	if x != nil {
+		return x.Iowait
+	}
+	return 0
+}
+
+func (x *CPUTime) GetIrq() uint64 {
+	if x != nil {
+		return x.Irq
+	}
+	return 0
+}
+
+func (x *CPUTime) GetSoftirq() uint64 {
+	if x != nil {
+		return x.Softirq
+	}
+	return 0
+}
+
+func (x *CPUTime) GetSteal() uint64 {
+	if x != nil {
+		return x.Steal
+	}
+	return 0
+}
+
+func (x *CPUTime) GetGuest() uint64 {
+	if x != nil {
+		return x.Guest
+	}
+	return 0
+}
+
+func (x *CPUTime) GetGuestNice() uint64 {
+	if x != nil {
+		return x.GuestNice
+	}
+	return 0
+}
+
+// CPUTimePeriod is the difference of two consecutive CPUTimes of a CPU.
+type CPUTimePeriod struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	CpuId int32 `protobuf:"varint,1,opt,name=cpu_id,json=cpuId,proto3" json:"cpu_id,omitempty"`
+	// Measured on the monotonic clock
+	Elapsed    *durationpb.Duration `protobuf:"bytes,2,opt,name=elapsed,proto3" json:"elapsed,omitempty"`
+	UserPeriod uint64               `protobuf:"varint,3,opt,name=user_period,json=userPeriod,proto3" json:"user_period,omitempty"`
+	NicePeriod uint64               `protobuf:"varint,4,opt,name=nice_period,json=nicePeriod,proto3" json:"nice_period,omitempty"`
+	SysPeriod  uint64               `protobuf:"varint,5,opt,name=sys_period,json=sysPeriod,proto3" json:"sys_period,omitempty"`
+	// sys, irq and softirq
+	TotalSystemPeriod uint64 `protobuf:"varint,6,opt,name=total_system_period,json=totalSystemPeriod,proto3" json:"total_system_period,omitempty"`
+	IdlePeriod        uint64 `protobuf:"varint,7,opt,name=idle_period,json=idlePeriod,proto3" json:"idle_period,omitempty"`
+	// idle and iowait
+	TotalIdlePeriod uint64 `protobuf:"varint,8,opt,name=total_idle_period,json=totalIdlePeriod,proto3" json:"total_idle_period,omitempty"`
+	IowaitPeriod    uint64 `protobuf:"varint,9,opt,name=iowait_period,json=iowaitPeriod,proto3" json:"iowait_period,omitempty"`
+	IrqPeriod       uint64 `protobuf:"varint,10,opt,name=irq_period,json=irqPeriod,proto3" json:"irq_period,omitempty"`
+	SoftirqPeriod   uint64 `protobuf:"varint,11,opt,name=softirq_period,json=softirqPeriod,proto3" json:"softirq_period,omitempty"`
+	StealPeriod     uint64 `protobuf:"varint,12,opt,name=steal_period,json=stealPeriod,proto3" json:"steal_period,omitempty"`
+	GuestPeriod     uint64 `protobuf:"varint,13,opt,name=guest_period,json=guestPeriod,proto3" json:"guest_period,omitempty"`
+	TotalPeriod     uint64 `protobuf:"varint,14,opt,name=total_period,json=totalPeriod,proto3" json:"total_period,omitempty"`
+}
+
+func (x *CPUTimePeriod) Reset() {
+	*x = CPUTimePeriod{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_rcpu_v1_rcpu_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CPUTimePeriod) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CPUTimePeriod) ProtoMessage() {}
+
+func (x *CPUTimePeriod) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_rcpu_v1_rcpu_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CPUTimePeriod.ProtoReflect.Descriptor instead.
+func (*CPUTimePeriod) Descriptor() ([]byte, []int) {
+	return file_proto_rcpu_v1_rcpu_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *CPUTimePeriod) GetCpuId() int32 {
+	if x != nil {
+		return x.CpuId
+	}
+	return 0
+}
+
+func (x *CPUTimePeriod) GetElapsed() *durationpb.Duration {
+	if x != nil {
+		return x.Elapsed
+	}
+	return nil
+}
+
+func (x *CPUTimePeriod) GetUserPeriod() uint64 {
+	if x != nil {
+		return x.UserPeriod
+	}
+	return 0
+}
+
+func (x *CPUTimePeriod) GetNicePeriod() uint64 {
+	if x != nil {
+		return x.NicePeriod
+	}
+	return 0
+}
+
+func (x *CPUTimePeriod) GetSysPeriod() uint64 {
+	if x != nil {
+		return x.SysPeriod
+	}
+	return 0
+}
+
+func (x *CPUTimePeriod) GetTotalSystemPeriod() uint64 {
+	if x != nil {
+		return x.TotalSystemPeriod
+	}
+	return 0
+}
+
+func (x *CPUTimePeriod) GetIdlePeriod() uint64 {
+	if x != nil {
+		return x.IdlePeriod
+	}
+	return 0
+}
+
+func (x *CPUTimePeriod) GetTotalIdlePeriod() uint64 {
+	if x != nil {
+		return x.TotalIdlePeriod
+	}
+	return 0
+}
+
+func (x *CPUTimePeriod) GetIowaitPeriod() uint64 {
+	if x != nil {
+		return x.IowaitPeriod
+	}
+	return 0
+}
+
+func (x *CPUTimePeriod) GetIrqPeriod() uint64 {
+	if x != nil {
+		return x.IrqPeriod
+	}
+	return 0
+}
+
+func (x *CPUTimePeriod) GetSoftirqPeriod() uint64 {
+	if x != nil {
+		return x.SoftirqPeriod
+	}
+	return 0
+}
+
+func (x *CPUTimePeriod) GetStealPeriod() uint64 {
+	if x != nil {
+		return x.StealPeriod
+	}
+	return 0
+}
+
+func (x *CPUTimePeriod) GetGuestPeriod() uint64 {
+	if x != nil {
+		return x.GuestPeriod
+	}
+	return 0
+}
+
+func (x *CPUTimePeriod) GetTotalPeriod() uint64 {
+	if x != nil {
+		return x.TotalPeriod
+	}
+	return 0
+}
+
+// GroupUsage is the adjusted CPU usage of a socket or NUMA node.
+type GroupUsage struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id               int32   `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	AdjustedCpuUsage float64 `protobuf:"fixed64,2,opt,name=adjusted_cpu_usage,json=adjustedCpuUsage,proto3" json:"adjusted_cpu_usage,omitempty"`
+}
+
+func (x *GroupUsage) Reset() {
+	*x = GroupUsage{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_rcpu_v1_rcpu_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GroupUsage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GroupUsage) ProtoMessage() {}
+
+func (x *GroupUsage) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_rcpu_v1_rcpu_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GroupUsage.ProtoReflect.Descriptor instead.
+func (*GroupUsage) Descriptor() ([]byte, []int) {
+	return file_proto_rcpu_v1_rcpu_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GroupUsage) GetId() int32 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *GroupUsage) GetAdjustedCpuUsage() float64 {
+	if x != nil {
+		return x.AdjustedCpuUsage
+	}
+	return 0
+}
+
+// LLCOccupancy is the last level cache a resctrl group occupies in a domain.
+type LLCOccupancy struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Group  string `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	Domain string `protobuf:"bytes,2,opt,name=domain,proto3" json:"domain,omitempty"`
+	Bytes  uint64 `protobuf:"varint,3,opt,name=bytes,proto3" json:"bytes,omitempty"`
+}
+
+func (x *LLCOccupancy) Reset() {
+	*x = LLCOccupancy{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_rcpu_v1_rcpu_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *LLCOccupancy) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LLCOccupancy) ProtoMessage() {}
+
+func (x *LLCOccupancy) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_rcpu_v1_rcpu_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LLCOccupancy.ProtoReflect.Descriptor instead.
+func (*LLCOccupancy) Descriptor() ([]byte, []int) {
+	return file_proto_rcpu_v1_rcpu_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *LLCOccupancy) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *LLCOccupancy) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+func (x *LLCOccupancy) GetBytes() uint64 {
+	if x != nil {
+		return x.Bytes
+	}
+	return 0
+}
+
+// WindowStats summarizes RCPU over the recent samples, in percent.
+type WindowStats struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Samples int32   `protobuf:"varint,1,opt,name=samples,proto3" json:"samples,omitempty"`
+	Mean    float64 `protobuf:"fixed64,2,opt,name=mean,proto3" json:"mean,omitempty"`
+	Stddev  float64 `protobuf:"fixed64,3,opt,name=stddev,proto3" json:"stddev,omitempty"`
+	Low     float64 `protobuf:"fixed64,4,opt,name=low,proto3" json:"low,omitempty"`
+	High    float64 `protobuf:"fixed64,5,opt,name=high,proto3" json:"high,omitempty"`
+}
+
+func (x *WindowStats) Reset() {
+	*x = WindowStats{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_rcpu_v1_rcpu_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WindowStats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WindowStats) ProtoMessage() {}
+
+func (x *WindowStats) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_rcpu_v1_rcpu_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WindowStats.ProtoReflect.Descriptor instead.
+func (*WindowStats) Descriptor() ([]byte, []int) {
+	return file_proto_rcpu_v1_rcpu_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *WindowStats) GetSamples() int32 {
+	if x != nil {
+		return x.Samples
+	}
+	return 0
+}
+
+func (x *WindowStats) GetMean() float64 {
+	if x != nil {
+		return x.Mean
+	}
+	return 0
+}
+
+func (x *WindowStats) GetStddev() float64 {
+	if x != nil {
+		return x.Stddev
+	}
+	return 0
+}
+
+func (x *WindowStats) GetLow() float64 {
+	if x != nil {
+		return x.Low
+	}
+	return 0
+}
+
+func (x *WindowStats) GetHigh() float64 {
+	if x != nil {
+		return x.High
+	}
+	return 0
+}
+
+// PodAttribution is a pod's share of the node's SMT-adjusted busy time, in
+// cores.
+type PodAttribution struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Namespace     string  `protobuf:"bytes,1,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	Name          string  `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	BusyCores     float64 `protobuf:"fixed64,3,opt,name=busy_cores,json=busyCores,proto3" json:"busy_cores,omitempty"`
+	AdjustedCores float64 `protobuf:"fixed64,4,opt,name=adjusted_cores,json=adjustedCores,proto3" json:"adjusted_cores,omitempty"`
+}
+
+func (x *PodAttribution) Reset() {
+	*x = PodAttribution{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_rcpu_v1_rcpu_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PodAttribution) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PodAttribution) ProtoMessage() {}
+
+func (x *PodAttribution) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_rcpu_v1_rcpu_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PodAttribution.ProtoReflect.Descriptor instead.
+func (*PodAttribution) Descriptor() ([]byte, []int) {
+	return file_proto_rcpu_v1_rcpu_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *PodAttribution) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+func (x *PodAttribution) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *PodAttribution) GetBusyCores() float64 {
+	if x != nil {
+		return x.BusyCores
+	}
+	return 0
+}
+
+func (x *PodAttribution) GetAdjustedCores() float64 {
+	if x != nil {
+		return x.AdjustedCores
+	}
+	return 0
+}
+
+// ContainerCPU is a container's CPU usage and its share of the node's
+// adjusted busy time, in cores.
+type ContainerCPU struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id            string  `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Namespace     string  `protobuf:"bytes,2,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	Pod           string  `protobuf:"bytes,3,opt,name=pod,proto3" json:"pod,omitempty"`
+	Container     string  `protobuf:"bytes,4,opt,name=container,proto3" json:"container,omitempty"`
+	BusyCores     float64 `protobuf:"fixed64,5,opt,name=busy_cores,json=busyCores,proto3" json:"busy_cores,omitempty"`
+	AdjustedCores float64 `protobuf:"fixed64,6,opt,name=adjusted_cores,json=adjustedCores,proto3" json:"adjusted_cores,omitempty"`
+}
+
+func (x *ContainerCPU) Reset() {
+	*x = ContainerCPU{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_rcpu_v1_rcpu_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ContainerCPU) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ContainerCPU) ProtoMessage() {}
+
+func (x *ContainerCPU) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_rcpu_v1_rcpu_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ContainerCPU.ProtoReflect.Descriptor instead.
+func (*ContainerCPU) Descriptor() ([]byte, []int) {
+	return file_proto_rcpu_v1_rcpu_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ContainerCPU) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *ContainerCPU) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+func (x *ContainerCPU) GetPod() string {
+	if x != nil {
+		return x.Pod
+	}
+	return ""
+}
+
+func (x *ContainerCPU) GetContainer() string {
+	if x != nil {
+		return x.Container
+	}
+	return ""
+}
+
+func (x *ContainerCPU) GetBusyCores() float64 {
+	if x != nil {
+		return x.BusyCores
+	}
+	return 0
+}
+
+func (x *ContainerCPU) GetAdjustedCores() float64 {
+	if x != nil {
+		return x.AdjustedCores
+	}
+	return 0
+}
+
+// Sample is what a node agent reports every interval, usages in percent.
+type Sample struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Cluster          string                 `protobuf:"bytes,1,opt,name=cluster,proto3" json:"cluster,omitempty"`
+	Node             string                 `protobuf:"bytes,2,opt,name=node,proto3" json:"node,omitempty"`
+	Pool             string                 `protobuf:"bytes,3,opt,name=pool,proto3" json:"pool,omitempty"`
+	Time             *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=time,proto3" json:"time,omitempty"`
+	Interval         *durationpb.Duration   `protobuf:"bytes,5,opt,name=interval,proto3" json:"interval,omitempty"`
+	Cpus             int32                  `protobuf:"varint,6,opt,name=cpus,proto3" json:"cpus,omitempty"`
+	Cores            int32                  `protobuf:"varint,7,opt,name=cores,proto3" json:"cores,omitempty"`
+	AvgCpuUsage      float64                `protobuf:"fixed64,8,opt,name=avg_cpu_usage,json=avgCpuUsage,proto3" json:"avg_cpu_usage,omitempty"`
+	AdjustedCpuUsage float64                `protobuf:"fixed64,9,opt,name=adjusted_cpu_usage,json=adjustedCpuUsage,proto3" json:"adjusted_cpu_usage,omitempty"`
+	Load1            float64                `protobuf:"fixed64,10,opt,name=load1,proto3" json:"load1,omitempty"`
+	Load5            float64                `protobuf:"fixed64,11,opt,name=load5,proto3" json:"load5,omitempty"`
+	Load15           float64                `protobuf:"fixed64,12,opt,name=load15,proto3" json:"load15,omitempty"`
+	Label            string                 `protobuf:"bytes,13,opt,name=label,proto3" json:"label,omitempty"`
+	Sockets          []*GroupUsage          `protobuf:"bytes,14,rep,name=sockets,proto3" json:"sockets,omitempty"`
+	Nodes            []*GroupUsage          `protobuf:"bytes,15,rep,name=nodes,proto3" json:"nodes,omitempty"`
+	IrqCpus          []int32                `protobuf:"varint,16,rep,packed,name=irq_cpus,json=irqCpus,proto3" json:"irq_cpus,omitempty"`
+	Steal            float64                `protobuf:"fixed64,17,opt,name=steal,proto3" json:"steal,omitempty"`
+	// Zero when unknown
+	Derating     float64         `protobuf:"fixed64,18,opt,name=derating,proto3" json:"derating,omitempty"`
+	BusyMhz      float64         `protobuf:"fixed64,19,opt,name=busy_mhz,json=busyMhz,proto3" json:"busy_mhz,omitempty"`
+	LlcOccupancy []*LLCOccupancy `protobuf:"bytes,20,rep,name=llc_occupancy,json=llcOccupancy,proto3" json:"llc_occupancy,omitempty"`
+	// Only measured by the ipc sibling model
+	SmtInterference *float64     `protobuf:"fixed64,21,opt,name=smt_interference,json=smtInterference,proto3,oneof" json:"smt_interference,omitempty"`
+	RcpuWindow      *WindowStats `protobuf:"bytes,22,opt,name=rcpu_window,json=rcpuWindow,proto3" json:"rcpu_window,omitempty"`
+	// Only attributed with -pod-resources-socket
+	Pods []*PodAttribution `protobuf:"bytes,23,rep,name=pods,proto3" json:"pods,omitempty"`
+	// Only attributed with -cri-endpoint
+	Containers []*ContainerCPU `protobuf:"bytes,24,rep,name=containers,proto3" json:"containers,omitempty"`
+	// Cores idle on all their threads, unset by older collectors
+	FreeCores *int32 `protobuf:"varint,25,opt,name=free_cores,json=freeCores,proto3,oneof" json:"free_cores,omitempty"`
+}
+
+func (x *Sample) Reset() {
+	*x = Sample{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_rcpu_v1_rcpu_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Sample) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Sample) ProtoMessage() {}
+
+func (x *Sample) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_rcpu_v1_rcpu_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Sample.ProtoReflect.Descriptor instead.
+func (*Sample) Descriptor() ([]byte, []int) {
+	return file_proto_rcpu_v1_rcpu_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Sample) GetCluster() string {
+	if x != nil {
+		return x.Cluster
+	}
+	return ""
+}
+
+func (x *Sample) GetNode() string {
+	if x != nil {
+		return x.Node
+	}
+	return ""
+}
+
+func (x *Sample) GetPool() string {
+	if x != nil {
+		return x.Pool
+	}
+	return ""
+}
+
+func (x *Sample) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *Sample) GetInterval() *durationpb.Duration {
+	if x != nil {
+		return x.Interval
+	}
+	return nil
+}
+
+func (x *Sample) GetCpus() int32 {
+	if x != nil {
+		return x.Cpus
+	}
+	return 0
+}
+
+func (x *Sample) GetCores() int32 {
+	if x != nil {
+		return x.Cores
+	}
+	return 0
+}
+
+func (x *Sample) GetAvgCpuUsage() float64 {
+	if x != nil {
+		return x.AvgCpuUsage
+	}
+	return 0
+}
+
+func (x *Sample) GetAdjustedCpuUsage() float64 {
+	if x != nil {
+		return x.AdjustedCpuUsage
+	}
+	return 0
+}
+
+func (x *Sample) GetLoad1() float64 {
+	if x != nil {
+		return x.Load1
+	}
+	return 0
+}
+
+func (x *Sample) GetLoad5() float64 {
+	if x != nil {
+		return x.Load5
+	}
+	return 0
+}
+
+func (x *Sample) GetLoad15() float64 {
+	if x != nil {
+		return x.Load15
+	}
+	return 0
+}
+
+func (x *Sample) GetLabel() string {
+	if x != nil {
+		return x.Label
+	}
+	return ""
+}
+
+func (x *Sample) GetSockets() []*GroupUsage {
+	if x != nil {
+		return x.Sockets
+	}
+	return nil
+}
+
+func (x *Sample) GetNodes() []*GroupUsage {
+	if x != nil {
+		return x.Nodes
+	}
+	return nil
+}
+
+func (x *Sample) GetIrqCpus() []int32 {
+	if x != nil {
+		return x.IrqCpus
+	}
+	return nil
+}
+
+func (x *Sample) GetSteal() float64 {
+	if x != nil {
+		return x.Steal
+	}
+	return 0
+}
+
+func (x *Sample) GetDerating() float64 {
+	if x != nil {
+		return x.Derating
+	}
+	return 0
+}
+
+func (x *Sample) GetBusyMhz() float64 {
+	if x != nil {
+		return x.BusyMhz
+	}
+	return 0
+}
+
+func (x *Sample) GetLlcOccupancy() []*LLCOccupancy {
+	if x != nil {
+		return x.LlcOccupancy
+	}
+	return nil
+}
+
+func (x *Sample) GetSmtInterference() float64 {
+	if x != nil && x.SmtInterference != nil {
+		return *x.SmtInterference
+	}
+	return 0
+}
+
+func (x *Sample) GetRcpuWindow() *WindowStats {
+	if x != nil {
+		return x.RcpuWindow
+	}
+	return nil
+}
+
+func (x *Sample) GetPods() []*PodAttribution {
+	if x != nil {
+		return x.Pods
+	}
+	return nil
+}
+
+func (x *Sample) GetContainers() []*ContainerCPU {
+	if x != nil {
+		return x.Containers
+	}
+	return nil
+}
+
+func (x *Sample) GetFreeCores() int32 {
+	if x != nil && x.FreeCores != nil {
+		return *x.FreeCores
+	}
+	return 0
+}
+
+// SampleBatch is the body of a push to the aggregator.
+type SampleBatch struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Samples []*Sample `protobuf:"bytes,1,rep,name=samples,proto3" json:"samples,omitempty"`
+}
+
+func (x *SampleBatch) Reset() {
+	*x = SampleBatch{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_rcpu_v1_rcpu_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SampleBatch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SampleBatch) ProtoMessage() {}
+
+func (x *SampleBatch) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_rcpu_v1_rcpu_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SampleBatch.ProtoReflect.Descriptor instead.
+func (*SampleBatch) Descriptor() ([]byte, []int) {
+	return file_proto_rcpu_v1_rcpu_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *SampleBatch) GetSamples() []*Sample {
+	if x != nil {
+		return x.Samples
+	}
+	return nil
+}
+
+type PushResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *PushResponse) Reset() {
+	*x = PushResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_rcpu_v1_rcpu_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PushResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PushResponse) ProtoMessage() {}
+
+func (x *PushResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_rcpu_v1_rcpu_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PushResponse.ProtoReflect.Descriptor instead.
+func (*PushResponse) Descriptor() ([]byte, []int) {
+	return file_proto_rcpu_v1_rcpu_proto_rawDescGZIP(), []int{9}
+}
+
+// TraceRecord is a record of a trace file, a sample with the counters of
+// every CPU it was computed from.
+type TraceRecord struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Sample   *Sample    `protobuf:"bytes,1,opt,name=sample,proto3" json:"sample,omitempty"`
+	CpuTimes []*CPUTime `protobuf:"bytes,2,rep,name=cpu_times,json=cpuTimes,proto3" json:"cpu_times,omitempty"`
+}
+
+func (x *TraceRecord) Reset() {
+	*x = TraceRecord{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_rcpu_v1_rcpu_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TraceRecord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TraceRecord) ProtoMessage() {}
+
+func (x *TraceRecord) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_rcpu_v1_rcpu_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TraceRecord.ProtoReflect.Descriptor instead.
+func (*TraceRecord) Descriptor() ([]byte, []int) {
+	return file_proto_rcpu_v1_rcpu_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *TraceRecord) GetSample() *Sample {
+	if x != nil {
+		return x.Sample
+	}
+	return nil
+}
+
+func (x *TraceRecord) GetCpuTimes() []*CPUTime {
+	if x != nil {
+		return x.CpuTimes
+	}
+	return nil
+}
+
+var File_proto_rcpu_v1_rcpu_proto protoreflect.FileDescriptor
+
+var file_proto_rcpu_v1_rcpu_proto_rawDesc = []byte{
+	0x0a, 0x18, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x72, 0x63, 0x70, 0x75, 0x2f, 0x76, 0x31, 0x2f,
+	0x72, 0x63, 0x70, 0x75, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x07, 0x72, 0x63, 0x70, 0x75,
+	0x2e, 0x76, 0x31, 0x1a, 0x1e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x62, 0x75, 0x66, 0x2f, 0x64, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x1a, 0x1f, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x62, 0x75, 0x66, 0x2f, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x22, 0xbc, 0x02, 0x0a, 0x07, 0x43, 0x50, 0x55, 0x54, 0x69, 0x6d, 0x65,
+	0x12, 0x15, 0x0a, 0x06, 0x63, 0x70, 0x75, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05,
+	0x52, 0x05, 0x63, 0x70, 0x75, 0x49, 0x64, 0x12, 0x3d, 0x0a, 0x0c, 0x63, 0x6f, 0x6c, 0x6c, 0x65,
+	0x63, 0x74, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e,
+	0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e,
+	0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x0b, 0x63, 0x6f, 0x6c, 0x6c, 0x65,
+	0x63, 0x74, 0x54, 0x69, 0x6d, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x75, 0x73, 0x65, 0x72, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x04, 0x52, 0x04, 0x75, 0x73, 0x65, 0x72, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x69,
+	0x63, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x04, 0x52, 0x04, 0x6e, 0x69, 0x63, 0x65, 0x12, 0x10,
+	0x0a, 0x03, 0x73, 0x79, 0x73, 0x18, 0x05, 0x20, 0x01, 0x28, 0x04, 0x52, 0x03, 0x73, 0x79, 0x73,
+	0x12, 0x12, 0x0a, 0x04, 0x69, 0x64, 0x6c, 0x65, 0x18, 0x06, 0x20, 0x01, 0x28, 0x04, 0x52, 0x04,
+	0x69, 0x64, 0x6c, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x69, 0x6f, 0x77, 0x61, 0x69, 0x74, 0x18, 0x07,
+	0x20, 0x01, 0x28, 0x04, 0x52, 0x06, 0x69, 0x6f, 0x77, 0x61, 0x69, 0x74, 0x12, 0x10, 0x0a, 0x03,
+	0x69, 0x72, 0x71, 0x18, 0x08, 0x20, 0x01, 0x28, 0x04, 0x52, 0x03, 0x69, 0x72, 0x71, 0x12, 0x18,
+	0x0a, 0x07, 0x73, 0x6f, 0x66, 0x74, 0x69, 0x72, 0x71, 0x18, 0x09, 0x20, 0x01, 0x28, 0x04, 0x52,
+	0x07, 0x73, 0x6f, 0x66, 0x74, 0x69, 0x72, 0x71, 0x12, 0x14, 0x0a, 0x05, 0x73, 0x74, 0x65, 0x61,
+	0x6c, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x04, 0x52, 0x05, 0x73, 0x74, 0x65, 0x61, 0x6c, 0x12, 0x14,
+	0x0a, 0x05, 0x67, 0x75, 0x65, 0x73, 0x74, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x04, 0x52, 0x05, 0x67,
+	0x75, 0x65, 0x73, 0x74, 0x12, 0x1d, 0x0a, 0x0a, 0x67, 0x75, 0x65, 0x73, 0x74, 0x5f, 0x6e, 0x69,
+	0x63, 0x65, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x04, 0x52, 0x09, 0x67, 0x75, 0x65, 0x73, 0x74, 0x4e,
+	0x69, 0x63, 0x65, 0x22, 0x8d, 0x04, 0x0a, 0x0d, 0x43, 0x50, 0x55, 0x54, 0x69, 0x6d, 0x65, 0x50,
+	0x65, 0x72, 0x69, 0x6f, 0x64, 0x12, 0x15, 0x0a, 0x06, 0x63, 0x70, 0x75, 0x5f, 0x69, 0x64, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x05, 0x63, 0x70, 0x75, 0x49, 0x64, 0x12, 0x33, 0x0a, 0x07,
+	0x65, 0x6c, 0x61, 0x70, 0x73, 0x65, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e,
+	0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e,
+	0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x07, 0x65, 0x6c, 0x61, 0x70, 0x73, 0x65,
+	0x64, 0x12, 0x1f, 0x0a, 0x0b, 0x75, 0x73, 0x65, 0x72, 0x5f, 0x70, 0x65, 0x72, 0x69, 0x6f, 0x64,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0a, 0x75, 0x73, 0x65, 0x72, 0x50, 0x65, 0x72, 0x69,
+	0x6f, 0x64, 0x12, 0x1f, 0x0a, 0x0b, 0x6e, 0x69, 0x63, 0x65, 0x5f, 0x70, 0x65, 0x72, 0x69, 0x6f,
+	0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0a, 0x6e, 0x69, 0x63, 0x65, 0x50, 0x65, 0x72,
+	0x69, 0x6f, 0x64, 0x12, 0x1d, 0x0a, 0x0a, 0x73, 0x79, 0x73, 0x5f, 0x70, 0x65, 0x72, 0x69, 0x6f,
+	0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x04, 0x52, 0x09, 0x73, 0x79, 0x73, 0x50, 0x65, 0x72, 0x69,
+	0x6f, 0x64, 0x12, 0x2e, 0x0a, 0x13, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x5f, 0x73, 0x79, 0x73, 0x74,
+	0x65, 0x6d, 0x5f, 0x70, 0x65, 0x72, 0x69, 0x6f, 0x64, 0x18, 0x06, 0x20, 0x01, 0x28, 0x04, 0x52,
+	0x11, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x53, 0x79, 0x73, 0x74, 0x65, 0x6d, 0x50, 0x65, 0x72, 0x69,
+	0x6f, 0x64, 0x12, 0x1f, 0x0a, 0x0b, 0x69, 0x64, 0x6c, 0x65, 0x5f, 0x70, 0x65, 0x72, 0x69, 0x6f,
+	0x64, 0x18, 0x07, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0a, 0x69, 0x64, 0x6c, 0x65, 0x50, 0x65, 0x72,
+	0x69, 0x6f, 0x64, 0x12, 0x2a, 0x0a, 0x11, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x5f, 0x69, 0x64, 0x6c,
+	0x65, 0x5f, 0x70, 0x65, 0x72, 0x69, 0x6f, 0x64, 0x18, 0x08, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0f,
+	0x74, 0x6f, 0x74, 0x61, 0x6c, 0x49, 0x64, 0x6c, 0x65, 0x50, 0x65, 0x72, 0x69, 0x6f, 0x64, 0x12,
+	0x23, 0x0a, 0x0d, 0x69, 0x6f, 0x77, 0x61, 0x69, 0x74, 0x5f, 0x70, 0x65, 0x72, 0x69, 0x6f, 0x64,
+	0x18, 0x09, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0c, 0x69, 0x6f, 0x77, 0x61, 0x69, 0x74, 0x50, 0x65,
+	0x72, 0x69, 0x6f, 0x64, 0x12, 0x1d, 0x0a, 0x0a, 0x69, 0x72, 0x71, 0x5f, 0x70, 0x65, 0x72, 0x69,
+	0x6f, 0x64, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x04, 0x52, 0x09, 0x69, 0x72, 0x71, 0x50, 0x65, 0x72,
+	0x69, 0x6f, 0x64, 0x12, 0x25, 0x0a, 0x0e, 0x73, 0x6f, 0x66, 0x74, 0x69, 0x72, 0x71, 0x5f, 0x70,
+	0x65, 0x72, 0x69, 0x6f, 0x64, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0d, 0x73, 0x6f, 0x66,
+	0x74, 0x69, 0x72, 0x71, 0x50, 0x65, 0x72, 0x69, 0x6f, 0x64, 0x12, 0x21, 0x0a, 0x0c, 0x73, 0x74,
+	0x65, 0x61, 0x6c, 0x5f, 0x70, 0x65, 0x72, 0x69, 0x6f, 0x64, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x04,
+	0x52, 0x0b, 0x73, 0x74, 0x65, 0x61, 0x6c, 0x50, 0x65, 0x72, 0x69, 0x6f, 0x64, 0x12, 0x21, 0x0a,
+	0x0c, 0x67, 0x75, 0x65, 0x73, 0x74, 0x5f, 0x70, 0x65, 0x72, 0x69, 0x6f, 0x64, 0x18, 0x0d, 0x20,
+	0x01, 0x28, 0x04, 0x52, 0x0b, 0x67, 0x75, 0x65, 0x73, 0x74, 0x50, 0x65, 0x72, 0x69, 0x6f, 0x64,
+	0x12, 0x21, 0x0a, 0x0c, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x5f, 0x70, 0x65, 0x72, 0x69, 0x6f, 0x64,
+	0x18, 0x0e, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0b, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x50, 0x65, 0x72,
+	0x69, 0x6f, 0x64, 0x22, 0x4a, 0x0a, 0x0a, 0x47, 0x72, 0x6f, 0x75, 0x70, 0x55, 0x73, 0x61, 0x67,
+	0x65, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x02, 0x69,
+	0x64, 0x12, 0x2c, 0x0a, 0x12, 0x61, 0x64, 0x6a, 0x75, 0x73, 0x74, 0x65, 0x64, 0x5f, 0x63, 0x70,
+	0x75, 0x5f, 0x75, 0x73, 0x61, 0x67, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x10, 0x61,
+	0x64, 0x6a, 0x75, 0x73, 0x74, 0x65, 0x64, 0x43, 0x70, 0x75, 0x55, 0x73, 0x61, 0x67, 0x65, 0x22,
+	0x52, 0x0a, 0x0c, 0x4c, 0x4c, 0x43, 0x4f, 0x63, 0x63, 0x75, 0x70, 0x61, 0x6e, 0x63, 0x79, 0x12,
+	0x14, 0x0a, 0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05,
+	0x67, 0x72, 0x6f, 0x75, 0x70, 0x12, 0x16, 0x0a, 0x06, 0x64, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x64, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x12, 0x14, 0x0a,
+	0x05, 0x62, 0x79, 0x74, 0x65, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x04, 0x52, 0x05, 0x62, 0x79,
+	0x74, 0x65, 0x73, 0x22, 0x79, 0x0a, 0x0b, 0x57, 0x69, 0x6e, 0x64, 0x6f, 0x77, 0x53, 0x74, 0x61,
+	0x74, 0x73, 0x12, 0x18, 0x0a, 0x07, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x73, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x05, 0x52, 0x07, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x73, 0x12, 0x12, 0x0a, 0x04,
+	0x6d, 0x65, 0x61, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x04, 0x6d, 0x65, 0x61, 0x6e,
+	0x12, 0x16, 0x0a, 0x06, 0x73, 0x74, 0x64, 0x64, 0x65, 0x76, 0x18, 0x03, 0x20, 0x01, 0x28, 0x01,
+	0x52, 0x06, 0x73, 0x74, 0x64, 0x64, 0x65, 0x76, 0x12, 0x10, 0x0a, 0x03, 0x6c, 0x6f, 0x77, 0x18,
+	0x04, 0x20, 0x01, 0x28, 0x01, 0x52, 0x03, 0x6c, 0x6f, 0x77, 0x12, 0x12, 0x0a, 0x04, 0x68, 0x69,
+	0x67, 0x68, 0x18, 0x05, 0x20, 0x01, 0x28, 0x01, 0x52, 0x04, 0x68, 0x69, 0x67, 0x68, 0x22, 0x88,
+	0x01, 0x0a, 0x0e, 0x50, 0x6f, 0x64, 0x41, 0x74, 0x74, 0x72, 0x69, 0x62, 0x75, 0x74, 0x69, 0x6f,
+	0x6e, 0x12, 0x1c, 0x0a, 0x09, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x70, 0x61, 0x63, 0x65, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x70, 0x61, 0x63, 0x65, 0x12,
+	0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e,
+	0x61, 0x6d, 0x65, 0x12, 0x1d, 0x0a, 0x0a, 0x62, 0x75, 0x73, 0x79, 0x5f, 0x63, 0x6f, 0x72, 0x65,
+	0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x01, 0x52, 0x09, 0x62, 0x75, 0x73, 0x79, 0x43, 0x6f, 0x72,
+	0x65, 0x73, 0x12, 0x25, 0x0a, 0x0e, 0x61, 0x64, 0x6a, 0x75, 0x73, 0x74, 0x65, 0x64, 0x5f, 0x63,
+	0x6f, 0x72, 0x65, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0d, 0x61, 0x64, 0x6a, 0x75,
+	0x73, 0x74, 0x65, 0x64, 0x43, 0x6f, 0x72, 0x65, 0x73, 0x22, 0xb2, 0x01, 0x0a, 0x0c, 0x43, 0x6f,
+	0x6e, 0x74, 0x61, 0x69, 0x6e, 0x65, 0x72, 0x43, 0x50, 0x55, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64, 0x12, 0x1c, 0x0a, 0x09, 0x6e, 0x61,
+	0x6d, 0x65, 0x73, 0x70, 0x61, 0x63, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x6e,
+	0x61, 0x6d, 0x65, 0x73, 0x70, 0x61, 0x63, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x70, 0x6f, 0x64, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x70, 0x6f, 0x64, 0x12, 0x1c, 0x0a, 0x09, 0x63, 0x6f,
+	0x6e, 0x74, 0x61, 0x69, 0x6e, 0x65, 0x72, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x63,
+	0x6f, 0x6e, 0x74, 0x61, 0x69, 0x6e, 0x65, 0x72, 0x12, 0x1d, 0x0a, 0x0a, 0x62, 0x75, 0x73, 0x79,
+	0x5f, 0x63, 0x6f, 0x72, 0x65, 0x73, 0x18, 0x05, 0x20, 0x01, 0x28, 0x01, 0x52, 0x09, 0x62, 0x75,
+	0x73, 0x79, 0x43, 0x6f, 0x72, 0x65, 0x73, 0x12, 0x25, 0x0a, 0x0e, 0x61, 0x64, 0x6a, 0x75, 0x73,
+	0x74, 0x65, 0x64, 0x5f, 0x63, 0x6f, 0x72, 0x65, 0x73, 0x18, 0x06, 0x20, 0x01, 0x28, 0x01, 0x52,
+	0x0d, 0x61, 0x64, 0x6a, 0x75, 0x73, 0x74, 0x65, 0x64, 0x43, 0x6f, 0x72, 0x65, 0x73, 0x22, 0x98,
+	0x07, 0x0a, 0x06, 0x53, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x63, 0x6c, 0x75,
+	0x73, 0x74, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x63, 0x6c, 0x75, 0x73,
+	0x74, 0x65, 0x72, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x6f, 0x64, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x04, 0x6e, 0x6f, 0x64, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x70, 0x6f, 0x6f, 0x6c, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x70, 0x6f, 0x6f, 0x6c, 0x12, 0x2e, 0x0a, 0x04, 0x74,
+	0x69, 0x6d, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67,
+	0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65,
+	0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x12, 0x35, 0x0a, 0x08, 0x69,
+	0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e,
+	0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e,
+	0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x08, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x76,
+	0x61, 0x6c, 0x12, 0x12, 0x0a, 0x04, 0x63, 0x70, 0x75, 0x73, 0x18, 0x06, 0x20, 0x01, 0x28, 0x05,
+	0x52, 0x04, 0x63, 0x70, 0x75, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x63, 0x6f, 0x72, 0x65, 0x73, 0x18,
+	0x07, 0x20, 0x01, 0x28, 0x05, 0x52, 0x05, 0x63, 0x6f, 0x72, 0x65, 0x73, 0x12, 0x22, 0x0a, 0x0d,
+	0x61, 0x76, 0x67, 0x5f, 0x63, 0x70, 0x75, 0x5f, 0x75, 0x73, 0x61, 0x67, 0x65, 0x18, 0x08, 0x20,
+	0x01, 0x28, 0x01, 0x52, 0x0b, 0x61, 0x76, 0x67, 0x43, 0x70, 0x75, 0x55, 0x73, 0x61, 0x67, 0x65,
+	0x12, 0x2c, 0x0a, 0x12, 0x61, 0x64, 0x6a, 0x75, 0x73, 0x74, 0x65, 0x64, 0x5f, 0x63, 0x70, 0x75,
+	0x5f, 0x75, 0x73, 0x61, 0x67, 0x65, 0x18, 0x09, 0x20, 0x01, 0x28, 0x01, 0x52, 0x10, 0x61, 0x64,
+	0x6a, 0x75, 0x73, 0x74, 0x65, 0x64, 0x43, 0x70, 0x75, 0x55, 0x73, 0x61, 0x67, 0x65, 0x12, 0x14,
+	0x0a, 0x05, 0x6c, 0x6f, 0x61, 0x64, 0x31, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x01, 0x52, 0x05, 0x6c,
+	0x6f, 0x61, 0x64, 0x31, 0x12, 0x14, 0x0a, 0x05, 0x6c, 0x6f, 0x61, 0x64, 0x35, 0x18, 0x0b, 0x20,
+	0x01, 0x28, 0x01, 0x52, 0x05, 0x6c, 0x6f, 0x61, 0x64, 0x35, 0x12, 0x16, 0x0a, 0x06, 0x6c, 0x6f,
+	0x61, 0x64, 0x31, 0x35, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x01, 0x52, 0x06, 0x6c, 0x6f, 0x61, 0x64,
+	0x31, 0x35, 0x12, 0x14, 0x0a, 0x05, 0x6c, 0x61, 0x62, 0x65, 0x6c, 0x18, 0x0d, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x05, 0x6c, 0x61, 0x62, 0x65, 0x6c, 0x12, 0x2d, 0x0a, 0x07, 0x73, 0x6f, 0x63, 0x6b,
+	0x65, 0x74, 0x73, 0x18, 0x0e, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x72, 0x63, 0x70, 0x75,
+	0x2e, 0x76, 0x31, 0x2e, 0x47, 0x72, 0x6f, 0x75, 0x70, 0x55, 0x73, 0x61, 0x67, 0x65, 0x52, 0x07,
+	0x73, 0x6f, 0x63, 0x6b, 0x65, 0x74, 0x73, 0x12, 0x29, 0x0a, 0x05, 0x6e, 0x6f, 0x64, 0x65, 0x73,
+	0x18, 0x0f, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x72, 0x63, 0x70, 0x75, 0x2e, 0x76, 0x31,
+	0x2e, 0x47, 0x72, 0x6f, 0x75, 0x70, 0x55, 0x73, 0x61, 0x67, 0x65, 0x52, 0x05, 0x6e, 0x6f, 0x64,
+	0x65, 0x73, 0x12, 0x19, 0x0a, 0x08, 0x69, 0x72, 0x71, 0x5f, 0x63, 0x70, 0x75, 0x73, 0x18, 0x10,
+	0x20, 0x03, 0x28, 0x05, 0x52, 0x07, 0x69, 0x72, 0x71, 0x43, 0x70, 0x75, 0x73, 0x12, 0x14, 0x0a,
+	0x05, 0x73, 0x74, 0x65, 0x61, 0x6c, 0x18, 0x11, 0x20, 0x01, 0x28, 0x01, 0x52, 0x05, 0x73, 0x74,
+	0x65, 0x61, 0x6c, 0x12, 0x1a, 0x0a, 0x08, 0x64, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6e, 0x67, 0x18,
+	0x12, 0x20, 0x01, 0x28, 0x01, 0x52, 0x08, 0x64, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6e, 0x67, 0x12,
+	0x19, 0x0a, 0x08, 0x62, 0x75, 0x73, 0x79, 0x5f, 0x6d, 0x68, 0x7a, 0x18, 0x13, 0x20, 0x01, 0x28,
+	0x01, 0x52, 0x07, 0x62, 0x75, 0x73, 0x79, 0x4d, 0x68, 0x7a, 0x12, 0x3a, 0x0a, 0x0d, 0x6c, 0x6c,
+	0x63, 0x5f, 0x6f, 0x63, 0x63, 0x75, 0x70, 0x61, 0x6e, 0x63, 0x79, 0x18, 0x14, 0x20, 0x03, 0x28,
+	0x0b, 0x32, 0x15, 0x2e, 0x72, 0x63, 0x70, 0x75, 0x2e, 0x76, 0x31, 0x2e, 0x4c, 0x4c, 0x43, 0x4f,
+	0x63, 0x63, 0x75, 0x70, 0x61, 0x6e, 0x63, 0x79, 0x52, 0x0c, 0x6c, 0x6c, 0x63, 0x4f, 0x63, 0x63,
+	0x75, 0x70, 0x61, 0x6e, 0x63, 0x79, 0x12, 0x2e, 0x0a, 0x10, 0x73, 0x6d, 0x74, 0x5f, 0x69, 0x6e,
+	0x74, 0x65, 0x72, 0x66, 0x65, 0x72, 0x65, 0x6e, 0x63, 0x65, 0x18, 0x15, 0x20, 0x01, 0x28, 0x01,
+	0x48, 0x00, 0x52, 0x0f, 0x73, 0x6d, 0x74, 0x49, 0x6e, 0x74, 0x65, 0x72, 0x66, 0x65, 0x72, 0x65,
+	0x6e, 0x63, 0x65, 0x88, 0x01, 0x01, 0x12, 0x35, 0x0a, 0x0b, 0x72, 0x63, 0x70, 0x75, 0x5f, 0x77,
+	0x69, 0x6e, 0x64, 0x6f, 0x77, 0x18, 0x16, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x14, 0x2e, 0x72, 0x63,
+	0x70, 0x75, 0x2e, 0x76, 0x31, 0x2e, 0x57, 0x69, 0x6e, 0x64, 0x6f, 0x77, 0x53, 0x74, 0x61, 0x74,
+	0x73, 0x52, 0x0a, 0x72, 0x63, 0x70, 0x75, 0x57, 0x69, 0x6e, 0x64, 0x6f, 0x77, 0x12, 0x2b, 0x0a,
+	0x04, 0x70, 0x6f, 0x64, 0x73, 0x18, 0x17, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x17, 0x2e, 0x72, 0x63,
+	0x70, 0x75, 0x2e, 0x76, 0x31, 0x2e, 0x50, 0x6f, 0x64, 0x41, 0x74, 0x74, 0x72, 0x69, 0x62, 0x75,
+	0x74, 0x69, 0x6f, 0x6e, 0x52, 0x04, 0x70, 0x6f, 0x64, 0x73, 0x12, 0x35, 0x0a, 0x0a, 0x63, 0x6f,
+	0x6e, 0x74, 0x61, 0x69, 0x6e, 0x65, 0x72, 0x73, 0x18, 0x18, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x15,
+	0x2e, 0x72, 0x63, 0x70, 0x75, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x6f, 0x6e, 0x74, 0x61, 0x69, 0x6e,
+	0x65, 0x72, 0x43, 0x50, 0x55, 0x52, 0x0a, 0x63, 0x6f, 0x6e, 0x74, 0x61, 0x69, 0x6e, 0x65, 0x72,
+	0x73, 0x12, 0x22, 0x0a, 0x0a, 0x66, 0x72, 0x65, 0x65, 0x5f, 0x63, 0x6f, 0x72, 0x65, 0x73, 0x18,
+	0x19, 0x20, 0x01, 0x28, 0x05, 0x48, 0x01, 0x52, 0x09, 0x66, 0x72, 0x65, 0x65, 0x43, 0x6f, 0x72,
+	0x65, 0x73, 0x88, 0x01, 0x01, 0x42, 0x13, 0x0a, 0x11, 0x5f, 0x73, 0x6d, 0x74, 0x5f, 0x69, 0x6e,
+	0x74, 0x65, 0x72, 0x66, 0x65, 0x72, 0x65, 0x6e, 0x63, 0x65, 0x42, 0x0d, 0x0a, 0x0b, 0x5f, 0x66,
+	0x72, 0x65, 0x65, 0x5f, 0x63, 0x6f, 0x72, 0x65, 0x73, 0x22, 0x38, 0x0a, 0x0b, 0x53, 0x61, 0x6d,
+	0x70, 0x6c, 0x65, 0x42, 0x61, 0x74, 0x63, 0x68, 0x12, 0x29, 0x0a, 0x07, 0x73, 0x61, 0x6d, 0x70,
+	0x6c, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x72, 0x63, 0x70, 0x75,
+	0x2e, 0x76, 0x31, 0x2e, 0x53, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x52, 0x07, 0x73, 0x61, 0x6d, 0x70,
+	0x6c, 0x65, 0x73, 0x22, 0x0e, 0x0a, 0x0c, 0x50, 0x75, 0x73, 0x68, 0x52, 0x65, 0x73, 0x70, 0x6f,
+	0x6e, 0x73, 0x65, 0x22, 0x65, 0x0a, 0x0b, 0x54, 0x72, 0x61, 0x63, 0x65, 0x52, 0x65, 0x63, 0x6f,
+	0x72, 0x64, 0x12, 0x27, 0x0a, 0x06, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x72, 0x63, 0x70, 0x75, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x61, 0x6d,
+	0x70, 0x6c, 0x65, 0x52, 0x06, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x12, 0x2d, 0x0a, 0x09, 0x63,
+	0x70, 0x75, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x10,
+	0x2e, 0x72, 0x63, 0x70, 0x75, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x50, 0x55, 0x54, 0x69, 0x6d, 0x65,
+	0x52, 0x08, 0x63, 0x70, 0x75, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x32, 0x44, 0x0a, 0x0d, 0x53, 0x61,
+	0x6d, 0x70, 0x6c, 0x65, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x33, 0x0a, 0x04, 0x50,
+	0x75, 0x73, 0x68, 0x12, 0x14, 0x2e, 0x72, 0x63, 0x70, 0x75, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x61,
+	0x6d, 0x70, 0x6c, 0x65, 0x42, 0x61, 0x74, 0x63, 0x68, 0x1a, 0x15, 0x2e, 0x72, 0x63, 0x70, 0x75,
+	0x2e, 0x76, 0x31, 0x2e, 0x50, 0x75, 0x73, 0x68, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65,
+	0x42, 0x2d, 0x5a, 0x2b, 0x73, 0x6f, 0x6c, 0x65, 0x6c, 0x61, 0x62, 0x2e, 0x74, 0x65, 0x63, 0x68,
+	0x2f, 0x63, 0x6f, 0x6c, 0x6c, 0x65, 0x63, 0x74, 0x6f, 0x72, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x2f, 0x72, 0x63, 0x70, 0x75, 0x2f, 0x76, 0x31, 0x3b, 0x72, 0x63, 0x70, 0x75, 0x76, 0x31, 0x62,
+	0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_proto_rcpu_v1_rcpu_proto_rawDescOnce sync.Once
+	file_proto_rcpu_v1_rcpu_proto_rawDescData = file_proto_rcpu_v1_rcpu_proto_rawDesc
+)
+
+func file_proto_rcpu_v1_rcpu_proto_rawDescGZIP() []byte {
+	file_proto_rcpu_v1_rcpu_proto_rawDescOnce.Do(func() {
+		file_proto_rcpu_v1_rcpu_proto_rawDescData = protoimpl.X.CompressGZIP(file_proto_rcpu_v1_rcpu_proto_rawDescData)
+	})
+	return file_proto_rcpu_v1_rcpu_proto_rawDescData
+}
+
+var file_proto_rcpu_v1_rcpu_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
+var file_proto_rcpu_v1_rcpu_proto_goTypes = []any{
+	(*CPUTime)(nil),               // 0: rcpu.v1.CPUTime
+	(*CPUTimePeriod)(nil),         // 1: rcpu.v1.CPUTimePeriod
+	(*GroupUsage)(nil),            // 2: rcpu.v1.GroupUsage
+	(*LLCOccupancy)(nil),          // 3: rcpu.v1.LLCOccupancy
+	(*WindowStats)(nil),           // 4: rcpu.v1.WindowStats
+	(*PodAttribution)(nil),        // 5: rcpu.v1.PodAttribution
+	(*ContainerCPU)(nil),          // 6: rcpu.v1.ContainerCPU
+	(*Sample)(nil),                // 7: rcpu.v1.Sample
+	(*SampleBatch)(nil),           // 8: rcpu.v1.SampleBatch
+	(*PushResponse)(nil),          // 9: rcpu.v1.PushResponse
+	(*TraceRecord)(nil),           // 10: rcpu.v1.TraceRecord
+	(*timestamppb.Timestamp)(nil), // 11: google.protobuf.Timestamp
+	(*durationpb.Duration)(nil),   // 12: google.protobuf.Duration
+}
+var file_proto_rcpu_v1_rcpu_proto_depIdxs = []int32{
+	11, // 0: rcpu.v1.CPUTime.collect_time:type_name -> google.protobuf.Timestamp
+	12, // 1: rcpu.v1.CPUTimePeriod.elapsed:type_name -> google.protobuf.Duration
+	11, // 2: rcpu.v1.Sample.time:type_name -> google.protobuf.Timestamp
+	12, // 3: rcpu.v1.Sample.interval:type_name -> google.protobuf.Duration
+	2,  // 4: rcpu.v1.Sample.sockets:type_name -> rcpu.v1.GroupUsage
+	2,  // 5: rcpu.v1.Sample.nodes:type_name -> rcpu.v1.GroupUsage
+	3,  // 6: rcpu.v1.Sample.llc_occupancy:type_name -> rcpu.v1.LLCOccupancy
+	4,  // 7: rcpu.v1.Sample.rcpu_window:type_name -> rcpu.v1.WindowStats
+	5,  // 8: rcpu.v1.Sample.pods:type_name -> rcpu.v1.PodAttribution
+	6,  // 9: rcpu.v1.Sample.containers:type_name -> rcpu.v1.ContainerCPU
+	7,  // 10: rcpu.v1.SampleBatch.samples:type_name -> rcpu.v1.Sample
+	7,  // 11: rcpu.v1.TraceRecord.sample:type_name -> rcpu.v1.Sample
+	0,  // 12: rcpu.v1.TraceRecord.cpu_times:type_name -> rcpu.v1.CPUTime
+	8,  // 13: rcpu.v1.SampleService.Push:input_type -> rcpu.v1.SampleBatch
+	9,  // 14: rcpu.v1.SampleService.Push:output_type -> rcpu.v1.PushResponse
+	14, // [14:15] is the sub-list for method output_type
+	13, // [13:14] is the sub-list for method input_type
+	13, // [13:13] is the sub-list for extension type_name
+	13, // [13:13] is the sub-list for extension extendee
+	0,  // [0:13] is the sub-list for field type_name
+}
+
+func init() { file_proto_rcpu_v1_rcpu_proto_init() }
+func file_proto_rcpu_v1_rcpu_proto_init() {
+	if File_proto_rcpu_v1_rcpu_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_proto_rcpu_v1_rcpu_proto_msgTypes[0].Exporter = func(v any, i int) any {
+			switch v := v.(*CPUTime); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_rcpu_v1_rcpu_proto_msgTypes[1].Exporter = func(v any, i int) any {
+			switch v := v.(*CPUTimePeriod); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_rcpu_v1_rcpu_proto_msgTypes[2].Exporter = func(v any, i int) any {
+			switch v := v.(*GroupUsage); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_rcpu_v1_rcpu_proto_msgTypes[3].Exporter = func(v any, i int) any {
+			switch v := v.(*LLCOccupancy); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_rcpu_v1_rcpu_proto_msgTypes[4].Exporter = func(v any, i int) any {
+			switch v := v.(*WindowStats); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_rcpu_v1_rcpu_proto_msgTypes[5].Exporter = func(v any, i int) any {
+			switch v := v.(*PodAttribution); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_rcpu_v1_rcpu_proto_msgTypes[6].Exporter = func(v any, i int) any {
+			switch v := v.(*ContainerCPU); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_rcpu_v1_rcpu_proto_msgTypes[7].Exporter = func(v any, i int) any {
+			switch v := v.(*Sample); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_rcpu_v1_rcpu_proto_msgTypes[8].Exporter = func(v any, i int) any {
+			switch v := v.(*SampleBatch); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_rcpu_v1_rcpu_proto_msgTypes[9].Exporter = func(v any, i int) any {
+			switch v := v.(*PushResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_rcpu_v1_rcpu_proto_msgTypes[10].Exporter = func(v any, i int) any {
+			switch v := v.(*TraceRecord); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_proto_rcpu_v1_rcpu_proto_msgTypes[7].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_proto_rcpu_v1_rcpu_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   11,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_proto_rcpu_v1_rcpu_proto_goTypes,
+		DependencyIndexes: file_proto_rcpu_v1_rcpu_proto_depIdxs,
+		MessageInfos:      file_proto_rcpu_v1_rcpu_proto_msgTypes,
+	}.Build()
+	File_proto_rcpu_v1_rcpu_proto = out.File
+	file_proto_rcpu_v1_rcpu_proto_rawDesc = nil
+	file_proto_rcpu_v1_rcpu_proto_goTypes = nil
+	file_proto_rcpu_v1_rcpu_proto_depIdxs = nil
+}
@@ -0,0 +1,134 @@
+// Wire format of the collector's samples, shared by every interop path.
+//
+// Versioning: fields are only ever added within v1. Removed fields are
+// reserved, never reused. A change that breaks readers goes to rcpu.v2.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: proto/rcpu/v1/rcpu.proto
+
+package rcpuv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	SampleService_Push_FullMethodName = "/rcpu.v1.SampleService/Push"
+)
+
+// SampleServiceClient is the client API for SampleService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// SampleService receives the samples of the collectors pushing with
+// -upstream. A batch that fails is pushed again, the aggregator keeps the
+// latest sample of a node so duplicates are harmless.
+type SampleServiceClient interface {
+	Push(ctx context.Context, in *SampleBatch, opts ...grpc.CallOption) (*PushResponse, error)
+}
+
+type sampleServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSampleServiceClient(cc grpc.ClientConnInterface) SampleServiceClient {
+	return &sampleServiceClient{cc}
+}
+
+func (c *sampleServiceClient) Push(ctx context.Context, in *SampleBatch, opts ...grpc.CallOption) (*PushResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PushResponse)
+	err := c.cc.Invoke(ctx, SampleService_Push_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SampleServiceServer is the server API for SampleService service.
+// All implementations must embed UnimplementedSampleServiceServer
+// for forward compatibility.
+//
+// SampleService receives the samples of the collectors pushing with
+// -upstream. A batch that fails is pushed again, the aggregator keeps the
+// latest sample of a node so duplicates are harmless.
+type SampleServiceServer interface {
+	Push(context.Context, *SampleBatch) (*PushResponse, error)
+	mustEmbedUnimplementedSampleServiceServer()
+}
+
+// UnimplementedSampleServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedSampleServiceServer struct{}
+
+func (UnimplementedSampleServiceServer) Push(context.Context, *SampleBatch) (*PushResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Push not implemented")
+}
+func (UnimplementedSampleServiceServer) mustEmbedUnimplementedSampleServiceServer() {}
+func (UnimplementedSampleServiceServer) testEmbeddedByValue()                       {}
+
+// UnsafeSampleServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SampleServiceServer will
+// result in compilation errors.
+type UnsafeSampleServiceServer interface {
+	mustEmbedUnimplementedSampleServiceServer()
+}
+
+func RegisterSampleServiceServer(s grpc.ServiceRegistrar, srv SampleServiceServer) {
+	// If the following call pancis, it indicates UnimplementedSampleServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&SampleService_ServiceDesc, srv)
+}
+
+func _SampleService_Push_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SampleBatch)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SampleServiceServer).Push(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SampleService_Push_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SampleServiceServer).Push(ctx, req.(*SampleBatch))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// SampleService_ServiceDesc is the grpc.ServiceDesc for SampleService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var SampleService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "rcpu.v1.SampleService",
+	HandlerType: (*SampleServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Push",
+			Handler:    _SampleService_Push_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "proto/rcpu/v1/rcpu.proto",
+}
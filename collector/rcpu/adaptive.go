@@ -0,0 +1,75 @@
+package rcpu
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	DefaultAdaptiveFastInterval = 250 * time.Millisecond
+	DefaultAdaptiveThreshold    = 40.0 // The scheduler plugin's default overload threshold
+	DefaultAdaptiveMargin       = 10.0
+	DefaultAdaptiveVolatility   = 5.0
+
+	// Calm ticks needed before slowing down again, one step at a time
+	adaptiveCalmTicks = 5
+)
+
+// AdaptiveInterval samples at the slow base interval on quiet nodes, and
+// switches to the fast interval while the adjusted usage is volatile or close
+// to the overload threshold, where bursts matter.
+type AdaptiveInterval struct {
+	Base time.Duration
+	Fast time.Duration
+
+	// Threshold and Margin are in adjusted CPU usage percent
+	Threshold float64
+	Margin    float64
+	// Volatility is the change in percentage points between two samples
+	// considered a burst
+	Volatility float64
+
+	current   time.Duration
+	prevUsage float64
+	hasPrev   bool
+	calmTicks int
+}
+
+func NewAdaptiveInterval(base, fast time.Duration) *AdaptiveInterval {
+	return &AdaptiveInterval{
+		Base:       base,
+		Fast:       fast,
+		Threshold:  DefaultAdaptiveThreshold,
+		Margin:     DefaultAdaptiveMargin,
+		Volatility: DefaultAdaptiveVolatility,
+		current:    base,
+	}
+}
+
+func (a *AdaptiveInterval) Current() time.Duration {
+	return a.current
+}
+
+// Next returns the interval to use after observing the usage. It jumps to
+// the fast interval at once, and backs off by doubling after a few calm ticks.
+func (a *AdaptiveInterval) Next(adjustedUsage float64) time.Duration {
+	volatile := a.hasPrev && math.Abs(adjustedUsage-a.prevUsage) >= a.Volatility
+	nearThreshold := math.Abs(adjustedUsage-a.Threshold) <= a.Margin
+
+	a.prevUsage = adjustedUsage
+	a.hasPrev = true
+
+	if volatile || nearThreshold {
+		a.calmTicks = 0
+		a.current = a.Fast
+		return a.current
+	}
+
+	a.calmTicks++
+	if a.calmTicks >= adaptiveCalmTicks && a.current < a.Base {
+		a.calmTicks = 0
+		a.current = min(a.Base, 2*a.current)
+	}
+
+	return a.current
+}
@@ -0,0 +1,767 @@
+package rcpu
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	apiv1 "solelab.tech/collector/api/v1"
+	rcpuv1 "solelab.tech/collector/proto/rcpu/v1"
+)
+
+const (
+	DefaultAggregateListenAddr = ":9464"
+	DefaultAggregateStaleAfter = 30 * time.Second
+	// DefaultAggregateForgetAfter keeps a silent node as unknown for a while,
+	// so a dead collector shows up rather than the node just vanishing
+	DefaultAggregateForgetAfter = 10 * time.Minute
+	DefaultAggregateMaxSkew     = time.Minute
+
+	DefaultPool = "default"
+
+	// SamplesPath receives samples on the aggregator, and serves the latest
+	// ones on both the aggregator and the collector's -metrics-listen. It and
+	// RollupsPath predate the /v1 API, see api/v1, and serve bare arrays.
+	SamplesPath = "/samples"
+	RollupsPath = "/rollups"
+)
+
+// Sample is what a node agent reports every interval. Its protobuf schema is
+// in proto/rcpu/v1/rcpu.proto, keep both and the conversions in protobuf.go
+// in sync.
+type Sample struct {
+	Cluster string    `json:"cluster,omitempty"`
+	Node    string    `json:"node"`
+	Pool    string    `json:"pool,omitempty"`
+	Time    time.Time `json:"time"`
+	// Interval is the actual elapsed time the usage was measured over
+	Interval         time.Duration `json:"interval,omitempty"`
+	CPUs             int           `json:"cpus"`
+	Cores            int           `json:"cores"`
+	AvgCPUUsage      float64       `json:"avg_cpu_usage"`
+	AdjustedCPUUsage float64       `json:"adjusted_cpu_usage"`
+	Load1            float64       `json:"load1,omitempty"`
+	Load5            float64       `json:"load5,omitempty"`
+	Load15           float64       `json:"load15,omitempty"`
+	// FreeCores is the number of cores idle on all their threads, see
+	// FreeCores, unknown in the samples of older collectors
+	FreeCores *int `json:"free_cores,omitempty"`
+	// Label is the mark the sample falls in, see Marks
+	Label   string       `json:"label,omitempty"`
+	Sockets []GroupUsage `json:"sockets,omitempty"`
+	Nodes   []GroupUsage `json:"nodes,omitempty"`
+	IRQCPUs []int32      `json:"irq_cpus,omitempty"`
+	// Steal is the share of CPU time taken by the hypervisor, only in VMs
+	Steal float64 `json:"steal,omitempty"`
+	// Derating is the capacity derating factor, see Frequency.Derating
+	Derating float64 `json:"derating,omitempty"`
+	BusyMHz  float64 `json:"busy_mhz,omitempty"`
+	// LLCOccupancy is only collected where resctrl is mounted
+	LLCOccupancy []LLCOccupancy `json:"llc_occupancy,omitempty"`
+	// SMTInterference is only measured by the ipc sibling model, see
+	// IPCSiblingModel.Interference
+	SMTInterference *float64 `json:"smt_interference,omitempty"`
+	// Window summarizes RCPU over the last -window samples
+	Window *WindowStats `json:"rcpu_window,omitempty"`
+	// Pods are only attributed with -pod-resources-socket
+	Pods []PodAttribution `json:"pods,omitempty"`
+	// Containers are only attributed with -cri-endpoint
+	Containers []ContainerCPU `json:"containers,omitempty"`
+	// PerCore is the usage of every physical core, only delivered to the
+	// subscribers of the SampleFeed, never reported, as are the fields below
+	PerCore []CoreUsage `json:"-"`
+	// Periods sums the time of every CPU by kind
+	Periods PeriodTotals `json:"-"`
+	// AnomalyZ is the distance of the adjusted usage from its recent mean,
+	// see AnomalyDetector
+	AnomalyZ float64 `json:"-"`
+	// CPUTimes are the counters of every CPU the sample was computed from,
+	// and CPUTimePeriods the periods since the last ones, indexed by CPU ID
+	CPUTimes       []CPUTime       `json:"-"`
+	CPUTimePeriods []CPUTimePeriod `json:"-"`
+	// Detection is the topology the sample was computed with, a new one once
+	// the topology changed
+	Detection *Detection `json:"-"`
+}
+
+func (s *Sample) RCPU() float64 {
+	return 100.0 - s.AdjustedCPUUsage
+}
+
+// LoadPerFreeCore relates the load to the remaining CPU, see LoadPerFreeCore.
+func (s *Sample) LoadPerFreeCore() float64 {
+	return LoadPerFreeCore(s.Load1, s.Cores, s.AdjustedCPUUsage)
+}
+
+// LoadPerFreeCore is the 1 minute load over the remaining physical cores
+// following RCPU, how many runnable tasks compete for each core that is
+// effectively free. Less than one free core counts as one, so the ratio
+// stays finite on a saturated node.
+func LoadPerFreeCore(load1 float64, cores int, adjustedCPUUsage float64) float64 {
+	free := float64(cores) * (100.0 - adjustedCPUUsage) / 100.0
+
+	return load1 / max(1, free)
+}
+
+// RemainingCores is the remaining CPU in physical cores, following RCPU.
+func (s *Sample) RemainingCores() float64 {
+	return float64(s.Cores) * s.RCPU() / 100.0
+}
+
+func (s *Sample) Validate() error {
+	if s.Node == "" {
+		return fmt.Errorf("missing node name")
+	}
+
+	if s.Cores <= 0 || s.CPUs < s.Cores {
+		return fmt.Errorf("invalid topology: %d CPUs, %d cores", s.CPUs, s.Cores)
+	}
+
+	// NaN compares false to both bounds
+	for _, usage := range []float64{s.AvgCPUUsage, s.AdjustedCPUUsage} {
+		if math.IsNaN(usage) || math.IsInf(usage, 0) || usage < 0 || usage > 100 {
+			return fmt.Errorf("usage out of range: avg %.2f%%, adjusted %.2f%%", s.AvgCPUUsage, s.AdjustedCPUUsage)
+		}
+	}
+
+	return nil
+}
+
+// Rollup summarizes the nodes of a pool in a cluster, an empty pool means all
+// pools of the cluster and an empty cluster means the federation as a whole.
+type Rollup struct {
+	Cluster string `json:"cluster"`
+	Pool    string `json:"pool"`
+	Nodes   int    `json:"nodes"`
+	// UnknownNodes stopped reporting, their collector is presumably dead,
+	// they count in none of the other fields
+	UnknownNodes   int     `json:"unknown_nodes"`
+	Cores          int     `json:"cores"`
+	RemainingCores float64 `json:"remaining_cores"`
+	RCPUMin        float64 `json:"rcpu_min"`
+	RCPUP10        float64 `json:"rcpu_p10"`
+	RCPUP50        float64 `json:"rcpu_p50"`
+	RCPUP90        float64 `json:"rcpu_p90"`
+	RCPUMax        float64 `json:"rcpu_max"`
+}
+
+// percentile expects sorted values and uses the nearest-rank method
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0.0
+	}
+
+	rank := int(p/100.0*float64(len(sorted))+0.5) - 1
+	rank = min(max(rank, 0), len(sorted)-1)
+
+	return sorted[rank]
+}
+
+func NewRollup(cluster, pool string, samples []*Sample) Rollup {
+	rollup := Rollup{Cluster: cluster, Pool: pool, Nodes: len(samples)}
+
+	rcpus := make([]float64, 0, len(samples))
+	for _, sample := range samples {
+		rollup.Cores += sample.Cores
+		rollup.RemainingCores += sample.RemainingCores()
+		rcpus = append(rcpus, sample.RCPU())
+	}
+	sort.Float64s(rcpus)
+
+	rollup.RCPUMin = percentile(rcpus, 0)
+	rollup.RCPUP10 = percentile(rcpus, 10)
+	rollup.RCPUP50 = percentile(rcpus, 50)
+	rollup.RCPUP90 = percentile(rcpus, 90)
+	rollup.RCPUMax = percentile(rcpus, 100)
+
+	return rollup
+}
+
+// Peers maps the bearer token of a peer to the cluster it reports for.
+type Peers map[string]string
+
+// LoadPeers reads "cluster token" lines, ignoring blank lines and comments.
+func LoadPeers(path string) (Peers, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	defer f.Close()
+
+	peers := make(Peers)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		items := strings.Fields(line)
+		if len(items) != 2 {
+			return nil, fmt.Errorf("malformed peer %q in %s, expected \"cluster token\"", line, path)
+		}
+
+		peers[items[1]] = items[0]
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	return peers, nil
+}
+
+// Authenticate returns the cluster of the peer presenting the bearer token.
+func (p Peers) Authenticate(r *http.Request) (string, bool) {
+	return p.AuthenticateHeader(r.Header.Get("Authorization"))
+}
+
+// AuthenticateHeader returns the cluster of the peer presenting the bearer
+// token in an Authorization header, of HTTP or of gRPC metadata.
+func (p Peers) AuthenticateHeader(header string) (string, bool) {
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return "", false
+	}
+
+	// Compare against every token to not leak which prefix matched
+	cluster, found := "", false
+	for peerToken, peerCluster := range p {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(peerToken)) == 1 {
+			cluster, found = peerCluster, true
+		}
+	}
+
+	return cluster, found
+}
+
+// receivedSample keeps when the aggregator received the sample, the agent's
+// clock decides the order of a node's samples but not whether it is stale.
+type receivedSample struct {
+	sample   *Sample
+	received time.Time
+}
+
+// Aggregator keeps the latest sample of every node and rolls them up per
+// cluster and pool. Without peers it serves a single cluster, with peers
+// every cluster authenticates with its own token and the rollups form a
+// federated view across clusters.
+type Aggregator struct {
+	mu          sync.Mutex
+	samples     map[string]*receivedSample
+	staleAfter  time.Duration
+	forgetAfter time.Duration
+	maxSkew     time.Duration
+
+	cluster string
+	peers   Peers
+}
+
+func NewAggregator(staleAfter time.Duration) *Aggregator {
+	return &Aggregator{
+		samples:     make(map[string]*receivedSample),
+		staleAfter:  staleAfter,
+		forgetAfter: staleAfter,
+		maxSkew:     DefaultAggregateMaxSkew,
+	}
+}
+
+// SetForgetAfter keeps the nodes which went stale as unknown until they
+// haven't reported for this long, rather than forgetting them right away.
+func (a *Aggregator) SetForgetAfter(forgetAfter time.Duration) {
+	a.forgetAfter = max(forgetAfter, a.staleAfter)
+}
+
+// SetMaxSkew rejects samples timestamped further than this ahead of the
+// aggregator's clock, which would otherwise hide the node's next samples.
+func (a *Aggregator) SetMaxSkew(maxSkew time.Duration) {
+	a.maxSkew = maxSkew
+}
+
+// SetCluster sets the cluster name of unauthenticated samples.
+func (a *Aggregator) SetCluster(cluster string) {
+	a.cluster = cluster
+}
+
+// SetPeers requires every sample to come from one of the peers.
+func (a *Aggregator) SetPeers(peers Peers) {
+	a.peers = peers
+}
+
+func sampleKey(sample *Sample) string {
+	return sample.Cluster + "/" + sample.Node
+}
+
+// Add keeps the samples received at now, either all of them or none if one
+// is invalid.
+func (a *Aggregator) Add(samples []*Sample, now time.Time) error {
+	for i, sample := range samples {
+		if err := sample.Validate(); err != nil {
+			return fmt.Errorf("sample %d: %v", i, err)
+		}
+
+		if skew := sample.Time.Sub(now); skew > a.maxSkew {
+			return fmt.Errorf("sample %d: time %v is %v ahead of the aggregator", i, sample.Time, skew.Round(time.Second))
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, sample := range samples {
+		if sample.Pool == "" {
+			sample.Pool = DefaultPool
+		}
+
+		// Drop samples arriving out of order
+		key := sampleKey(sample)
+		if prev, ok := a.samples[key]; ok && sample.Time.Before(prev.sample.Time) {
+			continue
+		}
+		a.samples[key] = &receivedSample{sample: sample, received: now}
+	}
+
+	return nil
+}
+
+// partitionSamples returns the samples which are not stale, and those of the
+// nodes which are but aren't forgotten yet, removing the forgotten ones
+func (a *Aggregator) partitionSamples(now time.Time) (fresh, unknown []*Sample) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	fresh = make([]*Sample, 0, len(a.samples))
+	for key, rs := range a.samples {
+		switch age := now.Sub(rs.received); {
+		case age > a.forgetAfter:
+			delete(a.samples, key)
+		case age > a.staleAfter:
+			unknown = append(unknown, rs.sample)
+		default:
+			fresh = append(fresh, rs.sample)
+		}
+	}
+
+	return fresh, unknown
+}
+
+// freshSamples returns the samples which are not stale
+func (a *Aggregator) freshSamples(now time.Time) []*Sample {
+	fresh, _ := a.partitionSamples(now)
+	return fresh
+}
+
+// Rollups returns the federation rollup first, followed by one rollup per
+// cluster and one per pool of every cluster.
+func (a *Aggregator) Rollups(now time.Time) []Rollup {
+	samples, unknown := a.partitionSamples(now)
+
+	type groupKey struct {
+		cluster string
+		pool    string
+	}
+
+	group := func(groups map[groupKey][]*Sample, samples []*Sample) map[groupKey][]*Sample {
+		for _, sample := range samples {
+			// Without a cluster name, the federation rollup is the cluster
+			// rollup
+			if sample.Cluster != "" {
+				clusterKey := groupKey{cluster: sample.Cluster}
+				groups[clusterKey] = append(groups[clusterKey], sample)
+			}
+
+			poolKey := groupKey{cluster: sample.Cluster, pool: sample.Pool}
+			groups[poolKey] = append(groups[poolKey], sample)
+		}
+
+		return groups
+	}
+	groups := group(make(map[groupKey][]*Sample), samples)
+	unknownGroups := group(make(map[groupKey][]*Sample), unknown)
+
+	keys := make([]groupKey, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	// A pool whose nodes all went silent still has a rollup
+	for key := range unknownGroups {
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].cluster != keys[j].cluster {
+			return keys[i].cluster < keys[j].cluster
+		}
+
+		return keys[i].pool < keys[j].pool
+	})
+
+	rollups := []Rollup{NewRollup("", "", samples)}
+	rollups[0].UnknownNodes = len(unknown)
+	for _, key := range keys {
+		rollup := NewRollup(key.cluster, key.pool, groups[key])
+		rollup.UnknownNodes = len(unknownGroups[key])
+		rollups = append(rollups, rollup)
+	}
+
+	return rollups
+}
+
+// Samples returns the fresh samples ordered by cluster and node.
+func (a *Aggregator) Samples(now time.Time) []*Sample {
+	samples := a.freshSamples(now)
+	sort.Slice(samples, func(i, j int) bool {
+		return sampleKey(samples[i]) < sampleKey(samples[j])
+	})
+
+	return samples
+}
+
+// WriteSamples serves samples as a JSON array, which the annotator polls.
+func WriteSamples(w http.ResponseWriter, samples []*Sample) {
+	if samples == nil {
+		samples = []*Sample{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(samples); err != nil {
+		log.Printf("failed to encode samples: %v", err)
+	}
+}
+
+// decodeSamples reads an rcpu.v1.SampleBatch, which collectors push, or JSON
+// for curl and older collectors, either a single sample or a batch.
+func decodeSamples(contentType string, body []byte) ([]*Sample, error) {
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == ContentTypeProtobuf {
+		var pb rcpuv1.SampleBatch
+		if err := proto.Unmarshal(body, &pb); err != nil {
+			return nil, err
+		}
+
+		samples := make([]*Sample, 0, len(pb.GetSamples()))
+		for _, sample := range pb.GetSamples() {
+			samples = append(samples, SampleFromProto(sample))
+		}
+
+		return samples, nil
+	}
+
+	var samples []*Sample
+	if err := json.Unmarshal(body, &samples); err != nil {
+		var sample Sample
+		if err := json.Unmarshal(body, &sample); err != nil {
+			return nil, err
+		}
+		samples = []*Sample{&sample}
+	}
+
+	return samples, nil
+}
+
+func (a *Aggregator) handleSamples(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		WriteSamples(w, a.Samples(time.Now()))
+	case http.MethodPost:
+		a.handlePush(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *Aggregator) handleSamplesV1(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		WriteSampleList(w, a.Samples(time.Now()))
+	case http.MethodPost:
+		a.handlePush(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *Aggregator) handlePush(w http.ResponseWriter, r *http.Request) {
+	cluster := a.cluster
+	if a.peers != nil {
+		peerCluster, ok := a.peers.Authenticate(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		cluster = peerCluster
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	samples, err := decodeSamples(r.Header.Get("Content-Type"), body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("malformed sample: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := a.receive(cluster, samples); err != nil {
+		http.Error(w, fmt.Sprintf("invalid sample: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// receive adds the samples pushed by a collector of the authenticated
+// cluster.
+func (a *Aggregator) receive(cluster string, samples []*Sample) error {
+	for _, sample := range samples {
+		// Peers can only report for their own cluster
+		if a.peers != nil || sample.Cluster == "" {
+			sample.Cluster = cluster
+		}
+	}
+
+	return a.Add(samples, time.Now())
+}
+
+// sampleService receives the pushes of -upstream, authenticated like the
+// HTTP pushes with the bearer token in the metadata.
+type sampleService struct {
+	rcpuv1.UnimplementedSampleServiceServer
+
+	aggregator *Aggregator
+}
+
+func (s *sampleService) Push(ctx context.Context, batch *rcpuv1.SampleBatch) (*rcpuv1.PushResponse, error) {
+	a := s.aggregator
+
+	cluster := a.cluster
+	if a.peers != nil {
+		peerCluster, ok := a.peers.AuthenticateHeader(authorizationMetadata(ctx))
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "unauthorized")
+		}
+		cluster = peerCluster
+	}
+
+	samples := make([]*Sample, 0, len(batch.GetSamples()))
+	for _, pb := range batch.GetSamples() {
+		samples = append(samples, SampleFromProto(pb))
+	}
+
+	if err := a.receive(cluster, samples); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid sample: %v", err)
+	}
+
+	return &rcpuv1.PushResponse{}, nil
+}
+
+func (a *Aggregator) handleRollups(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, a.Rollups(time.Now()))
+}
+
+func (a *Aggregator) handleRollupsV1(w http.ResponseWriter, r *http.Request) {
+	rollups := a.Rollups(time.Now())
+
+	list := apiv1.RollupList{Rollups: make([]apiv1.Rollup, 0, len(rollups))}
+	for i := range rollups {
+		list.Rollups = append(list.Rollups, RollupToAPI(&rollups[i]))
+	}
+
+	writeJSON(w, list)
+}
+
+// rollupLabels leaves out the labels the rollup spans over
+func rollupLabels(r *Rollup) string {
+	var labels []string
+	if r.Cluster != "" {
+		labels = append(labels, label("cluster", r.Cluster))
+	}
+
+	if r.Pool != "" {
+		labels = append(labels, label("pool", r.Pool))
+	}
+
+	if len(labels) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+func (a *Aggregator) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	rollups := a.Rollups(time.Now())
+
+	gauges := []struct {
+		name  string
+		help  string
+		value func(*Rollup) float64
+	}{
+		{"rcpu_pool_nodes", "Number of nodes reporting RCPU.", func(r *Rollup) float64 { return float64(r.Nodes) }},
+		{"rcpu_pool_unknown_nodes", "Number of nodes which stopped reporting RCPU, their collector is presumably dead.", func(r *Rollup) float64 { return float64(r.UnknownNodes) }},
+		{"rcpu_pool_cores", "Number of physical cores.", func(r *Rollup) float64 { return float64(r.Cores) }},
+		{"rcpu_pool_remaining_cores", "Remaining physical cores following RCPU.", func(r *Rollup) float64 { return r.RemainingCores }},
+		{"rcpu_pool_rcpu_min_percent", "Lowest node RCPU.", func(r *Rollup) float64 { return r.RCPUMin }},
+		{"rcpu_pool_rcpu_p10_percent", "10th percentile of node RCPU.", func(r *Rollup) float64 { return r.RCPUP10 }},
+		{"rcpu_pool_rcpu_p50_percent", "Median node RCPU.", func(r *Rollup) float64 { return r.RCPUP50 }},
+		{"rcpu_pool_rcpu_p90_percent", "90th percentile of node RCPU.", func(r *Rollup) float64 { return r.RCPUP90 }},
+		{"rcpu_pool_rcpu_max_percent", "Highest node RCPU.", func(r *Rollup) float64 { return r.RCPUMax }},
+	}
+
+	for _, gauge := range gauges {
+		fmt.Fprintf(w, "# HELP %s %s\n", gauge.name, gauge.help)
+		fmt.Fprintf(w, "# TYPE %s gauge\n", gauge.name)
+		for i := range rollups {
+			fmt.Fprintf(w, "%s%s %g\n", gauge.name, rollupLabels(&rollups[i]), gauge.value(&rollups[i]))
+		}
+	}
+}
+
+func (a *Aggregator) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(SamplesPath, a.handleSamples)
+	mux.HandleFunc(RollupsPath, a.handleRollups)
+	mux.HandleFunc(apiv1.SamplesPath, a.handleSamplesV1)
+	mux.HandleFunc(apiv1.RollupsPath, a.handleRollupsV1)
+	mux.HandleFunc("/metrics", a.handleMetrics)
+	return mux
+}
+
+// NewAggregatorGRPCServer serves the pushes of -upstream, the health of the
+// aggregator for load balancers and Kubernetes gRPC probes, and reflection
+// for tools like grpcurl.
+func NewAggregatorGRPCServer(aggregator *Aggregator, security ServerSecurity, limiter *RequestLimiter) (*grpc.Server, error) {
+	// Peers push with their own tokens, and probes have none
+	securityOpts, err := security.GRPCServerOptions(func(method string) bool {
+		return (aggregator.peers != nil && method == rcpuv1.SampleService_Push_FullMethodName) || strings.HasPrefix(method, "/"+healthpb.Health_ServiceDesc.ServiceName+"/")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Limited before the authentication, which floods would cost too
+	opts := append(limiter.GRPCServerOptions(), securityOpts...)
+	server := grpc.NewServer(opts...)
+	rcpuv1.RegisterSampleServiceServer(server, &sampleService{aggregator: aggregator})
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(rcpuv1.SampleService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, healthServer)
+
+	reflection.Register(server)
+
+	return server, nil
+}
+
+func RunAggregate(args []string) error {
+	fs := flag.NewFlagSet("aggregate", flag.ExitOnError)
+	listenAddr := fs.String("listen", DefaultAggregateListenAddr, "address to serve the aggregator on")
+	staleAfter := fs.Duration("stale-after", DefaultAggregateStaleAfter, "count nodes which haven't reported for this long as unknown")
+	forgetAfter := fs.Duration("forget-after", DefaultAggregateForgetAfter, "drop unknown nodes which haven't reported for this long")
+	cluster := fs.String("cluster", "", "cluster name of samples pushed without a peer token")
+	peersFile := fs.String("peers", "", "file of \"cluster token\" lines, enables federation and requires peers to authenticate")
+	maxSkew := fs.Duration("max-skew", DefaultAggregateMaxSkew, "reject samples timestamped further than this ahead of the aggregator's clock")
+	grpcListenAddr := fs.String("grpc-listen", "", "also receive the samples of collectors pushing with -upstream over gRPC on this address, e.g. :9465")
+	securityFlags := AddServerSecurityFlags(fs, "", "-listen and -grpc-listen")
+	limitFlags := AddRequestLimitFlags(fs, "", "-listen and -grpc-listen", 0, DefaultMetricsRateBurst, 0)
+	fs.Parse(args)
+
+	security, err := securityFlags.Load()
+	if err != nil {
+		return err
+	}
+
+	limiter, err := limitFlags.Limiter()
+	if err != nil {
+		return err
+	}
+
+	if *forgetAfter < *staleAfter {
+		return fmt.Errorf("-forget-after %v is shorter than -stale-after %v", *forgetAfter, *staleAfter)
+	}
+
+	aggregator := NewAggregator(*staleAfter)
+	aggregator.SetForgetAfter(*forgetAfter)
+	aggregator.SetCluster(*cluster)
+	aggregator.SetMaxSkew(*maxSkew)
+
+	if *peersFile != "" {
+		peers, err := LoadPeers(*peersFile)
+		if err != nil {
+			return err
+		}
+		aggregator.SetPeers(peers)
+
+		log.Printf("Federating %d peers\n", len(peers))
+	}
+
+	// Either server failing stops the other
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var grpcServer *grpc.Server
+	grpcErr := make(chan error, 1)
+	if *grpcListenAddr != "" {
+		lis, err := net.Listen("tcp", *grpcListenAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %v", *grpcListenAddr, err)
+		}
+
+		grpcServer, err = NewAggregatorGRPCServer(aggregator, security, limiter)
+		if err != nil {
+			lis.Close()
+			return err
+		}
+		go func() {
+			defer stop()
+
+			if err := grpcServer.Serve(lis); err != nil {
+				grpcErr <- fmt.Errorf("failed to serve gRPC: %v", err)
+			}
+			close(grpcErr)
+		}()
+
+		log.Printf("Aggregator is receiving gRPC pushes on %s\n", *grpcListenAddr)
+	}
+
+	log.Printf("Aggregator is listening on %s\n", *listenAddr)
+
+	err = security.ListenAndServe(ctx, *listenAddr, limiter.Handler(security.Handler(aggregator.Handler(), func(r *http.Request) bool {
+		return aggregator.peers != nil && r.Method == http.MethodPost && (r.URL.Path == SamplesPath || r.URL.Path == apiv1.SamplesPath)
+	})))
+
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
+		err = errors.Join(err, <-grpcErr)
+	}
+
+	return err
+}
@@ -0,0 +1,72 @@
+package rcpu
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// TestAggregatorUnknownNodes checks a node which stops reporting is counted
+// as unknown, not as idle, until it is forgotten.
+func TestAggregatorUnknownNodes(t *testing.T) {
+	aggregator := NewAggregator(30 * time.Second)
+	aggregator.SetForgetAfter(time.Minute)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sample := func(node, pool string, at time.Time) *Sample {
+		return &Sample{Node: node, Pool: pool, Time: at, CPUs: 4, Cores: 2, AvgCPUUsage: 10, AdjustedCPUUsage: 20}
+	}
+
+	if err := aggregator.Add([]*Sample{sample("node-1", "a", start), sample("node-2", "b", start)}, start); err != nil {
+		t.Fatal(err)
+	}
+	later := start.Add(45 * time.Second)
+	if err := aggregator.Add([]*Sample{sample("node-1", "a", later)}, later); err != nil {
+		t.Fatal(err)
+	}
+
+	rollups := aggregator.Rollups(later)
+	if len(rollups) != 3 {
+		t.Fatalf("expected the total and the rollups of pools a and b, got %+v", rollups)
+	}
+	if total := rollups[0]; total.Nodes != 1 || total.UnknownNodes != 1 {
+		t.Errorf("expected one node reporting and one unknown, got %+v", total)
+	}
+	if pool := rollups[2]; pool.Pool != "b" || pool.Nodes != 0 || pool.UnknownNodes != 1 {
+		t.Errorf("expected pool b to only have an unknown node, got %+v", pool)
+	}
+	if samples := aggregator.Samples(later); len(samples) != 1 || samples[0].Node != "node-1" {
+		t.Errorf("expected only the sample of node-1, got %+v", samples)
+	}
+
+	forgotten := start.Add(90 * time.Second)
+	if err := aggregator.Add([]*Sample{sample("node-1", "a", forgotten)}, forgotten); err != nil {
+		t.Fatal(err)
+	}
+	if rollups := aggregator.Rollups(forgotten); len(rollups) != 2 || rollups[0].UnknownNodes != 0 {
+		t.Errorf("expected node-2 to be forgotten, got %+v", rollups)
+	}
+}
+
+func TestSampleValidate(t *testing.T) {
+	tests := []struct {
+		avg, adjusted float64
+		valid         bool
+	}{
+		{10, 20, true},
+		{0, 100, true},
+		{-1, 20, false},
+		{10, 100.5, false},
+		{math.NaN(), 20, false},
+		{10, math.NaN(), false},
+		{math.Inf(1), 20, false},
+		{10, math.Inf(-1), false},
+	}
+
+	for _, test := range tests {
+		sample := Sample{Node: "node-1", CPUs: 4, Cores: 2, AvgCPUUsage: test.avg, AdjustedCPUUsage: test.adjusted}
+		if err := sample.Validate(); (err == nil) != test.valid {
+			t.Errorf("avg %v, adjusted %v: expected valid %v, got %v", test.avg, test.adjusted, test.valid, err)
+		}
+	}
+}
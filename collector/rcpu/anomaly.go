@@ -0,0 +1,91 @@
+package rcpu
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// DefaultAnomalyZ is how many standard deviations from the recent mean an
+	// adjusted usage must be to be anomalous
+	DefaultAnomalyZ = 4.0
+	// DefaultAnomalyMinDelta keeps flat nodes, whose deviation is close to
+	// zero, from flagging every small change, in percentage points
+	DefaultAnomalyMinDelta = 10.0
+
+	// anomalyAlpha is the weight of a new sample in the moving mean and
+	// variance, about the last 30 samples
+	anomalyAlpha = 1.0 / 16
+	// anomalyWarmup is the number of samples learned before flagging any
+	anomalyWarmup = 20
+)
+
+// AnomalyState is the detector's verdict on the latest sample.
+type AnomalyState struct {
+	// Z is the distance of the adjusted usage from the recent mean in
+	// standard deviations, zero while warming up
+	Z float64
+	// Mean is the recent mean adjusted usage the sample was compared with
+	Mean    float64
+	Anomaly bool
+	// Events counts the anomalies so far, a run of anomalous samples is one
+	Events uint64
+	// Since and SinceUsage are the time and adjusted usage of the first
+	// sample of the latest anomaly
+	Since      time.Time
+	SinceUsage float64
+}
+
+// AnomalyDetector flags samples whose adjusted usage deviates sharply from
+// the moving mean and variance of the recent samples, like a runaway process
+// taking the capacity of idle siblings. Anomalous samples move the mean but
+// not the variance, so a lasting change stays flagged until the mean catches
+// up with it and then becomes the new normal.
+type AnomalyDetector struct {
+	Z        float64
+	MinDelta float64
+
+	mean     float64
+	variance float64
+	samples  int
+	state    AnomalyState
+}
+
+func NewAnomalyDetector(z float64) *AnomalyDetector {
+	return &AnomalyDetector{Z: z, MinDelta: DefaultAnomalyMinDelta}
+}
+
+// Observe adds the adjusted usage of a sample taken at now and returns the
+// verdict on it. Started reports whether it begins a new anomaly.
+func (d *AnomalyDetector) Observe(now time.Time, adjustedUsage float64) (state AnomalyState, started bool) {
+	wasAnomaly := d.state.Anomaly
+
+	d.state.Z, d.state.Mean, d.state.Anomaly = 0, d.mean, false
+	if d.samples >= anomalyWarmup {
+		delta := adjustedUsage - d.mean
+		if stdDev := math.Sqrt(d.variance); stdDev > 0 {
+			d.state.Z = delta / stdDev
+		}
+
+		d.state.Anomaly = math.Abs(delta) >= d.MinDelta && (d.variance == 0 || math.Abs(d.state.Z) >= d.Z)
+	}
+
+	started = d.state.Anomaly && !wasAnomaly
+	if started {
+		d.state.Events++
+		d.state.Since, d.state.SinceUsage = now, adjustedUsage
+	}
+
+	if d.samples == 0 {
+		d.mean = adjustedUsage
+	} else {
+		delta := adjustedUsage - d.mean
+		d.mean += anomalyAlpha * delta
+		if !d.state.Anomaly {
+			d.variance = (1 - anomalyAlpha) * (d.variance + anomalyAlpha*delta*delta)
+		}
+	}
+	d.samples++
+
+	return d.state, started
+}
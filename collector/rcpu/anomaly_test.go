@@ -0,0 +1,96 @@
+package rcpu
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// warmDetector returns a detector past its warmup, at mean 50 with the given
+// variance.
+func warmDetector(variance float64) *AnomalyDetector {
+	d := NewAnomalyDetector(DefaultAnomalyZ)
+	d.mean, d.variance, d.samples = 50, variance, anomalyWarmup
+
+	return d
+}
+
+func TestAnomalyDetectorZ(t *testing.T) {
+	tests := []struct {
+		name     string
+		detector *AnomalyDetector
+		usage    float64
+		z        float64
+		anomaly  bool
+	}{
+		// A standard deviation of 4, 16 / 4 and -16 / 4
+		{"above", warmDetector(16), 66, 4, true},
+		{"below", warmDetector(16), 34, -4, true},
+		{"within z", warmDetector(16), 62, 3, false},
+		// 4.5 standard deviations of 2, but less than the minimum delta
+		{"within delta", warmDetector(4), 59, 4.5, false},
+		// A flat node flags any change of the minimum delta
+		{"flat", warmDetector(0), 60, 0, true},
+		{"flat within delta", warmDetector(0), 55, 0, false},
+		{"warming up", NewAnomalyDetector(DefaultAnomalyZ), 90, 0, false},
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range tests {
+		state, started := test.detector.Observe(start, test.usage)
+		if math.Abs(state.Z-test.z) > 1e-9 {
+			t.Errorf("%s: expected z %.4f, got %.4f", test.name, test.z, state.Z)
+		}
+		if state.Anomaly != test.anomaly || started != test.anomaly {
+			t.Errorf("%s: expected anomaly %v, got %v, started %v", test.name, test.anomaly, state.Anomaly, started)
+		}
+	}
+}
+
+func TestAnomalyDetectorRuns(t *testing.T) {
+	d := warmDetector(16)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		usage   float64
+		z       float64
+		mean    float64
+		anomaly bool
+		started bool
+		events  uint64
+		since   int
+	}{
+		// 20 / 4, the mean moves by 20/16 but the variance stays
+		{70, 5, 50, true, true, 1, 0},
+		// 18.75 / 4, still the first anomaly
+		{70, 4.6875, 51.25, true, false, 1, 0},
+		// -2.421875 / 4 ends it
+		{50, -0.60546875, 52.421875, false, false, 1, 0},
+		// The normal sample moved the mean by -2.421875/16 and learned the
+		// variance 15/16 * (16 + 2.421875²/16), 27.7294921875 / sqrt(15.34368)
+		{80, 7.07909, 52.2705078125, true, true, 2, 3},
+	}
+
+	for i, test := range tests {
+		now := start.Add(time.Duration(i) * time.Second)
+		state, started := d.Observe(now, test.usage)
+
+		if math.Abs(state.Z-test.z) > 1e-5 {
+			t.Errorf("sample %d: expected z %.4f, got %.4f", i, test.z, state.Z)
+		}
+		if math.Abs(state.Mean-test.mean) > 1e-9 {
+			t.Errorf("sample %d: expected the mean at %.4f, got %.4f", i, test.mean, state.Mean)
+		}
+		if state.Anomaly != test.anomaly || started != test.started {
+			t.Errorf("sample %d: expected anomaly %v and started %v, got %v and %v", i, test.anomaly, test.started, state.Anomaly, started)
+		}
+		if state.Events != test.events {
+			t.Errorf("sample %d: expected %d events, got %d", i, test.events, state.Events)
+		}
+
+		since := start.Add(time.Duration(test.since) * time.Second)
+		if !state.Since.Equal(since) || state.SinceUsage != tests[test.since].usage {
+			t.Errorf("sample %d: expected the anomaly since %v at %.2f%%, got %v at %.2f%%", i, since, tests[test.since].usage, state.Since, state.SinceUsage)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package rcpu
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	apiv1 "solelab.tech/collector/api/v1"
+)
+
+// The conversions between the collector's types and the /v1 HTTP API of
+// api/v1. The unversioned /samples and /rollups predate it and keep serving
+// bare arrays for the clients written against them.
+
+func SampleToAPI(s *Sample) apiv1.Sample {
+	sample := apiv1.Sample{
+		Cluster:          s.Cluster,
+		Node:             s.Node,
+		Pool:             s.Pool,
+		Time:             s.Time,
+		Interval:         int64(s.Interval),
+		CPUs:             s.CPUs,
+		Cores:            s.Cores,
+		FreeCores:        s.FreeCores,
+		AvgCPUUsage:      s.AvgCPUUsage,
+		AdjustedCPUUsage: s.AdjustedCPUUsage,
+		Load1:            s.Load1,
+		Load5:            s.Load5,
+		Load15:           s.Load15,
+		Label:            s.Label,
+		IRQCPUs:          s.IRQCPUs,
+		Steal:            s.Steal,
+		Derating:         s.Derating,
+		BusyMHz:          s.BusyMHz,
+		SMTInterference:  s.SMTInterference,
+	}
+
+	for _, g := range s.Sockets {
+		sample.Sockets = append(sample.Sockets, apiv1.GroupUsage{ID: g.Id, AdjustedCPUUsage: g.AdjustedCPUUsage, Cores: g.Cores})
+	}
+
+	for _, g := range s.Nodes {
+		sample.Nodes = append(sample.Nodes, apiv1.GroupUsage{ID: g.Id, AdjustedCPUUsage: g.AdjustedCPUUsage, Cores: g.Cores})
+	}
+
+	for _, o := range s.LLCOccupancy {
+		sample.LLCOccupancy = append(sample.LLCOccupancy, apiv1.LLCOccupancy{Group: o.Group, Domain: o.Domain, Bytes: o.Bytes})
+	}
+
+	if s.Window != nil {
+		sample.Window = &apiv1.Window{Samples: s.Window.Samples, Mean: s.Window.Mean, StdDev: s.Window.StdDev, Low: s.Window.Low, High: s.Window.High}
+	}
+
+	for _, p := range s.Pods {
+		sample.Pods = append(sample.Pods, apiv1.Pod{Namespace: p.Namespace, Name: p.Name, BusyCores: p.BusyCores, AdjustedCores: p.AdjustedCores})
+	}
+
+	for _, c := range s.Containers {
+		sample.Containers = append(sample.Containers, apiv1.Container{
+			ID:            c.ID,
+			Namespace:     c.Namespace,
+			Pod:           c.Pod,
+			Container:     c.Container,
+			BusyCores:     c.BusyCores,
+			AdjustedCores: c.AdjustedCores,
+		})
+	}
+
+	return sample
+}
+
+func RollupToAPI(r *Rollup) apiv1.Rollup {
+	return apiv1.Rollup{
+		Cluster:        r.Cluster,
+		Pool:           r.Pool,
+		Nodes:          r.Nodes,
+		UnknownNodes:   r.UnknownNodes,
+		Cores:          r.Cores,
+		RemainingCores: r.RemainingCores,
+		RCPUMin:        r.RCPUMin,
+		RCPUP10:        r.RCPUP10,
+		RCPUP50:        r.RCPUP50,
+		RCPUP90:        r.RCPUP90,
+		RCPUMax:        r.RCPUMax,
+	}
+}
+
+func MarkToAPI(m *Mark) apiv1.Mark {
+	return apiv1.Mark{Label: m.Label, Start: m.Start, End: m.End}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("failed to encode response: %v", err)
+	}
+}
+
+// WriteSampleList writes the body of GET /v1/samples.
+func WriteSampleList(w http.ResponseWriter, samples []*Sample) {
+	list := apiv1.SampleList{Samples: make([]apiv1.Sample, 0, len(samples))}
+	for _, sample := range samples {
+		list.Samples = append(list.Samples, SampleToAPI(sample))
+	}
+
+	writeJSON(w, list)
+}
@@ -0,0 +1,122 @@
+package rcpu
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	apiv1 "solelab.tech/collector/api/v1"
+)
+
+// apiSample sets every field, so a field missing from the conversion or
+// renamed in the schema shows up in the JSON
+func apiSample() *Sample {
+	interference := 0.12
+	freeCores := 1
+
+	return &Sample{
+		Cluster:          "cluster-1",
+		Node:             "node-1",
+		Pool:             "pool-1",
+		Time:             time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Interval:         time.Second,
+		CPUs:             4,
+		Cores:            2,
+		FreeCores:        &freeCores,
+		AvgCPUUsage:      40,
+		AdjustedCPUUsage: 60,
+		Load1:            1,
+		Load5:            2,
+		Load15:           3,
+		Label:            "bench",
+		Sockets:          []GroupUsage{{Id: 0, AdjustedCPUUsage: 60}},
+		Nodes:            []GroupUsage{{Id: 0, AdjustedCPUUsage: 60}},
+		IRQCPUs:          []int32{3},
+		Steal:            1.5,
+		Derating:         0.9,
+		BusyMHz:          2400,
+		LLCOccupancy:     []LLCOccupancy{{Group: "/", Domain: "0", Bytes: 1024}},
+		SMTInterference:  &interference,
+		Window:           &WindowStats{Samples: 10, Mean: 40, StdDev: 2, Low: 38, High: 42},
+		Pods:             []PodAttribution{{Namespace: "default", Name: "web", BusyCores: 1, AdjustedCores: 0.8}},
+		Containers:       []ContainerCPU{{ID: "abc", Namespace: "default", Pod: "web", Container: "nginx", BusyCores: 1, AdjustedCores: 0.8}},
+	}
+}
+
+// TestAPIV1Schema pins the JSON of /v1, which may only gain fields.
+func TestAPIV1Schema(t *testing.T) {
+	const want = `{"cluster":"cluster-1","node":"node-1","pool":"pool-1","time":"2024-01-01T00:00:00Z","interval":1000000000,"cpus":4,"cores":2,` +
+		`"avg_cpu_usage":40,"adjusted_cpu_usage":60,"load1":1,"load5":2,"load15":3,"free_cores":1,"label":"bench",` +
+		`"sockets":[{"id":0,"adjusted_cpu_usage":60}],"nodes":[{"id":0,"adjusted_cpu_usage":60}],"irq_cpus":[3],` +
+		`"steal":1.5,"derating":0.9,"busy_mhz":2400,"llc_occupancy":[{"group":"/","domain":"0","bytes":1024}],` +
+		`"smt_interference":0.12,"rcpu_window":{"samples":10,"mean":40,"stddev":2,"low":38,"high":42},` +
+		`"pods":[{"namespace":"default","name":"web","busy_cores":1,"adjusted_cores":0.8}],` +
+		`"containers":[{"id":"abc","namespace":"default","pod":"web","container":"nginx","busy_cores":1,"adjusted_cores":0.8}]}`
+
+	got, err := json.Marshal(SampleToAPI(apiSample()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("the v1 sample changed:\n got %s\nwant %s", got, want)
+	}
+
+	// The objects of /v1/samples are those of the unversioned /samples
+	legacy, err := json.Marshal(apiSample())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(legacy, got) {
+		t.Errorf("the v1 sample differs from the unversioned one:\n   v1 %s\nlegacy %s", got, legacy)
+	}
+}
+
+func TestAggregatorAPIV1(t *testing.T) {
+	aggregator := NewAggregator(time.Hour)
+	handler := aggregator.Handler()
+
+	sample := apiSample()
+	sample.Cluster = ""
+	sample.Time = time.Now()
+	body, err := json.Marshal(sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, apiv1.SamplesPath, bytes.NewReader(body)))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected the push to /v1/samples to succeed, got %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, apiv1.SamplesPath, nil))
+	var samples apiv1.SampleList
+	if err := json.NewDecoder(rec.Body).Decode(&samples); err != nil {
+		t.Fatal(err)
+	}
+	if len(samples.Samples) != 1 || samples.Samples[0].Node != "node-1" {
+		t.Errorf("expected the sample of node-1, got %+v", samples)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, apiv1.RollupsPath, nil))
+	var rollups apiv1.RollupList
+	if err := json.NewDecoder(rec.Body).Decode(&rollups); err != nil {
+		t.Fatal(err)
+	}
+	if len(rollups.Rollups) == 0 || rollups.Rollups[0].Nodes != 1 {
+		t.Errorf("expected a rollup of one node, got %+v", rollups)
+	}
+
+	// The unversioned path still serves a bare array
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, SamplesPath, nil))
+	var legacy []apiv1.Sample
+	if err := json.NewDecoder(rec.Body).Decode(&legacy); err != nil || len(legacy) != 1 {
+		t.Errorf("expected the unversioned samples to be an array of one, got %v %v", legacy, err)
+	}
+}
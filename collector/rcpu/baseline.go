@@ -0,0 +1,290 @@
+package rcpu
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// BaselineStat summarizes one usage over the samples of a baseline, in
+// percent.
+type BaselineStat struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P95  float64 `json:"p95"`
+	Max  float64 `json:"max"`
+}
+
+func NewBaselineStat(values []float64) BaselineStat {
+	if len(values) == 0 {
+		return BaselineStat{}
+	}
+
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+
+	return BaselineStat{
+		Mean: sum / float64(len(sorted)),
+		P50:  percentile(sorted, 50),
+		P95:  percentile(sorted, 95),
+		Max:  percentile(sorted, 100),
+	}
+}
+
+// Baseline is the RCPU profile of a node over a time range, saved to compare
+// later runs against, e.g. before and after moving a workload.
+type Baseline struct {
+	From     time.Time    `json:"from"`
+	To       time.Time    `json:"to"`
+	Samples  int          `json:"samples"`
+	Avg      BaselineStat `json:"avg"`
+	Adjusted BaselineStat `json:"adjusted"`
+	// Overlap is the share of core time both siblings were busy, twice the
+	// average minus the adjusted usage on SMT2
+	Overlap BaselineStat `json:"overlap"`
+}
+
+// SiblingOverlap is the part of the physical cores' capacity where both
+// hardware threads were busy at once. Each core contributes what its two
+// threads were busy in total minus what the core was busy, clamped at zero
+// since ticks don't line up exactly.
+func SiblingOverlap(avgCPUUsage, adjustedCPUUsage float64) float64 {
+	return max(0, 2*avgCPUUsage-adjustedCPUUsage)
+}
+
+// baselineRecord is the part of a JSON output line a baseline needs.
+type baselineRecord struct {
+	Time     interface{} `json:"time"`
+	Avg      *float64    `json:"avg"`
+	Adjusted *float64    `json:"adjusted"`
+}
+
+func parseRecordTime(v interface{}) (time.Time, error) {
+	switch v := v.(type) {
+	case string:
+		return time.Parse(time.RFC3339, v)
+	case float64:
+		return time.Unix(int64(v), 0), nil
+	default:
+		return time.Time{}, fmt.Errorf("missing time")
+	}
+}
+
+// NewBaseline summarizes the JSON lines written with -output json between
+// from and to, either of which may be zero to leave the range open. The
+// lines need the time, avg and adjusted fields, with rfc3339 or unix time.
+func NewBaseline(r io.Reader, from, to time.Time) (*Baseline, error) {
+	baseline := &Baseline{}
+	var avgs, adjusteds, overlaps []float64
+
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		var record baselineRecord
+		if err := json.Unmarshal(s.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		if record.Avg == nil || record.Adjusted == nil {
+			return nil, fmt.Errorf("line %d: missing avg or adjusted, record with -fields time,avg,adjusted", line)
+		}
+
+		t, err := parseRecordTime(record.Time)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid time %v, record with -time-format rfc3339 or unix", line, record.Time)
+		}
+
+		if (!from.IsZero() && t.Before(from)) || (!to.IsZero() && t.After(to)) {
+			continue
+		}
+
+		if baseline.Samples == 0 || t.Before(baseline.From) {
+			baseline.From = t
+		}
+		if t.After(baseline.To) {
+			baseline.To = t
+		}
+		baseline.Samples++
+
+		avgs = append(avgs, *record.Avg)
+		adjusteds = append(adjusteds, *record.Adjusted)
+		overlaps = append(overlaps, SiblingOverlap(*record.Avg, *record.Adjusted))
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	if baseline.Samples == 0 {
+		return nil, fmt.Errorf("no samples in the time range")
+	}
+
+	baseline.Avg = NewBaselineStat(avgs)
+	baseline.Adjusted = NewBaselineStat(adjusteds)
+	baseline.Overlap = NewBaselineStat(overlaps)
+
+	return baseline, nil
+}
+
+func LoadBaseline(path string) (*Baseline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	var baseline Baseline
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	return &baseline, nil
+}
+
+// WriteBaselineDiff reports every statistic of the baseline next to the run
+// and how much it moved, in percentage points.
+func WriteBaselineDiff(w io.Writer, baseline, run *Baseline) {
+	timeFormat := TimeFormat{Name: TimeFormatRFC3339}
+	fmt.Fprintf(w, "baseline %s to %s, %d samples\n", timeFormat.Format(baseline.From), timeFormat.Format(baseline.To), baseline.Samples)
+	fmt.Fprintf(w, "run      %s to %s, %d samples\n", timeFormat.Format(run.From), timeFormat.Format(run.To), run.Samples)
+	fmt.Fprintf(w, "%-8s  %-4s  %9s  %9s  %7s\n", "usage", "stat", "baseline", "run", "delta")
+
+	usages := []struct {
+		name          string
+		baseline, run BaselineStat
+	}{
+		{"avg", baseline.Avg, run.Avg},
+		{"adjusted", baseline.Adjusted, run.Adjusted},
+		{"overlap", baseline.Overlap, run.Overlap},
+	}
+
+	for _, usage := range usages {
+		stats := []struct {
+			name          string
+			baseline, run float64
+		}{
+			{"mean", usage.baseline.Mean, usage.run.Mean},
+			{"p50", usage.baseline.P50, usage.run.P50},
+			{"p95", usage.baseline.P95, usage.run.P95},
+			{"max", usage.baseline.Max, usage.run.Max},
+		}
+
+		for _, stat := range stats {
+			fmt.Fprintf(w, "%-8s  %-4s  %8.2f%%  %8.2f%%  %+7.2f\n", usage.name, stat.name, stat.baseline, stat.run, stat.run-stat.baseline)
+		}
+	}
+}
+
+// baselineFlags are the flags selecting the recording of a run.
+type baselineFlags struct {
+	input string
+	from  string
+	to    string
+}
+
+func (f *baselineFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.input, "input", "-", "JSON lines written with -output json, - for stdin")
+	fs.StringVar(&f.from, "from", "", "only samples at or after this RFC 3339 time")
+	fs.StringVar(&f.to, "to", "", "only samples at or before this RFC 3339 time")
+}
+
+func (f *baselineFlags) baseline() (*Baseline, error) {
+	var from, to time.Time
+	var err error
+	if f.from != "" {
+		if from, err = time.Parse(time.RFC3339, f.from); err != nil {
+			return nil, fmt.Errorf("invalid -from: %v", err)
+		}
+	}
+	if f.to != "" {
+		if to, err = time.Parse(time.RFC3339, f.to); err != nil {
+			return nil, fmt.Errorf("invalid -to: %v", err)
+		}
+	}
+
+	r := io.Reader(os.Stdin)
+	if f.input != "-" {
+		file, err := os.Open(f.input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %v", f.input, err)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	baseline, err := NewBaseline(r, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", f.input, err)
+	}
+
+	return baseline, nil
+}
+
+// RunBaseline saves the profile of a recorded run with "save" and compares a
+// later run against it with "diff".
+func RunBaseline(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected save or diff")
+	}
+
+	var flags baselineFlags
+	switch args[0] {
+	case "save":
+		fs := flag.NewFlagSet("baseline save", flag.ExitOnError)
+		flags.register(fs)
+		output := fs.String("o", "-", "file to save the baseline to, - for stdout")
+		fs.Parse(args[1:])
+
+		baseline, err := flags.baseline()
+		if err != nil {
+			return err
+		}
+
+		data, err := json.MarshalIndent(baseline, "", "  ")
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+
+		if *output == "-" {
+			_, err = os.Stdout.Write(data)
+			return err
+		}
+
+		return os.WriteFile(*output, data, 0o644)
+	case "diff":
+		fs := flag.NewFlagSet("baseline diff", flag.ExitOnError)
+		flags.register(fs)
+		baselinePath := fs.String("baseline", "", "baseline saved with baseline save")
+		fs.Parse(args[1:])
+
+		if *baselinePath == "" {
+			return fmt.Errorf("missing -baseline")
+		}
+
+		baseline, err := LoadBaseline(*baselinePath)
+		if err != nil {
+			return err
+		}
+
+		run, err := flags.baseline()
+		if err != nil {
+			return err
+		}
+
+		WriteBaselineDiff(os.Stdout, baseline, run)
+
+		return nil
+	default:
+		return fmt.Errorf("unknown baseline command %q, expected save or diff", args[0])
+	}
+}
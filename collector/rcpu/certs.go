@@ -0,0 +1,222 @@
+package rcpu
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultCertReloadInterval is how often the certificate files are checked
+// for changes, at most, on the handshakes using them.
+const DefaultCertReloadInterval = 10 * time.Second
+
+// CertReloader serves a certificate with its key and a CA bundle read from
+// files, and reads them again once they changed. cert-manager's CSI driver and
+// spiffe-helper rotate certificates by rewriting the files, long-running
+// agents and aggregators pick the new ones up without a restart. A rotation
+// that fails to load, e.g. caught halfway through, keeps the previous files
+// until the next check.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	interval time.Duration
+
+	mu       sync.Mutex
+	checked  time.Time
+	modTimes [3]time.Time
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+}
+
+// NewCertReloader loads the certificate and key, and the CA bundle, each
+// unless its file is empty.
+func NewCertReloader(certFile, keyFile, caFile string) (*CertReloader, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("a TLS certificate requires its key and the other way around")
+	}
+
+	r := &CertReloader{certFile: certFile, keyFile: keyFile, caFile: caFile, interval: DefaultCertReloadInterval}
+	if err := r.load(r.stat()); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// stat returns the modification times of the files, zero for those unset or
+// missing
+func (r *CertReloader) stat() [3]time.Time {
+	var modTimes [3]time.Time
+	for i, path := range []string{r.certFile, r.keyFile, r.caFile} {
+		if path == "" {
+			continue
+		}
+
+		if info, err := os.Stat(path); err == nil {
+			modTimes[i] = info.ModTime()
+		}
+	}
+
+	return modTimes
+}
+
+func (r *CertReloader) load(modTimes [3]time.Time) error {
+	var cert *tls.Certificate
+	if r.certFile != "" {
+		c, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS certificate %s: %v", r.certFile, err)
+		}
+		cert = &c
+	}
+
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		p, err := LoadCertPool(r.caFile)
+		if err != nil {
+			return err
+		}
+		pool = p
+	}
+
+	r.cert, r.pool, r.modTimes = cert, pool, modTimes
+
+	return nil
+}
+
+// current returns the certificate and CA bundle, reloading them first if
+// their files changed since the last check
+func (r *CertReloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.checked) < r.interval {
+		return r.cert, r.pool
+	}
+	r.checked = now
+
+	if modTimes := r.stat(); modTimes != r.modTimes {
+		if err := r.load(modTimes); err != nil {
+			log.Printf("Keeping the previous certificates: %v\n", err)
+		} else {
+			log.Printf("Reloaded the rotated TLS certificates\n")
+		}
+	}
+
+	return r.cert, r.pool
+}
+
+// ServerConfig returns the TLS configuration of a server presenting the
+// certificate, which also requires clients to present one signed by the CA
+// bundle if there is one.
+func (r *CertReloader) ServerConfig() *tls.Config {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cert, pool := r.current()
+		if cert == nil {
+			return nil, errors.New("no server certificate")
+		}
+
+		// Cloned to keep the protocols the HTTP and gRPC servers added
+		c := config.Clone()
+		c.GetConfigForClient = nil
+		c.Certificates = []tls.Certificate{*cert}
+		if pool != nil {
+			c.ClientCAs = pool
+			c.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+
+		return c, nil
+	}
+
+	return config
+}
+
+// ClientConfig returns the TLS configuration of a client presenting the
+// certificate if there is one, and verifying the server against the CA bundle,
+// or the system's without one. With a SPIFFE ID the server's certificate has
+// to carry it as its URI name instead of the server's host name, as SPIFFE
+// certificates usually don't name hosts.
+func (r *CertReloader) ClientConfig(spiffeID string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			if cert == nil {
+				// No certificate, the server decides whether that's fine
+				return &tls.Certificate{}, nil
+			}
+
+			return cert, nil
+		},
+		// The CA bundle may rotate too, VerifyConnection checks the server
+		// against the current one in place of the default verification
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			_, pool := r.current()
+
+			return verifyServer(cs, pool, spiffeID)
+		},
+	}
+}
+
+// verifyServer does the verification of crypto/tls, with the roots of pool,
+// or the system's if it is nil, and for the SPIFFE ID rather than the host
+// name unless it is empty
+func verifyServer(cs tls.ConnectionState, pool *x509.CertPool, spiffeID string) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("the server presented no certificate")
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if spiffeID == "" {
+		opts.DNSName = cs.ServerName
+	}
+
+	leaf := cs.PeerCertificates[0]
+	if _, err := leaf.Verify(opts); err != nil {
+		return err
+	}
+
+	if spiffeID != "" && !hasURI(leaf, spiffeID) {
+		return fmt.Errorf("the server's certificate isn't valid for %s", spiffeID)
+	}
+
+	return nil
+}
+
+func hasURI(cert *x509.Certificate, uri string) bool {
+	for _, u := range cert.URIs {
+		if u.String() == uri {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidateSPIFFEID checks id is a spiffe:// URI naming a workload.
+func ValidateSPIFFEID(id string) error {
+	u, err := url.Parse(id)
+	if err != nil || u.Scheme != "spiffe" || u.Host == "" || u.Path == "" || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("invalid SPIFFE ID %q, expected spiffe://trust-domain/path", id)
+	}
+
+	return nil
+}
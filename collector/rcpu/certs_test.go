@@ -0,0 +1,137 @@
+package rcpu
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCertReloaderRotation(t *testing.T) {
+	now := time.Now()
+	caFile, _, ca, caKey := testCertificate(t, "ca", &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "rcpu test CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, nil, nil)
+
+	spiffeID, err := url.Parse("spiffe://example.org/rcpu/aggregator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCertificate := func(serial int64) (string, string) {
+		certFile, keyFile, _, _ := testCertificate(t, "server", &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+			URIs:         []*url.URL{spiffeID},
+			NotBefore:    now.Add(-time.Hour),
+			NotAfter:     now.Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}, ca, caKey)
+
+		return certFile, keyFile
+	}
+
+	serverCert, serverKey := serverCertificate(2)
+	clientCert, clientKey, _, _ := testCertificate(t, "client", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	serverReloader, err := NewCertReloader(serverCert, serverKey, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverReloader.interval = 0
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	server.TLS = serverReloader.ServerConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	clientReloader, err := NewCertReloader(clientCert, clientKey, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial := func(spiffeID string) (int64, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientReloader.ClientConfig(spiffeID)}}
+		defer client.CloseIdleConnections()
+
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+
+		return resp.TLS.PeerCertificates[0].SerialNumber.Int64(), nil
+	}
+
+	if got, err := serial(""); err != nil || got != 2 {
+		t.Fatalf("expected the server's certificate 2, got %d %v", got, err)
+	}
+
+	// Rotate the server's certificate in place
+	rotatedCert, rotatedKey := serverCertificate(4)
+	later := now.Add(time.Minute)
+	for _, f := range []struct{ from, to string }{{rotatedCert, serverCert}, {rotatedKey, serverKey}} {
+		b, err := os.ReadFile(f.from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(f.to, b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(f.to, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, err := serial("spiffe://example.org/rcpu/aggregator"); err != nil || got != 4 {
+		t.Errorf("expected the rotated certificate 4, got %d %v", got, err)
+	}
+
+	if _, err := serial("spiffe://example.org/rcpu/other"); err == nil {
+		t.Errorf("expected another SPIFFE ID to be refused")
+	}
+
+	// The server refuses clients without a certificate
+	anonymous, err := NewCertReloader("", "", caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: anonymous.ClientConfig("")}}
+	if resp, err := client.Get(server.URL); err == nil {
+		resp.Body.Close()
+		t.Errorf("expected a client without a certificate to be refused")
+	}
+}
+
+func TestValidateSPIFFEID(t *testing.T) {
+	for id, valid := range map[string]bool{
+		"spiffe://example.org/rcpu/aggregator": true,
+		"spiffe://example.org":                 false,
+		"https://example.org/rcpu":             false,
+		"spiffe:///rcpu":                       false,
+	} {
+		if err := ValidateSPIFFEID(id); (err == nil) != valid {
+			t.Errorf("%s: expected valid %v, got %v", id, valid, err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package rcpu
+
+import (
+	"time"
+)
+
+// DefaultCgroupMaxDivergence is how far, in percent, the busy time of
+// /proc/stat and of the root cgroup may drift apart before it is logged.
+// Ticks and the scheduler's runtime never agree exactly.
+const DefaultCgroupMaxDivergence = 5.0
+
+// clockTick is the length of a USER_HZ tick /proc/stat counts in.
+const clockTick = 10 * time.Millisecond
+
+// CgroupCheck compares the busy time of /proc/stat with the CPU usage of the
+// root cgroup every interval. Both should account the same work, so a
+// divergence means RCPU is computed from counters that don't reflect what
+// runs on the node, e.g. a container seeing its own cgroup as the root.
+type CgroupCheck struct {
+	host     *Host
+	interval time.Duration
+
+	last        time.Time
+	prevBusy    time.Duration
+	prevCgroup  time.Duration
+	initialized bool
+}
+
+func NewCgroupCheck(host *Host, interval time.Duration) *CgroupCheck {
+	return &CgroupCheck{host: host, interval: interval}
+}
+
+// procBusy sums the busy time of every CPU like the kernel does for the root
+// cgroup, guest and steal time included.
+func procBusy(cpuTimes []CPUTime) time.Duration {
+	var ticks uint64
+	for i := range cpuTimes {
+		ticks += cpuTimes[i].TotalTime() - cpuTimes[i].TotalIdleTime()
+	}
+
+	return time.Duration(ticks) * clockTick
+}
+
+// Check returns the divergence in percent once an interval has passed since
+// the previous check, the difference of the two busy times relative to the
+// larger one, positive when /proc/stat counts more. ok is false in between.
+func (c *CgroupCheck) Check(now time.Time, cpuTimes []CPUTime) (divergence float64, ok bool, err error) {
+	if c.initialized && now.Sub(c.last) < c.interval {
+		return 0, false, nil
+	}
+
+	cgroup, err := c.host.CgroupCPUUsage()
+	if err != nil {
+		return 0, false, err
+	}
+
+	busy := procBusy(cpuTimes)
+	prevBusy, prevCgroup, initialized := c.prevBusy, c.prevCgroup, c.initialized
+	c.last, c.prevBusy, c.prevCgroup, c.initialized = now, busy, cgroup, true
+
+	// Start over after the first read or when either counter went backwards
+	if !initialized || busy < prevBusy || cgroup < prevCgroup {
+		return 0, false, nil
+	}
+
+	busyPeriod, cgroupPeriod := busy-prevBusy, cgroup-prevCgroup
+	if larger := max(busyPeriod, cgroupPeriod); larger > 0 {
+		divergence = 100.0 * float64(busyPeriod-cgroupPeriod) / float64(larger)
+	}
+
+	return divergence, true, nil
+}
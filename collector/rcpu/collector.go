@@ -0,0 +1,78 @@
+package rcpu
+
+import "fmt"
+
+// Collector is the platform backend the collector reads the machine through,
+// procfs and sysfs on Linux, sysctls on FreeBSD, the Mach host on macOS and
+// the Win32 and NT APIs on Windows. NewCollector picks the one of the platform
+// the binary is built for.
+type Collector interface {
+	// Detect checks the machine is supported and reads its topology.
+	Detect(preflight Preflight) (*Detection, error)
+	// NewCPUTimesReader opens the per-CPU times, read on every tick.
+	NewCPUTimesReader() (CPUTimesReader, error)
+	LoadAvg() ([3]float64, error)
+}
+
+// CPUTimesReader reads the cumulative per-CPU times, see ProcStatReader.
+type CPUTimesReader interface {
+	SetShards(shards int)
+	SetCPUs(cpuToCore map[int32]int32)
+	ReadInto(dst []CPUTime) ([]CPUTime, error)
+	Close() error
+}
+
+// ProcCollector reads procfs and sysfs, with the topology from lscpu or from
+// the host's sysfs.
+type ProcCollector struct {
+	host     *Host
+	useLsCPU bool
+}
+
+func NewProcCollector(h *Host, useLsCPU bool) *ProcCollector {
+	return &ProcCollector{host: h, useLsCPU: useLsCPU}
+}
+
+func (c *ProcCollector) Detect(preflight Preflight) (*Detection, error) {
+	return Detect(c.host, c.useLsCPU, preflight)
+}
+
+func (c *ProcCollector) NewCPUTimesReader() (CPUTimesReader, error) {
+	return NewProcStatReader(c.host)
+}
+
+func (c *ProcCollector) LoadAvg() ([3]float64, error) {
+	return c.host.LoadAvg()
+}
+
+// PairedCPUInfos numbers the CPUs of a machine that only reports how many
+// logical and physical CPUs it has, with siblings next to each other as
+// macOS numbers them. Without SMT, adjacent CPUs are paired up anyway so the
+// adjusted usage can be computed at all, synthetic is true then.
+func PairedCPUInfos(logical, physical, packages int) (cpuInfos []CPUInfo, synthetic bool, err error) {
+	if physical <= 0 || packages <= 0 || logical%physical != 0 || physical%packages != 0 {
+		return nil, false, fmt.Errorf("%w: %d logical CPUs, %d physical CPUs in %d packages", ErrUnsupportedTopology, logical, physical, packages)
+	}
+
+	switch logical / physical {
+	case 1:
+		if logical%2 != 0 {
+			return nil, false, fmt.Errorf("%w: can't pair up %d CPUs without SMT", ErrUnsupportedTopology, logical)
+		}
+		synthetic = true
+	case 2:
+	default:
+		return nil, false, fmt.Errorf("%w: %d threads per core, expected 2", ErrUnsupportedTopology, logical/physical)
+	}
+
+	cpusPerPackage := logical / packages
+	for cpuId := 0; cpuId < logical; cpuId++ {
+		cpuInfos = append(cpuInfos, CPUInfo{
+			CPUId:    int32(cpuId),
+			CoreId:   int32(cpuId / 2),
+			SocketId: int32(cpuId / cpusPerPackage),
+		})
+	}
+
+	return cpuInfos, synthetic, nil
+}
@@ -0,0 +1,159 @@
+//go:build darwin && cgo
+
+package rcpu
+
+/*
+#include <mach/mach_host.h>
+#include <mach/processor_info.h>
+#include <mach/vm_map.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"solelab.tech/collector/internal/parse"
+)
+
+// MachCollector reads the per-CPU ticks from the Mach host_processor_info
+// and pairs up the CPUs from hw.logicalcpu and hw.physicalcpu. It exists so
+// the collector, its displays and sinks can be run on a Mac during
+// development, production targets Linux. Apple silicon has no SMT, its CPUs
+// are paired up as if they were siblings and the model isn't checked.
+type MachCollector struct {
+	cpus int
+}
+
+func NewCollector(h *Host, useLsCPU bool) Collector {
+	return &MachCollector{}
+}
+
+func (c *MachCollector) Detect(preflight Preflight) (*Detection, error) {
+	model, err := unix.Sysctl("machdep.cpu.brand_string")
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to get CPU model: %v", ErrUnsupportedCPU, err)
+	}
+
+	var counts [3]uint32
+	for i, name := range []string{"hw.logicalcpu", "hw.physicalcpu", "hw.packages"} {
+		if counts[i], err = unix.SysctlUint32(name); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", name, err)
+		}
+	}
+
+	cpuInfos, synthetic, err := PairedCPUInfos(int(counts[0]), int(counts[1]), int(counts[2]))
+	if err != nil {
+		return nil, err
+	}
+
+	if synthetic || !strings.Contains(model, "Intel") {
+		log.Printf("%s has no SMT siblings, adjacent CPUs are paired up for development and RCPU is meaningless\n", model)
+	}
+
+	environment := EnvironmentBareMetal
+	if vmm, err := unix.SysctlUint32("kern.hv_vmm_present"); err == nil && vmm != 0 {
+		environment = EnvironmentVM
+	}
+
+	c.cpus = len(cpuInfos)
+
+	return NewDetection(model, environment, cpuInfos, preflight)
+}
+
+func (c *MachCollector) NewCPUTimesReader() (CPUTimesReader, error) {
+	r := &MachCPUTimesReader{}
+	if c.cpus > 0 {
+		r.cpus = make([]bool, c.cpus)
+		for i := range r.cpus {
+			r.cpus[i] = true
+		}
+	}
+
+	return r, nil
+}
+
+func (c *MachCollector) LoadAvg() ([3]float64, error) {
+	b, err := unix.SysctlRaw("vm.loadavg")
+	if err != nil {
+		return [3]float64{}, fmt.Errorf("failed to read vm.loadavg: %v", err)
+	}
+
+	// The same struct loadavg as on FreeBSD
+	return parse.BSDLoadAvg(b, int(unsafe.Sizeof(C.long(0))))
+}
+
+// MachCPUTimesReader reads the user, system, idle and nice ticks of
+// host_processor_info, macOS accounts nothing else.
+type MachCPUTimesReader struct {
+	// cpus selects the CPUs kept by ReadInto, indexed by CPU ID, nil keeps all
+	cpus []bool
+}
+
+// SetShards is a no-op, the ticks come as an array.
+func (r *MachCPUTimesReader) SetShards(shards int) {}
+
+// SetCPUs restricts ReadInto to the given CPUs.
+func (r *MachCPUTimesReader) SetCPUs(cpuToCore map[int32]int32) {
+	var maxCPUId int32
+	for cpuId := range cpuToCore {
+		maxCPUId = max(maxCPUId, cpuId)
+	}
+
+	r.cpus = make([]bool, maxCPUId+1)
+	for cpuId := range cpuToCore {
+		r.cpus[cpuId] = true
+	}
+}
+
+func (r *MachCPUTimesReader) Close() error {
+	return nil
+}
+
+func (r *MachCPUTimesReader) ReadInto(dst []CPUTime) ([]CPUTime, error) {
+	var cpus C.natural_t
+	var info C.processor_info_array_t
+	var infoCount C.mach_msg_type_number_t
+	if ret := C.host_processor_info(C.mach_host_self(), C.PROCESSOR_CPU_LOAD_INFO, &cpus, &info, &infoCount); ret != C.KERN_SUCCESS {
+		return nil, fmt.Errorf("%w: host_processor_info failed with %d", ErrStatParse, int(ret))
+	}
+	defer C.vm_deallocate(C.mach_task_self_, C.vm_address_t(uintptr(unsafe.Pointer(info))), C.vm_size_t(int(infoCount)*int(C.sizeof_integer_t)))
+
+	if int(infoCount) < int(cpus)*C.CPU_STATE_MAX {
+		return nil, fmt.Errorf("%w: host_processor_info returned %d ticks for %d CPUs", ErrStatParse, int(infoCount), int(cpus))
+	}
+
+	// The ticks are unsigned 32 bit counters that wrap around
+	ticks := unsafe.Slice((*C.integer_t)(unsafe.Pointer(info)), int(infoCount))
+	tick := func(cpuId, state int) uint64 {
+		return uint64(uint32(ticks[cpuId*C.CPU_STATE_MAX+state]))
+	}
+
+	now := time.Now()
+	dst = dst[:0]
+	for cpuId := 0; cpuId < int(cpus); cpuId++ {
+		if r.cpus != nil && (cpuId >= len(r.cpus) || !r.cpus[cpuId]) {
+			continue
+		}
+
+		dst = append(dst, CPUTime{
+			CPUId:       int32(cpuId),
+			CollectTime: now,
+			User:        tick(cpuId, C.CPU_STATE_USER),
+			Nice:        tick(cpuId, C.CPU_STATE_NICE),
+			Sys:         tick(cpuId, C.CPU_STATE_SYSTEM),
+			Idle:        tick(cpuId, C.CPU_STATE_IDLE),
+		})
+	}
+
+	if len(dst) == 0 {
+		return nil, fmt.Errorf("%w: no CPUs in host_processor_info", ErrStatParse)
+	}
+
+	return dst, nil
+}
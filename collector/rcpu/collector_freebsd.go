@@ -0,0 +1,174 @@
+//go:build freebsd
+
+package rcpu
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"solelab.tech/collector/internal/parse"
+)
+
+// C longs are as wide as Go ints on every platform FreeBSD runs on
+const sysctlWordSize = strconv.IntSize / 8
+
+// vmGuests maps kern.vm_guest to the environment.
+var vmGuests = map[string]string{
+	"none":   EnvironmentBareMetal,
+	"kvm":    EnvironmentKVM,
+	"vmware": EnvironmentVMware,
+	"xen":    EnvironmentXen,
+	"hv":     EnvironmentHyperV,
+}
+
+// SysctlCollector reads FreeBSD's sysctls, the per-CPU times from
+// kern.cp_times and the topology from the ULE scheduler's
+// kern.sched.topology_spec. The host is only used by the optional features
+// that need a Linux procfs, which fail on their own.
+type SysctlCollector struct {
+	// cpuToCore keeps the CPUs of the topology, kern.cp_times also has
+	// absent CPUs up to the highest ID
+	cpuToCore map[int32]int32
+}
+
+func NewCollector(h *Host, useLsCPU bool) Collector {
+	return &SysctlCollector{}
+}
+
+func (c *SysctlCollector) Detect(preflight Preflight) (*Detection, error) {
+	model, err := unix.Sysctl("hw.model")
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to get CPU model: %v", ErrUnsupportedCPU, err)
+	}
+
+	if err := preflight.CheckCPUModel(model); err != nil {
+		return nil, err
+	}
+
+	spec, err := unix.Sysctl("kern.sched.topology_spec")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kern.sched.topology_spec, only the ULE scheduler reports it: %v", err)
+	}
+
+	entries, smt, err := parse.SchedTopology(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse kern.sched.topology_spec: %w", err)
+	}
+
+	if err := preflight.CheckSMTEnabled(smt); err != nil {
+		return nil, err
+	}
+
+	var cpuInfos []CPUInfo
+	for _, entry := range entries {
+		// Kernels without NUMA support have no domains, everything is node 0
+		domain, _ := unix.SysctlUint32(fmt.Sprintf("dev.cpu.%d.%%domain", entry.CPU))
+
+		cpuInfos = append(cpuInfos, CPUInfo{
+			CPUId:    entry.CPU,
+			CoreId:   entry.Core,
+			SocketId: entry.Socket,
+			NodeId:   int32(domain),
+		})
+	}
+	sortCPUInfos(cpuInfos)
+
+	environment := EnvironmentVM
+	if guest, err := unix.Sysctl("kern.vm_guest"); err != nil {
+		environment = EnvironmentBareMetal
+	} else if env, ok := vmGuests[guest]; ok {
+		environment = env
+	}
+
+	detection, err := NewDetection(model, environment, cpuInfos, preflight)
+	if err != nil {
+		return nil, err
+	}
+	c.cpuToCore = detection.CPUToCore
+
+	return detection, nil
+}
+
+func (c *SysctlCollector) NewCPUTimesReader() (CPUTimesReader, error) {
+	r := &SysctlCPUTimesReader{}
+	if c.cpuToCore != nil {
+		r.SetCPUs(c.cpuToCore)
+	}
+
+	return r, nil
+}
+
+func (c *SysctlCollector) LoadAvg() ([3]float64, error) {
+	b, err := unix.SysctlRaw("vm.loadavg")
+	if err != nil {
+		return [3]float64{}, fmt.Errorf("failed to read vm.loadavg: %v", err)
+	}
+
+	return parse.BSDLoadAvg(b, sysctlWordSize)
+}
+
+// SysctlCPUTimesReader reads kern.cp_times, which has no idle split into
+// iowait nor softirq, steal or guest time.
+type SysctlCPUTimesReader struct {
+	// cpus selects the CPUs kept by ReadInto, indexed by CPU ID, nil keeps all
+	cpus []bool
+}
+
+// SetShards is a no-op, the binary counters are cheap to parse.
+func (r *SysctlCPUTimesReader) SetShards(shards int) {}
+
+// SetCPUs restricts ReadInto to the given CPUs.
+func (r *SysctlCPUTimesReader) SetCPUs(cpuToCore map[int32]int32) {
+	var maxCPUId int32
+	for cpuId := range cpuToCore {
+		maxCPUId = max(maxCPUId, cpuId)
+	}
+
+	r.cpus = make([]bool, maxCPUId+1)
+	for cpuId := range cpuToCore {
+		r.cpus[cpuId] = true
+	}
+}
+
+func (r *SysctlCPUTimesReader) Close() error {
+	return nil
+}
+
+func (r *SysctlCPUTimesReader) ReadInto(dst []CPUTime) ([]CPUTime, error) {
+	b, err := unix.SysctlRaw("kern.cp_times")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kern.cp_times: %v", err)
+	}
+
+	times, err := parse.CPTimes(b, sysctlWordSize)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrStatParse, err)
+	}
+
+	now := time.Now()
+	dst = dst[:0]
+	for cpuId, t := range times {
+		if r.cpus != nil && (cpuId >= len(r.cpus) || !r.cpus[cpuId]) {
+			continue
+		}
+
+		dst = append(dst, CPUTime{
+			CPUId:       int32(cpuId),
+			CollectTime: now,
+			User:        t[0],
+			Nice:        t[1],
+			Sys:         t[2],
+			IRQ:         t[3],
+			Idle:        t[4],
+		})
+	}
+
+	if len(dst) == 0 {
+		return nil, fmt.Errorf("%w: no CPUs in kern.cp_times", ErrStatParse)
+	}
+
+	return dst, nil
+}
@@ -0,0 +1,7 @@
+//go:build !freebsd && !windows && !(darwin && cgo)
+
+package rcpu
+
+func NewCollector(h *Host, useLsCPU bool) Collector {
+	return NewProcCollector(h, useLsCPU)
+}
@@ -0,0 +1,51 @@
+package rcpu
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPairedCPUInfos(t *testing.T) {
+	cpuInfos, synthetic, err := PairedCPUInfos(8, 4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if synthetic || len(cpuInfos) != 8 {
+		t.Fatalf("expected 8 real siblings, got %v, synthetic %v", cpuInfos, synthetic)
+	}
+
+	if info := cpuInfos[5]; info.CoreId != 2 || info.SocketId != 1 {
+		t.Errorf("expected CPU 5 on core 2 of socket 1, got %+v", info)
+	}
+
+	detection, err := NewDetection("Apple M2", EnvironmentBareMetal, mustPairedCPUInfos(t, 8, 8, 1), DefaultPreflight())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(detection.CoreToCPUs) != 4 {
+		t.Errorf("expected 4 synthetic cores, got %v", detection.CoreToCPUs)
+	}
+
+	for _, counts := range [][3]int{{7, 7, 1}, {12, 4, 1}, {8, 4, 3}} {
+		if _, _, err := PairedCPUInfos(counts[0], counts[1], counts[2]); !errors.Is(err, ErrUnsupportedTopology) {
+			t.Errorf("expected %v to be unsupported, got %v", counts, err)
+		}
+	}
+}
+
+func mustPairedCPUInfos(t *testing.T, logical, physical, packages int) []CPUInfo {
+	t.Helper()
+
+	cpuInfos, synthetic, err := PairedCPUInfos(logical, physical, packages)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !synthetic {
+		t.Fatalf("expected synthetic siblings without SMT")
+	}
+
+	return cpuInfos
+}
@@ -0,0 +1,223 @@
+//go:build windows
+
+package rcpu
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+
+	"solelab.tech/collector/cputime"
+	"solelab.tech/collector/internal/parse"
+)
+
+const (
+	windowsProcessorKey = `HARDWARE\DESCRIPTION\System\CentralProcessor\0`
+	windowsBIOSKey      = `HARDWARE\DESCRIPTION\System\BIOS`
+
+	// A KAFFINITY mask is pointer sized, a processor group has as many CPUs
+	// as it has bits
+	windowsGroupSize = strconv.IntSize
+)
+
+var procGetLogicalProcessorInformationEx = windows.NewLazySystemDLL("kernel32.dll").NewProc("GetLogicalProcessorInformationEx")
+
+// WindowsCollector reads the per-processor times of NtQuerySystemInformation
+// and the topology of GetLogicalProcessorInformationEx. Machines with more
+// than one processor group, i.e. above 64 CPUs, aren't supported, the times
+// only cover the group of the calling thread.
+type WindowsCollector struct {
+	cpuToCore map[int32]int32
+}
+
+func NewCollector(h *Host, useLsCPU bool) Collector {
+	return &WindowsCollector{}
+}
+
+func readRegistryString(path, name string) (string, error) {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, path, registry.QUERY_VALUE)
+	if err != nil {
+		return "", err
+	}
+	defer k.Close()
+
+	v, _, err := k.GetStringValue(name)
+
+	return strings.TrimSpace(v), err
+}
+
+func logicalProcessorInformation() ([]byte, error) {
+	var size uint32
+	r, _, err := procGetLogicalProcessorInformationEx.Call(parse.RelationAll, 0, uintptr(unsafe.Pointer(&size)))
+	if r == 0 && err != windows.ERROR_INSUFFICIENT_BUFFER {
+		return nil, fmt.Errorf("GetLogicalProcessorInformationEx failed: %v", err)
+	}
+
+	b := make([]byte, size)
+	r, _, err = procGetLogicalProcessorInformationEx.Call(parse.RelationAll, uintptr(unsafe.Pointer(&b[0])), uintptr(unsafe.Pointer(&size)))
+	if r == 0 {
+		return nil, fmt.Errorf("GetLogicalProcessorInformationEx failed: %v", err)
+	}
+
+	return b[:size], nil
+}
+
+// windowsEnvironment names the hypervisor from the SMBIOS system vendor and
+// product, Windows has no cheap way to read the CPUID hypervisor bit.
+func windowsEnvironment() string {
+	vendor, _ := readRegistryString(windowsBIOSKey, "SystemManufacturer")
+	product, _ := readRegistryString(windowsBIOSKey, "SystemProductName")
+
+	// Microsoft also makes Surface hardware, its VMs are "Virtual Machine"
+	if vendor == "Microsoft Corporation" && product != "Virtual Machine" {
+		return EnvironmentBareMetal
+	}
+
+	dmi := vendor + " " + product
+	for _, v := range dmiVendors {
+		if strings.Contains(dmi, v.substr) {
+			return v.environment
+		}
+	}
+
+	return EnvironmentBareMetal
+}
+
+func (c *WindowsCollector) Detect(preflight Preflight) (*Detection, error) {
+	model, err := readRegistryString(windowsProcessorKey, "ProcessorNameString")
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to get CPU model: %v", ErrUnsupportedCPU, err)
+	}
+
+	if err := preflight.CheckCPUModel(model); err != nil {
+		return nil, err
+	}
+
+	b, err := logicalProcessorInformation()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := parse.LogicalProcessors(b, windowsGroupSize/8)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the logical processor information: %w", err)
+	}
+
+	cores := make(map[int32]bool)
+	var cpuInfos []CPUInfo
+	for _, entry := range entries {
+		if entry.Group != 0 {
+			return nil, fmt.Errorf("%w: CPU %d is in processor group %d, only a single group is supported", ErrUnsupportedTopology, entry.CPU, entry.Group)
+		}
+
+		cores[entry.Core] = true
+		cpuInfos = append(cpuInfos, CPUInfo{
+			CPUId:    entry.CPU,
+			CoreId:   entry.Core,
+			SocketId: entry.Socket,
+			NodeId:   entry.Node,
+		})
+	}
+	sortCPUInfos(cpuInfos)
+
+	if err := preflight.CheckSMTEnabled(len(cores) < len(cpuInfos)); err != nil {
+		return nil, err
+	}
+
+	detection, err := NewDetection(model, windowsEnvironment(), cpuInfos, preflight)
+	if err != nil {
+		return nil, err
+	}
+	c.cpuToCore = detection.CPUToCore
+
+	return detection, nil
+}
+
+func (c *WindowsCollector) NewCPUTimesReader() (CPUTimesReader, error) {
+	r := &WindowsCPUTimesReader{buf: make([]byte, windowsGroupSize*parse.ProcessorPerformanceSize)}
+	if c.cpuToCore != nil {
+		r.SetCPUs(c.cpuToCore)
+	}
+
+	return r, nil
+}
+
+// LoadAvg is always zero, Windows has no load average.
+func (c *WindowsCollector) LoadAvg() ([3]float64, error) {
+	return [3]float64{}, nil
+}
+
+// WindowsCPUTimesReader reads SystemProcessorPerformanceInformation, in 100ns
+// units. DPCs, deferred interrupt work, count as soft IRQs.
+type WindowsCPUTimesReader struct {
+	buf []byte
+
+	// cpus selects the CPUs kept by ReadInto, indexed by CPU ID, nil keeps all
+	cpus []bool
+}
+
+// SetShards is a no-op, the times come as an array.
+func (r *WindowsCPUTimesReader) SetShards(shards int) {}
+
+// SetCPUs restricts ReadInto to the given CPUs.
+func (r *WindowsCPUTimesReader) SetCPUs(cpuToCore map[int32]int32) {
+	var maxCPUId int32
+	for cpuId := range cpuToCore {
+		maxCPUId = max(maxCPUId, cpuId)
+	}
+
+	r.cpus = make([]bool, maxCPUId+1)
+	for cpuId := range cpuToCore {
+		r.cpus[cpuId] = true
+	}
+}
+
+func (r *WindowsCPUTimesReader) Close() error {
+	return nil
+}
+
+func (r *WindowsCPUTimesReader) ReadInto(dst []CPUTime) ([]CPUTime, error) {
+	var n uint32
+	if err := windows.NtQuerySystemInformation(windows.SystemProcessorPerformanceInformation, unsafe.Pointer(&r.buf[0]), uint32(len(r.buf)), &n); err != nil {
+		return nil, fmt.Errorf("failed to query the processor performance information: %v", err)
+	}
+
+	perfs, err := parse.ProcessorPerformances(r.buf[:n])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrStatParse, err)
+	}
+
+	now := time.Now()
+	dst = dst[:0]
+	for cpuId, perf := range perfs {
+		if r.cpus != nil && (cpuId >= len(r.cpus) || !r.cpus[cpuId]) {
+			continue
+		}
+
+		// The kernel time includes everything but the user time
+		sys := cputime.SaturatedSub(perf.Kernel, perf.Idle)
+		sys = cputime.SaturatedSub(sys, perf.DPC)
+		sys = cputime.SaturatedSub(sys, perf.Interrupt)
+
+		dst = append(dst, CPUTime{
+			CPUId:       int32(cpuId),
+			CollectTime: now,
+			User:        perf.User,
+			Sys:         sys,
+			Idle:        perf.Idle,
+			IRQ:         perf.Interrupt,
+			SoftIRQ:     perf.DPC,
+		})
+	}
+
+	if len(dst) == 0 {
+		return nil, fmt.Errorf("%w: no processors in the performance information", ErrStatParse)
+	}
+
+	return dst, nil
+}
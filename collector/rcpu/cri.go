@@ -0,0 +1,339 @@
+package rcpu
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"solelab.tech/collector/internal/parse"
+)
+
+const (
+	DefaultCRIEndpoint = "unix:///run/containerd/containerd.sock"
+	DefaultCRITimeout  = 10 * time.Second
+	// DefaultCRIRefresh is how often the running containers are listed, their
+	// usage is read from their cgroups every tick
+	DefaultCRIRefresh = 10 * time.Second
+
+	criPodNameLabel       = "io.kubernetes.pod.name"
+	criPodNamespaceLabel  = "io.kubernetes.pod.namespace"
+	criContainerNameLabel = "io.kubernetes.container.name"
+
+	SysCgroupDir = "fs/cgroup"
+)
+
+// ContainerCgroup is where the CPU usage and the cpuset of a container are
+// read from, relative to the sysfs root.
+type ContainerCgroup struct {
+	UsagePath  string
+	CPUSetPath string
+	// V1 usage is cpuacct.usage in nanoseconds, v2 is cpu.stat in microseconds
+	V1 bool
+}
+
+// ProcessCgroup finds the cgroup of the process from /proc/<pid>/cgroup.
+func (h *Host) ProcessCgroup(pid int) (ContainerCgroup, error) {
+	name := path.Join(strconv.Itoa(pid), "cgroup")
+	out, err := fs.ReadFile(h.Proc, name)
+	if err != nil {
+		return ContainerCgroup{}, err
+	}
+
+	cgroups, err := parse.ProcCgroups(out)
+	if err != nil {
+		return ContainerCgroup{}, fmt.Errorf("failed to parse %s: %v", name, err)
+	}
+
+	if cpuacct, ok := cgroups["cpuacct"]; ok {
+		return ContainerCgroup{
+			UsagePath:  path.Join(SysCgroupDir, "cpuacct", cpuacct, "cpuacct.usage"),
+			CPUSetPath: path.Join(SysCgroupDir, "cpuset", cgroups["cpuset"], "cpuset.effective_cpus"),
+			V1:         true,
+		}, nil
+	}
+
+	unified, ok := cgroups[""]
+	if !ok {
+		return ContainerCgroup{}, fmt.Errorf("no cpuacct or unified cgroup in %s", name)
+	}
+
+	return ContainerCgroup{
+		UsagePath:  path.Join(SysCgroupDir, unified, "cpu.stat"),
+		CPUSetPath: path.Join(SysCgroupDir, unified, "cpuset.cpus.effective"),
+	}, nil
+}
+
+// ContainerCPUUsage reads the cumulative CPU usage of the cgroup and the CPUs
+// it may run on. The CPUs are nil when the cpuset controller isn't enabled.
+func (h *Host) ContainerCPUUsage(cgroup ContainerCgroup) (time.Duration, []int32, error) {
+	out, err := fs.ReadFile(h.Sys, cgroup.UsagePath)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var usage time.Duration
+	if cgroup.V1 {
+		nsec, err := parse.CgroupCPUAcctUsage(out)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to parse %s: %v", cgroup.UsagePath, err)
+		}
+		usage = time.Duration(nsec)
+	} else {
+		usec, err := parse.CgroupCPUStatUsage(out)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to parse %s: %v", cgroup.UsagePath, err)
+		}
+		usage = time.Duration(usec) * time.Microsecond
+	}
+
+	var cpus []int32
+	if out, err := fs.ReadFile(h.Sys, cgroup.CPUSetPath); err == nil {
+		if cpus, err = parse.CPUList(string(out)); err != nil {
+			return 0, nil, fmt.Errorf("failed to parse %s: %v", cgroup.CPUSetPath, err)
+		}
+	}
+
+	return usage, cpus, nil
+}
+
+type criContainer struct {
+	ID        string
+	Namespace string
+	Pod       string
+	Container string
+	Cgroup    ContainerCgroup
+}
+
+// CRIWatcher lists the running containers through the container runtime
+// (containerd or CRI-O) in the background, over a single connection, and
+// maps each of them to its cgroup through the pid the runtime reports.
+type CRIWatcher struct {
+	host   *Host
+	conn   *grpc.ClientConn
+	client criapi.RuntimeServiceClient
+
+	mu         sync.Mutex
+	containers map[string]criContainer
+}
+
+// NewCRIWatcher connects lazily, an unavailable runtime is reported by Run.
+func NewCRIWatcher(host *Host, endpoint string) (*CRIWatcher, error) {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "unix://" + endpoint
+	}
+
+	conn, err := grpc.NewClient(endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", endpoint, err)
+	}
+
+	return &CRIWatcher{
+		host:       host,
+		conn:       conn,
+		client:     criapi.NewRuntimeServiceClient(conn),
+		containers: make(map[string]criContainer),
+	}, nil
+}
+
+func (w *CRIWatcher) Close() error {
+	return w.conn.Close()
+}
+
+// containerPid reads the pid of the container's init process out of the
+// verbose status, both containerd and CRI-O report it under "info".
+func (w *CRIWatcher) containerPid(ctx context.Context, id string) (int, error) {
+	resp, err := w.client.ContainerStatus(ctx, &criapi.ContainerStatusRequest{ContainerId: id, Verbose: true})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the status of container %s: %v", id, err)
+	}
+
+	var info struct {
+		Pid int `json:"pid"`
+	}
+	if err := json.Unmarshal([]byte(resp.GetInfo()["info"]), &info); err != nil || info.Pid <= 0 {
+		return 0, fmt.Errorf("no pid in the status of container %s", id)
+	}
+
+	return info.Pid, nil
+}
+
+func (w *CRIWatcher) refresh(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, DefaultCRITimeout)
+	defer cancel()
+
+	resp, err := w.client.ListContainers(ctx, &criapi.ListContainersRequest{
+		Filter: &criapi.ContainerFilter{
+			State: &criapi.ContainerStateValue{State: criapi.ContainerState_CONTAINER_RUNNING},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list containers: %v", err)
+	}
+
+	w.mu.Lock()
+	known := w.containers
+	w.mu.Unlock()
+
+	// Only new containers are resolved, a container keeps its cgroup
+	containers := make(map[string]criContainer, len(resp.GetContainers()))
+	var errs []error
+	for _, c := range resp.GetContainers() {
+		if container, ok := known[c.GetId()]; ok {
+			containers[c.GetId()] = container
+			continue
+		}
+
+		pid, err := w.containerPid(ctx, c.GetId())
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		cgroup, err := w.host.ProcessCgroup(pid)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to find the cgroup of container %s: %v", c.GetId(), err))
+			continue
+		}
+
+		labels := c.GetLabels()
+		containers[c.GetId()] = criContainer{
+			ID:        c.GetId(),
+			Namespace: labels[criPodNamespaceLabel],
+			Pod:       labels[criPodNameLabel],
+			Container: labels[criContainerNameLabel],
+			Cgroup:    cgroup,
+		}
+	}
+
+	w.mu.Lock()
+	w.containers = containers
+	w.mu.Unlock()
+
+	return errors.Join(errs...)
+}
+
+// Run refreshes the containers every interval until ctx is done. The previous
+// containers are kept while the runtime is unreachable.
+func (w *CRIWatcher) Run(ctx context.Context, interval time.Duration, errors *ErrorLimiter) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.refresh(ctx); err != nil {
+			errors.Log(ErrorClassCRI, "%v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *CRIWatcher) snapshot() []criContainer {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	containers := make([]criContainer, 0, len(w.containers))
+	for _, container := range w.containers {
+		containers = append(containers, container)
+	}
+
+	return containers
+}
+
+type ContainerCPU struct {
+	ID        string `json:"id"`
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	// BusyCores is the container's CPU usage since the previous sample, in
+	// logical CPUs
+	BusyCores float64 `json:"busy_cores"`
+	// AdjustedCores is the container's share of the node's adjusted busy
+	// time, in physical cores
+	AdjustedCores float64 `json:"adjusted_cores"`
+}
+
+type containerUsage struct {
+	time  time.Time
+	usage time.Duration
+}
+
+// ContainerCPUTracker turns the cumulative usage in the cgroups of the
+// watched containers into rates.
+type ContainerCPUTracker struct {
+	watcher *CRIWatcher
+	prev    map[string]containerUsage
+}
+
+func NewContainerCPUTracker(watcher *CRIWatcher) *ContainerCPUTracker {
+	return &ContainerCPUTracker{
+		watcher: watcher,
+		prev:    make(map[string]containerUsage),
+	}
+}
+
+// Sample attributes the adjusted busy time to the containers by the usage of
+// their cgroups since the previous call. Containers seen for the first time
+// are left out until they have a rate.
+//
+// A thread's share of its core, see CPUBusy.Share, is lower the busier its
+// sibling, so each container is charged the SMT factor of the CPUs of its
+// cpuset: a container pinned next to a busy sibling gets less than its busy
+// time, one pinned to cores of its own gets all of it. Containers that may
+// run anywhere share the factor of the whole node, cgroup v2 doesn't tell on
+// which CPUs their time was spent.
+func (t *ContainerCPUTracker) Sample(now time.Time, busy *CPUBusy) ([]ContainerCPU, error) {
+	cur := make(map[string]containerUsage)
+	var containers []ContainerCPU
+	var errs []error
+	for _, container := range t.watcher.snapshot() {
+		usage, cpus, err := t.watcher.host.ContainerCPUUsage(container.Cgroup)
+		if err != nil {
+			// The container exited since it was listed
+			if !errors.Is(err, fs.ErrNotExist) {
+				errs = append(errs, fmt.Errorf("failed to read the usage of container %s: %v", container.ID, err))
+			}
+			continue
+		}
+		cur[container.ID] = containerUsage{time: now, usage: usage}
+
+		prev, ok := t.prev[container.ID]
+		if !ok || !now.After(prev.time) || usage < prev.usage {
+			continue
+		}
+
+		busyCores := float64(usage-prev.usage) / float64(now.Sub(prev.time))
+		containers = append(containers, ContainerCPU{
+			ID:            container.ID,
+			Namespace:     container.Namespace,
+			Pod:           container.Pod,
+			Container:     container.Container,
+			BusyCores:     busyCores,
+			AdjustedCores: busyCores * busy.Factor(cpus),
+		})
+	}
+
+	// Forget containers which are gone
+	t.prev = cur
+
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].AdjustedCores > containers[j].AdjustedCores
+	})
+
+	return containers, errors.Join(errs...)
+}
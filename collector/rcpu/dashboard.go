@@ -0,0 +1,239 @@
+package rcpu
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	DashboardFormatGrafana = "grafana"
+
+	DefaultDashboardTitle = "RCPU"
+
+	// grafanaSchemaVersion is the dashboard schema of Grafana 10
+	grafanaSchemaVersion = 39
+
+	// schedulerAnnotationAgeMetric is served by the scheduler plugin, see
+	// plugins/metrics.go, not by the collector
+	schedulerAnnotationAgeMetric = "rcpu_scheduler_annotation_age_seconds"
+)
+
+var dashboardFormats = []string{DashboardFormatGrafana}
+
+type GrafanaDatasource struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type GrafanaTarget struct {
+	RefID        string `json:"refId"`
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat,omitempty"`
+}
+
+type GrafanaGridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type GrafanaFieldDefaults struct {
+	Unit string   `json:"unit,omitempty"`
+	Min  *float64 `json:"min,omitempty"`
+	Max  *float64 `json:"max,omitempty"`
+}
+
+type GrafanaFieldConfig struct {
+	Defaults GrafanaFieldDefaults `json:"defaults"`
+}
+
+type GrafanaPanel struct {
+	ID          int                `json:"id"`
+	Type        string             `json:"type"`
+	Title       string             `json:"title"`
+	Description string             `json:"description,omitempty"`
+	GridPos     GrafanaGridPos     `json:"gridPos"`
+	Datasource  GrafanaDatasource  `json:"datasource"`
+	FieldConfig GrafanaFieldConfig `json:"fieldConfig"`
+	Options     map[string]any     `json:"options,omitempty"`
+	Targets     []GrafanaTarget    `json:"targets"`
+}
+
+type GrafanaVariable struct {
+	Name       string             `json:"name"`
+	Label      string             `json:"label,omitempty"`
+	Type       string             `json:"type"`
+	Query      string             `json:"query"`
+	Datasource *GrafanaDatasource `json:"datasource,omitempty"`
+	Multi      bool               `json:"multi,omitempty"`
+	IncludeAll bool               `json:"includeAll,omitempty"`
+	// Refresh 2 reloads the values when the time range changes
+	Refresh int `json:"refresh,omitempty"`
+}
+
+type GrafanaTemplating struct {
+	List []GrafanaVariable `json:"list"`
+}
+
+type GrafanaTimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type GrafanaDashboard struct {
+	UID           string            `json:"uid"`
+	Title         string            `json:"title"`
+	Tags          []string          `json:"tags"`
+	SchemaVersion int               `json:"schemaVersion"`
+	Refresh       string            `json:"refresh"`
+	Time          GrafanaTimeRange  `json:"time"`
+	Templating    GrafanaTemplating `json:"templating"`
+	Panels        []GrafanaPanel    `json:"panels"`
+}
+
+// dashboardDatasource is the Prometheus picked in the dashboard's datasource
+// variable
+var dashboardDatasource = GrafanaDatasource{Type: "prometheus", UID: "${datasource}"}
+
+// instanceSelector narrows a collector metric to the instances picked in the
+// dashboard's instance variable
+func instanceSelector(metric string) string {
+	return metric + `{instance=~"$instance"}`
+}
+
+func percentRange() GrafanaFieldDefaults {
+	min, max := 0.0, 100.0
+	return GrafanaFieldDefaults{Unit: "percent", Min: &min, Max: &max}
+}
+
+// NewGrafanaDashboard builds the dashboard from the names the exporter writes,
+// so renaming a metric renames it in the panels too.
+func NewGrafanaDashboard(title string) *GrafanaDashboard {
+	panels := []GrafanaPanel{
+		{
+			Type:        "timeseries",
+			Title:       "Average vs adjusted CPU usage",
+			Description: "The average usage top reports, and the usage adjusted for busy SMT siblings RCPU is computed from. The gap is the capacity the average hides.",
+			GridPos:     GrafanaGridPos{H: 8, W: 12, X: 0, Y: 0},
+			FieldConfig: GrafanaFieldConfig{Defaults: percentRange()},
+			Targets: []GrafanaTarget{
+				{RefID: "A", Expr: instanceSelector(metricAvgCPUUsage), LegendFormat: "{{instance}} average"},
+				{RefID: "B", Expr: instanceSelector(metricAdjustedCPUUsage), LegendFormat: "{{instance}} adjusted"},
+			},
+		},
+		{
+			Type:        "timeseries",
+			Title:       "Busy-sibling overlap",
+			Description: "Share of the physical cores' capacity both SMT siblings were busy at once.",
+			GridPos:     GrafanaGridPos{H: 8, W: 12, X: 12, Y: 0},
+			FieldConfig: GrafanaFieldConfig{Defaults: percentRange()},
+			Targets: []GrafanaTarget{
+				{RefID: "A", Expr: instanceSelector(metricSiblingOverlap), LegendFormat: "{{instance}}"},
+			},
+		},
+		{
+			Type:        "heatmap",
+			Title:       "Per-core usage",
+			Description: "How busy every physical core is, as its busiest thread. Needs the collector's -metrics-per-core.",
+			GridPos:     GrafanaGridPos{H: 10, W: 24, X: 0, Y: 8},
+			FieldConfig: GrafanaFieldConfig{Defaults: GrafanaFieldDefaults{Unit: "percent"}},
+			// Every series is a row of its own, the values are the colors
+			Options: map[string]any{
+				"calculate": false,
+				"color":     map[string]any{"mode": "scheme", "scheme": "RdYlGn", "reverse": true, "min": 0, "max": 100},
+				"yAxis":     map[string]any{"axisPlacement": "left"},
+			},
+			Targets: []GrafanaTarget{
+				{RefID: "A", Expr: instanceSelector(metricCoreBusy), LegendFormat: "{{instance}} core {{core}}"},
+			},
+		},
+		{
+			Type:        "timeseries",
+			Title:       "Remaining CPU and free cores",
+			Description: "RCPU, and the physical cores idle on all their threads.",
+			GridPos:     GrafanaGridPos{H: 8, W: 12, X: 0, Y: 18},
+			FieldConfig: GrafanaFieldConfig{Defaults: GrafanaFieldDefaults{Unit: "short"}},
+			Targets: []GrafanaTarget{
+				{RefID: "A", Expr: instanceSelector(metricRemainingCPU), LegendFormat: "{{instance}} RCPU %"},
+				{RefID: "B", Expr: instanceSelector(metricFreeCores), LegendFormat: "{{instance}} free cores"},
+			},
+		},
+		{
+			Type:        "timeseries",
+			Title:       "Annotation age",
+			Description: "Age of the node annotations the scheduler plugin filtered on, from the scheduler's metrics.",
+			GridPos:     GrafanaGridPos{H: 8, W: 12, X: 12, Y: 18},
+			FieldConfig: GrafanaFieldConfig{Defaults: GrafanaFieldDefaults{Unit: "s"}},
+			Targets: []GrafanaTarget{
+				{RefID: "A", Expr: fmt.Sprintf("histogram_quantile(0.5, sum by (le) (rate(%s_bucket[5m])))", schedulerAnnotationAgeMetric), LegendFormat: "p50"},
+				{RefID: "B", Expr: fmt.Sprintf("histogram_quantile(0.99, sum by (le) (rate(%s_bucket[5m])))", schedulerAnnotationAgeMetric), LegendFormat: "p99"},
+			},
+		},
+	}
+
+	for i := range panels {
+		panels[i].ID = i + 1
+		panels[i].Datasource = dashboardDatasource
+	}
+
+	return &GrafanaDashboard{
+		UID:           "rcpu",
+		Title:         title,
+		Tags:          []string{"rcpu"},
+		SchemaVersion: grafanaSchemaVersion,
+		Refresh:       "30s",
+		Time:          GrafanaTimeRange{From: "now-6h", To: "now"},
+		Templating: GrafanaTemplating{List: []GrafanaVariable{
+			{Name: "datasource", Label: "Data source", Type: "datasource", Query: "prometheus"},
+			{
+				Name:       "instance",
+				Label:      "Instance",
+				Type:       "query",
+				Query:      fmt.Sprintf("label_values(%s, instance)", metricAdjustedCPUUsage),
+				Datasource: &dashboardDatasource,
+				Multi:      true,
+				IncludeAll: true,
+				Refresh:    2,
+			},
+		}},
+		Panels: panels,
+	}
+}
+
+func ValidateDashboardFormat(format string) error {
+	for _, f := range dashboardFormats {
+		if format == f {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unsupported dashboard format %q, expected one of %v", format, dashboardFormats)
+}
+
+// WriteDashboard writes the dashboard in the format, ready to import.
+func WriteDashboard(w io.Writer, format, title string) error {
+	if err := ValidateDashboardFormat(format); err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(NewGrafanaDashboard(title), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
+
+func RunDashboard(args []string) error {
+	fs := flag.NewFlagSet("dashboard", flag.ExitOnError)
+	format := fs.String("format", DashboardFormatGrafana, "dashboard format, only grafana")
+	title := fs.String("title", DefaultDashboardTitle, "title of the dashboard")
+	fs.Parse(args)
+
+	return WriteDashboard(os.Stdout, *format, *title)
+}
@@ -0,0 +1,57 @@
+package rcpu
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// TestDashboardMetrics checks every collector metric the dashboard queries is
+// one the exporter writes.
+func TestDashboardMetrics(t *testing.T) {
+	freeCores := 2
+	exporter := NewMetricsExporter(MachineInfo{CPUs: 4, Cores: 2, Sockets: 1})
+	exporter.Update(&Sample{Node: "node-1", CPUs: 4, Cores: 2, FreeCores: &freeCores, AvgCPUUsage: 30, AdjustedCPUUsage: 50})
+	exporter.UpdateCoreUsages([]CoreUsage{{CoreId: 0, CPUs: []int32{0, 2}, Busy: 60}, {CoreId: 1, CPUs: []int32{1, 3}, Busy: 40}})
+
+	var metrics bytes.Buffer
+	exporter.WriteMetrics(&metrics, false)
+	written := make(map[string]bool)
+	for _, line := range strings.Split(metrics.String(), "\n") {
+		if fields := strings.Fields(line); len(fields) == 4 && fields[1] == "TYPE" {
+			written[fields[2]] = true
+		}
+	}
+
+	var out bytes.Buffer
+	if err := WriteDashboard(&out, DashboardFormatGrafana, DefaultDashboardTitle); err != nil {
+		t.Fatal(err)
+	}
+
+	var dashboard GrafanaDashboard
+	if err := json.Unmarshal(out.Bytes(), &dashboard); err != nil {
+		t.Fatalf("invalid dashboard JSON: %v", err)
+	}
+
+	metricName := regexp.MustCompile(`rcpu_[a-z0-9_]+`)
+	for _, panel := range dashboard.Panels {
+		for _, target := range panel.Targets {
+			for _, name := range metricName.FindAllString(target.Expr, -1) {
+				if strings.HasPrefix(name, "rcpu_scheduler_") {
+					continue
+				}
+				if !written[name] {
+					t.Errorf("panel %q queries %s, which the exporter doesn't write", panel.Title, name)
+				}
+			}
+		}
+	}
+}
+
+func TestDashboardFormat(t *testing.T) {
+	if err := WriteDashboard(&bytes.Buffer{}, "kibana", DefaultDashboardTitle); err == nil {
+		t.Error("expected an unsupported format to fail")
+	}
+}
@@ -0,0 +1,199 @@
+package rcpu
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/aquasecurity/table"
+	"github.com/liamg/tml"
+)
+
+const DefaultDisplayRows = 20
+
+// Display shows the samples, AddRow takes tml formatted cells.
+type Display interface {
+	AddRow(cells ...string)
+	// Reset drops the rows, for views redrawn from scratch every tick
+	Reset()
+	Render() error
+}
+
+// UseColor follows https://no-color.org, and only colors terminals.
+func UseColor(noColor bool, f *os.File) bool {
+	if noColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// logsShareTerminal reports whether the log lines end up on the terminal w
+// writes to, stdout and stderr are usually the same terminal.
+func logsShareTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok || log.Writer() != io.Writer(os.Stderr) {
+		return false
+	}
+
+	info, err := f.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+
+	stderr, err := os.Stderr.Stat()
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(info, stderr)
+}
+
+var tmlTag = regexp.MustCompile(`</?[a-z]+>`)
+
+// Sprintf formats a cell with tml markup, dropping the markup without color.
+func Sprintf(color bool, format string, args ...interface{}) string {
+	if color {
+		return tml.Sprintf(format, args...)
+	}
+
+	return fmt.Sprintf(tmlTag.ReplaceAllString(format, ""), args...)
+}
+
+// TableDisplay keeps the most recent rows in a ring and redraws the table in
+// place, moving the cursor back over the previous drawing instead of clearing
+// the screen, so memory stays bounded and the scrollback survives.
+//
+// Anything else written to the terminal in between, e.g. the logs on stderr,
+// would leave stale copies of the table behind, so when the logs go to the
+// same terminal every drawing is appended below the previous one instead.
+type TableDisplay struct {
+	w         io.Writer
+	headers   []string
+	alignment []table.Alignment
+
+	rows [][]string
+	next int
+	full bool
+
+	buf     bytes.Buffer
+	lines   int
+	inPlace bool
+}
+
+func NewTableDisplay(w io.Writer, maxRows int, headers ...string) *TableDisplay {
+	return &TableDisplay{
+		w:       w,
+		headers: headers,
+		rows:    make([][]string, max(1, maxRows)),
+		inPlace: !logsShareTerminal(w),
+	}
+}
+
+func (d *TableDisplay) SetAlignment(alignment ...table.Alignment) {
+	d.alignment = alignment
+}
+
+// AddRow replaces the oldest row once the ring is full.
+func (d *TableDisplay) AddRow(cells ...string) {
+	d.rows[d.next] = cells
+	d.next++
+	if d.next == len(d.rows) {
+		d.next = 0
+		d.full = true
+	}
+}
+
+func (d *TableDisplay) Reset() {
+	d.next = 0
+	d.full = false
+}
+
+func (d *TableDisplay) Render() error {
+	d.buf.Reset()
+
+	tbl := table.New(&d.buf)
+	tbl.SetBorders(true)
+	tbl.SetHeaderStyle(table.StyleBold)
+	tbl.SetLineStyle(table.StyleBlue)
+	tbl.SetDividers(table.UnicodeRoundedDividers)
+	tbl.SetHeaders(d.headers...)
+	tbl.SetAlignment(d.alignment...)
+
+	// Oldest first
+	if d.full {
+		for _, row := range d.rows[d.next:] {
+			tbl.AddRow(row...)
+		}
+	}
+	for _, row := range d.rows[:d.next] {
+		tbl.AddRow(row...)
+	}
+	tbl.Render()
+
+	if !d.inPlace {
+		_, err := d.w.Write(d.buf.Bytes())
+		return err
+	}
+
+	// Back to the first line of the previous drawing, then clear what's left
+	// below the new one in case it got shorter
+	if d.lines > 0 {
+		fmt.Fprintf(d.w, "\033[%dF", d.lines)
+	}
+	d.lines = bytes.Count(d.buf.Bytes(), []byte("\n"))
+	d.buf.WriteString("\033[J")
+
+	_, err := d.w.Write(d.buf.Bytes())
+
+	return err
+}
+
+// PlainDisplay appends every row as a line of plain text, for logs and
+// files where escape sequences would be noise.
+type PlainDisplay struct {
+	w       io.Writer
+	headers []string
+	rows    [][]string
+	started bool
+}
+
+func NewPlainDisplay(w io.Writer, headers ...string) *PlainDisplay {
+	return &PlainDisplay{w: w, headers: headers}
+}
+
+func (d *PlainDisplay) AddRow(cells ...string) {
+	d.rows = append(d.rows, cells)
+}
+
+func (d *PlainDisplay) Reset() {
+	d.rows = d.rows[:0]
+}
+
+func (d *PlainDisplay) Render() error {
+	if !d.started {
+		if _, err := fmt.Fprintln(d.w, strings.Join(d.headers, "\t")); err != nil {
+			return err
+		}
+		d.started = true
+	}
+
+	// Rows are only printed once
+	for _, row := range d.rows {
+		if _, err := fmt.Fprintln(d.w, strings.Join(row, "\t")); err != nil {
+			return err
+		}
+	}
+	d.Reset()
+
+	return nil
+}
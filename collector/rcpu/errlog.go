@@ -0,0 +1,198 @@
+package rcpu
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultErrorLogInterval = time.Minute
+
+// Error classes counted by ErrorLimiter and exported as metrics.
+const (
+	ErrorClassStatParse    = "stat_parse"
+	ErrorClassCounterReset = "counter_reset"
+	ErrorClassZeroPeriod   = "zero_period"
+	ErrorClassNFDWrite     = "nfd_write"
+	ErrorClassLoadAvg      = "loadavg"
+
+	ErrorClassCgroupRead       = "cgroup_read"
+	ErrorClassCgroupDivergence = "cgroup_divergence"
+	ErrorClassFrequency        = "frequency"
+	ErrorClassResctrl          = "resctrl"
+	ErrorClassPerf             = "perf"
+	ErrorClassPush             = "push"
+	ErrorClassPodResources     = "pod_resources"
+	ErrorClassCRI              = "cri"
+	ErrorClassRemote           = "remote"
+	ErrorClassSubscriber       = "subscriber"
+)
+
+// Warning classes are conditions of the machine rather than errors of the
+// collector, counted apart from the errors.
+const (
+	WarningClassSteal    = "steal"
+	WarningClassDerating = "derating"
+	WarningClassTopology = "topology"
+)
+
+type errorClass struct {
+	warning    bool
+	last       time.Time
+	suppressed int
+	// latest is the latest suppressed message, repeated in the summary
+	latest string
+	total  uint64
+}
+
+// ErrorLimiter logs the first error of a class and then at most one per
+// interval, summarizing how often it repeated in between, so a kernel the
+// parser chokes on doesn't flood the logs every tick. Run summarizes a burst
+// that stopped, which no later error would.
+type ErrorLimiter struct {
+	interval time.Duration
+
+	mu      sync.Mutex
+	classes map[string]*errorClass
+}
+
+func NewErrorLimiter(interval time.Duration) *ErrorLimiter {
+	l := &ErrorLimiter{
+		interval: interval,
+		classes:  make(map[string]*errorClass),
+	}
+
+	// Known classes are exported as zero before their first error
+	for _, class := range []string{ErrorClassStatParse, ErrorClassCounterReset, ErrorClassNFDWrite, ErrorClassLoadAvg} {
+		l.classes[class] = &errorClass{}
+	}
+	for _, class := range []string{WarningClassSteal, WarningClassDerating, WarningClassTopology} {
+		l.classes[class] = &errorClass{warning: true}
+	}
+
+	return l
+}
+
+// Log logs an error of the class.
+func (l *ErrorLimiter) Log(class string, format string, args ...interface{}) {
+	l.log(class, false, format, args...)
+}
+
+// Warn logs a warning of the class, limited the same way as errors.
+func (l *ErrorLimiter) Warn(class string, format string, args ...interface{}) {
+	l.log(class, true, format, args...)
+}
+
+func (l *ErrorLimiter) log(class string, warning bool, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c, ok := l.classes[class]
+	if !ok {
+		c = &errorClass{warning: warning}
+		l.classes[class] = c
+	}
+	c.total++
+
+	now := time.Now()
+	if !c.last.IsZero() && now.Sub(c.last) < l.interval {
+		c.suppressed++
+		c.latest = fmt.Sprintf(format, args...)
+		return
+	}
+
+	if c.suppressed > 0 {
+		log.Printf(format+" (repeated %d times in the last %v)", append(args, c.suppressed, now.Sub(c.last).Round(time.Second))...)
+	} else {
+		log.Printf(format, args...)
+	}
+	c.last = now
+	c.suppressed = 0
+	c.latest = ""
+}
+
+// Flush summarizes the classes which repeated since they were last logged
+// and have been quiet for an interval since.
+func (l *ErrorLimiter) Flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for _, c := range l.classes {
+		if c.suppressed == 0 || now.Sub(c.last) < l.interval {
+			continue
+		}
+
+		log.Printf("%s (repeated %d times in the last %v)", c.latest, c.suppressed, now.Sub(c.last).Round(time.Second))
+		c.last = now
+		c.suppressed = 0
+		c.latest = ""
+	}
+}
+
+// Run flushes the summaries every interval until ctx is done.
+func (l *ErrorLimiter) Run(ctx context.Context) {
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.Flush()
+		}
+	}
+}
+
+// Err summarizes the errors counted so far, by class, wrapping
+// ErrCollectionErrors. It is nil without any, warnings don't count.
+func (l *ErrorLimiter) Err() error {
+	var classes []string
+	for _, count := range l.Counts() {
+		if count.Total > 0 {
+			classes = append(classes, fmt.Sprintf("%s %d", count.Class, count.Total))
+		}
+	}
+
+	if len(classes) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s", ErrCollectionErrors, strings.Join(classes, ", "))
+}
+
+// ErrorCount is the number of errors of a class since startup.
+type ErrorCount struct {
+	Class string
+	Total uint64
+}
+
+// Counts returns the errors of every class.
+func (l *ErrorLimiter) Counts() []ErrorCount {
+	return l.counts(false)
+}
+
+// WarningCounts returns the warnings of every class.
+func (l *ErrorLimiter) WarningCounts() []ErrorCount {
+	return l.counts(true)
+}
+
+func (l *ErrorLimiter) counts(warning bool) []ErrorCount {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	counts := make([]ErrorCount, 0, len(l.classes))
+	for class, c := range l.classes {
+		if c.warning == warning {
+			counts = append(counts, ErrorCount{Class: class, Total: c.total})
+		}
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].Class < counts[j].Class })
+
+	return counts
+}
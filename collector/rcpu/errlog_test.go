@@ -0,0 +1,69 @@
+package rcpu
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestErrorLimiterFlushesStoppedBurst(t *testing.T) {
+	var buf bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&buf)
+
+	l := NewErrorLimiter(10 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		l.Log(ErrorClassStatParse, "skipping sample %d", i)
+	}
+	l.Warn(WarningClassSteal, "stolen")
+
+	// The burst stopped, no later error brings the summary
+	l.Flush()
+	if strings.Contains(buf.String(), "repeated") {
+		t.Fatalf("expected no summary within the interval, got %q", buf.String())
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	l.Flush()
+	if want := "skipping sample 2 (repeated 2 times"; !strings.Contains(buf.String(), want) {
+		t.Errorf("expected %q in %q", want, buf.String())
+	}
+
+	for _, count := range l.Counts() {
+		if count.Class == WarningClassSteal {
+			t.Errorf("expected the steal warning apart from the errors")
+		}
+	}
+	for _, count := range l.WarningCounts() {
+		if count.Class == WarningClassSteal && count.Total != 1 {
+			t.Errorf("expected 1 steal warning, got %d", count.Total)
+		}
+	}
+}
+
+func TestErrorLimiterErr(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	l := NewErrorLimiter(time.Minute)
+	l.Warn(WarningClassSteal, "stolen")
+	if err := l.Err(); err != nil {
+		t.Errorf("expected no error for a warning, got %v", err)
+	}
+
+	l.Log(ErrorClassStatParse, "skipping sample")
+	l.Log(ErrorClassStatParse, "skipping sample")
+	l.Log(ErrorClassPush, "failed to push")
+
+	err := l.Err()
+	if !errors.Is(err, ErrCollectionErrors) {
+		t.Fatalf("expected ErrCollectionErrors, got %v", err)
+	}
+	if want := "push 1, stat_parse 2"; !strings.HasSuffix(err.Error(), want) {
+		t.Errorf("expected the counts by class, %q, got %q", want, err.Error())
+	}
+}
@@ -0,0 +1,24 @@
+package rcpu
+
+import (
+	"errors"
+
+	"solelab.tech/collector/cputime"
+)
+
+// Sentinel errors, wrapped with details, so callers can branch on the failure
+// mode with errors.Is instead of matching strings. NewSampler and Run return
+// them to the programs embedding the collector too.
+var (
+	ErrUnsupportedCPU      = errors.New("unsupported CPU")
+	ErrSMTDisabled         = errors.New("SMT is not enabled")
+	ErrUnsupportedTopology = errors.New("unsupported CPU topology")
+	ErrStatParse           = errors.New("failed to parse CPU times")
+	ErrCounterReset        = cputime.ErrCounterReset
+	ErrCPUsChanged         = errors.New("online CPUs changed")
+	ErrZeroPeriod          = cputime.ErrZeroPeriod
+	ErrRemoteDisconnected  = errors.New("remote session failed")
+	// ErrCollectionErrors reports the errors Run recovered from, e.g. the
+	// samples skipped, counted by class
+	ErrCollectionErrors = errors.New("errors while collecting")
+)
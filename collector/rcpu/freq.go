@@ -0,0 +1,121 @@
+package rcpu
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+
+	"solelab.tech/collector/internal/parse"
+)
+
+const (
+	// DefaultDeratingWarning is the derating factor below which the collector
+	// logs that the remaining CPU is worth fewer cycles than nominal
+	DefaultDeratingWarning = 0.95
+
+	// minBusyForFrequency is the busy time, in CPUs, the frequency of busy
+	// CPUs is averaged over at least, idle CPUs clocking down is no derating
+	minBusyForFrequency = 0.5
+)
+
+// FrequencyReader samples the CPU frequencies from cpufreq, to tell how much
+// a cycle of the remaining CPU is worth compared to the base clock.
+type FrequencyReader struct {
+	fsys    fs.FS
+	cpuIds  []int32
+	baseKHz uint64
+}
+
+// NewFrequencyReader finds the base frequency in cpufreq, which intel_pstate
+// exposes, or else in the model name. It fails without cpufreq.
+func NewFrequencyReader(h *Host, cpuInfos []CPUInfo, model string) (*FrequencyReader, error) {
+	if len(cpuInfos) == 0 {
+		return nil, fmt.Errorf("no CPUs")
+	}
+
+	r := &FrequencyReader{fsys: h.Sys}
+	for _, info := range cpuInfos {
+		r.cpuIds = append(r.cpuIds, info.CPUId)
+	}
+
+	name := r.cpufreqPath(r.cpuIds[0], "scaling_cur_freq")
+	if _, err := fs.Stat(h.Sys, name); err != nil {
+		return nil, fmt.Errorf("cpufreq is not available: %v", err)
+	}
+
+	if base, err := readSysInt(h.Sys, r.cpufreqPath(r.cpuIds[0], "base_frequency")); err == nil && base > 0 {
+		r.baseKHz = uint64(base)
+	} else if base, ok := parse.ModelBaseFrequency(model); ok {
+		r.baseKHz = base
+	} else {
+		return nil, fmt.Errorf("unknown base frequency of %s", model)
+	}
+
+	return r, nil
+}
+
+func (r *FrequencyReader) cpufreqPath(cpuId int32, name string) string {
+	return path.Join(SysCPUDir, fmt.Sprintf("cpu%d", cpuId), "cpufreq", name)
+}
+
+// Frequency is what the busy CPUs ran at over an interval, in kHz.
+type Frequency struct {
+	BaseKHz uint64
+	// BusyKHz is the frequency averaged over the CPUs weighted by their
+	// busy time, zero when the machine was too idle to tell
+	BusyKHz float64
+	// CapKHz is the lowest scaling_max_freq, a cap set by the administrator,
+	// a power limit or thermal throttling
+	CapKHz uint64
+}
+
+// Derating is the share of the nominal capacity a cycle of the remaining CPU
+// is worth, 1 unless the busy CPUs run below the base clock or the frequency
+// is capped below it.
+func (f *Frequency) Derating() float64 {
+	derating := 1.0
+	if f.BusyKHz > 0 {
+		derating = min(derating, f.BusyKHz/float64(f.BaseKHz))
+	}
+
+	if f.CapKHz > 0 {
+		derating = min(derating, float64(f.CapKHz)/float64(f.BaseKHz))
+	}
+
+	return derating
+}
+
+// Read samples the current frequencies, weighting every CPU by its busy time
+// in the periods.
+func (r *FrequencyReader) Read(cpuTimePeriods []CPUTimePeriod) (Frequency, error) {
+	freq := Frequency{BaseKHz: r.baseKHz}
+
+	var weighted, busy float64
+	for _, cpuId := range r.cpuIds {
+		cur, err := readSysInt(r.fsys, r.cpufreqPath(cpuId, "scaling_cur_freq"))
+		if err != nil {
+			return freq, fmt.Errorf("failed to read the frequency of CPU %d: %v", cpuId, err)
+		}
+
+		if capKHz, err := readSysInt(r.fsys, r.cpufreqPath(cpuId, "scaling_max_freq")); err == nil && capKHz > 0 {
+			if freq.CapKHz == 0 || uint64(capKHz) < freq.CapKHz {
+				freq.CapKHz = uint64(capKHz)
+			}
+		}
+
+		p := &cpuTimePeriods[cpuId]
+		if p.TotalPeriod == 0 {
+			continue
+		}
+
+		b := 1 - float64(p.TotalIdlePeriod)/float64(p.TotalPeriod)
+		weighted += b * float64(cur)
+		busy += b
+	}
+
+	if busy >= minBusyForFrequency {
+		freq.BusyKHz = weighted / busy
+	}
+
+	return freq, nil
+}
@@ -0,0 +1,162 @@
+package rcpu
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+const HeatmapCoresPerLine = 16
+
+var heatmapLevels = []struct {
+	below float64
+	cell  string
+	color string
+}{
+	{10, "·", "green"},
+	{35, "░", "green"},
+	{60, "▒", "yellow"},
+	{85, "▓", "red"},
+	{101, "█", "red"},
+}
+
+// Heatmap shows one cell per logical CPU shaded by its busy time, cores
+// grouped by socket with the siblings of a core next to each other, so cores
+// with both threads loaded stand out.
+type Heatmap struct {
+	w          io.Writer
+	color      bool
+	timeFormat TimeFormat
+
+	socketIds []int32
+	// cores of every socket, each the CPU IDs of its threads
+	sockets [][][]int32
+
+	buf     bytes.Buffer
+	lines   int
+	inPlace bool
+}
+
+func NewHeatmap(w io.Writer, color bool, timeFormat TimeFormat, cpuInfos []CPUInfo, coreToCpus map[int32][]int32) *Heatmap {
+	coreSocket := make(map[int32]int32)
+	for _, info := range cpuInfos {
+		coreSocket[info.CoreId] = info.SocketId
+	}
+
+	// Logs on the same terminal would leave stale copies behind, see
+	// TableDisplay
+	h := &Heatmap{w: w, color: color, timeFormat: timeFormat, inPlace: color && !logsShareTerminal(w)}
+	socketIndex := make(map[int32]int)
+	for _, coreId := range NewCoreIds(coreToCpus) {
+		socketId := coreSocket[coreId]
+		i, ok := socketIndex[socketId]
+		if !ok {
+			i = len(h.socketIds)
+			socketIndex[socketId] = i
+			h.socketIds = append(h.socketIds, socketId)
+			h.sockets = append(h.sockets, nil)
+		}
+
+		cpus := append([]int32(nil), coreToCpus[coreId]...)
+		sort.Slice(cpus, func(a, b int) bool { return cpus[a] < cpus[b] })
+		h.sockets[i] = append(h.sockets[i], cpus)
+	}
+
+	return h
+}
+
+func (h *Heatmap) cell(busy float64) string {
+	for _, level := range heatmapLevels {
+		if busy < level.below {
+			return Sprintf(h.color, "<"+level.color+">"+level.cell+"</"+level.color+">")
+		}
+	}
+
+	return ""
+}
+
+func (h *Heatmap) Render(now time.Time, avgCPUUsage, adjustedCPUUsage float64, load [3]float64, cpuTimePeriods []CPUTimePeriod) error {
+	h.buf.Reset()
+
+	fmt.Fprintf(&h.buf, "%s  avg %.2f%%  adjusted %.2f%%  load %.2f %.2f %.2f\n",
+		h.timeFormat.Format(now), avgCPUUsage, adjustedCPUUsage, load[0], load[1], load[2])
+	for i, cores := range h.sockets {
+		for lo := 0; lo < len(cores); lo += HeatmapCoresPerLine {
+			if lo == 0 {
+				fmt.Fprintf(&h.buf, "socket %-3d", h.socketIds[i])
+			} else {
+				fmt.Fprintf(&h.buf, "%-10s", "")
+			}
+
+			for _, cpus := range cores[lo:min(len(cores), lo+HeatmapCoresPerLine)] {
+				h.buf.WriteByte(' ')
+				for _, cpuId := range cpus {
+					p := &cpuTimePeriods[cpuId]
+					h.buf.WriteString(h.cell(busyPercent(p.TotalPeriod, p.TotalIdlePeriod)))
+				}
+			}
+			h.buf.WriteByte('\n')
+		}
+	}
+
+	h.buf.WriteString("busy ")
+	for i := range heatmapLevels {
+		lower := 0.0
+		if i > 0 {
+			lower = heatmapLevels[i-1].below
+		}
+		fmt.Fprintf(&h.buf, " %s %.0f%%+", h.cell(lower), lower)
+	}
+	h.buf.WriteByte('\n')
+
+	// Redraw in place on terminals, append otherwise like PlainDisplay
+	lines := bytes.Count(h.buf.Bytes(), []byte("\n"))
+	if h.inPlace {
+		if h.lines > 0 {
+			fmt.Fprintf(h.w, "\033[%dF", h.lines)
+		}
+		h.buf.WriteString("\033[J")
+	}
+	h.lines = lines
+
+	_, err := h.w.Write(h.buf.Bytes())
+
+	return err
+}
+
+// heatmapSink renders the heatmap to stdout, laid out again whenever the
+// topology changes.
+type heatmapSink struct {
+	heatmap    *Heatmap
+	detection  *Detection
+	color      bool
+	timeFormat TimeFormat
+}
+
+func newHeatmapSink(ctx context.Context, arg string, config SinkConfig) (Sink, error) {
+	opts := config.Options
+
+	return &heatmapSink{color: UseColor(opts.NoColor, os.Stdout), timeFormat: opts.TimeFormat.Or(TimeFormatClock)}, nil
+}
+
+func (s *heatmapSink) Write(ctx context.Context, sample Sample) error {
+	if d := sample.Detection; d != s.detection {
+		s.heatmap = NewHeatmap(os.Stdout, s.color, s.timeFormat, d.CPUInfos, d.CoreToCPUs)
+		s.detection = d
+	}
+
+	load := [3]float64{sample.Load1, sample.Load5, sample.Load15}
+	if err := s.heatmap.Render(sample.Time, sample.AvgCPUUsage, sample.AdjustedCPUUsage, load, sample.CPUTimePeriods); err != nil {
+		return fmt.Errorf("failed to render: %v", err)
+	}
+
+	return nil
+}
+
+func (s *heatmapSink) Close() error {
+	return nil
+}
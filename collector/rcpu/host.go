@@ -0,0 +1,243 @@
+package rcpu
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"solelab.tech/collector/internal/parse"
+)
+
+const (
+	ProcLoadAvgName = "loadavg"
+
+	SysCPUDir = "devices/system/cpu"
+
+	SysCgroupCPUStatPath = "fs/cgroup/cpu.stat"
+	SysCgroupCPUAcctPath = "fs/cgroup/cpuacct/cpuacct.usage"
+)
+
+// Host is where the collector reads procfs and sysfs from, so alternate roots,
+// e.g. the host's /proc mounted into a container, or in-memory fixtures such
+// as fstest.MapFS can stand in for the real files.
+type Host struct {
+	Proc fs.FS
+	Sys  fs.FS
+}
+
+func NewHost(procRoot, sysRoot string) *Host {
+	return &Host{
+		Proc: os.DirFS(procRoot),
+		Sys:  os.DirFS(sysRoot),
+	}
+}
+
+func (h *Host) CPUModel() (string, error) {
+	f, err := h.Proc.Open(ProcCPUInfoName)
+	if err != nil {
+		return "unknown", fmt.Errorf("failed to open %s: %v", ProcCPUInfoName, err)
+	}
+	defer f.Close()
+
+	model, err := parse.CPUInfoModel(f)
+	if err != nil {
+		return "unknown", fmt.Errorf("failed to read %s: %v", ProcCPUInfoName, err)
+	}
+
+	return model, nil
+}
+
+func (h *Host) SMTEnabled() (bool, error) {
+	out, err := fs.ReadFile(h.Sys, SysCPUSMTActivePath)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %v", SysCPUSMTActivePath, err)
+	}
+
+	return strings.TrimSpace(string(out)) == "1", nil
+}
+
+// MemoryBytes returns MemTotal from /proc/meminfo.
+func (h *Host) MemoryBytes() (uint64, error) {
+	f, err := h.Proc.Open(ProcMemInfoName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %s: %v", ProcMemInfoName, err)
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		items := strings.Fields(s.Text())
+		if len(items) < 2 || items[0] != "MemTotal:" {
+			continue
+		}
+
+		kb, err := strconv.ParseUint(items[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse MemTotal in %s: %v", ProcMemInfoName, err)
+		}
+
+		return kb * 1024, nil
+	}
+
+	if err := s.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read %s: %v", ProcMemInfoName, err)
+	}
+
+	return 0, fmt.Errorf("failed to find MemTotal in %s", ProcMemInfoName)
+}
+
+// LoadAvg returns the 1, 5 and 15 minute load averages.
+func (h *Host) LoadAvg() ([3]float64, error) {
+	out, err := fs.ReadFile(h.Proc, ProcLoadAvgName)
+	if err != nil {
+		return [3]float64{}, fmt.Errorf("failed to read %s: %v", ProcLoadAvgName, err)
+	}
+
+	return parse.LoadAvg(out)
+}
+
+// CgroupCPUUsage returns the busy time of all CPUs accounted to the root
+// cgroup, from cpu.stat on cgroup v2 and cpuacct.usage on v1.
+func (h *Host) CgroupCPUUsage() (time.Duration, error) {
+	if out, err := fs.ReadFile(h.Sys, SysCgroupCPUStatPath); err == nil {
+		usec, err := parse.CgroupCPUStatUsage(out)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse %s: %v", SysCgroupCPUStatPath, err)
+		}
+
+		return time.Duration(usec) * time.Microsecond, nil
+	}
+
+	out, err := fs.ReadFile(h.Sys, SysCgroupCPUAcctPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s or %s: %v", SysCgroupCPUStatPath, SysCgroupCPUAcctPath, err)
+	}
+
+	nsec, err := parse.CgroupCPUAcctUsage(out)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %v", SysCgroupCPUAcctPath, err)
+	}
+
+	return time.Duration(nsec), nil
+}
+
+func readSysInt(fsys fs.FS, name string) (int32, error) {
+	out, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return 0, err
+	}
+
+	v, err := parse.SysInt(out)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %v", name, err)
+	}
+
+	return v, nil
+}
+
+// Topology reads the CPU topology from sysfs, as an alternative to lscpu
+// which always looks at the real /sys. Like lscpu, core IDs are numbered
+// across sockets, sysfs numbers them per socket. Offline CPUs are left out.
+func (h *Host) Topology() ([]CPUInfo, error) {
+	entries, err := fs.ReadDir(h.Sys, SysCPUDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %v", SysCPUDir, err)
+	}
+
+	type coreKey struct {
+		socketId int32
+		coreId   int32
+	}
+
+	var cpuInfos []CPUInfo
+	var keys []coreKey
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, "cpu") {
+			continue
+		}
+
+		cpuId, err := strconv.ParseInt(name[3:], 10, 32)
+		if err != nil {
+			continue
+		}
+
+		dir := path.Join(SysCPUDir, name)
+		socketId, err := readSysInt(h.Sys, path.Join(dir, "topology/physical_package_id"))
+		if err != nil {
+			continue
+		}
+
+		coreId, err := readSysInt(h.Sys, path.Join(dir, "topology/core_id"))
+		if err != nil {
+			continue
+		}
+
+		// The NUMA node is only exposed as a nodeN link in the CPU's directory
+		var nodeId int32
+		if cpuEntries, err := fs.ReadDir(h.Sys, dir); err == nil {
+			for _, cpuEntry := range cpuEntries {
+				if id, err := strconv.ParseInt(strings.TrimPrefix(cpuEntry.Name(), "node"), 10, 32); err == nil && strings.HasPrefix(cpuEntry.Name(), "node") {
+					nodeId = int32(id)
+					break
+				}
+			}
+		}
+
+		cpuInfos = append(cpuInfos, CPUInfo{
+			CPUId:    int32(cpuId),
+			SocketId: socketId,
+			NodeId:   nodeId,
+		})
+		keys = append(keys, coreKey{socketId: socketId, coreId: coreId})
+	}
+
+	if len(cpuInfos) == 0 {
+		return nil, fmt.Errorf("%w: no CPUs in %s", ErrUnsupportedTopology, SysCPUDir)
+	}
+
+	sorted := make([]coreKey, len(keys))
+	copy(sorted, keys)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].socketId != sorted[j].socketId {
+			return sorted[i].socketId < sorted[j].socketId
+		}
+
+		return sorted[i].coreId < sorted[j].coreId
+	})
+
+	logicalIds := make(map[coreKey]int32)
+	for _, key := range sorted {
+		if _, ok := logicalIds[key]; !ok {
+			logicalIds[key] = int32(len(logicalIds))
+		}
+	}
+
+	for i := range cpuInfos {
+		cpuInfos[i].CoreId = logicalIds[keys[i]]
+	}
+
+	sortCPUInfos(cpuInfos)
+
+	return cpuInfos, nil
+}
+
+// reopen returns the file at its start, seeking if the file system allows,
+// e.g. os.DirFS, and opening it again otherwise
+func reopen(fsys fs.FS, name string, f fs.File) (fs.File, error) {
+	if seeker, ok := f.(io.Seeker); ok {
+		_, err := seeker.Seek(0, io.SeekStart)
+		return f, err
+	}
+
+	f.Close()
+
+	return fsys.Open(name)
+}
@@ -0,0 +1,88 @@
+package rcpu
+
+import (
+	"sort"
+)
+
+// CoreGroup is the cores of a socket or of a NUMA node.
+type CoreGroup struct {
+	Id    int32
+	Cores [][]int32
+}
+
+// NewCoreGroups groups the cores by the socket or node of their first CPU,
+// ordered by group ID. key picks the group of a CPU.
+func NewCoreGroups(cpuInfos []CPUInfo, coreToCpus map[int32][]int32, key func(info *CPUInfo) int32) []CoreGroup {
+	groupOf := make(map[int32]int32, len(cpuInfos))
+	for i := range cpuInfos {
+		groupOf[cpuInfos[i].CPUId] = key(&cpuInfos[i])
+	}
+
+	byId := make(map[int32]*CoreGroup)
+	for _, cpuIds := range NewCoreList(coreToCpus) {
+		id := groupOf[cpuIds[0]]
+		group, ok := byId[id]
+		if !ok {
+			group = &CoreGroup{Id: id}
+			byId[id] = group
+		}
+		group.Cores = append(group.Cores, cpuIds)
+	}
+
+	groups := make([]CoreGroup, 0, len(byId))
+	for _, group := range byId {
+		groups = append(groups, *group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Id < groups[j].Id })
+
+	return groups
+}
+
+func SocketOf(info *CPUInfo) int32 {
+	return info.SocketId
+}
+
+func NodeOf(info *CPUInfo) int32 {
+	return info.NodeId
+}
+
+// GroupUsage is the adjusted CPU usage of a socket or NUMA node.
+type GroupUsage struct {
+	Id               int32   `json:"id"`
+	AdjustedCPUUsage float64 `json:"adjusted_cpu_usage"`
+	// Cores is the number of physical cores of the group, the protobuf
+	// samples don't carry it
+	Cores int `json:"cores,omitempty"`
+}
+
+// DoGroupAdjustedCPUUsage computes the adjusted usage of every group.
+func DoGroupAdjustedCPUUsage(model SiblingModel, groups []CoreGroup, cpuTimePeriods []CPUTimePeriod) ([]GroupUsage, error) {
+	usages := make([]GroupUsage, 0, len(groups))
+	for _, group := range groups {
+		usage, err := model.AdjustedCPUUsage(group.Cores, cpuTimePeriods)
+		if err != nil {
+			return nil, err
+		}
+
+		usages = append(usages, GroupUsage{Id: group.Id, AdjustedCPUUsage: usage, Cores: len(group.Cores)})
+	}
+
+	return usages, nil
+}
+
+// Spread is how much busier the busiest group is than the idlest, in
+// adjusted percent. A scheduler balancing well keeps it low, it is zero with
+// a single group.
+func Spread(usages []GroupUsage) float64 {
+	if len(usages) == 0 {
+		return 0
+	}
+
+	lo, hi := usages[0].AdjustedCPUUsage, usages[0].AdjustedCPUUsage
+	for _, usage := range usages[1:] {
+		lo = min(lo, usage.AdjustedCPUUsage)
+		hi = max(hi, usage.AdjustedCPUUsage)
+	}
+
+	return hi - lo
+}
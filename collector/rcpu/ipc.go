@@ -0,0 +1,136 @@
+package rcpu
+
+const (
+	// ipcAlpha is the weight of a new interval in the moving averages
+	ipcAlpha = 0.2
+	// ipcMinBusy is how busy a thread must be for its IPC to count
+	ipcMinBusy = 0.2
+	// ipcMaxIdleSiblingBusy is how busy a sibling may be for the thread to
+	// count as running alone
+	ipcMaxIdleSiblingBusy = 0.05
+)
+
+// IPCSiblingModel weights the sibling overlap by the interference measured
+// with the hardware counters. Every thread learns its IPC running alone, and
+// every core how much that IPC degrades while both threads run. Without any
+// degradation SMT doubles the throughput, a core counts as the average of its
+// threads, and at half the IPC it yields nothing, a core counts as its
+// busiest thread like MaxSiblingModel. Cores not measured yet are counted
+// like MaxSiblingModel too.
+type IPCSiblingModel struct {
+	sampler *PerfSampler
+
+	// Indexed by CPU ID, zero while unknown
+	ipc  []float64
+	solo []float64
+	// degradation is indexed by the first CPU ID of the core, negative while
+	// unknown
+	degradation []float64
+}
+
+func NewIPCSiblingModel(sampler *PerfSampler, maxCPUId int32) *IPCSiblingModel {
+	m := &IPCSiblingModel{
+		sampler:     sampler,
+		ipc:         make([]float64, maxCPUId+1),
+		solo:        make([]float64, maxCPUId+1),
+		degradation: make([]float64, maxCPUId+1),
+	}
+
+	for i := range m.degradation {
+		m.degradation[i] = -1
+	}
+
+	return m
+}
+
+func movingAverage(avg, v float64) float64 {
+	if avg <= 0 {
+		return v
+	}
+
+	return avg + ipcAlpha*(v-avg)
+}
+
+// Update reads the IPC of the interval the periods cover and learns from it,
+// call it once per tick before AdjustedCPUUsage.
+func (m *IPCSiblingModel) Update(cores [][]int32, cpuTimePeriods []CPUTimePeriod) error {
+	if err := m.sampler.Read(m.ipc); err != nil {
+		return err
+	}
+
+	for _, cpuIds := range cores {
+		// Anomalous cores have no sibling to interfere with
+		if len(cpuIds) != 2 {
+			continue
+		}
+
+		busy := [2]float64{threadBusy(&cpuTimePeriods[cpuIds[0]]), threadBusy(&cpuTimePeriods[cpuIds[1]])}
+
+		for i, cpuId := range cpuIds {
+			if busy[i] >= ipcMinBusy && busy[1-i] <= ipcMaxIdleSiblingBusy && m.ipc[cpuId] > 0 {
+				m.solo[cpuId] = movingAverage(m.solo[cpuId], m.ipc[cpuId])
+			}
+		}
+
+		if busy[0] < ipcMinBusy || busy[1] < ipcMinBusy {
+			continue
+		}
+
+		var degradation float64
+		measured := true
+		for _, cpuId := range cpuIds {
+			if m.solo[cpuId] <= 0 || m.ipc[cpuId] <= 0 {
+				measured = false
+				break
+			}
+			degradation += max(0, 1-m.ipc[cpuId]/m.solo[cpuId]) / 2
+		}
+
+		if measured {
+			if m.degradation[cpuIds[0]] < 0 {
+				m.degradation[cpuIds[0]] = degradation
+			} else {
+				m.degradation[cpuIds[0]] += ipcAlpha * (degradation - m.degradation[cpuIds[0]])
+			}
+		}
+	}
+
+	return nil
+}
+
+// interference is how much of the overlap of the core's threads counts, 0
+// when SMT doubles its throughput and 1 when it yields nothing
+func (m *IPCSiblingModel) interference(cpuIds []int32) float64 {
+	degradation := m.degradation[cpuIds[0]]
+	if degradation < 0 {
+		return 1
+	}
+
+	return min(1, 2*degradation)
+}
+
+// Interference averages the interference over the measured cores, -1 while
+// none is measured.
+func (m *IPCSiblingModel) Interference(cores [][]int32) float64 {
+	var total float64
+	n := 0
+	for _, cpuIds := range cores {
+		if m.degradation[cpuIds[0]] >= 0 {
+			total += m.interference(cpuIds)
+			n++
+		}
+	}
+
+	if n == 0 {
+		return -1
+	}
+
+	return total / float64(n)
+}
+
+func (m *IPCSiblingModel) AdjustedCPUUsage(cores [][]int32, cpuTimePeriods []CPUTimePeriod) (float64, error) {
+	return combineCores(cores, cpuTimePeriods, func(cpuIds []int32, busy0, busy1 float64) float64 {
+		avg := (busy0 + busy1) / 2
+		return avg + m.interference(cpuIds)*(max(busy0, busy1)-avg)
+	})
+}
@@ -0,0 +1,59 @@
+package rcpu
+
+import "solelab.tech/collector/cputime"
+
+const (
+	DefaultIRQRatio = 0.5
+	// IRQMinShare is the share of the period a CPU must spend in interrupts
+	// to be considered IRQ-heavy, so a few interrupts on an idle CPU don't
+	// count
+	IRQMinShare = 0.05
+)
+
+// IRQHeavy reports whether interrupts make up at least ratio of the CPU's
+// busy time, like the housekeeping CPUs NICs steer their queues to. Such
+// CPUs are not really available to workloads.
+func IRQHeavy(p *CPUTimePeriod, ratio float64) bool {
+	busy := cputime.SaturatedSub(p.TotalPeriod, p.TotalIdlePeriod)
+	irq := p.IRQPeriod + p.SoftIRQPeriod
+	if busy == 0 || float64(irq) < IRQMinShare*float64(p.TotalPeriod) {
+		return false
+	}
+
+	return float64(irq) >= ratio*float64(busy)
+}
+
+// FindIRQCPUs appends the IRQ-heavy CPUs to dst, reusing its capacity.
+func FindIRQCPUs(dst []int32, cpuTimes []CPUTime, cpuTimePeriods []CPUTimePeriod, ratio float64) []int32 {
+	dst = dst[:0]
+	for i := range cpuTimes {
+		cpuId := cpuTimes[i].CPUId
+		if IRQHeavy(&cpuTimePeriods[cpuId], ratio) {
+			dst = append(dst, cpuId)
+		}
+	}
+
+	return dst
+}
+
+// ExcludeCores appends the cores without any of the given CPUs to dst, as the
+// adjusted formula needs whole cores the core of an IRQ-heavy CPU goes.
+func ExcludeCores(dst [][]int32, cores [][]int32, cpuIds []int32) [][]int32 {
+	dst = dst[:0]
+	for _, core := range cores {
+		excluded := false
+		for _, cpuId := range core {
+			for _, id := range cpuIds {
+				if cpuId == id {
+					excluded = true
+				}
+			}
+		}
+
+		if !excluded {
+			dst = append(dst, core)
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,132 @@
+package rcpu
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultLogMaxSize    = 100 * 1024 * 1024
+	DefaultLogMaxAge     = 24 * time.Hour
+	DefaultLogMaxBackups = 5
+)
+
+// RotatingFile is a log file that is rotated once it grows past MaxSize or
+// gets older than MaxAge, keeping MaxBackups old files as path.1, path.2 and
+// so on, for bare metal hosts without journald or a container runtime.
+type RotatingFile struct {
+	Path       string
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+
+	mu       sync.Mutex
+	f        *os.File
+	size     int64
+	openedAt time.Time
+}
+
+func NewRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*RotatingFile, error) {
+	r := &RotatingFile{
+		Path:       path,
+		MaxSize:    maxSize,
+		MaxAge:     maxAge,
+		MaxBackups: maxBackups,
+	}
+
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// open appends to an existing file, its age counts from its modification
+// time so restarts don't keep a file around forever
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", r.Path, err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat %s: %v", r.Path, err)
+	}
+
+	r.f = f
+	r.size = info.Size()
+	r.openedAt = time.Now()
+	if info.Size() > 0 {
+		r.openedAt = info.ModTime()
+	}
+
+	return nil
+}
+
+// rotate moves the file aside before closing it, a file open for writing can
+// be renamed, so the current file stays open and keeps taking writes when the
+// rename fails.
+func (r *RotatingFile) rotate() error {
+	if r.MaxBackups <= 0 {
+		if err := os.Remove(r.Path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %v", r.Path, err)
+		}
+	} else {
+		// Shift path.N-1 to path.N, dropping the oldest
+		for i := r.MaxBackups - 1; i >= 1; i-- {
+			from := fmt.Sprintf("%s.%d", r.Path, i)
+			if err := os.Rename(from, fmt.Sprintf("%s.%d", r.Path, i+1)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to rename %s: %v", from, err)
+			}
+		}
+
+		if err := os.Rename(r.Path, r.Path+".1"); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rename %s: %v", r.Path, err)
+		}
+	}
+
+	old := r.f
+	if err := r.open(); err != nil {
+		// Keep writing to the rotated file rather than losing the logs
+		return err
+	}
+
+	if err := old.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %v", r.Path, err)
+	}
+
+	return nil
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tooBig := r.MaxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.MaxSize
+	tooOld := r.MaxAge > 0 && time.Since(r.openedAt) > r.MaxAge
+	var rotateErr error
+	if tooBig || tooOld {
+		// A failed rotation is reported but the write still goes to the
+		// current file
+		rotateErr = r.rotate()
+	}
+
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+
+	return n, err
+}
+
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.f.Close()
+}
@@ -0,0 +1,61 @@
+package rcpu
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFileKeepsWritingWhenRenameFails(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "collector.log")
+
+	r, err := NewRotatingFile(path, 16, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// A non-empty directory in the way of path.1 fails the rename
+	if err := os.MkdirAll(filepath.Join(path+".1", "busy"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Write([]byte("first line\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Write([]byte("second line\n")); err == nil {
+		t.Error("expected the failed rotation to be reported")
+	}
+	if _, err := r.Write([]byte("third line\n")); err == nil {
+		t.Error("expected the failed rotation to be reported")
+	}
+
+	out, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(out), "line\n"); got != 3 {
+		t.Errorf("expected all 3 lines in %s, got %q", path, out)
+	}
+
+	// Once the way is clear the file rotates and the writes go on
+	if err := os.RemoveAll(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Write([]byte("fourth line\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "fourth line\n" {
+		t.Errorf("expected only the fourth line after the rotation, got %q", out)
+	}
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Errorf("expected a backup: %v", err)
+	}
+}
@@ -0,0 +1,240 @@
+package rcpu
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DefaultManifestNamespace      = "rcpu-system"
+	DefaultManifestServiceAccount = "rcpu-agent"
+)
+
+type ObjectMeta struct {
+	Name      string            `yaml:"name"`
+	Namespace string            `yaml:"namespace,omitempty"`
+	Labels    map[string]string `yaml:"labels,omitempty"`
+}
+
+type PolicyRule struct {
+	APIGroups []string `yaml:"apiGroups"`
+	Resources []string `yaml:"resources"`
+	Verbs     []string `yaml:"verbs"`
+}
+
+type RoleRef struct {
+	APIGroup string `yaml:"apiGroup"`
+	Kind     string `yaml:"kind"`
+	Name     string `yaml:"name"`
+}
+
+type Subject struct {
+	Kind      string `yaml:"kind"`
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+type ServiceAccount struct {
+	APIVersion string     `yaml:"apiVersion"`
+	Kind       string     `yaml:"kind"`
+	Metadata   ObjectMeta `yaml:"metadata"`
+}
+
+type ClusterRole struct {
+	APIVersion string       `yaml:"apiVersion"`
+	Kind       string       `yaml:"kind"`
+	Metadata   ObjectMeta   `yaml:"metadata"`
+	Rules      []PolicyRule `yaml:"rules"`
+}
+
+type ClusterRoleBinding struct {
+	APIVersion string     `yaml:"apiVersion"`
+	Kind       string     `yaml:"kind"`
+	Metadata   ObjectMeta `yaml:"metadata"`
+	RoleRef    RoleRef    `yaml:"roleRef"`
+	Subjects   []Subject  `yaml:"subjects"`
+}
+
+type ResourceRule struct {
+	APIGroups   []string `yaml:"apiGroups"`
+	APIVersions []string `yaml:"apiVersions"`
+	Operations  []string `yaml:"operations"`
+	Resources   []string `yaml:"resources"`
+}
+
+type MatchResources struct {
+	ResourceRules []ResourceRule `yaml:"resourceRules"`
+}
+
+type MatchCondition struct {
+	Name       string `yaml:"name"`
+	Expression string `yaml:"expression"`
+}
+
+type Validation struct {
+	Expression string `yaml:"expression"`
+	Message    string `yaml:"message"`
+}
+
+type ValidatingAdmissionPolicySpec struct {
+	FailurePolicy    string           `yaml:"failurePolicy"`
+	MatchConstraints MatchResources   `yaml:"matchConstraints"`
+	MatchConditions  []MatchCondition `yaml:"matchConditions"`
+	Validations      []Validation     `yaml:"validations"`
+}
+
+type ValidatingAdmissionPolicy struct {
+	APIVersion string                        `yaml:"apiVersion"`
+	Kind       string                        `yaml:"kind"`
+	Metadata   ObjectMeta                    `yaml:"metadata"`
+	Spec       ValidatingAdmissionPolicySpec `yaml:"spec"`
+}
+
+type ValidatingAdmissionPolicyBindingSpec struct {
+	PolicyName        string   `yaml:"policyName"`
+	ValidationActions []string `yaml:"validationActions"`
+}
+
+type ValidatingAdmissionPolicyBinding struct {
+	APIVersion string                               `yaml:"apiVersion"`
+	Kind       string                               `yaml:"kind"`
+	Metadata   ObjectMeta                           `yaml:"metadata"`
+	Spec       ValidatingAdmissionPolicyBindingSpec `yaml:"spec"`
+}
+
+type ManifestOptions struct {
+	Namespace      string
+	ServiceAccount string
+}
+
+func manifestLabels() map[string]string {
+	return map[string]string{"app.kubernetes.io/name": "rcpu"}
+}
+
+// NewAgentRBAC returns the minimal permissions of the node agent. Nodes are
+// cluster scoped, so RBAC can only grant patch on every node, the admission
+// policy of NewAgentNodePolicy narrows it down to the agent's own node. The
+// agent never reads, lists or updates nodes. Events are needed for the
+// overload transition events.
+func NewAgentRBAC(opts ManifestOptions) []any {
+	sa := &ServiceAccount{
+		APIVersion: "v1",
+		Kind:       "ServiceAccount",
+		Metadata: ObjectMeta{
+			Name:      opts.ServiceAccount,
+			Namespace: opts.Namespace,
+			Labels:    manifestLabels(),
+		},
+	}
+
+	role := &ClusterRole{
+		APIVersion: "rbac.authorization.k8s.io/v1",
+		Kind:       "ClusterRole",
+		Metadata: ObjectMeta{
+			Name:   opts.ServiceAccount,
+			Labels: manifestLabels(),
+		},
+		Rules: []PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"nodes"}, Verbs: []string{"patch"}},
+			{APIGroups: []string{"", "events.k8s.io"}, Resources: []string{"events"}, Verbs: []string{"create", "patch"}},
+		},
+	}
+
+	binding := &ClusterRoleBinding{
+		APIVersion: "rbac.authorization.k8s.io/v1",
+		Kind:       "ClusterRoleBinding",
+		Metadata: ObjectMeta{
+			Name:   opts.ServiceAccount,
+			Labels: manifestLabels(),
+		},
+		RoleRef: RoleRef{
+			APIGroup: "rbac.authorization.k8s.io",
+			Kind:     "ClusterRole",
+			Name:     role.Metadata.Name,
+		},
+		Subjects: []Subject{
+			{Kind: "ServiceAccount", Name: sa.Metadata.Name, Namespace: opts.Namespace},
+		},
+	}
+
+	return []any{sa, role, binding}
+}
+
+// NewAgentNodePolicy returns an admission policy restricting the agent's
+// writes to the node named in its service account token, which the API server
+// puts in the user's extra info for tokens bound to a pod, since Kubernetes
+// 1.30. Writes without that claim are denied, as are node creations through
+// server-side apply.
+func NewAgentNodePolicy(opts ManifestOptions) []any {
+	username := fmt.Sprintf("system:serviceaccount:%s:%s", opts.Namespace, opts.ServiceAccount)
+	name := opts.ServiceAccount + "-own-node"
+
+	policy := &ValidatingAdmissionPolicy{
+		APIVersion: "admissionregistration.k8s.io/v1",
+		Kind:       "ValidatingAdmissionPolicy",
+		Metadata: ObjectMeta{
+			Name:   name,
+			Labels: manifestLabels(),
+		},
+		Spec: ValidatingAdmissionPolicySpec{
+			FailurePolicy: "Fail",
+			MatchConstraints: MatchResources{
+				ResourceRules: []ResourceRule{
+					{APIGroups: []string{""}, APIVersions: []string{"v1"}, Operations: []string{"CREATE", "UPDATE"}, Resources: []string{"nodes"}},
+				},
+			},
+			MatchConditions: []MatchCondition{
+				{Name: "agent", Expression: fmt.Sprintf("request.userInfo.username == %q", username)},
+			},
+			Validations: []Validation{
+				{
+					Expression: "request.operation == 'UPDATE' && 'authentication.kubernetes.io/node-name' in request.userInfo.extra && request.userInfo.extra['authentication.kubernetes.io/node-name'][0] == object.metadata.name",
+					Message:    "the rcpu agent may only patch the node it runs on",
+				},
+			},
+		},
+	}
+
+	binding := &ValidatingAdmissionPolicyBinding{
+		APIVersion: "admissionregistration.k8s.io/v1",
+		Kind:       "ValidatingAdmissionPolicyBinding",
+		Metadata: ObjectMeta{
+			Name:   name,
+			Labels: manifestLabels(),
+		},
+		Spec: ValidatingAdmissionPolicyBindingSpec{
+			PolicyName:        policy.Metadata.Name,
+			ValidationActions: []string{"Deny"},
+		},
+	}
+
+	return []any{policy, binding}
+}
+
+func WriteManifests(w io.Writer, objects []any) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	defer enc.Close()
+
+	for _, obj := range objects {
+		if err := enc.Encode(obj); err != nil {
+			return fmt.Errorf("failed to encode manifest: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func RunManifests(args []string) error {
+	fs := flag.NewFlagSet("manifests", flag.ExitOnError)
+	opts := ManifestOptions{}
+	fs.StringVar(&opts.Namespace, "namespace", DefaultManifestNamespace, "namespace of the agent")
+	fs.StringVar(&opts.ServiceAccount, "service-account", DefaultManifestServiceAccount, "service account of the agent")
+	fs.Parse(args)
+
+	return WriteManifests(os.Stdout, append(NewAgentRBAC(opts), NewAgentNodePolicy(opts)...))
+}
@@ -0,0 +1,263 @@
+package rcpu
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	apiv1 "solelab.tech/collector/api/v1"
+)
+
+const (
+	MarksPath = apiv1.MarksPath
+
+	DefaultMaxMarks    = 1000
+	DefaultMarkAddr    = "http://localhost:9465"
+	DefaultMarkTimeout = 10 * time.Second
+
+	// MaxMarkLabel is the longest label in bytes, labels end up in the
+	// metrics and every record
+	MaxMarkLabel = 128
+)
+
+// ValidateMarkLabel accepts printable UTF-8 labels up to MaxMarkLabel bytes.
+func ValidateMarkLabel(label string) error {
+	if len(label) > MaxMarkLabel {
+		return fmt.Errorf("label is longer than %d bytes", MaxMarkLabel)
+	}
+
+	if !utf8.ValidString(label) {
+		return fmt.Errorf("label %q is not valid UTF-8", label)
+	}
+
+	for _, r := range label {
+		if !unicode.IsPrint(r) {
+			return fmt.Errorf("label %q has a non-printable character", label)
+		}
+	}
+
+	return nil
+}
+
+// Mark labels the samples taken from Start until End, or until the next mark
+// when End is zero, e.g. with the name of the experiment running.
+type Mark struct {
+	Label string    `json:"label"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end,omitempty"`
+}
+
+func (m *Mark) Contains(t time.Time) bool {
+	return !t.Before(m.Start) && (m.End.IsZero() || t.Before(m.End))
+}
+
+// Marks keeps the most recent marks, so every sample can carry the label of
+// the workload that was running when it was taken.
+type Marks struct {
+	// token authenticates the POSTs, without it only local clients may post
+	token string
+
+	mu    sync.Mutex
+	marks []Mark
+	max   int
+}
+
+func NewMarks(max int) *Marks {
+	return &Marks{max: max}
+}
+
+// SetToken requires the POSTs to present the bearer token, they may come
+// from anywhere then.
+func (m *Marks) SetToken(token string) {
+	m.token = token
+}
+
+// authorized checks the bearer token if one is set, and that the client is
+// local otherwise, the metrics listener is usually reachable from the whole
+// cluster.
+func (m *Marks) authorized(r *http.Request) bool {
+	if m.token != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		return ok && subtle.ConstantTimeCompare([]byte(token), []byte(m.token)) == 1
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Add starts a mark at start, ending the open one. A zero duration keeps the
+// new mark open until the next one.
+func (m *Marks) Add(label string, start time.Time, duration time.Duration) Mark {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.marks {
+		if m.marks[i].Contains(start) {
+			m.marks[i].End = start
+		}
+	}
+
+	mark := Mark{Label: label, Start: start}
+	if duration > 0 {
+		mark.End = start.Add(duration)
+	}
+
+	if label != "" {
+		m.marks = append(m.marks, mark)
+		if len(m.marks) > m.max {
+			m.marks = append(m.marks[:0], m.marks[len(m.marks)-m.max:]...)
+		}
+	}
+
+	return mark
+}
+
+// Label returns the label of the mark t falls in, empty if none.
+func (m *Marks) Label(t time.Time) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.marks) - 1; i >= 0; i-- {
+		if m.marks[i].Contains(t) {
+			return m.marks[i].Label
+		}
+	}
+
+	return ""
+}
+
+func (m *Marks) List() []Mark {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	marks := make([]Mark, len(m.marks))
+	copy(marks, m.marks)
+
+	return marks
+}
+
+// ServeHTTP lists the marks on GET and adds one on POST.
+func (m *Marks) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		marks := m.List()
+		list := make([]apiv1.Mark, 0, len(marks))
+		for i := range marks {
+			list = append(list, MarkToAPI(&marks[i]))
+		}
+
+		writeJSON(w, list)
+	case http.MethodPost:
+		if !m.authorized(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req apiv1.MarkRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("malformed mark: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		var duration time.Duration
+		if req.Duration != "" {
+			var err error
+			if duration, err = time.ParseDuration(req.Duration); err != nil || duration < 0 {
+				http.Error(w, fmt.Sprintf("invalid duration %q", req.Duration), http.StatusBadRequest)
+				return
+			}
+		}
+
+		label := strings.TrimSpace(req.Label)
+		if err := ValidateMarkLabel(label); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		mark := m.Add(label, time.Now(), duration)
+
+		writeJSON(w, MarkToAPI(&mark))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// RunMark tags the samples of a running collector, which serves /v1/marks
+// next to its metrics.
+func RunMark(args []string) error {
+	fs := flag.NewFlagSet("mark", flag.ExitOnError)
+	label := fs.String("label", "", "label of the samples from now on, empty ends the current one")
+	duration := fs.Duration("for", 0, "end the mark after this long, 0 keeps it until the next mark")
+	addr := fs.String("addr", DefaultMarkAddr, "address of the collector's -metrics-listen")
+	tokenFile := fs.String("token-file", "", "authenticate with the bearer token in this file, the collector's -mark-token-file")
+	fs.Parse(args)
+
+	if err := ValidateMarkLabel(strings.TrimSpace(*label)); err != nil {
+		return err
+	}
+
+	var token string
+	if *tokenFile != "" {
+		var err error
+		if token, err = LoadToken(*tokenFile); err != nil {
+			return err
+		}
+	}
+
+	body, err := json.Marshal(&apiv1.MarkRequest{Label: *label, Duration: durationString(*duration)})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(*addr, "/")+MarksPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := &http.Client{Timeout: DefaultMarkTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post mark: %v", err)
+	}
+	defer resp.Body.Close()
+
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("collector returned %s: %s", resp.Status, strings.TrimSpace(string(out)))
+	}
+
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+func durationString(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+
+	return d.String()
+}
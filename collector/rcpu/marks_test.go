@@ -0,0 +1,52 @@
+package rcpu
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMarksPost(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		remote string
+		auth   string
+		body   string
+		status int
+	}{
+		{"local", "", "127.0.0.1:4000", "", `{"label":"run-1"}`, http.StatusOK},
+		{"remote without token", "", "192.0.2.1:4000", "", `{"label":"run-1"}`, http.StatusUnauthorized},
+		{"remote with token", "secret", "192.0.2.1:4000", "Bearer secret", `{"label":"run-1"}`, http.StatusOK},
+		{"wrong token", "secret", "127.0.0.1:4000", "Bearer guess", `{"label":"run-1"}`, http.StatusUnauthorized},
+		{"control character", "", "127.0.0.1:4000", "", `{"label":"run\u00091"}`, http.StatusBadRequest},
+		{"too long", "", "127.0.0.1:4000", "", `{"label":"` + strings.Repeat("x", MaxMarkLabel+1) + `"}`, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			marks := NewMarks(DefaultMaxMarks)
+			marks.SetToken(test.token)
+
+			req := httptest.NewRequest(http.MethodPost, MarksPath, strings.NewReader(test.body))
+			req.RemoteAddr = test.remote
+			if test.auth != "" {
+				req.Header.Set("Authorization", test.auth)
+			}
+
+			rec := httptest.NewRecorder()
+			marks.ServeHTTP(rec, req)
+			if rec.Code != test.status {
+				t.Errorf("expected %d, got %d: %s", test.status, rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	got := label("label", "a\\b\"c\nd\té")
+	if want := `label="a\\b\"c\nd` + "\t" + `é"`; got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,472 @@
+package rcpu
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	ProcMemInfoName = "meminfo"
+
+	ContentTypePrometheus  = "text/plain; version=0.0.4"
+	ContentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+	// exemplarMaxLabel keeps exemplar label sets below OpenMetrics' limit of
+	// 128 characters
+	exemplarMaxLabel = 100
+)
+
+// The names of the metrics the dashboard queries, see Dashboard.
+const (
+	metricAvgCPUUsage      = "rcpu_avg_cpu_usage_percent"
+	metricAdjustedCPUUsage = "rcpu_adjusted_cpu_usage_percent"
+	metricRemainingCPU     = "rcpu_remaining_cpu_percent"
+	metricFreeCores        = "rcpu_free_cores"
+	metricSiblingOverlap   = "rcpu_sibling_overlap_percent"
+	metricSMTInterference  = "rcpu_smt_interference"
+	metricCoreBusy         = "rcpu_core_busy_percent"
+	metricAnomalousCores   = "rcpu_anomalous_cores"
+)
+
+// MachineInfo holds the static facts exported under cAdvisor's machine_*
+// names, so dashboards keyed to them keep working without cAdvisor.
+type MachineInfo struct {
+	CPUs        int
+	Cores       int
+	Sockets     int
+	MemoryBytes uint64
+
+	// AnomalousCores have other than two CPUs, see CheckTopology
+	AnomalousCores int
+}
+
+func NewMachineInfo(h *Host, cpuInfos []CPUInfo) MachineInfo {
+	cores := make(map[int32]int)
+	sockets := make(map[int32]bool)
+	for _, info := range cpuInfos {
+		cores[info.CoreId]++
+		sockets[info.SocketId] = true
+	}
+
+	anomalous := 0
+	for _, cpus := range cores {
+		if cpus != 2 {
+			anomalous++
+		}
+	}
+
+	info := MachineInfo{
+		CPUs:           len(cpuInfos),
+		Cores:          len(cores),
+		Sockets:        len(sockets),
+		AnomalousCores: anomalous,
+	}
+
+	// Memory is informational only, don't fail the collector over it
+	if memoryBytes, err := h.MemoryBytes(); err == nil {
+		info.MemoryBytes = memoryBytes
+	}
+
+	return info
+}
+
+// MetricsExporter serves the latest sample in the Prometheus text format.
+type MetricsExporter struct {
+	mu      sync.Mutex
+	machine MachineInfo
+	sample  *Sample
+	errors  *ErrorLimiter
+	labels  string
+
+	// coreUsages are only exported with -metrics-per-core
+	coreUsages []CoreUsage
+
+	cgroupDivergence *float64
+	anomaly          *AnomalyState
+	// anomalyLabel is the mark the latest anomaly started in
+	anomalyLabel string
+
+	// created is when the counters started, they count from zero
+	created time.Time
+}
+
+func NewMetricsExporter(machine MachineInfo) *MetricsExporter {
+	return &MetricsExporter{machine: machine, created: time.Now()}
+}
+
+// SetErrorLimiter exports the error counts of the limiter.
+func (e *MetricsExporter) SetErrorLimiter(errors *ErrorLimiter) {
+	e.errors = errors
+}
+
+// SetMachine exports the machine anew after its topology changed.
+func (e *MetricsExporter) SetMachine(machine MachineInfo) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.machine = machine
+}
+
+func (e *MetricsExporter) Update(sample *Sample) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.sample = sample
+}
+
+// UpdateCoreUsages exports the usage of every core, copying usages.
+func (e *MetricsExporter) UpdateCoreUsages(usages []CoreUsage) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.coreUsages = append(e.coreUsages[:0], usages...)
+}
+
+// metricsSink exports the latest sample, and with perCore the usage of every
+// core. It isn't registered, the exporter is served with the marks.
+type metricsSink struct {
+	exporter *MetricsExporter
+	perCore  bool
+}
+
+func (s *metricsSink) Write(ctx context.Context, sample Sample) error {
+	s.exporter.Update(&sample)
+	if s.perCore {
+		s.exporter.UpdateCoreUsages(sample.PerCore)
+	}
+
+	return nil
+}
+
+func (s *metricsSink) Close() error {
+	return nil
+}
+
+// UpdateCgroupDivergence exports the latest result of the cgroup check.
+func (e *MetricsExporter) UpdateCgroupDivergence(divergence float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.cgroupDivergence = &divergence
+}
+
+// UpdateAnomaly exports the latest verdict of the anomaly detector, and with
+// OpenMetrics the first sample of the latest anomaly as an exemplar labelled
+// with the mark it started in.
+func (e *MetricsExporter) UpdateAnomaly(state AnomalyState, label string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.anomaly == nil || state.Events != e.anomaly.Events {
+		e.anomalyLabel = label
+	}
+	e.anomaly = &state
+}
+
+// SetConstLabels adds the labels, e.g. environment="kvm", to every metric.
+func (e *MetricsExporter) SetConstLabels(labels string) {
+	e.labels = labels
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// label formats a label pair. The exposition format only escapes backslashes,
+// double quotes and line feeds in label values, Go's quoting of other
+// characters would break the scrape.
+func label(name, value string) string {
+	return name + `="` + labelValueEscaper.Replace(value) + `"`
+}
+
+// joinLabels formats the non-empty labels as a label set, empty without any.
+func joinLabels(labels ...string) string {
+	var nonEmpty []string
+	for _, label := range labels {
+		if label != "" {
+			nonEmpty = append(nonEmpty, label)
+		}
+	}
+
+	if len(nonEmpty) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(nonEmpty, ",") + "}"
+}
+
+func writeGauge(w io.Writer, name, help, labels string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+	fmt.Fprintf(w, "%s%s %g\n", name, joinLabels(labels), value)
+}
+
+func boolGauge(v bool) float64 {
+	if v {
+		return 1
+	}
+
+	return 0
+}
+
+// writeCounterHeader writes the metadata of a counter, OpenMetrics names the
+// family without the _total suffix of its samples.
+func writeCounterHeader(w io.Writer, name, help string, openMetrics bool) {
+	if openMetrics {
+		name = strings.TrimSuffix(name, "_total")
+	}
+
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+}
+
+// writeCreated writes when a counter started, OpenMetrics only.
+func (e *MetricsExporter) writeCreated(w io.Writer, name, labels string, openMetrics bool) {
+	if openMetrics {
+		fmt.Fprintf(w, "%s_created%s %s\n", strings.TrimSuffix(name, "_total"), labels, formatTimestamp(e.created))
+	}
+}
+
+func formatTimestamp(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixNano())/1e9, 'f', 3, 64)
+}
+
+// exemplarLabel truncates a mark label to fit an exemplar's label set
+func exemplarLabel(label string) string {
+	if utf8.RuneCountInString(label) <= exemplarMaxLabel {
+		return label
+	}
+
+	return string([]rune(label)[:exemplarMaxLabel])
+}
+
+// writeGroupUsages writes the adjusted usage of every socket or node, the
+// cores it has left when its size is known, and the spread between the
+// busiest and the idlest.
+func writeGroupUsages(w io.Writer, group, noun, labels string, usages []GroupUsage) {
+	if len(usages) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "# HELP rcpu_%s_adjusted_cpu_usage_percent CPU usage of the %s adjusted for busy SMT siblings.\n", group, noun)
+	fmt.Fprintf(w, "# TYPE rcpu_%s_adjusted_cpu_usage_percent gauge\n", group)
+	for _, usage := range usages {
+		fmt.Fprintf(w, "rcpu_%s_adjusted_cpu_usage_percent%s %g\n", group, joinLabels(labels, fmt.Sprintf("%s=\"%d\"", group, usage.Id)), usage.AdjustedCPUUsage)
+	}
+
+	if usages[0].Cores > 0 {
+		fmt.Fprintf(w, "# HELP rcpu_%s_remaining_cores Physical cores of the %s left by the adjusted usage.\n", group, noun)
+		fmt.Fprintf(w, "# TYPE rcpu_%s_remaining_cores gauge\n", group)
+		for _, usage := range usages {
+			fmt.Fprintf(w, "rcpu_%s_remaining_cores%s %g\n", group, joinLabels(labels, fmt.Sprintf("%s=\"%d\"", group, usage.Id)), float64(usage.Cores)*(100-usage.AdjustedCPUUsage)/100)
+		}
+	}
+
+	writeGauge(w, "rcpu_"+group+"_imbalance_percent", "Adjusted usage of the busiest "+noun+" minus the idlest.", labels, Spread(usages))
+}
+
+// WriteMetrics writes the Prometheus text format, or OpenMetrics with created
+// timestamps and exemplars, without the closing # EOF.
+func (e *MetricsExporter) WriteMetrics(w io.Writer, openMetrics bool) {
+	e.mu.Lock()
+	machine := e.machine
+	sample := e.sample
+	coreUsages := append([]CoreUsage(nil), e.coreUsages...)
+	cgroupDivergence := e.cgroupDivergence
+	anomaly := e.anomaly
+	anomalyLabel := e.anomalyLabel
+	e.mu.Unlock()
+
+	writeGauge(w, "machine_cpu_cores", "Number of logical CPU cores.", e.labels, float64(machine.CPUs))
+	writeGauge(w, "machine_cpu_physical_cores", "Number of physical CPU cores.", e.labels, float64(machine.Cores))
+	writeGauge(w, "machine_cpu_sockets", "Number of CPU sockets.", e.labels, float64(machine.Sockets))
+	writeGauge(w, metricAnomalousCores, "Number of physical cores without two CPUs, whose CPUs count as cores of their own.", e.labels, float64(machine.AnomalousCores))
+	if machine.MemoryBytes > 0 {
+		writeGauge(w, "machine_memory_bytes", "Amount of memory installed on the machine.", e.labels, float64(machine.MemoryBytes))
+	}
+
+	if e.errors != nil {
+		writeCounterHeader(w, "rcpu_collector_errors_total", "Errors the collector recovered from, by class.", openMetrics)
+		for _, count := range e.errors.Counts() {
+			labels := joinLabels(e.labels, label("class", count.Class))
+			fmt.Fprintf(w, "rcpu_collector_errors_total%s %d\n", labels, count.Total)
+			e.writeCreated(w, "rcpu_collector_errors_total", labels, openMetrics)
+		}
+
+		writeCounterHeader(w, "rcpu_collector_warnings_total", "Conditions of the machine the collector warned about, by class.", openMetrics)
+		for _, count := range e.errors.WarningCounts() {
+			labels := joinLabels(e.labels, label("class", count.Class))
+			fmt.Fprintf(w, "rcpu_collector_warnings_total%s %d\n", labels, count.Total)
+			e.writeCreated(w, "rcpu_collector_warnings_total", labels, openMetrics)
+		}
+	}
+
+	if cgroupDivergence != nil {
+		writeGauge(w, "rcpu_cgroup_divergence_percent", "Difference of the busy time of /proc/stat and the root cgroup, relative to the larger.", e.labels, *cgroupDivergence)
+	}
+
+	if anomaly != nil {
+		writeGauge(w, "rcpu_adjusted_cpu_usage_zscore", "Standard deviations of the adjusted usage from its recent mean.", e.labels, anomaly.Z)
+		writeGauge(w, "rcpu_anomaly", "Whether the adjusted usage deviates sharply from its recent mean.", e.labels, boolGauge(anomaly.Anomaly))
+		writeCounterHeader(w, "rcpu_anomalies_total", "Runs of samples whose adjusted usage deviated sharply from the recent mean.", openMetrics)
+		fmt.Fprintf(w, "rcpu_anomalies_total%s %d", joinLabels(e.labels), anomaly.Events)
+		// The exemplar points at the spike and the workload marked at the time
+		if openMetrics && anomaly.Events > 0 {
+			var exemplar string
+			if anomalyLabel != "" {
+				exemplar = label("label", exemplarLabel(anomalyLabel))
+			}
+			fmt.Fprintf(w, " # {%s} %g %s", exemplar, anomaly.SinceUsage, formatTimestamp(anomaly.Since))
+		}
+		fmt.Fprintln(w)
+		e.writeCreated(w, "rcpu_anomalies_total", joinLabels(e.labels), openMetrics)
+	}
+
+	// Nothing to report until the second tick
+	if sample == nil {
+		return
+	}
+
+	writeGauge(w, "rcpu_sample_interval_seconds", "Actual time the usage was measured over.", e.labels, sample.Interval.Seconds())
+	writeGauge(w, metricAvgCPUUsage, "Average CPU usage, following top.", e.labels, sample.AvgCPUUsage)
+	writeGauge(w, metricAdjustedCPUUsage, "CPU usage adjusted for busy SMT siblings.", e.labels, sample.AdjustedCPUUsage)
+	writeGauge(w, "rcpu_avg_remaining_cpu_percent", "100% minus the average CPU usage.", e.labels, 100.0-sample.AvgCPUUsage)
+	writeGauge(w, metricRemainingCPU, "RCPU, 100% minus the adjusted CPU usage.", e.labels, sample.RCPU())
+	writeGauge(w, "rcpu_remaining_cores", "Remaining physical cores following RCPU.", e.labels, sample.RemainingCores())
+	writeGauge(w, "rcpu_load1", "1 minute load average.", e.labels, sample.Load1)
+	writeGauge(w, "rcpu_load5", "5 minute load average.", e.labels, sample.Load5)
+	writeGauge(w, "rcpu_load15", "15 minute load average.", e.labels, sample.Load15)
+	writeGauge(w, "rcpu_load_per_free_core", "1 minute load over the remaining physical cores, at least one.", e.labels, sample.LoadPerFreeCore())
+
+	if sample.Steal > 0 {
+		writeGauge(w, "rcpu_steal_percent", "CPU time taken by the hypervisor, counted as busy.", e.labels, sample.Steal)
+	}
+
+	if sample.BusyMHz > 0 {
+		writeGauge(w, "rcpu_busy_cpu_frequency_mhz", "Frequency of the CPUs weighted by their busy time.", e.labels, sample.BusyMHz)
+	}
+	if sample.Derating > 0 {
+		writeGauge(w, "rcpu_capacity_derating_factor", "Share of the nominal capacity a cycle of the remaining CPU is worth, below 1 under the base clock.", e.labels, sample.Derating)
+	}
+
+	if sample.Window != nil {
+		writeGauge(w, "rcpu_remaining_cpu_window_samples", "Number of samples the RCPU window holds.", e.labels, float64(sample.Window.Samples))
+		writeGauge(w, "rcpu_remaining_cpu_window_mean_percent", "Mean RCPU over the window.", e.labels, sample.Window.Mean)
+		writeGauge(w, "rcpu_remaining_cpu_window_stddev_percent", "Standard deviation of RCPU over the window.", e.labels, sample.Window.StdDev)
+		writeGauge(w, "rcpu_remaining_cpu_window_low_percent", "Lower 95% confidence bound of the mean RCPU over the window.", e.labels, sample.Window.Low)
+		writeGauge(w, "rcpu_remaining_cpu_window_high_percent", "Upper 95% confidence bound of the mean RCPU over the window.", e.labels, sample.Window.High)
+	}
+
+	if sample.FreeCores != nil {
+		writeGauge(w, metricFreeCores, "Number of physical cores idle on all their threads.", e.labels, float64(*sample.FreeCores))
+	}
+
+	writeGauge(w, metricSiblingOverlap, "Share of the physical cores' capacity both SMT siblings were busy at once.", e.labels, SiblingOverlap(sample.AvgCPUUsage, sample.AdjustedCPUUsage))
+
+	if sample.SMTInterference != nil {
+		writeGauge(w, metricSMTInterference, "Share of the overlap of SMT siblings counted as busy, from 0 when SMT doubles the throughput to 1 when it yields nothing.", e.labels, *sample.SMTInterference)
+	}
+
+	writeGauge(w, "rcpu_irq_heavy_cpus", "Number of CPUs busy mostly with IRQ and SoftIRQ.", e.labels, float64(len(sample.IRQCPUs)))
+
+	if len(sample.LLCOccupancy) > 0 {
+		fmt.Fprintln(w, "# HELP rcpu_llc_occupancy_bytes Last level cache occupied by the resctrl monitoring group.")
+		fmt.Fprintln(w, "# TYPE rcpu_llc_occupancy_bytes gauge")
+		for _, occupancy := range sample.LLCOccupancy {
+			fmt.Fprintf(w, "rcpu_llc_occupancy_bytes%s %d\n", joinLabels(e.labels, label("group", occupancy.Group), label("domain", occupancy.Domain)), occupancy.Bytes)
+		}
+	}
+
+	if len(sample.Pods) > 0 {
+		fmt.Fprintln(w, "# HELP rcpu_pod_busy_cores Busy time of the pod's pinned CPUs, in cores.")
+		fmt.Fprintln(w, "# TYPE rcpu_pod_busy_cores gauge")
+		for _, pod := range sample.Pods {
+			fmt.Fprintf(w, "rcpu_pod_busy_cores%s %g\n", joinLabels(e.labels, label("namespace", pod.Namespace), label("pod", pod.Name)), pod.BusyCores)
+		}
+
+		fmt.Fprintln(w, "# HELP rcpu_pod_adjusted_cores SMT-adjusted busy time attributed to the pod, in cores.")
+		fmt.Fprintln(w, "# TYPE rcpu_pod_adjusted_cores gauge")
+		for _, pod := range sample.Pods {
+			fmt.Fprintf(w, "rcpu_pod_adjusted_cores%s %g\n", joinLabels(e.labels, label("namespace", pod.Namespace), label("pod", pod.Name)), pod.AdjustedCores)
+		}
+	}
+
+	if len(sample.Containers) > 0 {
+		fmt.Fprintln(w, "# HELP rcpu_container_busy_cores CPU usage of the container's cgroup, in logical CPUs.")
+		fmt.Fprintln(w, "# TYPE rcpu_container_busy_cores gauge")
+		for _, c := range sample.Containers {
+			fmt.Fprintf(w, "rcpu_container_busy_cores%s %g\n", joinLabels(e.labels, label("namespace", c.Namespace), label("pod", c.Pod), label("container", c.Container)), c.BusyCores)
+		}
+
+		fmt.Fprintln(w, "# HELP rcpu_container_adjusted_cores SMT-adjusted busy time attributed to the container, in physical cores.")
+		fmt.Fprintln(w, "# TYPE rcpu_container_adjusted_cores gauge")
+		for _, c := range sample.Containers {
+			fmt.Fprintf(w, "rcpu_container_adjusted_cores%s %g\n", joinLabels(e.labels, label("namespace", c.Namespace), label("pod", c.Pod), label("container", c.Container)), c.AdjustedCores)
+		}
+	}
+
+	if len(coreUsages) > 0 {
+		fmt.Fprintf(w, "# HELP %s CPU usage of the physical core, as busy as its busiest thread.\n", metricCoreBusy)
+		fmt.Fprintf(w, "# TYPE %s gauge\n", metricCoreBusy)
+		for _, usage := range coreUsages {
+			fmt.Fprintf(w, "%s%s %g\n", metricCoreBusy, joinLabels(e.labels, label("core", strconv.Itoa(int(usage.CoreId)))), usage.Busy)
+		}
+	}
+
+	writeGroupUsages(w, "socket", "socket", e.labels, sample.Sockets)
+	writeGroupUsages(w, "numa_node", "NUMA node", e.labels, sample.Nodes)
+
+	if sample.Label != "" {
+		// OpenMetrics has a type of its own for info metrics
+		if openMetrics {
+			fmt.Fprintln(w, "# HELP rcpu_mark Label of the current mark.")
+			fmt.Fprintln(w, "# TYPE rcpu_mark info")
+		} else {
+			fmt.Fprintln(w, "# HELP rcpu_mark_info Label of the current mark.")
+			fmt.Fprintln(w, "# TYPE rcpu_mark_info gauge")
+		}
+		fmt.Fprintf(w, "rcpu_mark_info%s 1\n", joinLabels(e.labels, label("label", sample.Label)))
+	}
+}
+
+// ServeSamples serves the latest sample the way the aggregator serves its
+// samples, so the annotator can poll either of them.
+func (e *MetricsExporter) ServeSamples(w http.ResponseWriter, r *http.Request) {
+	WriteSamples(w, e.samples())
+}
+
+// ServeSampleList serves the latest sample on /v1/samples.
+func (e *MetricsExporter) ServeSampleList(w http.ResponseWriter, r *http.Request) {
+	WriteSampleList(w, e.samples())
+}
+
+func (e *MetricsExporter) samples() []*Sample {
+	e.mu.Lock()
+	sample := e.sample
+	e.mu.Unlock()
+
+	// Nothing to report until the second tick
+	var samples []*Sample
+	if sample != nil {
+		samples = append(samples, sample)
+	}
+
+	return samples
+}
+
+func (e *MetricsExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text") {
+		w.Header().Set("Content-Type", ContentTypePrometheus)
+		e.WriteMetrics(w, false)
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentTypeOpenMetrics)
+	e.WriteMetrics(w, true)
+	fmt.Fprintln(w, "# EOF")
+}
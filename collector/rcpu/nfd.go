@@ -0,0 +1,187 @@
+package rcpu
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	NFDLabelPrefix = "rcpu.solecnugit.io/"
+
+	// Headroom buckets following the scheduler plugin's defaults
+	HeadroomHighAbove   = 60.0
+	HeadroomMediumAbove = 30.0
+
+	// DefaultHeadroomHysteresis is how far past a boundary, in percent, the
+	// mean has to be before the bucket changes
+	DefaultHeadroomHysteresis = 5.0
+)
+
+func HeadroomBucket(rcpu float64) string {
+	switch {
+	case rcpu >= HeadroomHighAbove:
+		return "high"
+	case rcpu >= HeadroomMediumAbove:
+		return "medium"
+	default:
+		return "low"
+	}
+}
+
+func headroomRank(bucket string) int {
+	switch bucket {
+	case "high":
+		return 2
+	case "medium":
+		return 1
+	default:
+		return 0
+	}
+}
+
+// headroomBucketWithHysteresis only leaves the current bucket once rcpu is
+// past the boundary by the margin, so a node hovering around it keeps its label.
+func headroomBucketWithHysteresis(current string, rcpu, margin float64) string {
+	if current == "" {
+		return HeadroomBucket(rcpu)
+	}
+
+	if up := HeadroomBucket(rcpu - margin); headroomRank(up) > headroomRank(current) {
+		return up
+	}
+
+	if down := HeadroomBucket(rcpu + margin); headroomRank(down) < headroomRank(current) {
+		return down
+	}
+
+	return current
+}
+
+// sanitizeLabelValue maps a free form string into a valid label value
+func sanitizeLabelValue(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			sb.WriteRune(r)
+		default:
+			sb.WriteByte('_')
+		}
+	}
+
+	value := sb.String()
+	if len(value) > 63 {
+		value = value[:63]
+	}
+
+	// Label values must begin and end with an alphanumeric character
+	return strings.Trim(value, "._-")
+}
+
+// NFDFeatureWriter maintains a Node Feature Discovery local feature file, so
+// clusters standardized on NFD get the topology and SMT facts through their
+// existing labeling pipeline. The headroom is bucketed from the mean RCPU
+// over a window, with a hysteresis margin, since every change relabels the
+// node.
+type NFDFeatureWriter struct {
+	path   string
+	last   string
+	window *RCPUWindow
+	margin float64
+	bucket string
+}
+
+// NewNFDFeatureWriter averages RCPU over window samples before bucketing it.
+func NewNFDFeatureWriter(path string, window int, margin float64) *NFDFeatureWriter {
+	return &NFDFeatureWriter{
+		path:   path,
+		window: NewRCPUWindow(window),
+		margin: margin,
+	}
+}
+
+// ResetWindow forgets the RCPU of the samples so far, the headroom label
+// stays until the new window says otherwise.
+func (w *NFDFeatureWriter) ResetWindow() {
+	w.window = NewRCPUWindow(len(w.window.values))
+}
+
+// Write updates the feature file with the RCPU of the latest sample.
+func (w *NFDFeatureWriter) Write(model string, smt bool, rcpu float64) error {
+	w.window.Add(rcpu)
+	w.bucket = headroomBucketWithHysteresis(w.bucket, w.window.Stats().Mean, w.margin)
+
+	content := fmt.Sprintf("%scpu-model=%s\n%ssmt=%t\n%sheadroom=%s\n",
+		NFDLabelPrefix, sanitizeLabelValue(model),
+		NFDLabelPrefix, smt,
+		NFDLabelPrefix, w.bucket)
+
+	// Only touch the file on changes, NFD re-labels the node on every write
+	if content == w.last {
+		return nil
+	}
+
+	// Write atomically so NFD never reads a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(w.path), ".rcpu-features-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary feature file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write feature file: %v", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write feature file: %v", err)
+	}
+
+	if err := os.Rename(tmp.Name(), w.path); err != nil {
+		return fmt.Errorf("failed to replace %s: %v", w.path, err)
+	}
+
+	w.last = content
+
+	return nil
+}
+
+// nfdSink keeps the NFD feature file at its path up to date. The window
+// starts over when the topology changes.
+type nfdSink struct {
+	writer    *NFDFeatureWriter
+	detection *Detection
+	errors    *ErrorLimiter
+}
+
+func newNFDSink(ctx context.Context, path string, config SinkConfig) (Sink, error) {
+	opts := config.Options
+
+	// The headroom label is always smoothed, even when -window disables the stats
+	window := opts.Window
+	if window == 0 {
+		window = DefaultWindow
+	}
+
+	return &nfdSink{writer: NewNFDFeatureWriter(path, window, opts.NFDHysteresis), errors: config.Errors}, nil
+}
+
+func (s *nfdSink) Write(ctx context.Context, sample Sample) error {
+	if s.detection != nil && sample.Detection != s.detection {
+		s.writer.ResetWindow()
+	}
+	s.detection = sample.Detection
+
+	if err := s.writer.Write(s.detection.Model, s.detection.SMT(), sample.RCPU()); err != nil {
+		s.errors.Log(ErrorClassNFDWrite, "failed to write NFD features: %v", err)
+	}
+
+	return nil
+}
+
+func (s *nfdSink) Close() error {
+	return nil
+}
@@ -0,0 +1,400 @@
+package rcpu
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aquasecurity/table"
+)
+
+const (
+	OutputTable = "table"
+	OutputCSV   = "csv"
+	OutputJSON  = "json"
+
+	DefaultFields = "time,avg,adjusted,avg-remaining,rcpu,diff"
+
+	TimeFormatClock   = "clock"
+	TimeFormatRFC3339 = "rfc3339"
+	TimeFormatUnix    = "unix"
+)
+
+// TimeFormat formats the timestamps of every output the same way, in local
+// time unless UTC is set.
+type TimeFormat struct {
+	Name string
+	UTC  bool
+}
+
+func ParseTimeFormat(name string, utc bool) (TimeFormat, error) {
+	switch name {
+	case TimeFormatClock, TimeFormatRFC3339, TimeFormatUnix:
+		return TimeFormat{Name: name, UTC: utc}, nil
+	default:
+		return TimeFormat{}, fmt.Errorf("invalid time format %q, expected one of clock, rfc3339 or unix", name)
+	}
+}
+
+// Or returns the format, or the named one if none was chosen, as each output
+// has its own default.
+func (f TimeFormat) Or(name string) TimeFormat {
+	if f.Name == "" {
+		f.Name = name
+	}
+
+	return f
+}
+
+func (f TimeFormat) Format(t time.Time) string {
+	if f.UTC {
+		t = t.UTC()
+	} else {
+		t = t.Local()
+	}
+
+	switch f.Name {
+	case TimeFormatUnix:
+		return strconv.FormatInt(t.Unix(), 10)
+	case TimeFormatRFC3339:
+		return t.Format(time.RFC3339)
+	default:
+		return t.Format("15:04:05")
+	}
+}
+
+// Value is the JSON value, a number for unix time and a string otherwise.
+func (f TimeFormat) Value(t time.Time) interface{} {
+	if f.Name == TimeFormatUnix {
+		return t.Unix()
+	}
+
+	return f.Format(t)
+}
+
+// PeriodTotals sums the periods of all CPUs, to report the share of each
+// kind of time.
+type PeriodTotals struct {
+	User    uint64
+	Nice    uint64
+	Sys     uint64
+	Idle    uint64
+	IOWait  uint64
+	IRQ     uint64
+	SoftIRQ uint64
+	Steal   uint64
+	Guest   uint64
+	Total   uint64
+}
+
+func SumPeriods(cpuTimePeriods []CPUTimePeriod) PeriodTotals {
+	var totals PeriodTotals
+	for i := range cpuTimePeriods {
+		p := &cpuTimePeriods[i]
+		totals.User += p.UserPeriod
+		totals.Nice += p.NicePeriod
+		totals.Sys += p.SysPeriod
+		totals.Idle += p.IdlePeriod
+		totals.IOWait += p.IOWaitPeriod
+		totals.IRQ += p.IRQPeriod
+		totals.SoftIRQ += p.SoftIRQPeriod
+		totals.Steal += p.StealPeriod
+		totals.Guest += p.GuestPeriod
+		totals.Total += p.TotalPeriod
+	}
+
+	return totals
+}
+
+func (t *PeriodTotals) percent(period uint64) float64 {
+	if t.Total == 0 {
+		return 0
+	}
+
+	return 100.0 * float64(period) / float64(t.Total)
+}
+
+// Record is everything one tick can report.
+type Record struct {
+	Time             time.Time
+	Cores            int
+	AvgCPUUsage      float64
+	AdjustedCPUUsage float64
+	Periods          PeriodTotals
+	// Load holds the 1, 5 and 15 minute load averages
+	Load [3]float64
+	// Label is the mark the record falls in, see Marks
+	Label string
+	// Sockets and Nodes hold the adjusted usage of every socket and NUMA node
+	Sockets []GroupUsage
+	Nodes   []GroupUsage
+	// IRQCPUs are the IRQ-heavy CPUs, see IRQHeavy
+	IRQCPUs []int32
+	// Derating is the capacity derating factor, 1 when unknown
+	Derating float64
+	// LLCBytes is the LLC occupancy of all resctrl groups
+	LLCBytes uint64
+	// Window summarizes RCPU over the recent samples, zero when disabled
+	Window WindowStats
+	// AnomalyZ is the distance of the adjusted usage from its recent mean in
+	// standard deviations, see AnomalyDetector
+	AnomalyZ float64
+}
+
+// Field is a selectable output column. The time field has no value and is
+// formatted separately, text fields have Text instead of Value.
+type Field struct {
+	Name   string
+	Header string
+	// Format is the tml format of the table cell
+	Format string
+	Value  func(r *Record) float64
+	Text   func(r *Record) string
+}
+
+var Fields = []Field{
+	{Name: "time", Header: "Time"},
+	{"avg", "Avg CPU Usage", "<yellow>%.2f%%</yellow>", func(r *Record) float64 { return r.AvgCPUUsage }, nil},
+	{"adjusted", "Adjusted CPU Usage", "<green>%.2f%%</green>", func(r *Record) float64 { return r.AdjustedCPUUsage }, nil},
+	{"avg-remaining", "Avg Remaining CPU", "<yellow>%.2f%%</yellow>", func(r *Record) float64 { return 100.0 - r.AvgCPUUsage }, nil},
+	{"rcpu", "RCPU", "<green>%.2f%%</green>", func(r *Record) float64 { return 100.0 - r.AdjustedCPUUsage }, nil},
+	{"diff", "Difference", "<bold><red>%.2f%%</red></bold>", func(r *Record) float64 { return r.AdjustedCPUUsage - r.AvgCPUUsage }, nil},
+	{"user", "User", "%.2f%%", func(r *Record) float64 { return r.Periods.percent(r.Periods.User) }, nil},
+	{"nice", "Nice", "%.2f%%", func(r *Record) float64 { return r.Periods.percent(r.Periods.Nice) }, nil},
+	{"sys", "System", "%.2f%%", func(r *Record) float64 { return r.Periods.percent(r.Periods.Sys) }, nil},
+	{"idle", "Idle", "%.2f%%", func(r *Record) float64 { return r.Periods.percent(r.Periods.Idle) }, nil},
+	{"iowait", "IOWait", "%.2f%%", func(r *Record) float64 { return r.Periods.percent(r.Periods.IOWait) }, nil},
+	{"irq", "IRQ", "%.2f%%", func(r *Record) float64 { return r.Periods.percent(r.Periods.IRQ) }, nil},
+	{"softirq", "SoftIRQ", "%.2f%%", func(r *Record) float64 { return r.Periods.percent(r.Periods.SoftIRQ) }, nil},
+	{"steal", "Steal", "<red>%.2f%%</red>", func(r *Record) float64 { return r.Periods.percent(r.Periods.Steal) }, nil},
+	{"guest", "Guest", "%.2f%%", func(r *Record) float64 { return r.Periods.percent(r.Periods.Guest) }, nil},
+	{"load1", "Load 1m", "%.2f", func(r *Record) float64 { return r.Load[0] }, nil},
+	{"load5", "Load 5m", "%.2f", func(r *Record) float64 { return r.Load[1] }, nil},
+	{"load15", "Load 15m", "%.2f", func(r *Record) float64 { return r.Load[2] }, nil},
+	{"load-per-free-core", "Load/Free Core", "<bold>%.2f</bold>", func(r *Record) float64 {
+		return LoadPerFreeCore(r.Load[0], r.Cores, r.AdjustedCPUUsage)
+	}, nil},
+	{"derating", "Derating", "<red>%.2f</red>", func(r *Record) float64 { return r.Derating }, nil},
+	{"effective-rcpu", "Effective RCPU", "<green>%.2f%%</green>", func(r *Record) float64 { return (100.0 - r.AdjustedCPUUsage) * r.Derating }, nil},
+	{"rcpu-mean", "RCPU Mean", "<green>%.2f%%</green>", func(r *Record) float64 { return r.Window.Mean }, nil},
+	{"rcpu-stddev", "RCPU StdDev", "<red>%.2f</red>", func(r *Record) float64 { return r.Window.StdDev }, nil},
+	{"rcpu-low", "RCPU Low", "%.2f%%", func(r *Record) float64 { return r.Window.Low }, nil},
+	{"rcpu-high", "RCPU High", "%.2f%%", func(r *Record) float64 { return r.Window.High }, nil},
+	{"anomaly-z", "Anomaly Z", "<red>%.1f</red>", func(r *Record) float64 { return r.AnomalyZ }, nil},
+	{"llc-mb", "LLC MB", "%.1f", func(r *Record) float64 { return float64(r.LLCBytes) / (1 << 20) }, nil},
+	{"socket-spread", "Socket Spread", "<red>%.2f%%</red>", func(r *Record) float64 { return Spread(r.Sockets) }, nil},
+	{"node-spread", "Node Spread", "<red>%.2f%%</red>", func(r *Record) float64 { return Spread(r.Nodes) }, nil},
+	{Name: "irq-cpus", Header: "IRQ CPUs", Text: func(r *Record) string { return formatCPUs(r.IRQCPUs) }},
+	{Name: "label", Header: "Label", Text: func(r *Record) string { return r.Label }},
+}
+
+func fieldNames() []string {
+	names := make([]string, 0, len(Fields))
+	for _, field := range Fields {
+		names = append(names, field.Name)
+	}
+
+	return names
+}
+
+// ParseFields parses a comma separated list of field names.
+func ParseFields(s string) ([]Field, error) {
+	var fields []Field
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+
+		found := false
+		for _, field := range Fields {
+			if field.Name == name {
+				fields = append(fields, field)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("unknown field %q, expected some of %s", name, strings.Join(fieldNames(), ","))
+		}
+	}
+
+	return fields, nil
+}
+
+func ValidateOutput(output string) error {
+	switch output {
+	case OutputTable, OutputCSV, OutputJSON:
+		return nil
+	default:
+		return fmt.Errorf("invalid output %q, expected one of table, csv or json", output)
+	}
+}
+
+// NewRecord returns the record of the machine view of a sample.
+func NewRecord(sample *Sample) *Record {
+	record := &Record{
+		Time:             sample.Time,
+		Cores:            sample.Cores,
+		AvgCPUUsage:      sample.AvgCPUUsage,
+		AdjustedCPUUsage: sample.AdjustedCPUUsage,
+		Periods:          sample.Periods,
+		Load:             [3]float64{sample.Load1, sample.Load5, sample.Load15},
+		Label:            sample.Label,
+		Sockets:          sample.Sockets,
+		Nodes:            sample.Nodes,
+		IRQCPUs:          sample.IRQCPUs,
+		Derating:         sample.Derating,
+		LLCBytes:         TotalLLCOccupancy(sample.LLCOccupancy),
+		AnomalyZ:         sample.AnomalyZ,
+	}
+
+	// The sample leaves out the derating when it is unknown
+	if record.Derating == 0 {
+		record.Derating = 1
+	}
+	if sample.Window != nil {
+		record.Window = *sample.Window
+	}
+
+	return record
+}
+
+// RecordWriter writes a record per tick in one of the output formats.
+type RecordWriter interface {
+	Write(r *Record) error
+}
+
+type tableRecordWriter struct {
+	display    Display
+	fields     []Field
+	color      bool
+	timeFormat TimeFormat
+}
+
+// NewTableRecordWriter writes records as rows of the table, or of the plain
+// display without color.
+func NewTableRecordWriter(w io.Writer, fields []Field, color bool, rows int, timeFormat TimeFormat) RecordWriter {
+	headers := make([]string, 0, len(fields))
+	alignment := make([]table.Alignment, 0, len(fields))
+	for _, field := range fields {
+		headers = append(headers, field.Header)
+		if field.Value == nil {
+			alignment = append(alignment, table.AlignLeft)
+		} else {
+			alignment = append(alignment, table.AlignCenter)
+		}
+	}
+
+	if !color {
+		return &tableRecordWriter{display: NewPlainDisplay(w, headers...), fields: fields, timeFormat: timeFormat}
+	}
+
+	tableDisplay := NewTableDisplay(w, rows, headers...)
+	tableDisplay.SetAlignment(alignment...)
+
+	return &tableRecordWriter{display: tableDisplay, fields: fields, color: true, timeFormat: timeFormat}
+}
+
+func (t *tableRecordWriter) Write(r *Record) error {
+	cells := make([]string, 0, len(t.fields))
+	for _, field := range t.fields {
+		if field.Text != nil {
+			// Labels come from users, don't let tml interpret them
+			cells = append(cells, field.Text(r))
+			continue
+		}
+
+		if field.Value == nil {
+			cells = append(cells, t.timeFormat.Format(r.Time))
+			continue
+		}
+
+		cells = append(cells, Sprintf(t.color, field.Format, field.Value(r)))
+	}
+	t.display.AddRow(cells...)
+
+	return t.display.Render()
+}
+
+type csvRecordWriter struct {
+	w          *csv.Writer
+	fields     []Field
+	timeFormat TimeFormat
+	started    bool
+}
+
+// NewCSVRecordWriter writes a header line, then a line per record with the
+// raw values.
+func NewCSVRecordWriter(w io.Writer, fields []Field, timeFormat TimeFormat) RecordWriter {
+	return &csvRecordWriter{w: csv.NewWriter(w), fields: fields, timeFormat: timeFormat}
+}
+
+func (c *csvRecordWriter) Write(r *Record) error {
+	if !c.started {
+		c.w.Write(fieldNamesOf(c.fields))
+		c.started = true
+	}
+
+	line := make([]string, 0, len(c.fields))
+	for _, field := range c.fields {
+		if field.Text != nil {
+			line = append(line, field.Text(r))
+			continue
+		}
+
+		if field.Value == nil {
+			line = append(line, c.timeFormat.Format(r.Time))
+			continue
+		}
+
+		line = append(line, strconv.FormatFloat(field.Value(r), 'f', 4, 64))
+	}
+	c.w.Write(line)
+
+	// Flush every tick, someone may be tailing the file
+	c.w.Flush()
+
+	return c.w.Error()
+}
+
+func fieldNamesOf(fields []Field) []string {
+	names := make([]string, 0, len(fields))
+	for _, field := range fields {
+		names = append(names, field.Name)
+	}
+
+	return names
+}
+
+type jsonRecordWriter struct {
+	enc        *json.Encoder
+	fields     []Field
+	timeFormat TimeFormat
+}
+
+// NewJSONRecordWriter writes a JSON object per line, keyed by field name.
+func NewJSONRecordWriter(w io.Writer, fields []Field, timeFormat TimeFormat) RecordWriter {
+	return &jsonRecordWriter{enc: json.NewEncoder(w), fields: fields, timeFormat: timeFormat}
+}
+
+func (j *jsonRecordWriter) Write(r *Record) error {
+	object := make(map[string]interface{}, len(j.fields))
+	for _, field := range j.fields {
+		if field.Text != nil {
+			object[field.Name] = field.Text(r)
+			continue
+		}
+
+		if field.Value == nil {
+			object[field.Name] = j.timeFormat.Value(r.Time)
+			continue
+		}
+
+		object[field.Name] = field.Value(r)
+	}
+
+	return j.enc.Encode(object)
+}
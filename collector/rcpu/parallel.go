@@ -0,0 +1,89 @@
+package rcpu
+
+import (
+	"bytes"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	// Below this many logical CPUs a tick is cheaper done on one goroutine
+	ParallelCPUThreshold = 256
+	MaxShards            = 8
+)
+
+// NumShards returns how many goroutines to split per-CPU work of a machine
+// with n logical CPUs into.
+func NumShards(n int) int {
+	if n < ParallelCPUThreshold {
+		return 1
+	}
+
+	return max(1, min(MaxShards, runtime.GOMAXPROCS(0), n/(ParallelCPUThreshold/4)))
+}
+
+// runShards calls fn with consecutive [lo, hi) ranges covering n items, on
+// one goroutine per shard
+func runShards(n, shards int, fn func(shard, lo, hi int)) {
+	if shards <= 1 {
+		fn(0, 0, n)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for shard := 0; shard < shards; shard++ {
+		lo, hi := n*shard/shards, n*(shard+1)/shards
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(shard, lo, hi)
+		}()
+	}
+	wg.Wait()
+}
+
+// splitLines cuts data into up to shards chunks ending at line boundaries
+func splitLines(chunks [][]byte, data []byte, shards int) [][]byte {
+	chunks = chunks[:0]
+	for shard := shards; shard > 1 && len(data) > 0; shard-- {
+		cut := len(data) / shard
+		if i := bytes.IndexByte(data[cut:], '\n'); i >= 0 {
+			cut += i + 1
+		} else {
+			cut = len(data)
+		}
+
+		chunks = append(chunks, data[:cut])
+		data = data[cut:]
+	}
+
+	if len(data) > 0 {
+		chunks = append(chunks, data)
+	}
+
+	return chunks
+}
+
+// parseCPUTimesParallel parses the chunks of /proc/stat concurrently and
+// joins them in order, reusing the per-shard buffers across calls
+func parseCPUTimesParallel(dst []CPUTime, shardBufs [][]CPUTime, chunks [][]byte, now time.Time) ([]CPUTime, [][]CPUTime, error) {
+	for len(shardBufs) < len(chunks) {
+		shardBufs = append(shardBufs, nil)
+	}
+
+	errs := make([]error, len(chunks))
+	runShards(len(chunks), len(chunks), func(shard, lo, hi int) {
+		shardBufs[shard], errs[shard] = parseCPUTimes(shardBufs[shard][:0], chunks[shard], now)
+	})
+
+	for shard, buf := range shardBufs[:len(chunks)] {
+		if errs[shard] != nil {
+			return nil, shardBufs, errs[shard]
+		}
+
+		dst = append(dst, buf...)
+	}
+
+	return dst, shardBufs, nil
+}
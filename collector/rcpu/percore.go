@@ -0,0 +1,150 @@
+package rcpu
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	SortBusy = "busy"
+	SortIdle = "idle"
+	SortCore = "core"
+	SortDiff = "diff"
+)
+
+var sortKeys = []string{SortBusy, SortIdle, SortCore, SortDiff}
+
+// DefaultFreeCoreBusy is how busy, in percent, the busiest thread of a core
+// may be for FreeCores, housekeeping keeps idle cores a little above zero.
+const DefaultFreeCoreBusy = 5.0
+
+// CoreUsage is the usage of a physical core in percent. Busy follows the
+// adjusted formula, the core is as busy as its busiest thread, and Diff is
+// how much that exceeds the average of its threads, the sibling overlap
+// penalty the average usage hides.
+type CoreUsage struct {
+	CoreId int32
+	CPUs   []int32
+	Busy   float64
+	Idle   float64
+	Diff   float64
+}
+
+func NewCoreIds(coreToCpus map[int32][]int32) []int32 {
+	coreIds := make([]int32, 0, len(coreToCpus))
+	for coreId := range coreToCpus {
+		coreIds = append(coreIds, coreId)
+	}
+	sort.Slice(coreIds, func(i, j int) bool { return coreIds[i] < coreIds[j] })
+
+	return coreIds
+}
+
+func busyPercent(period, idlePeriod uint64) float64 {
+	if period == 0 {
+		return 0
+	}
+
+	return 100.0 * (1 - float64(idlePeriod)/float64(period))
+}
+
+// coreBusy is the adjusted usage of a core, as busy as its busiest thread
+func coreBusy(cpuIds []int32, cpuTimePeriods []CPUTimePeriod) float64 {
+	var period, idlePeriod uint64
+	for j, cpuId := range cpuIds {
+		p := &cpuTimePeriods[cpuId]
+		if j == 0 {
+			period, idlePeriod = p.TotalPeriod, p.TotalIdlePeriod
+		} else {
+			period, idlePeriod = max(period, p.TotalPeriod), min(idlePeriod, p.TotalIdlePeriod)
+		}
+	}
+
+	return busyPercent(period, idlePeriod)
+}
+
+// FreeCores counts the cores whose every thread is at most maxBusy percent
+// busy, the empty cores a pod pinning exclusive CPUs needs, which the
+// fractional headroom of RCPU can't tell apart from scattered idle threads.
+func FreeCores(cores [][]int32, cpuTimePeriods []CPUTimePeriod, maxBusy float64) int {
+	free := 0
+	for _, cpuIds := range cores {
+		if coreBusy(cpuIds, cpuTimePeriods) <= maxBusy {
+			free++
+		}
+	}
+
+	return free
+}
+
+// DoPerCoreUsage computes the usage of every core into dst, reusing its
+// capacity. coreIds and cores are parallel, as from NewCoreIds and
+// NewCoreList.
+func DoPerCoreUsage(dst []CoreUsage, coreIds []int32, cores [][]int32, cpuTimePeriods []CPUTimePeriod) []CoreUsage {
+	dst = dst[:0]
+	for i, cpuIds := range cores {
+		var threadBusy float64
+		for _, cpuId := range cpuIds {
+			p := &cpuTimePeriods[cpuId]
+			threadBusy += busyPercent(p.TotalPeriod, p.TotalIdlePeriod)
+		}
+
+		busy := coreBusy(cpuIds, cpuTimePeriods)
+		dst = append(dst, CoreUsage{
+			CoreId: coreIds[i],
+			CPUs:   cpuIds,
+			Busy:   busy,
+			Idle:   100.0 - busy,
+			Diff:   busy - threadBusy/float64(len(cpuIds)),
+		})
+	}
+
+	return dst
+}
+
+func ValidateSortKey(key string) error {
+	for _, k := range sortKeys {
+		if key == k {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid sort key %q, expected one of %s", key, strings.Join(sortKeys, ", "))
+}
+
+// SortCoreUsages puts the cores of interest first, the busiest, the most
+// idle, or the biggest overlap penalty, ties broken by core ID.
+func SortCoreUsages(usages []CoreUsage, key string) {
+	sort.SliceStable(usages, func(i, j int) bool {
+		a, b := &usages[i], &usages[j]
+		switch key {
+		case SortBusy:
+			if a.Busy != b.Busy {
+				return a.Busy > b.Busy
+			}
+		case SortIdle:
+			if a.Idle != b.Idle {
+				return a.Idle > b.Idle
+			}
+		case SortDiff:
+			if a.Diff != b.Diff {
+				return a.Diff > b.Diff
+			}
+		}
+
+		return a.CoreId < b.CoreId
+	})
+}
+
+func formatCPUs(cpuIds []int32) string {
+	var sb strings.Builder
+	for i, cpuId := range cpuIds {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprint(&sb, cpuId)
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,116 @@
+//go:build linux
+
+package rcpu
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"solelab.tech/collector/cputime"
+)
+
+// perfGroupSize is the size of a group read of cycles and instructions, the
+// number of counters, the times enabled and running and the two values
+const perfGroupSize = 5 * 8
+
+type perfCounts struct {
+	cycles       uint64
+	instructions uint64
+}
+
+// PerfSampler counts the cycles and instructions of every CPU with
+// perf_event_open, system wide, which needs CAP_PERFMON or a
+// perf_event_paranoid of at most 0.
+type PerfSampler struct {
+	cpuIds []int32
+	// fds holds the cycles leader and the instructions member of every CPU
+	fds  [][2]int
+	prev []perfCounts
+	buf  [perfGroupSize]byte
+}
+
+func openPerfCounter(config uint64, cpuId int32, groupFd int) (int, error) {
+	attr := unix.PerfEventAttr{
+		Type:        unix.PERF_TYPE_HARDWARE,
+		Config:      config,
+		Read_format: unix.PERF_FORMAT_GROUP | unix.PERF_FORMAT_TOTAL_TIME_ENABLED | unix.PERF_FORMAT_TOTAL_TIME_RUNNING,
+	}
+	attr.Size = uint32(unsafe.Sizeof(attr))
+
+	return unix.PerfEventOpen(&attr, -1, int(cpuId), groupFd, unix.PERF_FLAG_FD_CLOEXEC)
+}
+
+func NewPerfSampler(cpuIds []int32) (*PerfSampler, error) {
+	s := &PerfSampler{cpuIds: cpuIds, prev: make([]perfCounts, len(cpuIds))}
+	for _, cpuId := range cpuIds {
+		cycles, err := openPerfCounter(unix.PERF_COUNT_HW_CPU_CYCLES, cpuId, -1)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("failed to count cycles of CPU %d: %v", cpuId, err)
+		}
+
+		instructions, err := openPerfCounter(unix.PERF_COUNT_HW_INSTRUCTIONS, cpuId, cycles)
+		if err != nil {
+			unix.Close(cycles)
+			s.Close()
+			return nil, fmt.Errorf("failed to count instructions of CPU %d: %v", cpuId, err)
+		}
+
+		s.fds = append(s.fds, [2]int{cycles, instructions})
+	}
+
+	// Start counting from here, the first interval is the first tick
+	if err := s.Read(nil); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Read stores the IPC of every CPU since the previous read into ipc, indexed
+// by CPU ID, zero where the CPU ran no cycles. A nil ipc only takes a new
+// baseline.
+func (s *PerfSampler) Read(ipc []float64) error {
+	for i, fds := range s.fds {
+		n, err := unix.Read(fds[0], s.buf[:])
+		if err != nil {
+			return fmt.Errorf("failed to read the counters of CPU %d: %v", s.cpuIds[i], err)
+		}
+
+		if n != perfGroupSize || binary.LittleEndian.Uint64(s.buf[0:]) != 2 {
+			return fmt.Errorf("unexpected counter group of CPU %d", s.cpuIds[i])
+		}
+
+		// Multiplexing scales both counters alike, the ratio needs no scaling
+		cur := perfCounts{
+			cycles:       binary.LittleEndian.Uint64(s.buf[24:]),
+			instructions: binary.LittleEndian.Uint64(s.buf[32:]),
+		}
+
+		if ipc != nil {
+			cycles := cputime.SaturatedSub(cur.cycles, s.prev[i].cycles)
+			if cycles > 0 {
+				ipc[s.cpuIds[i]] = float64(cputime.SaturatedSub(cur.instructions, s.prev[i].instructions)) / float64(cycles)
+			} else {
+				ipc[s.cpuIds[i]] = 0
+			}
+		}
+		s.prev[i] = cur
+	}
+
+	return nil
+}
+
+func (s *PerfSampler) Close() error {
+	for _, fds := range s.fds {
+		unix.Close(fds[1])
+		unix.Close(fds[0])
+	}
+	s.fds = nil
+
+	return nil
+}
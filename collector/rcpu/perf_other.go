@@ -0,0 +1,22 @@
+//go:build !linux
+
+package rcpu
+
+import (
+	"fmt"
+)
+
+// PerfSampler needs perf_event_open, which only Linux has.
+type PerfSampler struct{}
+
+func NewPerfSampler(cpuIds []int32) (*PerfSampler, error) {
+	return nil, fmt.Errorf("perf events are only supported on Linux")
+}
+
+func (s *PerfSampler) Read(ipc []float64) error {
+	return fmt.Errorf("perf events are only supported on Linux")
+}
+
+func (s *PerfSampler) Close() error {
+	return nil
+}
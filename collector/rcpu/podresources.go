@@ -0,0 +1,237 @@
+package rcpu
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	podresourcesapi "k8s.io/kubelet/pkg/apis/podresources/v1"
+)
+
+const (
+	DefaultPodResourcesSocket  = "/var/lib/kubelet/pod-resources/kubelet.sock"
+	DefaultPodResourcesTimeout = 10 * time.Second
+	// DefaultPodResourcesRefresh is how often the pinned CPUs are listed,
+	// they only change when guaranteed pods come and go
+	DefaultPodResourcesRefresh = 10 * time.Second
+)
+
+// PodCPUs are the CPUs the kubelet allocated exclusively to a pod, which is
+// only the case for guaranteed pods under the static CPU manager policy.
+type PodCPUs struct {
+	Namespace string
+	Name      string
+	CPUs      []int32
+}
+
+// PodResourcesWatcher lists the pinned CPUs of every pod through the kubelet
+// podresources API in the background, over a single connection, so the
+// collector loop never waits for the kubelet.
+type PodResourcesWatcher struct {
+	conn   *grpc.ClientConn
+	client podresourcesapi.PodResourcesListerClient
+
+	mu   sync.Mutex
+	pods []PodCPUs
+}
+
+// NewPodResourcesWatcher connects lazily, an unavailable kubelet is reported
+// by Run.
+func NewPodResourcesWatcher(socket string) (*PodResourcesWatcher, error) {
+	conn, err := grpc.NewClient("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", socket, err)
+	}
+
+	return &PodResourcesWatcher{
+		conn:   conn,
+		client: podresourcesapi.NewPodResourcesListerClient(conn),
+	}, nil
+}
+
+func (w *PodResourcesWatcher) Close() error {
+	return w.conn.Close()
+}
+
+// list queries the pinned CPUs of every pod on the node. Pods without
+// exclusive CPUs are left out.
+func (w *PodResourcesWatcher) list(ctx context.Context) ([]PodCPUs, error) {
+	ctx, cancel := context.WithTimeout(ctx, DefaultPodResourcesTimeout)
+	defer cancel()
+
+	resp, err := w.client.List(ctx, &podresourcesapi.ListPodResourcesRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pod resources: %v", err)
+	}
+
+	var pods []PodCPUs
+	for _, pod := range resp.GetPodResources() {
+		podCPUs := PodCPUs{Namespace: pod.GetNamespace(), Name: pod.GetName()}
+		for _, container := range pod.GetContainers() {
+			for _, cpuId := range container.GetCpuIds() {
+				podCPUs.CPUs = append(podCPUs.CPUs, int32(cpuId))
+			}
+		}
+
+		if len(podCPUs.CPUs) > 0 {
+			pods = append(pods, podCPUs)
+		}
+	}
+
+	return pods, nil
+}
+
+// Run refreshes the pods every interval until ctx is done. The previous pods
+// are kept while the kubelet is unreachable.
+func (w *PodResourcesWatcher) Run(ctx context.Context, interval time.Duration, errors *ErrorLimiter) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if pods, err := w.list(ctx); err != nil {
+			errors.Log(ErrorClassPodResources, "%v", err)
+		} else {
+			w.mu.Lock()
+			w.pods = pods
+			w.mu.Unlock()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Pods returns the latest pods with pinned CPUs.
+func (w *PodResourcesWatcher) Pods() []PodCPUs {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.pods
+}
+
+// CPUBusy holds the busy fraction in [0, 1] of every CPU over the last
+// period and the SMT sibling of every CPU, both indexed by CPU ID. CPUs
+// without a sibling have -1.
+type CPUBusy struct {
+	Busy     []float64
+	Siblings []int32
+}
+
+func NewCPUBusy(cores [][]int32, cpuTimePeriods []CPUTimePeriod) *CPUBusy {
+	b := &CPUBusy{
+		Busy:     make([]float64, len(cpuTimePeriods)),
+		Siblings: make([]int32, len(cpuTimePeriods)),
+	}
+
+	for i := range b.Siblings {
+		b.Siblings[i] = -1
+	}
+
+	for _, cpuIds := range cores {
+		if len(cpuIds) == 2 {
+			b.Siblings[cpuIds[0]] = cpuIds[1]
+			b.Siblings[cpuIds[1]] = cpuIds[0]
+		}
+	}
+
+	for i := range cpuTimePeriods {
+		if p := &cpuTimePeriods[i]; p.TotalPeriod > 0 {
+			b.Busy[i] = 1 - float64(p.TotalIdlePeriod)/float64(p.TotalPeriod)
+		}
+	}
+
+	return b
+}
+
+// Share is the adjusted busy time of the CPU's core charged to the CPU. A
+// core counts as busy as its busiest thread, the same as the adjusted
+// formula, and that busy time is split proportionally to each thread's own
+// busy time, so the shares of both threads add up to the core's.
+func (b *CPUBusy) Share(cpuId int32) float64 {
+	if cpuId < 0 || int(cpuId) >= len(b.Busy) {
+		return 0
+	}
+
+	busy := b.Busy[cpuId]
+	sibling := b.Siblings[cpuId]
+	if sibling < 0 {
+		return busy
+	}
+
+	siblingBusy := b.Busy[sibling]
+	if busy+siblingBusy == 0 {
+		return 0
+	}
+
+	return max(busy, siblingBusy) * busy / (busy + siblingBusy)
+}
+
+// Factor is the share of the busy time of the CPUs the adjusted busy time of
+// their cores charges them, 1 when they are idle. Nil CPUs are all of them.
+func (b *CPUBusy) Factor(cpuIds []int32) float64 {
+	var busy, share float64
+	if cpuIds == nil {
+		for cpuId := range b.Busy {
+			busy += b.Busy[cpuId]
+			share += b.Share(int32(cpuId))
+		}
+	}
+
+	for _, cpuId := range cpuIds {
+		if cpuId < 0 || int(cpuId) >= len(b.Busy) {
+			continue
+		}
+
+		busy += b.Busy[cpuId]
+		share += b.Share(cpuId)
+	}
+
+	if busy == 0 {
+		return 1
+	}
+
+	return share / busy
+}
+
+// PodAttribution is the pod's share of the node's SMT-adjusted busy time, in
+// cores.
+type PodAttribution struct {
+	Namespace     string  `json:"namespace"`
+	Name          string  `json:"name"`
+	BusyCores     float64 `json:"busy_cores"`
+	AdjustedCores float64 `json:"adjusted_cores"`
+}
+
+// AttributePods splits the adjusted busy time of every physical core among
+// the pods pinned to its hardware threads. Summed over all pods pinned to a
+// node, this adds up to the node's adjusted busy time.
+func AttributePods(pods []PodCPUs, busy *CPUBusy) []PodAttribution {
+	attributions := make([]PodAttribution, 0, len(pods))
+	for _, pod := range pods {
+		attribution := PodAttribution{Namespace: pod.Namespace, Name: pod.Name}
+
+		for _, cpuId := range pod.CPUs {
+			if cpuId < 0 || int(cpuId) >= len(busy.Busy) {
+				continue
+			}
+
+			attribution.BusyCores += busy.Busy[cpuId]
+			attribution.AdjustedCores += busy.Share(cpuId)
+		}
+
+		attributions = append(attributions, attribution)
+	}
+
+	sort.Slice(attributions, func(i, j int) bool {
+		return attributions[i].AdjustedCores > attributions[j].AdjustedCores
+	})
+
+	return attributions
+}
@@ -0,0 +1,97 @@
+package rcpu
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// ValidatedVendor is the vendor the formulas were validated on, the model of
+// any other CPU gets a warning.
+const ValidatedVendor = "Intel"
+
+// Preflight is the policy for machines the formulas weren't designed for.
+// What it requires fails detection, the rest only logs a warning, so the
+// collector can consciously be run for evaluation on unsupported machines.
+type Preflight struct {
+	// RequireVendor must be in the CPU model, empty allows any vendor
+	RequireVendor string
+	RequireSMT    bool
+	// Strict fails on every warning, also on cores without two CPUs
+	Strict bool
+}
+
+// DefaultPreflight only allows Intel CPUs with SMT enabled, and warns about
+// the cores without two CPUs.
+func DefaultPreflight() Preflight {
+	return Preflight{RequireVendor: ValidatedVendor, RequireSMT: true}
+}
+
+// warn logs the warning, or returns it as err in strict mode.
+func (p Preflight) warn(err error) error {
+	if p.Strict {
+		return err
+	}
+
+	log.Printf("Warning: %v\n", err)
+	return nil
+}
+
+func (p Preflight) CheckCPUModel(model string) error {
+	if p.RequireVendor != "" && !strings.Contains(model, p.RequireVendor) {
+		return fmt.Errorf("%w: %s, expected %s, see -require-vendor", ErrUnsupportedCPU, model, p.RequireVendor)
+	}
+
+	if !strings.Contains(model, ValidatedVendor) {
+		return p.warn(fmt.Errorf("%w: %s, RCPU was only validated on %s CPUs", ErrUnsupportedCPU, model, ValidatedVendor))
+	}
+
+	return nil
+}
+
+// CheckSMTEnabled checks whether SMT is enabled, as reported by the
+// platform. Without it every CPU is a core of its own and RCPU is the average.
+func (p Preflight) CheckSMTEnabled(smt bool) error {
+	if smt {
+		return nil
+	}
+
+	if p.RequireSMT {
+		return fmt.Errorf("%w, see -require-smt", ErrSMTDisabled)
+	}
+
+	return p.warn(fmt.Errorf("%w, every CPU counts as a core and RCPU is the average usage", ErrSMTDisabled))
+}
+
+func (p Preflight) CheckSMT(h *Host) error {
+	smt, err := h.SMTEnabled()
+	if err != nil {
+		return fmt.Errorf("failed to check if SMT is enabled: %v", err)
+	}
+
+	return p.CheckSMTEnabled(smt)
+}
+
+// CheckTopology warns about every anomalous core, which the formulas count as
+// if each of its CPUs were a core of its own, and returns them. A machine
+// without a single core of two CPUs is only supported without -require-smt.
+func (p Preflight) CheckTopology(coreToCpus map[int32][]int32) ([]int32, error) {
+	anomalous := AnomalousCores(coreToCpus)
+	if len(anomalous) == len(coreToCpus) {
+		if p.RequireSMT {
+			return nil, fmt.Errorf("%w: no core has 2 CPUs", ErrUnsupportedTopology)
+		}
+
+		// SMT was already warned about, there is no point in a line per core
+		return anomalous, nil
+	}
+
+	for _, coreId := range anomalous {
+		err := fmt.Errorf("%w: core %d has %d CPUs %v, expected 2, counting them as cores of their own", ErrUnsupportedTopology, coreId, len(coreToCpus[coreId]), coreToCpus[coreId])
+		if err := p.warn(err); err != nil {
+			return nil, err
+		}
+	}
+
+	return anomalous, nil
+}
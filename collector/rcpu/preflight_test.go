@@ -0,0 +1,44 @@
+package rcpu
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPreflight(t *testing.T) {
+	lenient := Preflight{}
+	strict := Preflight{Strict: true}
+
+	if err := DefaultPreflight().CheckCPUModel("AMD EPYC 9654 96-Core Processor"); !errors.Is(err, ErrUnsupportedCPU) {
+		t.Errorf("expected AMD to be unsupported by default, got %v", err)
+	}
+	if err := lenient.CheckCPUModel("AMD EPYC 9654 96-Core Processor"); err != nil {
+		t.Errorf("expected only a warning without -require-vendor, got %v", err)
+	}
+	if err := strict.CheckCPUModel("AMD EPYC 9654 96-Core Processor"); !errors.Is(err, ErrUnsupportedCPU) {
+		t.Errorf("expected -strict to fail on the warning, got %v", err)
+	}
+
+	if err := lenient.CheckSMTEnabled(false); err != nil {
+		t.Errorf("expected only a warning without -require-smt, got %v", err)
+	}
+	if err := strict.CheckSMTEnabled(false); !errors.Is(err, ErrSMTDisabled) {
+		t.Errorf("expected -strict to fail without SMT, got %v", err)
+	}
+
+	noSMT := map[int32][]int32{0: {0}, 1: {1}}
+	if anomalous, err := lenient.CheckTopology(noSMT); err != nil || len(anomalous) != 2 {
+		t.Errorf("expected every core to be anomalous without SMT, got %v, %v", anomalous, err)
+	}
+	if _, err := DefaultPreflight().CheckTopology(noSMT); !errors.Is(err, ErrUnsupportedTopology) {
+		t.Errorf("expected no core of two CPUs to be unsupported with -require-smt, got %v", err)
+	}
+
+	hybrid := map[int32][]int32{0: {0, 1}, 1: {2}}
+	if anomalous, err := DefaultPreflight().CheckTopology(hybrid); err != nil || len(anomalous) != 1 || anomalous[0] != 1 {
+		t.Errorf("expected core 1 to be anomalous, got %v, %v", anomalous, err)
+	}
+	if _, err := strict.CheckTopology(hybrid); !errors.Is(err, ErrUnsupportedTopology) {
+		t.Errorf("expected -strict to fail on an anomalous core, got %v", err)
+	}
+}
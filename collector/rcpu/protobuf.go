@@ -0,0 +1,243 @@
+package rcpu
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative proto/rcpu/v1/rcpu.proto
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	rcpuv1 "solelab.tech/collector/proto/rcpu/v1"
+)
+
+// ContentTypeProtobuf marks bodies holding a message of proto/rcpu/v1.
+const ContentTypeProtobuf = "application/x-protobuf"
+
+// The conversions between the collector's types and the messages of
+// proto/rcpu/v1, the wire format of the pushes and the trace files.
+
+func timestampToProto(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+
+	return timestamppb.New(t)
+}
+
+func timestampFromProto(t *timestamppb.Timestamp) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+
+	return t.AsTime()
+}
+
+func groupUsagesToProto(usages []GroupUsage) []*rcpuv1.GroupUsage {
+	var pbs []*rcpuv1.GroupUsage
+	for _, usage := range usages {
+		pbs = append(pbs, &rcpuv1.GroupUsage{Id: usage.Id, AdjustedCpuUsage: usage.AdjustedCPUUsage})
+	}
+
+	return pbs
+}
+
+func groupUsagesFromProto(pbs []*rcpuv1.GroupUsage) []GroupUsage {
+	var usages []GroupUsage
+	for _, pb := range pbs {
+		usages = append(usages, GroupUsage{Id: pb.GetId(), AdjustedCPUUsage: pb.GetAdjustedCpuUsage()})
+	}
+
+	return usages
+}
+
+func SampleToProto(s *Sample) *rcpuv1.Sample {
+	pb := &rcpuv1.Sample{
+		Cluster:          s.Cluster,
+		Node:             s.Node,
+		Pool:             s.Pool,
+		Time:             timestampToProto(s.Time),
+		Cpus:             int32(s.CPUs),
+		Cores:            int32(s.Cores),
+		AvgCpuUsage:      s.AvgCPUUsage,
+		AdjustedCpuUsage: s.AdjustedCPUUsage,
+		Load1:            s.Load1,
+		Load5:            s.Load5,
+		Load15:           s.Load15,
+		Label:            s.Label,
+		Sockets:          groupUsagesToProto(s.Sockets),
+		Nodes:            groupUsagesToProto(s.Nodes),
+		IrqCpus:          s.IRQCPUs,
+		Steal:            s.Steal,
+		Derating:         s.Derating,
+		BusyMhz:          s.BusyMHz,
+		SmtInterference:  s.SMTInterference,
+	}
+
+	if s.FreeCores != nil {
+		freeCores := int32(*s.FreeCores)
+		pb.FreeCores = &freeCores
+	}
+
+	if s.Interval != 0 {
+		pb.Interval = durationpb.New(s.Interval)
+	}
+
+	for _, occupancy := range s.LLCOccupancy {
+		pb.LlcOccupancy = append(pb.LlcOccupancy, &rcpuv1.LLCOccupancy{Group: occupancy.Group, Domain: occupancy.Domain, Bytes: occupancy.Bytes})
+	}
+
+	if s.Window != nil {
+		pb.RcpuWindow = &rcpuv1.WindowStats{
+			Samples: int32(s.Window.Samples),
+			Mean:    s.Window.Mean,
+			Stddev:  s.Window.StdDev,
+			Low:     s.Window.Low,
+			High:    s.Window.High,
+		}
+	}
+
+	for _, pod := range s.Pods {
+		pb.Pods = append(pb.Pods, &rcpuv1.PodAttribution{
+			Namespace:     pod.Namespace,
+			Name:          pod.Name,
+			BusyCores:     pod.BusyCores,
+			AdjustedCores: pod.AdjustedCores,
+		})
+	}
+
+	for _, c := range s.Containers {
+		pb.Containers = append(pb.Containers, &rcpuv1.ContainerCPU{
+			Id:            c.ID,
+			Namespace:     c.Namespace,
+			Pod:           c.Pod,
+			Container:     c.Container,
+			BusyCores:     c.BusyCores,
+			AdjustedCores: c.AdjustedCores,
+		})
+	}
+
+	return pb
+}
+
+func SampleFromProto(pb *rcpuv1.Sample) *Sample {
+	s := &Sample{
+		Cluster:          pb.GetCluster(),
+		Node:             pb.GetNode(),
+		Pool:             pb.GetPool(),
+		Time:             timestampFromProto(pb.GetTime()),
+		Interval:         pb.GetInterval().AsDuration(),
+		CPUs:             int(pb.GetCpus()),
+		Cores:            int(pb.GetCores()),
+		AvgCPUUsage:      pb.GetAvgCpuUsage(),
+		AdjustedCPUUsage: pb.GetAdjustedCpuUsage(),
+		Load1:            pb.GetLoad1(),
+		Load5:            pb.GetLoad5(),
+		Load15:           pb.GetLoad15(),
+		Label:            pb.GetLabel(),
+		Sockets:          groupUsagesFromProto(pb.GetSockets()),
+		Nodes:            groupUsagesFromProto(pb.GetNodes()),
+		IRQCPUs:          pb.GetIrqCpus(),
+		Steal:            pb.GetSteal(),
+		Derating:         pb.GetDerating(),
+		BusyMHz:          pb.GetBusyMhz(),
+		SMTInterference:  pb.SmtInterference,
+	}
+
+	if pb.FreeCores != nil {
+		freeCores := int(pb.GetFreeCores())
+		s.FreeCores = &freeCores
+	}
+
+	for _, occupancy := range pb.GetLlcOccupancy() {
+		s.LLCOccupancy = append(s.LLCOccupancy, LLCOccupancy{Group: occupancy.GetGroup(), Domain: occupancy.GetDomain(), Bytes: occupancy.GetBytes()})
+	}
+
+	if window := pb.GetRcpuWindow(); window != nil {
+		s.Window = &WindowStats{
+			Samples: int(window.GetSamples()),
+			Mean:    window.GetMean(),
+			StdDev:  window.GetStddev(),
+			Low:     window.GetLow(),
+			High:    window.GetHigh(),
+		}
+	}
+
+	for _, pod := range pb.GetPods() {
+		s.Pods = append(s.Pods, PodAttribution{
+			Namespace:     pod.GetNamespace(),
+			Name:          pod.GetName(),
+			BusyCores:     pod.GetBusyCores(),
+			AdjustedCores: pod.GetAdjustedCores(),
+		})
+	}
+
+	for _, c := range pb.GetContainers() {
+		s.Containers = append(s.Containers, ContainerCPU{
+			ID:            c.GetId(),
+			Namespace:     c.GetNamespace(),
+			Pod:           c.GetPod(),
+			Container:     c.GetContainer(),
+			BusyCores:     c.GetBusyCores(),
+			AdjustedCores: c.GetAdjustedCores(),
+		})
+	}
+
+	return s
+}
+
+func CPUTimeToProto(t *CPUTime) *rcpuv1.CPUTime {
+	return &rcpuv1.CPUTime{
+		CpuId:       t.CPUId,
+		CollectTime: timestampToProto(t.CollectTime),
+		User:        t.User,
+		Nice:        t.Nice,
+		Sys:         t.Sys,
+		Idle:        t.Idle,
+		Iowait:      t.IOWait,
+		Irq:         t.IRQ,
+		Softirq:     t.SoftIRQ,
+		Steal:       t.Steal,
+		Guest:       t.Guest,
+		GuestNice:   t.GuestNice,
+	}
+}
+
+func CPUTimeFromProto(pb *rcpuv1.CPUTime) CPUTime {
+	return CPUTime{
+		CPUId:       pb.GetCpuId(),
+		CollectTime: timestampFromProto(pb.GetCollectTime()),
+		User:        pb.GetUser(),
+		Nice:        pb.GetNice(),
+		Sys:         pb.GetSys(),
+		Idle:        pb.GetIdle(),
+		IOWait:      pb.GetIowait(),
+		IRQ:         pb.GetIrq(),
+		SoftIRQ:     pb.GetSoftirq(),
+		Steal:       pb.GetSteal(),
+		Guest:       pb.GetGuest(),
+		GuestNice:   pb.GetGuestNice(),
+	}
+}
+
+func TraceRecordToProto(r *TraceRecord) *rcpuv1.TraceRecord {
+	pb := &rcpuv1.TraceRecord{Sample: SampleToProto(r.Sample)}
+	for i := range r.CPUTimes {
+		pb.CpuTimes = append(pb.CpuTimes, CPUTimeToProto(&r.CPUTimes[i]))
+	}
+
+	return pb
+}
+
+func TraceRecordFromProto(pb *rcpuv1.TraceRecord) *TraceRecord {
+	r := &TraceRecord{}
+	if pb.GetSample() != nil {
+		r.Sample = SampleFromProto(pb.GetSample())
+	}
+
+	for _, t := range pb.GetCpuTimes() {
+		r.CPUTimes = append(r.CPUTimes, CPUTimeFromProto(t))
+	}
+
+	return r
+}
@@ -0,0 +1,65 @@
+package rcpu
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	rcpuv1 "solelab.tech/collector/proto/rcpu/v1"
+)
+
+func TestSampleProtoRoundTrip(t *testing.T) {
+	interference := 0.12
+	freeCores := 2
+	sample := &Sample{
+		Cluster:          "east",
+		Node:             "node-1",
+		Pool:             "batch",
+		Time:             time.Date(2024, 1, 1, 0, 0, 1, 500, time.UTC),
+		Interval:         time.Second,
+		CPUs:             8,
+		Cores:            4,
+		FreeCores:        &freeCores,
+		AvgCPUUsage:      40,
+		AdjustedCPUUsage: 55,
+		Load1:            1.5,
+		Label:            "run-1",
+		Sockets:          []GroupUsage{{Id: 0, AdjustedCPUUsage: 50}, {Id: 1, AdjustedCPUUsage: 60}},
+		IRQCPUs:          []int32{3},
+		Derating:         0.9,
+		LLCOccupancy:     []LLCOccupancy{{Group: "/", Domain: "0", Bytes: 1 << 20}},
+		SMTInterference:  &interference,
+		Window:           &WindowStats{Samples: 10, Mean: 45, StdDev: 2, Low: 44, High: 46},
+		Pods:             []PodAttribution{{Namespace: "default", Name: "web", BusyCores: 1, AdjustedCores: 0.75}},
+		Containers:       []ContainerCPU{{ID: "abc", Namespace: "default", Pod: "web", Container: "nginx", BusyCores: 1, AdjustedCores: 0.75}},
+	}
+
+	body, err := proto.Marshal(&rcpuv1.SampleBatch{Samples: []*rcpuv1.Sample{SampleToProto(sample)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	samples, err := decodeSamples(ContentTypeProtobuf, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(samples) != 1 || !reflect.DeepEqual(samples[0], sample) {
+		t.Errorf("expected %+v, got %+v", sample, samples)
+	}
+}
+
+func TestDecodeSamplesJSON(t *testing.T) {
+	for _, body := range []string{`{"node":"node-1"}`, `[{"node":"node-1"}]`} {
+		samples, err := decodeSamples("application/json", []byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(samples) != 1 || samples[0].Node != "node-1" {
+			t.Errorf("expected node-1 from %s, got %+v", body, samples)
+		}
+	}
+}
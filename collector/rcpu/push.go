@@ -0,0 +1,254 @@
+package rcpu
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	rcpuv1 "solelab.tech/collector/proto/rcpu/v1"
+)
+
+const (
+	// NodeNameEnv is populated from spec.nodeName through the downward API
+	NodeNameEnv = "NODE_NAME"
+
+	DefaultPushQueue      = 600
+	DefaultPushTimeout    = 10 * time.Second
+	DefaultPushMinBackoff = time.Second
+	DefaultPushMaxBackoff = time.Minute
+)
+
+// NodeName names the node in the samples, NODE_NAME if set since the hostname
+// of a pod is the pod's name, or the hostname otherwise.
+func NodeName() (string, error) {
+	if nodeName := os.Getenv(NodeNameEnv); nodeName != "" {
+		return nodeName, nil
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("failed to get hostname: %v", err)
+	}
+
+	return hostname, nil
+}
+
+// SamplePusher pushes the samples to the aggregator in the background. The
+// samples queue up while the aggregator is unreachable, up to a limit past
+// which the oldest are dropped, and are sent as one batch once it is back.
+type SamplePusher struct {
+	url    string
+	token  string
+	client *http.Client
+
+	mu      sync.Mutex
+	queue   []*Sample
+	max     int
+	dropped int
+	ready   chan struct{}
+}
+
+// NewSamplePusher pushes to the aggregator at addr, e.g.
+// http://aggregator:9464, with the bearer token of a federation peer unless
+// it is empty, and the TLS configuration of an https address unless it is nil.
+func NewSamplePusher(addr, token string, tlsConfig *tls.Config) *SamplePusher {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &SamplePusher{
+		url:    strings.TrimSuffix(addr, "/") + SamplesPath,
+		token:  token,
+		client: &http.Client{Transport: transport, Timeout: DefaultPushTimeout},
+		max:    DefaultPushQueue,
+		ready:  make(chan struct{}, 1),
+	}
+}
+
+// LoadToken reads a bearer token from a file.
+func LoadToken(path string) (string, error) {
+	out, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token %s: %v", path, err)
+	}
+
+	token := strings.TrimSpace(string(out))
+	if token == "" {
+		return "", fmt.Errorf("token %s is empty", path)
+	}
+
+	return token, nil
+}
+
+// Push queues the sample without blocking the collector loop.
+func (p *SamplePusher) Push(sample *Sample) {
+	p.mu.Lock()
+	if len(p.queue) == p.max {
+		p.queue = p.queue[1:]
+		p.dropped++
+	}
+	p.queue = append(p.queue, sample)
+	p.mu.Unlock()
+
+	select {
+	case p.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Flush sends what is still queued once Run returned, when the collector
+// stops, the samples are lost if the aggregator is unreachable.
+func (p *SamplePusher) Flush() error {
+	p.mu.Lock()
+	batch := p.queue
+	p.queue = nil
+	p.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultPushTimeout)
+	defer cancel()
+
+	if err := p.send(ctx, batch); err != nil {
+		return fmt.Errorf("failed to flush %d samples: %v", len(batch), err)
+	}
+
+	return nil
+}
+
+// send posts the batch as an rcpu.v1.SampleBatch.
+func (p *SamplePusher) send(ctx context.Context, batch []*Sample) error {
+	pb := &rcpuv1.SampleBatch{Samples: make([]*rcpuv1.Sample, 0, len(batch))}
+	for _, sample := range batch {
+		pb.Samples = append(pb.Samples, SampleToProto(sample))
+	}
+
+	body, err := proto.Marshal(pb)
+	if err != nil {
+		return fmt.Errorf("failed to encode samples: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ContentTypeProtobuf)
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push samples: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to push samples: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
+
+// Run sends the queued samples until ctx is done, backing off exponentially
+// while the aggregator is unreachable.
+func (p *SamplePusher) Run(ctx context.Context, errors *ErrorLimiter) {
+	backoff := DefaultPushMinBackoff
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.ready:
+		}
+
+		p.mu.Lock()
+		batch := p.queue
+		p.queue = nil
+		dropped := p.dropped
+		p.dropped = 0
+		p.mu.Unlock()
+
+		if dropped > 0 {
+			log.Printf("Dropped %d samples the aggregator was unreachable for\n", dropped)
+		}
+
+		if len(batch) == 0 {
+			continue
+		}
+
+		err := p.send(ctx, batch)
+		if err == nil {
+			backoff = DefaultPushMinBackoff
+			continue
+		}
+		errors.Log(ErrorClassPush, "%v, retrying in %v", err, backoff)
+
+		// Put the batch back in front of what queued up meanwhile
+		p.mu.Lock()
+		p.queue = append(batch, p.queue...)
+		if n := len(p.queue) - p.max; n > 0 {
+			p.queue = p.queue[n:]
+			p.dropped += n
+		}
+		p.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, DefaultPushMaxBackoff)
+
+		select {
+		case p.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// pusherSink pushes the samples to the aggregator at its address, from a
+// SamplePusher running until the sink's ctx is done.
+type pusherSink struct {
+	pusher *SamplePusher
+	done   chan struct{}
+}
+
+func newPusherSink(ctx context.Context, addr string, config SinkConfig) (Sink, error) {
+	opts := config.Options
+
+	sink := &pusherSink{
+		pusher: NewSamplePusher(addr, opts.AggregatorToken, opts.AggregatorTLS),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(sink.done)
+		sink.pusher.Run(ctx, config.Errors)
+	}()
+
+	return sink, nil
+}
+
+func (s *pusherSink) Write(ctx context.Context, sample Sample) error {
+	s.pusher.Push(&sample)
+	return nil
+}
+
+// Close sends what is still queued once the pusher stopped.
+func (s *pusherSink) Close() error {
+	<-s.done
+	return s.pusher.Flush()
+}
@@ -0,0 +1,246 @@
+package rcpu
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// The collector's defaults leave room for a few scrapers and annotators,
+	// but not for one polling in a loop
+	DefaultMetricsRateLimit     = 5.0
+	DefaultMetricsRateBurst     = 20
+	DefaultMetricsMaxConcurrent = 4
+
+	// Clients idle for this long are forgotten, their bucket is full again
+	rateLimitIdle = time.Minute
+)
+
+// clientBucket is the token bucket of a client
+type clientBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// RequestLimiter limits the requests of every client to a rate, with bursts,
+// and the requests being served to a cap. Requests past either are refused
+// rather than queued, so a misbehaving client costs the server next to
+// nothing. Clients are told apart by their address.
+type RequestLimiter struct {
+	rate  float64
+	burst float64
+	// slots holds a token per request being served, nil without a cap
+	slots chan struct{}
+
+	mu        sync.Mutex
+	clients   map[string]*clientBucket
+	lastSweep time.Time
+}
+
+// NewRequestLimiter allows every client rate requests per second, and burst
+// at once, and serves up to maxConcurrent requests. A zero rate or cap
+// disables that limit.
+func NewRequestLimiter(rate float64, burst, maxConcurrent int) *RequestLimiter {
+	l := &RequestLimiter{
+		rate:    rate,
+		burst:   math.Max(float64(burst), 1),
+		clients: make(map[string]*clientBucket),
+	}
+
+	if maxConcurrent > 0 {
+		l.slots = make(chan struct{}, maxConcurrent)
+	}
+
+	return l
+}
+
+// allow takes a token from the client's bucket, refilled at the rate
+func (l *RequestLimiter) allow(client string, now time.Time) bool {
+	if l.rate <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > rateLimitIdle {
+		for key, b := range l.clients {
+			if now.Sub(b.updated) > rateLimitIdle {
+				delete(l.clients, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.clients[client]
+	if !ok {
+		b = &clientBucket{tokens: l.burst, updated: now}
+		l.clients[client] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.updated).Seconds()*l.rate)
+	b.updated = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+
+	return true
+}
+
+// acquire takes a slot, release gives it back
+func (l *RequestLimiter) acquire() bool {
+	if l.slots == nil {
+		return true
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *RequestLimiter) release() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// retryAfter is how long until a client out of tokens has one again, in
+// whole seconds
+func (l *RequestLimiter) retryAfter() string {
+	return strconv.Itoa(int(math.Ceil(1 / l.rate)))
+}
+
+// clientHost is the client's address without the port, which changes with
+// every connection
+func clientHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+
+	return addr
+}
+
+// Handler limits the requests to h, answering 429 past the client's rate and
+// 503 past the cap. A nil limiter serves every request.
+func (l *RequestLimiter) Handler(h http.Handler) http.Handler {
+	if l == nil {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.allow(clientHost(r.RemoteAddr), time.Now()) {
+			w.Header().Set("Retry-After", l.retryAfter())
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		if !l.acquire() {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many concurrent requests", http.StatusServiceUnavailable)
+			return
+		}
+		defer l.release()
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// GRPCServerOptions limits the calls of a gRPC server like Handler, with
+// ResourceExhausted and Unavailable. A nil limiter adds no options.
+func (l *RequestLimiter) GRPCServerOptions() []grpc.ServerOption {
+	if l == nil {
+		return nil
+	}
+
+	check := func(ctx context.Context) error {
+		var client string
+		if p, ok := peer.FromContext(ctx); ok {
+			client = clientHost(p.Addr.String())
+		}
+
+		if !l.allow(client, time.Now()) {
+			return status.Error(codes.ResourceExhausted, "too many requests")
+		}
+
+		if !l.acquire() {
+			return status.Error(codes.Unavailable, "too many concurrent requests")
+		}
+
+		return nil
+	}
+
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			if err := check(ctx); err != nil {
+				return nil, err
+			}
+			defer l.release()
+
+			return handler(ctx, req)
+		}),
+		grpc.ChainStreamInterceptor(func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := check(ss.Context()); err != nil {
+				return err
+			}
+			defer l.release()
+
+			return handler(srv, ss)
+		}),
+	}
+}
+
+// RequestLimitFlags are the flags of a RequestLimiter, see
+// AddRequestLimitFlags.
+type RequestLimitFlags struct {
+	rate          *float64
+	burst         *int
+	maxConcurrent *int
+}
+
+// AddRequestLimitFlags adds the request limit flags of a server, their names
+// starting with prefix, e.g. -metrics-rate-limit, with their defaults.
+func AddRequestLimitFlags(fs *flag.FlagSet, prefix, server string, rate float64, burst, maxConcurrent int) *RequestLimitFlags {
+	return &RequestLimitFlags{
+		rate:          fs.Float64(prefix+"rate-limit", rate, "requests per second every client of "+server+" may make, 0 disables the limit"),
+		burst:         fs.Int(prefix+"rate-burst", burst, "requests a client of "+server+" may make at once past -"+prefix+"rate-limit"),
+		maxConcurrent: fs.Int(prefix+"max-concurrent", maxConcurrent, "requests "+server+" serves at once, refusing the others, 0 disables the cap"),
+	}
+}
+
+// Limiter validates the flags and returns the limiter, nil if both limits are
+// disabled.
+func (f *RequestLimitFlags) Limiter() (*RequestLimiter, error) {
+	if *f.rate < 0 || math.IsNaN(*f.rate) || math.IsInf(*f.rate, 0) {
+		return nil, fmt.Errorf("invalid rate limit %g", *f.rate)
+	}
+
+	if *f.burst < 1 {
+		return nil, fmt.Errorf("invalid rate burst %d, must be at least 1", *f.burst)
+	}
+
+	if *f.maxConcurrent < 0 {
+		return nil, fmt.Errorf("invalid max concurrent requests %d", *f.maxConcurrent)
+	}
+
+	if *f.rate == 0 && *f.maxConcurrent == 0 {
+		return nil, nil
+	}
+
+	return NewRequestLimiter(*f.rate, *f.burst, *f.maxConcurrent), nil
+}
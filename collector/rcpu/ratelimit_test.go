@@ -0,0 +1,73 @@
+package rcpu
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestLimiterRate(t *testing.T) {
+	l := NewRequestLimiter(1, 2, 0)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, want := range []bool{true, true, false} {
+		if got := l.allow("10.0.0.1", now); got != want {
+			t.Errorf("request %d: expected %v, got %v", i, want, got)
+		}
+	}
+
+	// Other clients have buckets of their own
+	if !l.allow("10.0.0.2", now) {
+		t.Errorf("expected another client to be allowed")
+	}
+
+	if !l.allow("10.0.0.1", now.Add(time.Second)) {
+		t.Errorf("expected a token after a second")
+	}
+	if l.allow("10.0.0.1", now.Add(time.Second)) {
+		t.Errorf("expected a single token after a second")
+	}
+
+	// Idle clients are forgotten
+	l.allow("10.0.0.3", now.Add(2*rateLimitIdle))
+	if _, ok := l.clients["10.0.0.1"]; ok {
+		t.Errorf("expected the idle client to be forgotten")
+	}
+}
+
+func TestRequestLimiterHandler(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	h := NewRequestLimiter(0, 1, 1).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+
+	done := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		done <- rec.Code
+	}()
+	<-started
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 past the cap, got %d", rec.Code)
+	}
+
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("expected the first request to be served, got %d", code)
+	}
+
+	limited := NewRequestLimiter(1, 1, 0).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	limited.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	rec = httptest.NewRecorder()
+	limited.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("expected 429 with Retry-After past the rate, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
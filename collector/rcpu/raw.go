@@ -0,0 +1,162 @@
+package rcpu
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// RawCPU is a CPU's cumulative counters as the kernel reports them, in
+// USER_HZ ticks, and the periods computed from them for the last interval.
+type RawCPU struct {
+	Time string `json:"time"`
+	CPU  int32  `json:"cpu"`
+	Core int32  `json:"core"`
+	// The kernel counts guest time in user time as well, the counters are
+	// reported that way here too
+	User      uint64 `json:"user"`
+	Nice      uint64 `json:"nice"`
+	Sys       uint64 `json:"sys"`
+	Idle      uint64 `json:"idle"`
+	IOWait    uint64 `json:"iowait"`
+	IRQ       uint64 `json:"irq"`
+	SoftIRQ   uint64 `json:"softirq"`
+	Steal     uint64 `json:"steal"`
+	Guest     uint64 `json:"guest"`
+	GuestNice uint64 `json:"guestNice"`
+
+	ElapsedNanos  int64  `json:"elapsedNanos"`
+	UserPeriod    uint64 `json:"userPeriod"`
+	NicePeriod    uint64 `json:"nicePeriod"`
+	SysPeriod     uint64 `json:"sysPeriod"`
+	IdlePeriod    uint64 `json:"idlePeriod"`
+	IOWaitPeriod  uint64 `json:"iowaitPeriod"`
+	IRQPeriod     uint64 `json:"irqPeriod"`
+	SoftIRQPeriod uint64 `json:"softirqPeriod"`
+	StealPeriod   uint64 `json:"stealPeriod"`
+	GuestPeriod   uint64 `json:"guestPeriod"`
+	TotalPeriod   uint64 `json:"totalPeriod"`
+}
+
+var rawCSVHeader = []string{
+	"time", "cpu", "core",
+	"user", "nice", "sys", "idle", "iowait", "irq", "softirq", "steal", "guest", "guest_nice",
+	"elapsed_nanos", "user_period", "nice_period", "sys_period", "idle_period", "iowait_period",
+	"irq_period", "softirq_period", "steal_period", "guest_period", "total_period",
+}
+
+func NewRawCPU(t *CPUTime, p *CPUTimePeriod, core int32, timeFormat TimeFormat) RawCPU {
+	return RawCPU{
+		Time:      timeFormat.Format(t.CollectTime),
+		CPU:       t.CPUId,
+		Core:      core,
+		User:      t.User + t.Guest,
+		Nice:      t.Nice + t.GuestNice,
+		Sys:       t.Sys,
+		Idle:      t.Idle,
+		IOWait:    t.IOWait,
+		IRQ:       t.IRQ,
+		SoftIRQ:   t.SoftIRQ,
+		Steal:     t.Steal,
+		Guest:     t.Guest,
+		GuestNice: t.GuestNice,
+
+		ElapsedNanos:  p.Elapsed.Nanoseconds(),
+		UserPeriod:    p.UserPeriod,
+		NicePeriod:    p.NicePeriod,
+		SysPeriod:     p.SysPeriod,
+		IdlePeriod:    p.IdlePeriod,
+		IOWaitPeriod:  p.IOWaitPeriod,
+		IRQPeriod:     p.IRQPeriod,
+		SoftIRQPeriod: p.SoftIRQPeriod,
+		StealPeriod:   p.StealPeriod,
+		GuestPeriod:   p.GuestPeriod,
+		TotalPeriod:   p.TotalPeriod,
+	}
+}
+
+func (r *RawCPU) csvLine() []string {
+	u := func(v uint64) string { return strconv.FormatUint(v, 10) }
+
+	return []string{
+		r.Time, strconv.Itoa(int(r.CPU)), strconv.Itoa(int(r.Core)),
+		u(r.User), u(r.Nice), u(r.Sys), u(r.Idle), u(r.IOWait), u(r.IRQ), u(r.SoftIRQ), u(r.Steal), u(r.Guest), u(r.GuestNice),
+		strconv.FormatInt(r.ElapsedNanos, 10), u(r.UserPeriod), u(r.NicePeriod), u(r.SysPeriod), u(r.IdlePeriod), u(r.IOWaitPeriod),
+		u(r.IRQPeriod), u(r.SoftIRQPeriod), u(r.StealPeriod), u(r.GuestPeriod), u(r.TotalPeriod),
+	}
+}
+
+// RawWriter writes a line per CPU and tick, as CSV or JSON lines, for
+// downstream systems doing their own windowing.
+type RawWriter struct {
+	csv     *csv.Writer
+	json    *json.Encoder
+	started bool
+}
+
+func NewRawWriter(w io.Writer, output string) *RawWriter {
+	if output == OutputCSV {
+		return &RawWriter{csv: csv.NewWriter(w)}
+	}
+
+	return &RawWriter{json: json.NewEncoder(w)}
+}
+
+func (r *RawWriter) Write(cpus []RawCPU) error {
+	if r.json != nil {
+		for i := range cpus {
+			if err := r.json.Encode(&cpus[i]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if !r.started {
+		r.csv.Write(rawCSVHeader)
+		r.started = true
+	}
+
+	for i := range cpus {
+		r.csv.Write(cpus[i].csvLine())
+	}
+	r.csv.Flush()
+
+	return r.csv.Error()
+}
+
+// rawSink writes the counters of every CPU to stdout, in the -output format.
+type rawSink struct {
+	raw        *RawWriter
+	timeFormat TimeFormat
+	cpus       []RawCPU
+}
+
+func newRawSink(ctx context.Context, arg string, config SinkConfig) (Sink, error) {
+	opts := config.Options
+
+	return &rawSink{raw: NewRawWriter(os.Stdout, opts.Output), timeFormat: opts.TimeFormat.Or(TimeFormatRFC3339)}, nil
+}
+
+func (s *rawSink) Write(ctx context.Context, sample Sample) error {
+	s.cpus = s.cpus[:0]
+	for i := range sample.CPUTimes {
+		cpuId := sample.CPUTimes[i].CPUId
+		s.cpus = append(s.cpus, NewRawCPU(&sample.CPUTimes[i], &sample.CPUTimePeriods[cpuId], sample.Detection.CPUToCore[cpuId], s.timeFormat))
+	}
+
+	if err := s.raw.Write(s.cpus); err != nil {
+		return fmt.Errorf("failed to write output: %v", err)
+	}
+
+	return nil
+}
+
+func (s *rawSink) Close() error {
+	return nil
+}
@@ -0,0 +1,1393 @@
+package rcpu
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"net/http"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	apiv1 "solelab.tech/collector/api/v1"
+	"solelab.tech/collector/cputime"
+	"solelab.tech/collector/internal/parse"
+)
+
+const (
+	ProcRootDir     = "/proc"
+	ProcCPUInfoName = "cpuinfo"
+	ProcStatName    = "stat"
+
+	SysRootDir          = "/sys"
+	SysCPUSMTActivePath = "devices/system/cpu/smt/active"
+)
+
+type Options struct {
+	Interval        time.Duration
+	Adaptive        bool
+	FastInterval    time.Duration
+	NFDFeaturesFile string
+	NFDHysteresis   float64
+	MetricsListen   string
+	MetricsPerCore  bool
+	MetricsSecurity ServerSecurity
+	MetricsLimiter  *RequestLimiter
+	ProcRoot        string
+	SysRoot         string
+	Rows            int
+	NoColor         bool
+	PerCore         bool
+	Sort            string
+	Preflight       Preflight
+	CPUs            string
+	Heatmap         bool
+	Output          string
+	Fields          []Field
+	TimeFormat      TimeFormat
+	Raw             bool
+	LogFile         string
+	LogMaxSize      int64
+	LogMaxAge       time.Duration
+	LogMaxBackups   int
+	CgroupCheck     time.Duration
+	Label           string
+	IRQRatio        float64
+	ExcludeIRQCPUs  bool
+	SiblingModel    string
+	SMTYield        float64
+	Window          int
+	AnomalyZ        float64
+	RollupFile      string
+	Rollups         []time.Duration
+	Aggregator      string
+	AggregatorToken string
+	AggregatorTLS   *tls.Config
+	MarkToken       string
+	TraceFile       string
+	TraceCodec      string
+	Node            string
+	Pool            string
+	PodResources    string
+	CRIEndpoint     string
+	Remote          string
+	SSH             string
+	Upstream        string
+	UpstreamBuffer  string
+	// Sinks are the specs of the sinks, see ValidateSinks, those of -sink
+	// and those the other flags imply, e.g. the -output of the machine view
+	Sinks []string
+}
+
+// DefaultOptions returns the defaults of the collector's flags, without any
+// sink or node name.
+func DefaultOptions() *Options {
+	fields, err := ParseFields(DefaultFields)
+	if err != nil {
+		panic(err)
+	}
+
+	rollups, err := ParseRollups(DefaultRollups)
+	if err != nil {
+		panic(err)
+	}
+
+	return &Options{
+		Interval:       time.Second,
+		FastInterval:   DefaultAdaptiveFastInterval,
+		NFDHysteresis:  DefaultHeadroomHysteresis,
+		MetricsLimiter: NewRequestLimiter(DefaultMetricsRateLimit, DefaultMetricsRateBurst, DefaultMetricsMaxConcurrent),
+		ProcRoot:       ProcRootDir,
+		SysRoot:        SysRootDir,
+		Rows:           DefaultDisplayRows,
+		Sort:           SortBusy,
+		Preflight:      DefaultPreflight(),
+		Output:         OutputTable,
+		Fields:         fields,
+		LogMaxSize:     DefaultLogMaxSize,
+		LogMaxAge:      DefaultLogMaxAge,
+		LogMaxBackups:  DefaultLogMaxBackups,
+		IRQRatio:       DefaultIRQRatio,
+		SiblingModel:   SiblingModelMax,
+		SMTYield:       DefaultSMTYield,
+		Window:         DefaultWindow,
+		AnomalyZ:       DefaultAnomalyZ,
+		Rollups:        rollups,
+		TraceCodec:     TraceCompressionNone,
+		UpstreamBuffer: DefaultUpstreamBufferDir,
+	}
+}
+
+// validateIntervals checks the sampling intervals, of -adaptive too.
+func (opts *Options) validateIntervals() error {
+	if opts.Interval < MinInterval {
+		return fmt.Errorf("invalid interval %v, must be at least %v", opts.Interval, MinInterval)
+	}
+
+	if opts.Adaptive && (opts.FastInterval < MinInterval || opts.FastInterval > opts.Interval) {
+		return fmt.Errorf("invalid fast interval %v, must be at least %v and at most %v", opts.FastInterval, MinInterval, opts.Interval)
+	}
+
+	return nil
+}
+
+// ParseOptions parses the flags of the collector, name is the remote command
+// when it reads another machine, which adds the flags of the SSH session.
+// The flags default to DefaultOptions.
+func ParseOptions(name string, args []string) (*Options, error) {
+	opts := DefaultOptions()
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.DurationVar(&opts.Interval, "interval", opts.Interval, "sampling interval, ticks are aligned to multiples of it on the wall clock")
+	fs.BoolVar(&opts.Adaptive, "adaptive", false, "sample at -interval on quiet nodes and at -fast-interval when usage is volatile or near the overload threshold")
+	fs.DurationVar(&opts.FastInterval, "fast-interval", opts.FastInterval, "sampling interval of -adaptive during bursts")
+	fs.StringVar(&opts.NFDFeaturesFile, "nfd-features-file", "", "maintain a Node Feature Discovery local feature file, e.g. /etc/kubernetes/node-feature-discovery/features.d/rcpu")
+	fs.Float64Var(&opts.NFDHysteresis, "nfd-hysteresis", opts.NFDHysteresis, "how far past a boundary, in percent, the mean RCPU over -window has to be before the headroom label changes")
+	fs.StringVar(&opts.MetricsListen, "metrics-listen", "", "serve Prometheus metrics on this address, e.g. :9465")
+	fs.BoolVar(&opts.MetricsPerCore, "metrics-per-core", false, "also export the usage of every physical core with -metrics-listen, a series per core")
+	fs.StringVar(&opts.ProcRoot, "proc-root", opts.ProcRoot, "where procfs is mounted, e.g. /host/proc in a container")
+	fs.StringVar(&opts.SysRoot, "sys-root", opts.SysRoot, "where sysfs is mounted, the topology is read from it instead of lscpu unless it is /sys")
+	fs.IntVar(&opts.Rows, "rows", opts.Rows, "number of recent samples shown in the table")
+	fs.BoolVar(&opts.NoColor, "no-color", false, "print plain text, also the case with NO_COLOR set or when stdout isn't a terminal")
+	fs.BoolVar(&opts.PerCore, "per-core", false, "show the usage of every physical core, up to -rows of them, instead of the machine")
+	fs.StringVar(&opts.Sort, "sort", opts.Sort, "order of the per-core view, one of busy, idle, core or diff")
+	fs.StringVar(&opts.Preflight.RequireVendor, "require-vendor", opts.Preflight.RequireVendor, "fail unless the CPU model contains this vendor, empty to run on any CPU with a warning")
+	fs.BoolVar(&opts.Preflight.RequireSMT, "require-smt", opts.Preflight.RequireSMT, "fail unless SMT is enabled, false to run without it with a warning, RCPU is then the average")
+	fs.BoolVar(&opts.Preflight.Strict, "strict", false, "fail on every preflight warning instead, also on cores without two CPUs")
+	fs.StringVar(&opts.CPUs, "cpus", "", "only collect these CPUs, in cpuset list syntax, e.g. 0-15,32-47 for a shared pool")
+	fs.BoolVar(&opts.Heatmap, "heatmap", false, "show a heatmap of every logical CPU, siblings next to each other, instead of the table")
+	fs.StringVar(&opts.Output, "output", opts.Output, "output format, one of table, csv or json")
+	fields := fs.String("fields", DefaultFields, "comma separated columns of the table, CSV or JSON, out of "+strings.Join(fieldNames(), ","))
+	timeFormat := fs.String("time-format", "", "timestamps as clock, rfc3339 or unix, defaults to clock for the table and rfc3339 for CSV and JSON")
+	utc := fs.Bool("utc", false, "print timestamps in UTC instead of local time")
+	fs.StringVar(&opts.LogFile, "log-file", "", "write the collector's logs to this file instead of stderr, rotating it")
+	logMaxSizeMB := fs.Int64("log-max-size", DefaultLogMaxSize/1024/1024, "rotate -log-file past this many megabytes")
+	fs.DurationVar(&opts.LogMaxAge, "log-max-age", opts.LogMaxAge, "rotate -log-file once it is older than this, 0 disables it")
+	fs.IntVar(&opts.LogMaxBackups, "log-max-backups", opts.LogMaxBackups, "number of rotated log files to keep")
+	fs.BoolVar(&opts.Raw, "raw", false, "print the cumulative counters and periods of every CPU in ticks, as JSON lines or with -output csv as CSV")
+	fs.DurationVar(&opts.CgroupCheck, "cgroup-check", 0, "compare the busy time of /proc/stat with the root cgroup's CPU usage this often, 0 disables it")
+	fs.StringVar(&opts.Label, "label", "", "label the samples until another mark is posted to "+MarksPath+", see the mark command")
+	metricsSecurity := AddServerSecurityFlags(fs, "metrics-", "-metrics-listen")
+	metricsLimits := AddRequestLimitFlags(fs, "metrics-", "-metrics-listen", DefaultMetricsRateLimit, DefaultMetricsRateBurst, DefaultMetricsMaxConcurrent)
+	markTokenFile := fs.String("mark-token-file", "", "require marks posted to "+MarksPath+" to present the bearer token in this file, only local clients may post without it")
+	fs.Float64Var(&opts.IRQRatio, "irq-ratio", opts.IRQRatio, "flag CPUs spending at least this share of their busy time in IRQ and SoftIRQ")
+	fs.BoolVar(&opts.ExcludeIRQCPUs, "exclude-irq-cpus", false, "leave the cores of IRQ-heavy CPUs out of the usage and RCPU, as they aren't available to workloads")
+	fs.StringVar(&opts.SiblingModel, "sibling-model", opts.SiblingModel, "how busy SMT siblings add up to a core, max counts a core as its busiest thread, yield adds -smt-yield for the overlap of its threads, overlap does so assuming they run independently, ipc weights the overlap by the IPC lost to the sibling, measured with perf events")
+	fs.Float64Var(&opts.SMTYield, "smt-yield", opts.SMTYield, "share of a core the second busy thread adds, for the yield and overlap sibling models")
+	fs.IntVar(&opts.Window, "window", opts.Window, "number of samples the mean, standard deviation and confidence bounds of RCPU are computed over, 0 disables them")
+	fs.Float64Var(&opts.AnomalyZ, "anomaly-z", opts.AnomalyZ, "log an anomaly when the adjusted usage is this many standard deviations from its recent mean, 0 disables it")
+	fs.StringVar(&opts.RollupFile, "rollup-file", "", "append rollups of the -fields to files named after this prefix, e.g. /var/log/rcpu gives /var/log/rcpu-1m.csv")
+	rollups := fs.String("rollups", DefaultRollups, "comma separated periods of the -rollup-file and rollup sink rollups, each row holds the mean, min and max over a period")
+	fs.StringVar(&opts.Aggregator, "aggregator", "", "push the samples to the aggregator at this address, e.g. http://aggregator:9464")
+	fs.StringVar(&opts.Upstream, "upstream", "", "push the samples to the aggregator's -grpc-listen over gRPC, e.g. grpcs://aggregator:443, buffering them on disk while it is unreachable")
+	fs.StringVar(&opts.UpstreamBuffer, "upstream-buffer", opts.UpstreamBuffer, "directory buffering the samples of -upstream until the aggregator took them")
+	aggregatorTokenFile := fs.String("aggregator-token-file", "", "authenticate to the aggregator with the bearer token in this file, as a federation peer")
+	aggregatorCertFile := fs.String("aggregator-cert-file", "", "authenticate to the aggregator with the PEM client certificate in this file, reloaded once rotated, e.g. the svid.pem of spiffe-helper")
+	aggregatorKeyFile := fs.String("aggregator-key-file", "", "PEM key of the -aggregator-cert-file certificate")
+	aggregatorCAFile := fs.String("aggregator-ca-file", "", "verify the aggregator against the PEM CAs in this file instead of the system's, reloaded once rotated")
+	aggregatorSPIFFEID := fs.String("aggregator-spiffe-id", "", "verify the aggregator presents this SPIFFE ID, e.g. spiffe://example.org/rcpu/aggregator, instead of its host name")
+	fs.StringVar(&opts.Node, "node", "", "node name of the samples, defaults to "+NodeNameEnv+" or the hostname")
+	fs.StringVar(&opts.Pool, "pool", "", "node pool of the samples pushed to the aggregator, defaults to "+DefaultPool)
+	fs.StringVar(&opts.PodResources, "pod-resources-socket", "", "attribute the adjusted usage to the pods with pinned CPUs listed by the kubelet podresources API at this socket, e.g. "+DefaultPodResourcesSocket)
+	fs.StringVar(&opts.TraceFile, "trace-file", "", "record every sample with the counters of every CPU to this trace file, see the replay command")
+	fs.StringVar(&opts.TraceCodec, "trace-compression", opts.TraceCodec, "compression of the -trace-file chunks, "+TraceCompressionNone+" or "+TraceCompressionZstd)
+	fs.Func("sink", "also write the samples to this sink, NAME[:ARG] with NAME one of "+strings.Join(SinkNames(), ", ")+", e.g. csv:/var/log/rcpu.csv, repeatable", func(spec string) error {
+		opts.Sinks = append(opts.Sinks, spec)
+		return nil
+	})
+	fs.StringVar(&opts.CRIEndpoint, "cri-endpoint", "", "attribute the adjusted usage to the containers of the container runtime at this endpoint, from the usage of their cgroups, e.g. "+DefaultCRIEndpoint)
+	if name == RemoteCommand {
+		fs.StringVar(&opts.Remote, "host", "", "read the machine at this SSH destination, e.g. user@node")
+		fs.StringVar(&opts.SSH, "ssh", DefaultSSHCommand, "SSH client and its options, split on spaces, e.g. \"ssh -i key -p 2222\"")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	opts.LogMaxSize = *logMaxSizeMB * 1024 * 1024
+
+	if name == RemoteCommand {
+		if opts.Remote == "" {
+			return nil, fmt.Errorf("-host is required")
+		}
+
+		if len(strings.Fields(opts.SSH)) == 0 {
+			return nil, fmt.Errorf("-ssh is empty")
+		}
+
+		// These read the local machine, which isn't the one collected
+		if opts.CgroupCheck > 0 || opts.PodResources != "" || opts.CRIEndpoint != "" || opts.SiblingModel == SiblingModelIPC {
+			return nil, fmt.Errorf("-cgroup-check, -pod-resources-socket, -cri-endpoint and -sibling-model %s only apply to the local machine", SiblingModelIPC)
+		}
+
+		// The node name of the samples is the remote host's
+		if opts.Node == "" {
+			opts.Node = opts.Remote[strings.LastIndex(opts.Remote, "@")+1:]
+		}
+	}
+
+	if opts.Rows <= 0 {
+		return nil, fmt.Errorf("invalid number of rows %d", opts.Rows)
+	}
+
+	if opts.Heatmap && opts.PerCore {
+		return nil, fmt.Errorf("-heatmap and -per-core are exclusive")
+	}
+
+	if opts.Raw && (opts.Heatmap || opts.PerCore) {
+		return nil, fmt.Errorf("-raw replaces the other views")
+	}
+
+	if opts.RollupFile != "" && (opts.Raw || opts.Heatmap || opts.PerCore) {
+		return nil, fmt.Errorf("-rollup-file only applies to the machine view")
+	}
+
+	if opts.MetricsPerCore && opts.MetricsListen == "" {
+		return nil, fmt.Errorf("-metrics-per-core only applies to -metrics-listen")
+	}
+
+	if opts.IRQRatio <= 0 || opts.IRQRatio > 1 {
+		return nil, fmt.Errorf("invalid IRQ ratio %v, must be in (0, 1]", opts.IRQRatio)
+	}
+
+	if opts.CgroupCheck < 0 {
+		return nil, fmt.Errorf("invalid cgroup check interval %v", opts.CgroupCheck)
+	}
+
+	// The root cgroup covers every CPU, a subset can't be compared against it
+	if opts.CgroupCheck > 0 && opts.CPUs != "" {
+		return nil, fmt.Errorf("-cgroup-check and -cpus are exclusive")
+	}
+
+	if err := ValidateOutput(opts.Output); err != nil {
+		return nil, err
+	}
+
+	if err := ValidateSiblingModel(opts.SiblingModel); err != nil {
+		return nil, err
+	}
+
+	if opts.AnomalyZ < 0 {
+		return nil, fmt.Errorf("invalid anomaly threshold %v", opts.AnomalyZ)
+	}
+
+	if opts.Window < 0 {
+		return nil, fmt.Errorf("invalid window of %d samples", opts.Window)
+	}
+
+	if opts.NFDHysteresis < 0 {
+		return nil, fmt.Errorf("invalid NFD hysteresis %v", opts.NFDHysteresis)
+	}
+
+	if opts.SMTYield < 0 || opts.SMTYield > 1 {
+		return nil, fmt.Errorf("invalid SMT yield %v, must be in [0, 1]", opts.SMTYield)
+	}
+
+	if opts.Output != OutputTable && (opts.Heatmap || opts.PerCore) {
+		return nil, fmt.Errorf("-output %s only applies to the machine view", opts.Output)
+	}
+
+	var err error
+	if opts.Fields, err = ParseFields(*fields); err != nil {
+		return nil, err
+	}
+
+	if opts.Rollups, err = ParseRollups(*rollups); err != nil {
+		return nil, err
+	}
+
+	opts.TimeFormat = TimeFormat{UTC: *utc}
+	if *timeFormat != "" {
+		if opts.TimeFormat, err = ParseTimeFormat(*timeFormat, *utc); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ValidateSortKey(opts.Sort); err != nil {
+		return nil, err
+	}
+
+	if err := opts.validateIntervals(); err != nil {
+		return nil, err
+	}
+
+	if err := ValidateMarkLabel(opts.Label); err != nil {
+		return nil, fmt.Errorf("invalid -label: %v", err)
+	}
+
+	if opts.MetricsSecurity, err = metricsSecurity.Load(); err != nil {
+		return nil, err
+	}
+
+	if opts.MetricsLimiter, err = metricsLimits.Limiter(); err != nil {
+		return nil, err
+	}
+
+	if *markTokenFile != "" {
+		if opts.MarkToken, err = LoadToken(*markTokenFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Upstream != "" && opts.Aggregator != "" {
+		return nil, fmt.Errorf("-aggregator and -upstream are exclusive")
+	}
+
+	// The views and exporters of the flags are sinks like those of -sink
+	switch {
+	case opts.Raw:
+		opts.Sinks = append(opts.Sinks, SinkRaw)
+	case opts.Heatmap:
+		opts.Sinks = append(opts.Sinks, SinkHeatmap)
+	case opts.PerCore:
+		opts.Sinks = append(opts.Sinks, SinkPerCore)
+	default:
+		opts.Sinks = append(opts.Sinks, opts.Output)
+	}
+	if opts.TraceFile != "" {
+		opts.Sinks = append(opts.Sinks, SinkTrace+":"+opts.TraceFile)
+	}
+	if opts.NFDFeaturesFile != "" {
+		opts.Sinks = append(opts.Sinks, SinkNFD+":"+opts.NFDFeaturesFile)
+	}
+	if opts.RollupFile != "" {
+		opts.Sinks = append(opts.Sinks, SinkRollup+":"+opts.RollupFile)
+	}
+	if opts.Aggregator != "" {
+		opts.Sinks = append(opts.Sinks, SinkAggregator+":"+opts.Aggregator)
+	}
+	if opts.Upstream != "" {
+		opts.Sinks = append(opts.Sinks, SinkUpstream+":"+opts.Upstream)
+	}
+	if err := ValidateSinks(opts.Sinks); err != nil {
+		return nil, err
+	}
+
+	if *aggregatorTokenFile != "" {
+		if opts.AggregatorToken, err = LoadToken(*aggregatorTokenFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if *aggregatorCertFile != "" || *aggregatorKeyFile != "" || *aggregatorCAFile != "" || *aggregatorSPIFFEID != "" {
+		var secure bool
+		for _, spec := range opts.Sinks {
+			s, _ := ParseSinkSpec(spec)
+			secure = secure || s.Name == SinkAggregator && strings.HasPrefix(s.Arg, "https://") || s.Name == SinkUpstream && strings.HasPrefix(s.Arg, "grpcs://")
+		}
+		if !secure {
+			return nil, fmt.Errorf("the aggregator's TLS flags require an https:// -aggregator or a grpcs:// -upstream")
+		}
+
+		if *aggregatorSPIFFEID != "" {
+			if err := ValidateSPIFFEID(*aggregatorSPIFFEID); err != nil {
+				return nil, err
+			}
+		}
+
+		reloader, err := NewCertReloader(*aggregatorCertFile, *aggregatorKeyFile, *aggregatorCAFile)
+		if err != nil {
+			return nil, err
+		}
+		opts.AggregatorTLS = reloader.ClientConfig(*aggregatorSPIFFEID)
+	}
+
+	if opts.Node == "" {
+		if opts.Node, err = NodeName(); err != nil {
+			return nil, err
+		}
+	}
+
+	return opts, nil
+}
+
+type CPUInfo struct {
+	CPUId    int32
+	CoreId   int32
+	SocketId int32
+	NodeId   int32
+}
+
+// The times and periods are computed by the public cputime package.
+type (
+	CPUTime       = cputime.CPUTime
+	CPUTimePeriod = cputime.CPUTimePeriod
+)
+
+// AnomalousCores returns the IDs of the cores without exactly two CPUs,
+// sorted, e.g. those with an offline sibling or the efficiency cores of a
+// hybrid CPU.
+func AnomalousCores(coreToCpus map[int32][]int32) []int32 {
+	var coreIds []int32
+	for coreId, cpuIds := range coreToCpus {
+		if len(cpuIds) != 2 {
+			coreIds = append(coreIds, coreId)
+		}
+	}
+	sort.Slice(coreIds, func(i, j int) bool { return coreIds[i] < coreIds[j] })
+
+	return coreIds
+}
+
+// Detection is what the collector learned about the machine at startup.
+type Detection struct {
+	Model       string
+	Environment string
+	CPUInfos    []CPUInfo
+	CPUToCore   map[int32]int32
+	CoreToCPUs  map[int32][]int32
+	// AnomalousCores are the sorted IDs of the cores without two CPUs
+	AnomalousCores []int32
+}
+
+// Detect checks the machine is supported and reads its topology, from lscpu
+// or from the host's sysfs.
+func Detect(h *Host, useLsCPU bool, preflight Preflight) (*Detection, error) {
+	model, err := h.CPUModel()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to get CPU model: %v", ErrUnsupportedCPU, err)
+	}
+
+	if err := preflight.CheckCPUModel(model); err != nil {
+		return nil, err
+	}
+
+	if err := preflight.CheckSMT(h); err != nil {
+		return nil, err
+	}
+
+	var cpuInfos []CPUInfo
+	if useLsCPU {
+		cpuInfos, err = getCPUInfos()
+	} else {
+		cpuInfos, err = h.Topology()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get CPU infos: %w", err)
+	}
+
+	return NewDetection(model, h.Environment(), cpuInfos, preflight)
+}
+
+// NewDetection indexes the topology of a machine and warns about the cores
+// without two CPUs.
+func NewDetection(model, environment string, cpuInfos []CPUInfo, preflight Preflight) (*Detection, error) {
+	cpuToCore := make(map[int32]int32)
+	for _, info := range cpuInfos {
+		cpuToCore[info.CPUId] = info.CoreId
+	}
+
+	coreToCpus := make(map[int32][]int32)
+	for _, info := range cpuInfos {
+		coreToCpus[info.CoreId] = append(coreToCpus[info.CoreId], info.CPUId)
+	}
+
+	anomalous, err := preflight.CheckTopology(coreToCpus)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Detection{
+		Model:          model,
+		Environment:    environment,
+		CPUInfos:       cpuInfos,
+		CPUToCore:      cpuToCore,
+		CoreToCPUs:     coreToCpus,
+		AnomalousCores: anomalous,
+	}, nil
+}
+
+// SMT reports whether any core has two CPUs.
+func (d *Detection) SMT() bool {
+	return len(d.AnomalousCores) < len(d.CoreToCPUs)
+}
+
+// Restrict keeps only the given CPUs, which must cover whole cores since the
+// adjusted formula needs every sibling.
+func (d *Detection) Restrict(cpuIds []int32) error {
+	selected := make(map[int32]bool, len(cpuIds))
+	for _, cpuId := range cpuIds {
+		if _, ok := d.CPUToCore[cpuId]; !ok {
+			return fmt.Errorf("CPU %d is not online", cpuId)
+		}
+		selected[cpuId] = true
+	}
+
+	for coreId, cpus := range d.CoreToCPUs {
+		n := 0
+		for _, cpuId := range cpus {
+			if selected[cpuId] {
+				n++
+			}
+		}
+
+		if n == 0 {
+			delete(d.CoreToCPUs, coreId)
+		} else if n != len(cpus) {
+			return fmt.Errorf("%w: CPU subset splits core %d, select all of its CPUs %v", ErrUnsupportedTopology, coreId, cpus)
+		}
+	}
+
+	var cpuInfos []CPUInfo
+	for _, info := range d.CPUInfos {
+		if selected[info.CPUId] {
+			cpuInfos = append(cpuInfos, info)
+		} else {
+			delete(d.CPUToCore, info.CPUId)
+		}
+	}
+	d.CPUInfos = cpuInfos
+	d.AnomalousCores = AnomalousCores(d.CoreToCPUs)
+
+	return nil
+}
+
+func doLsCPU() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	executable, err := exec.LookPath("lscpu")
+	if err != nil {
+		return "", fmt.Errorf("failed to find lscpu: %v", err)
+	}
+
+	out, err := exec.CommandContext(ctx, executable, "-e=CPU,NODE,SOCKET,CORE").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to run lscpu: %v", err)
+	}
+
+	return string(out), nil
+}
+
+func getCPUInfos() ([]CPUInfo, error) {
+	lsCPUStr, err := doLsCPU()
+	if err != nil {
+		return nil, err
+	}
+
+	return lsCPUInfos(lsCPUStr)
+}
+
+// lsCPUInfos parses the output of lscpu -e=CPU,NODE,SOCKET,CORE.
+func lsCPUInfos(lsCPUStr string) ([]CPUInfo, error) {
+	entries, err := parse.LsCPU(lsCPUStr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedTopology, err)
+	}
+
+	cpuInfos := make([]CPUInfo, 0, len(entries))
+	for _, entry := range entries {
+		cpuInfos = append(cpuInfos, CPUInfo{
+			CPUId:    entry.CPU,
+			CoreId:   entry.Core,
+			SocketId: entry.Socket,
+			NodeId:   entry.Node,
+		})
+	}
+
+	if len(cpuInfos) == 0 {
+		return nil, fmt.Errorf("%w: no CPUs reported by lscpu", ErrUnsupportedTopology)
+	}
+
+	sortCPUInfos(cpuInfos)
+
+	return cpuInfos, nil
+}
+
+func sortCPUInfos(cpuInfos []CPUInfo) {
+	sort.Slice(cpuInfos, func(i, j int) bool {
+		a, b := cpuInfos[i], cpuInfos[j]
+		if a.NodeId != b.NodeId {
+			return a.NodeId < b.NodeId
+		}
+
+		if a.SocketId != b.SocketId {
+			return a.SocketId < b.SocketId
+		}
+
+		if a.CoreId != b.CoreId {
+			return a.CoreId < b.CoreId
+		}
+
+		return a.CPUId < b.CPUId
+	})
+}
+
+// ProcStatReader keeps /proc/stat open and re-reads it into a reused buffer,
+// saving the open and close syscalls on every tick.
+type ProcStatReader struct {
+	fsys fs.FS
+	path string
+	f    fs.File
+	buf  []byte
+
+	shards    int
+	chunks    [][]byte
+	shardBufs [][]CPUTime
+
+	// cpus selects the CPUs kept by ReadInto, indexed by CPU ID, nil keeps all
+	cpus []bool
+}
+
+func NewProcStatReader(h *Host) (*ProcStatReader, error) {
+	f, err := h.Proc.Open(ProcStatName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", ProcStatName, err)
+	}
+
+	return &ProcStatReader{
+		fsys:   h.Proc,
+		path:   ProcStatName,
+		f:      f,
+		buf:    make([]byte, 64*1024),
+		shards: 1,
+	}, nil
+}
+
+// SetShards parses the file on this many goroutines, see NumShards.
+func (r *ProcStatReader) SetShards(shards int) {
+	r.shards = max(1, shards)
+}
+
+// SetCPUs restricts ReadInto to the given CPUs.
+func (r *ProcStatReader) SetCPUs(cpuToCore map[int32]int32) {
+	var maxCPUId int32
+	for cpuId := range cpuToCore {
+		maxCPUId = max(maxCPUId, cpuId)
+	}
+
+	r.cpus = make([]bool, maxCPUId+1)
+	for cpuId := range cpuToCore {
+		r.cpus[cpuId] = true
+	}
+}
+
+func (r *ProcStatReader) Close() error {
+	return r.f.Close()
+}
+
+// readAll reads the whole file, procfs reports a size of zero so the buffer
+// is grown until the file fits
+func (r *ProcStatReader) readAll() ([]byte, error) {
+	f, err := reopen(r.fsys, r.path, r.f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rewind %s: %w", r.path, err)
+	}
+	r.f = f
+
+	n := 0
+	for {
+		if n == len(r.buf) {
+			r.buf = append(r.buf, make([]byte, len(r.buf))...)
+		}
+
+		m, err := r.f.Read(r.buf[n:])
+		n += m
+		if err == io.EOF || (err == nil && m == 0) {
+			return r.buf[:n], nil
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", r.path, err)
+		}
+	}
+}
+
+// ReadInto parses the per-CPU times into dst, reusing its capacity. The
+// returned slice must not be passed to ReadInto again while still in use.
+func (r *ProcStatReader) ReadInto(dst []CPUTime) ([]CPUTime, error) {
+	data, err := r.readAll()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if r.shards <= 1 {
+		dst, err = parseCPUTimes(dst[:0], data, now)
+	} else {
+		r.chunks = splitLines(r.chunks, data, r.shards)
+		dst, r.shardBufs, err = parseCPUTimesParallel(dst[:0], r.shardBufs, r.chunks, now)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", r.path, err)
+	}
+
+	if r.cpus != nil {
+		n := 0
+		for i := range dst {
+			if cpuId := dst[i].CPUId; int(cpuId) < len(r.cpus) && r.cpus[cpuId] {
+				dst[n] = dst[i]
+				n++
+			}
+		}
+		dst = dst[:n]
+	}
+
+	if len(dst) == 0 {
+		return nil, fmt.Errorf("%w: no per-CPU lines in %s", ErrStatParse, r.path)
+	}
+
+	return dst, nil
+}
+
+// parseCPUTimes scans /proc/stat in place without allocating, besides
+// growing dst on the first call
+func parseCPUTimes(dst []CPUTime, data []byte, now time.Time) ([]CPUTime, error) {
+	var fields [parse.StatCPUFields]uint64
+
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			data = nil
+		}
+
+		// Ignore total CPU time, the "cpu " line
+		if !parse.IsStatCPULine(line) {
+			continue
+		}
+
+		// A CPU silently missing would misalign the periods, fail instead
+		cpuId, err := parse.StatCPULine(line, &fields)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrStatParse, err)
+		}
+
+		// Duplicated or reordered lines would pair up the wrong CPUs
+		if len(dst) > 0 && cpuId <= dst[len(dst)-1].CPUId {
+			return nil, fmt.Errorf("%w: cpu%d out of order", ErrStatParse, cpuId)
+		}
+
+		dst = append(dst, cputime.FromProcStat(cpuId, now, fields))
+	}
+
+	return dst, nil
+}
+
+// NewCoreList flattens the core map into a slice ordered by core ID
+func NewCoreList(coreToCpus map[int32][]int32) [][]int32 {
+	coreIds := NewCoreIds(coreToCpus)
+
+	cores := make([][]int32, 0, len(coreIds))
+	for _, coreId := range coreIds {
+		cores = append(cores, coreToCpus[coreId])
+	}
+
+	return cores
+}
+
+// computePeriods fills periods, indexed by CPU ID, from two consecutive reads
+func computePeriods(periods []CPUTimePeriod, prev, cur []CPUTime, shards int) error {
+	if len(prev) != len(cur) {
+		return fmt.Errorf("%w: %d != %d CPUs", ErrCPUsChanged, len(prev), len(cur))
+	}
+
+	errs := make([]error, shards)
+	runShards(len(cur), shards, func(shard, lo, hi int) {
+		for i := lo; i < hi; i++ {
+			cpuId := cur[i].CPUId
+			if int(cpuId) >= len(periods) {
+				errs[shard] = fmt.Errorf("%w: unknown CPU %d", ErrCPUsChanged, cpuId)
+				return
+			}
+
+			if err := periods[cpuId].Set(&prev[i], &cur[i]); err != nil {
+				errs[shard] = err
+				return
+			}
+		}
+	})
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run samples the machine until ctx is done, and then flushes and closes the
+// sinks and the trace. Its error joins the one that stopped it, if any, with
+// those of flushing and those it recovered from, see ErrCollectionErrors. A
+// Sampler runs once.
+func (s *Sampler) Run(ctx context.Context) (err error) {
+	opts, host, collector := s.opts, s.host, s.backend
+	model, environment := s.detection.Model, s.detection.Environment
+	cpuInfos, cpuToCore, coreToCpus := s.detection.CPUInfos, s.detection.CPUToCore, s.detection.CoreToCPUs
+
+	// Reported last, with the errors of flushing counted too
+	errorLimiter := NewErrorLimiter(DefaultErrorLogInterval)
+	defer func() {
+		err = errors.Join(err, errorLimiter.Err())
+	}()
+
+	// The sinks are flushed last, in reverse, once their samples are in
+	var flushes []func() error
+	defer func() {
+		for i := len(flushes) - 1; i >= 0; i-- {
+			err = errors.Join(err, flushes[i]())
+		}
+	}()
+
+	// The goroutines of the sinks stop before they are flushed, also when
+	// an error stops the loop
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ticker := s.clock.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	var adaptive *AdaptiveInterval
+	if opts.Adaptive {
+		adaptive = NewAdaptiveInterval(opts.Interval, opts.FastInterval)
+	}
+
+	go errorLimiter.Run(ctx)
+
+	marks := NewMarks(DefaultMaxMarks)
+	marks.SetToken(opts.MarkToken)
+	if opts.Label != "" {
+		marks.Add(opts.Label, time.Now(), 0)
+	}
+
+	var cgroupCheck *CgroupCheck
+	if opts.CgroupCheck > 0 {
+		cgroupCheck = NewCgroupCheck(host, opts.CgroupCheck)
+	}
+
+	var exporter *MetricsExporter
+	var serveErr chan error
+	if opts.MetricsListen != "" {
+		exporter = NewMetricsExporter(NewMachineInfo(host, cpuInfos))
+		exporter.SetErrorLimiter(errorLimiter)
+		exporter.SetConstLabels(label("environment", environment))
+
+		serveErr = make(chan error, 1)
+		go func() {
+			// The marks authenticate on their own
+			security := opts.MetricsSecurity
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", security.Handler(exporter, nil))
+			mux.Handle(SamplesPath, security.Handler(http.HandlerFunc(exporter.ServeSamples), nil))
+			mux.Handle(apiv1.SamplesPath, security.Handler(http.HandlerFunc(exporter.ServeSampleList), nil))
+			mux.Handle(MarksPath, marks)
+			if err := security.ListenAndServe(ctx, opts.MetricsListen, opts.MetricsLimiter.Handler(mux)); err != nil {
+				serveErr <- err
+			}
+		}()
+	}
+
+	// The consumers of the samples subscribe to the feed, each at its own
+	// pace, rather than being called from the loop
+	feed := s.feed
+	feed.SetErrorLimiter(errorLimiter)
+
+	// Before the sinks are flushed, the consumers take the samples left
+	var consumers sync.WaitGroup
+	defer func() {
+		feed.Close()
+		consumers.Wait()
+	}()
+
+	consume := func(handle func(sample *Sample), opts ...SubscribeOption) error {
+		samples, err := feed.Subscribe(ctx, opts...)
+		if err != nil {
+			return err
+		}
+
+		consumers.Add(1)
+		go func() {
+			defer consumers.Done()
+			for sample := range samples {
+				handle(&sample)
+			}
+		}()
+		return nil
+	}
+
+	// A sink failing stops the loop, and gets no samples after
+	sinkErr := make(chan error, 1)
+	addSink := func(sink Sink, opts ...SubscribeOption) error {
+		flushes = append(flushes, sink.Close)
+
+		var failed bool
+		return consume(func(sample *Sample) {
+			if failed {
+				return
+			}
+			if err := sink.Write(ctx, *sample); err != nil {
+				failed = true
+				select {
+				case sinkErr <- err:
+				default:
+				}
+			}
+		}, opts...)
+	}
+
+	if exporter != nil {
+		// Only the latest sample is exported
+		if err := addSink(&metricsSink{exporter: exporter, perCore: opts.MetricsPerCore}, SubscriberBuffer(1)); err != nil {
+			return err
+		}
+	}
+
+	config := SinkConfig{Options: opts, Errors: errorLimiter}
+	for _, spec := range opts.Sinks {
+		sink, err := NewSink(ctx, spec, config)
+		if err != nil {
+			return err
+		}
+		if err := addSink(sink); err != nil {
+			return err
+		}
+	}
+
+	for _, sink := range s.sinks {
+		if err := addSink(sink); err != nil {
+			return err
+		}
+	}
+
+	var podResources *PodResourcesWatcher
+	if opts.PodResources != "" {
+		watcher, err := NewPodResourcesWatcher(opts.PodResources)
+		if err != nil {
+			return err
+		}
+		defer watcher.Close()
+
+		go watcher.Run(ctx, DefaultPodResourcesRefresh, errorLimiter)
+		podResources = watcher
+	}
+
+	var containerTracker *ContainerCPUTracker
+	if opts.CRIEndpoint != "" {
+		watcher, err := NewCRIWatcher(host, opts.CRIEndpoint)
+		if err != nil {
+			return err
+		}
+		defer watcher.Close()
+
+		go watcher.Run(ctx, DefaultCRIRefresh, errorLimiter)
+		containerTracker = NewContainerCPUTracker(watcher)
+	}
+
+	statReader, err := collector.NewCPUTimesReader()
+	if err != nil {
+		return fmt.Errorf("failed to open CPU times: %v", err)
+	}
+	defer statReader.Close()
+
+	// Index based from here on, maps are too slow on the largest machines
+	var shards int
+	var cores [][]int32
+	var coreIds []int32
+	var sockets, nodes []CoreGroup
+	var maxCPUId int32
+	var cpuTimePeriods []CPUTimePeriod
+	setTopology := func() {
+		if opts.CPUs != "" {
+			statReader.SetCPUs(cpuToCore)
+		}
+
+		shards = NumShards(len(cpuToCore))
+		statReader.SetShards(shards)
+
+		cores = NewCoreList(coreToCpus)
+		coreIds = NewCoreIds(coreToCpus)
+		sockets = NewCoreGroups(cpuInfos, coreToCpus, SocketOf)
+		nodes = NewCoreGroups(cpuInfos, coreToCpus, NodeOf)
+
+		maxCPUId = 0
+		for cpuId := range cpuToCore {
+			maxCPUId = max(maxCPUId, cpuId)
+		}
+		cpuTimePeriods = make([]CPUTimePeriod, maxCPUId+1)
+	}
+	setTopology()
+
+	resctrl := host.ResctrlAvailable()
+	var llcOccupancy []LLCOccupancy
+
+	freqReader, err := NewFrequencyReader(host, cpuInfos, model)
+	if err != nil {
+		log.Printf("Capacity derating is not available: %v\n", err)
+	}
+	var irqCPUs []int32
+	var schedulableCores [][]int32
+
+	var anomalies *AnomalyDetector
+	if opts.AnomalyZ > 0 {
+		anomalies = NewAnomalyDetector(opts.AnomalyZ)
+	}
+
+	var window *RCPUWindow
+	if opts.Window > 0 {
+		window = NewRCPUWindow(opts.Window)
+	}
+
+	var siblingModel SiblingModel
+	var ipcModel *IPCSiblingModel
+	var sampler *PerfSampler
+	defer func() {
+		if sampler != nil {
+			sampler.Close()
+		}
+	}()
+	// The perf events are opened on the CPUs of the topology
+	setIPCModel := func() error {
+		var cpuIds []int32
+		for _, core := range cores {
+			cpuIds = append(cpuIds, core...)
+		}
+
+		if sampler != nil {
+			sampler.Close()
+		}
+
+		if sampler, err = NewPerfSampler(cpuIds); err != nil {
+			return fmt.Errorf("failed to open perf events: %v", err)
+		}
+
+		ipcModel = NewIPCSiblingModel(sampler, maxCPUId)
+		siblingModel = ipcModel
+		return nil
+	}
+	if opts.SiblingModel != SiblingModelIPC {
+		if siblingModel, err = NewSiblingModel(opts.SiblingModel, opts.SMTYield); err != nil {
+			return err
+		}
+	} else if err := setIPCModel(); err != nil {
+		return err
+	}
+
+	// SMT switched at runtime changes the online CPUs, the topology is read
+	// again and the windows start over since the usages before aren't
+	// comparable. Cores left without a sibling get the plain formula.
+	smtWatcher := NewSMTWatcher(host)
+	detection := s.detection
+	var restrictedCPUs []int32
+	if opts.CPUs != "" {
+		if restrictedCPUs, err = parse.CPUList(opts.CPUs); err != nil {
+			return err
+		}
+	}
+	redetect := func() error {
+		changed, err := Redetect(collector, restrictedCPUs)
+		if err != nil {
+			return fmt.Errorf("failed to detect the changed topology: %v", err)
+		}
+
+		detection = changed
+		cpuInfos, cpuToCore, coreToCpus = detection.CPUInfos, detection.CPUToCore, detection.CoreToCPUs
+		setTopology()
+		log.Printf("Topology changed, %d CPUs on %d cores, SMT enabled: %v\n", len(cpuToCore), len(coreToCpus), detection.SMT())
+
+		if ipcModel != nil {
+			if err := setIPCModel(); err != nil {
+				return err
+			}
+		}
+
+		if freqReader != nil {
+			if freqReader, err = NewFrequencyReader(host, cpuInfos, model); err != nil {
+				log.Printf("Capacity derating is not available: %v\n", err)
+			}
+		}
+
+		if exporter != nil {
+			exporter.SetMachine(NewMachineInfo(host, cpuInfos))
+		}
+
+		if window != nil {
+			window = NewRCPUWindow(opts.Window)
+		}
+
+		if anomalies != nil {
+			anomalies = NewAnomalyDetector(opts.AnomalyZ)
+		}
+
+		irqCPUs, schedulableCores = nil, nil
+		return nil
+	}
+
+	// Double buffered, so the previous times stay intact while parsing
+	var prevCPUTimes, spareCPUTimes []CPUTime
+	for {
+		// The deferred closes flush the rollups and release the watchers
+		select {
+		case <-ctx.Done():
+			log.Printf("Collector is stopping\n")
+			return nil
+		case err := <-serveErr:
+			return fmt.Errorf("failed to serve metrics: %v", err)
+		case err := <-sinkErr:
+			return err
+		case <-ticker.Ticks():
+		}
+
+		if smtWatcher != nil {
+			if _, changed := smtWatcher.Changed(); changed {
+				if err := redetect(); err != nil {
+					return err
+				}
+
+				// Measure from the next read on
+				prevCPUTimes, spareCPUTimes = nil, nil
+			}
+		}
+
+		cpuTimes, err := statReader.ReadInto(spareCPUTimes)
+		if errors.Is(err, ErrStatParse) {
+			// Keep the previous times and try again on the next tick
+			errorLimiter.Log(ErrorClassStatParse, "skipping sample: %v", err)
+			continue
+		} else if errors.Is(err, ErrRemoteDisconnected) {
+			errorLimiter.Log(ErrorClassRemote, "skipping sample: %v", err)
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to get CPU times: %v", err)
+		}
+
+		if len(prevCPUTimes) == 0 {
+			prevCPUTimes = cpuTimes
+			continue
+		}
+
+		if err := computePeriods(cpuTimePeriods, prevCPUTimes, cpuTimes, shards); errors.Is(err, ErrCPUsChanged) {
+			// A CPU went offline or came online, possibly SMT before the
+			// watcher noticed
+			errorLimiter.Warn(WarningClassTopology, "skipping sample: %v", err)
+			if err := redetect(); err != nil {
+				return err
+			}
+
+			prevCPUTimes, spareCPUTimes = nil, nil
+			continue
+		} else if errors.Is(err, ErrCounterReset) {
+			// Skip the tick and measure from the new counters on
+			errorLimiter.Log(ErrorClassCounterReset, "skipping sample: %v", err)
+			prevCPUTimes, spareCPUTimes = cpuTimes, prevCPUTimes
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to create CPU time period: %v", err)
+		}
+
+		if ipcModel != nil {
+			if err := ipcModel.Update(cores, cpuTimePeriods); err != nil {
+				errorLimiter.Log(ErrorClassPerf, "%v", err)
+			}
+		}
+
+		irqCPUs = FindIRQCPUs(irqCPUs, cpuTimes, cpuTimePeriods, opts.IRQRatio)
+
+		// Unless every core has an IRQ-heavy CPU
+		usedCores := cores
+		if opts.ExcludeIRQCPUs && len(irqCPUs) > 0 {
+			schedulableCores = ExcludeCores(schedulableCores, cores, irqCPUs)
+			if len(schedulableCores) > 0 {
+				usedCores = schedulableCores
+			}
+		}
+
+		var avgCPUUsage float64
+		if len(usedCores) == len(cores) {
+			avgCPUUsage, err = cputime.AverageCPUUsage(cpuTimePeriods)
+		} else {
+			avgCPUUsage, err = cputime.CoresAverageCPUUsage(usedCores, cpuTimePeriods)
+		}
+		if errors.Is(err, ErrZeroPeriod) {
+			// Nothing was counted since the previous tick, keep its times
+			// and measure the next tick over the longer period
+			errorLimiter.Log(ErrorClassZeroPeriod, "skipping sample: %v", err)
+			spareCPUTimes = cpuTimes
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to calculate average CPU usage: %v", err)
+		}
+		adjustedCPUUsage, err := siblingModel.AdjustedCPUUsage(usedCores, cpuTimePeriods)
+		if err != nil {
+			return fmt.Errorf("failed to calculate adjusted CPU usage: %v", err)
+		}
+
+		socketUsages, err := DoGroupAdjustedCPUUsage(siblingModel, sockets, cpuTimePeriods)
+		if err != nil {
+			return fmt.Errorf("failed to calculate socket CPU usage: %v", err)
+		}
+		nodeUsages, err := DoGroupAdjustedCPUUsage(siblingModel, nodes, cpuTimePeriods)
+		if err != nil {
+			return fmt.Errorf("failed to calculate node CPU usage: %v", err)
+		}
+
+		if adaptive != nil {
+			prevInterval := adaptive.Current()
+			if interval := adaptive.Next(adjustedCPUUsage); interval != prevInterval {
+				ticker.Reset(interval)
+			}
+		}
+
+		adjustedRemainingCPUUsage := 100.0 - adjustedCPUUsage
+
+		label := marks.Label(cpuTimes[0].CollectTime)
+
+		var anomaly AnomalyState
+		if anomalies != nil {
+			var started bool
+			if anomaly, started = anomalies.Observe(cpuTimes[0].CollectTime, adjustedCPUUsage); started {
+				log.Printf("Anomaly: adjusted CPU usage of %.2f%% is %.1f standard deviations from the recent %.2f%%\n", adjustedCPUUsage, anomaly.Z, anomaly.Mean)
+			}
+
+			if exporter != nil {
+				exporter.UpdateAnomaly(anomaly, label)
+			}
+		}
+
+		var windowStats WindowStats
+		if window != nil {
+			window.Add(adjustedRemainingCPUUsage)
+			windowStats = window.Stats()
+		}
+
+		periodTotals := SumPeriods(cpuTimePeriods)
+
+		// The hypervisor running other guests shows up as steal time, which
+		// counts as busy and lowers RCPU without anything running here
+		var steal float64
+		if IsVirtualized(environment) {
+			if steal = periodTotals.percent(periodTotals.Steal); steal > DefaultStealWarning {
+				errorLimiter.Warn(WarningClassSteal, "%.2f%% of the CPU time was stolen by the hypervisor", steal)
+			}
+		}
+
+		// Informational only, a missing loadavg leaves it at zero
+		load, err := collector.LoadAvg()
+		if err != nil {
+			errorLimiter.Log(ErrorClassLoadAvg, "failed to read load average: %v", err)
+		}
+
+		if cgroupCheck != nil {
+			divergence, ok, err := cgroupCheck.Check(cpuTimes[0].CollectTime, cpuTimes)
+			if err != nil {
+				errorLimiter.Log(ErrorClassCgroupRead, "failed to read cgroup CPU usage: %v", err)
+			} else if ok {
+				if math.Abs(divergence) > DefaultCgroupMaxDivergence {
+					errorLimiter.Log(ErrorClassCgroupDivergence, "busy time of %s and the root cgroup diverge by %.2f%%", ProcStatName, divergence)
+				}
+
+				if exporter != nil {
+					exporter.UpdateCgroupDivergence(divergence)
+				}
+			}
+		}
+
+		if resctrl {
+			if llcOccupancy, err = host.LLCOccupancy(); err != nil {
+				errorLimiter.Log(ErrorClassResctrl, "failed to read LLC occupancy: %v", err)
+			}
+		}
+
+		// Nominal unless the busy CPUs are known to run below base clock
+		derating := 1.0
+		var freq Frequency
+		if freqReader != nil {
+			if freq, err = freqReader.Read(cpuTimePeriods); err != nil {
+				errorLimiter.Log(ErrorClassFrequency, "%v", err)
+			} else if derating = freq.Derating(); derating < DefaultDeratingWarning {
+				errorLimiter.Warn(WarningClassDerating, "CPUs run at %.0f MHz busy and are capped at %.0f MHz, below the base clock of %.0f MHz, the remaining CPU is worth %.0f%% of nominal",
+					freq.BusyKHz/1000, float64(freq.CapKHz)/1000, float64(freq.BaseKHz)/1000, 100*derating)
+			}
+		}
+
+		var cpuBusy *CPUBusy
+		if podResources != nil || containerTracker != nil {
+			cpuBusy = NewCPUBusy(cores, cpuTimePeriods)
+		}
+
+		var pods []PodAttribution
+		if podResources != nil {
+			pods = AttributePods(podResources.Pods(), cpuBusy)
+		}
+
+		var containers []ContainerCPU
+		if containerTracker != nil {
+			if containers, err = containerTracker.Sample(cpuTimes[0].CollectTime, cpuBusy); err != nil {
+				errorLimiter.Log(ErrorClassCgroupRead, "%v", err)
+			}
+		}
+
+		if feed.Subscribers() > 0 || len(s.observers) > 0 {
+			// Leave the derating out of the metrics when it is unknown
+			sampleDerating := derating
+			if freqReader == nil {
+				sampleDerating = 0
+			}
+
+			var sampleWindow *WindowStats
+			if window != nil {
+				sampleWindow = &windowStats
+			}
+
+			var interference *float64
+			if ipcModel != nil {
+				if v := ipcModel.Interference(usedCores); v >= 0 {
+					interference = &v
+				}
+			}
+
+			freeCores := FreeCores(usedCores, cpuTimePeriods, DefaultFreeCoreBusy)
+			perCore := DoPerCoreUsage(nil, coreIds, cores, cpuTimePeriods)
+			sample := &Sample{
+				Node:             opts.Node,
+				Pool:             opts.Pool,
+				Time:             cpuTimes[0].CollectTime,
+				Interval:         cpuTimePeriods[cpuTimes[0].CPUId].Elapsed,
+				CPUs:             len(cpuToCore),
+				Cores:            len(usedCores),
+				FreeCores:        &freeCores,
+				AvgCPUUsage:      avgCPUUsage,
+				AdjustedCPUUsage: adjustedCPUUsage,
+				Load1:            load[0],
+				Load5:            load[1],
+				Load15:           load[2],
+				Label:            label,
+				Sockets:          socketUsages,
+				Nodes:            nodeUsages,
+				IRQCPUs:          append([]int32(nil), irqCPUs...),
+				Steal:            steal,
+				Derating:         sampleDerating,
+				BusyMHz:          freq.BusyKHz / 1000,
+				LLCOccupancy:     llcOccupancy,
+				SMTInterference:  interference,
+				Window:           sampleWindow,
+				Pods:             pods,
+				Containers:       containers,
+				PerCore:          perCore,
+				Periods:          periodTotals,
+				AnomalyZ:         anomaly.Z,
+				// The buffers are reused by the next tick
+				CPUTimes:       append([]CPUTime(nil), cpuTimes...),
+				CPUTimePeriods: append([]CPUTimePeriod(nil), cpuTimePeriods...),
+				Detection:      detection,
+			}
+
+			for _, observe := range s.observers {
+				observe(*sample)
+			}
+			feed.Publish(sample)
+		}
+
+		prevCPUTimes, spareCPUTimes = cpuTimes, prevCPUTimes
+	}
+}
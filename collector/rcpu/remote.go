@@ -0,0 +1,346 @@
+package rcpu
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RemoteCommand     = "remote"
+	DefaultSSHCommand = "ssh"
+
+	DefaultRemoteSnapshotTimeout = 30 * time.Second
+
+	// remoteMarker starts the lines separating the files the remote shell
+	// prints, followed by the file's path or end
+	remoteMarker = "@@rcpu@@"
+	remoteEnd    = remoteMarker + " end"
+)
+
+// remoteSnapshotFiles are read once, the topology comes from lscpu. They are
+// named relative to the root in the output.
+var remoteSnapshotFiles = []string{
+	"proc/" + ProcCPUInfoName,
+	"proc/" + ProcMemInfoName,
+	"sys/" + SysCPUSMTActivePath,
+	"sys/" + SysDMIDir + "/sys_vendor",
+	"sys/" + SysDMIDir + "/product_name",
+	"sys/" + SysDMIDir + "/bios_vendor",
+	"sys/" + SysDMIDir + "/chassis_asset_tag",
+	"sys/" + SysHypervisorTypePath,
+}
+
+// remoteCat prints a file if it exists, after the marker naming it
+func remoteCat(name string) string {
+	return fmt.Sprintf("if [ -r /%[2]s ]; then echo \"%[1]s %[2]s\"; cat /%[2]s; fi; ", remoteMarker, name)
+}
+
+func remoteSnapshotScript() string {
+	var b strings.Builder
+	for _, name := range remoteSnapshotFiles {
+		b.WriteString(remoteCat(name))
+	}
+	fmt.Fprintf(&b, "echo \"%s lscpu\"; LC_ALL=C lscpu -e=CPU,NODE,SOCKET,CORE; echo \"%s\"", remoteMarker, remoteEnd)
+
+	return b.String()
+}
+
+// remoteStatScript prints /proc/stat and /proc/loadavg for every line read,
+// so a single session serves every tick
+var remoteStatScript = "while read -r _; do " + remoteCat("proc/"+ProcStatName) + remoteCat("proc/"+ProcLoadAvgName) + "echo \"" + remoteEnd + "\"; done"
+
+// readRemoteFiles splits what the remote shell printed into files by path,
+// up to the end marker.
+func readRemoteFiles(r *bufio.Reader) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	var current string
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if text := strings.TrimRight(string(line), "\n"); strings.HasPrefix(text, remoteMarker) {
+			if text == remoteEnd {
+				return files, nil
+			}
+
+			current = strings.TrimPrefix(text, remoteMarker+" ")
+			files[current] = nil
+			continue
+		}
+
+		if current == "" {
+			return nil, fmt.Errorf("output before the first file: %q", line)
+		}
+		files[current] = append(files[current], line...)
+	}
+}
+
+// fileMap is a read-only fs.FS of the files fetched by their names, without
+// directories.
+type fileMap map[string][]byte
+
+func (m fileMap) Open(name string) (fs.File, error) {
+	data, ok := m[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return &mapFile{name: name, size: int64(len(data)), r: bytes.NewReader(data)}, nil
+}
+
+// mapFile is a file of a fileMap and its own fs.FileInfo. It can't seek, so
+// ProcStatReader opens /proc/stat again on every tick instead of rewinding
+// the previous copy.
+type mapFile struct {
+	name string
+	size int64
+	r    io.Reader
+}
+
+func (f *mapFile) Read(b []byte) (int, error) {
+	return f.r.Read(b)
+}
+
+func (f *mapFile) Close() error {
+	return nil
+}
+
+func (f *mapFile) Stat() (fs.FileInfo, error) {
+	return f, nil
+}
+
+func (f *mapFile) Name() string {
+	return path.Base(f.name)
+}
+
+func (f *mapFile) Size() int64 {
+	return f.size
+}
+
+func (f *mapFile) Mode() fs.FileMode {
+	return 0444
+}
+
+func (f *mapFile) ModTime() time.Time {
+	return time.Time{}
+}
+
+func (f *mapFile) IsDir() bool {
+	return false
+}
+
+func (f *mapFile) Sys() any {
+	return nil
+}
+
+// remoteProc serves the snapshot, and /proc/stat fetched over the session on
+// every open along with /proc/loadavg.
+type remoteProc struct {
+	c *RemoteCollector
+
+	mu       sync.Mutex
+	snapshot fileMap
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	stdout   *bufio.Reader
+	loadavg  []byte
+}
+
+func (p *remoteProc) Open(name string) (fs.File, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch name {
+	case ProcStatName:
+		stat, err := p.fetch()
+		if err != nil {
+			return nil, err
+		}
+
+		return fileMap{name: stat}.Open(name)
+	case ProcLoadAvgName:
+		if p.loadavg == nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+
+		return fileMap{name: p.loadavg}.Open(name)
+	default:
+		return p.snapshot.Open(name)
+	}
+}
+
+func (p *remoteProc) connect() error {
+	cmd := p.c.command(context.Background(), remoteStatScript)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	p.cmd, p.stdin, p.stdout = cmd, stdin, bufio.NewReaderSize(stdout, 64*1024)
+
+	return nil
+}
+
+func (p *remoteProc) disconnect() {
+	if p.cmd == nil {
+		return
+	}
+
+	p.stdin.Close()
+	p.cmd.Process.Kill()
+	p.cmd.Wait()
+	p.cmd = nil
+}
+
+// fetch asks the session for the next copy of the files, reconnecting after a
+// failure on the next call.
+func (p *remoteProc) fetch() ([]byte, error) {
+	if p.cmd == nil {
+		if err := p.connect(); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrRemoteDisconnected, p.c.destination, err)
+		}
+	}
+
+	files, err := func() (map[string][]byte, error) {
+		if _, err := io.WriteString(p.stdin, "\n"); err != nil {
+			return nil, err
+		}
+
+		return readRemoteFiles(p.stdout)
+	}()
+	if err != nil {
+		p.disconnect()
+		return nil, fmt.Errorf("%w: %s: %v", ErrRemoteDisconnected, p.c.destination, err)
+	}
+
+	p.loadavg = files["proc/"+ProcLoadAvgName]
+
+	return files["proc/"+ProcStatName], nil
+}
+
+// RemoteCollector reads a Linux machine over SSH, without an agent on it.
+// The topology and the machine's description are read once, /proc/stat on
+// every tick over a single session that is reconnected when it drops. The
+// SSH client does the authentication, with the user's keys, agent and config.
+type RemoteCollector struct {
+	ssh         []string
+	destination string
+
+	proc *remoteProc
+	host *Host
+}
+
+// NewRemoteCollector reads the machine at destination, e.g. user@node, with
+// the SSH client command and its options.
+func NewRemoteCollector(ssh []string, destination string) *RemoteCollector {
+	c := &RemoteCollector{ssh: ssh, destination: destination}
+	c.proc = &remoteProc{c: c, snapshot: fileMap{}}
+	c.host = &Host{Proc: c.proc, Sys: fileMap{}}
+
+	return c
+}
+
+func (c *RemoteCollector) command(ctx context.Context, script string) *exec.Cmd {
+	// A partitioned network fails the reads instead of hanging the loop
+	args := append([]string{}, c.ssh[1:]...)
+	args = append(args, "-o", "BatchMode=yes", "-o", "ServerAliveInterval=5", "-o", "ServerAliveCountMax=3", c.destination, script)
+	cmd := exec.CommandContext(ctx, c.ssh[0], args...)
+	cmd.Stderr = os.Stderr
+
+	return cmd
+}
+
+// Host is the remote machine as seen by the optional features, only its
+// snapshot files and /proc/stat are there.
+func (c *RemoteCollector) Host() *Host {
+	return c.host
+}
+
+func (c *RemoteCollector) Detect(preflight Preflight) (*Detection, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRemoteSnapshotTimeout)
+	defer cancel()
+
+	cmd := c.command(ctx, remoteSnapshotScript())
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("%w: %s: timed out reading the topology", ErrRemoteDisconnected, c.destination)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrRemoteDisconnected, c.destination, err)
+	}
+
+	files, err := readRemoteFiles(bufio.NewReader(bytes.NewReader(out)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the topology of %s: %v", c.destination, err)
+	}
+
+	sys := fileMap{}
+	for path, data := range files {
+		if name, ok := strings.CutPrefix(path, "proc/"); ok {
+			c.proc.snapshot[name] = data
+		} else if name, ok := strings.CutPrefix(path, "sys/"); ok {
+			sys[name] = data
+		}
+	}
+	c.host.Sys = sys
+
+	model, err := c.host.CPUModel()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to get CPU model: %v", ErrUnsupportedCPU, err)
+	}
+
+	if err := preflight.CheckCPUModel(model); err != nil {
+		return nil, err
+	}
+
+	if err := preflight.CheckSMT(c.host); err != nil {
+		return nil, err
+	}
+
+	cpuInfos, err := lsCPUInfos(string(files["lscpu"]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get CPU infos: %w", err)
+	}
+
+	return NewDetection(model, c.host.Environment(), cpuInfos, preflight)
+}
+
+func (c *RemoteCollector) NewCPUTimesReader() (CPUTimesReader, error) {
+	return NewProcStatReader(c.host)
+}
+
+func (c *RemoteCollector) LoadAvg() ([3]float64, error) {
+	return c.host.LoadAvg()
+}
+
+// Close ends the session.
+func (c *RemoteCollector) Close() {
+	c.proc.mu.Lock()
+	defer c.proc.mu.Unlock()
+
+	c.proc.disconnect()
+}
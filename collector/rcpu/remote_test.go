@@ -0,0 +1,111 @@
+package rcpu
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"solelab.tech/collector/internal/testutil"
+)
+
+// fakeSSH runs the remote scripts locally against a machine written to disk,
+// with lscpu answering from its topology.
+func fakeSSH(t *testing.T, m *testutil.Machine) (string, string, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	procRoot, sysRoot, bin := filepath.Join(dir, "proc"), filepath.Join(dir, "sys"), filepath.Join(dir, "bin")
+	if err := m.WriteDir(procRoot, sysRoot); err != nil {
+		t.Fatal(err)
+	}
+
+	var lscpu strings.Builder
+	lscpu.WriteString("CPU NODE SOCKET CORE\n")
+	for cpu := 0; cpu < m.NumCPUs(); cpu++ {
+		fmt.Fprintf(&lscpu, "%d 0 0 %d\n", cpu, m.Siblings(cpu)[0])
+	}
+
+	scripts := map[string]string{
+		"ssh":   fmt.Sprintf("#!/bin/sh\nfor script; do :; done\nscript=$(printf '%%s' \"$script\" | sed -e 's|/proc/|%s/|g' -e 's|/sys/|%s/|g')\nPATH=%s:$PATH exec sh -c \"$script\"\n", procRoot, sysRoot, bin),
+		"lscpu": "#!/bin/sh\ncat <<EOF\n" + lscpu.String() + "EOF\n",
+	}
+	if err := os.Mkdir(bin, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return filepath.Join(bin, "ssh"), procRoot, sysRoot
+}
+
+func TestRemoteCollector(t *testing.T) {
+	m := testutil.NewMachine(testutil.DualSocket(2))
+	ssh, procRoot, sysRoot := fakeSSH(t, m)
+
+	c := NewRemoteCollector([]string{ssh, "-p", "2222"}, "user@node-1")
+	defer c.Close()
+
+	detection, err := c.Detect(DefaultPreflight())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(detection.CPUInfos) != m.NumCPUs() || len(detection.CoreToCPUs) != m.NumCPUs()/2 {
+		t.Fatalf("expected %d CPUs on %d cores, got %v", m.NumCPUs(), m.NumCPUs()/2, detection.CoreToCPUs)
+	}
+
+	r, err := c.NewCPUTimesReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	first, err := r.ReadInto(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every read goes over the session, so it sees the machine move on
+	m.Step(time.Second, func(cpu int) float64 { return 1 })
+	if err := m.WriteDir(procRoot, sysRoot); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := r.ReadInto(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(second) != m.NumCPUs() || second[0].User <= first[0].User {
+		t.Errorf("expected the counters to advance, got %+v then %+v", first[0], second[0])
+	}
+
+	if load, err := c.LoadAvg(); err != nil || load[0] != 0.52 {
+		t.Errorf("expected the remote load average, got %v, %v", load, err)
+	}
+}
+
+func TestRemoteCollectorDisconnected(t *testing.T) {
+	c := NewRemoteCollector([]string{"false"}, "user@node-1")
+	defer c.Close()
+
+	if _, err := c.NewCPUTimesReader(); !errors.Is(err, ErrRemoteDisconnected) {
+		t.Errorf("expected the session to fail, got %v", err)
+	}
+}
+
+func TestReadRemoteFilesTruncated(t *testing.T) {
+	out := remoteMarker + " /proc/stat\ncpu0 1 2 3 4\n"
+	if _, err := readRemoteFiles(bufio.NewReader(strings.NewReader(out))); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected a truncated output to fail, got %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package rcpu
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"solelab.tech/collector/internal/parse"
+)
+
+const (
+	SysResctrlDir = "fs/resctrl"
+
+	resctrlL3MonDir   = "info/L3_MON"
+	resctrlMonData    = "mon_data"
+	resctrlMonGroups  = "mon_groups"
+	resctrlOccupancy  = "llc_occupancy"
+	resctrlRootGroup  = "/"
+	resctrlDomainPref = "mon_L3_"
+)
+
+// LLCOccupancy is the last level cache a resctrl monitoring group occupies
+// in one cache domain, usually a socket.
+type LLCOccupancy struct {
+	// Group is the group's directory in resctrl, / for the root group
+	Group  string `json:"group"`
+	Domain string `json:"domain"`
+	Bytes  uint64 `json:"bytes"`
+}
+
+// ResctrlAvailable reports whether resctrl is mounted with L3 monitoring,
+// which needs Intel RDT or AMD PQoS.
+func (h *Host) ResctrlAvailable() bool {
+	_, err := fs.Stat(h.Sys, path.Join(SysResctrlDir, resctrlL3MonDir))
+	return err == nil
+}
+
+// resctrlGroups lists the monitoring groups by their directory relative to
+// the resctrl root, the root group and every control group followed by their
+// mon_groups
+func (h *Host) resctrlGroups() ([]string, error) {
+	entries, err := fs.ReadDir(h.Sys, SysResctrlDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %v", SysResctrlDir, err)
+	}
+
+	ctrlGroups := []string{"."}
+	for _, entry := range entries {
+		switch name := entry.Name(); {
+		case !entry.IsDir(), name == "info", name == resctrlMonData, name == resctrlMonGroups:
+		default:
+			ctrlGroups = append(ctrlGroups, name)
+		}
+	}
+
+	var groups []string
+	for _, ctrlGroup := range ctrlGroups {
+		groups = append(groups, ctrlGroup)
+
+		monEntries, err := fs.ReadDir(h.Sys, path.Join(SysResctrlDir, ctrlGroup, resctrlMonGroups))
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range monEntries {
+			if entry.IsDir() {
+				groups = append(groups, path.Join(ctrlGroup, resctrlMonGroups, entry.Name()))
+			}
+		}
+	}
+
+	return groups, nil
+}
+
+// LLCOccupancy reads the LLC occupancy of every monitoring group in every
+// cache domain. Domains the kernel reports as unavailable are left out.
+func (h *Host) LLCOccupancy() ([]LLCOccupancy, error) {
+	groups, err := h.resctrlGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	var occupancies []LLCOccupancy
+	for _, group := range groups {
+		dir := path.Join(SysResctrlDir, group, resctrlMonData)
+		domains, err := fs.ReadDir(h.Sys, dir)
+		if err != nil {
+			// The group may have been removed since it was listed
+			continue
+		}
+
+		name := group
+		if group == "." {
+			name = resctrlRootGroup
+		}
+
+		for _, domain := range domains {
+			domainId, ok := strings.CutPrefix(domain.Name(), resctrlDomainPref)
+			if !ok {
+				continue
+			}
+
+			out, err := fs.ReadFile(h.Sys, path.Join(dir, domain.Name(), resctrlOccupancy))
+			if err != nil {
+				continue
+			}
+
+			bytes, err := parse.SysUint(out)
+			if err != nil {
+				// "Unavailable" while the RMID isn't tracked, "Error" on failure
+				continue
+			}
+
+			occupancies = append(occupancies, LLCOccupancy{Group: name, Domain: domainId, Bytes: bytes})
+		}
+	}
+
+	return occupancies, nil
+}
+
+// TotalLLCOccupancy sums the occupancy of every control group and domain. A
+// control group already counts its monitoring groups.
+func TotalLLCOccupancy(occupancies []LLCOccupancy) uint64 {
+	var total uint64
+	for _, occupancy := range occupancies {
+		if !strings.Contains(occupancy.Group, resctrlMonGroups+"/") {
+			total += occupancy.Bytes
+		}
+	}
+
+	return total
+}
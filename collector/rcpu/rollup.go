@@ -0,0 +1,229 @@
+package rcpu
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+const DefaultRollups = "1m,10m"
+
+// RecordRollup downsamples the records into buckets of Period aligned on the
+// wall clock, writing the mean, minimum and maximum of every field once a
+// bucket is complete, so traces of days stay small enough to load. Unlike
+// Rollup it summarizes one node over time rather than a pool at one time.
+type RecordRollup struct {
+	Period time.Duration
+
+	fields  []Field
+	writer  RecordWriter
+	file    *os.File
+	start   time.Time
+	samples int
+	sum     []float64
+	min     []float64
+	max     []float64
+	text    []string
+}
+
+// ParseRollups parses a comma separated list of rollup periods.
+func ParseRollups(s string) ([]time.Duration, error) {
+	var periods []time.Duration
+	for _, part := range strings.Split(s, ",") {
+		period, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("invalid rollup period %q: %v", part, err)
+		}
+
+		if period < time.Second {
+			return nil, fmt.Errorf("invalid rollup period %v, must be at least a second", period)
+		}
+
+		periods = append(periods, period)
+	}
+
+	return periods, nil
+}
+
+// rollupPath names the file of a period after the prefix, e.g. rcpu-1m.csv
+func rollupPath(prefix string, period time.Duration, output string) string {
+	name := period.String()
+	if strings.HasSuffix(name, "m0s") {
+		name = strings.TrimSuffix(name, "0s")
+	}
+	if strings.HasSuffix(name, "h0m") {
+		name = strings.TrimSuffix(name, "0m")
+	}
+
+	ext := "csv"
+	if output == OutputJSON {
+		ext = "jsonl"
+	}
+
+	return fmt.Sprintf("%s-%s.%s", prefix, name, ext)
+}
+
+// NewRecordRollup appends the rollup of the fields to its file next to
+// prefix, as JSON lines with -output json and as CSV otherwise.
+func NewRecordRollup(prefix string, period time.Duration, fields []Field, output string, timeFormat TimeFormat) (*RecordRollup, error) {
+	path := rollupPath(prefix, period, output)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open rollup file: %v", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat %s: %v", path, err)
+	}
+
+	r := &RecordRollup{
+		Period: period,
+		fields: fields,
+		file:   file,
+		sum:    make([]float64, len(fields)),
+		min:    make([]float64, len(fields)),
+		max:    make([]float64, len(fields)),
+		text:   make([]string, len(fields)),
+	}
+
+	columns := r.columns()
+	if output == OutputJSON {
+		r.writer = NewJSONRecordWriter(file, columns, timeFormat)
+	} else {
+		// Appending to an earlier run's file, which has the header already
+		r.writer = &csvRecordWriter{w: csv.NewWriter(file), fields: columns, timeFormat: timeFormat, started: info.Size() > 0}
+	}
+
+	return r, nil
+}
+
+// columns are the fields of the rolled up records, the bucket's start time,
+// the number of samples and the mean, min and max of every value field
+func (r *RecordRollup) columns() []Field {
+	columns := []Field{
+		{Name: "time", Header: "Time"},
+		{"samples", "Samples", "%.0f", func(*Record) float64 { return float64(r.samples) }, nil},
+	}
+
+	for i, field := range r.fields {
+		i := i
+		switch {
+		case field.Text != nil:
+			// The latest, e.g. the label the bucket ended with
+			columns = append(columns, Field{Name: field.Name, Header: field.Header, Text: func(*Record) string { return r.text[i] }})
+		case field.Value != nil:
+			columns = append(columns,
+				Field{field.Name, field.Header, field.Format, func(*Record) float64 { return r.sum[i] / float64(r.samples) }, nil},
+				Field{field.Name + "-min", field.Header + " Min", field.Format, func(*Record) float64 { return r.min[i] }, nil},
+				Field{field.Name + "-max", field.Header + " Max", field.Format, func(*Record) float64 { return r.max[i] }, nil},
+			)
+		}
+	}
+
+	return columns
+}
+
+// Add adds a record to the current bucket, writing the previous bucket first
+// once the record falls past it.
+func (r *RecordRollup) Add(record *Record) error {
+	start := record.Time.Truncate(r.Period)
+	if r.samples > 0 && !start.Equal(r.start) {
+		if err := r.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if r.samples == 0 {
+		r.start = start
+		for i := range r.fields {
+			r.sum[i], r.min[i], r.max[i] = 0, math.Inf(1), math.Inf(-1)
+		}
+	}
+
+	for i, field := range r.fields {
+		switch {
+		case field.Text != nil:
+			r.text[i] = field.Text(record)
+		case field.Value != nil:
+			v := field.Value(record)
+			r.sum[i] += v
+			r.min[i] = min(r.min[i], v)
+			r.max[i] = max(r.max[i], v)
+		}
+	}
+	r.samples++
+
+	return nil
+}
+
+// Flush writes the current bucket, even if it isn't complete.
+func (r *RecordRollup) Flush() error {
+	if r.samples == 0 {
+		return nil
+	}
+
+	err := r.writer.Write(&Record{Time: r.start})
+	r.samples = 0
+
+	return err
+}
+
+func (r *RecordRollup) Close() error {
+	err := r.Flush()
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// rollupSink rolls the samples up over every period of -rollups, into files
+// named after its prefix.
+type rollupSink struct {
+	rollups []*RecordRollup
+}
+
+func newRollupSink(ctx context.Context, prefix string, config SinkConfig) (Sink, error) {
+	opts := config.Options
+
+	sink := &rollupSink{}
+	for _, period := range opts.Rollups {
+		rollup, err := NewRecordRollup(prefix, period, opts.Fields, opts.Output, opts.TimeFormat.Or(TimeFormatRFC3339))
+		if err != nil {
+			sink.Close()
+			return nil, err
+		}
+
+		sink.rollups = append(sink.rollups, rollup)
+	}
+
+	return sink, nil
+}
+
+func (s *rollupSink) Write(ctx context.Context, sample Sample) error {
+	record := NewRecord(&sample)
+	for _, rollup := range s.rollups {
+		if err := rollup.Add(record); err != nil {
+			return fmt.Errorf("failed to write rollup: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Close writes the last partial bucket of every period.
+func (s *rollupSink) Close() error {
+	var errs []error
+	for _, rollup := range s.rollups {
+		errs = append(errs, rollup.Close())
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,219 @@
+package rcpu
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"solelab.tech/collector/internal/parse"
+)
+
+// TopologySource is where the Sampler reads the topology of the machine.
+type TopologySource int
+
+const (
+	// TopologyAuto runs lscpu on the real /sys, and reads sysfs under any
+	// other root, which lscpu can't look at
+	TopologyAuto TopologySource = iota
+	TopologyLsCPU
+	TopologySysfs
+)
+
+// Sampler is the collector as embedded in another program: it detects the
+// machine, reads its CPU times every interval and publishes the samples to
+// its subscribers. It is configured with SamplerOptions, the collector's
+// flags are WithOptions.
+type Sampler struct {
+	opts     *Options
+	topology TopologySource
+	clock    Clock
+	host     *Host
+	backend  Collector
+	feed     *SampleFeed
+	// observers see every sample on the loop, before the subscribers
+	observers []func(Sample)
+	// sinks are those of WithSink, on top of the specs of the options
+	sinks []Sink
+
+	detection *Detection
+}
+
+type SamplerOption func(*Sampler) error
+
+// WithOptions configures the sampler like the collector's flags, the
+// options after it override them.
+func WithOptions(opts *Options) SamplerOption {
+	return func(s *Sampler) error {
+		o := *opts
+		s.opts = &o
+		return nil
+	}
+}
+
+// WithInterval samples every interval, at least MinInterval and, with
+// -adaptive, at least its fast interval, every second by default.
+func WithInterval(interval time.Duration) SamplerOption {
+	return func(s *Sampler) error {
+		o := *s.opts
+		o.Interval = interval
+		if err := o.validateIntervals(); err != nil {
+			return err
+		}
+
+		s.opts.Interval = interval
+		return nil
+	}
+}
+
+// WithProcFS reads the machine where procfs and sysfs are mounted, e.g.
+// /host/proc and /host/sys in a container.
+func WithProcFS(procRoot, sysRoot string) SamplerOption {
+	return func(s *Sampler) error {
+		s.opts.ProcRoot, s.opts.SysRoot = procRoot, sysRoot
+		return nil
+	}
+}
+
+// WithTopologySource picks where the topology is read, TopologyAuto by
+// default.
+func WithTopologySource(source TopologySource) SamplerOption {
+	return func(s *Sampler) error {
+		if source < TopologyAuto || source > TopologySysfs {
+			return fmt.Errorf("invalid topology source %d", source)
+		}
+
+		s.topology = source
+		return nil
+	}
+}
+
+// WithStrategy picks how busy SMT siblings add up to a core, one of the
+// sibling models, SiblingModelMax by default.
+func WithStrategy(siblingModel string) SamplerOption {
+	return func(s *Sampler) error {
+		if err := ValidateSiblingModel(siblingModel); err != nil {
+			return err
+		}
+
+		s.opts.SiblingModel = siblingModel
+		return nil
+	}
+}
+
+// WithClock paces the sampler with the clock's tickers instead of ticks
+// aligned on the wall clock.
+func WithClock(clock Clock) SamplerOption {
+	return func(s *Sampler) error {
+		s.clock = clock
+		return nil
+	}
+}
+
+// WithObserver calls observe with every sample, e.g. to feed an autoscaler
+// or throttle locally. The observers run on the loop, in the order they were
+// added and before the subscribers get the sample, so a slow one delays the
+// next tick. Subscribe instead, or hand the sample off, for anything slower.
+// The sample is a shallow copy, its slices and pointers, e.g. Pods,
+// Containers and PerCore, are shared with the other observers and the
+// subscribers, so observe must copy rather than modify them.
+func WithObserver(observe func(Sample)) SamplerOption {
+	return func(s *Sampler) error {
+		if observe == nil {
+			return fmt.Errorf("nil observer")
+		}
+
+		s.observers = append(s.observers, observe)
+		return nil
+	}
+}
+
+// WithSink writes every sample to the sink, which Run closes once it stops.
+// The sinks of the collector's flags are those of WithOptions.
+func WithSink(sink Sink) SamplerOption {
+	return func(s *Sampler) error {
+		if sink == nil {
+			return fmt.Errorf("nil sink")
+		}
+
+		s.sinks = append(s.sinks, sink)
+		return nil
+	}
+}
+
+// WithBackend reads the machine through the backend, e.g. a RemoteCollector,
+// with host as what the optional features read.
+func WithBackend(backend Collector, host *Host) SamplerOption {
+	return func(s *Sampler) error {
+		s.backend, s.host = backend, host
+		return nil
+	}
+}
+
+// NewSampler detects the machine, failing if it isn't supported.
+func NewSampler(opts ...SamplerOption) (*Sampler, error) {
+	s := &Sampler{
+		opts:  DefaultOptions(),
+		clock: alignedClock{},
+		feed:  NewSampleFeed(),
+	}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+
+	// Options of WithOptions built by hand skip the checks of ParseOptions
+	if err := s.opts.validateIntervals(); err != nil {
+		return nil, err
+	}
+
+	if s.opts.Node == "" {
+		var err error
+		if s.opts.Node, err = NodeName(); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.backend == nil {
+		s.host = NewHost(s.opts.ProcRoot, s.opts.SysRoot)
+
+		// lscpu always looks at the real /sys
+		useLsCPU := s.opts.SysRoot == SysRootDir
+		if s.topology != TopologyAuto {
+			useLsCPU = s.topology == TopologyLsCPU
+		}
+		s.backend = NewCollector(s.host, useLsCPU)
+	}
+
+	detection, err := s.backend.Detect(s.opts.Preflight)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.opts.CPUs != "" {
+		cpuIds, err := parse.CPUList(s.opts.CPUs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CPU list: %v", err)
+		}
+
+		if err := detection.Restrict(cpuIds); err != nil {
+			return nil, err
+		}
+	}
+	s.detection = detection
+
+	return s, nil
+}
+
+// Detection is the machine as detected by NewSampler.
+func (s *Sampler) Detection() *Detection {
+	return s.detection
+}
+
+// Subscribe returns a channel receiving the samples, see SampleFeed.Subscribe.
+// Subscribing before Run receives the samples from the first tick on, and the
+// channel is closed once Run returns. Like the observers' samples, the slices
+// and pointers of the samples are shared and must not be modified.
+func (s *Sampler) Subscribe(ctx context.Context, opts ...SubscribeOption) (<-chan Sample, error) {
+	return s.feed.Subscribe(ctx, opts...)
+}
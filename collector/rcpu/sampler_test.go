@@ -0,0 +1,317 @@
+package rcpu
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"solelab.tech/collector/internal/testutil"
+)
+
+// writeMachine writes a dual socket machine of 4 cores per socket to disk.
+func writeMachine(t *testing.T) (procRoot, sysRoot string) {
+	procRoot, sysRoot, _ = writeSteppedMachine(t)
+	return procRoot, sysRoot
+}
+
+// writeSteppedMachine also returns a function advancing the machine by a
+// second and writing it again.
+func writeSteppedMachine(t *testing.T) (procRoot, sysRoot string, step func()) {
+	m := testutil.NewMachine(testutil.DualSocket(4))
+	m.Step(time.Hour, func(cpu int) float64 { return 0.3 })
+
+	dir := t.TempDir()
+	procRoot, sysRoot = filepath.Join(dir, "proc"), filepath.Join(dir, "sys")
+	if err := m.WriteDir(procRoot, sysRoot); err != nil {
+		t.Fatal(err)
+	}
+
+	step = func() {
+		m.Step(time.Second, func(cpu int) float64 { return 0.5 })
+		if err := m.WriteDir(procRoot, sysRoot); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return procRoot, sysRoot, step
+}
+
+// manualClock ticks when the test sends on ticks. The loop asks for the
+// ticks once it is done with the previous one, which idle tells.
+type manualClock struct {
+	ticks chan time.Time
+	idle  chan struct{}
+}
+
+func newManualClock() *manualClock {
+	return &manualClock{ticks: make(chan time.Time), idle: make(chan struct{}, 1)}
+}
+
+func (c *manualClock) NewTicker(time.Duration) Ticker { return c }
+
+func (c *manualClock) Ticks() <-chan time.Time {
+	select {
+	case c.idle <- struct{}{}:
+	default:
+	}
+
+	return c.ticks
+}
+
+func (c *manualClock) Reset(time.Duration) {}
+func (c *manualClock) Stop()               {}
+
+// countingSink counts the samples it was written and whether it was closed.
+type countingSink struct {
+	samples atomic.Int32
+	closed  atomic.Bool
+}
+
+func (s *countingSink) Write(ctx context.Context, sample Sample) error {
+	s.samples.Add(1)
+	return nil
+}
+
+func (s *countingSink) Close() error {
+	s.closed.Store(true)
+	return nil
+}
+
+func TestNewSampler(t *testing.T) {
+	procRoot, sysRoot := writeMachine(t)
+
+	s, err := NewSampler(
+		WithProcFS(procRoot, sysRoot),
+		WithTopologySource(TopologySysfs),
+		WithInterval(5*time.Second),
+		WithStrategy(SiblingModelYield),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d := s.Detection(); len(d.CPUInfos) != 16 || len(d.CoreToCPUs) != 8 {
+		t.Errorf("expected 16 CPUs and 8 cores, got %d and %d", len(d.CPUInfos), len(d.CoreToCPUs))
+	}
+	if s.opts.Interval != 5*time.Second || s.opts.SiblingModel != SiblingModelYield {
+		t.Errorf("expected the options to apply, got an interval of %v and the %s model", s.opts.Interval, s.opts.SiblingModel)
+	}
+
+	// The collector's flags, and the options after them
+	opts, err := ParseOptions("collector", []string{"-cpus", "0-3,8-11", "-sibling-model", SiblingModelOverlap})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = NewSampler(WithOptions(opts), WithProcFS(procRoot, sysRoot), WithTopologySource(TopologySysfs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := s.Detection(); len(d.CPUToCore) != 8 {
+		t.Errorf("expected -cpus to restrict the detection to 8 CPUs, got %d", len(d.CPUToCore))
+	}
+	if s.opts.SiblingModel != SiblingModelOverlap || opts.ProcRoot != ProcRootDir {
+		t.Errorf("expected the options to apply to a copy of the flags")
+	}
+}
+
+func TestNewSamplerInvalid(t *testing.T) {
+	procRoot, sysRoot := writeMachine(t)
+
+	for name, opt := range map[string]SamplerOption{
+		"interval": WithInterval(time.Millisecond),
+		"topology": WithTopologySource(TopologySysfs + 1),
+		"strategy": WithStrategy("busiest"),
+		"observer": WithObserver(nil),
+		"sink":     WithSink(nil),
+	} {
+		if _, err := NewSampler(WithProcFS(procRoot, sysRoot), WithTopologySource(TopologySysfs), opt); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	// Shorter than the fast interval of -adaptive
+	adaptive := DefaultOptions()
+	adaptive.Adaptive = true
+	if _, err := NewSampler(WithOptions(adaptive), WithProcFS(procRoot, sysRoot), WithTopologySource(TopologySysfs), WithInterval(100*time.Millisecond)); err == nil {
+		t.Errorf("expected an error for an interval shorter than the fast interval")
+	}
+
+	if _, err := NewSampler(WithProcFS(filepath.Join(procRoot, "missing"), sysRoot), WithTopologySource(TopologySysfs)); err == nil {
+		t.Errorf("expected an error without procfs")
+	}
+}
+
+func TestNewSamplerSentinelErrors(t *testing.T) {
+	procRoot, sysRoot := writeMachine(t)
+
+	// CPUs 0-3 leave out their siblings 8-11
+	opts := DefaultOptions()
+	opts.CPUs = "0-3"
+	if _, err := NewSampler(WithOptions(opts), WithProcFS(procRoot, sysRoot), WithTopologySource(TopologySysfs)); !errors.Is(err, ErrUnsupportedTopology) {
+		t.Errorf("expected ErrUnsupportedTopology, got %v", err)
+	}
+
+	opts = DefaultOptions()
+	opts.Preflight.RequireVendor = "Zilog"
+	if _, err := NewSampler(WithOptions(opts), WithProcFS(procRoot, sysRoot), WithTopologySource(TopologySysfs)); !errors.Is(err, ErrUnsupportedCPU) {
+		t.Errorf("expected ErrUnsupportedCPU, got %v", err)
+	}
+}
+
+func TestSamplerRun(t *testing.T) {
+	procRoot, sysRoot, step := writeSteppedMachine(t)
+	tracePath := filepath.Join(t.TempDir(), "trace")
+
+	clock := newManualClock()
+	csvPath := filepath.Join(t.TempDir(), "rcpu.csv")
+	opts, err := ParseOptions("collector", []string{"-output", "csv", "-trace-file", tracePath, "-sink", "csv:" + csvPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var observed atomic.Int32
+	observe := func(sample Sample) {
+		if sample.CPUs == 16 {
+			observed.Add(1)
+		}
+	}
+	sink := &countingSink{}
+	s, err := NewSampler(WithOptions(opts), WithProcFS(procRoot, sysRoot), WithTopologySource(TopologySysfs), WithClock(clock), WithObserver(observe), WithSink(sink))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	samples, err := s.Subscribe(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Run(ctx)
+	}()
+
+	// The first tick only reads the counters. The files are only written
+	// while the loop waits for a tick, a read of a half written file would
+	// be a stat_parse error of Run.
+	var received int
+	for deadline := time.Now().Add(10 * time.Second); received < 2; {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out after %d samples", received)
+		}
+
+		<-clock.idle
+		step()
+		clock.ticks <- time.Now()
+
+		select {
+		case sample := <-samples:
+			if sample.CPUs != 16 || sample.AvgCPUUsage < 49 || sample.AvgCPUUsage > 51 || len(sample.PerCore) != 8 {
+				t.Fatalf("expected a sample of 16 CPUs 50%% busy with 8 cores, got %+v", sample)
+			}
+			received++
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected a clean stop, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected Run to return once ctx is done")
+	}
+
+	for range samples {
+	}
+
+	// The observers see every sample, before the subscribers
+	if n := observed.Load(); int(n) < received {
+		t.Errorf("expected the observer to see at least %d samples, got %d", received, n)
+	}
+
+	// The sinks get every sample, and are closed once the last is in
+	if n := sink.samples.Load(); int(n) < received || !sink.closed.Load() {
+		t.Errorf("expected the sink to get at least %d samples and be closed, got %d samples, closed %v", received, n, sink.closed.Load())
+	}
+
+	data, err := os.ReadFile(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines < received+1 {
+		t.Errorf("expected a header and at least %d lines in the csv sink, got %d lines", received, lines)
+	}
+
+	// The trace is only written in chunks, stopping flushed the last one
+	f, err := os.Open(tracePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	trace, err := NewTraceReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer trace.Close()
+
+	var records int
+	for {
+		if _, err := trace.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		records++
+	}
+	if records < received {
+		t.Errorf("expected at least %d trace records, got %d", received, records)
+	}
+}
+
+func TestSamplerRunReportsErrors(t *testing.T) {
+	procRoot, sysRoot := writeMachine(t)
+
+	clock := newManualClock()
+	s, err := NewSampler(WithProcFS(procRoot, sysRoot), WithTopologySource(TopologySysfs), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Run(ctx)
+	}()
+
+	// The garbled counters are skipped, and the sampler runs on
+	<-clock.idle
+	if err := os.WriteFile(filepath.Join(procRoot, ProcStatName), []byte("cpu garbled\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	clock.ticks <- time.Now()
+	<-clock.idle
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrCollectionErrors) || !strings.Contains(err.Error(), ErrorClassStatParse+" 1") {
+			t.Errorf("expected Run to report the stat_parse error, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected Run to return once ctx is done")
+	}
+}
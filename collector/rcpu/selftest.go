@@ -0,0 +1,206 @@
+package rcpu
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"testing/fstest"
+	"time"
+
+	"solelab.tech/collector/cputime"
+)
+
+//go:embed fixtures/*.json
+var fixtureFiles embed.FS
+
+// Fixture is a snapshot of a machine, trimmed to a few cores, with what the
+// collector is expected to make of it.
+type Fixture struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Proc        map[string]string `json:"proc"`
+	Sys         map[string]string `json:"sys"`
+	// Stat holds two consecutive reads of /proc/stat
+	Stat   [2]string          `json:"stat"`
+	Expect FixtureExpectation `json:"expect"`
+}
+
+type FixtureExpectation struct {
+	// Error is the sentinel detection fails with, empty if supported
+	Error            string  `json:"error,omitempty"`
+	CPUs             int     `json:"cpus,omitempty"`
+	Cores            int     `json:"cores,omitempty"`
+	Sockets          int     `json:"sockets,omitempty"`
+	AnomalousCores   int     `json:"anomalousCores,omitempty"`
+	AvgCPUUsage      float64 `json:"avgCPUUsage,omitempty"`
+	AdjustedCPUUsage float64 `json:"adjustedCPUUsage,omitempty"`
+}
+
+var fixtureErrors = []error{ErrUnsupportedCPU, ErrSMTDisabled, ErrUnsupportedTopology, ErrStatParse, ErrCounterReset}
+
+func LoadFixtures() ([]*Fixture, error) {
+	names, err := fs.Glob(fixtureFiles, "fixtures/*.json")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	var fixtures []*Fixture
+	for _, name := range names {
+		data, err := fixtureFiles.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", name, err)
+		}
+
+		fixture := &Fixture{}
+		if err := json.Unmarshal(data, fixture); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", name, err)
+		}
+		fixtures = append(fixtures, fixture)
+	}
+
+	return fixtures, nil
+}
+
+func mapFS(files map[string]string) fstest.MapFS {
+	m := fstest.MapFS{}
+	for name, data := range files {
+		m[path.Clean(name)] = &fstest.MapFile{Data: []byte(data)}
+	}
+
+	return m
+}
+
+// Host returns the fixture as a host, with the given read of /proc/stat.
+func (f *Fixture) Host(stat int) *Host {
+	proc := mapFS(f.Proc)
+	proc[ProcStatName] = &fstest.MapFile{Data: []byte(f.Stat[stat])}
+
+	return &Host{Proc: proc, Sys: mapFS(f.Sys)}
+}
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+// Check runs detection and the formulas on the fixture and compares them
+// with the expectation.
+func (f *Fixture) Check() error {
+	detection, err := Detect(f.Host(0), false, DefaultPreflight())
+	if f.Expect.Error != "" {
+		for _, sentinel := range fixtureErrors {
+			if sentinel.Error() == f.Expect.Error && errors.Is(err, sentinel) {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("expected %q, got %v", f.Expect.Error, err)
+	}
+
+	if err != nil {
+		return fmt.Errorf("detection failed: %v", err)
+	}
+
+	sockets := make(map[int32]bool)
+	for _, info := range detection.CPUInfos {
+		sockets[info.SocketId] = true
+	}
+	machine := MachineInfo{CPUs: len(detection.CPUInfos), Cores: len(detection.CoreToCPUs), Sockets: len(sockets), AnomalousCores: len(detection.AnomalousCores)}
+
+	if machine.CPUs != f.Expect.CPUs || machine.Cores != f.Expect.Cores || machine.Sockets != f.Expect.Sockets || machine.AnomalousCores != f.Expect.AnomalousCores {
+		return fmt.Errorf("expected %d CPUs, %d cores, %d sockets, %d anomalous cores, got %d, %d, %d, %d",
+			f.Expect.CPUs, f.Expect.Cores, f.Expect.Sockets, f.Expect.AnomalousCores, machine.CPUs, machine.Cores, machine.Sockets, machine.AnomalousCores)
+	}
+
+	var times [2][]CPUTime
+	for i := range times {
+		r, err := NewProcStatReader(f.Host(i))
+		if err != nil {
+			return err
+		}
+
+		times[i], err = r.ReadInto(nil)
+		r.Close()
+		if err != nil {
+			return err
+		}
+
+		// Both reads are taken now, space them a second apart
+		for j := range times[i] {
+			times[i][j].CollectTime = times[i][j].CollectTime.Add(time.Duration(i) * time.Second)
+		}
+	}
+
+	var maxCPUId int32
+	for cpuId := range detection.CPUToCore {
+		maxCPUId = max(maxCPUId, cpuId)
+	}
+
+	periods := make([]CPUTimePeriod, maxCPUId+1)
+	if err := computePeriods(periods, times[0], times[1], 1); err != nil {
+		return err
+	}
+
+	avgCPUUsage, err := cputime.AverageCPUUsage(periods)
+	if err != nil {
+		return err
+	}
+
+	adjustedCPUUsage, err := cputime.AdjustedCPUUsage(NewCoreList(detection.CoreToCPUs), periods)
+	if err != nil {
+		return err
+	}
+
+	if !almostEqual(avgCPUUsage, f.Expect.AvgCPUUsage) || !almostEqual(adjustedCPUUsage, f.Expect.AdjustedCPUUsage) {
+		return fmt.Errorf("expected %.4f%% average and %.4f%% adjusted usage, got %.4f%% and %.4f%%",
+			f.Expect.AvgCPUUsage, f.Expect.AdjustedCPUUsage, avgCPUUsage, adjustedCPUUsage)
+	}
+
+	return nil
+}
+
+// WriteSelftest checks every fixture, or only the named one, and reports
+// how many failed.
+func WriteSelftest(w io.Writer, fixtures []*Fixture, only string) int {
+	failed := 0
+	for _, fixture := range fixtures {
+		if only != "" && fixture.Name != only {
+			continue
+		}
+
+		if err := fixture.Check(); err != nil {
+			fmt.Fprintf(w, "FAIL %s: %v\n", fixture.Name, err)
+			failed++
+			continue
+		}
+
+		fmt.Fprintf(w, "ok   %s (%s)\n", fixture.Name, fixture.Description)
+	}
+
+	return failed
+}
+
+func RunSelftest(args []string) error {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	only := fs.String("fixture", "", "only check this fixture")
+	fs.Parse(args)
+
+	fixtures, err := LoadFixtures()
+	if err != nil {
+		return err
+	}
+
+	if failed := WriteSelftest(os.Stdout, fixtures, *only); failed > 0 {
+		return fmt.Errorf("%d fixtures failed", failed)
+	}
+
+	return nil
+}
@@ -0,0 +1,20 @@
+package rcpu
+
+import (
+	"testing"
+)
+
+func TestFixtures(t *testing.T) {
+	fixtures, err := LoadFixtures()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fixture := range fixtures {
+		t.Run(fixture.Name, func(t *testing.T) {
+			if err := fixture.Check(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
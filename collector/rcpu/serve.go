@@ -0,0 +1,227 @@
+package rcpu
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultShutdownTimeout bounds how long the requests in flight may take to
+// complete once a server is stopped
+const DefaultShutdownTimeout = 5 * time.Second
+
+// ServerSecurity is the TLS and client authentication of a server. Without a
+// certificate it serves plain text, with a client CA it requires clients to
+// present a certificate the CA signed, and with a token it requires the bearer
+// token. The client checks combine.
+type ServerSecurity struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	Token        string
+}
+
+// ServerSecurityFlags are the flags of a ServerSecurity, see
+// AddServerSecurityFlags.
+type ServerSecurityFlags struct {
+	certFile     *string
+	keyFile      *string
+	clientCAFile *string
+	tokenFile    *string
+}
+
+// AddServerSecurityFlags adds the TLS and client authentication flags of a
+// server, their names starting with prefix, e.g. -metrics-tls-cert-file.
+func AddServerSecurityFlags(fs *flag.FlagSet, prefix, server string) *ServerSecurityFlags {
+	return &ServerSecurityFlags{
+		certFile:     fs.String(prefix+"tls-cert-file", "", "serve "+server+" over TLS with the PEM certificate in this file"),
+		keyFile:      fs.String(prefix+"tls-key-file", "", "PEM key of the -"+prefix+"tls-cert-file certificate"),
+		clientCAFile: fs.String(prefix+"client-ca-file", "", "require the clients of "+server+" to present a certificate signed by the PEM CAs in this file"),
+		tokenFile:    fs.String(prefix+"token-file", "", "require the clients of "+server+" to present the bearer token in this file"),
+	}
+}
+
+// Load validates the flags and reads the token.
+func (f *ServerSecurityFlags) Load() (ServerSecurity, error) {
+	s := ServerSecurity{CertFile: *f.certFile, KeyFile: *f.keyFile, ClientCAFile: *f.clientCAFile}
+
+	if (s.CertFile == "") != (s.KeyFile == "") {
+		return s, fmt.Errorf("a TLS certificate requires its key and the other way around")
+	}
+
+	// Client certificates are presented in the TLS handshake
+	if s.ClientCAFile != "" && s.CertFile == "" {
+		return s, fmt.Errorf("a client CA requires a TLS certificate")
+	}
+
+	if *f.tokenFile != "" {
+		token, err := LoadToken(*f.tokenFile)
+		if err != nil {
+			return s, err
+		}
+		s.Token = token
+	}
+
+	// Fail at startup rather than on the first handshake
+	if _, err := s.TLSConfig(); err != nil {
+		return s, err
+	}
+
+	return s, nil
+}
+
+// TLSConfig returns the server's TLS configuration, nil without TLS. The
+// certificate and client CA are reloaded once rotated.
+func (s ServerSecurity) TLSConfig() (*tls.Config, error) {
+	if s.CertFile == "" {
+		return nil, nil
+	}
+
+	reloader, err := NewCertReloader(s.CertFile, s.KeyFile, s.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return reloader.ServerConfig(), nil
+}
+
+// LoadCertPool reads the PEM certificates of a CA bundle.
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA %s: %v", path, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM certificate in CA %s", path)
+	}
+
+	return pool, nil
+}
+
+// authorized checks the bearer token of an Authorization header, of HTTP or
+// of gRPC metadata
+func (s ServerSecurity) authorized(header string) bool {
+	if s.Token == "" {
+		return true
+	}
+
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) == 1
+}
+
+// Handler requires the bearer token of the requests to h, except those exempt
+// returns true for, which authenticate on their own. exempt may be nil.
+func (s ServerSecurity) Handler(h http.Handler, exempt func(r *http.Request) bool) http.Handler {
+	if s.Token == "" {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (exempt == nil || !exempt(r)) && !s.authorized(r.Header.Get("Authorization")) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// ListenAndServe serves h on addr, over TLS if configured, until ctx is done,
+// and then shuts the server down, returning nil.
+func (s ServerSecurity) ListenAndServe(ctx context.Context, addr string, h http.Handler) error {
+	config, err := s.TLSConfig()
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{Addr: addr, Handler: h, TLSConfig: config}
+	stop := context.AfterFunc(ctx, func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), DefaultShutdownTimeout)
+		defer cancel()
+
+		server.Shutdown(shutdownCtx)
+	})
+	defer stop()
+
+	if config == nil {
+		err = server.ListenAndServe()
+	} else {
+		// The certificate is in the TLS configuration already
+		err = server.ListenAndServeTLS("", "")
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// GRPCServerOptions returns the options of a gRPC server requiring TLS and the
+// bearer token as configured. Methods exempt returns true for, by their full
+// name, authenticate on their own. exempt may be nil.
+func (s ServerSecurity) GRPCServerOptions(exempt func(method string) bool) ([]grpc.ServerOption, error) {
+	var opts []grpc.ServerOption
+
+	config, err := s.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(config)))
+	}
+
+	if s.Token != "" {
+		check := func(ctx context.Context, method string) error {
+			if (exempt == nil || !exempt(method)) && !s.authorized(authorizationMetadata(ctx)) {
+				return status.Error(codes.Unauthenticated, "unauthorized")
+			}
+
+			return nil
+		}
+
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+				if err := check(ctx, info.FullMethod); err != nil {
+					return nil, err
+				}
+
+				return handler(ctx, req)
+			}),
+			grpc.ChainStreamInterceptor(func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+				if err := check(ss.Context(), info.FullMethod); err != nil {
+					return err
+				}
+
+				return handler(srv, ss)
+			}),
+		)
+	}
+
+	return opts, nil
+}
+
+// authorizationMetadata returns the Authorization header of a gRPC call
+func authorizationMetadata(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("authorization")) > 0 {
+		return md.Get("authorization")[0]
+	}
+
+	return ""
+}
@@ -0,0 +1,298 @@
+package rcpu
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+
+	rcpuv1 "solelab.tech/collector/proto/rcpu/v1"
+)
+
+// testCertificate writes a certificate and its key signed by parent, or self
+// signed without one, and returns their paths
+func testCertificate(t *testing.T, name string, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (string, string, *x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile, cert, key
+}
+
+// testPKI writes a CA, a certificate for 127.0.0.1 and a client certificate
+func testPKI(t *testing.T) (caFile, serverCert, serverKey string, client tls.Certificate) {
+	t.Helper()
+
+	now := time.Now()
+	caFile, _, ca, caKey := testCertificate(t, "ca", &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "rcpu test CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, nil, nil)
+
+	serverCert, serverKey, _, _ = testCertificate(t, "server", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "aggregator"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+
+	clientCert, clientKey, _, _ := testCertificate(t, "client", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "annotator"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	client, err := tls.LoadX509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return caFile, serverCert, serverKey, client
+}
+
+func TestServerSecurityMutualTLS(t *testing.T) {
+	caFile, serverCert, serverKey, clientCert := testPKI(t)
+	security := ServerSecurity{CertFile: serverCert, KeyFile: serverKey, ClientCAFile: caFile, Token: "secret"}
+
+	config, err := security.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(security.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), nil))
+	server.TLS = config
+	server.StartTLS()
+	defer server.Close()
+
+	roots, err := LoadCertPool(caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(certs []tls.Certificate, token string) (int, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+
+		return resp.StatusCode, nil
+	}
+
+	if _, err := get(nil, "secret"); err == nil {
+		t.Errorf("expected the handshake without a client certificate to fail")
+	}
+
+	if code, err := get([]tls.Certificate{clientCert}, "wrong"); err != nil || code != http.StatusUnauthorized {
+		t.Errorf("expected 401 with the wrong token, got %d %v", code, err)
+	}
+
+	if code, err := get([]tls.Certificate{clientCert}, "secret"); err != nil || code != http.StatusNoContent {
+		t.Errorf("expected 204 with the certificate and token, got %d %v", code, err)
+	}
+}
+
+func TestServerSecurityLoad(t *testing.T) {
+	for _, args := range [][]string{
+		{"-tls-cert-file", "server.crt"},
+		{"-client-ca-file", "ca.crt"},
+		{"-tls-cert-file", "missing.crt", "-tls-key-file", "missing.key"},
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		flags := AddServerSecurityFlags(fs, "", "the server")
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := flags.Load(); err == nil {
+			t.Errorf("expected %v to fail", args)
+		}
+	}
+}
+
+func TestServerSecurityGRPCPeersExempt(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aggregator := NewAggregator(time.Hour)
+	aggregator.SetPeers(Peers{"peer-secret": "cluster-1"})
+
+	security := ServerSecurity{Token: "secret"}
+	opts, err := security.GRPCServerOptions(func(method string) bool {
+		return method == rcpuv1.SampleService_Push_FullMethodName
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := grpc.NewServer(opts...)
+	rcpuv1.RegisterSampleServiceServer(server, &sampleService{aggregator: aggregator})
+	go server.Serve(lis)
+	defer server.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := rcpuv1.NewSampleServiceClient(conn)
+
+	batch := &rcpuv1.SampleBatch{Samples: []*rcpuv1.Sample{SampleToProto(&Sample{Node: "node-1", Time: time.Now(), CPUs: 4, Cores: 2})}}
+	push := func(token string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+		_, err := client.Push(ctx, batch)
+		return err
+	}
+
+	// The push is exempt from the server's token, the peer's token counts
+	if err := push("secret"); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected the server's token to be refused for a peer push, got %v", err)
+	}
+
+	if err := push("peer-secret"); err != nil {
+		t.Errorf("expected the peer's push to be accepted, got %v", err)
+	}
+}
+
+func TestAggregatorGRPCHealthAndReflection(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := NewAggregatorGRPCServer(NewAggregator(time.Hour), ServerSecurity{Token: "secret"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(lis)
+	defer server.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Probes have no token
+	for _, service := range []string{"", rcpuv1.SampleService_ServiceDesc.ServiceName} {
+		resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil || resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+			t.Errorf("expected %q to be serving, got %v %v", service, resp, err)
+		}
+	}
+
+	listServices := func(ctx context.Context) ([]string, error) {
+		stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := stream.Send(&reflectionpb.ServerReflectionRequest{MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{}}); err != nil {
+			return nil, err
+		}
+
+		resp, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+
+		var services []string
+		for _, service := range resp.GetListServicesResponse().GetService() {
+			services = append(services, service.GetName())
+		}
+
+		return services, nil
+	}
+
+	if _, err := listServices(ctx); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected reflection without the token to be refused, got %v", err)
+	}
+
+	services, err := listServices(metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(services, rcpuv1.SampleService_ServiceDesc.ServiceName) {
+		t.Errorf("expected reflection to list the sample service, got %v", services)
+	}
+}
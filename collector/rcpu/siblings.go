@@ -0,0 +1,128 @@
+package rcpu
+
+import (
+	"fmt"
+	"strings"
+
+	"solelab.tech/collector/cputime"
+)
+
+const (
+	SiblingModelMax     = "max"
+	SiblingModelYield   = "yield"
+	SiblingModelOverlap = "overlap"
+	SiblingModelIPC     = "ipc"
+
+	// DefaultSMTYield is the throughput a second busy thread adds to a core,
+	// a common figure for mixed workloads
+	DefaultSMTYield = 0.25
+)
+
+var siblingModels = []string{SiblingModelMax, SiblingModelYield, SiblingModelOverlap, SiblingModelIPC}
+
+func ValidateSiblingModel(name string) error {
+	for _, model := range siblingModels {
+		if model == name {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid sibling model %q, expected one of %s", name, strings.Join(siblingModels, ", "))
+}
+
+// SiblingModel combines the busy time of the hardware threads of every core
+// into the adjusted CPU usage.
+type SiblingModel interface {
+	AdjustedCPUUsage(cores [][]int32, cpuTimePeriods []CPUTimePeriod) (float64, error)
+}
+
+// MaxSiblingModel is cputime.AdjustedCPUUsage, a core is as busy as its busiest
+// thread. It is the most pessimistic rule, assuming a busy sibling leaves
+// nothing for the other thread.
+type MaxSiblingModel struct{}
+
+func (MaxSiblingModel) AdjustedCPUUsage(cores [][]int32, cpuTimePeriods []CPUTimePeriod) (float64, error) {
+	return cputime.AdjustedCPUUsage(cores, cpuTimePeriods)
+}
+
+// NewSiblingModel returns the model of the given name, except ipc, which
+// needs the perf events, see NewIPCSiblingModel.
+func NewSiblingModel(name string, yield float64) (SiblingModel, error) {
+	switch name {
+	case SiblingModelMax:
+		return MaxSiblingModel{}, nil
+	case SiblingModelYield:
+		return YieldSiblingModel{Yield: yield}, nil
+	case SiblingModelOverlap:
+		return OverlapSiblingModel{Yield: yield}, nil
+	}
+
+	return nil, fmt.Errorf("sibling model %q can't be created by name", name)
+}
+
+// YieldSiblingModel counts the second thread of a core as Yield of a core.
+// A core offers 1+Yield, its busiest thread uses 1 and the other thread Yield
+// while they overlap. Like MaxSiblingModel it assumes the threads are busy at
+// the same time, which it equals with a Yield of 0, while a Yield of 1 counts
+// the average of the threads.
+type YieldSiblingModel struct {
+	Yield float64
+}
+
+func (m YieldSiblingModel) AdjustedCPUUsage(cores [][]int32, cpuTimePeriods []CPUTimePeriod) (float64, error) {
+	return combineCores(cores, cpuTimePeriods, func(_ []int32, busy0, busy1 float64) float64 {
+		return (max(busy0, busy1) + m.Yield*min(busy0, busy1)) / (1 + m.Yield)
+	})
+}
+
+// OverlapSiblingModel is YieldSiblingModel with threads busy independently
+// of each other, so they overlap busy0*busy1 of the time instead of the
+// shorter busy time. It suits many unrelated tasks better than a few
+// synchronized ones, and counts lightly loaded cores busier than max does.
+type OverlapSiblingModel struct {
+	Yield float64
+}
+
+func (m OverlapSiblingModel) AdjustedCPUUsage(cores [][]int32, cpuTimePeriods []CPUTimePeriod) (float64, error) {
+	return combineCores(cores, cpuTimePeriods, func(_ []int32, busy0, busy1 float64) float64 {
+		both := busy0 * busy1
+		alone := busy0 + busy1 - 2*both
+		return (alone + (1+m.Yield)*both) / (1 + m.Yield)
+	})
+}
+
+func threadBusy(p *CPUTimePeriod) float64 {
+	if p.TotalPeriod == 0 {
+		return 0
+	}
+
+	return 1 - float64(p.TotalIdlePeriod)/float64(p.TotalPeriod)
+}
+
+// combineCores averages the usage of every core as combine computes it from
+// the busy fractions of its threads, in percent. The CPUs of an anomalous
+// core count as cores of their own.
+func combineCores(cores [][]int32, cpuTimePeriods []CPUTimePeriod, combine func(core []int32, busy0, busy1 float64) float64) (float64, error) {
+	if len(cores) == 0 {
+		return 0.0, fmt.Errorf("no cores")
+	}
+
+	var total float64
+	n := 0
+	for _, cpuIds := range cores {
+		if len(cpuIds) != 2 {
+			for _, cpuId := range cpuIds {
+				total += threadBusy(&cpuTimePeriods[cpuId])
+			}
+			n += len(cpuIds)
+			continue
+		}
+
+		busy0 := threadBusy(&cpuTimePeriods[cpuIds[0]])
+		busy1 := threadBusy(&cpuTimePeriods[cpuIds[1]])
+		total += combine(cpuIds, busy0, busy1)
+		n++
+	}
+
+	return 100.0 * total / float64(n), nil
+}
@@ -0,0 +1,80 @@
+package rcpu
+
+import (
+	"math"
+	"testing"
+)
+
+// siblingPeriods returns periods of 100 ticks with the given busy ticks.
+func siblingPeriods(busy ...uint64) []CPUTimePeriod {
+	periods := make([]CPUTimePeriod, len(busy))
+	for i, b := range busy {
+		periods[i] = CPUTimePeriod{CPUId: int32(i), TotalPeriod: 100, TotalIdlePeriod: 100 - b}
+	}
+
+	return periods
+}
+
+func TestSiblingModels(t *testing.T) {
+	pair := [][]int32{{0, 1}}
+	// CPU 4 is the anomalous core of a single thread
+	machine := [][]int32{{0, 1}, {2, 3}, {4}}
+
+	tests := []struct {
+		name         string
+		cores        [][]int32
+		periods      []CPUTimePeriod
+		yield        float64
+		yieldModel   float64
+		overlapModel float64
+	}{
+		// (0.8 + 0.25*0.4) / 1.25 and, overlapping 0.32 of the time,
+		// (0.56 + 1.25*0.32) / 1.25
+		{"busy pair", pair, siblingPeriods(80, 40), 0.25, 72, 76.8},
+		// Without a yield the model is max, and the overlap counts either
+		// thread busy, 0.56 + 0.32
+		{"no yield", pair, siblingPeriods(80, 40), 0, 80, 88},
+		// A yield of 1 averages the threads
+		{"full yield", pair, siblingPeriods(80, 40), 1, 60, 60},
+		{"idle pair", pair, siblingPeriods(0, 0), 0.25, 0, 0},
+		{"saturated pair", pair, siblingPeriods(100, 100), 0.25, 100, 100},
+		// The busy pair, an idle pair and a thread busy half the time, 1.22 / 3
+		// and 1.268 / 3
+		{"anomalous core", machine, siblingPeriods(80, 40, 0, 0, 50), 0.25, 122.0 / 3, 126.8 / 3},
+	}
+
+	for _, test := range tests {
+		yield, err := YieldSiblingModel{Yield: test.yield}.AdjustedCPUUsage(test.cores, test.periods)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(yield-test.yieldModel) > 1e-9 {
+			t.Errorf("%s: expected the yield model at %.4f%%, got %.4f%%", test.name, test.yieldModel, yield)
+		}
+
+		overlap, err := OverlapSiblingModel{Yield: test.yield}.AdjustedCPUUsage(test.cores, test.periods)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(overlap-test.overlapModel) > 1e-9 {
+			t.Errorf("%s: expected the overlap model at %.4f%%, got %.4f%%", test.name, test.overlapModel, overlap)
+		}
+	}
+}
+
+func TestSiblingModelsEmptyPeriod(t *testing.T) {
+	// A period without ticks, e.g. of an offline CPU, is idle
+	periods := []CPUTimePeriod{{CPUId: 0}, {CPUId: 1, TotalPeriod: 100, TotalIdlePeriod: 50}}
+
+	usage, err := YieldSiblingModel{Yield: 0.25}.AdjustedCPUUsage([][]int32{{0, 1}}, periods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(usage-40) > 1e-9 {
+		t.Errorf("expected 0.5 / 1.25, got %.4f%%", usage)
+	}
+
+	if _, err := (OverlapSiblingModel{}).AdjustedCPUUsage(nil, nil); err == nil {
+		t.Errorf("expected an error without cores")
+	}
+}
@@ -0,0 +1,332 @@
+package rcpu
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aquasecurity/table"
+)
+
+// Sinks built in, see RegisterSink. The table, csv and json sinks are the
+// -output formats of the machine view.
+const (
+	SinkTable      = OutputTable
+	SinkCSV        = OutputCSV
+	SinkJSON       = OutputJSON
+	SinkPerCore    = "per-core"
+	SinkRaw        = "raw"
+	SinkHeatmap    = "heatmap"
+	SinkTrace      = "trace"
+	SinkNFD        = "nfd"
+	SinkRollup     = "rollup"
+	SinkAggregator = "aggregator"
+	SinkUpstream   = "upstream"
+)
+
+// Sink receives every sample of the collector, each from a subscription of
+// its own, so a slow sink doesn't hold up the loop or the other sinks.
+type Sink interface {
+	// Write handles a sample. An error stops the collector, failures a sink
+	// can recover from, e.g. an unreachable aggregator, are its to log.
+	// Samples left when the collector stops are still written, with ctx done.
+	Write(ctx context.Context, sample Sample) error
+	// Close flushes the sink once the samples are written.
+	Close() error
+}
+
+// SinkConfig is what a sink is built from besides its argument.
+type SinkConfig struct {
+	Options *Options
+	Errors  *ErrorLimiter
+}
+
+// SinkFactory builds a sink from the argument of its -sink spec, which is
+// empty when the spec has none. Goroutines of the sink run until ctx is done,
+// before Close is called.
+type SinkFactory func(ctx context.Context, arg string, config SinkConfig) (Sink, error)
+
+var sinkRegistry = struct {
+	sync.Mutex
+	factories map[string]SinkFactory
+}{factories: make(map[string]SinkFactory)}
+
+// RegisterSink makes the sink available to -sink under name. It panics if
+// the name is taken, as registering happens in init.
+func RegisterSink(name string, factory SinkFactory) {
+	sinkRegistry.Lock()
+	defer sinkRegistry.Unlock()
+
+	if _, ok := sinkRegistry.factories[name]; ok {
+		panic(fmt.Sprintf("sink %q is already registered", name))
+	}
+	sinkRegistry.factories[name] = factory
+}
+
+// SinkNames returns the names of the registered sinks, sorted.
+func SinkNames() []string {
+	sinkRegistry.Lock()
+	defer sinkRegistry.Unlock()
+
+	names := make([]string, 0, len(sinkRegistry.factories))
+	for name := range sinkRegistry.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// SinkSpec is a sink of -sink, NAME[:ARG], e.g. csv:/var/log/rcpu.csv.
+type SinkSpec struct {
+	Name string
+	Arg  string
+}
+
+func ParseSinkSpec(spec string) (SinkSpec, error) {
+	name, arg, _ := strings.Cut(spec, ":")
+
+	sinkRegistry.Lock()
+	_, ok := sinkRegistry.factories[name]
+	sinkRegistry.Unlock()
+	if !ok {
+		return SinkSpec{}, fmt.Errorf("invalid sink %q, expected one of %s", name, strings.Join(SinkNames(), ", "))
+	}
+
+	return SinkSpec{Name: name, Arg: arg}, nil
+}
+
+func (s SinkSpec) String() string {
+	if s.Arg == "" {
+		return s.Name
+	}
+
+	return s.Name + ":" + s.Arg
+}
+
+// Stdout tells the sinks writing to stdout, only one of which can run.
+func (s SinkSpec) Stdout() bool {
+	switch s.Name {
+	case SinkTable, SinkPerCore, SinkRaw, SinkHeatmap:
+		return true
+	case SinkCSV, SinkJSON:
+		return s.Arg == ""
+	}
+
+	return false
+}
+
+// ValidateSinks checks the specs before anything is opened, the arguments of
+// the built in sinks and that at most one writes to stdout.
+func ValidateSinks(specs []string) error {
+	var stdout []string
+	var upstream bool
+	for _, s := range specs {
+		spec, err := ParseSinkSpec(s)
+		if err != nil {
+			return err
+		}
+
+		if spec.Stdout() {
+			stdout = append(stdout, spec.String())
+		}
+
+		switch spec.Name {
+		case SinkTable, SinkPerCore, SinkRaw, SinkHeatmap:
+			if spec.Arg != "" {
+				return fmt.Errorf("the %s sink writes to stdout and takes no argument", spec.Name)
+			}
+		case SinkTrace, SinkNFD:
+			if spec.Arg == "" {
+				return fmt.Errorf("the %s sink requires the path of its file, %s:PATH", spec.Name, spec.Name)
+			}
+		case SinkRollup:
+			if spec.Arg == "" {
+				return fmt.Errorf("the %s sink requires the prefix of its files, %s:PREFIX", spec.Name, spec.Name)
+			}
+		case SinkAggregator:
+			if spec.Arg == "" {
+				return fmt.Errorf("the %s sink requires the aggregator's address, %s:ADDRESS", spec.Name, spec.Name)
+			}
+		case SinkUpstream:
+			// The samples of both would share the disk buffer
+			if upstream {
+				return fmt.Errorf("only one %s sink can run", SinkUpstream)
+			}
+			upstream = true
+
+			if _, _, err := ParseUpstream(spec.Arg); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(stdout) > 1 {
+		return fmt.Errorf("only one sink can write to stdout, got %s", strings.Join(stdout, " and "))
+	}
+
+	return nil
+}
+
+// NewSink builds the sink of the spec.
+func NewSink(ctx context.Context, spec string, config SinkConfig) (Sink, error) {
+	s, err := ParseSinkSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	sinkRegistry.Lock()
+	factory := sinkRegistry.factories[s.Name]
+	sinkRegistry.Unlock()
+
+	sink, err := factory(ctx, s.Arg, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the %s sink: %v", s.Name, err)
+	}
+
+	return sink, nil
+}
+
+func init() {
+	RegisterSink(SinkTable, newRecordSink(SinkTable))
+	RegisterSink(SinkCSV, newRecordSink(SinkCSV))
+	RegisterSink(SinkJSON, newRecordSink(SinkJSON))
+	RegisterSink(SinkPerCore, newPerCoreSink)
+	RegisterSink(SinkRaw, newRawSink)
+	RegisterSink(SinkHeatmap, newHeatmapSink)
+	RegisterSink(SinkTrace, newTraceSink)
+	RegisterSink(SinkNFD, newNFDSink)
+	RegisterSink(SinkRollup, newRollupSink)
+	RegisterSink(SinkAggregator, newPusherSink)
+	RegisterSink(SinkUpstream, newUpstreamSink)
+}
+
+// recordSink writes the machine view in one of the -output formats, to stdout
+// or appending to a file.
+type recordSink struct {
+	records RecordWriter
+	file    *os.File
+}
+
+func newRecordSink(output string) SinkFactory {
+	return func(ctx context.Context, path string, config SinkConfig) (Sink, error) {
+		opts := config.Options
+
+		if path == "" {
+			var records RecordWriter
+			switch output {
+			case OutputCSV:
+				records = NewCSVRecordWriter(os.Stdout, opts.Fields, opts.TimeFormat.Or(TimeFormatRFC3339))
+			case OutputJSON:
+				records = NewJSONRecordWriter(os.Stdout, opts.Fields, opts.TimeFormat.Or(TimeFormatRFC3339))
+			default:
+				color := UseColor(opts.NoColor, os.Stdout)
+				records = NewTableRecordWriter(os.Stdout, opts.Fields, color, opts.Rows, opts.TimeFormat.Or(TimeFormatClock))
+			}
+
+			return &recordSink{records: records}, nil
+		}
+
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to stat %s: %v", path, err)
+		}
+
+		sink := &recordSink{file: file}
+		if output == OutputJSON {
+			sink.records = NewJSONRecordWriter(file, opts.Fields, opts.TimeFormat.Or(TimeFormatRFC3339))
+		} else {
+			// Appending to an earlier run's file, which has the header already
+			sink.records = &csvRecordWriter{w: csv.NewWriter(file), fields: opts.Fields, timeFormat: opts.TimeFormat.Or(TimeFormatRFC3339), started: info.Size() > 0}
+		}
+
+		return sink, nil
+	}
+}
+
+func (s *recordSink) Write(ctx context.Context, sample Sample) error {
+	if err := s.records.Write(NewRecord(&sample)); err != nil {
+		return fmt.Errorf("failed to write output: %v", err)
+	}
+
+	return nil
+}
+
+func (s *recordSink) Close() error {
+	if s.file == nil {
+		return nil
+	}
+
+	return s.file.Close()
+}
+
+// perCoreSink shows the usage of the busiest, or otherwise -sort ordered,
+// -rows cores every tick.
+type perCoreSink struct {
+	display    Display
+	color      bool
+	rows       int
+	sort       string
+	timeFormat TimeFormat
+	usages     []CoreUsage
+}
+
+func newPerCoreSink(ctx context.Context, arg string, config SinkConfig) (Sink, error) {
+	opts := config.Options
+
+	sink := &perCoreSink{
+		color:      UseColor(opts.NoColor, os.Stdout),
+		rows:       opts.Rows,
+		sort:       opts.Sort,
+		timeFormat: opts.TimeFormat.Or(TimeFormatClock),
+	}
+
+	headers := []string{"Time", "Core", "CPUs", "Busy", "Idle", "Difference"}
+	if sink.color {
+		tableDisplay := NewTableDisplay(os.Stdout, opts.Rows, headers...)
+		tableDisplay.SetAlignment(table.AlignLeft, table.AlignCenter, table.AlignCenter, table.AlignCenter, table.AlignCenter, table.AlignCenter)
+		sink.display = tableDisplay
+	} else {
+		sink.display = NewPlainDisplay(os.Stdout, headers...)
+	}
+
+	return sink, nil
+}
+
+func (s *perCoreSink) Write(ctx context.Context, sample Sample) error {
+	// The other subscribers share the sample's usages
+	s.usages = append(s.usages[:0], sample.PerCore...)
+	SortCoreUsages(s.usages, s.sort)
+
+	s.display.Reset()
+	for _, usage := range s.usages[:min(len(s.usages), s.rows)] {
+		s.display.AddRow(
+			s.timeFormat.Format(sample.Time),
+			fmt.Sprint(usage.CoreId),
+			formatCPUs(usage.CPUs),
+			Sprintf(s.color, "<green>%.2f%%</green>", usage.Busy),
+			Sprintf(s.color, "<yellow>%.2f%%</yellow>", usage.Idle),
+			Sprintf(s.color, "<bold><red>%.2f%%</red></bold>", usage.Diff),
+		)
+	}
+
+	if err := s.display.Render(); err != nil {
+		return fmt.Errorf("failed to render: %v", err)
+	}
+
+	return nil
+}
+
+func (s *perCoreSink) Close() error {
+	return nil
+}
@@ -0,0 +1,81 @@
+package rcpu
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateSinks(t *testing.T) {
+	tests := []struct {
+		specs []string
+		valid bool
+	}{
+		{[]string{SinkTable}, true},
+		{[]string{SinkTable, "csv:/var/log/rcpu.csv", "json:/var/log/rcpu.jsonl"}, true},
+		{[]string{"json", "rollup:/var/log/rcpu", "aggregator:http://aggregator:9464", "upstream:grpcs://aggregator:443"}, true},
+		{[]string{"printer"}, false},
+		{[]string{SinkTable, SinkCSV}, false},
+		{[]string{SinkPerCore, SinkJSON}, false},
+		{[]string{"table:/tmp/rcpu"}, false},
+		{[]string{SinkRollup}, false},
+		{[]string{SinkAggregator}, false},
+		{[]string{"upstream:http://aggregator:443"}, false},
+		{[]string{"upstream:grpc://a:443", "upstream:grpc://b:443"}, false},
+		{[]string{SinkRaw, "json:/var/log/rcpu.jsonl", "trace:/var/lib/rcpu/trace", "nfd:/etc/kubernetes/node-feature-discovery/features.d/rcpu"}, true},
+		{[]string{SinkHeatmap, SinkTable}, false},
+		{[]string{"raw:/tmp/rcpu"}, false},
+		{[]string{SinkTrace}, false},
+		{[]string{SinkNFD}, false},
+	}
+
+	for _, test := range tests {
+		if err := ValidateSinks(test.specs); (err == nil) != test.valid {
+			t.Errorf("%v: expected valid %v, got %v", test.specs, test.valid, err)
+		}
+	}
+}
+
+func TestRecordSinkAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rcpu.csv")
+	opts, err := ParseOptions("collector", []string{"-fields", "time,adjusted,derating"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := SinkConfig{Options: opts}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// A restart appends to the file without another header
+	for i := 0; i < 2; i++ {
+		sink, err := NewSink(context.Background(), "csv:"+path, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sample := Sample{Time: start.Add(time.Duration(i) * time.Second), AdjustedCPUUsage: 40 + float64(i)}
+		if err := sink.Write(context.Background(), sample); err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 || lines[0] != "time,adjusted,derating" {
+		t.Fatalf("expected a header and 2 lines, got %q", lines)
+	}
+
+	// An unknown derating is nominal
+	if !strings.HasSuffix(lines[2], ",41.0000,1.0000") {
+		t.Errorf("expected the adjusted usage of the second sample and no derating, got %q", lines[2])
+	}
+}
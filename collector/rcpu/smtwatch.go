@@ -0,0 +1,66 @@
+package rcpu
+
+import (
+	"fmt"
+)
+
+// SMTWatcher notices SMT being switched off or on at runtime, e.g. through
+// /sys/devices/system/cpu/smt/control, which takes the siblings offline or
+// brings them back and leaves the detected topology wrong.
+type SMTWatcher struct {
+	host *Host
+	smt  bool
+}
+
+// NewSMTWatcher returns nil on platforms without the SMT state in sysfs.
+func NewSMTWatcher(h *Host) *SMTWatcher {
+	smt, err := h.SMTEnabled()
+	if err != nil {
+		return nil
+	}
+
+	return &SMTWatcher{host: h, smt: smt}
+}
+
+// Changed reads the SMT state and reports whether it changed since the last
+// read. A failed read keeps the last state.
+func (w *SMTWatcher) Changed() (smt bool, changed bool) {
+	smt, err := w.host.SMTEnabled()
+	if err != nil || smt == w.smt {
+		return w.smt, false
+	}
+
+	w.smt = smt
+	return smt, true
+}
+
+// Redetect reads the topology again after SMT or CPU hotplug changed it. The
+// preflight checks already passed at startup, the machine only gets warnings
+// now, and the CPU subset keeps the CPUs of it which are still online.
+func Redetect(collector Collector, cpuIds []int32) (*Detection, error) {
+	detection, err := collector.Detect(Preflight{})
+	if err != nil {
+		return nil, err
+	}
+
+	if cpuIds == nil {
+		return detection, nil
+	}
+
+	var online []int32
+	for _, cpuId := range cpuIds {
+		if _, ok := detection.CPUToCore[cpuId]; ok {
+			online = append(online, cpuId)
+		}
+	}
+
+	if len(online) == 0 {
+		return nil, fmt.Errorf("none of the CPUs of -cpus is online")
+	}
+
+	if err := detection.Restrict(online); err != nil {
+		return nil, err
+	}
+
+	return detection, nil
+}
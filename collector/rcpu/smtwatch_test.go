@@ -0,0 +1,52 @@
+package rcpu
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"solelab.tech/collector/internal/testutil"
+)
+
+// TestRedetectSMTOff checks SMT switched off, which takes the second thread
+// of every core offline, is noticed and leaves every core with one CPU.
+func TestRedetectSMTOff(t *testing.T) {
+	m := testutil.NewMachine(testutil.DualSocket(2))
+	host := &Host{Proc: m.ProcFS(), Sys: m.SysFS()}
+
+	watcher := NewSMTWatcher(host)
+	if watcher == nil {
+		t.Fatal("expected the SMT state to be readable")
+	}
+	if _, changed := watcher.Changed(); changed {
+		t.Fatal("expected no change before SMT is switched")
+	}
+
+	// The machine numbers the first threads of every core first
+	for cpu := m.NumCPUs() / 2; cpu < m.NumCPUs(); cpu++ {
+		m.SetOnline(cpu, false)
+	}
+	sys := m.SysFS()
+	sys[SysCPUSMTActivePath] = &fstest.MapFile{Data: []byte("0\n")}
+	host.Proc, host.Sys = m.ProcFS(), sys
+
+	if smt, changed := watcher.Changed(); smt || !changed {
+		t.Fatalf("expected SMT to be switched off, got %v, changed %v", smt, changed)
+	}
+
+	detection, err := Redetect(NewProcCollector(host, false), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detection.SMT() || len(detection.CoreToCPUs) != m.NumCPUs()/2 || len(detection.AnomalousCores) != m.NumCPUs()/2 {
+		t.Errorf("expected %d cores of one CPU, got %v", m.NumCPUs()/2, detection.CoreToCPUs)
+	}
+
+	// The subset keeps the siblings which are still online
+	detection, err = Redetect(NewProcCollector(host, false), []int32{0, int32(m.NumCPUs() / 2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(detection.CPUToCore) != 1 {
+		t.Errorf("expected only CPU 0 to be left, got %v", detection.CPUToCore)
+	}
+}
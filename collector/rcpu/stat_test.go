@@ -0,0 +1,75 @@
+package rcpu
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"solelab.tech/collector/internal/testutil"
+)
+
+// newStatReader writes a busy dual socket machine to disk, so the reader
+// seeks a real file like it does on /proc/stat.
+func newStatReader(tb testing.TB, coresPerSocket int) *ProcStatReader {
+	m := testutil.NewMachine(testutil.DualSocket(coresPerSocket))
+	m.Step(time.Hour, func(cpu int) float64 { return float64(cpu%10) / 10 })
+
+	dir := tb.TempDir()
+	procRoot, sysRoot := filepath.Join(dir, "proc"), filepath.Join(dir, "sys")
+	if err := m.WriteDir(procRoot, sysRoot); err != nil {
+		tb.Fatal(err)
+	}
+
+	r, err := NewProcStatReader(NewHost(procRoot, sysRoot))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { r.Close() })
+
+	return r
+}
+
+func TestProcStatReaderAllocs(t *testing.T) {
+	r := newStatReader(t, 64)
+
+	cpuTimes, err := r.ReadInto(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cpuTimes) != 256 {
+		t.Fatalf("expected 256 CPUs, got %d", len(cpuTimes))
+	}
+
+	// Once the slice and the buffer have grown, a read allocates nothing
+	allocs := testing.AllocsPerRun(100, func() {
+		if cpuTimes, err = r.ReadInto(cpuTimes); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocations per read, got %v", allocs)
+	}
+}
+
+func BenchmarkProcStatReader(b *testing.B) {
+	for _, coresPerSocket := range []int{4, 64, 256} {
+		b.Run(fmt.Sprintf("cpus=%d", 4*coresPerSocket), func(b *testing.B) {
+			r := newStatReader(b, coresPerSocket)
+
+			cpuTimes, err := r.ReadInto(nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if cpuTimes, err = r.ReadInto(cpuTimes); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
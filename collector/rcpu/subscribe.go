@@ -0,0 +1,183 @@
+package rcpu
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// DefaultSubscriberBuffer is how many samples a subscriber can fall behind
+// by default, see SubscriberBuffer.
+const DefaultSubscriberBuffer = 16
+
+// ErrFeedClosed is returned by Subscribe once the feed is closed, e.g. after
+// the Sampler's Run returned.
+var ErrFeedClosed = errors.New("sample feed is closed")
+
+// SlowConsumer is what the feed does with a sample for a subscriber whose
+// buffer is full. Publishing never waits on a subscriber, a stuck consumer
+// doesn't hold up the collector loop or the others.
+type SlowConsumer int
+
+const (
+	// DropOldest discards the oldest buffered sample, so the subscriber
+	// catches up on the latest ones, e.g. a display or the exporter
+	DropOldest SlowConsumer = iota
+	// DropNewest discards the new sample, the subscriber gets every sample
+	// up to the gap
+	DropNewest
+	// Disconnect closes the subscriber's channel, for consumers that would
+	// rather notice than skip samples
+	Disconnect
+)
+
+func (c SlowConsumer) String() string {
+	switch c {
+	case DropOldest:
+		return "drop-oldest"
+	case DropNewest:
+		return "drop-newest"
+	case Disconnect:
+		return "disconnect"
+	}
+
+	return "unknown"
+}
+
+type SubscribeOption func(*subscriber)
+
+// SubscriberBuffer buffers up to n samples for the subscriber, at least one,
+// DefaultSubscriberBuffer by default.
+func SubscriberBuffer(n int) SubscribeOption {
+	return func(s *subscriber) {
+		s.buffer = max(1, n)
+	}
+}
+
+// OnSlowConsumer sets what happens once the subscriber's buffer is full,
+// DropOldest by default.
+func OnSlowConsumer(policy SlowConsumer) SubscribeOption {
+	return func(s *subscriber) {
+		s.policy = policy
+	}
+}
+
+type subscriber struct {
+	ch     chan Sample
+	buffer int
+	policy SlowConsumer
+}
+
+// SampleFeed delivers the samples of every tick, with the per-core usages,
+// to any number of consumers, each with a buffer and slow-consumer policy of
+// its own.
+type SampleFeed struct {
+	errors *ErrorLimiter
+
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+	closed      bool
+}
+
+func NewSampleFeed() *SampleFeed {
+	return &SampleFeed{subscribers: make(map[*subscriber]struct{})}
+}
+
+// SetErrorLimiter logs the samples dropped for slow consumers.
+func (f *SampleFeed) SetErrorLimiter(errors *ErrorLimiter) {
+	f.errors = errors
+}
+
+// Subscribe returns a channel receiving the samples published from now on.
+// The channel is closed once ctx is done, the feed is closed, or the
+// subscriber is disconnected for falling behind.
+func (f *SampleFeed) Subscribe(ctx context.Context, opts ...SubscribeOption) (<-chan Sample, error) {
+	sub := &subscriber{buffer: DefaultSubscriberBuffer, policy: DropOldest}
+	for _, opt := range opts {
+		opt(sub)
+	}
+	sub.ch = make(chan Sample, sub.buffer)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil, ErrFeedClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.subscribers[sub] = struct{}{}
+
+	context.AfterFunc(ctx, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		f.remove(sub)
+	})
+
+	return sub.ch, nil
+}
+
+// Subscribers returns how many consumers the feed has, the loop skips
+// assembling samples nobody receives.
+func (f *SampleFeed) Subscribers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.subscribers)
+}
+
+// Publish delivers the sample to every subscriber without blocking. The
+// subscribers share its slices and must not modify them.
+func (f *SampleFeed) Publish(sample *Sample) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for sub := range f.subscribers {
+		select {
+		case sub.ch <- *sample:
+			continue
+		default:
+		}
+
+		switch sub.policy {
+		case DropOldest:
+			// Only Publish sends, under the lock, so a slot is free after this
+			select {
+			case <-sub.ch:
+			default:
+			}
+			sub.ch <- *sample
+		case DropNewest:
+		case Disconnect:
+			f.remove(sub)
+		}
+
+		if f.errors != nil {
+			f.errors.Log(ErrorClassSubscriber, "subscriber fell %d samples behind, %s", sub.buffer, sub.policy)
+		}
+	}
+}
+
+// Close closes the channels of every subscriber, once they received the
+// samples already buffered.
+func (f *SampleFeed) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for sub := range f.subscribers {
+		f.remove(sub)
+	}
+	f.closed = true
+}
+
+// remove closes the channel of the subscriber, unless it is already gone.
+func (f *SampleFeed) remove(sub *subscriber) {
+	if _, ok := f.subscribers[sub]; !ok {
+		return
+	}
+
+	delete(f.subscribers, sub)
+	close(sub.ch)
+}
@@ -0,0 +1,124 @@
+package rcpu
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func publishNodes(f *SampleFeed, nodes ...string) {
+	for _, node := range nodes {
+		f.Publish(&Sample{Node: node})
+	}
+}
+
+func receiveNodes(t *testing.T, samples <-chan Sample, n int) []string {
+	t.Helper()
+
+	var nodes []string
+	for i := 0; i < n; i++ {
+		select {
+		case sample, ok := <-samples:
+			if !ok {
+				t.Fatalf("channel closed after %d samples, expected %d", i, n)
+			}
+			nodes = append(nodes, sample.Node)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out after %d samples, expected %d", i, n)
+		}
+	}
+
+	return nodes
+}
+
+func expectClosed(t *testing.T, samples <-chan Sample) {
+	t.Helper()
+
+	select {
+	case sample, ok := <-samples:
+		if ok {
+			t.Fatalf("expected the channel to be closed, got a sample of %s", sample.Node)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the channel to be closed")
+	}
+}
+
+func TestSampleFeedSlowConsumer(t *testing.T) {
+	ctx := context.Background()
+	f := NewSampleFeed()
+
+	oldest, err := f.Subscribe(ctx, SubscriberBuffer(2), OnSlowConsumer(DropOldest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newest, err := f.Subscribe(ctx, SubscriberBuffer(2), OnSlowConsumer(DropNewest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	disconnect, err := f.Subscribe(ctx, SubscriberBuffer(2), OnSlowConsumer(Disconnect))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := f.Subscribers(); n != 3 {
+		t.Fatalf("expected 3 subscribers, got %d", n)
+	}
+
+	publishNodes(f, "a", "b", "c")
+
+	if got := receiveNodes(t, oldest, 2); got[0] != "b" || got[1] != "c" {
+		t.Errorf("expected drop-oldest to keep b and c, got %v", got)
+	}
+	if got := receiveNodes(t, newest, 2); got[0] != "a" || got[1] != "b" {
+		t.Errorf("expected drop-newest to keep a and b, got %v", got)
+	}
+
+	// The buffered samples are still delivered before the close
+	if got := receiveNodes(t, disconnect, 2); got[0] != "a" || got[1] != "b" {
+		t.Errorf("expected disconnect to deliver a and b, got %v", got)
+	}
+	expectClosed(t, disconnect)
+
+	if n := f.Subscribers(); n != 2 {
+		t.Errorf("expected 2 subscribers after the disconnect, got %d", n)
+	}
+
+	// The others keep receiving
+	publishNodes(f, "d")
+	if got := receiveNodes(t, oldest, 1); got[0] != "d" {
+		t.Errorf("expected d, got %v", got)
+	}
+}
+
+func TestSampleFeedUnsubscribe(t *testing.T) {
+	f := NewSampleFeed()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	samples, err := f.Subscribe(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := f.Subscribe(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	expectClosed(t, samples)
+
+	publishNodes(f, "a")
+	if got := receiveNodes(t, other, 1); got[0] != "a" {
+		t.Errorf("expected a, got %v", got)
+	}
+
+	f.Close()
+	expectClosed(t, other)
+
+	if _, err := f.Subscribe(context.Background()); !errors.Is(err, ErrFeedClosed) {
+		t.Errorf("expected ErrFeedClosed, got %v", err)
+	}
+
+	// Publishing to a closed feed is a no-op
+	publishNodes(f, "b")
+}
@@ -0,0 +1,130 @@
+package rcpu
+
+import (
+	"time"
+)
+
+// MinInterval is the USER_HZ tick, /proc/stat counts nothing in shorter
+// intervals.
+const MinInterval = clockTick
+
+// AlignedTicker ticks on multiples of the interval on the wall clock, e.g.
+// every full second. The ticks are aligned once and then scheduled from that
+// base on the monotonic clock rather than from the previous tick, so a late
+// tick doesn't shift the ones after it and NTP slewing the wall clock can't
+// fire the same tick twice. Like time.Ticker, ticks are dropped for slow
+// receivers.
+type AlignedTicker struct {
+	C     <-chan time.Time
+	stop  chan struct{}
+	reset chan time.Duration
+}
+
+func NextAlignedTick(now time.Time, interval time.Duration) time.Time {
+	return now.Truncate(interval).Add(interval)
+}
+
+// alignedBase is the next aligned tick after now, keeping now's monotonic
+// clock reading, which Truncate strips.
+func alignedBase(now time.Time, interval time.Duration) time.Time {
+	return now.Add(NextAlignedTick(now, interval).Sub(now))
+}
+
+// alignedSchedule numbers the ticks from a base on the monotonic clock.
+type alignedSchedule struct {
+	base     time.Time
+	interval time.Duration
+	// next is the number of the next tick, the first is the base
+	next int64
+}
+
+func newAlignedSchedule(now time.Time, interval time.Duration) *alignedSchedule {
+	return &alignedSchedule{base: alignedBase(now, interval), interval: interval}
+}
+
+// after returns the first tick after now and moves past it. Ticks missed by a
+// slow receiver are skipped, and a tick never comes twice.
+func (s *alignedSchedule) after(now time.Time) time.Time {
+	if elapsed := now.Sub(s.base); elapsed >= 0 {
+		s.next = max(s.next, int64(elapsed/s.interval)+1)
+	}
+
+	tick := s.base.Add(time.Duration(s.next) * s.interval)
+	s.next++
+
+	return tick
+}
+
+func NewAlignedTicker(interval time.Duration) *AlignedTicker {
+	c := make(chan time.Time, 1)
+	t := &AlignedTicker{
+		C:     c,
+		stop:  make(chan struct{}),
+		reset: make(chan time.Duration),
+	}
+
+	go func() {
+		schedule := newAlignedSchedule(time.Now(), interval)
+		timer := time.NewTimer(time.Until(schedule.after(time.Now())))
+		defer timer.Stop()
+
+		for {
+			select {
+			case now := <-timer.C:
+				select {
+				case c <- now:
+				default:
+				}
+
+				timer.Reset(time.Until(schedule.after(time.Now())))
+			case interval = <-t.reset:
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+
+				schedule = newAlignedSchedule(time.Now(), interval)
+				timer.Reset(time.Until(schedule.after(time.Now())))
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+
+	return t
+}
+
+// Reset changes the interval, starting with the next aligned tick.
+func (t *AlignedTicker) Reset(interval time.Duration) {
+	t.reset <- interval
+}
+
+func (t *AlignedTicker) Stop() {
+	close(t.stop)
+}
+
+func (t *AlignedTicker) Ticks() <-chan time.Time {
+	return t.C
+}
+
+// Ticker paces the collector loop, see Clock.
+type Ticker interface {
+	Ticks() <-chan time.Time
+	// Reset changes the interval, for -adaptive
+	Reset(interval time.Duration)
+	Stop()
+}
+
+// Clock makes the ticker of the collector loop, an AlignedTicker unless an
+// embedder or a test drives the ticks itself, see WithClock.
+type Clock interface {
+	NewTicker(interval time.Duration) Ticker
+}
+
+type alignedClock struct{}
+
+func (alignedClock) NewTicker(interval time.Duration) Ticker {
+	return NewAlignedTicker(interval)
+}
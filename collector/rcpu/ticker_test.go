@@ -0,0 +1,32 @@
+package rcpu
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAlignedScheduleNeverRepeats(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 300*int(time.Millisecond), time.UTC)
+	s := newAlignedSchedule(start, time.Second)
+
+	first := s.after(start)
+	if want := time.Date(2024, 1, 1, 0, 0, 1, 0, time.UTC); !first.Equal(want) {
+		t.Fatalf("expected the first tick at %v, got %v", want, first)
+	}
+
+	// A clock stepping back to the tick that just fired, or before it
+	prev := first
+	for _, now := range []time.Time{first, first.Add(-500 * time.Millisecond), first.Add(time.Millisecond)} {
+		tick := s.after(now)
+		if !tick.After(prev) {
+			t.Fatalf("tick %v at %v doesn't follow %v", tick, now, prev)
+		}
+		prev = tick
+	}
+
+	// A slow receiver skips the missed ticks
+	now := prev.Add(3500 * time.Millisecond)
+	if tick, want := s.after(now), prev.Add(4*time.Second); !tick.Equal(want) {
+		t.Errorf("expected %v after a stall, got %v", want, tick)
+	}
+}
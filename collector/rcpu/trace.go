@@ -0,0 +1,396 @@
+package rcpu
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/protobuf/encoding/protodelim"
+
+	rcpuv1 "solelab.tech/collector/proto/rcpu/v1"
+)
+
+const (
+	TraceCompressionNone = "none"
+	TraceCompressionZstd = "zstd"
+
+	// A chunk is the unit a replay seeks by and the most a crash loses, it
+	// is written once it holds DefaultTraceChunkSize bytes of records or
+	// spans DefaultTraceChunkPeriod
+	DefaultTraceChunkSize   = 1024 * 1024
+	DefaultTraceChunkPeriod = time.Minute
+
+	traceMagic = "RCPUTRC1"
+	// first and last record time in Unix nanoseconds, number of records,
+	// payload length and compression
+	traceChunkHeaderSize = 8 + 8 + 4 + 4 + 1
+
+	traceChunkNone byte = 0
+	traceChunkZstd byte = 1
+)
+
+// TraceRecord is a sample with the counters of every CPU it was computed
+// from, so a replay can compute it again under another model. It is written
+// as the rcpu.v1.TraceRecord message.
+type TraceRecord struct {
+	Sample   *Sample   `json:"sample"`
+	CPUTimes []CPUTime `json:"cpu_times"`
+}
+
+type traceChunkHeader struct {
+	first   time.Time
+	last    time.Time
+	records uint32
+	length  uint32
+	codec   byte
+}
+
+func (h *traceChunkHeader) marshal() []byte {
+	b := make([]byte, traceChunkHeaderSize)
+	binary.BigEndian.PutUint64(b[0:], uint64(h.first.UnixNano()))
+	binary.BigEndian.PutUint64(b[8:], uint64(h.last.UnixNano()))
+	binary.BigEndian.PutUint32(b[16:], h.records)
+	binary.BigEndian.PutUint32(b[20:], h.length)
+	b[24] = h.codec
+
+	return b
+}
+
+func unmarshalTraceChunkHeader(b []byte) traceChunkHeader {
+	return traceChunkHeader{
+		first:   time.Unix(0, int64(binary.BigEndian.Uint64(b[0:]))),
+		last:    time.Unix(0, int64(binary.BigEndian.Uint64(b[8:]))),
+		records: binary.BigEndian.Uint32(b[16:]),
+		length:  binary.BigEndian.Uint32(b[20:]),
+		codec:   b[24],
+	}
+}
+
+// TraceWriter records the samples of the collector loop to a trace file.
+//
+// The file starts with a magic string followed by chunks, each a header with
+// the time span of its records and a payload of length delimited
+// rcpu.v1.TraceRecord messages, compressed on its own with zstd if enabled.
+// A replay skips the chunks before the time it starts at by their headers,
+// without decompressing them, and a crash only loses the chunk being filled.
+type TraceWriter struct {
+	f     *os.File
+	codec byte
+	enc   *zstd.Encoder
+
+	buf     bytes.Buffer
+	header  traceChunkHeader
+	encoded []byte
+}
+
+// NewTraceWriter creates the trace file, overwriting an existing one.
+func NewTraceWriter(path, compression string) (*TraceWriter, error) {
+	t := &TraceWriter{}
+	switch compression {
+	case TraceCompressionNone:
+		t.codec = traceChunkNone
+	case TraceCompressionZstd:
+		t.codec = traceChunkZstd
+
+		var err error
+		if t.enc, err = zstd.NewWriter(nil); err != nil {
+			return nil, fmt.Errorf("failed to create zstd encoder: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("invalid trace compression %q, expected %s or %s", compression, TraceCompressionNone, TraceCompressionZstd)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace %s: %v", path, err)
+	}
+
+	if _, err := f.WriteString(traceMagic); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write trace %s: %v", path, err)
+	}
+	t.f = f
+
+	return t, nil
+}
+
+func (t *TraceWriter) Write(record *TraceRecord) error {
+	if _, err := protodelim.MarshalTo(&t.buf, TraceRecordToProto(record)); err != nil {
+		return fmt.Errorf("failed to encode trace record: %v", err)
+	}
+
+	if t.header.records == 0 {
+		t.header.first = record.Sample.Time
+	}
+	t.header.last = record.Sample.Time
+	t.header.records++
+
+	if t.buf.Len() >= DefaultTraceChunkSize || t.header.last.Sub(t.header.first) >= DefaultTraceChunkPeriod {
+		return t.Flush()
+	}
+
+	return nil
+}
+
+// Flush writes the records so far as a chunk.
+func (t *TraceWriter) Flush() error {
+	if t.header.records == 0 {
+		return nil
+	}
+
+	payload := t.buf.Bytes()
+	if t.enc != nil {
+		t.encoded = t.enc.EncodeAll(payload, t.encoded[:0])
+		payload = t.encoded
+	}
+
+	t.header.length = uint32(len(payload))
+	t.header.codec = t.codec
+	if _, err := t.f.Write(append(t.header.marshal(), payload...)); err != nil {
+		return fmt.Errorf("failed to write trace %s: %v", t.f.Name(), err)
+	}
+
+	t.buf.Reset()
+	t.header = traceChunkHeader{}
+
+	return nil
+}
+
+func (t *TraceWriter) Close() error {
+	err := t.Flush()
+	if t.enc != nil {
+		t.enc.Close()
+	}
+
+	if closeErr := t.f.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// traceSink records the samples with their counters into the trace file at
+// its path, compressed with -trace-compression.
+type traceSink struct {
+	trace *TraceWriter
+}
+
+func newTraceSink(ctx context.Context, path string, config SinkConfig) (Sink, error) {
+	trace, err := NewTraceWriter(path, config.Options.TraceCodec)
+	if err != nil {
+		return nil, err
+	}
+
+	return &traceSink{trace: trace}, nil
+}
+
+func (s *traceSink) Write(ctx context.Context, sample Sample) error {
+	return s.trace.Write(&TraceRecord{Sample: &sample, CPUTimes: sample.CPUTimes})
+}
+
+func (s *traceSink) Close() error {
+	return s.trace.Close()
+}
+
+// TraceReader reads the records of a trace file in order.
+type TraceReader struct {
+	r   io.ReadSeeker
+	dec *zstd.Decoder
+
+	// next is the header of the chunk the reader is at, nil at the end
+	next    *traceChunkHeader
+	records *bufio.Reader
+	since   time.Time
+}
+
+func NewTraceReader(r io.ReadSeeker) (*TraceReader, error) {
+	magic := make([]byte, len(traceMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != traceMagic {
+		return nil, fmt.Errorf("not a trace file")
+	}
+
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd decoder: %v", err)
+	}
+
+	t := &TraceReader{r: r, dec: dec}
+	if err := t.readHeader(); err != nil {
+		dec.Close()
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (t *TraceReader) Close() {
+	t.dec.Close()
+}
+
+func (t *TraceReader) readHeader() error {
+	b := make([]byte, traceChunkHeaderSize)
+	if _, err := io.ReadFull(t.r, b); err != nil {
+		if err == io.EOF {
+			t.next = nil
+			return nil
+		}
+
+		return fmt.Errorf("failed to read trace chunk: %w", err)
+	}
+
+	header := unmarshalTraceChunkHeader(b)
+	t.next = &header
+
+	return nil
+}
+
+// Start is the time of the first record, zero for an empty trace.
+func (t *TraceReader) Start() time.Time {
+	if t.next == nil {
+		return time.Time{}
+	}
+
+	return t.next.first
+}
+
+// Seek moves ahead to the first record at or after since. Only the chunk it
+// falls in is decompressed.
+func (t *TraceReader) Seek(since time.Time) error {
+	t.since = since
+	for t.records == nil && t.next != nil && t.next.last.Before(since) {
+		if _, err := t.r.Seek(int64(t.next.length), io.SeekCurrent); err != nil {
+			return fmt.Errorf("failed to skip trace chunk: %v", err)
+		}
+
+		if err := t.readHeader(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *TraceReader) readChunk() error {
+	payload := make([]byte, t.next.length)
+	if _, err := io.ReadFull(t.r, payload); err != nil {
+		return fmt.Errorf("failed to read trace chunk: %w", err)
+	}
+
+	switch t.next.codec {
+	case traceChunkNone:
+	case traceChunkZstd:
+		var err error
+		if payload, err = t.dec.DecodeAll(payload, nil); err != nil {
+			return fmt.Errorf("failed to decompress trace chunk: %v", err)
+		}
+	default:
+		return fmt.Errorf("unknown trace chunk compression %d", t.next.codec)
+	}
+
+	t.records = bufio.NewReader(bytes.NewReader(payload))
+
+	return t.readHeader()
+}
+
+// Next returns the next record, or io.EOF at the end of the trace. A chunk
+// cut short by a crash fails with io.ErrUnexpectedEOF.
+func (t *TraceReader) Next() (*TraceRecord, error) {
+	for {
+		if t.records == nil {
+			if t.next == nil {
+				return nil, io.EOF
+			}
+
+			if err := t.readChunk(); err != nil {
+				return nil, err
+			}
+		}
+
+		var pb rcpuv1.TraceRecord
+		if err := (protodelim.UnmarshalOptions{MaxSize: -1}).UnmarshalFrom(t.records, &pb); err == io.EOF {
+			t.records = nil
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("malformed trace record: %v", err)
+		}
+
+		record := TraceRecordFromProto(&pb)
+		if record.Sample == nil || record.Sample.Time.Before(t.since) {
+			continue
+		}
+
+		return record, nil
+	}
+}
+
+// RunReplay prints the samples of a trace as JSON lines, starting at an
+// offset into it.
+func RunReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	path := fs.String("trace", "", "trace file written by -trace-file")
+	offset := fs.Duration("offset", 0, "start this long after the first sample")
+	duration := fs.Duration("duration", 0, "stop after this long, 0 replays to the end")
+	cpuTimes := fs.Bool("cpu-times", false, "print the whole records with the counters of every CPU instead of the samples")
+	fs.Parse(args)
+
+	if *path == "" {
+		return fmt.Errorf("-trace is required")
+	}
+
+	f, err := os.Open(*path)
+	if err != nil {
+		return fmt.Errorf("failed to open trace: %v", err)
+	}
+	defer f.Close()
+
+	trace, err := NewTraceReader(f)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", *path, err)
+	}
+	defer trace.Close()
+
+	since := trace.Start().Add(*offset)
+	if err := trace.Seek(since); err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	enc := json.NewEncoder(w)
+	for {
+		record, err := trace.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			log.Printf("The last chunk of %s is truncated, the collector didn't stop cleanly\n", *path)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if *duration > 0 && !record.Sample.Time.Before(since.Add(*duration)) {
+			return nil
+		}
+
+		var v interface{} = record.Sample
+		if *cpuTimes {
+			v = record
+		}
+		if err := enc.Encode(v); err != nil {
+			return fmt.Errorf("failed to write sample: %v", err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package rcpu
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestTrace(t *testing.T, path, compression string, start time.Time, n int) {
+	t.Helper()
+
+	w, err := NewTraceWriter(path, compression)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < n; i++ {
+		now := start.Add(time.Duration(i) * 10 * time.Second)
+		record := &TraceRecord{
+			Sample:   &Sample{Node: "node-1", Time: now, AdjustedCPUUsage: float64(i)},
+			CPUTimes: []CPUTime{{CPUId: 0, CollectTime: now, User: uint64(i)}, {CPUId: 1, CollectTime: now, Idle: uint64(i)}},
+		}
+		if err := w.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTraceSeek(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, compression := range []string{TraceCompressionNone, TraceCompressionZstd} {
+		t.Run(compression, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "trace")
+			// 10 minutes of samples, about one chunk a minute
+			writeTestTrace(t, path, compression, start, 60)
+
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			trace, err := NewTraceReader(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer trace.Close()
+
+			if !trace.Start().Equal(start) {
+				t.Fatalf("expected the trace to start at %v, got %v", start, trace.Start())
+			}
+
+			if err := trace.Seek(start.Add(5*time.Minute + 5*time.Second)); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []float64
+			for {
+				record, err := trace.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if len(record.CPUTimes) != 2 || record.CPUTimes[1].Idle != uint64(record.Sample.AdjustedCPUUsage) {
+					t.Fatalf("expected the counters of both CPUs, got %+v", record.CPUTimes)
+				}
+				got = append(got, record.Sample.AdjustedCPUUsage)
+			}
+
+			if len(got) != 29 || got[0] != 31 || got[len(got)-1] != 59 {
+				t.Errorf("expected samples 31 to 59, got %v", got)
+			}
+		})
+	}
+}
+
+func TestTraceTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace")
+	writeTestTrace(t, path, TraceCompressionZstd, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 20)
+
+	out, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trace, err := NewTraceReader(bytes.NewReader(out[:len(out)-10]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer trace.Close()
+
+	var n int
+	for {
+		_, err := trace.Next()
+		if err == nil {
+			n++
+			continue
+		}
+
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("expected the truncated chunk to fail with an unexpected EOF, got %v", err)
+		}
+		break
+	}
+
+	// Chunks span a minute, 7 samples, only the last one is lost
+	if n != 14 {
+		t.Errorf("expected the 14 samples of the complete chunks, got %d", n)
+	}
+}
@@ -0,0 +1,415 @@
+package rcpu
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protodelim"
+
+	rcpuv1 "solelab.tech/collector/proto/rcpu/v1"
+)
+
+const (
+	DefaultUpstreamBufferDir = "/var/lib/rcpu/upstream"
+	DefaultUpstreamBufferMax = 64 * 1024 * 1024
+
+	// A segment is pushed as one batch and deleted once the aggregator took
+	// it
+	upstreamSegmentSamples = 600
+	upstreamSegmentSuffix  = ".samples"
+)
+
+// SampleBuffer queues the samples of -upstream on disk until the aggregator
+// took them, so they survive both a network partition and a restart. It is a
+// directory of numbered segments of length delimited rcpu.v1.Sample messages,
+// the newest one being appended to. Past its size limit the oldest segments
+// are dropped.
+type SampleBuffer struct {
+	dir string
+	max int64
+
+	mu   sync.Mutex
+	next uint64
+	// f is the segment being appended to, nil until the next sample
+	f       *os.File
+	samples int
+}
+
+func OpenSampleBuffer(dir string, max int64) (*SampleBuffer, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create sample buffer %s: %v", dir, err)
+	}
+
+	b := &SampleBuffer{dir: dir, max: max}
+	segments, err := b.segments()
+	if err != nil {
+		return nil, err
+	}
+
+	// Resume after the segments left by the previous run
+	if len(segments) > 0 {
+		last := strings.TrimSuffix(filepath.Base(segments[len(segments)-1]), upstreamSegmentSuffix)
+		n, _ := strconv.ParseUint(last, 10, 64)
+		b.next = n + 1
+	}
+
+	return b, nil
+}
+
+// segments lists the segments, oldest first
+func (b *SampleBuffer) segments() ([]string, error) {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sample buffer %s: %v", b.dir, err)
+	}
+
+	var segments []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if _, err := strconv.ParseUint(strings.TrimSuffix(name, upstreamSegmentSuffix), 10, 64); err == nil && strings.HasSuffix(name, upstreamSegmentSuffix) {
+			segments = append(segments, filepath.Join(b.dir, name))
+		}
+	}
+	sort.Strings(segments)
+
+	return segments, nil
+}
+
+// seal closes the segment being appended to, the next sample starts another
+func (b *SampleBuffer) seal() error {
+	if b.f == nil {
+		return nil
+	}
+
+	err := b.f.Close()
+	b.f, b.samples = nil, 0
+
+	return err
+}
+
+func (b *SampleBuffer) Append(sample *Sample) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.f == nil {
+		f, err := os.OpenFile(filepath.Join(b.dir, fmt.Sprintf("%020d%s", b.next, upstreamSegmentSuffix)), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to create buffer segment: %v", err)
+		}
+		b.f = f
+		b.next++
+	}
+
+	if _, err := protodelim.MarshalTo(b.f, SampleToProto(sample)); err != nil {
+		return fmt.Errorf("failed to buffer sample: %v", err)
+	}
+
+	b.samples++
+	if b.samples >= upstreamSegmentSamples {
+		if err := b.seal(); err != nil {
+			return err
+		}
+	}
+
+	return b.trim()
+}
+
+// trim drops the oldest segments past the size limit
+func (b *SampleBuffer) trim() error {
+	segments, err := b.segments()
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	sizes := make([]int64, len(segments))
+	for i, segment := range segments {
+		if info, err := os.Stat(segment); err == nil {
+			sizes[i] = info.Size()
+			size += sizes[i]
+		}
+	}
+
+	for i := 0; size > b.max && i < len(segments)-1; i++ {
+		if err := os.Remove(segments[i]); err != nil {
+			return fmt.Errorf("failed to drop buffer segment: %v", err)
+		}
+		size -= sizes[i]
+
+		log.Printf("Dropped %s, the aggregator was unreachable for longer than the buffer holds\n", filepath.Base(segments[i]))
+	}
+
+	return nil
+}
+
+// Oldest returns the oldest segment and its samples, sealing the one being
+// appended to if it is the only one. The path is empty when the buffer is
+// empty. A segment cut short by a crash returns the samples before the cut.
+func (b *SampleBuffer) Oldest() (string, []*Sample, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	segments, err := b.segments()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(segments) == 1 && b.f != nil {
+		if err := b.seal(); err != nil {
+			return "", nil, err
+		}
+	}
+
+	if len(segments) == 0 {
+		return "", nil, nil
+	}
+
+	f, err := os.Open(segments[0])
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to open buffer segment: %v", err)
+	}
+	defer f.Close()
+
+	var samples []*Sample
+	r := bufio.NewReader(f)
+	for {
+		var pb rcpuv1.Sample
+		if err := protodelim.UnmarshalFrom(r, &pb); err == io.EOF {
+			break
+		} else if err != nil {
+			log.Printf("Buffer segment %s is truncated after %d samples: %v\n", filepath.Base(segments[0]), len(samples), err)
+			break
+		}
+
+		samples = append(samples, SampleFromProto(&pb))
+	}
+
+	return segments[0], samples, nil
+}
+
+// Remove deletes a segment the aggregator took.
+func (b *SampleBuffer) Remove(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove buffer segment: %v", err)
+	}
+
+	return nil
+}
+
+func (b *SampleBuffer) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.seal()
+}
+
+// ParseUpstream splits grpcs://host:port, or grpc://host:port without TLS,
+// into the gRPC target and whether it uses TLS.
+func ParseUpstream(upstream string) (string, bool, error) {
+	if target, ok := strings.CutPrefix(upstream, "grpcs://"); ok && target != "" {
+		return target, true, nil
+	}
+
+	if target, ok := strings.CutPrefix(upstream, "grpc://"); ok && target != "" {
+		return target, false, nil
+	}
+
+	return "", false, fmt.Errorf("invalid upstream %q, expected grpcs://host:port or grpc://host:port", upstream)
+}
+
+// UpstreamPusher pushes the samples to the aggregator over gRPC, through the
+// disk buffer. Unlike SamplePusher nothing is lost while the aggregator is
+// unreachable, up to the size of the buffer, and the segments left by a
+// restart are pushed once the collector is back.
+type UpstreamPusher struct {
+	conn   *grpc.ClientConn
+	client rcpuv1.SampleServiceClient
+	token  string
+	buffer *SampleBuffer
+	ready  chan struct{}
+}
+
+// NewUpstreamPusher pushes to the aggregator at upstream, see ParseUpstream,
+// with the bearer token unless it is empty, and the TLS configuration of a
+// grpcs upstream unless it is nil.
+func NewUpstreamPusher(upstream, token, bufferDir string, tlsConfig *tls.Config) (*UpstreamPusher, error) {
+	target, secure, err := ParseUpstream(upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := insecure.NewCredentials()
+	if secure {
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", upstream, err)
+	}
+
+	buffer, err := OpenSampleBuffer(bufferDir, DefaultUpstreamBufferMax)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	p := &UpstreamPusher{
+		conn:   conn,
+		client: rcpuv1.NewSampleServiceClient(conn),
+		token:  token,
+		buffer: buffer,
+		ready:  make(chan struct{}, 1),
+	}
+	// Push what the previous run left behind
+	p.ready <- struct{}{}
+
+	return p, nil
+}
+
+// Push buffers the sample without blocking the collector loop on the network.
+func (p *UpstreamPusher) Push(sample *Sample) error {
+	if err := p.buffer.Append(sample); err != nil {
+		return err
+	}
+
+	select {
+	case p.ready <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+func (p *UpstreamPusher) send(ctx context.Context, samples []*Sample) error {
+	batch := &rcpuv1.SampleBatch{Samples: make([]*rcpuv1.Sample, 0, len(samples))}
+	for _, sample := range samples {
+		batch.Samples = append(batch.Samples, SampleToProto(sample))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, DefaultPushTimeout)
+	defer cancel()
+
+	if p.token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+p.token)
+	}
+
+	if _, err := p.client.Push(ctx, batch); err != nil {
+		return fmt.Errorf("failed to push samples: %v", err)
+	}
+
+	return nil
+}
+
+// Run pushes the buffered segments, oldest first, until ctx is done, backing
+// off exponentially while the aggregator is unreachable.
+func (p *UpstreamPusher) Run(ctx context.Context, errors *ErrorLimiter) {
+	backoff := DefaultPushMinBackoff
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.ready:
+		}
+
+		for ctx.Err() == nil {
+			path, samples, err := p.buffer.Oldest()
+			if err != nil {
+				errors.Log(ErrorClassPush, "%v", err)
+				break
+			}
+
+			if path == "" {
+				break
+			}
+
+			if len(samples) > 0 {
+				if err := p.send(ctx, samples); err != nil {
+					errors.Log(ErrorClassPush, "%v, retrying in %v", err, backoff)
+
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(backoff):
+					}
+					backoff = min(2*backoff, DefaultPushMaxBackoff)
+					continue
+				}
+				backoff = DefaultPushMinBackoff
+			}
+
+			if err := p.buffer.Remove(path); err != nil {
+				errors.Log(ErrorClassPush, "%v", err)
+				break
+			}
+		}
+	}
+}
+
+// Close keeps the buffered samples on disk for the next run.
+func (p *UpstreamPusher) Close() error {
+	err := p.buffer.Close()
+	if closeErr := p.conn.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// upstreamSink pushes the samples to the aggregator over gRPC, buffering
+// them in -upstream-buffer.
+type upstreamSink struct {
+	pusher *UpstreamPusher
+	errors *ErrorLimiter
+	done   chan struct{}
+}
+
+func newUpstreamSink(ctx context.Context, upstream string, config SinkConfig) (Sink, error) {
+	opts := config.Options
+
+	pusher, err := NewUpstreamPusher(upstream, opts.AggregatorToken, opts.UpstreamBuffer, opts.AggregatorTLS)
+	if err != nil {
+		return nil, err
+	}
+
+	sink := &upstreamSink{pusher: pusher, errors: config.Errors, done: make(chan struct{})}
+	go func() {
+		defer close(sink.done)
+		pusher.Run(ctx, config.Errors)
+	}()
+
+	return sink, nil
+}
+
+// Write logs the samples the buffer couldn't take, the collector goes on.
+func (s *upstreamSink) Write(ctx context.Context, sample Sample) error {
+	if err := s.pusher.Push(&sample); err != nil {
+		s.errors.Log(ErrorClassPush, "%v", err)
+	}
+
+	return nil
+}
+
+func (s *upstreamSink) Close() error {
+	<-s.done
+	return s.pusher.Close()
+}
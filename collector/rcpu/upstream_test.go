@@ -0,0 +1,128 @@
+package rcpu
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	rcpuv1 "solelab.tech/collector/proto/rcpu/v1"
+)
+
+func TestSampleBufferResume(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	b, err := OpenSampleBuffer(dir, DefaultUpstreamBufferMax)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := b.Append(&Sample{Node: "node-1", Time: start.Add(time.Duration(i) * time.Second)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restart appends after the segment left behind
+	b, err = OpenSampleBuffer(dir, DefaultUpstreamBufferMax)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if err := b.Append(&Sample{Node: "node-1", Time: start.Add(3 * time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []time.Time
+	for {
+		path, samples, err := b.Oldest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path == "" {
+			break
+		}
+
+		for _, sample := range samples {
+			got = append(got, sample.Time)
+		}
+		if err := b.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(got) != 4 || !got[0].Equal(start) || !got[3].Equal(start.Add(3*time.Second)) {
+		t.Errorf("expected the 4 samples in order, got %v", got)
+	}
+}
+
+func TestUpstreamPusherPartition(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	aggregator := NewAggregator(time.Hour)
+	aggregator.SetPeers(Peers{"secret": "cluster-1"})
+
+	pusher, err := NewUpstreamPusher("grpc://"+addr, "secret", t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pusher.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pusher.Run(ctx, NewErrorLimiter(DefaultErrorLogInterval))
+
+	// Pushed while the aggregator is down
+	now := time.Now()
+	if err := pusher.Push(&Sample{Node: "node-1", Time: now, CPUs: 4, Cores: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if len(aggregator.Samples(time.Now())) != 0 {
+		t.Fatalf("expected nothing to arrive while the aggregator is down")
+	}
+
+	lis, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	rcpuv1.RegisterSampleServiceServer(server, &sampleService{aggregator: aggregator})
+	go server.Serve(lis)
+	defer server.Stop()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if samples := aggregator.Samples(time.Now()); len(samples) == 1 {
+			if samples[0].Cluster != "cluster-1" || !samples[0].Time.Equal(now) {
+				t.Errorf("expected the buffered sample of cluster-1, got %+v", samples[0])
+			}
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	t.Fatalf("the buffered sample never arrived")
+}
+
+func TestUpstreamUnauthenticated(t *testing.T) {
+	aggregator := NewAggregator(time.Hour)
+	aggregator.SetPeers(Peers{"secret": "cluster-1"})
+
+	_, err := (&sampleService{aggregator: aggregator}).Push(context.Background(), &rcpuv1.SampleBatch{})
+	if err == nil {
+		t.Errorf("expected a push without a token to be rejected")
+	}
+}
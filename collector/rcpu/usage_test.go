@@ -0,0 +1,191 @@
+package rcpu
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"solelab.tech/collector/cputime"
+	"solelab.tech/collector/internal/testutil"
+)
+
+// readCPUTimes parses the machine's current /proc/stat.
+func readCPUTimes(t *testing.T, m *testutil.Machine, now time.Time) []CPUTime {
+	t.Helper()
+
+	cpuTimes, err := parseCPUTimes(nil, m.Stat(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cpuTimes
+}
+
+// stepPeriods advances the machine by a second and returns the periods of
+// the step, indexed by CPU ID.
+func stepPeriods(t *testing.T, m *testutil.Machine, busy func(cpu int) float64) ([]CPUTimePeriod, error) {
+	t.Helper()
+
+	start := time.Now()
+	prev := readCPUTimes(t, m, start)
+	m.Step(time.Second, busy)
+	cur := readCPUTimes(t, m, start.Add(time.Second))
+
+	periods := make([]CPUTimePeriod, m.NumCPUs())
+	return periods, computePeriods(periods, prev, cur, 1)
+}
+
+func boolUsage(busy bool) float64 {
+	if busy {
+		return 1
+	}
+
+	return 0
+}
+
+func TestDualSocketSMT(t *testing.T) {
+	// 4 cores per socket, CPUs 0-7 are the first threads of cores 0-7, CPUs
+	// 8-15 their siblings, cores 0-3 are on socket 0
+	tests := []struct {
+		name         string
+		busy         func(cpu int) float64
+		avg          float64
+		adjusted     float64
+		socketUsages [2]float64
+		freeCores    int
+	}{
+		{
+			name:         "idle",
+			busy:         func(cpu int) float64 { return 0 },
+			avg:          0,
+			adjusted:     0,
+			socketUsages: [2]float64{0, 0},
+			freeCores:    8,
+		},
+		{
+			name:         "one thread per core of socket 0",
+			busy:         func(cpu int) float64 { return boolUsage(cpu < 4) },
+			avg:          25,
+			adjusted:     50,
+			socketUsages: [2]float64{100, 0},
+			freeCores:    4,
+		},
+		{
+			name:         "both threads of every core of socket 0",
+			busy:         func(cpu int) float64 { return boolUsage(cpu%8 < 4) },
+			avg:          50,
+			adjusted:     50,
+			socketUsages: [2]float64{100, 0},
+			freeCores:    4,
+		},
+		{
+			name:         "one thread of every core at half",
+			busy:         func(cpu int) float64 { return boolUsage(cpu < 8) / 2 },
+			avg:          25,
+			adjusted:     50,
+			socketUsages: [2]float64{50, 50},
+		},
+		{
+			name:         "saturated",
+			busy:         func(cpu int) float64 { return 1 },
+			avg:          100,
+			adjusted:     100,
+			socketUsages: [2]float64{100, 100},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := testutil.NewMachine(testutil.DualSocket(4))
+			m.Step(time.Hour, func(cpu int) float64 { return 0.3 })
+
+			detection, err := Detect(&Host{Proc: m.ProcFS(), Sys: m.SysFS()}, false, DefaultPreflight())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(detection.CPUInfos) != 16 || len(detection.CoreToCPUs) != 8 {
+				t.Fatalf("expected 16 CPUs and 8 cores, got %d and %d", len(detection.CPUInfos), len(detection.CoreToCPUs))
+			}
+
+			periods, err := stepPeriods(t, m, test.busy)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			avg, err := cputime.AverageCPUUsage(periods)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cores := NewCoreList(detection.CoreToCPUs)
+			adjusted, err := cputime.AdjustedCPUUsage(cores, periods)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !almostEqual(avg, test.avg) || !almostEqual(adjusted, test.adjusted) {
+				t.Errorf("expected avg %.2f%% and adjusted %.2f%%, got %.2f%% and %.2f%%", test.avg, test.adjusted, avg, adjusted)
+			}
+
+			if free := FreeCores(cores, periods, DefaultFreeCoreBusy); free != test.freeCores {
+				t.Errorf("expected %d free cores, got %d", test.freeCores, free)
+			}
+
+			sockets := NewCoreGroups(detection.CPUInfos, detection.CoreToCPUs, SocketOf)
+			socketUsages, err := DoGroupAdjustedCPUUsage(MaxSiblingModel{}, sockets, periods)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(socketUsages) != 2 {
+				t.Fatalf("expected 2 sockets, got %d", len(socketUsages))
+			}
+
+			for i, usage := range socketUsages {
+				if !almostEqual(usage.AdjustedCPUUsage, test.socketUsages[i]) {
+					t.Errorf("expected socket %d at %.2f%%, got %.2f%%", i, test.socketUsages[i], usage.AdjustedCPUUsage)
+				}
+			}
+		})
+	}
+}
+
+func TestCounterWrap(t *testing.T) {
+	m := testutil.NewMachine(testutil.DualSocket(2))
+
+	// Guest time is subtracted from user time, so eight counters add up to
+	// the total, which wraps during the next step
+	m.SetCounters(3, math.MaxUint64/8-10)
+
+	half := func(cpu int) float64 { return 0.5 }
+	if _, err := stepPeriods(t, m, half); !errors.Is(err, ErrCounterReset) {
+		t.Fatalf("expected %v, got %v", ErrCounterReset, err)
+	}
+
+	// Like the collector loop, measure from the new counters on
+	periods, err := stepPeriods(t, m, half)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	avg, err := cputime.AverageCPUUsage(periods)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	detection, err := Detect(&Host{Proc: m.ProcFS(), Sys: m.SysFS()}, false, DefaultPreflight())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adjusted, err := cputime.AdjustedCPUUsage(NewCoreList(detection.CoreToCPUs), periods)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !almostEqual(avg, 50) || !almostEqual(adjusted, 50) {
+		t.Errorf("expected avg and adjusted at 50%%, got %.2f%% and %.2f%%", avg, adjusted)
+	}
+}
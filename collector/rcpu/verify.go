@@ -0,0 +1,212 @@
+package rcpu
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"solelab.tech/collector/cputime"
+)
+
+const (
+	VerifyAgainstMpstat = "mpstat"
+
+	DefaultVerifyCount   = 10
+	DefaultVerifyMaxDiff = 1.0
+)
+
+type mpstatCPULoad struct {
+	CPU    string  `json:"cpu"`
+	IOWait float64 `json:"iowait"`
+	Idle   float64 `json:"idle"`
+}
+
+// mpstatReport is the part of mpstat -o JSON the verification needs.
+type mpstatReport struct {
+	Sysstat struct {
+		Hosts []struct {
+			Statistics []struct {
+				CPULoad []mpstatCPULoad `json:"cpu-load"`
+			} `json:"statistics"`
+		} `json:"hosts"`
+	} `json:"sysstat"`
+}
+
+// ParseMpstatJSON returns the busy percent of every interval reported by
+// mpstat -o JSON. mpstat splits iowait off idle, it counts as idle here like
+// it does for top and cputime.AverageCPUUsage.
+func ParseMpstatJSON(r io.Reader) ([]float64, error) {
+	var report mpstatReport
+	if err := json.NewDecoder(r).Decode(&report); err != nil {
+		return nil, fmt.Errorf("failed to parse mpstat output: %v", err)
+	}
+
+	if len(report.Sysstat.Hosts) == 0 {
+		return nil, fmt.Errorf("no hosts in mpstat output")
+	}
+
+	var busy []float64
+	for _, statistics := range report.Sysstat.Hosts[0].Statistics {
+		for _, load := range statistics.CPULoad {
+			if load.CPU == "all" {
+				busy = append(busy, 100.0-load.Idle-load.IOWait)
+			}
+		}
+	}
+
+	return busy, nil
+}
+
+// sampleAverageCPUUsage computes cputime.AverageCPUUsage for count intervals, the
+// first read taken right away and the others on a fixed schedule from it,
+// like mpstat does.
+func sampleAverageCPUUsage(host *Host, interval time.Duration, count int) ([]float64, error) {
+	statReader, err := NewProcStatReader(host)
+	if err != nil {
+		return nil, err
+	}
+	defer statReader.Close()
+
+	start := time.Now()
+	prevCPUTimes, err := statReader.ReadInto(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var maxCPUId int32
+	for i := range prevCPUTimes {
+		maxCPUId = max(maxCPUId, prevCPUTimes[i].CPUId)
+	}
+	periods := make([]CPUTimePeriod, maxCPUId+1)
+
+	var spareCPUTimes []CPUTime
+	usages := make([]float64, 0, count)
+	for i := 1; i <= count; i++ {
+		time.Sleep(time.Until(start.Add(time.Duration(i) * interval)))
+
+		cpuTimes, err := statReader.ReadInto(spareCPUTimes)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := computePeriods(periods, prevCPUTimes, cpuTimes, 1); err != nil {
+			return nil, err
+		}
+
+		usage, err := cputime.AverageCPUUsage(periods)
+		if err != nil {
+			return nil, err
+		}
+		usages = append(usages, usage)
+
+		prevCPUTimes, spareCPUTimes = cpuTimes, prevCPUTimes
+	}
+
+	return usages, nil
+}
+
+// WriteVerify reports the usage of both sides for every interval and how
+// many intervals differ by more than maxDiff percentage points.
+func WriteVerify(w io.Writer, against string, theirs, ours []float64, maxDiff float64) int {
+	fmt.Fprintf(w, "%-8s  %9s  %9s  %7s\n", "interval", against, "collector", "diff")
+
+	failed := 0
+	var sumDiff, maxAbsDiff float64
+	for i := range ours {
+		diff := ours[i] - theirs[i]
+		sumDiff += math.Abs(diff)
+		maxAbsDiff = max(maxAbsDiff, math.Abs(diff))
+
+		mark := ""
+		if math.Abs(diff) > maxDiff {
+			mark = "  FAIL"
+			failed++
+		}
+
+		fmt.Fprintf(w, "%-8d  %8.2f%%  %8.2f%%  %+7.2f%s\n", i+1, theirs[i], ours[i], diff, mark)
+	}
+
+	if len(ours) > 0 {
+		fmt.Fprintf(w, "mean absolute difference %.2f, max %.2f percentage points\n", sumDiff/float64(len(ours)), maxAbsDiff)
+	}
+
+	return failed
+}
+
+// RunVerify runs mpstat next to the collector's own average computation over
+// the same intervals, showing cputime.AverageCPUUsage agrees with the common tools
+// before the adjusted usage, which has nothing to compare with, is trusted.
+func RunVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	against := fs.String("against", VerifyAgainstMpstat, "tool to compare the average CPU usage with, only mpstat")
+	interval := fs.Duration("interval", time.Second, "length of an interval, in whole seconds as mpstat takes it")
+	count := fs.Int("count", DefaultVerifyCount, "number of intervals to compare")
+	maxDiff := fs.Float64("max-diff", DefaultVerifyMaxDiff, "fail if an interval differs by more percentage points")
+	fs.Parse(args)
+
+	if *against != VerifyAgainstMpstat {
+		return fmt.Errorf("unknown tool %q, only %s is supported", *against, VerifyAgainstMpstat)
+	}
+
+	if *interval < time.Second || *interval%time.Second != 0 {
+		return fmt.Errorf("invalid interval %v, must be whole seconds", *interval)
+	}
+
+	if *count <= 0 {
+		return fmt.Errorf("invalid count %d", *count)
+	}
+
+	executable, err := exec.LookPath("mpstat")
+	if err != nil {
+		return fmt.Errorf("failed to find mpstat: %v", err)
+	}
+
+	// mpstat always reads the real /proc
+	host := NewHost(ProcRootDir, SysRootDir)
+
+	// The first interval also sees mpstat starting up while only one side
+	// measures it, it is sampled but left out of the comparison
+	intervals := *count + 1
+
+	var out bytes.Buffer
+	cmd := exec.Command(executable, "-o", "JSON", strconv.Itoa(int(*interval/time.Second)), strconv.Itoa(intervals))
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to run mpstat: %v", err)
+	}
+
+	ours, sampleErr := sampleAverageCPUUsage(host, *interval, intervals)
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("failed to run mpstat: %v", err)
+	}
+
+	if sampleErr != nil {
+		return fmt.Errorf("failed to sample CPU times: %v", sampleErr)
+	}
+
+	theirs, err := ParseMpstatJSON(&out)
+	if err != nil {
+		return err
+	}
+
+	if len(theirs) != len(ours) {
+		return fmt.Errorf("mpstat reported %d intervals, expected %d", len(theirs), len(ours))
+	}
+	theirs, ours = theirs[1:], ours[1:]
+
+	if failed := WriteVerify(os.Stdout, *against, theirs, ours, *maxDiff); failed > 0 {
+		return fmt.Errorf("%d of %d intervals differ by more than %.2f percentage points", failed, len(ours), *maxDiff)
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package rcpu
+
+import (
+	"io/fs"
+	"strings"
+
+	"solelab.tech/collector/internal/parse"
+)
+
+// Environments the collector tells apart. EnvironmentVM is a hypervisor
+// none of the others match.
+const (
+	EnvironmentBareMetal = "bare-metal"
+	EnvironmentKVM       = "kvm"
+	EnvironmentVMware    = "vmware"
+	EnvironmentXen       = "xen"
+	EnvironmentHyperV    = "hyperv"
+	EnvironmentAWS       = "aws"
+	EnvironmentGCP       = "gcp"
+	EnvironmentAzure     = "azure"
+	EnvironmentVM        = "vm"
+)
+
+// DefaultStealWarning is the steal time in percent of the CPU time above
+// which a guest logs that the hypervisor is overcommitted.
+const DefaultStealWarning = 10.0
+
+const (
+	SysDMIDir             = "class/dmi/id"
+	SysHypervisorTypePath = "hypervisor/type"
+
+	// azureAssetTag is the chassis asset tag of every Azure VM, which
+	// otherwise look like any Hyper-V guest
+	azureAssetTag = "7783-7084-3265-9085-8269-3286-77"
+)
+
+// dmiVendors maps substrings of the DMI vendor and product names to the
+// environment, clouds first as they run on one of the hypervisors below.
+var dmiVendors = []struct {
+	substr      string
+	environment string
+}{
+	{"Amazon EC2", EnvironmentAWS},
+	{"Google", EnvironmentGCP},
+	{"VMware", EnvironmentVMware},
+	{"QEMU", EnvironmentKVM},
+	{"KVM", EnvironmentKVM},
+	{"Xen", EnvironmentXen},
+	{"Microsoft Corporation", EnvironmentHyperV},
+}
+
+func IsVirtualized(environment string) bool {
+	return environment != EnvironmentBareMetal
+}
+
+func (h *Host) readDMI(name string) string {
+	out, err := fs.ReadFile(h.Sys, SysDMIDir+"/"+name)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+// Environment tells whether the host is bare metal or a guest, and of which
+// hypervisor or cloud. The CPUID hypervisor bit, as the hypervisor flag of
+// /proc/cpuinfo, decides the former, so bare metal cloud instances count as
+// bare metal. DMI and /sys/hypervisor name the hypervisor.
+func (h *Host) Environment() string {
+	f, err := h.Proc.Open(ProcCPUInfoName)
+	if err != nil {
+		return EnvironmentBareMetal
+	}
+	flags, err := parse.CPUInfoFlags(f)
+	f.Close()
+
+	virtualized := false
+	for _, flag := range flags {
+		if flag == "hypervisor" {
+			virtualized = true
+		}
+	}
+
+	// Xen PV guests may hide the flag but always have /sys/hypervisor
+	if out, err := fs.ReadFile(h.Sys, SysHypervisorTypePath); err == nil && strings.TrimSpace(string(out)) == "xen" {
+		virtualized = true
+	}
+
+	if !virtualized {
+		return EnvironmentBareMetal
+	}
+
+	if h.readDMI("chassis_asset_tag") == azureAssetTag {
+		return EnvironmentAzure
+	}
+
+	dmi := strings.Join([]string{h.readDMI("sys_vendor"), h.readDMI("product_name"), h.readDMI("bios_vendor")}, " ")
+	for _, vendor := range dmiVendors {
+		if strings.Contains(dmi, vendor.substr) {
+			return vendor.environment
+		}
+	}
+
+	if out, err := fs.ReadFile(h.Sys, SysHypervisorTypePath); err == nil && strings.TrimSpace(string(out)) == "xen" {
+		return EnvironmentXen
+	}
+
+	return EnvironmentVM
+}
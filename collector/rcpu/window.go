@@ -0,0 +1,93 @@
+package rcpu
+
+import (
+	"math"
+)
+
+// DefaultWindow is the number of samples RCPU is summarized over
+const DefaultWindow = 30
+
+// tQuantiles holds the two-sided 95% quantiles of Student's t distribution by
+// degrees of freedom, beyond them the normal quantile is close enough
+var tQuantiles = []float64{
+	0, 12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262,
+	2.228, 2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093,
+	2.086, 2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045,
+	2.042,
+}
+
+const zQuantile = 1.960
+
+// WindowStats summarizes RCPU over the recent samples, in percent. StdDev
+// tells a steady node from one oscillating around the same mean, Low and High
+// bound the mean with 95% confidence. Consecutive samples are correlated, so
+// the bounds are narrower than they should be on slowly changing load.
+type WindowStats struct {
+	Samples int     `json:"samples"`
+	Mean    float64 `json:"mean"`
+	StdDev  float64 `json:"stddev"`
+	Low     float64 `json:"low"`
+	High    float64 `json:"high"`
+}
+
+// RCPUWindow keeps the RCPU of the last samples in a ring.
+type RCPUWindow struct {
+	values []float64
+	next   int
+	full   bool
+}
+
+func NewRCPUWindow(size int) *RCPUWindow {
+	return &RCPUWindow{values: make([]float64, size)}
+}
+
+func (w *RCPUWindow) Add(rcpu float64) {
+	w.values[w.next] = rcpu
+	w.next++
+	if w.next == len(w.values) {
+		w.next = 0
+		w.full = true
+	}
+}
+
+func (w *RCPUWindow) Stats() WindowStats {
+	values := w.values[:w.next]
+	if w.full {
+		values = w.values
+	}
+
+	n := len(values)
+	if n == 0 {
+		return WindowStats{}
+	}
+
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(n)
+
+	if n == 1 {
+		return WindowStats{Samples: 1, Mean: mean, Low: mean, High: mean}
+	}
+
+	var squares float64
+	for _, v := range values {
+		squares += (v - mean) * (v - mean)
+	}
+	stdDev := math.Sqrt(squares / float64(n-1))
+
+	quantile := zQuantile
+	if n-1 < len(tQuantiles) {
+		quantile = tQuantiles[n-1]
+	}
+	margin := quantile * stdDev / math.Sqrt(float64(n))
+
+	return WindowStats{
+		Samples: n,
+		Mean:    mean,
+		StdDev:  stdDev,
+		Low:     max(0, mean-margin),
+		High:    min(100, mean+margin),
+	}
+}
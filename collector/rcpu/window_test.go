@@ -0,0 +1,56 @@
+package rcpu
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRCPUWindowStats(t *testing.T) {
+	alternating := make([]float64, 32)
+	for i := range alternating {
+		alternating[i] = 45 + 10*float64(i%2)
+	}
+
+	tests := []struct {
+		name   string
+		size   int
+		values []float64
+		want   WindowStats
+	}{
+		{"empty", 4, nil, WindowStats{}},
+		{"single sample", 4, []float64{40}, WindowStats{Samples: 1, Mean: 40, Low: 40, High: 40}},
+		// sqrt(8/3) and 3.182 * sqrt(8/3) / 2 around the mean
+		{"t quantile", 4, []float64{48, 50, 52, 50}, WindowStats{Samples: 4, Mean: 50, StdDev: 1.63299, Low: 47.40191, High: 52.59809}},
+		// The margin of 12.706 * 14.142 / sqrt(2) is clamped to the range
+		{"clamped", 4, []float64{40, 60}, WindowStats{Samples: 2, Mean: 50, StdDev: 14.14214, Low: 0, High: 100}},
+		// Past 31 degrees of freedom, 1.96 * sqrt(800/31) / sqrt(32)
+		{"normal quantile", 32, alternating, WindowStats{Samples: 32, Mean: 50, StdDev: 5.08001, Low: 48.23987, High: 51.76013}},
+		// 10 is overwritten, leaving 40, 20 and 30, 4.303 * 10 / sqrt(3)
+		{"ring", 3, []float64{10, 20, 30, 40}, WindowStats{Samples: 3, Mean: 30, StdDev: 10, Low: 5.15662, High: 54.84338}},
+	}
+
+	for _, test := range tests {
+		window := NewRCPUWindow(test.size)
+		for _, v := range test.values {
+			window.Add(v)
+		}
+
+		got := window.Stats()
+		if got.Samples != test.want.Samples {
+			t.Errorf("%s: expected %d samples, got %d", test.name, test.want.Samples, got.Samples)
+		}
+		for _, field := range []struct {
+			name      string
+			got, want float64
+		}{
+			{"mean", got.Mean, test.want.Mean},
+			{"stddev", got.StdDev, test.want.StdDev},
+			{"low", got.Low, test.want.Low},
+			{"high", got.High, test.want.High},
+		} {
+			if math.Abs(field.got-field.want) > 1e-5 {
+				t.Errorf("%s: expected the %s at %.5f, got %.5f", test.name, field.name, field.want, field.got)
+			}
+		}
+	}
+}
@@ -0,0 +1,672 @@
+package rcpu
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultAnnotateSource is the collector's -metrics-listen on the same node
+	DefaultAnnotateSource   = "http://localhost:9465/v1/samples"
+	DefaultAnnotateInterval = 5 * time.Second
+	DefaultAnnotateTimeout  = 5 * time.Second
+)
+
+// metricWindows are the windows the annotated metrics are averaged over.
+var metricWindows = []struct {
+	key string
+	// avgKey is the plain average utilization over the window
+	avgKey string
+	window time.Duration
+}{
+	{RCPUMetric1mKey, RCPUAvg1mKey, time.Minute},
+	{RCPUMetric5mKey, RCPUAvg5mKey, 5 * time.Minute},
+	{RCPUMetric15mKey, RCPUAvg15mKey, 15 * time.Minute},
+}
+
+// avgMetricKey returns the plain average utilization over the window of the
+// metric, e.g. rcpu-scheduler/avg_15min for RCPUMetric15mKey.
+func avgMetricKey(metric string) string {
+	for _, w := range metricWindows {
+		if w.key == metric {
+			return w.avgKey
+		}
+	}
+
+	return ""
+}
+
+func isAvgMetric(key string) bool {
+	for _, w := range metricWindows {
+		if key == w.avgKey {
+			return true
+		}
+	}
+
+	return false
+}
+
+// groupMetricKey names the metric of a window for a group of the node's CPUs,
+// e.g. rcpu-scheduler/rcpu_node1_15min for NUMA node 1.
+func groupMetricKey(key, group string, id int32) string {
+	window := strings.TrimPrefix(key, RCPUAnnotationPrefix+"rcpu_")
+	return fmt.Sprintf("%srcpu_%s%d_%s", RCPUAnnotationPrefix, group, id, window)
+}
+
+var groupMetricKeyPattern = regexp.MustCompile(`^rcpu-scheduler/rcpu_(node|socket)([0-9]+)_([0-9]+min)$`)
+
+// NUMANodeMetricKey is the annotation of the metric for a NUMA node, e.g.
+// NUMANodeMetricKey(RCPUMetric15mKey, 1) is rcpu-scheduler/rcpu_node1_15min.
+func NUMANodeMetricKey(key string, id int32) string {
+	return groupMetricKey(key, "node", id)
+}
+
+// SocketMetricKey is the annotation of the metric for a socket, e.g.
+// rcpu-scheduler/rcpu_socket1_15min.
+func SocketMetricKey(key string, id int32) string {
+	return groupMetricKey(key, "socket", id)
+}
+
+// metricGroups are the groups of a node's CPUs with metrics of their own.
+var metricGroups = []struct {
+	key    func(key string, id int32) string
+	groups func(p *usagePoint) []SourceGroup
+}{
+	{NUMANodeMetricKey, func(p *usagePoint) []SourceGroup { return p.numaNodes }},
+	{SocketMetricKey, func(p *usagePoint) []SourceGroup { return p.sockets }},
+}
+
+// SourceSample holds the fields of the collector's and the aggregator's
+// samples the annotator needs.
+type SourceSample struct {
+	Node             string    `json:"node"`
+	Time             time.Time `json:"time"`
+	AdjustedCPUUsage float64   `json:"adjusted_cpu_usage"`
+	AvgCPUUsage      float64   `json:"avg_cpu_usage"`
+	// Cores is the number of physical cores the usage is relative to
+	Cores int `json:"cores,omitempty"`
+	// FreeCores is missing from the samples of older collectors
+	FreeCores *int `json:"free_cores,omitempty"`
+	// Interval is in nanoseconds, like time.Duration
+	Interval time.Duration `json:"interval,omitempty"`
+	// NUMANodes and Sockets are the usages of the NUMA nodes and the
+	// sockets of the node
+	NUMANodes []SourceGroup `json:"nodes,omitempty"`
+	Sockets   []SourceGroup `json:"sockets,omitempty"`
+	// Pods and Containers are only attributed by collectors run with
+	// -pod-resources-socket and -cri-endpoint, see PodMetricsStore
+	Pods       []SourcePod       `json:"pods,omitempty"`
+	Containers []SourceContainer `json:"containers,omitempty"`
+}
+
+// SourceGroup is the adjusted usage of a group of the node's CPUs.
+type SourceGroup struct {
+	ID               int32   `json:"id"`
+	AdjustedCPUUsage float64 `json:"adjusted_cpu_usage"`
+}
+
+// SourcePod is the usage of a pod with pinned CPUs, in cores.
+type SourcePod struct {
+	Namespace     string  `json:"namespace"`
+	Name          string  `json:"name"`
+	BusyCores     float64 `json:"busy_cores"`
+	AdjustedCores float64 `json:"adjusted_cores"`
+}
+
+// SourceContainer is the usage of a container, in logical CPUs for
+// BusyCores and in physical cores for AdjustedCores.
+type SourceContainer struct {
+	Namespace     string  `json:"namespace"`
+	Pod           string  `json:"pod"`
+	BusyCores     float64 `json:"busy_cores"`
+	AdjustedCores float64 `json:"adjusted_cores"`
+}
+
+type usagePoint struct {
+	time  time.Time
+	usage float64
+	avg   float64
+	// freeCores is -1 when unknown
+	freeCores int
+	numaNodes []SourceGroup
+	sockets   []SourceGroup
+}
+
+// UsageSeries keeps the adjusted usage of a node over the longest window.
+type UsageSeries struct {
+	points []usagePoint
+	// cores is of the latest sample, 0 when unknown
+	cores int
+}
+
+// Add appends the sample unless it is not newer than the latest one, and
+// reports whether it was added.
+func (s *UsageSeries) Add(sample *SourceSample) bool {
+	t := sample.Time
+	if n := len(s.points); n > 0 && !t.After(s.points[n-1].time) {
+		return false
+	}
+
+	freeCores := -1
+	if sample.FreeCores != nil {
+		freeCores = max(*sample.FreeCores, 0)
+	}
+	s.points = append(s.points, usagePoint{time: t, usage: sample.AdjustedCPUUsage, avg: sample.AvgCPUUsage, freeCores: freeCores, numaNodes: sample.NUMANodes, sockets: sample.Sockets})
+	s.cores = sample.Cores
+
+	// Forget what no window covers anymore
+	longest := metricWindows[len(metricWindows)-1].window
+	i := 0
+	for i < len(s.points) && t.Sub(s.points[i].time) > longest {
+		i++
+	}
+	s.points = s.points[i:]
+
+	return true
+}
+
+func perMille(usage float64) string {
+	return strconv.FormatInt(int64(math.Round(usage*float64(RCPUMaxScore)/100)), 10)
+}
+
+// groupMeans averages the usage of the groups over the points, a group only
+// over the points which have it.
+func groupMeans(points []usagePoint, groups func(p *usagePoint) []SourceGroup) map[int32]float64 {
+	sums := make(map[int32]float64)
+	counts := make(map[int32]int)
+	for i := range points {
+		for _, g := range groups(&points[i]) {
+			sums[g.ID] += g.AdjustedCPUUsage
+			counts[g.ID]++
+		}
+	}
+
+	for id := range sums {
+		sums[id] /= float64(counts[id])
+	}
+
+	return sums
+}
+
+// Annotations returns the mean usage over every window in the annotations'
+// per-mille scale, and the mean plain average utilization. A window shorter than its duration is averaged over the
+// samples it has, so a restarted agent reports right away. The free cores are
+// the fewest of the shortest window, a core idle for a moment isn't free.
+//
+// The NUMA nodes and the sockets of machines with more than one get their own
+// metrics, of those in the latest sample.
+func (s *UsageSeries) Annotations() map[string]string {
+	annotations := make(map[string]string, 2*len(metricWindows)+1)
+	if len(s.points) == 0 {
+		return annotations
+	}
+
+	last := &s.points[len(s.points)-1]
+	latest := last.time
+	for _, w := range metricWindows {
+		first := len(s.points) - 1
+		for first > 0 && latest.Sub(s.points[first-1].time) <= w.window {
+			first--
+		}
+		points := s.points[first:]
+
+		var sum, avgSum float64
+		for _, p := range points {
+			sum += p.usage
+			avgSum += p.avg
+		}
+		annotations[w.key] = perMille(sum / float64(len(points)))
+		annotations[w.avgKey] = perMille(avgSum / float64(len(points)))
+
+		for _, mg := range metricGroups {
+			if groups := mg.groups(last); len(groups) > 1 {
+				means := groupMeans(points, mg.groups)
+				for _, g := range groups {
+					annotations[mg.key(w.key, g.ID)] = perMille(means[g.ID])
+				}
+			}
+		}
+	}
+
+	freeCores := -1
+	for i := len(s.points) - 1; i >= 0 && latest.Sub(s.points[i].time) <= metricWindows[0].window; i-- {
+		if s.points[i].freeCores < 0 {
+			// Published only once every sample of the window has it
+			freeCores = -1
+			break
+		}
+
+		if freeCores < 0 || s.points[i].freeCores < freeCores {
+			freeCores = s.points[i].freeCores
+		}
+	}
+	if freeCores >= 0 {
+		annotations[RCPUFreeCoresKey] = strconv.Itoa(freeCores)
+	}
+
+	return annotations
+}
+
+// FetchSamples reads the samples served at url, presenting the bearer token
+// unless it is empty.
+func FetchSamples(ctx context.Context, client *http.Client, url, token string) ([]SourceSample, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch samples: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch samples: %s", resp.Status)
+	}
+
+	var body json.RawMessage
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<20)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("malformed samples: %v", err)
+	}
+
+	// /v1/samples wraps the samples, the unversioned /samples of older
+	// collectors and aggregators serves them bare
+	var list struct {
+		Samples []SourceSample `json:"samples"`
+	}
+	if len(body) > 0 && body[0] == '[' {
+		err = json.Unmarshal(body, &list.Samples)
+	} else {
+		err = json.Unmarshal(body, &list)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("malformed samples: %v", err)
+	}
+
+	return list.Samples, nil
+}
+
+// AnnotateConfig configures the annotators created by RunAnnotate.
+type AnnotateConfig struct {
+	Source   string
+	Interval time.Duration
+	// SourceToken and SourceTLS authenticate to a source run with a token or
+	// a client CA, SourceTLS also trusting its CA
+	SourceToken string
+	SourceTLS   *tls.Config
+	// NodeName restricts the annotator to a single node, which then takes
+	// every sample of the source as its own
+	NodeName string
+
+	FieldManager string
+	SigningKey   []byte
+	Buckets      *HeadroomBuckets
+	Policy       UpdatePolicy
+	// Schema is the format of the annotations, see RCPUSchemaKey
+	Schema string
+	// Payload packs the annotations into RCPUPayloadKey, with the v1 schema
+	Payload bool
+
+	Events         bool
+	EventThreshold int64
+
+	// LivenessLeaseNamespace enables a LivenessLease per node in this
+	// namespace, renewed as long as new samples arrive
+	LivenessLeaseNamespace string
+	LivenessLeaseDuration  time.Duration
+}
+
+type annotateLoop struct {
+	cfg        AnnotateConfig
+	client     clientset.Interface
+	httpClient *http.Client
+
+	// recorder is shared by the annotators of every node
+	recorder record.EventRecorder
+
+	series     map[string]*UsageSeries
+	annotators map[string]*Annotator
+
+	// leases is nil without liveness leases, held by holder
+	leases map[string]*LivenessLease
+	holder string
+}
+
+func (l *annotateLoop) annotator(nodeName string) *Annotator {
+	if a, ok := l.annotators[nodeName]; ok {
+		return a
+	}
+
+	a := NewAnnotator(l.client, nodeName)
+	a.SetUpdatePolicy(l.cfg.Policy)
+	if l.cfg.FieldManager != "" {
+		a.SetFieldManager(l.cfg.FieldManager)
+	}
+	if l.cfg.SigningKey != nil {
+		a.SetSigningKey(l.cfg.SigningKey)
+	}
+	if l.cfg.Buckets != nil {
+		a.SetHeadroomBuckets(*l.cfg.Buckets)
+	}
+	if l.recorder != nil {
+		a.SetEventRecorder(l.recorder, DefaultRCPUMetric, l.cfg.EventThreshold)
+	}
+
+	l.annotators[nodeName] = a
+	return a
+}
+
+func (l *annotateLoop) lease(nodeName string) *LivenessLease {
+	if lease, ok := l.leases[nodeName]; ok {
+		return lease
+	}
+
+	lease := NewLivenessLease(l.client, l.cfg.LivenessLeaseNamespace, nodeName, l.holder, l.cfg.LivenessLeaseDuration)
+	if l.cfg.FieldManager != "" {
+		lease.SetFieldManager(l.cfg.FieldManager)
+	}
+
+	l.leases[nodeName] = lease
+	return lease
+}
+
+func (l *annotateLoop) poll(ctx context.Context) {
+	fetchCtx, cancel := context.WithTimeout(ctx, DefaultAnnotateTimeout)
+	samples, err := FetchSamples(fetchCtx, l.httpClient, l.cfg.Source, l.cfg.SourceToken)
+	cancel()
+	if err != nil {
+		klog.ErrorS(err, "Failed to poll samples", "source", l.cfg.Source)
+		return
+	}
+
+	for _, sample := range samples {
+		nodeName := sample.Node
+		if l.cfg.NodeName != "" {
+			nodeName = l.cfg.NodeName
+		}
+
+		series, ok := l.series[nodeName]
+		if !ok {
+			series = &UsageSeries{}
+			l.series[nodeName] = series
+		}
+
+		// Only a new sample refreshes the annotations, so a dead agent's
+		// annotations age instead of being re-signed as fresh
+		if !series.Add(&sample) {
+			continue
+		}
+
+		// The lease follows the samples, not the update policy, a quiet node
+		// isn't annotated for a while but its collector is alive
+		if l.leases != nil {
+			if _, err := l.lease(nodeName).Renew(ctx, time.Now()); err != nil {
+				klog.ErrorS(err, "Failed to renew the liveness lease", "node", nodeName)
+			}
+		}
+
+		annotations := encodeSchema(series.Annotations(), l.cfg.Schema, series.cores)
+		if l.cfg.Payload {
+			var err error
+			if annotations, err = packPayload(annotations, series); err != nil {
+				klog.ErrorS(err, "Failed to pack the payload", "node", nodeName)
+				continue
+			}
+		}
+
+		if _, err := l.annotator(nodeName).Update(ctx, annotations); err != nil {
+			klog.ErrorS(err, "Failed to annotate node", "node", nodeName)
+		}
+	}
+}
+
+// Annotate polls the source every interval and publishes the samples as
+// annotations until ctx is done.
+func Annotate(ctx context.Context, client clientset.Interface, cfg AnnotateConfig) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.SourceTLS != nil {
+		transport.TLSClientConfig = cfg.SourceTLS
+	}
+
+	l := &annotateLoop{
+		cfg:        cfg,
+		client:     client,
+		httpClient: &http.Client{Transport: transport},
+		series:     make(map[string]*UsageSeries),
+		annotators: make(map[string]*Annotator),
+	}
+
+	if cfg.Events {
+		l.recorder = NewEventRecorder(client)
+	}
+
+	if cfg.LivenessLeaseNamespace != "" {
+		l.leases = make(map[string]*LivenessLease)
+		// The pod name, or whatever names the annotator outside of a pod
+		l.holder, _ = os.Hostname()
+		if l.holder == "" {
+			l.holder = DefaultFieldManager
+		}
+	}
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		l.poll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SourceTLSConfig trusts the PEM CAs in caFile besides the system's, and
+// presents the client certificate in certFile, each unless it is empty.
+func SourceTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	if caFile == "" && certFile == "" {
+		return nil, nil
+	}
+
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("a client certificate requires its key and the other way around")
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA %s: %v", caFile, err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificate in CA %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate %s: %v", certFile, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// LoadSourceToken reads the bearer token presented to the source, none if
+// path is empty.
+func LoadSourceToken(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+
+	out, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read source token %s: %v", path, err)
+	}
+
+	token := strings.TrimSpace(string(out))
+	if token == "" {
+		return "", fmt.Errorf("source token %s is empty", path)
+	}
+
+	return token, nil
+}
+
+// NewClient builds a clientset from the kubeconfig, or from the in-cluster
+// configuration if it is empty.
+func NewClient(kubeconfig string) (clientset.Interface, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the client configuration: %v", err)
+	}
+
+	client, err := clientset.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the client: %v", err)
+	}
+
+	return client, nil
+}
+
+// RunAnnotate runs the annotate command. By default it annotates the node
+// named by NODE_NAME from the collector on the same node, with -all-nodes it
+// annotates every node reported by the source, e.g. the aggregator.
+func RunAnnotate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("annotate", flag.ExitOnError)
+	cfg := AnnotateConfig{Policy: DefaultUpdatePolicy(), Schema: DefaultSchema}
+	fs.StringVar(&cfg.Source, "source", DefaultAnnotateSource, "URL of the samples, served by the collector's -metrics-listen or by the aggregator")
+	fs.DurationVar(&cfg.Interval, "interval", DefaultAnnotateInterval, "how often to poll the source")
+	sourceTokenFile := fs.String("source-token-file", "", "authenticate to the source with the bearer token in this file, see the collector's -metrics-token-file")
+	sourceCAFile := fs.String("source-ca-file", "", "trust the PEM CAs in this file for an https source")
+	sourceCertFile := fs.String("source-cert-file", "", "authenticate to the source with the PEM client certificate in this file, see the collector's -metrics-client-ca-file")
+	sourceKeyFile := fs.String("source-key-file", "", "PEM key of the -source-cert-file certificate")
+	allNodes := fs.Bool("all-nodes", false, "annotate every node of the source instead of the node named by "+NodeNameEnv)
+	kubeconfig := fs.String("kubeconfig", "", "kubeconfig file, the in-cluster configuration is used if empty")
+	fs.StringVar(&cfg.FieldManager, "field-manager", DefaultFieldManager, "field manager of the server-side apply")
+	signingKeyFile := fs.String("signing-key-file", "", "sign the annotations with the key in this file, see the plugin's signingKeyFile")
+	fs.StringVar(&cfg.Schema, "schema", DefaultSchema, "format of the annotations, "+strings.Join(Schemas, " or ")+", v2 with units and the capacity requires a scheduler which reads it")
+	fs.BoolVar(&cfg.Payload, "payload", false, "write the metrics as a single JSON annotation, "+RCPUPayloadKey+", which requires a scheduler which reads it")
+	buckets := fs.String("headroom-buckets", "", "also publish the "+HeadroomLabelKey+" label with these high,medium boundaries, e.g. 600,300")
+	fs.BoolVar(&cfg.Events, "events", false, "emit events on the node when it becomes overloaded and when it recovers")
+	fs.Int64Var(&cfg.EventThreshold, "event-threshold", DefaultRCPUThreshold, "value of "+DefaultRCPUMetric+" the events are emitted at")
+	fs.Int64Var(&cfg.Policy.MinChange, "min-change", DefaultMinChange, "smallest change of a metric that updates the node before -max-interval")
+	fs.DurationVar(&cfg.Policy.MinInterval, "min-interval", DefaultMinInterval, "never update a node more often than this")
+	fs.DurationVar(&cfg.Policy.MaxInterval, "max-interval", DefaultMaxInterval, "update a node at least this often while samples arrive")
+	fs.Float64Var(&cfg.Policy.Jitter, "jitter", DefaultJitter, "spread -max-interval by up to this fraction")
+	fs.StringVar(&cfg.LivenessLeaseNamespace, "liveness-lease-namespace", "", "renew a lease per node in this namespace while its collector reports, see the plugin's livenessLeaseNamespace")
+	fs.DurationVar(&cfg.LivenessLeaseDuration, "liveness-lease-duration", DefaultLivenessLeaseDuration, "how long a liveness lease lasts without new samples")
+	leaderElect := fs.Bool("leader-elect", false, "with -all-nodes, only annotate while holding the lease, so several replicas can run")
+	election := DefaultLeaderElectionConfig()
+	fs.StringVar(&election.LeaseName, "lease-name", DefaultLeaseName, "name of the leader election lease")
+	fs.StringVar(&election.LeaseNamespace, "lease-namespace", DefaultLeaseNamespace, "namespace of the leader election lease")
+	fs.Parse(args)
+
+	if cfg.Interval <= 0 {
+		return fmt.Errorf("invalid interval %v", cfg.Interval)
+	}
+
+	if !supportedSchema(cfg.Schema) {
+		return fmt.Errorf("unknown schema %q, expected %s", cfg.Schema, strings.Join(Schemas, " or "))
+	}
+
+	// The payload names its units already
+	if cfg.Payload && cfg.Schema != SchemaV1 {
+		return fmt.Errorf("-payload requires -schema %s", SchemaV1)
+	}
+
+	if cfg.LivenessLeaseNamespace != "" && cfg.LivenessLeaseDuration < time.Second {
+		return fmt.Errorf("invalid liveness lease duration %v, expected at least 1s", cfg.LivenessLeaseDuration)
+	}
+
+	// Per-node annotators never overlap, there is nothing to elect
+	if *leaderElect && !*allNodes {
+		return fmt.Errorf("-leader-elect requires -all-nodes")
+	}
+
+	if !*allNodes {
+		nodeName, err := NodeNameFromEnv()
+		if err != nil {
+			return err
+		}
+		cfg.NodeName = nodeName
+	}
+
+	sourceToken, err := LoadSourceToken(*sourceTokenFile)
+	if err != nil {
+		return err
+	}
+	cfg.SourceToken = sourceToken
+
+	sourceTLS, err := SourceTLSConfig(*sourceCAFile, *sourceCertFile, *sourceKeyFile)
+	if err != nil {
+		return err
+	}
+	cfg.SourceTLS = sourceTLS
+
+	if *signingKeyFile != "" {
+		key, err := LoadSigningKey(*signingKeyFile)
+		if err != nil {
+			return err
+		}
+		cfg.SigningKey = key
+	}
+
+	if *buckets != "" {
+		hb, err := ParseHeadroomBuckets(DefaultRCPUMetric, *buckets)
+		if err != nil {
+			return err
+		}
+		cfg.Buckets = &hb
+	}
+
+	client, err := NewClient(*kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	klog.InfoS("Annotator is running", "source", cfg.Source, "node", cfg.NodeName)
+
+	if *leaderElect {
+		// A new leader starts over, the windows refill from the source
+		return RunWithLeaderElection(ctx, client, election, func(ctx context.Context) {
+			Annotate(ctx, client, cfg)
+		})
+	}
+
+	Annotate(ctx, client, cfg)
+
+	return nil
+}
@@ -0,0 +1,377 @@
+package rcpu
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	applycorev1 "k8s.io/client-go/applyconfigurations/core/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	DefaultFieldManager = "rcpu-annotator"
+
+	// NodeNameEnv is populated from spec.nodeName through the downward API
+	NodeNameEnv = "NODE_NAME"
+
+	DefaultMinChange   = int64(20) // Skip updates moving every metric by less than 2%
+	DefaultMinInterval = 5 * time.Second
+	DefaultMaxInterval = 60 * time.Second
+	DefaultJitter      = 0.2
+
+	HeadroomLabelKey = "rcpu.solecnugit.io/headroom"
+
+	HeadroomHigh   = "high"
+	HeadroomMedium = "medium"
+	HeadroomLow    = "low"
+)
+
+// HeadroomBuckets maps a metric to a coarse node label, so plain nodeAffinity
+// and nodeSelector users benefit without installing the scheduler plugin.
+type HeadroomBuckets struct {
+	Metric string
+	// Headroom is RCPUMaxScore minus the metric, in the same millicore scale
+	HighAbove   int64
+	MediumAbove int64
+}
+
+func DefaultHeadroomBuckets() HeadroomBuckets {
+	return HeadroomBuckets{
+		Metric:      DefaultRCPUMetric,
+		HighAbove:   int64(0.6 * 1000),
+		MediumAbove: int64(0.3 * 1000),
+	}
+}
+
+// ParseHeadroomBuckets parses "high,medium" boundaries like "600,300".
+func ParseHeadroomBuckets(metric, s string) (HeadroomBuckets, error) {
+	highStr, mediumStr, ok := strings.Cut(s, ",")
+	if !ok {
+		return HeadroomBuckets{}, fmt.Errorf("invalid headroom buckets %q, expected high,medium", s)
+	}
+
+	high, err := parseRCPUValue(SchemaV1, strings.TrimSpace(highStr))
+	if err != nil {
+		return HeadroomBuckets{}, fmt.Errorf("invalid high headroom boundary: %v", err)
+	}
+
+	medium, err := parseRCPUValue(SchemaV1, strings.TrimSpace(mediumStr))
+	if err != nil {
+		return HeadroomBuckets{}, fmt.Errorf("invalid medium headroom boundary: %v", err)
+	}
+
+	if medium > high {
+		return HeadroomBuckets{}, fmt.Errorf("medium headroom boundary %d above high boundary %d", medium, high)
+	}
+
+	return HeadroomBuckets{Metric: metric, HighAbove: high, MediumAbove: medium}, nil
+}
+
+func (hb *HeadroomBuckets) Bucket(annotations map[string]string) (string, bool) {
+	rcpu, ok := getRCPU(annotations, hb.Metric)
+	if !ok {
+		return "", false
+	}
+
+	headroom := RCPUMaxScore - rcpu
+	switch {
+	case headroom >= hb.HighAbove:
+		return HeadroomHigh, true
+	case headroom >= hb.MediumAbove:
+		return HeadroomMedium, true
+	default:
+		return HeadroomLow, true
+	}
+}
+
+// UpdatePolicy bounds how often the annotator patches the node, so thousands
+// of nodes don't hammer the API server every second.
+type UpdatePolicy struct {
+	// MinChange is the smallest change of any RCPU metric, in the
+	// annotation's millicore scale, that triggers an update before
+	// MaxInterval elapses, any change of the free cores does
+	MinChange int64
+	// MinInterval rate limits updates no matter how much the metrics move
+	MinInterval time.Duration
+	// MaxInterval forces a refresh even if nothing changed, so consumers can
+	// tell a quiet node from a dead agent
+	MaxInterval time.Duration
+	// Jitter spreads MaxInterval by up to this fraction to avoid the whole
+	// fleet refreshing in lockstep
+	Jitter float64
+}
+
+func DefaultUpdatePolicy() UpdatePolicy {
+	return UpdatePolicy{
+		MinChange:   DefaultMinChange,
+		MinInterval: DefaultMinInterval,
+		MaxInterval: DefaultMaxInterval,
+		Jitter:      DefaultJitter,
+	}
+}
+
+// Annotator publishes the node agent's metrics as rcpu-scheduler/* annotations
+// on its own node. It writes with server-side apply under a dedicated field
+// manager, so it only ever owns its own annotation keys and never clobbers
+// annotations managed by other controllers.
+//
+// The only API call made is a patch of its own node, so the agent's service
+// account needs nothing beyond the "patch nodes" permission generated by the
+// collector's manifests subcommand.
+type Annotator struct {
+	client       clientset.Interface
+	nodeName     string
+	fieldManager string
+	signingKey   []byte
+	buckets      *HeadroomBuckets
+
+	policy      UpdatePolicy
+	lastApplied map[string]string
+	lastTime    time.Time
+	nextRefresh time.Duration
+
+	recorder        record.EventRecorder
+	eventMetric     string
+	eventThreshold  int64
+	overloadedSince time.Time
+}
+
+func NewAnnotator(client clientset.Interface, nodeName string) *Annotator {
+	return &Annotator{
+		client:       client,
+		nodeName:     nodeName,
+		fieldManager: DefaultFieldManager,
+		policy:       DefaultUpdatePolicy(),
+	}
+}
+
+func (a *Annotator) SetUpdatePolicy(policy UpdatePolicy) {
+	a.policy = policy
+}
+
+// NodeNameFromEnv returns the name of the node the agent runs on.
+func NodeNameFromEnv() (string, error) {
+	nodeName := os.Getenv(NodeNameEnv)
+	if nodeName == "" {
+		return "", fmt.Errorf("%s is not set, expose spec.nodeName through the downward API", NodeNameEnv)
+	}
+
+	return nodeName, nil
+}
+
+// NewAnnotatorFromEnv creates an annotator for the node named by NODE_NAME.
+func NewAnnotatorFromEnv(client clientset.Interface) (*Annotator, error) {
+	nodeName, err := NodeNameFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewAnnotator(client, nodeName), nil
+}
+
+func (a *Annotator) SetFieldManager(fieldManager string) {
+	a.fieldManager = fieldManager
+}
+
+// SetSigningKey makes the annotator sign the metrics, see SignAnnotations.
+func (a *Annotator) SetSigningKey(key []byte) {
+	a.signingKey = key
+}
+
+// SetHeadroomBuckets makes the annotator also publish the headroom label.
+func (a *Annotator) SetHeadroomBuckets(buckets HeadroomBuckets) {
+	a.buckets = &buckets
+}
+
+// NewEventRecorder returns a recorder that emits events on behalf of the
+// annotator, the agent's service account needs permission to create events.
+func NewEventRecorder(client clientset.Interface) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: DefaultFieldManager})
+}
+
+// SetEventRecorder enables events on the node whenever the metric crosses the
+// threshold, so `kubectl describe node` shows the SMT contention history.
+func (a *Annotator) SetEventRecorder(recorder record.EventRecorder, metric string, threshold int64) {
+	a.recorder = recorder
+	a.eventMetric = metric
+	a.eventThreshold = threshold
+}
+
+func (a *Annotator) nodeRef() *v1.ObjectReference {
+	// Events on nodes are keyed by name, the same way the kubelet does it
+	return &v1.ObjectReference{
+		Kind: "Node",
+		Name: a.nodeName,
+		UID:  types.UID(a.nodeName),
+	}
+}
+
+func (a *Annotator) recordTransition(annotations map[string]string, now time.Time) {
+	if a.recorder == nil {
+		return
+	}
+
+	rcpu, ok := getRCPU(annotations, a.eventMetric)
+	if !ok {
+		return
+	}
+
+	overloaded := rcpu >= a.eventThreshold
+	switch {
+	case overloaded && a.overloadedSince.IsZero():
+		a.overloadedSince = now
+		a.recorder.Eventf(a.nodeRef(), v1.EventTypeWarning, "RCPUOverloaded",
+			"%s is %d, at or above the threshold %d", a.eventMetric, rcpu, a.eventThreshold)
+	case !overloaded && !a.overloadedSince.IsZero():
+		duration := now.Sub(a.overloadedSince).Round(time.Second)
+		a.overloadedSince = time.Time{}
+		a.recorder.Eventf(a.nodeRef(), v1.EventTypeNormal, "RCPURecovered",
+			"%s is %d, below the threshold %d after %v", a.eventMetric, rcpu, a.eventThreshold, duration)
+	}
+}
+
+// Apply sets the annotations owned by the annotator. Keys applied previously
+// but missing from annotations are removed, since server-side apply drops
+// fields no longer present in the applied configuration.
+func (a *Annotator) Apply(ctx context.Context, annotations map[string]string) error {
+	// Stamp a copy, the caller's map keeps only the metrics
+	annotations = copyAnnotations(annotations)
+	if a.signingKey != nil {
+		SignAnnotations(a.signingKey, a.nodeName, annotations, time.Now())
+	} else {
+		StampAnnotations(annotations, time.Now())
+	}
+
+	node := applycorev1.Node(a.nodeName).WithAnnotations(annotations)
+
+	// The label is left out while the metric is missing, which removes it
+	if a.buckets != nil {
+		if bucket, ok := a.buckets.Bucket(annotations); ok {
+			node.WithLabels(map[string]string{HeadroomLabelKey: bucket})
+		}
+	}
+
+	_, err := a.client.CoreV1().Nodes().Apply(ctx, node, metav1.ApplyOptions{
+		FieldManager: a.fieldManager,
+		// Never take over fields owned by someone else
+		Force: false,
+	})
+	if apierrors.IsConflict(err) {
+		return fmt.Errorf("conflicting owner for rcpu annotations on node %s: %v", a.nodeName, err)
+	} else if err != nil {
+		return fmt.Errorf("failed to apply rcpu annotations on node %s: %v", a.nodeName, err)
+	}
+
+	return nil
+}
+
+func copyAnnotations(annotations map[string]string) map[string]string {
+	copied := make(map[string]string, len(annotations))
+	for key, value := range annotations {
+		copied[key] = value
+	}
+
+	return copied
+}
+
+func (a *Annotator) jitteredMaxInterval() time.Duration {
+	if a.policy.Jitter <= 0 {
+		return a.policy.MaxInterval
+	}
+
+	return a.policy.MaxInterval + time.Duration(rand.Float64()*a.policy.Jitter*float64(a.policy.MaxInterval))
+}
+
+// isPerMilleMetric reports whether MinChange applies to the annotation
+func isPerMilleMetric(key string) bool {
+	for _, w := range metricWindows {
+		if key == w.key || key == w.avgKey {
+			return true
+		}
+	}
+
+	return groupMetricKeyPattern.MatchString(key)
+}
+
+// changedEnough reports whether any per-mille metric moved by at least
+// MinChange, other values count as changed whenever they differ.
+func (a *Annotator) changedEnough(annotations map[string]string) bool {
+	// The time in a payload changes with every sample, its metrics are
+	// compared instead
+	annotations, _ = expandPayload(annotations)
+	lastApplied, _ := expandPayload(a.lastApplied)
+	if len(annotations) != len(lastApplied) {
+		return true
+	}
+
+	for key, value := range annotations {
+		lastValue, ok := lastApplied[key]
+		if !ok {
+			return true
+		}
+
+		if value == lastValue {
+			continue
+		}
+
+		if !isPerMilleMetric(key) {
+			return true
+		}
+
+		cur, err1 := parseAnnotatedMetric(annotations, value)
+		last, err2 := parseAnnotatedMetric(lastApplied, lastValue)
+		if err1 != nil || err2 != nil {
+			return true
+		}
+
+		if diff := cur - last; diff >= a.policy.MinChange || -diff >= a.policy.MinChange {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Update applies the annotations if the update policy allows it, and reports
+// whether the node was patched.
+func (a *Annotator) Update(ctx context.Context, annotations map[string]string) (bool, error) {
+	now := time.Now()
+
+	a.recordTransition(annotations, now)
+
+	if a.lastApplied != nil {
+		elapsed := now.Sub(a.lastTime)
+		if elapsed < a.policy.MinInterval {
+			return false, nil
+		}
+
+		if elapsed < a.nextRefresh && !a.changedEnough(annotations) {
+			return false, nil
+		}
+	}
+
+	// Keep a copy, the caller may reuse its map for the next update
+	applied := copyAnnotations(annotations)
+	if err := a.Apply(ctx, applied); err != nil {
+		return false, err
+	}
+
+	a.lastApplied = applied
+	a.lastTime = now
+	a.nextRefresh = a.jitteredMaxInterval()
+
+	return true, nil
+}
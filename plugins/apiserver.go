@@ -0,0 +1,657 @@
+package rcpu
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/client-go/informers"
+	clientset "k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	NodeRCPUGroup    = "rcpu.metrics.k8s.io"
+	NodeRCPUVersion  = "v1alpha1"
+	NodeRCPUResource = "nodercpus"
+	NodeRCPUKind     = "NodeRCPU"
+
+	DefaultAPIServerListen = ":6443"
+
+	// authenticationConfigMap is where kube-apiserver publishes the CA and
+	// the headers of the requests it proxies to aggregated API servers
+	authenticationConfigMapNamespace = "kube-system"
+	authenticationConfigMap          = "extension-apiserver-authentication"
+)
+
+var nodeRCPUGroupVersion = schema.GroupVersion{Group: NodeRCPUGroup, Version: NodeRCPUVersion}
+
+// NodeRCPU is the RCPU of a node, as its annotations publish it. The metrics
+// are per mille, like the annotations, and missing until the annotator wrote
+// them.
+type NodeRCPU struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Enabled tells whether the plugin acts on the node, see FeatureGate
+	Enabled bool `json:"enabled"`
+	// Timestamp is when the annotator last wrote the metrics
+	Timestamp *metav1.Time `json:"timestamp,omitempty"`
+	RCPU1m    *int64       `json:"rcpu1m,omitempty"`
+	RCPU5m    *int64       `json:"rcpu5m,omitempty"`
+	RCPU15m   *int64       `json:"rcpu15m,omitempty"`
+	FreeCores *int64       `json:"freeCores,omitempty"`
+}
+
+type NodeRCPUList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []NodeRCPU `json:"items"`
+}
+
+func optionalInt64(v int64, ok bool) *int64 {
+	if !ok {
+		return nil
+	}
+
+	return &v
+}
+
+// NewNodeRCPU reads the RCPU of the node from its annotations.
+func NewNodeRCPU(node *v1.Node, gate *FeatureGate) NodeRCPU {
+	m := parseNodeMetrics(node.Annotations)
+	obj := NodeRCPU{
+		TypeMeta: metav1.TypeMeta{APIVersion: nodeRCPUGroupVersion.String(), Kind: NodeRCPUKind},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              node.Name,
+			Labels:            node.Labels,
+			CreationTimestamp: node.CreationTimestamp,
+		},
+		Enabled:   gate.Enabled(node),
+		FreeCores: optionalInt64(m.freeCores, m.hasFreeCores),
+	}
+
+	if !m.written.IsZero() {
+		obj.Timestamp = &metav1.Time{Time: m.written}
+	}
+
+	for _, field := range []struct {
+		key string
+		dst **int64
+	}{
+		{RCPUMetric1mKey, &obj.RCPU1m},
+		{RCPUMetric5mKey, &obj.RCPU5m},
+		{RCPUMetric15mKey, &obj.RCPU15m},
+	} {
+		rcpu, ok := m.rcpu[field.key]
+		*field.dst = optionalInt64(rcpu, ok)
+	}
+
+	return obj
+}
+
+// RequestHeaderAuthenticator authenticates the requests kube-apiserver
+// proxies to an aggregated API server: the client certificate of the proxy
+// vouches for the user named in the request's headers.
+type RequestHeaderAuthenticator struct {
+	roots *x509.CertPool
+	// allowedNames are the common names the proxy may have, any if empty
+	allowedNames    []string
+	usernameHeaders []string
+	groupHeaders    []string
+	extraPrefixes   []string
+}
+
+// user is who a request is from, as SubjectAccessReview takes it
+type user struct {
+	name   string
+	groups []string
+	extra  map[string]authorizationv1.ExtraValue
+}
+
+// LoadRequestHeaderAuthenticator reads the configuration kube-apiserver
+// publishes for aggregated API servers.
+func LoadRequestHeaderAuthenticator(ctx context.Context, client clientset.Interface) (*RequestHeaderAuthenticator, error) {
+	cm, err := client.CoreV1().ConfigMaps(authenticationConfigMapNamespace).Get(ctx, authenticationConfigMap, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s/%s: %v", authenticationConfigMapNamespace, authenticationConfigMap, err)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(cm.Data["requestheader-client-ca-file"])) {
+		return nil, fmt.Errorf("%s/%s has no requestheader-client-ca-file, kube-apiserver needs --requestheader-client-ca-file", authenticationConfigMapNamespace, authenticationConfigMap)
+	}
+
+	a := &RequestHeaderAuthenticator{roots: roots}
+	for _, field := range []struct {
+		key string
+		dst *[]string
+	}{
+		{"requestheader-allowed-names", &a.allowedNames},
+		{"requestheader-username-headers", &a.usernameHeaders},
+		{"requestheader-group-headers", &a.groupHeaders},
+		{"requestheader-extra-headers-prefix", &a.extraPrefixes},
+	} {
+		if value := cm.Data[field.key]; value != "" {
+			if err := json.Unmarshal([]byte(value), field.dst); err != nil {
+				return nil, fmt.Errorf("malformed %s in %s/%s: %v", field.key, authenticationConfigMapNamespace, authenticationConfigMap, err)
+			}
+		}
+	}
+
+	if len(a.usernameHeaders) == 0 {
+		return nil, fmt.Errorf("%s/%s has no requestheader-username-headers", authenticationConfigMapNamespace, authenticationConfigMap)
+	}
+
+	return a, nil
+}
+
+// Authenticate returns the user of a request proxied by kube-apiserver.
+func (a *RequestHeaderAuthenticator) Authenticate(r *http.Request) (*user, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, errors.New("no client certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range r.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	cert := r.TLS.PeerCertificates[0]
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         a.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return nil, fmt.Errorf("untrusted client certificate: %v", err)
+	}
+
+	if len(a.allowedNames) > 0 && !containsString(a.allowedNames, cert.Subject.CommonName) {
+		return nil, fmt.Errorf("client certificate %q isn't an allowed proxy", cert.Subject.CommonName)
+	}
+
+	u := &user{}
+	for _, header := range a.usernameHeaders {
+		if u.name = r.Header.Get(header); u.name != "" {
+			break
+		}
+	}
+	if u.name == "" {
+		return nil, errors.New("no user in the request headers")
+	}
+
+	for _, header := range a.groupHeaders {
+		u.groups = append(u.groups, r.Header.Values(header)...)
+	}
+
+	for name, values := range r.Header {
+		for _, prefix := range a.extraPrefixes {
+			if len(name) <= len(prefix) || !strings.EqualFold(name[:len(prefix)], prefix) {
+				continue
+			}
+
+			// The keys are lowercased and escaped, see the requestheader
+			// authenticator of k8s.io/apiserver
+			key, err := url.PathUnescape(strings.ToLower(name[len(prefix):]))
+			if err != nil {
+				continue
+			}
+			if u.extra == nil {
+				u.extra = make(map[string]authorizationv1.ExtraValue)
+			}
+			u.extra[key] = append(u.extra[key], values...)
+		}
+	}
+
+	return u, nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// APIServer serves the NodeRCPU of every node as the aggregated API
+// rcpu.metrics.k8s.io, read-only, and with pod metrics the custom metrics API.
+// Requests are authorized by kube-apiserver through a SubjectAccessReview, so
+// RBAC grants get and list on nodercpus.
+type APIServer struct {
+	client clientset.Interface
+	nodes  corelisters.NodeLister
+	gate   *FeatureGate
+	authn  *RequestHeaderAuthenticator
+
+	// pods and podMetrics are nil unless the custom metrics are served
+	pods       corelisters.PodLister
+	podMetrics *PodMetricsStore
+}
+
+func NewAPIServer(client clientset.Interface, nodes corelisters.NodeLister, gate *FeatureGate, authn *RequestHeaderAuthenticator) *APIServer {
+	return &APIServer{client: client, nodes: nodes, gate: gate, authn: authn}
+}
+
+// SetPodMetrics serves the pod metrics of the store as custom metrics, see
+// CustomMetricsGroup.
+func (s *APIServer) SetPodMetrics(pods corelisters.PodLister, store *PodMetricsStore) {
+	s.pods, s.podMetrics = pods, store
+}
+
+func writeJSON(w http.ResponseWriter, code int, obj any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(obj); err != nil {
+		klog.ErrorS(err, "Failed to write response")
+	}
+}
+
+func writeStatus(w http.ResponseWriter, err *apierrors.StatusError) {
+	status := err.ErrStatus
+	status.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Status"}
+	writeJSON(w, int(status.Code), status)
+}
+
+// authorize asks kube-apiserver whether the user may make the request
+func (s *APIServer) authorize(ctx context.Context, u *user, attrs authorizationv1.SubjectAccessReviewSpec) (bool, string, error) {
+	attrs.User, attrs.Groups, attrs.Extra = u.name, u.groups, u.extra
+	review, err := s.client.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{Spec: attrs}, metav1.CreateOptions{})
+	if err != nil {
+		return false, "", err
+	}
+
+	return review.Status.Allowed, review.Status.Reason, nil
+}
+
+// route is what a request asks for, the attributes it is authorized with and
+// what serves it
+type route struct {
+	attrs authorizationv1.SubjectAccessReviewSpec
+	serve http.HandlerFunc
+}
+
+func (s *APIServer) route(r *http.Request) route {
+	nodeRCPUPath := "/apis/" + nodeRCPUGroupVersion.String() + "/" + NodeRCPUResource
+	switch {
+	case r.URL.Path == nodeRCPUPath:
+		if r.URL.Query().Get("watch") == "true" {
+			return route{
+				attrs: authorizationv1.SubjectAccessReviewSpec{ResourceAttributes: &authorizationv1.ResourceAttributes{Verb: "watch", Group: NodeRCPUGroup, Version: NodeRCPUVersion, Resource: NodeRCPUResource}},
+				serve: func(w http.ResponseWriter, r *http.Request) {
+					writeStatus(w, apierrors.NewMethodNotSupported(schema.GroupResource{Group: NodeRCPUGroup, Resource: NodeRCPUResource}, "watch"))
+				},
+			}
+		}
+		return route{
+			attrs: authorizationv1.SubjectAccessReviewSpec{ResourceAttributes: &authorizationv1.ResourceAttributes{Verb: "list", Group: NodeRCPUGroup, Version: NodeRCPUVersion, Resource: NodeRCPUResource}},
+			serve: s.serveNodeRCPUList,
+		}
+	case strings.HasPrefix(r.URL.Path, nodeRCPUPath+"/"):
+		name := strings.TrimPrefix(r.URL.Path, nodeRCPUPath+"/")
+		return route{
+			attrs: authorizationv1.SubjectAccessReviewSpec{ResourceAttributes: &authorizationv1.ResourceAttributes{Verb: "get", Group: NodeRCPUGroup, Version: NodeRCPUVersion, Resource: NodeRCPUResource, Name: name}},
+			serve: func(w http.ResponseWriter, r *http.Request) { s.serveNodeRCPU(w, r, name) },
+		}
+	}
+
+	if s.podMetrics != nil {
+		if req, ok := parseCustomMetricPath(r.URL.Path); ok {
+			return route{attrs: req.attributes(), serve: func(w http.ResponseWriter, r *http.Request) { s.serveCustomMetric(w, r, req) }}
+		}
+	}
+
+	return route{
+		attrs: authorizationv1.SubjectAccessReviewSpec{NonResourceAttributes: &authorizationv1.NonResourceAttributes{Verb: "get", Path: r.URL.Path}},
+		serve: s.serveDiscovery,
+	}
+}
+
+func (s *APIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" || r.URL.Path == "/livez" {
+		w.Write([]byte("ok"))
+		return
+	}
+
+	u, err := s.authn.Authenticate(r)
+	if err != nil {
+		klog.V(4).InfoS("Unauthenticated request", "path", r.URL.Path, "err", err)
+		writeStatus(w, apierrors.NewUnauthorized(err.Error()))
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		writeStatus(w, apierrors.NewMethodNotSupported(schema.GroupResource{Group: NodeRCPUGroup, Resource: NodeRCPUResource}, strings.ToLower(r.Method)))
+		return
+	}
+
+	rt := s.route(r)
+	allowed, reason, err := s.authorize(r.Context(), u, rt.attrs)
+	if err != nil {
+		writeStatus(w, apierrors.NewInternalError(fmt.Errorf("failed to authorize: %v", err)))
+		return
+	}
+	if !allowed {
+		var gr schema.GroupResource
+		var name string
+		if attrs := rt.attrs.ResourceAttributes; attrs != nil {
+			gr, name = schema.GroupResource{Group: attrs.Group, Resource: attrs.Resource}, attrs.Name
+		}
+		writeStatus(w, apierrors.NewForbidden(gr, name, fmt.Errorf("user %q cannot get %s: %s", u.name, r.URL.Path, reason)))
+		return
+	}
+
+	rt.serve(w, r)
+}
+
+func (s *APIServer) serveDiscovery(w http.ResponseWriter, r *http.Request) {
+	groups := []metav1.APIGroup{apiGroup(nodeRCPUGroupVersion)}
+	resources := map[string][]metav1.APIResource{
+		nodeRCPUGroupVersion.String(): {{
+			Name:         NodeRCPUResource,
+			SingularName: "nodercpu",
+			Namespaced:   false,
+			Kind:         NodeRCPUKind,
+			Verbs:        metav1.Verbs{"get", "list"},
+			ShortNames:   []string{"noderc"},
+		}},
+	}
+	if s.podMetrics != nil {
+		groups = append(groups, apiGroup(customMetricsGroupVersion))
+		resources[customMetricsGroupVersion.String()] = customMetricResources()
+	}
+
+	if r.URL.Path == "/apis" {
+		writeJSON(w, http.StatusOK, metav1.APIGroupList{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "APIGroupList"},
+			Groups:   groups,
+		})
+		return
+	}
+
+	for _, group := range groups {
+		switch r.URL.Path {
+		case "/apis/" + group.Name:
+			writeJSON(w, http.StatusOK, group)
+			return
+		case "/apis/" + group.PreferredVersion.GroupVersion:
+			writeJSON(w, http.StatusOK, metav1.APIResourceList{
+				TypeMeta:     metav1.TypeMeta{APIVersion: "v1", Kind: "APIResourceList"},
+				GroupVersion: group.PreferredVersion.GroupVersion,
+				APIResources: resources[group.PreferredVersion.GroupVersion],
+			})
+			return
+		}
+	}
+
+	writeStatus(w, apierrors.NewNotFound(schema.GroupResource{}, r.URL.Path))
+}
+
+func apiGroup(gv schema.GroupVersion) metav1.APIGroup {
+	version := metav1.GroupVersionForDiscovery{GroupVersion: gv.String(), Version: gv.Version}
+	return metav1.APIGroup{
+		TypeMeta:         metav1.TypeMeta{APIVersion: "v1", Kind: "APIGroup"},
+		Name:             gv.Group,
+		Versions:         []metav1.GroupVersionForDiscovery{version},
+		PreferredVersion: version,
+	}
+}
+
+// wantsTable tells whether the client, e.g. kubectl get, asks for the
+// server-side printing of a Table
+func wantsTable(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "as=Table")
+}
+
+func (s *APIServer) serveNodeRCPUList(w http.ResponseWriter, r *http.Request) {
+	selector := labels.Everything()
+	if query := r.URL.Query().Get("labelSelector"); query != "" {
+		var err error
+		if selector, err = labels.Parse(query); err != nil {
+			writeStatus(w, apierrors.NewBadRequest(fmt.Sprintf("invalid label selector: %v", err)))
+			return
+		}
+	}
+
+	nodes, err := s.nodes.List(selector)
+	if err != nil {
+		writeStatus(w, apierrors.NewInternalError(err))
+		return
+	}
+
+	list := NodeRCPUList{
+		TypeMeta: metav1.TypeMeta{APIVersion: nodeRCPUGroupVersion.String(), Kind: NodeRCPUKind + "List"},
+		Items:    make([]NodeRCPU, 0, len(nodes)),
+	}
+	for _, node := range nodes {
+		list.Items = append(list.Items, NewNodeRCPU(node, s.gate))
+	}
+
+	if wantsTable(r) {
+		writeJSON(w, http.StatusOK, nodeRCPUTable(list.Items, time.Now()))
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *APIServer) serveNodeRCPU(w http.ResponseWriter, r *http.Request, name string) {
+	node, err := s.nodes.Get(name)
+	if apierrors.IsNotFound(err) {
+		writeStatus(w, apierrors.NewNotFound(schema.GroupResource{Group: NodeRCPUGroup, Resource: NodeRCPUResource}, name))
+		return
+	} else if err != nil {
+		writeStatus(w, apierrors.NewInternalError(err))
+		return
+	}
+
+	obj := NewNodeRCPU(node, s.gate)
+	if wantsTable(r) {
+		writeJSON(w, http.StatusOK, nodeRCPUTable([]NodeRCPU{obj}, time.Now()))
+		return
+	}
+	writeJSON(w, http.StatusOK, obj)
+}
+
+func formatOptional(v *int64) string {
+	if v == nil {
+		return "<none>"
+	}
+
+	return fmt.Sprint(*v)
+}
+
+// nodeRCPUTable is what kubectl get prints, the metrics and how long ago the
+// annotator wrote them
+func nodeRCPUTable(items []NodeRCPU, now time.Time) *metav1.Table {
+	table := &metav1.Table{
+		TypeMeta: metav1.TypeMeta{APIVersion: "meta.k8s.io/v1", Kind: "Table"},
+		ColumnDefinitions: []metav1.TableColumnDefinition{
+			{Name: "Name", Type: "string", Format: "name", Description: "Name of the node."},
+			{Name: "Enabled", Type: "boolean", Description: "Whether the plugin acts on the node."},
+			{Name: "RCPU-1m", Type: "string", Description: "Per-mille RCPU over the last minute."},
+			{Name: "RCPU-5m", Type: "string", Description: "Per-mille RCPU over the last 5 minutes."},
+			{Name: "RCPU-15m", Type: "string", Description: "Per-mille RCPU over the last 15 minutes."},
+			{Name: "Free-Cores", Type: "string", Description: "Physical cores idle on all their threads."},
+			{Name: "Updated", Type: "string", Description: "How long ago the annotator wrote the metrics."},
+		},
+	}
+
+	for i := range items {
+		item := &items[i]
+		updated := "<unknown>"
+		if item.Timestamp != nil {
+			updated = duration.HumanDuration(now.Sub(item.Timestamp.Time))
+		}
+
+		// kubectl only needs the metadata of the rows
+		meta, _ := json.Marshal(metav1.PartialObjectMetadata{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "meta.k8s.io/v1", Kind: "PartialObjectMetadata"},
+			ObjectMeta: item.ObjectMeta,
+		})
+		table.Rows = append(table.Rows, metav1.TableRow{
+			Cells:  []any{item.Name, item.Enabled, formatOptional(item.RCPU1m), formatOptional(item.RCPU5m), formatOptional(item.RCPU15m), formatOptional(item.FreeCores), updated},
+			Object: runtime.RawExtension{Raw: meta},
+		})
+	}
+
+	return table
+}
+
+// selfSignedCertificate is served without -tls-cert-file, the APIService
+// then has to skip the verification of the server
+func selfSignedCertificate(host string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(now.UnixNano()),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// RunAPIServer serves the NodeRCPU API, and with -source the custom metrics
+// of the pods, registered with kube-apiserver by APIServices, see
+// NewAPIServerManifests.
+func RunAPIServer(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("apiserver", flag.ExitOnError)
+	listen := fs.String("listen", DefaultAPIServerListen, "address to serve the API on, over TLS")
+	certFile := fs.String("tls-cert-file", "", "PEM certificate to serve, a self-signed one is generated if empty")
+	keyFile := fs.String("tls-key-file", "", "PEM key of the -tls-cert-file certificate")
+	kubeconfig := fs.String("kubeconfig", "", "kubeconfig file, the in-cluster configuration is used if empty")
+	gateKey := fs.String("feature-gate-key", RCPUFeatureGateKey, "annotation enabling the plugin on a node, see the plugin's featureGateKey")
+	source := fs.String("source", "", "also serve the usage of the pods in these samples as custom metrics, e.g. the aggregator's /v1/samples")
+	interval := fs.Duration("interval", DefaultAnnotateInterval, "how often to poll -source")
+	maxAge := fs.Duration("pod-metrics-max-age", DefaultPodMetricsMaxAge, "stop serving the usage of a pod -source hasn't reported for this long")
+	sourceTokenFile := fs.String("source-token-file", "", "authenticate to the source with the bearer token in this file")
+	sourceCAFile := fs.String("source-ca-file", "", "trust the PEM CAs in this file for an https source")
+	sourceCertFile := fs.String("source-cert-file", "", "authenticate to the source with the PEM client certificate in this file")
+	sourceKeyFile := fs.String("source-key-file", "", "PEM key of the -source-cert-file certificate")
+	fs.Parse(args)
+
+	if *source != "" && (*interval <= 0 || *maxAge <= 0) {
+		return fmt.Errorf("invalid interval %v or max age %v", *interval, *maxAge)
+	}
+
+	if (*certFile == "") != (*keyFile == "") {
+		return fmt.Errorf("-tls-cert-file and -tls-key-file go together")
+	}
+
+	var cert tls.Certificate
+	var err error
+	if *certFile != "" {
+		cert, err = tls.LoadX509KeyPair(*certFile, *keyFile)
+	} else {
+		cert, err = selfSignedCertificate(DefaultAPIServerName)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load the serving certificate: %v", err)
+	}
+
+	client, err := NewClient(*kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	authn, err := LoadRequestHeaderAuthenticator(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	gate, err := NewFeatureGate(*gateKey, nil)
+	if err != nil {
+		return err
+	}
+
+	factory := informers.NewSharedInformerFactory(client, 0)
+	apiServer := NewAPIServer(client, factory.Core().V1().Nodes().Lister(), gate, authn)
+
+	if *source != "" {
+		sourceToken, err := LoadSourceToken(*sourceTokenFile)
+		if err != nil {
+			return err
+		}
+
+		sourceTLS, err := SourceTLSConfig(*sourceCAFile, *sourceCertFile, *sourceKeyFile)
+		if err != nil {
+			return err
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if sourceTLS != nil {
+			transport.TLSClientConfig = sourceTLS
+		}
+
+		store := NewPodMetricsStore(*maxAge)
+		apiServer.SetPodMetrics(factory.Core().V1().Pods().Lister(), store)
+		go PollPodMetrics(ctx, &http.Client{Transport: transport}, *source, sourceToken, *interval, store)
+	}
+
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+
+	server := &http.Server{
+		Addr:    *listen,
+		Handler: apiServer,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			// The proxy's certificate is verified against the requestheader
+			// CA, other clients may still reach the health checks
+			ClientAuth: tls.RequestClientCert,
+			MinVersion: tls.VersionTLS12,
+		},
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	klog.InfoS("API server is running", "listen", *listen, "groupVersion", nodeRCPUGroupVersion.String())
+	if err := server.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package rcpu
+
+import (
+	"context"
+	"math"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// DefaultBalanceWeight weighs RCPU and the allocation balance equally
+const DefaultBalanceWeight = 0.5
+
+// podRequestsStateKey holds the requests of the pod, from PreScore to Score
+const podRequestsStateKey framework.StateKey = Name + "/pod-requests"
+
+// podRequests are the requests of the pod the balance is scored with,
+// computed once rather than for every node
+type podRequests struct {
+	milliCPU int64
+	memory   int64
+}
+
+func (r *podRequests) Clone() framework.StateData {
+	return r
+}
+
+func newPodRequests(pod *v1.Pod) *podRequests {
+	memory := podRequest(pod, v1.ResourceMemory)
+	return &podRequests{milliCPU: podCPUMillis(pod), memory: memory.Value()}
+}
+
+// readPodRequests returns the requests PreScore computed, or computes them
+// if the plugin isn't enabled at PreScore
+func readPodRequests(state *framework.CycleState, pod *v1.Pod) *podRequests {
+	if data, err := state.Read(podRequestsStateKey); err == nil {
+		if requests, ok := data.(*podRequests); ok {
+			return requests
+		}
+	}
+
+	return newPodRequests(pod)
+}
+
+// PreScore computes the requests of the pod for the Balanced scoring, and
+// samples the nodes Score ranks with a score sample size.
+func (rs *RCPUScheduler) PreScore(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodes []*framework.NodeInfo) *framework.Status {
+	defer observeDuration("PreScore", time.Now())
+
+	if rs.scoring == ScoringBalanced && rs.mode != ModeFilterOnly {
+		state.Write(podRequestsStateKey, newPodRequests(pod))
+	}
+
+	if sampled := sampleNodes(nodes, rs.scoreSampleSize); sampled != nil && rs.mode != ModeFilterOnly {
+		state.Write(sampledNodesStateKey, sampled)
+	}
+
+	return framework.NewStatus(framework.Success, "")
+}
+
+// requestedFraction is the fraction of the allocatable resource requested
+// once the pod is placed, capped at 1 like NodeResourcesBalancedAllocation
+func requestedFraction(requested, podRequest, allocatable int64) float64 {
+	if allocatable <= 0 {
+		return 1
+	}
+
+	return math.Min(1, float64(requested+podRequest)/float64(allocatable))
+}
+
+// balanceScore is the per-mille score NodeResourcesBalancedAllocation gives
+// the node for the pod: the standard deviation of the requested CPU and
+// memory fractions once the pod is placed, subtracted from 1. It ranges from
+// 500, all of one resource requested and none of the other, to 1000.
+func balanceScore(requests *podRequests, nodeInfo *framework.NodeInfo) int64 {
+	if nodeInfo.Requested == nil || nodeInfo.Allocatable == nil {
+		return RCPUMaxScore
+	}
+
+	cpu := requestedFraction(nodeInfo.Requested.MilliCPU, requests.milliCPU, nodeInfo.Allocatable.MilliCPU)
+	memory := requestedFraction(nodeInfo.Requested.Memory, requests.memory, nodeInfo.Allocatable.Memory)
+
+	// The standard deviation of two values is half their difference
+	std := math.Abs(cpu-memory) / 2
+	return int64(math.Round((1 - std) * float64(RCPUMaxScore)))
+}
+
+// combineScores weighs the per-mille RCPU and balance scores, so a node has
+// to do well on both to come first.
+func combineScores(rcpuScore, balance int64, balanceWeight float64) int64 {
+	return int64(math.Round((1-balanceWeight)*float64(rcpuScore) + balanceWeight*float64(balance)))
+}
@@ -0,0 +1,213 @@
+package rcpu
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	clientset "k8s.io/client-go/kubernetes"
+	coordinationlisters "k8s.io/client-go/listers/coordination/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	DefaultCleanupFieldManager = "rcpu-cleanup"
+	DefaultCleanupLeaseName    = "rcpu-cleanup"
+
+	// DefaultCleanupGracePeriod leaves a restarting agent, or a node being
+	// drained for maintenance, time to come back before its metrics go
+	DefaultCleanupGracePeriod = 10 * time.Minute
+	DefaultCleanupInterval    = time.Minute
+)
+
+// StaleAnnotationCleaner removes the rcpu-scheduler/* annotations, the
+// feature gate and the headroom label of the nodes whose liveness lease
+// expired longer than the grace period ago. Otherwise the last metrics of a
+// decommissioned agent keep the node filtered out for good if they were high,
+// or preferred for good if they were low.
+//
+// Nodes without a lease are left alone, their annotator may not renew any.
+type StaleAnnotationCleaner struct {
+	client       clientset.Interface
+	nodes        corelisters.NodeLister
+	leases       coordinationlisters.LeaseNamespaceLister
+	gateKey      string
+	gracePeriod  time.Duration
+	fieldManager string
+}
+
+func NewStaleAnnotationCleaner(client clientset.Interface, nodes corelisters.NodeLister, leases coordinationlisters.LeaseNamespaceLister, gateKey string, gracePeriod time.Duration) *StaleAnnotationCleaner {
+	return &StaleAnnotationCleaner{
+		client:       client,
+		nodes:        nodes,
+		leases:       leases,
+		gateKey:      gateKey,
+		gracePeriod:  gracePeriod,
+		fieldManager: DefaultCleanupFieldManager,
+	}
+}
+
+func (c *StaleAnnotationCleaner) SetFieldManager(fieldManager string) {
+	c.fieldManager = fieldManager
+}
+
+// stalePatch is the merge patch removing the annotations and labels the agent
+// left on the node, nil if there are none.
+func (c *StaleAnnotationCleaner) stalePatch(annotations, nodeLabels map[string]string) ([]byte, error) {
+	removed := make(map[string]any)
+	for key := range annotations {
+		if strings.HasPrefix(key, RCPUAnnotationPrefix) || key == c.gateKey {
+			removed[key] = nil
+		}
+	}
+
+	metadata := make(map[string]any)
+	if len(removed) > 0 {
+		metadata["annotations"] = removed
+	}
+	if _, ok := nodeLabels[HeadroomLabelKey]; ok {
+		metadata["labels"] = map[string]any{HeadroomLabelKey: nil}
+	}
+
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+
+	return json.Marshal(map[string]any{"metadata": metadata})
+}
+
+// Clean removes the stale metrics of every node whose lease expired longer
+// than the grace period before now, and returns the nodes it cleaned.
+func (c *StaleAnnotationCleaner) Clean(ctx context.Context, now time.Time) ([]string, error) {
+	leases, err := c.leases.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the liveness leases: %v", err)
+	}
+
+	var cleaned []string
+	var errs []string
+	for _, lease := range leases {
+		nodeName, ok := strings.CutPrefix(lease.Name, LivenessLeasePrefix)
+		if !ok || !leaseExpired(lease, now.Add(-c.gracePeriod)) {
+			continue
+		}
+
+		// The lease of a deleted node lingers, there is nothing to clean
+		node, err := c.nodes.Get(nodeName)
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			errs = append(errs, fmt.Sprintf("node %s: %v", nodeName, err))
+			continue
+		}
+
+		patch, err := c.stalePatch(node.Annotations, node.Labels)
+		if err != nil {
+			return cleaned, err
+		}
+		if patch == nil {
+			continue
+		}
+
+		_, err = c.client.CoreV1().Nodes().Patch(ctx, nodeName, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: c.fieldManager})
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, fmt.Sprintf("node %s: %v", nodeName, err))
+			continue
+		}
+
+		klog.InfoS("Removed the stale rcpu metrics of the node", "node", nodeName, "renewTime", lease.Spec.RenewTime)
+		cleaned = append(cleaned, nodeName)
+	}
+
+	sort.Strings(cleaned)
+	if len(errs) > 0 {
+		return cleaned, fmt.Errorf("failed to clean nodes: %s", strings.Join(errs, ", "))
+	}
+
+	return cleaned, nil
+}
+
+// Run cleans the nodes every interval until ctx is done.
+func (c *StaleAnnotationCleaner) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := c.Clean(ctx, time.Now()); err != nil {
+			klog.ErrorS(err, "Failed to clean up stale rcpu metrics")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunCleanup runs the cleanup command, the controller removing the metrics of
+// the nodes whose agent stopped renewing its liveness lease.
+func RunCleanup(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	leaseNamespace := fs.String("liveness-lease-namespace", "", "namespace of the annotators' liveness leases, see the annotator's -liveness-lease-namespace")
+	gracePeriod := fs.Duration("grace-period", DefaultCleanupGracePeriod, "how long after its lease expired the metrics of a node are removed")
+	interval := fs.Duration("interval", DefaultCleanupInterval, "how often to look for expired leases")
+	gateKey := fs.String("feature-gate-key", RCPUFeatureGateKey, "annotation enabling the plugin on a node, removed too, see the plugin's featureGateKey")
+	fieldManager := fs.String("field-manager", DefaultCleanupFieldManager, "field manager of the node patches")
+	kubeconfig := fs.String("kubeconfig", "", "kubeconfig file, the in-cluster configuration is used if empty")
+	leaderElect := fs.Bool("leader-elect", false, "only clean up while holding the lease, so several replicas can run")
+	election := DefaultLeaderElectionConfig()
+	fs.StringVar(&election.LeaseName, "lease-name", DefaultCleanupLeaseName, "name of the leader election lease")
+	fs.StringVar(&election.LeaseNamespace, "lease-namespace", DefaultLeaseNamespace, "namespace of the leader election lease")
+	fs.Parse(args)
+
+	if *leaseNamespace == "" {
+		return fmt.Errorf("-liveness-lease-namespace is required")
+	}
+
+	if *gracePeriod < 0 || *interval <= 0 {
+		return fmt.Errorf("invalid grace period %v or interval %v", *gracePeriod, *interval)
+	}
+
+	if *gateKey == "" {
+		return fmt.Errorf("-feature-gate-key can't be empty")
+	}
+
+	client, err := NewClient(*kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	nodeFactory := informers.NewSharedInformerFactory(client, 0)
+	leaseFactory := informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithNamespace(*leaseNamespace))
+	cleaner := NewStaleAnnotationCleaner(client, nodeFactory.Core().V1().Nodes().Lister(),
+		leaseFactory.Coordination().V1().Leases().Lister().Leases(*leaseNamespace), *gateKey, *gracePeriod)
+	cleaner.SetFieldManager(*fieldManager)
+
+	for _, factory := range []informers.SharedInformerFactory{nodeFactory, leaseFactory} {
+		factory.Start(ctx.Done())
+		factory.WaitForCacheSync(ctx.Done())
+	}
+
+	klog.InfoS("Cleanup controller is running", "leaseNamespace", *leaseNamespace, "gracePeriod", *gracePeriod)
+
+	if *leaderElect {
+		return RunWithLeaderElection(ctx, client, election, func(ctx context.Context) {
+			cleaner.Run(ctx, *interval)
+		})
+	}
+
+	cleaner.Run(ctx, *interval)
+
+	return nil
+}
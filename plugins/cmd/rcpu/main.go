@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"k8s.io/klog/v2"
+
+	rcpu "solelab.tech/plugins"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		klog.Fatalf("usage: %s annotate|simulate|report|manifests|apiserver|cleanup|webhook [flags]", os.Args[0])
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch os.Args[1] {
+	case "annotate":
+		if err := rcpu.RunAnnotate(ctx, os.Args[2:]); err != nil {
+			klog.Fatalf("annotator failed: %v", err)
+		}
+	case "simulate":
+		if err := rcpu.RunSimulate(os.Args[2:]); err != nil {
+			klog.Fatalf("simulation failed: %v", err)
+		}
+	case "report":
+		if err := rcpu.RunReport(os.Args[2:]); err != nil {
+			klog.Fatalf("report failed: %v", err)
+		}
+	case "manifests":
+		if err := rcpu.RunManifests(os.Args[2:]); err != nil {
+			klog.Fatalf("manifests failed: %v", err)
+		}
+	case "apiserver":
+		if err := rcpu.RunAPIServer(ctx, os.Args[2:]); err != nil {
+			klog.Fatalf("API server failed: %v", err)
+		}
+	case "cleanup":
+		if err := rcpu.RunCleanup(ctx, os.Args[2:]); err != nil {
+			klog.Fatalf("cleanup failed: %v", err)
+		}
+	case "webhook":
+		if err := rcpu.RunWebhook(ctx, os.Args[2:]); err != nil {
+			klog.Fatalf("webhook failed: %v", err)
+		}
+	default:
+		klog.Fatalf("unknown command %q", os.Args[1])
+	}
+}
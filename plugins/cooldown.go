@@ -0,0 +1,45 @@
+package rcpu
+
+import (
+	"sync"
+	"time"
+)
+
+// Cooldown keeps the nodes that tripped the threshold filtered for a minimum
+// duration, so the pods waiting on an overloaded node don't all land on it
+// the moment a single annotation dips below the threshold.
+type Cooldown struct {
+	duration time.Duration
+
+	mu sync.Mutex
+	// until is when the cooldown of every node ends
+	until map[string]time.Time
+}
+
+func NewCooldown(duration time.Duration) *Cooldown {
+	return &Cooldown{duration: duration, until: make(map[string]time.Time)}
+}
+
+// Observe records whether the node is overloaded at now, restarting its
+// cooldown if it is, and reports whether the node is cooling down.
+func (c *Cooldown) Observe(nodeName string, overloaded bool, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if overloaded {
+		c.until[nodeName] = now.Add(c.duration)
+		return true
+	}
+
+	until, ok := c.until[nodeName]
+	if !ok {
+		return false
+	}
+
+	if !now.Before(until) {
+		delete(c.until, nodeName)
+		return false
+	}
+
+	return true
+}
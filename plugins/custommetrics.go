@@ -0,0 +1,318 @@
+package rcpu
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog/v2"
+)
+
+const (
+	CustomMetricsGroup   = "custom.metrics.k8s.io"
+	CustomMetricsVersion = "v1beta2"
+
+	// PodAdjustedCoresMetric is the SMT-adjusted usage of a pod, in physical
+	// cores, what the pod takes from the node once busy siblings count
+	PodAdjustedCoresMetric = "rcpu_adjusted_cores"
+	// PodBusyCoresMetric is the raw usage of a pod, in logical CPUs
+	PodBusyCoresMetric = "rcpu_busy_cores"
+
+	// DefaultPodMetricsMaxAge drops the usage of pods the source stopped
+	// reporting, e.g. once they were deleted or their node is gone
+	DefaultPodMetricsMaxAge = 2 * time.Minute
+)
+
+var customMetricsGroupVersion = schema.GroupVersion{Group: CustomMetricsGroup, Version: CustomMetricsVersion}
+
+// podMetricNames are the custom metrics served for pods
+var podMetricNames = []string{PodAdjustedCoresMetric, PodBusyCoresMetric}
+
+// The custom.metrics.k8s.io/v1beta2 types, k8s.io/metrics isn't a dependency
+// of the plugin.
+type MetricValueList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []MetricValue `json:"items"`
+}
+
+type MetricValue struct {
+	metav1.TypeMeta `json:",inline"`
+
+	DescribedObject v1.ObjectReference `json:"describedObject"`
+	Metric          MetricIdentifier   `json:"metric"`
+	Timestamp       metav1.Time        `json:"timestamp"`
+	WindowSeconds   *int64             `json:"windowSeconds,omitempty"`
+	Value           resource.Quantity  `json:"value"`
+}
+
+type MetricIdentifier struct {
+	Name     string                `json:"name"`
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+type podKey struct {
+	namespace string
+	name      string
+}
+
+// PodUsage is the latest usage of a pod, in cores.
+type PodUsage struct {
+	AdjustedCores float64
+	BusyCores     float64
+	Time          time.Time
+	Window        time.Duration
+}
+
+func (u PodUsage) metric(name string) float64 {
+	if name == PodBusyCoresMetric {
+		return u.BusyCores
+	}
+
+	return u.AdjustedCores
+}
+
+// PodMetricsStore keeps the latest usage of every pod the samples attribute
+// usage to.
+type PodMetricsStore struct {
+	maxAge time.Duration
+
+	mu   sync.RWMutex
+	pods map[podKey]PodUsage
+}
+
+func NewPodMetricsStore(maxAge time.Duration) *PodMetricsStore {
+	return &PodMetricsStore{maxAge: maxAge, pods: make(map[podKey]PodUsage)}
+}
+
+// podUsages attributes the usage of the sample to its pods. A pod with pinned
+// CPUs is attributed by the podresources API, the others by summing the cgroup
+// usage of their containers.
+func podUsages(sample *SourceSample) map[podKey]PodUsage {
+	usages := make(map[podKey]PodUsage, len(sample.Pods))
+	for _, c := range sample.Containers {
+		key := podKey{c.Namespace, c.Pod}
+		u := usages[key]
+		u.AdjustedCores += c.AdjustedCores
+		u.BusyCores += c.BusyCores
+		usages[key] = u
+	}
+
+	// The podresources attribution is exact for pinned CPUs, it replaces the
+	// containers' share
+	for _, p := range sample.Pods {
+		usages[podKey{p.Namespace, p.Name}] = PodUsage{AdjustedCores: p.AdjustedCores, BusyCores: p.BusyCores}
+	}
+
+	for key, u := range usages {
+		u.Time, u.Window = sample.Time, sample.Interval
+		usages[key] = u
+	}
+
+	return usages
+}
+
+// Update records the usage of the pods in the samples, keeping the newest of
+// a pod reported twice, and drops the pods not reported within the max age.
+func (s *PodMetricsStore) Update(samples []SourceSample, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range samples {
+		for key, u := range podUsages(&samples[i]) {
+			if prev, ok := s.pods[key]; !ok || u.Time.After(prev.Time) {
+				s.pods[key] = u
+			}
+		}
+	}
+
+	for key, u := range s.pods {
+		if now.Sub(u.Time) > s.maxAge {
+			delete(s.pods, key)
+		}
+	}
+}
+
+// Get returns the usage of the pod unless it is unknown or older than the
+// max age.
+func (s *PodMetricsStore) Get(namespace, name string, now time.Time) (PodUsage, bool) {
+	s.mu.RLock()
+	u, ok := s.pods[podKey{namespace, name}]
+	s.mu.RUnlock()
+
+	if !ok || now.Sub(u.Time) > s.maxAge {
+		return PodUsage{}, false
+	}
+
+	return u, true
+}
+
+// PollPodMetrics updates the store from the samples served at source every
+// interval until ctx is done, see FetchSamples.
+func PollPodMetrics(ctx context.Context, client *http.Client, source, token string, interval time.Duration, store *PodMetricsStore) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		fetchCtx, cancel := context.WithTimeout(ctx, DefaultAnnotateTimeout)
+		samples, err := FetchSamples(fetchCtx, client, source, token)
+		cancel()
+		if err != nil {
+			klog.ErrorS(err, "Failed to poll samples", "source", source)
+		} else {
+			store.Update(samples, time.Now())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// customMetricRequest is a request for the metric of a pod, or of the pods
+// of a namespace when the name is *
+type customMetricRequest struct {
+	namespace string
+	name      string
+	metric    string
+}
+
+// parseCustomMetricPath parses
+// /apis/custom.metrics.k8s.io/v1beta2/namespaces/NAMESPACE/pods/NAME/METRIC
+func parseCustomMetricPath(path string) (customMetricRequest, bool) {
+	rest, ok := strings.CutPrefix(path, "/apis/"+customMetricsGroupVersion.String()+"/namespaces/")
+	if !ok {
+		return customMetricRequest{}, false
+	}
+
+	parts := strings.Split(rest, "/")
+	if len(parts) != 4 || parts[1] != "pods" || parts[0] == "" || parts[2] == "" || parts[3] == "" {
+		return customMetricRequest{}, false
+	}
+
+	return customMetricRequest{namespace: parts[0], name: parts[2], metric: parts[3]}, true
+}
+
+// attributes are those kube-apiserver gives the request, the metric is the
+// subresource of the pods, so RBAC grants custom.metrics.k8s.io pods
+func (req customMetricRequest) attributes() authorizationv1.SubjectAccessReviewSpec {
+	attrs := &authorizationv1.ResourceAttributes{
+		Verb:        "get",
+		Group:       CustomMetricsGroup,
+		Version:     CustomMetricsVersion,
+		Resource:    "pods",
+		Subresource: req.metric,
+		Namespace:   req.namespace,
+		Name:        req.name,
+	}
+	if req.name == "*" {
+		attrs.Verb, attrs.Name = "list", ""
+	}
+
+	return authorizationv1.SubjectAccessReviewSpec{ResourceAttributes: attrs}
+}
+
+func customMetricResources() []metav1.APIResource {
+	resources := make([]metav1.APIResource, 0, len(podMetricNames))
+	for _, name := range podMetricNames {
+		resources = append(resources, metav1.APIResource{
+			Name:       "pods/" + name,
+			Namespaced: true,
+			Kind:       "MetricValueList",
+			Verbs:      metav1.Verbs{"get"},
+		})
+	}
+
+	return resources
+}
+
+func newMetricValue(pod *v1.Pod, metric string, u PodUsage) MetricValue {
+	value := MetricValue{
+		DescribedObject: v1.ObjectReference{Kind: "Pod", APIVersion: "/v1", Namespace: pod.Namespace, Name: pod.Name},
+		Metric:          MetricIdentifier{Name: metric},
+		Timestamp:       metav1.Time{Time: u.Time},
+		Value:           *resource.NewMilliQuantity(int64(u.metric(metric)*1000), resource.DecimalSI),
+	}
+	if u.Window > 0 {
+		seconds := int64(u.Window.Round(time.Second) / time.Second)
+		value.WindowSeconds = &seconds
+	}
+
+	return value
+}
+
+// serveCustomMetric serves the metric of a pod, or of the pods matching the
+// labelSelector, as the HPA asks for it. Pods without usage are left out, a
+// pod that isn't found is a 404.
+func (s *APIServer) serveCustomMetric(w http.ResponseWriter, r *http.Request, req customMetricRequest) {
+	gr := schema.GroupResource{Group: CustomMetricsGroup, Resource: "pods"}
+	if !containsString(podMetricNames, req.metric) {
+		writeStatus(w, apierrors.NewNotFound(gr, fmt.Sprintf("%s/%s", req.name, req.metric)))
+		return
+	}
+
+	// The metrics have no labels of their own, a metricSelector matches none
+	if r.URL.Query().Get("metricSelector") != "" {
+		writeStatus(w, apierrors.NewBadRequest("metric selectors aren't supported"))
+		return
+	}
+
+	var pods []*v1.Pod
+	if req.name == "*" {
+		selector := labels.Everything()
+		if query := r.URL.Query().Get("labelSelector"); query != "" {
+			var err error
+			if selector, err = labels.Parse(query); err != nil {
+				writeStatus(w, apierrors.NewBadRequest(fmt.Sprintf("invalid label selector: %v", err)))
+				return
+			}
+		}
+
+		var err error
+		if pods, err = s.pods.Pods(req.namespace).List(selector); err != nil {
+			writeStatus(w, apierrors.NewInternalError(err))
+			return
+		}
+	} else {
+		pod, err := s.pods.Pods(req.namespace).Get(req.name)
+		if apierrors.IsNotFound(err) {
+			writeStatus(w, apierrors.NewNotFound(gr, req.name))
+			return
+		} else if err != nil {
+			writeStatus(w, apierrors.NewInternalError(err))
+			return
+		}
+		pods = []*v1.Pod{pod}
+	}
+
+	now := time.Now()
+	list := MetricValueList{
+		TypeMeta: metav1.TypeMeta{APIVersion: customMetricsGroupVersion.String(), Kind: "MetricValueList"},
+		Items:    make([]MetricValue, 0, len(pods)),
+	}
+	for _, pod := range pods {
+		if u, ok := s.podMetrics.Get(pod.Namespace, pod.Name, now); ok {
+			list.Items = append(list.Items, newMetricValue(pod, req.metric, u))
+		}
+	}
+
+	if req.name != "*" && len(list.Items) == 0 {
+		writeStatus(w, apierrors.NewNotFound(gr, fmt.Sprintf("%s/%s", req.name, req.metric)))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
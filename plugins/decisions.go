@@ -0,0 +1,188 @@
+package rcpu
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// DecisionLogLevel is the klog verbosity logging every scheduling decision
+// with the metrics, verdict and score of every candidate node
+const DecisionLogLevel = 5
+
+// decisionsStateKey holds the decisions of a scheduling cycle, from
+// PreFilter to Reserve
+const decisionsStateKey framework.StateKey = Name + "/decisions"
+
+func decisionLogEnabled() bool {
+	return klog.V(DecisionLogLevel).Enabled()
+}
+
+type nodeDecision struct {
+	metrics *nodeMetrics
+	// verdict is empty until filtered
+	verdict string
+	score   int64
+	scored  bool
+}
+
+// decisions collects what the plugin saw of every node in a scheduling
+// cycle. Filter and Score run concurrently over the nodes.
+type decisions struct {
+	mu    sync.Mutex
+	nodes map[string]*nodeDecision
+}
+
+// Clone shares the decisions, the preemption dry runs filter the same nodes
+func (d *decisions) Clone() framework.StateData {
+	return d
+}
+
+func readDecisions(state *framework.CycleState) *decisions {
+	data, err := state.Read(decisionsStateKey)
+	if err != nil {
+		return nil
+	}
+
+	d, _ := data.(*decisions)
+	return d
+}
+
+func (d *decisions) node(nodeName string, metrics *nodeMetrics) *nodeDecision {
+	nd, ok := d.nodes[nodeName]
+	if !ok {
+		nd = &nodeDecision{}
+		d.nodes[nodeName] = nd
+	}
+
+	if metrics != nil {
+		nd.metrics = metrics
+	}
+
+	return nd
+}
+
+func (d *decisions) filtered(nodeName string, metrics *nodeMetrics, status *framework.Status) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	nd := d.node(nodeName, metrics)
+	if status.IsSuccess() {
+		nd.verdict = "pass"
+	} else {
+		nd.verdict = status.Message()
+	}
+}
+
+func (d *decisions) scored(nodeName string, metrics *nodeMetrics, score int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	nd := d.node(nodeName, metrics)
+	nd.score, nd.scored = score, true
+}
+
+// normalized replaces the score of the node with its final one
+func (d *decisions) normalized(nodeName string, score int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	nd := d.node(nodeName, nil)
+	nd.score, nd.scored = score, true
+}
+
+// String formats the nodes on a single line, sorted by name, e.g.
+// node-1[rcpu_1min=120 rcpu_5min=130 rcpu_15min=140 free_cores=3 filter=pass score=86]
+func (d *decisions) String() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	names := make([]string, 0, len(d.nodes))
+	for name := range d.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for i, name := range names {
+		nd := d.nodes[name]
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(name)
+		sb.WriteByte('[')
+
+		var fields []string
+		if m := nd.metrics; m != nil {
+			fields = append(fields, fmt.Sprintf("enabled=%t", m.enabled))
+			if m.unknown {
+				fields = append(fields, "metrics=unknown")
+			}
+			if m.provider != "" {
+				fields = append(fields, "provider="+m.provider)
+			}
+			for _, w := range metricWindows {
+				if rcpu, ok := m.rcpu[w.key]; ok {
+					fields = append(fields, fmt.Sprintf("%s=%d", strings.TrimPrefix(w.key, RCPUAnnotationPrefix), rcpu))
+				}
+			}
+			for _, w := range metricWindows {
+				if avg, ok := m.avg[w.avgKey]; ok {
+					fields = append(fields, fmt.Sprintf("%s=%d", strings.TrimPrefix(w.avgKey, RCPUAnnotationPrefix), avg))
+				}
+			}
+			if m.hasFreeCores {
+				fields = append(fields, fmt.Sprintf("free_cores=%d", m.freeCores))
+			}
+		}
+		if nd.verdict != "" {
+			fields = append(fields, fmt.Sprintf("filter=%q", nd.verdict))
+		}
+		if nd.scored {
+			fields = append(fields, fmt.Sprintf("score=%d", nd.score))
+		}
+
+		sb.WriteString(strings.Join(fields, " "))
+		sb.WriteByte(']')
+	}
+
+	return sb.String()
+}
+
+// log writes the decision for the pod on a single line
+func (d *decisions) log(pod *v1.Pod, nodeName string) {
+	klog.V(DecisionLogLevel).InfoS("RCPU scheduling decision", "pod", klog.KObj(pod), "node", nodeName, "candidates", d.String())
+}
+
+// PreFilter starts collecting the decisions of the cycle when they are
+// logged, and ranks the nodes for the overload percentile.
+func (rs *RCPUScheduler) PreFilter(ctx context.Context, state *framework.CycleState, pod *v1.Pod) (*framework.PreFilterResult, *framework.Status) {
+	if decisionLogEnabled() {
+		state.Write(decisionsStateKey, &decisions{nodes: make(map[string]*nodeDecision)})
+	}
+
+	rs.writeOverloadCutoff(state, pod)
+
+	return nil, framework.NewStatus(framework.Success, "")
+}
+
+func (rs *RCPUScheduler) PreFilterExtensions() framework.PreFilterExtensions {
+	return nil
+}
+
+// decisionMetrics returns the metrics of the node for the decision log, nil
+// if the node is gone
+func (rs *RCPUScheduler) decisionMetrics(nodeName string) *nodeMetrics {
+	nodeInfo, err := rs.handle.SnapshotSharedLister().NodeInfos().Get(nodeName)
+	if err != nil || nodeInfo.Node() == nil {
+		return nil
+	}
+
+	return rs.nodeMetrics(nodeInfo.Node())
+}
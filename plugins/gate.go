@@ -0,0 +1,41 @@
+package rcpu
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// FeatureGate tells the nodes the plugin acts on, by the annotation key set
+// to "true" or by their labels.
+type FeatureGate struct {
+	key string
+	// selector is nil unless nodes are also gated by their labels
+	selector labels.Selector
+}
+
+// NewFeatureGate gates the nodes annotated with key set to "true", and those
+// matching the selector unless it is nil.
+func NewFeatureGate(key string, selector *metav1.LabelSelector) (*FeatureGate, error) {
+	g := &FeatureGate{key: key}
+	if selector != nil {
+		s, err := metav1.LabelSelectorAsSelector(selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node selector: %v", err)
+		}
+		g.selector = s
+	}
+
+	return g, nil
+}
+
+// Enabled reports whether the plugin acts on the node.
+func (g *FeatureGate) Enabled(node *v1.Node) bool {
+	if node.Annotations[g.key] == "true" {
+		return true
+	}
+
+	return g.selector != nil && g.selector.Matches(labels.Set(node.Labels))
+}
@@ -0,0 +1,102 @@
+package rcpu
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+const (
+	DefaultLeaseName      = "rcpu-leader"
+	DefaultLeaseNamespace = "kube-system"
+
+	DefaultLeaseDuration = 15 * time.Second
+	DefaultRenewDeadline = 10 * time.Second
+	DefaultRetryPeriod   = 2 * time.Second
+)
+
+// LeaderElectionConfig configures the lease used by side components, such as
+// the annotator with -all-nodes, so several replicas can run for availability
+// while only one of them actuates.
+type LeaderElectionConfig struct {
+	LeaseName      string
+	LeaseNamespace string
+	// Identity defaults to the hostname, which is the pod name in Kubernetes
+	Identity string
+
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+func DefaultLeaderElectionConfig() LeaderElectionConfig {
+	return LeaderElectionConfig{
+		LeaseName:      DefaultLeaseName,
+		LeaseNamespace: DefaultLeaseNamespace,
+		LeaseDuration:  DefaultLeaseDuration,
+		RenewDeadline:  DefaultRenewDeadline,
+		RetryPeriod:    DefaultRetryPeriod,
+	}
+}
+
+// RunWithLeaderElection blocks until ctx is done, calling run whenever this
+// replica becomes the leader. The context passed to run is cancelled when
+// leadership is lost.
+func RunWithLeaderElection(ctx context.Context, client clientset.Interface, cfg LeaderElectionConfig, run func(ctx context.Context)) error {
+	identity := cfg.Identity
+	if identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("failed to get hostname for leader election identity: %v", err)
+		}
+		identity = hostname
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      cfg.LeaseName,
+			Namespace: cfg.LeaseNamespace,
+		},
+		Client: client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   cfg.LeaseDuration,
+		RenewDeadline:   cfg.RenewDeadline,
+		RetryPeriod:     cfg.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            cfg.LeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: run,
+			OnStoppedLeading: func() {
+				klog.InfoS("Lost leadership", "lease", klog.KRef(cfg.LeaseNamespace, cfg.LeaseName), "identity", identity)
+			},
+			OnNewLeader: func(leader string) {
+				if leader != identity {
+					klog.InfoS("New leader elected", "lease", klog.KRef(cfg.LeaseNamespace, cfg.LeaseName), "leader", leader)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create leader elector: %v", err)
+	}
+
+	// Run returns whenever leadership is lost, keep contending until cancelled
+	for ctx.Err() == nil {
+		elector.Run(ctx)
+	}
+
+	return nil
+}
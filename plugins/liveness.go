@@ -0,0 +1,123 @@
+package rcpu
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	applycoordinationv1 "k8s.io/client-go/applyconfigurations/coordination/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	coordinationlisters "k8s.io/client-go/listers/coordination/v1"
+)
+
+const (
+	// LivenessLeasePrefix names the lease of a node's collector, followed by
+	// the node name, so the leases can share a namespace with others
+	LivenessLeasePrefix = "rcpu-collector-"
+
+	// DefaultLivenessLeaseDuration outlasts a few missed samples, the lease
+	// is renewed at most every quarter of it
+	DefaultLivenessLeaseDuration = 40 * time.Second
+)
+
+func LivenessLeaseName(nodeName string) string {
+	return LivenessLeasePrefix + nodeName
+}
+
+// LivenessLease is the coordination.k8s.io Lease an annotator renews for a
+// node as long as the node's collector keeps reporting new samples. Unlike
+// the annotations, which stay put on a quiet node, an expired lease tells
+// that the collector died rather than that the node is idle.
+type LivenessLease struct {
+	client       clientset.Interface
+	namespace    string
+	nodeName     string
+	holder       string
+	fieldManager string
+	duration     time.Duration
+
+	renewed time.Time
+}
+
+func NewLivenessLease(client clientset.Interface, namespace, nodeName, holder string, duration time.Duration) *LivenessLease {
+	return &LivenessLease{
+		client:       client,
+		namespace:    namespace,
+		nodeName:     nodeName,
+		holder:       holder,
+		fieldManager: DefaultFieldManager,
+		duration:     duration,
+	}
+}
+
+func (l *LivenessLease) SetFieldManager(fieldManager string) {
+	l.fieldManager = fieldManager
+}
+
+// Renew applies the lease with its renew time at now, unless it was renewed
+// within a quarter of its duration, and reports whether it did.
+func (l *LivenessLease) Renew(ctx context.Context, now time.Time) (bool, error) {
+	if !l.renewed.IsZero() && now.Sub(l.renewed) < l.duration/4 {
+		return false, nil
+	}
+
+	spec := applycoordinationv1.LeaseSpec().
+		WithHolderIdentity(l.holder).
+		WithLeaseDurationSeconds(int32(math.Ceil(l.duration.Seconds()))).
+		WithRenewTime(metav1.NewMicroTime(now))
+	lease := applycoordinationv1.Lease(LivenessLeaseName(l.nodeName), l.namespace).
+		WithLabels(map[string]string{"app.kubernetes.io/name": "rcpu"}).
+		WithSpec(spec)
+
+	// The lease is the annotator's alone, a restarted annotator takes it over
+	_, err := l.client.CoordinationV1().Leases(l.namespace).Apply(ctx, lease, metav1.ApplyOptions{
+		FieldManager: l.fieldManager,
+		Force:        true,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to renew the liveness lease of node %s: %v", l.nodeName, err)
+	}
+
+	l.renewed = now
+	return true, nil
+}
+
+// leaseExpired reports whether the lease wasn't renewed within its duration,
+// a lease never renewed is expired.
+func leaseExpired(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+
+	expires := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return !now.Before(expires)
+}
+
+// LivenessLeases tells the nodes whose collector is alive from the leases of
+// the annotators.
+type LivenessLeases struct {
+	leases coordinationlisters.LeaseNamespaceLister
+}
+
+func NewLivenessLeases(leases coordinationlisters.LeaseNamespaceLister) *LivenessLeases {
+	return &LivenessLeases{leases: leases}
+}
+
+// Alive reports whether the lease of the node is held, a node without a
+// lease has no collector reporting for it.
+func (l *LivenessLeases) Alive(nodeName string, now time.Time) bool {
+	lease, err := l.leases.Get(LivenessLeaseName(nodeName))
+	if apierrors.IsNotFound(err) {
+		return false
+	} else if err != nil {
+		// The lister only fails on missing objects, keep trusting the
+		// annotations otherwise
+		return true
+	}
+
+	return !leaseExpired(lease, now)
+}
@@ -0,0 +1,802 @@
+package rcpu
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	DefaultManifestNamespace = "rcpu-system"
+	DefaultAgentName         = "rcpu-agent"
+	DefaultSchedulerName     = "rcpu-scheduler"
+	DefaultAPIServerName     = "rcpu-apiserver"
+	DefaultCleanupName       = "rcpu-cleanup"
+	DefaultWebhookName       = "rcpu-webhook"
+
+	DefaultCollectorImage = "rcpu-collector:latest"
+	DefaultAnnotatorImage = "rcpu:latest"
+	// DefaultSchedulerImage is a kube-scheduler built with the plugin
+	// registered, e.g. through app.WithPlugin(Name, New)
+	DefaultSchedulerImage = "rcpu-scheduler:latest"
+
+	// agentMetricsPort is the collector's -metrics-listen, which
+	// DefaultAnnotateSource polls
+	agentMetricsPort = 9465
+
+	apiServerPort = 6443
+	webhookPort   = 8443
+
+	webhookTLSDir = "/etc/rcpu-webhook/tls"
+
+	schedulerConfigDir  = "/etc/kubernetes/rcpu-scheduler"
+	schedulerConfigFile = "config.yaml"
+)
+
+// ManifestOptions configures the manifests of RunManifests.
+type ManifestOptions struct {
+	Namespace      string
+	AgentName      string
+	SchedulerName  string
+	APIServerName  string
+	CleanupName    string
+	WebhookName    string
+	CollectorImage string
+	AnnotatorImage string
+	SchedulerImage string
+	// PodMetricsSource is where the API server reads the usage of the pods
+	// it serves as custom metrics, none if empty
+	PodMetricsSource string
+	// WebhookCABundle verifies the webhook's certificate, cert-manager
+	// injects the CA of its Certificate if empty
+	WebhookCABundle []byte
+	// WorkloadClassFactors are the webhook's -workload-class-factors
+	WorkloadClassFactors string
+	// Args are the plugin's args in the scheduler's configuration, only the
+	// fields set are written, the plugin defaults the others
+	Args RCPUSchedulerArgs
+}
+
+// The KubeSchedulerConfiguration fields the manifests set, the scheduler's
+// own types would pull the whole scheduler in.
+type KubeSchedulerConfiguration struct {
+	metav1.TypeMeta `json:",inline"`
+
+	LeaderElection LeaderElectionConfiguration `json:"leaderElection"`
+	Profiles       []KubeSchedulerProfile      `json:"profiles"`
+}
+
+type LeaderElectionConfiguration struct {
+	LeaderElect       bool   `json:"leaderElect"`
+	ResourceName      string `json:"resourceName"`
+	ResourceNamespace string `json:"resourceNamespace"`
+}
+
+type KubeSchedulerProfile struct {
+	SchedulerName string         `json:"schedulerName"`
+	Plugins       Plugins        `json:"plugins"`
+	PluginConfig  []PluginConfig `json:"pluginConfig"`
+}
+
+type Plugins struct {
+	MultiPoint PluginSet `json:"multiPoint"`
+}
+
+type PluginSet struct {
+	Enabled []PluginRef `json:"enabled"`
+}
+
+type PluginRef struct {
+	Name string `json:"name"`
+}
+
+type PluginConfig struct {
+	Name string            `json:"name"`
+	Args RCPUSchedulerArgs `json:"args"`
+}
+
+// The APIService of kube-aggregator, whose types would pull the aggregator in.
+type APIService struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+
+	Spec APIServiceSpec `json:"spec"`
+}
+
+type APIServiceSpec struct {
+	Service               ServiceReference `json:"service"`
+	Group                 string           `json:"group"`
+	Version               string           `json:"version"`
+	InsecureSkipTLSVerify bool             `json:"insecureSkipTLSVerify"`
+	GroupPriorityMinimum  int32            `json:"groupPriorityMinimum"`
+	VersionPriority       int32            `json:"versionPriority"`
+}
+
+type ServiceReference struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Port      int32  `json:"port"`
+}
+
+func manifestLabels(component string) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":      "rcpu",
+		"app.kubernetes.io/component": component,
+	}
+}
+
+func manifestMeta(name, namespace, component string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: manifestLabels(component)}
+}
+
+// NewAgentManifests returns the per-node agent: the collector reading the
+// host's procfs and sysfs, and the annotator publishing its samples on the
+// node, with the annotator's permissions.
+func NewAgentManifests(opts ManifestOptions) []any {
+	const component = "agent"
+
+	sa := &v1.ServiceAccount{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+		ObjectMeta: manifestMeta(opts.AgentName, opts.Namespace, component),
+	}
+
+	// Server-side apply is a patch, events are for -events
+	role := &rbacv1.ClusterRole{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+		ObjectMeta: manifestMeta(opts.AgentName, "", component),
+		Rules: []rbacv1.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"nodes"}, Verbs: []string{"patch"}},
+			{APIGroups: []string{"", "events.k8s.io"}, Resources: []string{"events"}, Verbs: []string{"create", "patch"}},
+		},
+	}
+
+	binding := &rbacv1.ClusterRoleBinding{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"},
+		ObjectMeta: manifestMeta(opts.AgentName, "", component),
+		RoleRef:    rbacv1.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "ClusterRole", Name: role.Name},
+		Subjects:   []rbacv1.Subject{{Kind: "ServiceAccount", Name: sa.Name, Namespace: opts.Namespace}},
+	}
+
+	nodeName := v1.EnvVar{
+		Name:      NodeNameEnv,
+		ValueFrom: &v1.EnvVarSource{FieldRef: &v1.ObjectFieldSelector{FieldPath: "spec.nodeName"}},
+	}
+
+	hostPath := func(name, path string) v1.Volume {
+		return v1.Volume{Name: name, VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: path}}}
+	}
+
+	annotator := v1.Container{
+		Name:    "annotator",
+		Image:   opts.AnnotatorImage,
+		Command: []string{"rcpu", "annotate"},
+		Env:     []v1.EnvVar{nodeName},
+	}
+	objects := []any{sa, role, binding}
+
+	if namespace := opts.Args.LivenessLeaseNamespace; namespace != "" {
+		annotator.Args = []string{"-liveness-lease-namespace=" + namespace}
+
+		// Server-side apply creates the leases through a patch too
+		leaseRole := &rbacv1.Role{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+			ObjectMeta: manifestMeta(opts.AgentName, namespace, component),
+			Rules: []rbacv1.PolicyRule{
+				{APIGroups: []string{"coordination.k8s.io"}, Resources: []string{"leases"}, Verbs: []string{"create", "patch"}},
+			},
+		}
+		objects = append(objects, leaseRole, &rbacv1.RoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+			ObjectMeta: manifestMeta(opts.AgentName, namespace, component),
+			RoleRef:    rbacv1.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "Role", Name: leaseRole.Name},
+			Subjects:   binding.Subjects,
+		})
+	}
+
+	daemonSet := &appsv1.DaemonSet{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "DaemonSet"},
+		ObjectMeta: manifestMeta(opts.AgentName, opts.Namespace, component),
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: manifestLabels(component)},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: manifestLabels(component)},
+				Spec: v1.PodSpec{
+					ServiceAccountName: sa.Name,
+					// Every node is measured, tainted or not
+					Tolerations: []v1.Toleration{{Operator: v1.TolerationOpExists}},
+					Containers: []v1.Container{
+						{
+							Name:  "collector",
+							Image: opts.CollectorImage,
+							Args: []string{
+								fmt.Sprintf("-metrics-listen=:%d", agentMetricsPort),
+								"-proc-root=/host/proc",
+								"-sys-root=/host/sys",
+								"-output=json",
+							},
+							Env:   []v1.EnvVar{nodeName},
+							Ports: []v1.ContainerPort{{Name: "metrics", ContainerPort: agentMetricsPort}},
+							VolumeMounts: []v1.VolumeMount{
+								{Name: "proc", MountPath: "/host/proc", ReadOnly: true},
+								{Name: "sys", MountPath: "/host/sys", ReadOnly: true},
+							},
+						},
+						annotator,
+					},
+					Volumes: []v1.Volume{hostPath("proc", "/proc"), hostPath("sys", "/sys")},
+				},
+			},
+		},
+	}
+
+	return append(objects, daemonSet)
+}
+
+// NewSchedulerManifests returns a second scheduler running the plugin under
+// its own name, its configuration and its permissions: those of the default
+// scheduler, its own leader election lease, and the placement ConfigMap.
+func NewSchedulerManifests(opts ManifestOptions) ([]any, error) {
+	const component = "scheduler"
+
+	sa := &v1.ServiceAccount{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+		ObjectMeta: manifestMeta(opts.SchedulerName, opts.Namespace, component),
+	}
+	subjects := []rbacv1.Subject{{Kind: "ServiceAccount", Name: sa.Name, Namespace: opts.Namespace}}
+
+	objects := []any{sa}
+	for _, role := range []string{"system:kube-scheduler", "system:volume-scheduler"} {
+		objects = append(objects, &rbacv1.ClusterRoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"},
+			ObjectMeta: manifestMeta(opts.SchedulerName+"-"+strings.TrimPrefix(role, "system:"), "", component),
+			RoleRef:    rbacv1.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "ClusterRole", Name: role},
+			Subjects:   subjects,
+		})
+	}
+
+	objects = append(objects, &rbacv1.RoleBinding{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+		ObjectMeta: manifestMeta(opts.SchedulerName+"-authentication-reader", "kube-system", component),
+		RoleRef:    rbacv1.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "Role", Name: "extension-apiserver-authentication-reader"},
+		Subjects:   subjects,
+	})
+
+	// system:kube-scheduler only covers the lease of the default scheduler
+	namespacedRole := func(namespace string, rules []rbacv1.PolicyRule) []any {
+		role := &rbacv1.Role{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+			ObjectMeta: manifestMeta(opts.SchedulerName, namespace, component),
+			Rules:      rules,
+		}
+
+		return []any{role, &rbacv1.RoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+			ObjectMeta: manifestMeta(opts.SchedulerName, namespace, component),
+			RoleRef:    rbacv1.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "Role", Name: role.Name},
+			Subjects:   subjects,
+		}}
+	}
+
+	rules := []rbacv1.PolicyRule{
+		{APIGroups: []string{"coordination.k8s.io"}, Resources: []string{"leases"}, Verbs: []string{"create"}},
+		{APIGroups: []string{"coordination.k8s.io"}, Resources: []string{"leases"}, ResourceNames: []string{opts.SchedulerName}, Verbs: []string{"get", "update"}},
+	}
+
+	// Rules outside of the scheduler's namespace get a role of their own
+	var otherNamespaces []string
+	otherRules := make(map[string][]rbacv1.PolicyRule)
+	addRule := func(namespace string, rule rbacv1.PolicyRule) {
+		if namespace == opts.Namespace {
+			rules = append(rules, rule)
+			return
+		}
+
+		if _, ok := otherRules[namespace]; !ok {
+			otherNamespaces = append(otherNamespaces, namespace)
+		}
+		otherRules[namespace] = append(otherRules[namespace], rule)
+	}
+
+	if opts.Args.PlacementConfigMap != "" {
+		namespace, name, err := ParseConfigMapRef(opts.Args.PlacementConfigMap)
+		if err != nil {
+			return nil, err
+		}
+
+		// Server-side apply creates the ConfigMap through a patch too
+		addRule(namespace, rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"configmaps"}, ResourceNames: []string{name}, Verbs: []string{"patch"}})
+	}
+
+	if opts.Args.PolicyConfigMap != "" {
+		namespace, name, err := ParseConfigMapRef(opts.Args.PolicyConfigMap)
+		if err != nil {
+			return nil, err
+		}
+
+		addRule(namespace, rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"configmaps"}, ResourceNames: []string{name}, Verbs: []string{"get", "list", "watch"}})
+	}
+
+	// The liveness leases are watched rather than read one by one
+	if namespace := opts.Args.LivenessLeaseNamespace; namespace != "" {
+		addRule(namespace, rbacv1.PolicyRule{APIGroups: []string{"coordination.k8s.io"}, Resources: []string{"leases"}, Verbs: []string{"get", "list", "watch"}})
+	}
+
+	objects = append(objects, namespacedRole(opts.Namespace, rules)...)
+	for _, namespace := range otherNamespaces {
+		objects = append(objects, namespacedRole(namespace, otherRules[namespace])...)
+	}
+
+	config := &KubeSchedulerConfiguration{
+		TypeMeta: metav1.TypeMeta{APIVersion: "kubescheduler.config.k8s.io/v1", Kind: "KubeSchedulerConfiguration"},
+		LeaderElection: LeaderElectionConfiguration{
+			LeaderElect:       true,
+			ResourceName:      opts.SchedulerName,
+			ResourceNamespace: opts.Namespace,
+		},
+		Profiles: []KubeSchedulerProfile{{
+			SchedulerName: opts.SchedulerName,
+			Plugins:       Plugins{MultiPoint: PluginSet{Enabled: []PluginRef{{Name: Name}}}},
+			PluginConfig:  []PluginConfig{{Name: Name, Args: opts.Args}},
+		}},
+	}
+
+	configYAML, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the scheduler configuration: %v", err)
+	}
+
+	configMap := &v1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: manifestMeta(opts.SchedulerName+"-config", opts.Namespace, component),
+		Data:       map[string]string{schedulerConfigFile: string(configYAML)},
+	}
+
+	replicas := int32(1)
+	deployment := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: manifestMeta(opts.SchedulerName, opts.Namespace, component),
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: manifestLabels(component)},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: manifestLabels(component)},
+				Spec: v1.PodSpec{
+					ServiceAccountName: sa.Name,
+					Containers: []v1.Container{{
+						Name:         "scheduler",
+						Image:        opts.SchedulerImage,
+						Command:      []string{"kube-scheduler", "--config=" + schedulerConfigDir + "/" + schedulerConfigFile},
+						VolumeMounts: []v1.VolumeMount{{Name: "config", MountPath: schedulerConfigDir, ReadOnly: true}},
+					}},
+					Volumes: []v1.Volume{{
+						Name: "config",
+						VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{
+							LocalObjectReference: v1.LocalObjectReference{Name: configMap.Name},
+						}},
+					}},
+				},
+			},
+		},
+	}
+
+	return append(objects, configMap, deployment), nil
+}
+
+// NewAPIServerManifests returns the aggregated API server of NodeRCPU, its
+// APIService, and its permissions: reading the nodes, delegating the
+// authentication and authorization of the requests to kube-apiserver, and a
+// ClusterRole reading nodercpus aggregated to the view role. With a pod
+// metrics source it also registers the custom metrics API.
+func NewAPIServerManifests(opts ManifestOptions) []any {
+	const component = "apiserver"
+
+	sa := &v1.ServiceAccount{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+		ObjectMeta: manifestMeta(opts.APIServerName, opts.Namespace, component),
+	}
+	subjects := []rbacv1.Subject{{Kind: "ServiceAccount", Name: sa.Name, Namespace: opts.Namespace}}
+
+	role := &rbacv1.ClusterRole{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+		ObjectMeta: manifestMeta(opts.APIServerName, "", component),
+		Rules: []rbacv1.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"nodes"}, Verbs: []string{"get", "list", "watch"}},
+		},
+	}
+	command := []string{"rcpu", "apiserver", fmt.Sprintf("-listen=:%d", apiServerPort)}
+	if opts.PodMetricsSource != "" {
+		// The HPA selects the pods by their labels
+		role.Rules = append(role.Rules, rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get", "list", "watch"}})
+		command = append(command, "-source="+opts.PodMetricsSource)
+	}
+
+	objects := []any{sa, role}
+	for _, ref := range []rbacv1.RoleRef{
+		{APIGroup: "rbac.authorization.k8s.io", Kind: "ClusterRole", Name: role.Name},
+		// SubjectAccessReviews
+		{APIGroup: "rbac.authorization.k8s.io", Kind: "ClusterRole", Name: "system:auth-delegator"},
+	} {
+		name := opts.APIServerName
+		if ref.Name != role.Name {
+			name += "-" + strings.TrimPrefix(ref.Name, "system:")
+		}
+		objects = append(objects, &rbacv1.ClusterRoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"},
+			ObjectMeta: manifestMeta(name, "", component),
+			RoleRef:    ref,
+			Subjects:   subjects,
+		})
+	}
+
+	objects = append(objects, &rbacv1.RoleBinding{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+		ObjectMeta: manifestMeta(opts.APIServerName+"-authentication-reader", authenticationConfigMapNamespace, component),
+		RoleRef:    rbacv1.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "Role", Name: "extension-apiserver-authentication-reader"},
+		Subjects:   subjects,
+	})
+
+	readerLabels := manifestLabels(component)
+	for _, aggregate := range []string{"view", "edit", "admin"} {
+		readerLabels["rbac.authorization.k8s.io/aggregate-to-"+aggregate] = "true"
+	}
+	reader := &rbacv1.ClusterRole{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+		ObjectMeta: metav1.ObjectMeta{Name: opts.APIServerName + "-reader", Labels: readerLabels},
+		Rules: []rbacv1.PolicyRule{
+			{APIGroups: []string{NodeRCPUGroup}, Resources: []string{NodeRCPUResource}, Verbs: []string{"get", "list"}},
+		},
+	}
+
+	replicas := int32(1)
+	deployment := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: manifestMeta(opts.APIServerName, opts.Namespace, component),
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: manifestLabels(component)},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: manifestLabels(component)},
+				Spec: v1.PodSpec{
+					ServiceAccountName: sa.Name,
+					Containers: []v1.Container{{
+						Name:    "apiserver",
+						Image:   opts.AnnotatorImage,
+						Command: command,
+						Ports:   []v1.ContainerPort{{Name: "https", ContainerPort: apiServerPort}},
+					}},
+				},
+			},
+		},
+	}
+
+	service := &v1.Service{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: manifestMeta(opts.APIServerName, opts.Namespace, component),
+		Spec: v1.ServiceSpec{
+			Selector: manifestLabels(component),
+			Ports:    []v1.ServicePort{{Name: "https", Port: 443, TargetPort: intstr.FromString("https")}},
+		},
+	}
+
+	// The server generates a self-signed certificate, there is no CA bundle
+	// to verify it with
+	apiService := func(gv schema.GroupVersion) *APIService {
+		return &APIService{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apiregistration.k8s.io/v1", Kind: "APIService"},
+			ObjectMeta: manifestMeta(gv.Version+"."+gv.Group, "", component),
+			Spec: APIServiceSpec{
+				Service:               ServiceReference{Namespace: opts.Namespace, Name: service.Name, Port: 443},
+				Group:                 gv.Group,
+				Version:               gv.Version,
+				InsecureSkipTLSVerify: true,
+				GroupPriorityMinimum:  100,
+				VersionPriority:       100,
+			},
+		}
+	}
+
+	objects = append(objects, reader, deployment, service, apiService(nodeRCPUGroupVersion))
+	if opts.PodMetricsSource != "" {
+		objects = append(objects, apiService(customMetricsGroupVersion))
+	}
+
+	return objects
+}
+
+// NewCleanupManifests returns the controller removing the metrics of the
+// nodes whose liveness lease expired, and its permissions: watching the leases
+// and patching the nodes. The validating webhook has to allow its service
+// account too.
+func NewCleanupManifests(opts ManifestOptions) ([]any, error) {
+	const component = "cleanup"
+
+	leaseNamespace := opts.Args.LivenessLeaseNamespace
+	if leaseNamespace == "" {
+		return nil, fmt.Errorf("the cleanup component requires -liveness-lease-namespace")
+	}
+
+	sa := &v1.ServiceAccount{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+		ObjectMeta: manifestMeta(opts.CleanupName, opts.Namespace, component),
+	}
+	subjects := []rbacv1.Subject{{Kind: "ServiceAccount", Name: sa.Name, Namespace: opts.Namespace}}
+
+	role := &rbacv1.ClusterRole{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+		ObjectMeta: manifestMeta(opts.CleanupName, "", component),
+		Rules: []rbacv1.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"nodes"}, Verbs: []string{"get", "list", "watch", "patch"}},
+		},
+	}
+
+	leaseRole := &rbacv1.Role{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+		ObjectMeta: manifestMeta(opts.CleanupName, leaseNamespace, component),
+		Rules: []rbacv1.PolicyRule{
+			{APIGroups: []string{"coordination.k8s.io"}, Resources: []string{"leases"}, Verbs: []string{"get", "list", "watch"}},
+		},
+	}
+
+	command := []string{"rcpu", "cleanup", "-liveness-lease-namespace=" + leaseNamespace}
+	if opts.Args.FeatureGateKey != "" {
+		command = append(command, "-feature-gate-key="+opts.Args.FeatureGateKey)
+	}
+
+	replicas := int32(1)
+	deployment := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: manifestMeta(opts.CleanupName, opts.Namespace, component),
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: manifestLabels(component)},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: manifestLabels(component)},
+				Spec: v1.PodSpec{
+					ServiceAccountName: sa.Name,
+					Containers: []v1.Container{{
+						Name:    "cleanup",
+						Image:   opts.AnnotatorImage,
+						Command: command,
+					}},
+				},
+			},
+		},
+	}
+
+	return []any{
+		sa,
+		role,
+		&rbacv1.ClusterRoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"},
+			ObjectMeta: manifestMeta(opts.CleanupName, "", component),
+			RoleRef:    rbacv1.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "ClusterRole", Name: role.Name},
+			Subjects:   subjects,
+		},
+		leaseRole,
+		&rbacv1.RoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+			ObjectMeta: manifestMeta(opts.CleanupName, leaseNamespace, component),
+			RoleRef:    rbacv1.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "Role", Name: leaseRole.Name},
+			Subjects:   subjects,
+		},
+		deployment,
+	}, nil
+}
+
+// NewWebhookManifests returns the admission webhooks and their
+// configurations: the DemandWebhook annotating the pods created with their
+// estimated demand, and the AnnotationValidator letting only the agent and the
+// cleanup controller write the annotations of the nodes. The serving certificate is the Secret named after the
+// webhook with -tls, e.g. of a cert-manager Certificate of the same name.
+func NewWebhookManifests(opts ManifestOptions) []any {
+	const component = "webhook"
+
+	sa := &v1.ServiceAccount{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+		ObjectMeta: manifestMeta(opts.WebhookName, opts.Namespace, component),
+	}
+
+	allowedUsers := []string{
+		fmt.Sprintf("system:serviceaccount:%s:%s", opts.Namespace, opts.AgentName),
+		fmt.Sprintf("system:serviceaccount:%s:%s", opts.Namespace, opts.CleanupName),
+	}
+	command := []string{"rcpu", "webhook", fmt.Sprintf("-listen=:%d", webhookPort),
+		"-tls-cert-file=" + webhookTLSDir + "/tls.crt", "-tls-key-file=" + webhookTLSDir + "/tls.key",
+		"-allowed-users=" + strings.Join(allowedUsers, ",")}
+	if opts.WorkloadClassFactors != "" {
+		command = append(command, "-workload-class-factors="+opts.WorkloadClassFactors)
+	}
+
+	replicas := int32(1)
+	deployment := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: manifestMeta(opts.WebhookName, opts.Namespace, component),
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: manifestLabels(component)},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: manifestLabels(component)},
+				Spec: v1.PodSpec{
+					ServiceAccountName: sa.Name,
+					Containers: []v1.Container{{
+						Name:    "webhook",
+						Image:   opts.AnnotatorImage,
+						Command: command,
+						Ports:   []v1.ContainerPort{{Name: "https", ContainerPort: webhookPort}},
+						VolumeMounts: []v1.VolumeMount{
+							{Name: "tls", MountPath: webhookTLSDir, ReadOnly: true},
+						},
+					}},
+					Volumes: []v1.Volume{
+						{Name: "tls", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: opts.WebhookName + "-tls"}}},
+					},
+				},
+			},
+		},
+	}
+
+	service := &v1.Service{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: manifestMeta(opts.WebhookName, opts.Namespace, component),
+		Spec: v1.ServiceSpec{
+			Selector: manifestLabels(component),
+			Ports:    []v1.ServicePort{{Name: "https", Port: 443, TargetPort: intstr.FromString("https")}},
+		},
+	}
+
+	configMeta := manifestMeta(opts.WebhookName, "", component)
+	if len(opts.WebhookCABundle) == 0 {
+		configMeta.Annotations = map[string]string{"cert-manager.io/inject-ca-from": opts.Namespace + "/" + opts.WebhookName}
+	}
+	clientConfig := func(path string) admissionregistrationv1.WebhookClientConfig {
+		port := int32(443)
+		return admissionregistrationv1.WebhookClientConfig{
+			Service:  &admissionregistrationv1.ServiceReference{Namespace: opts.Namespace, Name: service.Name, Path: &path, Port: &port},
+			CABundle: opts.WebhookCABundle,
+		}
+	}
+	sideEffects := admissionregistrationv1.SideEffectClassNone
+	timeout := int32(5)
+
+	// Never block pod creation because of the estimate
+	ignore := admissionregistrationv1.Ignore
+	mutating := &admissionregistrationv1.MutatingWebhookConfiguration{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "admissionregistration.k8s.io/v1", Kind: "MutatingWebhookConfiguration"},
+		ObjectMeta: configMeta,
+		Webhooks: []admissionregistrationv1.MutatingWebhook{{
+			Name:         "demand.rcpu-scheduler.solelab.tech",
+			ClientConfig: clientConfig(DemandWebhookPath),
+			Rules: []admissionregistrationv1.RuleWithOperations{{
+				Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+				Rule:       admissionregistrationv1.Rule{APIGroups: []string{""}, APIVersions: []string{"v1"}, Resources: []string{"pods"}},
+			}},
+			FailurePolicy:           &ignore,
+			SideEffects:             &sideEffects,
+			TimeoutSeconds:          &timeout,
+			AdmissionReviewVersions: []string{"v1"},
+		}},
+	}
+
+	// Reject the annotations of anyone else even while the webhook is down
+	fail := admissionregistrationv1.Fail
+	validating := &admissionregistrationv1.ValidatingWebhookConfiguration{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "admissionregistration.k8s.io/v1", Kind: "ValidatingWebhookConfiguration"},
+		ObjectMeta: configMeta,
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{{
+			Name:         "annotations.rcpu-scheduler.solelab.tech",
+			ClientConfig: clientConfig(AnnotationValidatorPath),
+			Rules: []admissionregistrationv1.RuleWithOperations{{
+				Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update},
+				Rule:       admissionregistrationv1.Rule{APIGroups: []string{""}, APIVersions: []string{"v1"}, Resources: []string{"nodes"}},
+			}},
+			FailurePolicy:           &fail,
+			SideEffects:             &sideEffects,
+			TimeoutSeconds:          &timeout,
+			AdmissionReviewVersions: []string{"v1"},
+		}},
+	}
+
+	return []any{sa, deployment, service, mutating, validating}
+}
+
+// WriteManifests writes the objects as a multi-document YAML stream.
+func WriteManifests(w io.Writer, objects []any) error {
+	for _, obj := range objects {
+		out, err := yaml.Marshal(obj)
+		if err != nil {
+			return fmt.Errorf("failed to encode manifest: %v", err)
+		}
+
+		if _, err := fmt.Fprintf(w, "---\n%s", out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func RunManifests(args []string) error {
+	fs := flag.NewFlagSet("manifests", flag.ExitOnError)
+	opts := ManifestOptions{}
+	fs.StringVar(&opts.Namespace, "namespace", DefaultManifestNamespace, "namespace of the agent, the scheduler and the API server")
+	fs.StringVar(&opts.AgentName, "agent-name", DefaultAgentName, "name of the agent's DaemonSet and service account")
+	fs.StringVar(&opts.SchedulerName, "scheduler-name", DefaultSchedulerName, "schedulerName of the pods the scheduler schedules, and name of its Deployment")
+	fs.StringVar(&opts.APIServerName, "apiserver-name", DefaultAPIServerName, "name of the NodeRCPU API server's Deployment and Service")
+	fs.StringVar(&opts.CleanupName, "cleanup-name", DefaultCleanupName, "name of the cleanup controller's Deployment and service account")
+	fs.StringVar(&opts.WebhookName, "webhook-name", DefaultWebhookName, "name of the webhooks' Deployment, Service, configurations and certificate")
+	webhookCAFile := fs.String("webhook-ca-file", "", "PEM CA of the webhook's certificate, cert-manager injects the CA of the webhook's Certificate if empty")
+	fs.StringVar(&opts.WorkloadClassFactors, "workload-class-factors", "", "the demand webhook's factors of the pods' CPU requests by workload class, e.g. batch=0.5,latency=1.5")
+	fs.StringVar(&opts.CollectorImage, "collector-image", DefaultCollectorImage, "image of the collector")
+	fs.StringVar(&opts.AnnotatorImage, "annotator-image", DefaultAnnotatorImage, "image of the rcpu command")
+	fs.StringVar(&opts.SchedulerImage, "scheduler-image", DefaultSchedulerImage, "image of a kube-scheduler built with the plugin")
+	fs.StringVar(&opts.PodMetricsSource, "pod-metrics-source", "", "samples the API server serves the usage of the pods of as custom metrics, e.g. the aggregator's /v1/samples")
+	components := fs.String("components", "agent,scheduler", "comma separated components to write, agent, scheduler, apiserver, cleanup and webhook")
+	fs.StringVar(&opts.Args.Mode, "mode", "", "the plugin's mode, defaults to "+DefaultMode)
+	fs.StringVar(&opts.Args.Scoring, "scoring", "", "the plugin's scoring, defaults to "+DefaultScoring)
+	fs.StringVar(&opts.Args.PlacementConfigMap, "placement-config-map", "", "namespace/name of the ConfigMap the plugin records the placements in, with the permissions to")
+	fs.BoolVar(&opts.Args.DryRun, "dry-run", false, "run the plugin's filter in dry run")
+	fs.StringVar(&opts.Args.PolicyConfigMap, "policy-config-map", "", "namespace/name of the ConfigMap the plugin reloads its policy from, with the permissions to watch it")
+	fs.StringVar(&opts.Args.LivenessLeaseNamespace, "liveness-lease-namespace", "", "namespace the annotators renew their liveness leases in, and the plugin watches them in")
+	fs.Parse(args)
+
+	// Validate a copy, the defaults are left to the plugin
+	validated := opts.Args
+	if err := ValidateArgs(&validated); err != nil {
+		return err
+	}
+
+	if _, err := ParseWorkloadClassFactors(opts.WorkloadClassFactors); err != nil {
+		return err
+	}
+
+	if *webhookCAFile != "" {
+		var err error
+		if opts.WebhookCABundle, err = os.ReadFile(*webhookCAFile); err != nil {
+			return fmt.Errorf("failed to read the webhook's CA: %v", err)
+		}
+	}
+
+	var objects []any
+	for _, component := range strings.Split(*components, ",") {
+		switch strings.TrimSpace(component) {
+		case "agent":
+			objects = append(objects, NewAgentManifests(opts)...)
+		case "scheduler":
+			scheduler, err := NewSchedulerManifests(opts)
+			if err != nil {
+				return err
+			}
+			objects = append(objects, scheduler...)
+		case "apiserver":
+			objects = append(objects, NewAPIServerManifests(opts)...)
+		case "cleanup":
+			cleanup, err := NewCleanupManifests(opts)
+			if err != nil {
+				return err
+			}
+			objects = append(objects, cleanup...)
+		case "webhook":
+			objects = append(objects, NewWebhookManifests(opts)...)
+		default:
+			return fmt.Errorf("unknown component %q, expected agent, scheduler, apiserver, cleanup or webhook", component)
+		}
+	}
+
+	namespace := &v1.Namespace{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+		ObjectMeta: metav1.ObjectMeta{Name: opts.Namespace, Labels: map[string]string{"app.kubernetes.io/name": "rcpu"}},
+	}
+
+	return WriteManifests(os.Stdout, append([]any{namespace}, objects...))
+}
@@ -0,0 +1,86 @@
+package rcpu
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+// metricsSubsystem prefixes the plugin's metrics, served with the scheduler's
+const metricsSubsystem = "rcpu_scheduler"
+
+var (
+	metricsCacheRequests = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "metrics_cache_requests_total",
+			Help:           "Lookups of the parsed node metrics by result, hit or miss.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"result"},
+	)
+
+	dryRunRejections = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "dry_run_rejections_total",
+			Help:           "Nodes Filter would have rejected for a pod, but passed in dry run.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+
+	// extensionPointDuration times the plugin alone, the scheduler's own
+	// plugin_execution_duration_seconds only samples a fraction of the cycles
+	extensionPointDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Subsystem: metricsSubsystem,
+			Name:      "extension_point_duration_seconds",
+			Help:      "Duration of the plugin at every extension point.",
+			// 1µs to about 16ms
+			Buckets:        metrics.ExponentialBuckets(0.000001, 2, 15),
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"extension_point"},
+	)
+
+	// annotationAge tells how fresh the annotations Filter decides on are,
+	// they age with the annotator's -max-interval on a steady node
+	annotationAge = metrics.NewHistogram(
+		&metrics.HistogramOpts{
+			Subsystem: metricsSubsystem,
+			Name:      "annotation_age_seconds",
+			Help:      "Age of the node annotations Filter decided on, from their timestamp.",
+			// 1s to about 34 minutes
+			Buckets:        metrics.ExponentialBuckets(1, 2, 12),
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+
+	policyReloads = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "policy_reloads_total",
+			Help:           "Updates of the policy ConfigMap by result, applied or invalid.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"result"},
+	)
+
+	registerMetricsOnce sync.Once
+)
+
+// registerMetrics registers the metrics once, however many profiles use the
+// plugin.
+func registerMetrics() {
+	registerMetricsOnce.Do(func() {
+		legacyregistry.MustRegister(metricsCacheRequests, dryRunRejections, extensionPointDuration, annotationAge, policyReloads)
+	})
+}
+
+// observeDuration records the duration of an extension point since start,
+// deferred at its beginning
+func observeDuration(extensionPoint string, start time.Time) {
+	extensionPointDuration.WithLabelValues(extensionPoint).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,227 @@
+package rcpu
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// DefaultMetricsCacheTTL bounds how long parsed metrics outlive a missed
+// node update, updates already invalidate them
+const DefaultMetricsCacheTTL = 30 * time.Second
+
+// nodeMetrics are the annotations of a node Filter and Score use, parsed.
+// enabled follows the default feature gate, the plugin applies its own.
+type nodeMetrics struct {
+	enabled bool
+	// rcpu has the metrics that parsed, clamped by getRCPU, and avg the
+	// plain average utilizations
+	rcpu         map[string]int64
+	avg          map[string]int64
+	freeCores    int64
+	hasFreeCores bool
+	// provider is empty for the annotator, see RCPUProviderKey
+	provider string
+	// written is zero unless the annotations are stamped, see
+	// StampAnnotations
+	written time.Time
+	// unknown is set when the collector's liveness lease expired, or the
+	// schema, the range or the payload of the annotations isn't supported,
+	// the metrics are dropped then
+	unknown bool
+}
+
+func parseNodeMetrics(annotations map[string]string) *nodeMetrics {
+	m := &nodeMetrics{
+		enabled: annotations[RCPUFeatureGateKey] == "true",
+		rcpu:    make(map[string]int64, len(metricWindows)),
+		avg:     make(map[string]int64, len(metricWindows)),
+	}
+
+	// A newer annotator can't be read by guessing, and a payload failing
+	// validation isn't trusted in part
+	annotations, ok := expandPayload(annotations)
+	if !ok || !supportedSchema(annotationSchema(annotations)) {
+		m.unknown = true
+		return m
+	}
+	if _, ok := annotationRange(annotations); !ok {
+		m.unknown = true
+		return m
+	}
+	m.provider = annotations[RCPUProviderKey]
+
+	for _, w := range metricWindows {
+		if rcpu, ok := getRCPU(annotations, w.key); ok {
+			m.rcpu[w.key] = rcpu
+		}
+		if avg, ok := getRCPU(annotations, w.avgKey); ok {
+			m.avg[w.avgKey] = avg
+		}
+	}
+	m.freeCores, m.hasFreeCores = getFreeCores(annotations)
+	if timestamp, err := strconv.ParseInt(annotations[RCPUTimestampKey], 10, 64); err == nil {
+		m.written = time.Unix(timestamp, 0)
+	}
+
+	return m
+}
+
+// filter is the verdict of Filter: nodes without the feature gate or the
+// metric pass.
+func (m *nodeMetrics) filter(metric string, threshold int64) bool {
+	if !m.enabled {
+		return true
+	}
+
+	rcpu, ok := m.rcpu[metric]
+	return !ok || rcpu < threshold
+}
+
+// score is the per-mille RCPU score. Nodes without the feature gate or with
+// unknown metrics score 0, a node with the gate but without the metric can't
+// be scored.
+func (m *nodeMetrics) score(metric string) (int64, bool) {
+	if !m.enabled || m.unknown {
+		return 0, true
+	}
+
+	rcpu, ok := m.rcpu[metric]
+	if !ok {
+		return 0, false
+	}
+
+	return max(0, RCPUMaxScore-rcpu), true
+}
+
+// avgScore is the per-mille headroom of the plain average utilization over
+// the window of the metric, false unless the node publishes it.
+func (m *nodeMetrics) avgScore(metric string) (int64, bool) {
+	if !m.enabled || m.unknown {
+		return 0, false
+	}
+
+	avg, ok := m.avg[avgMetricKey(metric)]
+	if !ok {
+		return 0, false
+	}
+
+	return max(0, RCPUMaxScore-avg), true
+}
+
+// freeCoresScore is the raw score of the FreeCores provider, the free cores
+// themselves, normalized across the nodes by NormalizeScore. Nodes without
+// the feature gate or the annotation score 0.
+func (m *nodeMetrics) freeCoresScore() int64 {
+	if !m.enabled || !m.hasFreeCores {
+		return 0
+	}
+
+	return m.freeCores
+}
+
+type nodeMetricsEntry struct {
+	metrics *nodeMetrics
+	expires time.Time
+	// resourceVersion is the version of the node the metrics were parsed from
+	resourceVersion string
+}
+
+// NodeMetricsCache keeps the parsed metrics of every node, instead of parsing
+// the annotations of the node again for every pod. Entries are dropped as the
+// informer sees their node change, and expire after the TTL in case an event
+// was missed.
+type NodeMetricsCache struct {
+	ttl time.Duration
+
+	mu      sync.RWMutex
+	entries map[string]nodeMetricsEntry
+}
+
+func NewNodeMetricsCache(ttl time.Duration) *NodeMetricsCache {
+	return &NodeMetricsCache{ttl: ttl, entries: make(map[string]nodeMetricsEntry)}
+}
+
+// Get returns the parsed metrics of the node, parsing them on a miss. An
+// entry of another version of the node is a miss, so a scheduling cycle
+// still on an older snapshot doesn't bring back the annotations an update
+// invalidated.
+func (c *NodeMetricsCache) Get(node *v1.Node, now time.Time) *nodeMetrics {
+	c.mu.RLock()
+	entry, ok := c.entries[node.Name]
+	c.mu.RUnlock()
+	if ok && now.Before(entry.expires) && entry.resourceVersion == node.ResourceVersion {
+		metricsCacheRequests.WithLabelValues("hit").Inc()
+		return entry.metrics
+	}
+
+	metricsCacheRequests.WithLabelValues("miss").Inc()
+	metrics := parseNodeMetrics(node.Annotations)
+
+	c.mu.Lock()
+	c.entries[node.Name] = nodeMetricsEntry{metrics: metrics, expires: now.Add(c.ttl), resourceVersion: node.ResourceVersion}
+	c.mu.Unlock()
+
+	return metrics
+}
+
+// Invalidate drops the metrics of the node.
+func (c *NodeMetricsCache) Invalidate(nodeName string) {
+	c.mu.Lock()
+	delete(c.entries, nodeName)
+	c.mu.Unlock()
+}
+
+// EventHandler invalidates the nodes the informer sees updated or deleted.
+func (c *NodeMetricsCache) EventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj any) {
+			oldNode, ok1 := oldObj.(*v1.Node)
+			node, ok2 := newObj.(*v1.Node)
+			// Status updates don't touch the metrics
+			if ok1 && ok2 && len(changedRCPUAnnotations(oldNode.Annotations, node.Annotations)) == 0 {
+				return
+			}
+			if ok2 {
+				c.Invalidate(node.Name)
+			}
+		},
+		DeleteFunc: func(obj any) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if node, ok := obj.(*v1.Node); ok {
+				c.Invalidate(node.Name)
+			}
+		},
+	}
+}
+
+// nodeMetrics returns the parsed metrics of the node, from the cache unless
+// it is disabled, gated by the plugin's feature gate.
+func (rs *RCPUScheduler) nodeMetrics(node *v1.Node) *nodeMetrics {
+	var m *nodeMetrics
+	if rs.metricsCache == nil {
+		m = parseNodeMetrics(node.Annotations)
+	} else {
+		m = rs.metricsCache.Get(node, time.Now())
+	}
+
+	// The cached metrics are shared, and the labels of the node aren't
+	// watched, so the gate is applied to a copy
+	if enabled := rs.gate.Enabled(node); enabled != m.enabled {
+		gated := *m
+		gated.enabled = enabled
+		m = &gated
+	}
+
+	// Leases expire without the node changing, so they aren't cached either
+	if m.enabled && rs.liveness != nil && !rs.liveness.Alive(node.Name, time.Now()) {
+		m = &nodeMetrics{enabled: true, written: m.written, unknown: true}
+	}
+
+	return m
+}
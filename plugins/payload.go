@@ -0,0 +1,241 @@
+package rcpu
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// RCPUPayloadKey packs the metrics of a node into a single JSON
+	// annotation, written by the annotator's -payload instead of a key per
+	// metric
+	RCPUPayloadKey = "rcpu-scheduler/payload"
+
+	// MaxPayloadSize bounds the payload in bytes, the annotator drops the
+	// values of the sockets and then of the NUMA nodes to fit
+	MaxPayloadSize = 2048
+)
+
+// Payload is the value of RCPUPayloadKey. The RCPU values are per mille, by
+// window, e.g. 1min.
+type Payload struct {
+	// Time is when the latest sample was taken, in Unix seconds
+	Time int64            `json:"time"`
+	RCPU map[string]int64 `json:"rcpu"`
+	// Avg is the plain average utilization, missing from older annotators
+	Avg       map[string]int64 `json:"avg,omitempty"`
+	FreeCores *int64           `json:"free_cores,omitempty"`
+	Topology  PayloadTopology  `json:"topology"`
+	NUMANodes []PayloadGroup   `json:"nodes,omitempty"`
+	Sockets   []PayloadGroup   `json:"sockets,omitempty"`
+}
+
+// PayloadTopology summarizes the node, each count 0 when unknown.
+type PayloadTopology struct {
+	Cores     int `json:"cores,omitempty"`
+	NUMANodes int `json:"nodes,omitempty"`
+	Sockets   int `json:"sockets,omitempty"`
+}
+
+// PayloadGroup is the RCPU of a NUMA node or a socket.
+type PayloadGroup struct {
+	ID   int32            `json:"id"`
+	RCPU map[string]int64 `json:"rcpu"`
+}
+
+// windowName is the name of the window of a metric key in the payload, e.g.
+// 1min for RCPUMetric1mKey.
+func windowName(key string) string {
+	return strings.TrimPrefix(key, RCPUAnnotationPrefix+"rcpu_")
+}
+
+// ParsePayload decodes the payload strictly, anything this version of the
+// plugin doesn't know, or a value out of range, fails it as a whole.
+func ParsePayload(value string) (*Payload, error) {
+	if len(value) > MaxPayloadSize {
+		return nil, fmt.Errorf("payload of %d bytes exceeds %d", len(value), MaxPayloadSize)
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.DisallowUnknownFields()
+
+	var p Payload
+	if err := decoder.Decode(&p); err != nil {
+		return nil, fmt.Errorf("malformed payload: %v", err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("malformed payload: trailing data")
+	}
+
+	if p.Time <= 0 {
+		return nil, fmt.Errorf("payload has no time")
+	}
+	if err := validatePayloadWindows(p.RCPU); err != nil {
+		return nil, err
+	}
+	if p.Avg != nil {
+		if err := validatePayloadWindows(p.Avg); err != nil {
+			return nil, fmt.Errorf("avg: %v", err)
+		}
+	}
+	if p.FreeCores != nil && *p.FreeCores < 0 {
+		return nil, fmt.Errorf("payload has %d free cores", *p.FreeCores)
+	}
+	if p.Topology.Cores < 0 || p.Topology.NUMANodes < 0 || p.Topology.Sockets < 0 {
+		return nil, fmt.Errorf("payload has a negative topology %+v", p.Topology)
+	}
+
+	for _, groups := range [][]PayloadGroup{p.NUMANodes, p.Sockets} {
+		seen := make(map[int32]bool, len(groups))
+		for _, g := range groups {
+			if g.ID < 0 || seen[g.ID] {
+				return nil, fmt.Errorf("payload has an invalid or repeated group %d", g.ID)
+			}
+			seen[g.ID] = true
+
+			if err := validatePayloadWindows(g.RCPU); err != nil {
+				return nil, fmt.Errorf("group %d: %v", g.ID, err)
+			}
+		}
+	}
+
+	return &p, nil
+}
+
+// validatePayloadWindows requires a value in range for every window, and
+// nothing else.
+func validatePayloadWindows(rcpu map[string]int64) error {
+	if len(rcpu) != len(metricWindows) {
+		return fmt.Errorf("payload has %d windows, expected %d", len(rcpu), len(metricWindows))
+	}
+
+	for _, w := range metricWindows {
+		value, ok := rcpu[windowName(w.key)]
+		if !ok {
+			return fmt.Errorf("payload has no %s window", windowName(w.key))
+		}
+		if value < 0 || value > RCPUMaxScore {
+			return fmt.Errorf("payload %s value %d out of range [0, %d]", windowName(w.key), value, RCPUMaxScore)
+		}
+	}
+
+	return nil
+}
+
+// expandPayload returns the annotations with the payload, if any, unpacked
+// into the v1 keys it replaces. Keys already present win, ok is false when
+// the payload fails ParsePayload.
+func expandPayload(annotations map[string]string) (map[string]string, bool) {
+	value, found := annotations[RCPUPayloadKey]
+	if !found {
+		return annotations, true
+	}
+
+	expanded := make(map[string]string, len(annotations)+len(metricWindows)+2)
+	for key, value := range annotations {
+		expanded[key] = value
+	}
+	delete(expanded, RCPUPayloadKey)
+
+	p, err := ParsePayload(value)
+	if err != nil {
+		return expanded, false
+	}
+
+	set := func(key, value string) {
+		if _, ok := annotations[key]; !ok {
+			expanded[key] = value
+		}
+	}
+
+	for _, w := range metricWindows {
+		set(w.key, strconv.FormatInt(p.RCPU[windowName(w.key)], 10))
+		if p.Avg != nil {
+			set(w.avgKey, strconv.FormatInt(p.Avg[windowName(w.key)], 10))
+		}
+		for _, g := range p.NUMANodes {
+			set(NUMANodeMetricKey(w.key, g.ID), strconv.FormatInt(g.RCPU[windowName(w.key)], 10))
+		}
+		for _, g := range p.Sockets {
+			set(SocketMetricKey(w.key, g.ID), strconv.FormatInt(g.RCPU[windowName(w.key)], 10))
+		}
+	}
+	if p.FreeCores != nil {
+		set(RCPUFreeCoresKey, strconv.FormatInt(*p.FreeCores, 10))
+	}
+	if p.Topology.Cores > 0 {
+		set(RCPUCapacityKey, strconv.Itoa(p.Topology.Cores))
+	}
+
+	return expanded, true
+}
+
+// packPayload packs the v1 annotations of the series into the payload. Only
+// the signature and the timestamp written by Apply stay separate keys.
+func packPayload(annotations map[string]string, s *UsageSeries) (map[string]string, error) {
+	p := Payload{RCPU: make(map[string]int64, len(metricWindows))}
+	if len(s.points) > 0 {
+		last := &s.points[len(s.points)-1]
+		p.Time = last.time.Unix()
+		p.Topology = PayloadTopology{Cores: s.cores, NUMANodes: len(last.numaNodes), Sockets: len(last.sockets)}
+	}
+
+	groups := map[string]*[]PayloadGroup{"node": &p.NUMANodes, "socket": &p.Sockets}
+	for key, value := range annotations {
+		rcpu, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed %s annotation %q", key, value)
+		}
+
+		match := groupMetricKeyPattern.FindStringSubmatch(key)
+		switch {
+		case key == RCPUFreeCoresKey:
+			p.FreeCores = &rcpu
+		case match != nil:
+			id, err := strconv.ParseInt(match[2], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("malformed %s annotation", key)
+			}
+			list := groups[match[1]]
+
+			i := 0
+			for i < len(*list) && (*list)[i].ID != int32(id) {
+				i++
+			}
+			if i == len(*list) {
+				*list = append(*list, PayloadGroup{ID: int32(id), RCPU: make(map[string]int64, len(metricWindows))})
+			}
+			(*list)[i].RCPU[match[3]] = rcpu
+		case isAvgMetric(key):
+			if p.Avg == nil {
+				p.Avg = make(map[string]int64, len(metricWindows))
+			}
+			p.Avg[strings.TrimPrefix(key, RCPUAnnotationPrefix+"avg_")] = rcpu
+		case isPerMilleMetric(key):
+			p.RCPU[windowName(key)] = rcpu
+		}
+	}
+
+	for _, list := range groups {
+		sort.Slice(*list, func(i, j int) bool { return (*list)[i].ID < (*list)[j].ID })
+	}
+
+	out, err := json.Marshal(p)
+	for err == nil && len(out) > MaxPayloadSize && (p.Sockets != nil || p.NUMANodes != nil) {
+		if p.Sockets != nil {
+			p.Sockets = nil
+		} else {
+			p.NUMANodes = nil
+		}
+		out, err = json.Marshal(p)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{RCPUPayloadKey: string(out)}, nil
+}
@@ -0,0 +1,93 @@
+package rcpu
+
+import (
+	"slices"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// overloadCutoffStateKey holds the threshold of the overload percentile,
+// from PreFilter to Filter
+const overloadCutoffStateKey framework.StateKey = Name + "/overload-cutoff"
+
+type overloadCutoff struct {
+	// threshold is what nodeMetrics.filter rejects the worst nodes at
+	threshold int64
+}
+
+func (c *overloadCutoff) Clone() framework.StateData {
+	return c
+}
+
+// overloadThreshold returns the threshold rejecting the values in the worst
+// percentile, false when there are too few values for any to be. Values tied
+// with the best of the rest pass, so a cluster running uniformly hot keeps
+// every node.
+func overloadThreshold(values []int64, percentile float64) (int64, bool) {
+	worst := int(float64(len(values)) * percentile / 100)
+	if worst == 0 {
+		return 0, false
+	}
+
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+
+	return sorted[len(sorted)-worst-1] + 1, true
+}
+
+// writeOverloadCutoff ranks the nodes of the snapshot with the metric of the
+// pod, which an overload percentile filters on instead of the threshold.
+// Nodes without the gate, with unknown metrics or failing verification don't
+// count.
+func (rs *RCPUScheduler) writeOverloadCutoff(state *framework.CycleState, pod *v1.Pod) {
+	policy := rs.policy.Policy().For(pod.Namespace)
+	if rs.overloadPercentile == 0 || rs.mode == ModeScoreOnly || policy.OptOut {
+		return
+	}
+
+	nodes, err := rs.handle.SnapshotSharedLister().NodeInfos().List()
+	if err != nil {
+		klog.ErrorS(err, "Failed to list the nodes, filtering on the threshold", "pod", klog.KObj(pod))
+		return
+	}
+
+	values := make([]int64, 0, len(nodes))
+	for _, nodeInfo := range nodes {
+		node := nodeInfo.Node()
+		if node == nil {
+			continue
+		}
+
+		metrics := rs.nodeMetrics(node)
+		if rcpu, ok := metrics.rcpu[policy.Metric]; ok && metrics.enabled && !metrics.unknown && rs.isTrusted(node, metrics.enabled) {
+			values = append(values, rcpu)
+		}
+	}
+
+	threshold, ok := overloadThreshold(values, rs.overloadPercentile)
+	if !ok {
+		return
+	}
+
+	klog.V(4).InfoS("Overload percentile", "pod", klog.KObj(pod), "nodes", len(values), "threshold", threshold)
+	state.Write(overloadCutoffStateKey, &overloadCutoff{threshold: threshold})
+}
+
+// readOverloadCutoff returns the threshold PreFilter computed, false without
+// an overload percentile, too few nodes for it, or the plugin not enabled at
+// PreFilter.
+func readOverloadCutoff(state *framework.CycleState) (int64, bool) {
+	data, err := state.Read(overloadCutoffStateKey)
+	if err != nil {
+		return 0, false
+	}
+
+	cutoff, ok := data.(*overloadCutoff)
+	if !ok {
+		return 0, false
+	}
+
+	return cutoff.threshold, true
+}
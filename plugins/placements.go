@@ -0,0 +1,121 @@
+package rcpu
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	applycorev1 "k8s.io/client-go/applyconfigurations/core/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultPlacementHistory keeps the ConfigMap well below its 1MiB limit
+	DefaultPlacementHistory       = 1000
+	DefaultPlacementFlushInterval = 30 * time.Second
+
+	// PlacementsKey holds the placements in the ConfigMap, as the JSON of
+	// []Placement, oldest first
+	PlacementsKey = "placements.json"
+
+	placementFieldManager = "rcpu-scheduler"
+)
+
+// ParseConfigMapRef parses a namespace/name reference to a ConfigMap.
+func ParseConfigMapRef(ref string) (string, string, error) {
+	namespace, name, ok := strings.Cut(ref, "/")
+	if !ok || namespace == "" || name == "" || strings.Contains(name, "/") {
+		return "", "", fmt.Errorf("invalid ConfigMap %q, expected namespace/name", ref)
+	}
+
+	return namespace, name, nil
+}
+
+// PlacementRecorder keeps the latest placements of the scheduler, with the
+// RCPU of their node at decision time, and writes them to a ConfigMap, so
+// they can be correlated with the overload of the nodes afterwards. Placements
+// are batched, the scheduler doesn't wait on the API server for every bind.
+type PlacementRecorder struct {
+	client    clientset.Interface
+	namespace string
+	name      string
+	limit     int
+
+	mu         sync.Mutex
+	placements []Placement
+	dirty      bool
+}
+
+func NewPlacementRecorder(client clientset.Interface, namespace, name string) *PlacementRecorder {
+	return &PlacementRecorder{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+		limit:     DefaultPlacementHistory,
+	}
+}
+
+// Record adds a placement, forgetting the oldest past the history.
+func (r *PlacementRecorder) Record(placement Placement) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.placements = append(r.placements, placement)
+	if n := len(r.placements) - r.limit; n > 0 {
+		r.placements = append(r.placements[:0], r.placements[n:]...)
+	}
+	r.dirty = true
+}
+
+// Flush writes the placements to the ConfigMap if any were recorded since
+// the last flush.
+func (r *PlacementRecorder) Flush(ctx context.Context) error {
+	r.mu.Lock()
+	if !r.dirty {
+		r.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(r.placements)
+	r.dirty = false
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	configMap := applycorev1.ConfigMap(r.name, r.namespace).WithData(map[string]string{PlacementsKey: string(data)})
+	_, err = r.client.CoreV1().ConfigMaps(r.namespace).Apply(ctx, configMap, metav1.ApplyOptions{
+		FieldManager: placementFieldManager,
+		Force:        true,
+	})
+	if err != nil {
+		// Try again with the next flush
+		r.mu.Lock()
+		r.dirty = true
+		r.mu.Unlock()
+		return fmt.Errorf("failed to write placements to ConfigMap %s/%s: %v", r.namespace, r.name, err)
+	}
+
+	return nil
+}
+
+// Run flushes the placements every interval until ctx is done.
+func (r *PlacementRecorder) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Flush(ctx); err != nil {
+				klog.ErrorS(err, "Failed to record placements")
+			}
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package rcpu
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// The keys of the policy ConfigMap, each optional, and each but dryRun also
+// overridable per namespace.
+const (
+	// PolicyThresholdKey is the per-mille RCPU at which Filter rejects a node
+	PolicyThresholdKey = "threshold"
+	// PolicyMetricKey is the window Filter and Score read, 1min, 5min or 15min
+	PolicyMetricKey = "metric"
+	// PolicyDryRunKey is "true" or "false", see RCPUSchedulerArgs.DryRun
+	PolicyDryRunKey = "dryRun"
+	// PolicyOptOutKey is "true" to leave the pods of a namespace alone, only
+	// as an override, e.g. batch.optOut
+	PolicyOptOutKey = "optOut"
+)
+
+// Policy is what Filter and Score decide on, which the PolicyWatcher reloads
+// while the scheduler runs.
+type Policy struct {
+	Threshold int64
+	Metric    string
+	DryRun    bool
+	// OptOut passes every node in Filter and scores them all the same
+	OptOut bool
+	// Namespaces are the policies of the namespaces with overrides, the
+	// keys prefixed by the namespace, e.g. batch.threshold, over the rest
+	Namespaces map[string]Policy
+}
+
+// For returns the policy of the pods of the namespace.
+func (p Policy) For(namespace string) Policy {
+	if override, ok := p.Namespaces[namespace]; ok {
+		return override
+	}
+
+	return p
+}
+
+// ParsePolicy reads the data of the policy ConfigMap over the defaults. A key
+// it doesn't know fails it, a misspelled threshold isn't silently ignored.
+func ParsePolicy(data map[string]string, defaults Policy) (Policy, error) {
+	policy := defaults
+	policy.Namespaces = nil
+
+	// The namespaces override the rest of the ConfigMap, whatever the order
+	overrides := make(map[string]map[string]string)
+	for key, value := range data {
+		value = strings.TrimSpace(value)
+		if namespace, field, ok := strings.Cut(key, "."); ok {
+			if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+				return Policy{}, fmt.Errorf("invalid namespace of %q: %s", key, strings.Join(errs, ", "))
+			}
+			if overrides[namespace] == nil {
+				overrides[namespace] = make(map[string]string)
+			}
+			overrides[namespace][field] = value
+			continue
+		}
+
+		if err := policy.set(key, value, false); err != nil {
+			return Policy{}, err
+		}
+	}
+
+	for namespace, fields := range overrides {
+		override := policy
+		override.Namespaces = nil
+		for field, value := range fields {
+			if err := override.set(field, value, true); err != nil {
+				return Policy{}, fmt.Errorf("namespace %s: %v", namespace, err)
+			}
+		}
+
+		if policy.Namespaces == nil {
+			policy.Namespaces = make(map[string]Policy, len(overrides))
+		}
+		policy.Namespaces[namespace] = override
+	}
+
+	return policy, nil
+}
+
+// set parses a key of the ConfigMap into the policy, a namespace overrides
+// the threshold, the metric or whether it opts out.
+func (p *Policy) set(key, value string, namespace bool) error {
+	switch {
+	case key == PolicyThresholdKey:
+		threshold, err := parseRCPUValue(SchemaV1, value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+		p.Threshold = threshold
+	case key == PolicyMetricKey:
+		metric, err := parsePolicyMetric(value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+		p.Metric = metric
+	case key == PolicyDryRunKey && !namespace:
+		dryRun, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s must be \"true\" or \"false\", got %q", key, value)
+		}
+		p.DryRun = dryRun
+	case key == PolicyOptOutKey && namespace:
+		optOut, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s must be \"true\" or \"false\", got %q", key, value)
+		}
+		p.OptOut = optOut
+	case namespace:
+		return fmt.Errorf("unknown key %q, expected %s, %s or %s", key, PolicyThresholdKey, PolicyMetricKey, PolicyOptOutKey)
+	default:
+		return fmt.Errorf("unknown key %q, expected %s, %s, %s or <namespace>.<key>", key, PolicyThresholdKey, PolicyMetricKey, PolicyDryRunKey)
+	}
+
+	return nil
+}
+
+// parsePolicyMetric takes the window, e.g. 15min, or the whole annotation.
+func parsePolicyMetric(value string) (string, error) {
+	windows := make([]string, 0, len(metricWindows))
+	for _, w := range metricWindows {
+		if value == windowName(w.key) || value == w.key {
+			return w.key, nil
+		}
+		windows = append(windows, windowName(w.key))
+	}
+
+	return "", fmt.Errorf("unknown window %q, expected one of %s", value, strings.Join(windows, ", "))
+}
+
+// PolicyWatcher keeps the policy of a ConfigMap current. A ConfigMap failing
+// ParsePolicy keeps the last valid policy, a deleted one restores the
+// defaults, from the plugin's args.
+type PolicyWatcher struct {
+	defaults Policy
+	policy   atomic.Pointer[Policy]
+}
+
+func NewPolicyWatcher(defaults Policy) *PolicyWatcher {
+	w := &PolicyWatcher{defaults: defaults}
+	w.policy.Store(&defaults)
+	return w
+}
+
+// Policy returns the current policy, read once per extension point so a
+// reload in between doesn't mix two policies.
+func (w *PolicyWatcher) Policy() Policy {
+	return *w.policy.Load()
+}
+
+// EventHandler updates the policy from the ConfigMap, the informer is
+// expected to watch that ConfigMap alone.
+func (w *PolicyWatcher) EventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			w.update(obj)
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			w.update(obj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			w.policy.Store(&w.defaults)
+			klog.InfoS("Policy ConfigMap deleted, restored the default policy", "policy", w.defaults)
+		},
+	}
+}
+
+func (w *PolicyWatcher) update(obj interface{}) {
+	cm, ok := obj.(*v1.ConfigMap)
+	if !ok {
+		return
+	}
+
+	policy, err := ParsePolicy(cm.Data, w.defaults)
+	if err != nil {
+		policyReloads.WithLabelValues("invalid").Inc()
+		klog.ErrorS(err, "Ignoring invalid policy ConfigMap, keeping the current policy", "configMap", klog.KObj(cm), "policy", w.Policy())
+		return
+	}
+
+	if previous := w.Policy(); previous.String() != policy.String() {
+		klog.InfoS("Policy reloaded", "configMap", klog.KObj(cm), "policy", policy, "previous", previous)
+	}
+	w.policy.Store(&policy)
+	policyReloads.WithLabelValues("applied").Inc()
+}
+
+// String formats the policy like the ConfigMap, the namespaces in order, for
+// the logs.
+func (p Policy) String() string {
+	s := fmt.Sprintf("%s=%d %s=%s %s=%t", PolicyThresholdKey, p.Threshold, PolicyMetricKey, windowName(p.Metric), PolicyDryRunKey, p.DryRun)
+
+	namespaces := make([]string, 0, len(p.Namespaces))
+	for namespace := range p.Namespaces {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	for _, namespace := range namespaces {
+		override := p.Namespaces[namespace]
+		s += fmt.Sprintf(" %s.%s=%d %s.%s=%s %s.%s=%t", namespace, PolicyThresholdKey, override.Threshold,
+			namespace, PolicyMetricKey, windowName(override.Metric), namespace, PolicyOptOutKey, override.OptOut)
+	}
+
+	return s
+}
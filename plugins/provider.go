@@ -0,0 +1,102 @@
+package rcpu
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	// RCPUProviderKey names what wrote the metrics of the node, e.g. an
+	// adapter publishing them from Prometheus, shown in the decision log
+	RCPUProviderKey = "rcpu-scheduler/provider"
+	// RCPURangeKey is the min-max scale a provider writes its v1 metrics in
+	// when it isn't per mille, e.g. 0-100, which the plugin normalizes
+	RCPURangeKey = "rcpu-scheduler/range"
+)
+
+// metricRange is the scale of the metrics of a provider.
+type metricRange struct {
+	min, max int64
+}
+
+// perMilleRange is the scale of the annotator, and of the v2 schema
+var perMilleRange = metricRange{0, RCPUMaxScore}
+
+func parseMetricRange(value string) (metricRange, error) {
+	minStr, maxStr, ok := strings.Cut(value, "-")
+	if !ok {
+		return metricRange{}, fmt.Errorf("%q is not a min-max range", value)
+	}
+
+	lo, err1 := strconv.ParseInt(minStr, 10, 64)
+	hi, err2 := strconv.ParseInt(maxStr, 10, 64)
+	if err1 != nil || err2 != nil || lo < 0 || hi <= lo {
+		return metricRange{}, fmt.Errorf("%q is not a min-max range of increasing non-negative integers", value)
+	}
+
+	return metricRange{lo, hi}, nil
+}
+
+// annotationRange returns the scale of the metrics of the annotations, per
+// mille unless their v1 metrics declare another, false when it is malformed.
+func annotationRange(annotations map[string]string) (metricRange, bool) {
+	value, found := annotations[RCPURangeKey]
+	if !found || annotationSchema(annotations) != SchemaV1 {
+		return perMilleRange, true
+	}
+
+	r, err := parseMetricRange(value)
+	if err != nil {
+		return metricRange{}, false
+	}
+
+	return r, true
+}
+
+// normalize maps a value of the range onto per mille, so nodes aren't
+// advantaged by the scale of their provider.
+func (r metricRange) normalize(value int64) int64 {
+	if r == perMilleRange {
+		return value
+	}
+
+	return int64(math.Round(float64(value-r.min) * float64(RCPUMaxScore) / float64(r.max-r.min)))
+}
+
+// denormalize maps a per-mille value back onto the range.
+func (r metricRange) denormalize(rcpu int64) int64 {
+	if r == perMilleRange {
+		return rcpu
+	}
+
+	return r.min + int64(math.Round(float64(rcpu)*float64(r.max-r.min)/float64(RCPUMaxScore)))
+}
+
+// parseAnnotatedMetric parses a metric of the annotations onto per mille, in
+// their schema and range, not range checked.
+func parseAnnotatedMetric(annotations map[string]string, value string) (int64, error) {
+	rcpu, err := parseMetric(annotationSchema(annotations), value)
+	if err != nil {
+		return 0, err
+	}
+
+	r, ok := annotationRange(annotations)
+	if !ok {
+		return 0, fmt.Errorf("malformed %s annotation %q", RCPURangeKey, annotations[RCPURangeKey])
+	}
+
+	return r.normalize(rcpu), nil
+}
+
+// formatAnnotatedMetric formats a per-mille metric in the schema and range of
+// the annotations.
+func formatAnnotatedMetric(annotations map[string]string, rcpu int64) string {
+	r, ok := annotationRange(annotations)
+	if !ok {
+		r = perMilleRange
+	}
+
+	return formatMetric(annotationSchema(annotations), r.denormalize(rcpu))
+}
@@ -3,14 +3,26 @@ package rcpu
 import (
 	"context"
 	"fmt"
-	"strconv"
+	"math"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/klog/v2"
 	"k8s.io/kubernetes/pkg/scheduler/framework"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
 )
 
+var _ framework.PreFilterPlugin = &RCPUScheduler{}
 var _ framework.FilterPlugin = &RCPUScheduler{}
+var _ framework.PreScorePlugin = &RCPUScheduler{}
 var _ framework.ScorePlugin = &RCPUScheduler{}
+var _ framework.ScoreExtensions = &RCPUScheduler{}
+var _ framework.ReservePlugin = &RCPUScheduler{}
+var _ framework.PostBindPlugin = &RCPUScheduler{}
 
 const (
 	Name = "RCPUScheduler"
@@ -18,16 +30,340 @@ const (
 	DefaultRCPUThreshold = int64(0.4 * 1000) // Default threshold for banning a node based on rcpu utilization, we multiply by 1000 to convert it to millicores to avoid floating point arithmetic
 	RCPUMaxScore = int64(1.0 * 1000)
 
+	RCPUAnnotationPrefix = "rcpu-scheduler/"
+
 	RCPUFeatureGateKey = "rcpu-scheduler/enable"
 	RCPUMetric1mKey    = "rcpu-scheduler/rcpu_1min"
 	RCPUMetric5mKey    = "rcpu-scheduler/rcpu_5min"
 	RCPUMetric15mKey   = "rcpu-scheduler/rcpu_15min"
+	// RCPUAvg*Key are the plain average utilization of the logical CPUs over
+	// the same windows, for scoring on both, see AvgUtilizationWeight
+	RCPUAvg1mKey  = "rcpu-scheduler/avg_1min"
+	RCPUAvg5mKey  = "rcpu-scheduler/avg_5min"
+	RCPUAvg15mKey = "rcpu-scheduler/avg_15min"
+	// RCPUFreeCoresKey is the number of physical cores idle on all their
+	// threads, the fewest over the last minute
+	RCPUFreeCoresKey = "rcpu-scheduler/free_cores"
 
 	DefaultRCPUMetric = RCPUMetric15mKey
 )
 
+// The modes of RCPUSchedulerArgs, which extension points the plugin acts on.
+const (
+	ModeFilterAndScore = "FilterAndScore"
+	// ModeScoreOnly prefers idle nodes without ever filtering one out, to
+	// adopt RCPU gradually
+	ModeScoreOnly = "ScoreOnly"
+	// ModeFilterOnly keeps pods off overloaded nodes and leaves the ranking
+	// of the others to the other plugins
+	ModeFilterOnly = "FilterOnly"
+
+	DefaultMode        = ModeFilterAndScore
+	DefaultScoreWeight = 1.0
+)
+
+// The scoring providers of RCPUSchedulerArgs, what the plugin scores nodes on.
+const (
+	// ScoringRCPU prefers the nodes with the most fractional headroom
+	ScoringRCPU = "RCPU"
+	// ScoringFreeCores prefers the nodes with the most whole idle cores, for
+	// pods pinning exclusive CPUs, which need empty cores rather than
+	// headroom spread over busy ones
+	ScoringFreeCores = "FreeCores"
+	// ScoringBalanced weighs RCPU against the allocation balance the
+	// NodeResourcesBalancedAllocation plugin scores, so the two don't pull
+	// pods in opposite directions: RCPU alone keeps sending CPU-heavy pods to
+	// the least busy node even once its requested CPU far outweighs its
+	// requested memory, which is exactly the node balanced allocation steers
+	// away from
+	ScoringBalanced = "Balanced"
+
+	DefaultScoring = ScoringRCPU
+)
+
+type RCPUSchedulerArgs struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// SigningKeyFile enables verification of rcpu-scheduler/signature, nodes
+	// whose metrics fail verification are filtered out
+	SigningKeyFile string `json:"signingKeyFile,omitempty"`
+	// MaxSignatureAge rejects signatures older than this, defaults to
+	// DefaultMaxSignatureAge
+	MaxSignatureAge metav1.Duration `json:"maxSignatureAge,omitempty"`
+
+	// Mode is FilterAndScore, ScoreOnly or FilterOnly, defaults to
+	// DefaultMode. The plugin has to be enabled at the extension points the
+	// mode uses in the profile too.
+	Mode string `json:"mode,omitempty"`
+	// ScoreWeight scales the plugin's scores, in (0, 1], defaults to
+	// DefaultScoreWeight. The scheduler multiplies the score of every plugin
+	// by its integer weight in the profile before adding them up, so a plugin
+	// of weight 1 already counts as much as any other. ScoreWeight lowers it
+	// below that, e.g. 0.25 lets RCPU break ties between nodes the other
+	// plugins find about equal without overriding them. The profile weight
+	// still applies on top of it.
+	ScoreWeight *float64 `json:"scoreWeight,omitempty"`
+	// Scoring is RCPU or FreeCores, defaults to DefaultScoring. FreeCores
+	// needs annotators publishing rcpu-scheduler/free_cores, nodes without it
+	// score 0. Filter uses RCPU either way.
+	Scoring string `json:"scoring,omitempty"`
+	// BalanceWeight is the share of the allocation balance in the Balanced
+	// score, in [0, 1], defaults to DefaultBalanceWeight. 0 scores on RCPU
+	// alone and 1 on the balance alone.
+	BalanceWeight *float64 `json:"balanceWeight,omitempty"`
+	// AvgUtilizationWeight is the share of the plain average utilization in
+	// the RCPU score, in [0, 1], defaults to 0, for a gentler transition from
+	// load-aware schedulers scoring on it. Nodes whose annotator doesn't
+	// publish it score on RCPU alone.
+	AvgUtilizationWeight *float64 `json:"avgUtilizationWeight,omitempty"`
+	// OverloadCooldown keeps a node that reached the threshold filtered for
+	// at least this long, even once its metric dips below, 0 disables it
+	OverloadCooldown metav1.Duration `json:"overloadCooldown,omitempty"`
+	// PlacementConfigMap records the placements of the pods on nodes with
+	// the metric, with its value at decision time, in this namespace/name
+	// ConfigMap. The plugin has to be enabled at the reserve and postBind
+	// extension points too.
+	PlacementConfigMap string `json:"placementConfigMap,omitempty"`
+	// MetricsCacheTTL is how long the parsed annotations of a node are kept,
+	// unless the node changes first, defaults to DefaultMetricsCacheTTL. 0
+	// disables the cache.
+	MetricsCacheTTL *metav1.Duration `json:"metricsCacheTTL,omitempty"`
+	// DryRun makes Filter pass every node, logging and counting the nodes it
+	// would have rejected instead, to try a threshold out before enforcing it
+	DryRun bool `json:"dryRun,omitempty"`
+	// OverloadPercentile makes Filter reject the nodes whose RCPU is in the
+	// worst OverloadPercentile percent of the nodes with the metric, instead
+	// of those at the threshold, adapting to clusters running uniformly hot
+	// or cold. The nodes are ranked in PreFilter, where the plugin has to be
+	// enabled too. 0 disables it.
+	OverloadPercentile float64 `json:"overloadPercentile,omitempty"`
+	// ScoreSampleSize bounds the cost of Score in clusters of thousands of
+	// nodes: only this many of the feasible nodes, picked at random in
+	// PreScore, are ranked, the others get the neutral score 0, like in
+	// FilterOnly mode. Picking the best of a few random nodes keeps most of
+	// the benefit of ranking all of them. 0 ranks every node.
+	ScoreSampleSize int `json:"scoreSampleSize,omitempty"`
+	// PolicyConfigMap is the namespace/name ConfigMap overriding the
+	// threshold, the metric and DryRun, and the threshold and the metric of
+	// the pods of some namespaces, or opting them out, watched so changes
+	// apply without restarting the scheduler, see ParsePolicy
+	PolicyConfigMap string `json:"policyConfigMap,omitempty"`
+
+	// FeatureGateKey is the node annotation enabling the plugin on the node
+	// when "true", defaults to RCPUFeatureGateKey
+	FeatureGateKey string `json:"featureGateKey,omitempty"`
+	// NodeSelector also enables the plugin on the nodes matching it, so
+	// existing labels can be reused instead of annotating every node. An
+	// empty selector matches every node.
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+
+	// LivenessLeaseNamespace is where the annotators run with
+	// -liveness-lease-namespace renew a lease per node. The metrics of a node
+	// whose lease expired, or which has none, are unknown however recent its
+	// annotations: it passes Filter and its RCPU scores 0, as its collector
+	// died rather than its node went idle. Empty disables it.
+	LivenessLeaseNamespace string `json:"livenessLeaseNamespace,omitempty"`
+}
+
+// ValidateArgs checks the args and fills in the defaults.
+func ValidateArgs(args *RCPUSchedulerArgs) error {
+	switch args.Mode {
+	case "":
+		args.Mode = DefaultMode
+	case ModeFilterAndScore, ModeScoreOnly, ModeFilterOnly:
+	default:
+		return fmt.Errorf("invalid mode %q, expected %s, %s or %s", args.Mode, ModeFilterAndScore, ModeScoreOnly, ModeFilterOnly)
+	}
+
+	switch args.Scoring {
+	case "":
+		args.Scoring = DefaultScoring
+	case ScoringRCPU, ScoringFreeCores, ScoringBalanced:
+	default:
+		return fmt.Errorf("invalid scoring %q, expected %s, %s or %s", args.Scoring, ScoringRCPU, ScoringFreeCores, ScoringBalanced)
+	}
+
+	if args.BalanceWeight == nil {
+		weight := DefaultBalanceWeight
+		args.BalanceWeight = &weight
+	}
+
+	if weight := *args.BalanceWeight; !(weight >= 0 && weight <= 1) {
+		return fmt.Errorf("invalid balanceWeight %g, expected a weight in [0, 1]", weight)
+	}
+
+	if args.AvgUtilizationWeight == nil {
+		weight := 0.0
+		args.AvgUtilizationWeight = &weight
+	}
+
+	if weight := *args.AvgUtilizationWeight; !(weight >= 0 && weight <= 1) {
+		return fmt.Errorf("invalid avgUtilizationWeight %g, expected a weight in [0, 1]", weight)
+	}
+
+	if args.ScoreWeight == nil {
+		weight := DefaultScoreWeight
+		args.ScoreWeight = &weight
+	}
+
+	if weight := *args.ScoreWeight; !(weight > 0 && weight <= 1) {
+		return fmt.Errorf("invalid scoreWeight %g, expected a weight in (0, 1]", weight)
+	}
+
+	if args.ScoreSampleSize < 0 {
+		return fmt.Errorf("invalid scoreSampleSize %d", args.ScoreSampleSize)
+	}
+
+	if p := args.OverloadPercentile; !(p >= 0 && p < 100) {
+		return fmt.Errorf("invalid overloadPercentile %g, expected a percentage in [0, 100)", p)
+	}
+
+	if args.OverloadCooldown.Duration < 0 {
+		return fmt.Errorf("invalid overloadCooldown %v", args.OverloadCooldown.Duration)
+	}
+
+	if args.FeatureGateKey == "" {
+		args.FeatureGateKey = RCPUFeatureGateKey
+	}
+
+	if _, err := NewFeatureGate(args.FeatureGateKey, args.NodeSelector); err != nil {
+		return err
+	}
+
+	if args.MetricsCacheTTL == nil {
+		args.MetricsCacheTTL = &metav1.Duration{Duration: DefaultMetricsCacheTTL}
+	}
+
+	if args.PlacementConfigMap != "" {
+		if _, _, err := ParseConfigMapRef(args.PlacementConfigMap); err != nil {
+			return fmt.Errorf("invalid placementConfigMap: %v", err)
+		}
+	}
+
+	if args.PolicyConfigMap != "" {
+		if _, _, err := ParseConfigMapRef(args.PolicyConfigMap); err != nil {
+			return fmt.Errorf("invalid policyConfigMap: %v", err)
+		}
+	}
+
+	return nil
+}
+
 type RCPUScheduler struct {
-	handle framework.Handle
+	handle          framework.Handle
+	signingKey      []byte
+	maxSignatureAge time.Duration
+	mode            string
+	scoreWeight     float64
+	scoring         string
+	balanceWeight   float64
+	avgWeight       float64
+	// cooldown is nil without an overload cooldown
+	cooldown *Cooldown
+	// placements is nil unless placements are recorded
+	placements *PlacementRecorder
+	// metricsCache is nil when disabled
+	metricsCache *NodeMetricsCache
+	policy       *PolicyWatcher
+	// overloadPercentile is 0 when Filter uses the threshold
+	overloadPercentile float64
+	// scoreSampleSize is 0 when every node is ranked
+	scoreSampleSize int
+	gate            *FeatureGate
+	// liveness is nil without liveness leases
+	liveness *LivenessLeases
+}
+
+func New(ctx context.Context, obj runtime.Object, h framework.Handle) (framework.Plugin, error) {
+	args := RCPUSchedulerArgs{}
+	if obj != nil {
+		if err := frameworkruntime.DecodeInto(obj, &args); err != nil {
+			return nil, fmt.Errorf("failed to decode %s args: %v", Name, err)
+		}
+	}
+
+	if err := ValidateArgs(&args); err != nil {
+		return nil, fmt.Errorf("invalid %s args: %v", Name, err)
+	}
+
+	rs := &RCPUScheduler{
+		handle:        h,
+		mode:          args.Mode,
+		scoreWeight:   *args.ScoreWeight,
+		scoring:       args.Scoring,
+		balanceWeight: *args.BalanceWeight,
+		policy: NewPolicyWatcher(Policy{
+			Threshold: DefaultRCPUThreshold,
+			Metric:    DefaultRCPUMetric,
+			DryRun:    args.DryRun,
+		}),
+	}
+
+	gate, err := NewFeatureGate(args.FeatureGateKey, args.NodeSelector)
+	if err != nil {
+		return nil, err
+	}
+	rs.gate = gate
+	rs.overloadPercentile = args.OverloadPercentile
+	rs.scoreSampleSize = args.ScoreSampleSize
+	rs.avgWeight = *args.AvgUtilizationWeight
+
+	if args.OverloadCooldown.Duration > 0 {
+		rs.cooldown = NewCooldown(args.OverloadCooldown.Duration)
+	}
+
+	registerMetrics()
+
+	if ttl := args.MetricsCacheTTL.Duration; ttl > 0 {
+		rs.metricsCache = NewNodeMetricsCache(ttl)
+		informer := h.SharedInformerFactory().Core().V1().Nodes().Informer()
+		if _, err := informer.AddEventHandler(rs.metricsCache.EventHandler()); err != nil {
+			return nil, fmt.Errorf("failed to watch nodes: %v", err)
+		}
+	}
+
+	if ns := args.LivenessLeaseNamespace; ns != "" {
+		factory := informers.NewSharedInformerFactoryWithOptions(h.ClientSet(), 0, informers.WithNamespace(ns))
+		rs.liveness = NewLivenessLeases(factory.Coordination().V1().Leases().Lister().Leases(ns))
+		factory.Start(ctx.Done())
+		// Every node would be unknown until the leases are listed
+		factory.WaitForCacheSync(ctx.Done())
+	}
+
+	if args.PolicyConfigMap != "" {
+		namespace, name, _ := ParseConfigMapRef(args.PolicyConfigMap)
+		factory := informers.NewSharedInformerFactoryWithOptions(h.ClientSet(), 0, informers.WithNamespace(namespace),
+			informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+				options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+			}))
+		if _, err := factory.Core().V1().ConfigMaps().Informer().AddEventHandler(rs.policy.EventHandler()); err != nil {
+			return nil, fmt.Errorf("failed to watch the policy ConfigMap: %v", err)
+		}
+		factory.Start(ctx.Done())
+		// The first pods would be filtered on the defaults otherwise
+		factory.WaitForCacheSync(ctx.Done())
+	}
+
+	if args.PlacementConfigMap != "" {
+		namespace, name, _ := ParseConfigMapRef(args.PlacementConfigMap)
+		rs.placements = NewPlacementRecorder(h.ClientSet(), namespace, name)
+		go rs.placements.Run(ctx, DefaultPlacementFlushInterval)
+	}
+
+	if args.SigningKeyFile != "" {
+		key, err := LoadSigningKey(args.SigningKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		rs.signingKey = key
+
+		rs.maxSignatureAge = args.MaxSignatureAge.Duration
+		if rs.maxSignatureAge <= 0 {
+			rs.maxSignatureAge = DefaultMaxSignatureAge
+		}
+	}
+
+	return rs, nil
 }
 
 func (rs *RCPUScheduler) Name() string {
@@ -43,22 +379,93 @@ func IsDaemonSetPod(pod *v1.Pod) bool {
 	return false
 }
 
-func isOverloaded(annotations map[string]string, metric string, threshold int64) bool {
+func parseRCPUValue(schema, rcpuStr string) (int64, error) {
+	rcpu, err := parseMetric(schema, rcpuStr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rcpu value %q: %v", rcpuStr, err)
+	}
+
+	if rcpu < 0 || rcpu > RCPUMaxScore {
+		return 0, fmt.Errorf("rcpu value %d out of range [0, %d]", rcpu, RCPUMaxScore)
+	}
+
+	return rcpu, nil
+}
+
+// getRCPU returns the metric from the annotations in their schema and range,
+// or from their payload, ignoring malformed values. Out of range values are
+// clamped rather than ignored, so a node reporting more than full utilization
+// is still filtered and scored as overloaded.
+func getRCPU(annotations map[string]string, metric string) (int64, bool) {
+	annotations, _ = expandPayload(annotations)
 	rcpuStr, ok := annotations[metric]
 	if !ok {
-		return false
+		return 0, false
 	}
 
-	rcpu, err := strconv.ParseInt(rcpuStr, 10, 64)
+	rcpu, err := parseAnnotatedMetric(annotations, rcpuStr)
 	if err != nil {
+		return 0, false
+	}
+
+	return min(max(rcpu, 0), RCPUMaxScore), true
+}
+
+// isTrusted reports whether the metric annotations can be used, which is
+// always the case unless signature verification is enabled. Untrusted nodes
+// are unschedulable rather than unknown, otherwise forging the annotations
+// would be enough to steer pods onto a node. The gate isn't signed, so a
+// signed node with the gate turned off is untrusted too, rather than
+// passing unchecked.
+func (rs *RCPUScheduler) isTrusted(node *v1.Node, enabled bool) bool {
+	if rs.signingKey == nil {
+		return true
+	}
+
+	if !enabled {
+		if _, signed := node.Annotations[RCPUSignatureKey]; !signed {
+			return true
+		}
+
+		klog.V(4).InfoS("Ignoring signed rcpu annotations of a node with the gate turned off", "node", node.Name)
+		return false
+	}
+
+	if err := VerifyAnnotations(rs.signingKey, node.Name, node.Annotations, time.Now(), rs.maxSignatureAge); err != nil {
+		klog.V(4).InfoS("Ignoring unverified rcpu annotations", "node", node.Name, "err", err)
 		return false
 	}
 
-	return rcpu >= threshold
+	return true
+}
+
+// filterAnnotations is the verdict of Filter on a trusted node, shared with
+// the simulator: nodes without the feature gate or the metric pass.
+func filterAnnotations(annotations map[string]string, metric string, threshold int64) bool {
+	return parseNodeMetrics(annotations).filter(metric, threshold)
 }
 
 func (rs *RCPUScheduler) Filter(ctx context.Context, cycleState *framework.CycleState, pod *v1.Pod, nodeInfo *framework.NodeInfo) *framework.Status {
-	if IsDaemonSetPod(pod) {
+	defer observeDuration("Filter", time.Now())
+
+	policy := rs.policy.Policy()
+	status := rs.filter(cycleState, pod, nodeInfo, policy)
+	if decisions := readDecisions(cycleState); decisions != nil && nodeInfo.Node() != nil {
+		decisions.filtered(nodeInfo.Node().Name, rs.nodeMetrics(nodeInfo.Node()), status)
+	}
+
+	if policy.DryRun && status.Code() == framework.Unschedulable {
+		dryRunRejections.Inc()
+		klog.V(2).InfoS("Dry run, not rejecting node", "pod", klog.KObj(pod), "node", nodeInfo.Node().Name, "reason", status.Message())
+		return framework.NewStatus(framework.Success, "")
+	}
+
+	return status
+}
+
+func (rs *RCPUScheduler) filter(state *framework.CycleState, pod *v1.Pod, nodeInfo *framework.NodeInfo, policy Policy) *framework.Status {
+	podPolicy := policy.For(pod.Namespace)
+	if rs.mode == ModeScoreOnly || IsDaemonSetPod(pod) || podPolicy.OptOut {
 		return framework.NewStatus(framework.Success, "")
 	}
 
@@ -67,38 +474,83 @@ func (rs *RCPUScheduler) Filter(ctx context.Context, cycleState *framework.Cycle
 		return framework.NewStatus(framework.Error, "node not found")
 	}
 
-	nodeAnnotations := node.GetAnnotations()
-	if nodeAnnotations == nil {
+	if node.GetAnnotations() == nil {
 		return framework.NewStatus(framework.Success, "")
 	}
 
-	annotation, ok := nodeAnnotations[RCPUFeatureGateKey]
-	if !ok || annotation != "true" {
+	metrics := rs.nodeMetrics(node)
+	if metrics.enabled && !metrics.written.IsZero() {
+		annotationAge.Observe(time.Since(metrics.written).Seconds())
+	}
+	if !rs.isTrusted(node, metrics.enabled) {
+		return framework.NewStatus(framework.Unschedulable, "rcpu annotations failed verification")
+	}
+
+	if rs.cooldown != nil {
+		// Only the threshold itself starts a cooldown, not the room left for
+		// the demand of a pod, nor the threshold of its namespace
+		overloaded := !metrics.filter(policy.Metric, policy.Threshold)
+		if rs.cooldown.Observe(node.Name, overloaded, time.Now()) && !overloaded {
+			return framework.NewStatus(framework.Unschedulable, "rcpu utilization was too high recently")
+		}
+	}
+
+	// The worst nodes are rejected whatever their RCPU
+	if threshold, ok := readOverloadCutoff(state); ok {
+		if !metrics.filter(podPolicy.Metric, threshold) {
+			return framework.NewStatus(framework.Unschedulable, "rcpu utilization is among the highest of the cluster")
+		}
 		return framework.NewStatus(framework.Success, "")
 	}
 
-	if isOverloaded(nodeAnnotations, DefaultRCPUMetric, DefaultRCPUThreshold) {
+	// Leave room for the pod's own demand when the webhook estimated one
+	threshold := podPolicy.Threshold - getPodDemand(pod, node)
+	if !metrics.filter(podPolicy.Metric, threshold) {
 		return framework.NewStatus(framework.Unschedulable, "rcpu utilization is too high")
 	}
 
 	return framework.NewStatus(framework.Success, "")
 }
 
-func getNodeScore(annotations map[string]string, metric string) (int64, bool) {
-	rcpuStr, ok := annotations[metric]
-	if !ok {
-		return 0, false
-	}
+// scoreAnnotations is the result of Score on a trusted node, shared with the
+// simulator. Nodes without the feature gate score 0, a node with the gate but
+// without the metric can't be scored.
+func scoreAnnotations(annotations map[string]string, metric string) (int64, bool) {
+	return parseNodeMetrics(annotations).score(metric)
+}
 
-	rcpu, err := strconv.ParseInt(rcpuStr, 10, 64)
-	if err != nil {
+// getFreeCores returns the free cores from the annotations in their schema,
+// ignoring malformed values.
+func getFreeCores(annotations map[string]string) (int64, bool) {
+	annotations, _ = expandPayload(annotations)
+	freeCores, err := parseCores(annotationSchema(annotations), annotations[RCPUFreeCoresKey])
+	if err != nil || freeCores < 0 {
 		return 0, false
 	}
 
-	return max(0, RCPUMaxScore - rcpu), true
+	return freeCores, true
 }
 
+// Score ranks the nodes by RCPU, scaled from the annotations' per-mille to
+// the framework's MaxNodeScore and by the score weight.
 func (rs *RCPUScheduler) Score(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) (int64, *framework.Status) {
+	defer observeDuration("Score", time.Now())
+
+	score, status := rs.score(state, pod, nodeName)
+	if decisions := readDecisions(state); decisions != nil {
+		decisions.scored(nodeName, rs.decisionMetrics(nodeName), score)
+	}
+
+	return score, status
+}
+
+func (rs *RCPUScheduler) score(state *framework.CycleState, pod *v1.Pod, nodeName string) (int64, *framework.Status) {
+	// Every node scores the same, which leaves the ranking to the others
+	policy := rs.policy.Policy().For(pod.Namespace)
+	if rs.mode == ModeFilterOnly || policy.OptOut || !readSampledNodes(state).ranks(nodeName) {
+		return 0, framework.NewStatus(framework.Success, "")
+	}
+
 	nodeInfo, err := rs.handle.SnapshotSharedLister().NodeInfos().Get(nodeName)
 	if err != nil {
 		return 0, framework.NewStatus(framework.Error, fmt.Sprintf("getting node %q from Snapshot: %v", nodeName, err))
@@ -109,25 +561,146 @@ func (rs *RCPUScheduler) Score(ctx context.Context, state *framework.CycleState,
 		return 0, framework.NewStatus(framework.Error, "node not found")
 	}
 
-	nodeAnnotations := node.Annotations
-	if nodeAnnotations == nil {
+	// Nodes without annotations score like those without the feature gate,
+	// Balanced still scores their allocation
+	metrics := rs.nodeMetrics(node)
+
+	// Filter already rejected the node, score it the lowest just in case
+	if !rs.isTrusted(node, metrics.enabled) {
 		return 0, framework.NewStatus(framework.Success, "")
 	}
 
-	annotation, ok := nodeAnnotations[RCPUFeatureGateKey]
-	if !ok || annotation != "true" {
-		return 0, framework.NewStatus(framework.Success, "")
+	if rs.scoring == ScoringFreeCores {
+		return metrics.freeCoresScore(), framework.NewStatus(framework.Success, "")
 	}
 
-	score, ok := getNodeScore(nodeAnnotations, DefaultRCPUMetric)
+	score, ok := metrics.score(policy.Metric)
 	if !ok {
 		return 0, framework.NewStatus(framework.Error, "failed to get node score")
 	}
 
-	return score, framework.NewStatus(framework.Success, "")
+	if avg, ok := metrics.avgScore(policy.Metric); ok && rs.avgWeight > 0 {
+		score = combineScores(score, avg, rs.avgWeight)
+	}
+
+	if rs.scoring == ScoringBalanced {
+		score = combineScores(score, balanceScore(readPodRequests(state, pod), nodeInfo), rs.balanceWeight)
+	}
+
+	return rs.scaleScore(score), framework.NewStatus(framework.Success, "")
+}
+
+// scaleScore maps a per-mille score to [0, MaxNodeScore*scoreWeight]
+func (rs *RCPUScheduler) scaleScore(score int64) int64 {
+	return int64(math.Round(float64(score) * float64(framework.MaxNodeScore) / float64(RCPUMaxScore) * rs.scoreWeight))
 }
 
 func (rs *RCPUScheduler) ScoreExtensions() framework.ScoreExtensions {
-	// We don't need to implement normalizer, since the score is already normalized
+	// The RCPU scores don't need a normalizer, scaleScore already keeps them
+	// in range, while free cores have no upper bound. The decision log wants
+	// the final scores either way.
+	if (rs.scoring == ScoringFreeCores && rs.mode != ModeFilterOnly) || decisionLogEnabled() {
+		return rs
+	}
+
 	return nil
 }
+
+// NormalizeScore maps the free cores to [0, MaxNodeScore*scoreWeight], the
+// node with the most getting the highest score.
+func (rs *RCPUScheduler) NormalizeScore(ctx context.Context, state *framework.CycleState, pod *v1.Pod, scores framework.NodeScoreList) *framework.Status {
+	defer observeDuration("NormalizeScore", time.Now())
+
+	if rs.scoring == ScoringFreeCores && rs.mode != ModeFilterOnly {
+		rs.normalizeFreeCores(scores)
+	}
+
+	if decisions := readDecisions(state); decisions != nil {
+		for _, score := range scores {
+			decisions.normalized(score.Name, score.Score)
+		}
+	}
+
+	return framework.NewStatus(framework.Success, "")
+}
+
+func (rs *RCPUScheduler) normalizeFreeCores(scores framework.NodeScoreList) {
+	var most int64
+	for _, score := range scores {
+		most = max(most, score.Score)
+	}
+
+	for i := range scores {
+		if most == 0 {
+			scores[i].Score = 0
+			continue
+		}
+
+		scores[i].Score = int64(math.Round(float64(scores[i].Score) * float64(framework.MaxNodeScore) / float64(most) * rs.scoreWeight))
+	}
+}
+
+// placementStateKey holds the metric of the node a pod is reserved on, from
+// Reserve to PostBind
+const placementStateKey framework.StateKey = Name + "/placement"
+
+type placementState struct {
+	rcpu int64
+}
+
+func (s *placementState) Clone() framework.StateData {
+	return s
+}
+
+// Reserve logs the decision, and remembers the metric of the chosen node at
+// decision time, binding may well happen after the next annotation.
+func (rs *RCPUScheduler) Reserve(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) *framework.Status {
+	if decisions := readDecisions(state); decisions != nil {
+		decisions.log(pod, nodeName)
+	}
+
+	if rs.placements == nil {
+		return framework.NewStatus(framework.Success, "")
+	}
+
+	nodeInfo, err := rs.handle.SnapshotSharedLister().NodeInfos().Get(nodeName)
+	if err != nil || nodeInfo.Node() == nil {
+		return framework.NewStatus(framework.Success, "")
+	}
+
+	if rcpu, ok := getRCPU(nodeInfo.Node().Annotations, rs.policy.Policy().For(pod.Namespace).Metric); ok {
+		state.Write(placementStateKey, &placementState{rcpu: rcpu})
+	}
+
+	return framework.NewStatus(framework.Success, "")
+}
+
+// Unreserve has nothing to undo, placements are only recorded once bound.
+func (rs *RCPUScheduler) Unreserve(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) {
+}
+
+// PostBind records the placement of the pod, unless its node had no metric.
+func (rs *RCPUScheduler) PostBind(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) {
+	if rs.placements == nil {
+		return
+	}
+
+	data, err := state.Read(placementStateKey)
+	if err != nil {
+		return
+	}
+	s, ok := data.(*placementState)
+	if !ok {
+		return
+	}
+
+	placement := Placement{
+		Pod:     pod.Namespace + "/" + pod.Name,
+		Arrival: pod.CreationTimestamp.Time,
+		Time:    time.Now(),
+		Node:    nodeName,
+		RCPU:    s.rcpu,
+	}
+	klog.V(4).InfoS("Recording placement", "pod", klog.KObj(pod), "node", nodeName, "rcpu", s.rcpu)
+	rs.placements.Record(placement)
+}
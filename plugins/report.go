@@ -0,0 +1,183 @@
+package rcpu
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// DefaultHotThreshold marks a placement onto a node at or above 70% rcpu
+// utilization as a hot spot.
+const DefaultHotThreshold = int64(0.7 * 1000)
+
+type PlacementSummary struct {
+	Name string `json:"name"`
+
+	Placed        int `json:"placed"`
+	Unschedulable int `json:"unschedulable"`
+
+	// Hot-spotting
+	MaxPodsPerNode    int     `json:"maxPodsPerNode"`
+	PodsPerNodeStdDev float64 `json:"podsPerNodeStdDev"`
+	HotPlacements     int     `json:"hotPlacements"`
+
+	// Pending time of the placed pods
+	MeanPending time.Duration `json:"meanPending"`
+	MaxPending  time.Duration `json:"maxPending"`
+
+	// Interference, the node's rcpu utilization the pods landed on
+	MeanRCPU    float64          `json:"meanRCPU"`
+	NodeMaxRCPU map[string]int64 `json:"nodeMaxRCPU"`
+}
+
+// LoadSimulationResult reads a result previously written as JSON.
+func LoadSimulationResult(r io.Reader) (*SimulationResult, error) {
+	var result SimulationResult
+	if err := json.NewDecoder(r).Decode(&result); err != nil {
+		return nil, fmt.Errorf("malformed simulation result: %v", err)
+	}
+
+	return &result, nil
+}
+
+func SummarizePlacements(result *SimulationResult, hotThreshold int64) PlacementSummary {
+	summary := PlacementSummary{
+		Name:          result.Policy.Name,
+		Unschedulable: result.Unschedulable,
+		NodeMaxRCPU:   make(map[string]int64),
+	}
+
+	var totalPending time.Duration
+	var totalRCPU int64
+	for _, placement := range result.Placements {
+		if placement.Node == "" {
+			continue
+		}
+		summary.Placed++
+
+		pending := placement.Time.Sub(placement.Arrival)
+		totalPending += pending
+		summary.MaxPending = max(summary.MaxPending, pending)
+
+		totalRCPU += placement.RCPU
+		summary.NodeMaxRCPU[placement.Node] = max(summary.NodeMaxRCPU[placement.Node], placement.RCPU)
+
+		if placement.RCPU >= hotThreshold {
+			summary.HotPlacements++
+		}
+	}
+
+	if summary.Placed > 0 {
+		summary.MeanPending = totalPending / time.Duration(summary.Placed)
+		summary.MeanRCPU = float64(totalRCPU) / float64(summary.Placed)
+	}
+
+	if len(result.PodsPerNode) > 0 {
+		var sum, sumSquares float64
+		for _, pods := range result.PodsPerNode {
+			summary.MaxPodsPerNode = max(summary.MaxPodsPerNode, pods)
+			sum += float64(pods)
+			sumSquares += float64(pods) * float64(pods)
+		}
+
+		n := float64(len(result.PodsPerNode))
+		mean := sum / n
+		summary.PodsPerNodeStdDev = math.Sqrt(max(0, sumSquares/n-mean*mean))
+	}
+
+	return summary
+}
+
+// WriteComparisonReport compares the placement of a baseline, e.g. the
+// default scoring, with a candidate, e.g. the RCPU scoring.
+func WriteComparisonReport(w io.Writer, baseline, candidate *SimulationResult, hotThreshold int64) {
+	a := SummarizePlacements(baseline, hotThreshold)
+	b := SummarizePlacements(candidate, hotThreshold)
+
+	fmt.Fprintf(w, "%-24s %16s %16s %12s\n", "", a.Name, b.Name, "delta")
+
+	row := func(name string, x, y float64, format string) {
+		fmt.Fprintf(w, "%-24s %16s %16s %12s\n", name,
+			fmt.Sprintf(format, x), fmt.Sprintf(format, y), fmt.Sprintf("%+"+format[1:], y-x))
+	}
+
+	row("placed", float64(a.Placed), float64(b.Placed), "%.0f")
+	row("unschedulable", float64(a.Unschedulable), float64(b.Unschedulable), "%.0f")
+	row("max pods per node", float64(a.MaxPodsPerNode), float64(b.MaxPodsPerNode), "%.0f")
+	row("pods per node stddev", a.PodsPerNodeStdDev, b.PodsPerNodeStdDev, "%.2f")
+	row("hot placements", float64(a.HotPlacements), float64(b.HotPlacements), "%.0f")
+	row("mean pending (s)", a.MeanPending.Seconds(), b.MeanPending.Seconds(), "%.1f")
+	row("max pending (s)", a.MaxPending.Seconds(), b.MaxPending.Seconds(), "%.1f")
+	row("mean rcpu at placement", a.MeanRCPU, b.MeanRCPU, "%.1f")
+
+	fmt.Fprintf(w, "pods moved: %d\n", SimulationDiff(baseline, candidate))
+
+	// Per node interference, sorted by the worst node under the baseline
+	nodes := make(map[string]bool)
+	for node := range a.NodeMaxRCPU {
+		nodes[node] = true
+	}
+	for node := range b.NodeMaxRCPU {
+		nodes[node] = true
+	}
+
+	names := make([]string, 0, len(nodes))
+	for node := range nodes {
+		names = append(names, node)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if a.NodeMaxRCPU[names[i]] != a.NodeMaxRCPU[names[j]] {
+			return a.NodeMaxRCPU[names[i]] > a.NodeMaxRCPU[names[j]]
+		}
+
+		return names[i] < names[j]
+	})
+
+	fmt.Fprintf(w, "\n%-24s %16s %16s\n", "max rcpu at placement", a.Name, b.Name)
+	for _, node := range names {
+		fmt.Fprintf(w, "%-24s %16d %16d\n", node, a.NodeMaxRCPU[node], b.NodeMaxRCPU[node])
+	}
+}
+
+func loadSimulationResultFile(path string) (*SimulationResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return LoadSimulationResult(f)
+}
+
+// RunReport runs the report command, which compares two results written by
+// simulate -out-dir.
+func RunReport(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	baselinePath := fs.String("baseline", "", "result of the baseline policy")
+	candidatePath := fs.String("candidate", "", "result of the candidate policy")
+	hotThreshold := fs.Int64("hot-threshold", DefaultHotThreshold, "rcpu at or above which a placement counts as a hot spot")
+	fs.Parse(args)
+
+	if *baselinePath == "" || *candidatePath == "" {
+		return fmt.Errorf("-baseline and -candidate are required")
+	}
+
+	baseline, err := loadSimulationResultFile(*baselinePath)
+	if err != nil {
+		return err
+	}
+
+	candidate, err := loadSimulationResultFile(*candidatePath)
+	if err != nil {
+		return err
+	}
+
+	WriteComparisonReport(os.Stdout, baseline, candidate, *hotThreshold)
+
+	return nil
+}
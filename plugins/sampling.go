@@ -0,0 +1,62 @@
+package rcpu
+
+import (
+	"math/rand/v2"
+
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// sampledNodesStateKey holds the nodes Score ranks with a score sample size,
+// from PreScore to Score
+const sampledNodesStateKey framework.StateKey = Name + "/sampled-nodes"
+
+type sampledNodes struct {
+	names map[string]bool
+}
+
+func (s *sampledNodes) Clone() framework.StateData {
+	return s
+}
+
+// sampleNodes picks k of the feasible nodes at random, nil when there are no
+// more than k of them, and every node is ranked.
+func sampleNodes(nodes []*framework.NodeInfo, k int) *sampledNodes {
+	if k <= 0 || len(nodes) <= k {
+		return nil
+	}
+
+	names := make([]string, 0, len(nodes))
+	for _, nodeInfo := range nodes {
+		if node := nodeInfo.Node(); node != nil {
+			names = append(names, node.Name)
+		}
+	}
+
+	// A partial shuffle, only the first k are needed
+	sampled := &sampledNodes{names: make(map[string]bool, k)}
+	for i := 0; i < k && i < len(names); i++ {
+		j := i + rand.IntN(len(names)-i)
+		names[i], names[j] = names[j], names[i]
+		sampled.names[names[i]] = true
+	}
+
+	return sampled
+}
+
+// readSampledNodes returns the nodes PreScore sampled, nil when every node is
+// ranked.
+func readSampledNodes(state *framework.CycleState) *sampledNodes {
+	data, err := state.Read(sampledNodesStateKey)
+	if err != nil {
+		return nil
+	}
+
+	sampled, _ := data.(*sampledNodes)
+	return sampled
+}
+
+// ranks reports whether Score ranks the node rather than giving it the
+// neutral score.
+func (s *sampledNodes) ranks(nodeName string) bool {
+	return s == nil || s.names[nodeName]
+}
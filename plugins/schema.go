@@ -0,0 +1,130 @@
+package rcpu
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	// RCPUSchemaKey versions the format of the metric annotations, a node
+	// without it has the v1 format
+	RCPUSchemaKey = "rcpu-scheduler/schema"
+	// RCPUCapacityKey is the number of physical cores of the node, only
+	// written with the v2 schema
+	RCPUCapacityKey = "rcpu-scheduler/capacity"
+
+	// SchemaV1 has the metrics as bare per-mille integers and the free cores
+	// as a bare count
+	SchemaV1 = "v1"
+	// SchemaV2 has explicit units, the metrics in percent, e.g. 45.0%, and
+	// the cores with a cores suffix, e.g. 3cores
+	SchemaV2 = "v2"
+
+	// DefaultSchema is understood by every version of the plugin
+	DefaultSchema = SchemaV1
+
+	coresUnit = "cores"
+)
+
+var Schemas = []string{SchemaV1, SchemaV2}
+
+func supportedSchema(schema string) bool {
+	return schema == SchemaV1 || schema == SchemaV2
+}
+
+// annotationSchema returns the schema of the annotations, v1 when unset.
+func annotationSchema(annotations map[string]string) string {
+	if schema, ok := annotations[RCPUSchemaKey]; ok {
+		return schema
+	}
+
+	return SchemaV1
+}
+
+// parseMetric parses a metric of the schema into the per-mille scale, not
+// range checked.
+func parseMetric(schema, value string) (int64, error) {
+	switch schema {
+	case SchemaV1:
+		return strconv.ParseInt(value, 10, 64)
+	case SchemaV2:
+		number, ok := strings.CutSuffix(value, "%")
+		if !ok {
+			return 0, fmt.Errorf("%q has no %% unit", value)
+		}
+
+		percent, err := strconv.ParseFloat(number, 64)
+		if err != nil || math.IsNaN(percent) || math.IsInf(percent, 0) {
+			return 0, fmt.Errorf("%q is not a percentage", value)
+		}
+
+		return int64(math.Round(percent * float64(RCPUMaxScore) / 100)), nil
+	}
+
+	return 0, fmt.Errorf("unsupported schema %q", schema)
+}
+
+func formatMetric(schema string, rcpu int64) string {
+	if schema == SchemaV2 {
+		return strconv.FormatFloat(float64(rcpu)*100/float64(RCPUMaxScore), 'f', 1, 64) + "%"
+	}
+
+	return strconv.FormatInt(rcpu, 10)
+}
+
+// parseCores parses a number of cores of the schema.
+func parseCores(schema, value string) (int64, error) {
+	switch schema {
+	case SchemaV1:
+		return strconv.ParseInt(value, 10, 64)
+	case SchemaV2:
+		number, ok := strings.CutSuffix(value, coresUnit)
+		if !ok {
+			return 0, fmt.Errorf("%q has no %s unit", value, coresUnit)
+		}
+
+		return strconv.ParseInt(number, 10, 64)
+	}
+
+	return 0, fmt.Errorf("unsupported schema %q", schema)
+}
+
+func formatCores(schema string, cores int64) string {
+	if schema == SchemaV2 {
+		return strconv.FormatInt(cores, 10) + coresUnit
+	}
+
+	return strconv.FormatInt(cores, 10)
+}
+
+// encodeSchema rewrites the v1 annotations of UsageSeries in the schema. The
+// v2 schema names itself and adds the capacity, unless it is unknown.
+func encodeSchema(annotations map[string]string, schema string, capacity int) map[string]string {
+	if schema == SchemaV1 {
+		return annotations
+	}
+
+	encoded := make(map[string]string, len(annotations)+2)
+	for key, value := range annotations {
+		switch {
+		case isPerMilleMetric(key):
+			if rcpu, err := parseMetric(SchemaV1, value); err == nil {
+				value = formatMetric(schema, rcpu)
+			}
+		case key == RCPUFreeCoresKey:
+			if cores, err := parseCores(SchemaV1, value); err == nil {
+				value = formatCores(schema, cores)
+			}
+		}
+		encoded[key] = value
+	}
+
+	encoded[RCPUSchemaKey] = schema
+	if capacity > 0 {
+		encoded[RCPUCapacityKey] = formatCores(schema, int64(capacity))
+	}
+
+	return encoded
+}
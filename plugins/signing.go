@@ -0,0 +1,139 @@
+package rcpu
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RCPUTimestampKey = "rcpu-scheduler/timestamp" // Unix seconds when the metrics were written
+	RCPUSignatureKey = "rcpu-scheduler/signature" // Hex HMAC-SHA256 over the node name, the metrics and the timestamp
+
+	// DefaultMaxSignatureAge leaves room for a few missed refreshes of the
+	// annotator, which re-signs at least every DefaultMaxInterval plus jitter
+	DefaultMaxSignatureAge = 5 * time.Minute
+)
+
+// signedKeys are the annotations covered by the signature. The feature gate is
+// left out so operators can still toggle it by hand, the plugin rejects a
+// signed node with the gate turned off instead, see isTrusted.
+var signedKeys = []string{
+	RCPUMetric1mKey,
+	RCPUMetric5mKey,
+	RCPUMetric15mKey,
+	RCPUAvg1mKey,
+	RCPUAvg5mKey,
+	RCPUAvg15mKey,
+	RCPUFreeCoresKey,
+	RCPUCapacityKey,
+	RCPUProviderKey,
+	RCPURangeKey,
+	RCPUSchemaKey,
+	RCPUPayloadKey,
+	RCPUTimestampKey,
+}
+
+func LoadSigningKey(path string) ([]byte, error) {
+	out, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key %s: %v", path, err)
+	}
+
+	key := []byte(strings.TrimSpace(string(out)))
+	if len(key) == 0 {
+		return nil, fmt.Errorf("signing key %s is empty", path)
+	}
+
+	return key, nil
+}
+
+// signaturePayload binds the metrics to the node, so a signed set copied onto
+// another node fails verification
+func signaturePayload(nodeName string, annotations map[string]string) string {
+	keys := make([]string, 0, len(signedKeys))
+	for _, key := range signedKeys {
+		if _, ok := annotations[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString("node=")
+	sb.WriteString(nodeName)
+	sb.WriteByte('\n')
+	for _, key := range keys {
+		sb.WriteString(key)
+		sb.WriteByte('=')
+		sb.WriteString(annotations[key])
+		sb.WriteByte('\n')
+	}
+
+	return sb.String()
+}
+
+func computeSignature(key []byte, nodeName string, annotations map[string]string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signaturePayload(nodeName, annotations)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// StampAnnotations records when the metric annotations were written, which
+// the plugin reports as their age.
+func StampAnnotations(annotations map[string]string, now time.Time) {
+	annotations[RCPUTimestampKey] = strconv.FormatInt(now.Unix(), 10)
+}
+
+// SignAnnotations stamps the metric annotations of the node with the current
+// time and signs them, for use by whatever writes the annotations onto it.
+func SignAnnotations(key []byte, nodeName string, annotations map[string]string, now time.Time) {
+	StampAnnotations(annotations, now)
+	annotations[RCPUSignatureKey] = computeSignature(key, nodeName, annotations)
+}
+
+// VerifyAnnotations checks the signature written by SignAnnotations for the
+// node, and that it is no older than maxAge so a stale set can't be replayed.
+func VerifyAnnotations(key []byte, nodeName string, annotations map[string]string, now time.Time, maxAge time.Duration) error {
+	signature, ok := annotations[RCPUSignatureKey]
+	if !ok {
+		return fmt.Errorf("missing %s annotation", RCPUSignatureKey)
+	}
+
+	timestampStr, ok := annotations[RCPUTimestampKey]
+	if !ok {
+		return fmt.Errorf("missing %s annotation", RCPUTimestampKey)
+	}
+
+	timestamp, err := strconv.ParseInt(timestampStr, 10, 64)
+	if err != nil {
+		return fmt.Errorf("malformed %s annotation %q", RCPUTimestampKey, timestampStr)
+	}
+
+	// A little skew between the writer's and the scheduler's clocks is fine
+	if age := now.Sub(time.Unix(timestamp, 0)); age > maxAge || age < -maxAge {
+		return fmt.Errorf("signature is %v old, more than %v", age.Round(time.Second), maxAge)
+	}
+
+	expected, err := hex.DecodeString(computeSignature(key, nodeName, annotations))
+	if err != nil {
+		return err
+	}
+
+	actual, err := hex.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("malformed signature: %v", err)
+	}
+
+	if !hmac.Equal(expected, actual) {
+		return fmt.Errorf("signature mismatch")
+	}
+
+	return nil
+}
@@ -0,0 +1,442 @@
+package rcpu
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// NodeRecord is one line of a recorded trace, the annotations of a node at a
+// point in time.
+type NodeRecord struct {
+	Time        time.Time         `json:"time"`
+	Node        string            `json:"node"`
+	Annotations map[string]string `json:"annotations"`
+	// AllocatableCPU is needed to account for pod demands, e.g. "64"
+	AllocatableCPU string `json:"allocatableCPU,omitempty"`
+}
+
+// LoadTrace reads JSON lines of NodeRecord, sorted by time.
+func LoadTrace(r io.Reader) ([]NodeRecord, error) {
+	var records []NodeRecord
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; s.Scan(); line++ {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+
+		var record NodeRecord
+		if err := json.Unmarshal(s.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("malformed trace record on line %d: %v", line, err)
+		}
+		records = append(records, record)
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read trace: %v", err)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+
+	return records, nil
+}
+
+const (
+	StrategyRCPU   = "rcpu"   // Filter and Score like the plugin does
+	StrategySpread = "spread" // Ignore RCPU and spread pods evenly, as a baseline
+)
+
+type SimulationPolicy struct {
+	Name      string
+	Strategy  string
+	Metric    string
+	Threshold int64
+}
+
+type Placement struct {
+	Pod     string    `json:"pod"`
+	Arrival time.Time `json:"arrival"`
+	// Time and Node are empty if the pod was never scheduled
+	Time time.Time `json:"time"`
+	Node string    `json:"node"`
+	// RCPU is the metric of the node at decision time, including pending demand
+	RCPU int64 `json:"rcpu"`
+}
+
+type SimulationResult struct {
+	Policy        SimulationPolicy `json:"policy"`
+	Placements    []Placement      `json:"placements"`
+	Unschedulable int              `json:"unschedulable"`
+	// PodsPerNode has every node of the trace, including those with no pods
+	PodsPerNode map[string]int `json:"podsPerNode"`
+}
+
+type simulatedNode struct {
+	node *v1.Node
+	// demand placed since the last record, which the annotations don't reflect yet
+	pendingDemand int64
+}
+
+func newSimulatedNode(record *NodeRecord) (*simulatedNode, error) {
+	node := &v1.Node{}
+	node.Name = record.Node
+	node.Annotations = record.Annotations
+
+	if record.AllocatableCPU != "" {
+		cpu, err := resource.ParseQuantity(record.AllocatableCPU)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allocatable CPU of node %s: %v", record.Node, err)
+		}
+		node.Status.Allocatable = v1.ResourceList{v1.ResourceCPU: cpu}
+	}
+
+	return &simulatedNode{node: node}, nil
+}
+
+func podArrival(pod *v1.Pod, start time.Time) time.Time {
+	if pod.CreationTimestamp.IsZero() {
+		return start
+	}
+
+	return pod.CreationTimestamp.Time
+}
+
+// Simulate replays the pods against the recorded node annotations and returns
+// where each pod would have been placed under the policy. Pods placed on a
+// node count against it until the next record of that node, mimicking the
+// staleness of the annotations. Unschedulable pods stay pending and are
+// retried whenever new records arrive.
+func Simulate(trace []NodeRecord, pods []*v1.Pod, policy SimulationPolicy) (*SimulationResult, error) {
+	result := &SimulationResult{
+		Policy:      policy,
+		PodsPerNode: make(map[string]int),
+	}
+
+	if len(trace) == 0 {
+		return result, nil
+	}
+
+	// Nodes that get no pods count too, or the spread would look even
+	for _, record := range trace {
+		result.PodsPerNode[record.Node] = 0
+	}
+
+	start := trace[0].Time
+	sorted := make([]*v1.Pod, len(pods))
+	copy(sorted, pods)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return podArrival(sorted[i], start).Before(podArrival(sorted[j], start))
+	})
+
+	nodes := make(map[string]*simulatedNode)
+	next := 0
+
+	var pending []*v1.Pod
+	schedule := func(pod *v1.Pod, now time.Time) bool {
+		node, rcpu := simulateSchedule(nodes, pod, policy, result.PodsPerNode)
+		if node == "" {
+			return false
+		}
+
+		result.PodsPerNode[node]++
+		nodes[node].pendingDemand += getPodDemand(pod, nodes[node].node)
+		result.Placements = append(result.Placements, Placement{
+			Pod:     pod.Namespace + "/" + pod.Name,
+			Arrival: podArrival(pod, start),
+			Time:    now,
+			Node:    node,
+			RCPU:    rcpu,
+		})
+
+		return true
+	}
+
+	// advance applies the records up to now and retries the pending pods
+	advance := func(now time.Time) error {
+		for ; next < len(trace) && !trace[next].Time.After(now); next++ {
+			node, err := newSimulatedNode(&trace[next])
+			if err != nil {
+				return err
+			}
+			nodes[trace[next].Node] = node
+
+			// Retry as soon as a node reports, like a node update event requeues pods
+			if next+1 == len(trace) || trace[next+1].Time.After(trace[next].Time) {
+				var stillPending []*v1.Pod
+				for _, pod := range pending {
+					if !schedule(pod, trace[next].Time) {
+						stillPending = append(stillPending, pod)
+					}
+				}
+				pending = stillPending
+			}
+		}
+
+		return nil
+	}
+
+	for _, pod := range sorted {
+		arrival := podArrival(pod, start)
+		if err := advance(arrival); err != nil {
+			return nil, err
+		}
+
+		if !schedule(pod, arrival) {
+			pending = append(pending, pod)
+		}
+	}
+
+	// Drain the rest of the trace for pods still waiting
+	if len(pending) > 0 {
+		if err := advance(trace[len(trace)-1].Time); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, pod := range pending {
+		result.Placements = append(result.Placements, Placement{
+			Pod:     pod.Namespace + "/" + pod.Name,
+			Arrival: podArrival(pod, start),
+		})
+	}
+	result.Unschedulable = len(pending)
+
+	return result, nil
+}
+
+// annotations folds the pending demand into the metric, as if the annotator
+// had caught up. A missing metric stays missing.
+func (sn *simulatedNode) annotations(metric string) map[string]string {
+	rcpu, ok := getRCPU(sn.node.Annotations, metric)
+	if !ok || sn.pendingDemand == 0 {
+		return sn.node.Annotations
+	}
+
+	annotations := make(map[string]string, len(sn.node.Annotations))
+	for key, value := range sn.node.Annotations {
+		annotations[key] = value
+	}
+	annotations[metric] = formatAnnotatedMetric(annotations, min(RCPUMaxScore, rcpu+sn.pendingDemand))
+
+	return annotations
+}
+
+// simulateSchedule filters and scores the nodes the way the plugin does, an
+// empty node means the pod can't be scheduled for now.
+func simulateSchedule(nodes map[string]*simulatedNode, pod *v1.Pod, policy SimulationPolicy, podsPerNode map[string]int) (string, int64) {
+	best, bestScore, bestRCPU := "", int64(-1), int64(0)
+	for name, sn := range nodes {
+		annotations := sn.annotations(policy.Metric)
+		rcpu, _ := getRCPU(annotations, policy.Metric)
+
+		var score int64
+		switch policy.Strategy {
+		case StrategySpread:
+			score = RCPUMaxScore - int64(podsPerNode[name])
+		default:
+			if !filterAnnotations(annotations, policy.Metric, policy.Threshold-getPodDemand(pod, sn.node)) {
+				continue
+			}
+
+			var ok bool
+			if score, ok = scoreAnnotations(annotations, policy.Metric); !ok {
+				// Score fails the whole scheduling cycle of the pod
+				return "", 0
+			}
+		}
+
+		if score > bestScore || (score == bestScore && name < best) {
+			best, bestScore, bestRCPU = name, score, rcpu
+		}
+	}
+
+	return best, bestRCPU
+}
+
+// SimulationDiff counts how many pods landed on a different node than under
+// the baseline policy.
+func SimulationDiff(baseline, other *SimulationResult) int {
+	baselineNodes := make(map[string]string, len(baseline.Placements))
+	for _, placement := range baseline.Placements {
+		baselineNodes[placement.Pod] = placement.Node
+	}
+
+	moved := 0
+	for _, placement := range other.Placements {
+		if baselineNodes[placement.Pod] != placement.Node {
+			moved++
+		}
+	}
+
+	return moved
+}
+
+// WriteSimulationReport prints a summary of every result compared to the first.
+func WriteSimulationReport(w io.Writer, results []*SimulationResult) {
+	for _, result := range results {
+		busiest, busiestPods := "", 0
+		for node, pods := range result.PodsPerNode {
+			if pods > busiestPods || (pods == busiestPods && node < busiest) {
+				busiest, busiestPods = node, pods
+			}
+		}
+
+		fmt.Fprintf(w, "%s: strategy=%s metric=%s threshold=%d placed=%d unschedulable=%d busiest=%s(%d)",
+			result.Policy.Name, result.Policy.Strategy, result.Policy.Metric, result.Policy.Threshold,
+			len(result.Placements)-result.Unschedulable, result.Unschedulable, busiest, busiestPods)
+
+		if result != results[0] {
+			fmt.Fprintf(w, " moved=%d", SimulationDiff(results[0], result))
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// LoadPods reads the pods to replay from YAML documents or JSON objects.
+func LoadPods(r io.Reader) ([]*v1.Pod, error) {
+	var pods []*v1.Pod
+
+	decoder := yaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		pod := &v1.Pod{}
+		if err := decoder.Decode(pod); err != nil {
+			if errors.Is(err, io.EOF) {
+				return pods, nil
+			}
+			return nil, fmt.Errorf("malformed pod: %v", err)
+		}
+
+		// Skip empty documents
+		if pod.Name == "" {
+			continue
+		}
+		pods = append(pods, pod)
+	}
+}
+
+// ParseSimulationPolicy parses name:strategy[:threshold[:metric]], the
+// threshold and metric default to the plugin's.
+func ParseSimulationPolicy(value string) (SimulationPolicy, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 || len(parts) > 4 || parts[0] == "" {
+		return SimulationPolicy{}, fmt.Errorf("invalid policy %q, expected name:strategy[:threshold[:metric]]", value)
+	}
+
+	policy := SimulationPolicy{
+		Name:      parts[0],
+		Strategy:  parts[1],
+		Metric:    DefaultRCPUMetric,
+		Threshold: DefaultRCPUThreshold,
+	}
+
+	if policy.Strategy != StrategyRCPU && policy.Strategy != StrategySpread {
+		return SimulationPolicy{}, fmt.Errorf("unknown strategy %q of policy %s", policy.Strategy, policy.Name)
+	}
+
+	if len(parts) > 2 {
+		threshold, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil || threshold <= 0 || threshold > RCPUMaxScore {
+			return SimulationPolicy{}, fmt.Errorf("invalid threshold %q of policy %s", parts[2], policy.Name)
+		}
+		policy.Threshold = threshold
+	}
+
+	if len(parts) > 3 {
+		policy.Metric = parts[3]
+	}
+
+	return policy, nil
+}
+
+// RunSimulate runs the simulate command, which replays the pods against a
+// trace under every policy and reports how the placements differ from the
+// first policy's.
+func RunSimulate(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	tracePath := fs.String("trace", "", "trace of the node annotations, JSON lines of NodeRecord")
+	podsPath := fs.String("pods", "", "pods to replay, YAML documents or JSON objects, arriving at their creationTimestamp")
+	var policies []SimulationPolicy
+	fs.Func("policy", "policy as name:strategy[:threshold[:metric]], repeated, the first is the baseline (default spread:spread and rcpu:rcpu)", func(value string) error {
+		policy, err := ParseSimulationPolicy(value)
+		if err != nil {
+			return err
+		}
+		policies = append(policies, policy)
+		return nil
+	})
+	outDir := fs.String("out-dir", "", "also write the result of every policy to NAME.json in this directory")
+	fs.Parse(args)
+
+	if *tracePath == "" || *podsPath == "" {
+		return fmt.Errorf("-trace and -pods are required")
+	}
+
+	if len(policies) == 0 {
+		policies = []SimulationPolicy{
+			{Name: "spread", Strategy: StrategySpread, Metric: DefaultRCPUMetric, Threshold: DefaultRCPUThreshold},
+			{Name: "rcpu", Strategy: StrategyRCPU, Metric: DefaultRCPUMetric, Threshold: DefaultRCPUThreshold},
+		}
+	}
+
+	traceFile, err := os.Open(*tracePath)
+	if err != nil {
+		return err
+	}
+	defer traceFile.Close()
+
+	trace, err := LoadTrace(traceFile)
+	if err != nil {
+		return err
+	}
+
+	podsFile, err := os.Open(*podsPath)
+	if err != nil {
+		return err
+	}
+	defer podsFile.Close()
+
+	pods, err := LoadPods(podsFile)
+	if err != nil {
+		return err
+	}
+
+	var results []*SimulationResult
+	for _, policy := range policies {
+		result, err := Simulate(trace, pods, policy)
+		if err != nil {
+			return fmt.Errorf("failed to simulate policy %s: %v", policy.Name, err)
+		}
+		results = append(results, result)
+
+		if *outDir != "" {
+			out, err := json.MarshalIndent(result, "", "  ")
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(filepath.Join(*outDir, policy.Name+".json"), out, 0644); err != nil {
+				return fmt.Errorf("failed to write result of policy %s: %v", policy.Name, err)
+			}
+		}
+	}
+
+	WriteSimulationReport(os.Stdout, results)
+
+	return nil
+}
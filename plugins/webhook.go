@@ -0,0 +1,450 @@
+package rcpu
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+)
+
+const (
+	RCPUDemandKey        = "rcpu-scheduler/demand"         // Pod annotation holding the estimated SMT-adjusted demand in millicores
+	RCPUWorkloadClassKey = "rcpu-scheduler/workload-class" // Pod label or annotation selecting the workload class factor
+
+	DefaultWorkloadClassFactor = 1.0
+
+	DefaultWebhookListen = ":8443"
+	// DemandWebhookPath is where the webhook command serves the DemandWebhook
+	DemandWebhookPath = "/mutate-pods"
+	// AnnotationValidatorPath is where it serves the AnnotationValidator
+	AnnotationValidatorPath = "/validate-nodes"
+)
+
+// DemandWebhook is a mutating admission webhook that annotates incoming pods
+// with an estimated RCPU demand, so the filter can account for the pod without
+// every team changing their manifests.
+type DemandWebhook struct {
+	// ClassFactors maps a workload class to the multiplier applied to the
+	// pod's CPU requests, e.g. latency-sensitive pods suffer more from a busy
+	// sibling thread than batch pods do.
+	ClassFactors  map[string]float64
+	DefaultFactor float64
+}
+
+func NewDemandWebhook(classFactors map[string]float64) *DemandWebhook {
+	return &DemandWebhook{
+		ClassFactors:  classFactors,
+		DefaultFactor: DefaultWorkloadClassFactor,
+	}
+}
+
+// ParseWorkloadClassFactors parses a mapping like "batch=0.5,latency=1.5".
+func ParseWorkloadClassFactors(s string) (map[string]float64, error) {
+	factors := make(map[string]float64)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		class, factorStr, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid workload class mapping %q, expected class=factor", item)
+		}
+
+		factor, err := strconv.ParseFloat(strings.TrimSpace(factorStr), 64)
+		if err != nil || factor < 0 {
+			return nil, fmt.Errorf("invalid factor for workload class %q: %q", class, factorStr)
+		}
+
+		factors[strings.TrimSpace(class)] = factor
+	}
+
+	return factors, nil
+}
+
+func workloadClass(pod *v1.Pod) string {
+	if class, ok := pod.Labels[RCPUWorkloadClassKey]; ok {
+		return class
+	}
+
+	return pod.Annotations[RCPUWorkloadClassKey]
+}
+
+// podRequest sums the pod's requests of the resource
+func podRequest(pod *v1.Pod, name v1.ResourceName) resource.Quantity {
+	var total resource.Quantity
+	for _, container := range pod.Spec.Containers {
+		// Fall back to the limit, since the API server defaults requests to limits anyway
+		if request, ok := container.Resources.Requests[name]; ok {
+			total.Add(request)
+		} else if limit, ok := container.Resources.Limits[name]; ok {
+			total.Add(limit)
+		}
+	}
+
+	// Init containers run sequentially, so only the largest one matters
+	for _, container := range pod.Spec.InitContainers {
+		if request, ok := container.Resources.Requests[name]; ok && request.Cmp(total) > 0 {
+			total = request.DeepCopy()
+		}
+	}
+
+	return total
+}
+
+func podCPUMillis(pod *v1.Pod) int64 {
+	cpu := podRequest(pod, v1.ResourceCPU)
+	return cpu.MilliValue()
+}
+
+// EstimateDemand returns the pod's estimated RCPU demand in millicores.
+func (dw *DemandWebhook) EstimateDemand(pod *v1.Pod) int64 {
+	factor := dw.DefaultFactor
+	if f, ok := dw.ClassFactors[workloadClass(pod)]; ok {
+		factor = f
+	}
+
+	return int64(float64(podCPUMillis(pod)) * factor)
+}
+
+type jsonPatchOp struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value,omitempty"`
+}
+
+func escapeJSONPointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
+
+func (dw *DemandWebhook) mutate(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	resp := &admissionv1.AdmissionResponse{UID: req.UID, Allowed: true}
+
+	if req.Kind.Kind != "Pod" || req.Operation != admissionv1.Create {
+		return resp
+	}
+
+	var pod v1.Pod
+	if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
+		// Never block pod creation because of the estimate
+		resp.Warnings = []string{fmt.Sprintf("rcpu: failed to decode pod: %v", err)}
+		return resp
+	}
+
+	// Respect explicit demands set by the owner
+	if _, ok := pod.Annotations[RCPUDemandKey]; ok || IsDaemonSetPod(&pod) {
+		return resp
+	}
+
+	demand := strconv.FormatInt(dw.EstimateDemand(&pod), 10)
+
+	var patch []jsonPatchOp
+	if pod.Annotations == nil {
+		patch = append(patch, jsonPatchOp{Op: "add", Path: "/metadata/annotations", Value: map[string]string{RCPUDemandKey: demand}})
+	} else {
+		patch = append(patch, jsonPatchOp{Op: "add", Path: "/metadata/annotations/" + escapeJSONPointer(RCPUDemandKey), Value: demand})
+	}
+
+	patchBytes, err := json.Marshal(patch)
+	if err != nil {
+		resp.Warnings = []string{fmt.Sprintf("rcpu: failed to encode patch: %v", err)}
+		return resp
+	}
+
+	patchType := admissionv1.PatchTypeJSONPatch
+	resp.Patch = patchBytes
+	resp.PatchType = &patchType
+
+	return resp
+}
+
+func serveAdmissionReview(w http.ResponseWriter, r *http.Request, admit func(*admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var review admissionv1.AdmissionReview
+	if err := json.Unmarshal(body, &review); err != nil || review.Request == nil {
+		http.Error(w, "malformed admission review", http.StatusBadRequest)
+		return
+	}
+
+	review.Response = admit(review.Request)
+	review.Request = nil
+	review.TypeMeta = metav1.TypeMeta{APIVersion: admissionv1.SchemeGroupVersion.String(), Kind: "AdmissionReview"}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&review); err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
+	}
+}
+
+func (dw *DemandWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serveAdmissionReview(w, r, dw.mutate)
+}
+
+// AnnotationValidator is a validating admission webhook for Node objects that
+// only lets the agent's service accounts write rcpu-scheduler/* annotations,
+// and only with well-formed values. The rcpu-scheduler/enable feature gate is
+// the exception, anyone allowed to update the node may toggle it.
+type AnnotationValidator struct {
+	// AllowedUsers are the usernames allowed to change the annotations, e.g.
+	// "system:serviceaccount:rcpu-system:rcpu-agent"
+	AllowedUsers map[string]bool
+}
+
+func NewAnnotationValidator(allowedUsers ...string) *AnnotationValidator {
+	av := &AnnotationValidator{AllowedUsers: make(map[string]bool)}
+	for _, user := range allowedUsers {
+		av.AllowedUsers[user] = true
+	}
+
+	return av
+}
+
+func changedRCPUAnnotations(oldAnnotations, newAnnotations map[string]string) []string {
+	var changed []string
+	for key, value := range newAnnotations {
+		if !strings.HasPrefix(key, RCPUAnnotationPrefix) {
+			continue
+		}
+
+		if oldValue, ok := oldAnnotations[key]; !ok || oldValue != value {
+			changed = append(changed, key)
+		}
+	}
+
+	for key := range oldAnnotations {
+		if !strings.HasPrefix(key, RCPUAnnotationPrefix) {
+			continue
+		}
+
+		if _, ok := newAnnotations[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+
+	return changed
+}
+
+// ValidateAnnotation checks a single rcpu-scheduler/* node annotation value
+// in the schema and range of the annotations of the node.
+func ValidateAnnotation(key, value string, annotations map[string]string) error {
+	schema := annotationSchema(annotations)
+	switch key {
+	case RCPUFeatureGateKey:
+		if value != "true" && value != "false" {
+			return fmt.Errorf("%s must be \"true\" or \"false\", got %q", key, value)
+		}
+	case RCPUMetric1mKey, RCPUMetric5mKey, RCPUMetric15mKey, RCPUAvg1mKey, RCPUAvg5mKey, RCPUAvg15mKey:
+		return validateMetric(key, value, annotations)
+	case RCPUFreeCoresKey, RCPUCapacityKey:
+		if cores, err := parseCores(schema, value); err != nil || cores < 0 {
+			return fmt.Errorf("%s must be a number of cores in schema %s, got %q", key, schema, value)
+		}
+	case RCPUPayloadKey:
+		if _, err := ParsePayload(value); err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+	case RCPURangeKey:
+		if _, err := parseMetricRange(value); err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+	case RCPUProviderKey:
+		if errs := validation.IsDNS1123Label(value); len(errs) > 0 {
+			return fmt.Errorf("%s: %s", key, strings.Join(errs, ", "))
+		}
+	case RCPUSchemaKey:
+		if !supportedSchema(value) {
+			return fmt.Errorf("%s must be one of %s, got %q", key, strings.Join(Schemas, ", "), value)
+		}
+	case RCPUTimestampKey:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("%s must be a unix timestamp, got %q", key, value)
+		}
+	default:
+		// The metrics of the NUMA nodes and sockets are in the node's range too
+		if groupMetricKeyPattern.MatchString(key) {
+			return validateMetric(key, value, annotations)
+		}
+	}
+
+	return nil
+}
+
+// validateMetric checks a metric is in the range of the node.
+func validateMetric(key, value string, annotations map[string]string) error {
+	rcpu, err := parseAnnotatedMetric(annotations, value)
+	if err != nil {
+		return fmt.Errorf("%s: invalid rcpu value %q: %v", key, value, err)
+	}
+	if rcpu < 0 || rcpu > RCPUMaxScore {
+		return fmt.Errorf("%s: rcpu value %q out of the range of the node", key, value)
+	}
+
+	return nil
+}
+
+func (av *AnnotationValidator) validate(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	resp := &admissionv1.AdmissionResponse{UID: req.UID, Allowed: true}
+
+	if req.Kind.Kind != "Node" {
+		return resp
+	}
+
+	var oldNode, newNode v1.Node
+	if len(req.OldObject.Raw) > 0 {
+		if err := json.Unmarshal(req.OldObject.Raw, &oldNode); err != nil {
+			return deny(resp, fmt.Sprintf("failed to decode old node: %v", err))
+		}
+	}
+
+	if len(req.Object.Raw) > 0 {
+		if err := json.Unmarshal(req.Object.Raw, &newNode); err != nil {
+			return deny(resp, fmt.Sprintf("failed to decode node: %v", err))
+		}
+	}
+
+	changed := changedRCPUAnnotations(oldNode.Annotations, newNode.Annotations)
+	if len(changed) == 0 {
+		return resp
+	}
+
+	// The feature gate is left to operators, only its value is checked
+	var restricted []string
+	for _, key := range changed {
+		if key != RCPUFeatureGateKey {
+			restricted = append(restricted, key)
+		}
+	}
+
+	if len(restricted) > 0 && !av.AllowedUsers[req.UserInfo.Username] {
+		return deny(resp, fmt.Sprintf("user %q is not allowed to modify %s", req.UserInfo.Username, strings.Join(restricted, ", ")))
+	}
+
+	for _, key := range changed {
+		value, ok := newNode.Annotations[key]
+		if !ok {
+			continue
+		}
+
+		if err := ValidateAnnotation(key, value, newNode.Annotations); err != nil {
+			return deny(resp, err.Error())
+		}
+	}
+
+	return resp
+}
+
+func deny(resp *admissionv1.AdmissionResponse, message string) *admissionv1.AdmissionResponse {
+	resp.Allowed = false
+	resp.Result = &metav1.Status{
+		Status:  metav1.StatusFailure,
+		Message: message,
+		Reason:  metav1.StatusReasonForbidden,
+		Code:    http.StatusForbidden,
+	}
+
+	return resp
+}
+
+func (av *AnnotationValidator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serveAdmissionReview(w, r, av.validate)
+}
+
+// getPodDemand returns the pod's demand as a share of the node's allocatable
+// CPU, in the same per-mille scale as the rcpu annotations.
+func getPodDemand(pod *v1.Pod, node *v1.Node) int64 {
+	demandStr, ok := pod.Annotations[RCPUDemandKey]
+	if !ok {
+		return 0
+	}
+
+	demand, err := strconv.ParseInt(demandStr, 10, 64)
+	if err != nil || demand <= 0 {
+		return 0
+	}
+
+	allocatable := node.Status.Allocatable.Cpu().MilliValue()
+	if allocatable <= 0 {
+		return 0
+	}
+
+	return demand * RCPUMaxScore / allocatable
+}
+
+// RunWebhook serves the admission webhooks over TLS, registered with
+// kube-apiserver by the webhook configurations of NewWebhookManifests.
+func RunWebhook(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("webhook", flag.ExitOnError)
+	listen := fs.String("listen", DefaultWebhookListen, "address to serve the webhooks on, over TLS")
+	certFile := fs.String("tls-cert-file", "", "PEM certificate to serve, signed by the CA of the webhook configurations' caBundle")
+	keyFile := fs.String("tls-key-file", "", "PEM key of the -tls-cert-file certificate")
+	classFactors := fs.String("workload-class-factors", "", "comma separated factors of the pods' CPU requests by workload class, e.g. batch=0.5,latency=1.5")
+	allowedUsers := fs.String("allowed-users", "", "comma separated users allowed to write the rcpu-scheduler/ annotations of the nodes, e.g. system:serviceaccount:rcpu-system:rcpu-agent")
+	fs.Parse(args)
+
+	// kube-apiserver verifies the webhooks, unlike aggregated API servers
+	if *certFile == "" || *keyFile == "" {
+		return fmt.Errorf("-tls-cert-file and -tls-key-file are required")
+	}
+
+	cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load the serving certificate: %v", err)
+	}
+
+	factors, err := ParseWorkloadClassFactors(*classFactors)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	var users []string
+	if *allowedUsers != "" {
+		users = strings.Split(*allowedUsers, ",")
+	}
+
+	mux.Handle(DemandWebhookPath, NewDemandWebhook(factors))
+	mux.Handle(AnnotationValidatorPath, NewAnnotationValidator(users...))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	server := &http.Server{
+		Addr:      *listen,
+		Handler:   mux,
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12},
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	klog.InfoS("Webhooks are running", "listen", *listen)
+	if err := server.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}